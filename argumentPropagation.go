@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Some fields of a type only make sense when they are given the same arguments, ie a list of orders and the
+// number of orders, filtered by status. When those fields live in different services, a client that only
+// passes the filter to one of them gets a response that doesn't agree with itself. An ArgumentPropagation
+// tells the planner to copy the arguments between the fields before the query is broken up into steps so
+// that every service sees the same filter.
+
+// ArgumentPropagation designates the arguments that are shared by fields of the same type
+type ArgumentPropagation struct {
+	// the type that defines the fields
+	Type string
+	// the fields that share the arguments
+	Fields []string
+	// the arguments that are copied between the fields. If there aren't any, every argument that the fields
+	// define with the same name and type is shared.
+	Arguments []string
+}
+
+// WithArgumentPropagation returns an Option that copies the arguments passed to one of the fields of the rule
+// to the others when they are selected under the same parent without them
+func WithArgumentPropagation(rule ArgumentPropagation) Option {
+	return func(g *Gateway) {
+		g.argumentPropagations = append(g.argumentPropagations, rule)
+	}
+}
+
+// checkArgumentPropagations makes sure that every rule names the fields it shares the arguments between
+func checkArgumentPropagations(rules []ArgumentPropagation) error {
+	for _, rule := range rules {
+		if len(rule.Fields) == 0 {
+			return fmt.Errorf("Invalid argument propagation for %s: it has no fields", rule.Type)
+		}
+	}
+
+	return nil
+}
+
+// sharedArguments returns the arguments that the rule copies between the fields of the type
+func (rule ArgumentPropagation) sharedArguments(definition *ast.Definition) []string {
+	if len(rule.Arguments) > 0 {
+		return rule.Arguments
+	}
+
+	// the arguments are shared if every field defines them the same way
+	shared := []string{}
+	first := definition.Fields.ForName(rule.Fields[0])
+	if first == nil {
+		return shared
+	}
+	for _, argument := range first.Arguments {
+		matches := true
+		for _, name := range rule.Fields[1:] {
+			field := definition.Fields.ForName(name)
+			if field == nil {
+				continue
+			}
+			other := field.Arguments.ForName(argument.Name)
+			if other == nil || other.Type.String() != argument.Type.String() {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			shared = append(shared, argument.Name)
+		}
+	}
+
+	return shared
+}
+
+// plannerPropagateArguments copies the shared arguments between the fields selected in the document
+func plannerPropagateArguments(query *ast.QueryDocument, rules []ArgumentPropagation) {
+	if len(rules) == 0 {
+		return
+	}
+
+	for _, operation := range query.Operations {
+		plannerPropagateSelectionArguments(operation.SelectionSet, query.Fragments, rules)
+	}
+	for _, fragment := range query.Fragments {
+		plannerPropagateSelectionArguments(fragment.SelectionSet, query.Fragments, rules)
+	}
+}
+
+// plannerPropagateSelectionArguments copies the shared arguments between the fields of the selection set
+// and the ones nested inside of it
+func plannerPropagateSelectionArguments(selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList, rules []ArgumentPropagation) {
+	// the fields that are selected under the same parent, indexed by name. The ones in the fragments end up next
+	// to the others in the response so they have to get the same arguments. The arguments can come from any of
+	// them but the fields of a named fragment are only changed along with the rest of the fragment, since it can
+	// be spread under parents that don't select the same fields.
+	sources := map[string][]*ast.Field{}
+	for _, field := range executorSelectedFields(selectionSet, fragments) {
+		sources[field.Name] = append(sources[field.Name], field)
+	}
+	fields := map[string][]*ast.Field{}
+	for _, field := range plannerInlineFields(selectionSet) {
+		fields[field.Name] = append(fields[field.Name], field)
+	}
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			plannerPropagateSelectionArguments(selection.SelectionSet, fragments, rules)
+		case *ast.InlineFragment:
+			plannerPropagateSelectionArguments(selection.SelectionSet, fragments, rules)
+		}
+	}
+
+	for _, rule := range rules {
+		// the rule only applies to the fields of its type
+		var definition *ast.Definition
+		for _, name := range rule.Fields {
+			for _, field := range sources[name] {
+				if field.ObjectDefinition != nil && field.ObjectDefinition.Name == rule.Type {
+					definition = field.ObjectDefinition
+				}
+			}
+		}
+		if definition == nil {
+			continue
+		}
+
+		for _, argumentName := range rule.sharedArguments(definition) {
+			// find the value one of the fields was given
+			var value *ast.Value
+			for _, name := range rule.Fields {
+				for _, field := range sources[name] {
+					if field.ObjectDefinition != definition {
+						continue
+					}
+					if argument := field.Arguments.ForName(argumentName); argument != nil && value == nil {
+						value = argument.Value
+					}
+				}
+			}
+			if value == nil {
+				continue
+			}
+
+			// and give it to the fields that were left without it
+			for _, name := range rule.Fields {
+				fieldDefinition := definition.Fields.ForName(name)
+				if fieldDefinition == nil || fieldDefinition.Arguments.ForName(argumentName) == nil {
+					continue
+				}
+
+				for _, field := range fields[name] {
+					if field.ObjectDefinition == definition && field.Arguments.ForName(argumentName) == nil {
+						field.Arguments = append(field.Arguments, &ast.Argument{
+							Name:     argumentName,
+							Value:    value,
+							Position: value.Position,
+						})
+					}
+				}
+			}
+		}
+	}
+}
+
+// plannerInlineFields returns the fields of the selection set, including the ones in its inline fragments
+func plannerInlineFields(selectionSet ast.SelectionSet) []*ast.Field {
+	fields := []*ast.Field{}
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			fields = append(fields, selection)
+		case *ast.InlineFragment:
+			fields = append(fields, plannerInlineFields(selection.SelectionSet)...)
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestGateway_argumentPropagation(t *testing.T) {
+	ordersSchema := `
+		enum OrderStatus {
+			PENDING
+			SHIPPED
+		}
+
+		interface Node {
+			id: ID!
+		}
+
+		type Order {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			orders(status: OrderStatus): [Order!]!
+		}
+
+		type Query {
+			me: User
+			node(id: ID!): Node
+		}
+	`
+	analyticsSchema := `
+		enum OrderStatus {
+			PENDING
+			SHIPPED
+		}
+
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			orderCount(status: OrderStatus): Int!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`
+
+	// the arguments that each service was sent, indexed by url
+	lock := &sync.Mutex{}
+	received := map[string]ast.ArgumentList{}
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			// find the arguments of the field we care about
+			lock.Lock()
+			selectionSet := input.QueryDocument.Operations[0].SelectionSet
+			if url == "orders" {
+				received[url] = selectionSet[0].(*ast.Field).SelectionSet[0].(*ast.Field).Arguments
+			} else {
+				received[url] = selectionSet[0].(*ast.Field).SelectionSet[0].(*ast.InlineFragment).SelectionSet[0].(*ast.Field).Arguments
+			}
+			lock.Unlock()
+
+			if url == "orders" {
+				return map[string]interface{}{
+					"me": map[string]interface{}{gatewayIDAlias: "1", "orders": []interface{}{}},
+				}, nil
+			}
+			return map[string]interface{}{
+				gatewayNodeAlias: map[string]interface{}{"orderCount": 0},
+			}, nil
+		})
+	})
+
+	table := []struct {
+		Name  string
+		Rules []ArgumentPropagation
+		Query string
+		// the value of status sent to each service
+		Expected map[string]string
+	}{
+		{
+			Name:     "Arguments on both fields",
+			Query:    `{ me { orders(status: SHIPPED) { id } orderCount(status: SHIPPED) } }`,
+			Expected: map[string]string{"orders": "SHIPPED", "analytics": "SHIPPED"},
+		},
+		{
+			Name:     "No rules",
+			Query:    `{ me { orders(status: SHIPPED) { id } orderCount } }`,
+			Expected: map[string]string{"orders": "SHIPPED", "analytics": ""},
+		},
+		{
+			Name: "Designated arguments",
+			Rules: []ArgumentPropagation{
+				{Type: "User", Fields: []string{"orders", "orderCount"}, Arguments: []string{"status"}},
+			},
+			Query:    `{ me { orders(status: SHIPPED) { id } orderCount } }`,
+			Expected: map[string]string{"orders": "SHIPPED", "analytics": "SHIPPED"},
+		},
+		{
+			Name: "Inferred arguments",
+			Rules: []ArgumentPropagation{
+				{Type: "User", Fields: []string{"orders", "orderCount"}},
+			},
+			Query:    `{ me { orders { id } orderCount(status: PENDING) } }`,
+			Expected: map[string]string{"orders": "PENDING", "analytics": "PENDING"},
+		},
+		{
+			Name: "Variables",
+			Rules: []ArgumentPropagation{
+				{Type: "User", Fields: []string{"orders", "orderCount"}},
+			},
+			Query:    `query($status: OrderStatus) { me { orders(status: $status) { id } orderCount } }`,
+			Expected: map[string]string{"orders": "$status", "analytics": "$status"},
+		},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			received = map[string]ast.ArgumentList{}
+
+			options := []Option{WithQueryerFactory(&factory)}
+			for _, rule := range row.Rules {
+				options = append(options, WithArgumentPropagation(rule))
+			}
+
+			// the schemas are merged into the gateway's so every gateway needs its own
+			orders, _ := graphql.LoadSchema(ordersSchema)
+			analytics, _ := graphql.LoadSchema(analyticsSchema)
+
+			gateway, err := New([]*graphql.RemoteSchema{
+				{Schema: orders, URL: "orders"},
+				{Schema: analytics, URL: "analytics"},
+			}, options...)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			ctx := &RequestContext{
+				Context:   context.Background(),
+				Query:     row.Query,
+				Variables: map[string]interface{}{"status": "SHIPPED"},
+			}
+			plans, err := gateway.GetPlans(ctx)
+			if !assert.Nil(t, err) {
+				return
+			}
+			if _, err := gateway.Execute(ctx, plans); !assert.Nil(t, err) {
+				return
+			}
+
+			for url, expected := range row.Expected {
+				status := received[url].ForName("status")
+				if expected == "" {
+					assert.Nil(t, status, url)
+					continue
+				}
+				if assert.NotNil(t, status, url) {
+					assert.Equal(t, expected, status.Value.String(), url)
+				}
+			}
+		})
+	}
+}
+
+func TestGateway_argumentPropagationWithoutFields(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			hello: String
+		}
+	`)
+
+	// a rule without fields can't do anything so it's a mistake
+	_, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithArgumentPropagation(ArgumentPropagation{Type: "User"}))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "User")
+	}
+}
@@ -0,0 +1,239 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Some operations take longer to execute than the infrastructure in front of the gateway is willing
+// to keep a connection open. Clients can ask for an operation to be executed asynchronously by
+// sending the X-Gateway-Async header (or {"extensions": {"async": true}}) along with their request.
+// Instead of the result, the gateway will respond immediately with the id of the operation which
+// can be used to poll for the result with a GET to /graphql?operation=<id>.
+
+// AsyncHeader is the header clients send to ask for asynchronous execution of an operation
+const AsyncHeader = "X-Gateway-Async"
+
+// AsyncOperationStatus is the status of an operation that is being executed asynchronously
+type AsyncOperationStatus string
+
+const (
+	// AsyncOperationPending is the status of an operation that is still executing
+	AsyncOperationPending AsyncOperationStatus = "pending"
+	// AsyncOperationComplete is the status of an operation whose result is ready
+	AsyncOperationComplete AsyncOperationStatus = "complete"
+)
+
+// AsyncOperation holds the state of an operation that is being executed asynchronously
+type AsyncOperation struct {
+	ID        string
+	Status    AsyncOperationStatus
+	Result    map[string]interface{}
+	ExpiresAt time.Time
+}
+
+// AsyncOperationStore holds the state of asynchronous operations until they expire. Implementations
+// must be safe for concurrent use.
+type AsyncOperationStore interface {
+	Put(ctx context.Context, operation *AsyncOperation) error
+	Get(ctx context.Context, id string) (*AsyncOperation, error)
+}
+
+// ErrAsyncOperationNotFound is returned when the designated operation does not exist or has expired
+var ErrAsyncOperationNotFound = errors.New("could not find operation")
+
+// WithAsyncExecution returns an Option that allows clients to execute operations asynchronously. The
+// state of each operation is kept in the store for the designated duration.
+func WithAsyncExecution(store AsyncOperationStore, ttl time.Duration) Option {
+	return func(g *Gateway) {
+		g.asyncStore = store
+		g.asyncTTL = ttl
+	}
+}
+
+// EnqueueOperation starts executing the plans in the background and returns the id that can be
+// used to retrieve the result with FetchOperation.
+func (g *Gateway) EnqueueOperation(ctx *RequestContext, plans QueryPlanList) (string, error) {
+	if g.asyncStore == nil {
+		return "", errors.New("asynchronous execution is not enabled")
+	}
+
+	// the operation is in flight until its result is saved
+	done, err := g.trackOperation()
+	if err != nil {
+		return "", err
+	}
+
+	// generate an id for the operation
+	id, err := asyncOperationID()
+	if err != nil {
+		done()
+		return "", err
+	}
+
+	// save the pending operation so clients can start polling
+	err = g.asyncStore.Put(ctx.Context, &AsyncOperation{
+		ID:        id,
+		Status:    AsyncOperationPending,
+		ExpiresAt: time.Now().Add(g.asyncTTL),
+	})
+	if err != nil {
+		done()
+		return "", err
+	}
+
+	// the execution has to outlive the request that started it
+	detached := *ctx
+	detached.Context = detachedContext{parent: ctx.Context}
+	executionContext := &detached
+
+	go func() {
+		defer done()
+
+		// execute the plan
+		result, err := g.execute(executionContext, plans)
+
+		payload := map[string]interface{}{"data": g.orderedData(executionContext, plans, result)}
+		if err != nil {
+			payload = g.errorResponse(executionContext.Context, g.orderedData(executionContext, plans, result), err, "INTERNAL_SERVER_ERROR")
+		}
+
+		// save the result for when the client comes back
+		err = g.asyncStore.Put(executionContext.Context, &AsyncOperation{
+			ID:        id,
+			Status:    AsyncOperationComplete,
+			Result:    payload,
+			ExpiresAt: time.Now().Add(g.asyncTTL),
+		})
+		if err != nil {
+			log.Warn("Could not save the result of operation ", id, ": ", err)
+		}
+	}()
+
+	return id, nil
+}
+
+// FetchOperation returns the current state of the operation with the designated id
+func (g *Gateway) FetchOperation(ctx context.Context, id string) (*AsyncOperation, error) {
+	if g.asyncStore == nil {
+		return nil, errors.New("asynchronous execution is not enabled")
+	}
+
+	return g.asyncStore.Get(ctx, id)
+}
+
+// asyncRequested returns true if the client asked for the operation to be executed asynchronously
+func asyncRequested(r *http.Request, operation *HTTPOperation) bool {
+	header := r.Header.Get(AsyncHeader)
+	return operation.Extensions.Async || header == "1" || header == "true"
+}
+
+// handleAsyncPoll writes the state of the operation designated by the request to the response
+func (g *Gateway) handleAsyncPoll(w http.ResponseWriter, r *http.Request, id string) {
+	operation, err := g.FetchOperation(r.Context(), id)
+	if err != nil {
+		response, _ := json.Marshal(g.errorResponse(r.Context(), nil, err, "NOT_FOUND"))
+		emitResponse(w, http.StatusNotFound, string(response))
+		return
+	}
+
+	// if the operation isn't done, let the client know
+	var payload interface{} = map[string]interface{}{"status": operation.Status}
+	if operation.Status == AsyncOperationComplete {
+		payload = operation.Result
+	}
+
+	response, err := json.Marshal(payload)
+	if err != nil {
+		response, _ = json.Marshal(formatErrors(nil, err))
+		emitResponse(w, http.StatusInternalServerError, string(response))
+		return
+	}
+
+	emitResponse(w, http.StatusOK, string(response))
+}
+
+func asyncOperationID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// detachedContext carries the values of its parent without being canceled when the parent is
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// InMemoryAsyncOperationStore is an AsyncOperationStore that holds up to a fixed number
+// of operations in memory
+type InMemoryAsyncOperationStore struct {
+	operations map[string]*AsyncOperation
+	maxSize    int
+	mutex      sync.Mutex
+}
+
+// NewInMemoryAsyncOperationStore returns a store that holds up to maxSize operations at a time
+func NewInMemoryAsyncOperationStore(maxSize int) *InMemoryAsyncOperationStore {
+	return &InMemoryAsyncOperationStore{
+		operations: map[string]*AsyncOperation{},
+		maxSize:    maxSize,
+	}
+}
+
+// Put saves the operation, replacing any previous state for the same id
+func (s *InMemoryAsyncOperationStore) Put(ctx context.Context, operation *AsyncOperation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// if this is a new operation we have to make room for it
+	if _, exists := s.operations[operation.ID]; !exists && len(s.operations) >= s.maxSize {
+		// clean up any operations that have been abandoned
+		now := time.Now()
+		for id, existing := range s.operations {
+			if now.After(existing.ExpiresAt) {
+				delete(s.operations, id)
+			}
+		}
+
+		// if we still don't have room then we can't accept the operation
+		if len(s.operations) >= s.maxSize {
+			return errors.New("too many operations in progress")
+		}
+	}
+
+	s.operations[operation.ID] = operation
+	return nil
+}
+
+// Get returns the operation with the designated id if it has not expired
+func (s *InMemoryAsyncOperationStore) Get(ctx context.Context, id string) (*AsyncOperation, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	operation, ok := s.operations[id]
+	if !ok {
+		return nil, ErrAsyncOperationNotFound
+	}
+
+	// if the operation has expired, clean it up
+	if time.Now().After(operation.ExpiresAt) {
+		delete(s.operations, id)
+		return nil, ErrAsyncOperationNotFound
+	}
+
+	return operation, nil
+}
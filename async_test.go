@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLHandler_asyncExecution(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			report: String!
+		}
+	`)
+
+	// an executor that waits until we tell it to finish
+	release := make(chan bool)
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithAsyncExecution(NewInMemoryAsyncOperationStore(10), time.Minute),
+		WithExecutor(ExecutorFunc(func(ctx *ExecutionContext) (map[string]interface{}, error) {
+			<-release
+			return map[string]interface{}{"report": "done"}, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// start the operation
+	request := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ report }"}`))
+	request.Header.Set(AsyncHeader, "1")
+	responseRecorder := httptest.NewRecorder()
+	gateway.GraphQLHandler(responseRecorder, request)
+
+	assert.Equal(t, http.StatusAccepted, responseRecorder.Code)
+	started := map[string]interface{}{}
+	if !assert.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &started)) {
+		return
+	}
+	id, ok := started["operation"].(string)
+	if !assert.True(t, ok) {
+		return
+	}
+
+	poll := func() string {
+		request := httptest.NewRequest("GET", "/graphql?operation="+id, nil)
+		responseRecorder := httptest.NewRecorder()
+		gateway.GraphQLHandler(responseRecorder, request)
+		return responseRecorder.Body.String()
+	}
+
+	// the operation is still running
+	assert.Equal(t, `{"status":"pending"}`, poll())
+
+	// let the operation finish
+	release <- true
+
+	// wait for the result to show up
+	var result string
+	for i := 0; i < 100; i++ {
+		result = poll()
+		if result != `{"status":"pending"}` {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, `{"data":{"report":"done"}}`, result)
+
+	t.Run("Unknown operation", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/graphql?operation=unknown", nil)
+		responseRecorder := httptest.NewRecorder()
+		gateway.GraphQLHandler(responseRecorder, request)
+
+		assert.Equal(t, http.StatusNotFound, responseRecorder.Code)
+	})
+}
+
+func TestInMemoryAsyncOperationStore(t *testing.T) {
+	store := NewInMemoryAsyncOperationStore(1)
+	ctx := context.Background()
+
+	// an operation that has been abandoned
+	assert.Nil(t, store.Put(ctx, &AsyncOperation{ID: "1", ExpiresAt: time.Now().Add(-time.Second)}))
+
+	// expired operations can't be retrieved
+	_, err := store.Get(ctx, "1")
+	assert.Equal(t, ErrAsyncOperationNotFound, err)
+
+	// abandoned operations make room for new ones
+	assert.Nil(t, store.Put(ctx, &AsyncOperation{ID: "2", ExpiresAt: time.Now().Add(time.Minute)}))
+
+	// but the store is bounded
+	assert.NotNil(t, store.Put(ctx, &AsyncOperation{ID: "3", ExpiresAt: time.Now().Add(time.Minute)}))
+
+	// updating an existing operation is always allowed
+	assert.Nil(t, store.Put(ctx, &AsyncOperation{ID: "2", Status: AsyncOperationComplete, ExpiresAt: time.Now().Add(time.Minute)}))
+	operation, err := store.Get(ctx, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, AsyncOperationComplete, operation.Status)
+}
@@ -0,0 +1,340 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nautilus/graphql"
+)
+
+// When a client reports a wrong value in a response, the only way to find out which service it came from is to
+// see what each service was asked and what it said. An audit sink gets a record of everything that went into a
+// request: the client's operation, the query sent for each step along with the raw response the service gave back,
+// and a hash of the final result. Capturing all of that for every request would be far too much data so it only
+// happens for the requests that ask for it with the audit header (or whose context was built with WithAuditCapture).
+// The capture doesn't change how the request is executed, the bodies it keeps are cut off at a limit and the
+// variables are passed through a redaction function before they are stored.
+
+// AuditHeader is the header that turns on the audit capture for a request when it is set to 1
+const AuditHeader = "X-Gateway-Audit"
+
+// the number of bytes of each query and response that are kept unless another limit is provided
+const defaultAuditBodyLimit = 64 * 1024
+
+// AuditRecord is everything that went into the response to a single operation
+type AuditRecord struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	// the requests sent to the services, in the order they were sent
+	Steps []*AuditStep `json:"steps"`
+	// the sha256 of the JSON encoding of the result that was sent to the client
+	ResultHash string        `json:"resultHash"`
+	Errors     []string      `json:"errors,omitempty"`
+	Start      time.Time     `json:"start"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// AuditStep is a single request sent to a service
+type AuditStep struct {
+	URL       string                 `json:"url"`
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	// the body of the response as the service sent it. Queryers that don't talk to the service over HTTP
+	// leave the response they decoded instead.
+	Response string `json:"response"`
+	// true if the query or the response were longer than the limit and were cut off
+	Truncated bool          `json:"truncated,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Start     time.Time     `json:"start"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// AuditSink receives the record of every request that asked to be audited once the request is over
+type AuditSink interface {
+	WriteAuditRecord(record *AuditRecord) error
+}
+
+// AuditRedactor returns the value to store in place of the variable with the designated name. It is called
+// for every key in the variables, including the ones nested in input objects.
+type AuditRedactor func(key string, value interface{}) interface{}
+
+// RedactKeys returns an AuditRedactor that hides the values of the keys with one of the designated names,
+// regardless of their case
+func RedactKeys(keys ...string) AuditRedactor {
+	redacted := Set{}
+	for _, key := range keys {
+		redacted.Add(strings.ToLower(key))
+	}
+
+	return func(key string, value interface{}) interface{} {
+		if redacted.Has(strings.ToLower(key)) {
+			return "[REDACTED]"
+		}
+		return value
+	}
+}
+
+// WithAuditSink returns an Option that sends a record of the requests that set the audit header to the sink
+func WithAuditSink(sink AuditSink) Option {
+	return func(g *Gateway) {
+		g.auditSink = sink
+	}
+}
+
+// WithAuditRedaction returns an Option that passes the variables of the audited requests through the
+// redactor before they are stored
+func WithAuditRedaction(redact AuditRedactor) Option {
+	return func(g *Gateway) {
+		g.auditRedactor = redact
+	}
+}
+
+// WithAuditBodyLimit returns an Option that changes the number of bytes of each query and response that
+// are kept in an audit record
+func WithAuditBodyLimit(bytes int) Option {
+	return func(g *Gateway) {
+		g.auditBodyLimit = bytes
+	}
+}
+
+// auditCaptureKey is the context key that marks a request for the audit sink
+type auditCaptureKey struct{}
+
+// WithAuditCapture returns a copy of the context that turns on the audit capture for the request it executes
+func WithAuditCapture(ctx context.Context) context.Context {
+	return context.WithValue(ctx, auditCaptureKey{}, true)
+}
+
+// auditContext returns the context to execute the request with, marked for the audit sink if the request asked for it
+func (g *Gateway) auditContext(ctx context.Context, r *http.Request) context.Context {
+	if g.auditSink == nil || r.Header.Get(AuditHeader) != "1" {
+		return ctx
+	}
+	return WithAuditCapture(ctx)
+}
+
+// startAudit returns the recorder for the request, or nil if the request isn't being audited
+func (g *Gateway) startAudit(ctx *RequestContext, operationName string) *auditRecorder {
+	if g.auditSink == nil || ctx.Context == nil {
+		return nil
+	}
+	if requested, _ := ctx.Context.Value(auditCaptureKey{}).(bool); !requested {
+		return nil
+	}
+
+	limit := g.auditBodyLimit
+	if limit <= 0 {
+		limit = defaultAuditBodyLimit
+	}
+
+	recorder := &auditRecorder{redact: g.auditRedactor, limit: limit}
+	query, _ := recorder.truncate(ctx.Query)
+	recorder.record = &AuditRecord{
+		Query:         query,
+		OperationName: operationName,
+		Variables:     recorder.redactVariables(ctx.Variables),
+		Steps:         []*AuditStep{},
+		Start:         time.Now(),
+	}
+
+	return recorder
+}
+
+// finishAudit sends the record of the request to the sink
+func (g *Gateway) finishAudit(recorder *auditRecorder, result map[string]interface{}, err error) {
+	if recorder == nil {
+		return
+	}
+
+	record := recorder.finish(result, err)
+	if err := g.auditSink.WriteAuditRecord(record); err != nil {
+		log.Warn("Could not write audit record: ", err)
+	}
+}
+
+// auditRecorder collects the record of a request while it is executed
+type auditRecorder struct {
+	mutex  sync.Mutex
+	record *AuditRecord
+	redact AuditRedactor
+	limit  int
+}
+
+// step starts the record of a request sent to a service
+func (a *auditRecorder) step(url string, input *graphql.QueryInput) *auditStepRecorder {
+	query, truncated := a.truncate(input.Query)
+	step := &AuditStep{
+		URL:       url,
+		Query:     query,
+		Variables: a.redactVariables(input.Variables),
+		Truncated: truncated,
+		Start:     time.Now(),
+	}
+
+	a.mutex.Lock()
+	a.record.Steps = append(a.record.Steps, step)
+	a.mutex.Unlock()
+
+	return &auditStepRecorder{recorder: a, step: step}
+}
+
+// finish fills in what is left of the record once the request is over
+func (a *auditRecorder) finish(result map[string]interface{}, err error) *AuditRecord {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.record.Duration = time.Since(a.record.Start)
+	if encoded, encodeErr := json.Marshal(result); encodeErr == nil {
+		hash := sha256.Sum256(encoded)
+		a.record.ResultHash = hex.EncodeToString(hash[:])
+	}
+
+	if errs, ok := err.(graphql.ErrorList); ok {
+		for _, err := range errs {
+			a.record.Errors = append(a.record.Errors, err.Error())
+		}
+	} else if err != nil {
+		a.record.Errors = append(a.record.Errors, err.Error())
+	}
+
+	return a.record
+}
+
+// truncate returns the body cut off at the limit and whether it had to be
+func (a *auditRecorder) truncate(body string) (string, bool) {
+	if len(body) <= a.limit {
+		return body, false
+	}
+	return body[:a.limit], true
+}
+
+// redactVariables returns a copy of the variables that went through the redactor
+func (a *auditRecorder) redactVariables(variables map[string]interface{}) map[string]interface{} {
+	if variables == nil {
+		return nil
+	}
+	if a.redact == nil {
+		return variables
+	}
+
+	return a.redactObject(variables)
+}
+
+func (a *auditRecorder) redactObject(object map[string]interface{}) map[string]interface{} {
+	redacted := map[string]interface{}{}
+	for key, value := range object {
+		redacted[key] = a.redactValue(a.redact(key, value))
+	}
+	return redacted
+}
+
+func (a *auditRecorder) redactValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return a.redactObject(value)
+	case []interface{}:
+		redacted := make([]interface{}, len(value))
+		for i, entry := range value {
+			redacted[i] = a.redactValue(entry)
+		}
+		return redacted
+	default:
+		return value
+	}
+}
+
+// auditStepKey is the context key for the step whose response the transport should record
+type auditStepKey struct{}
+
+// auditStepRecorder fills in the record of a single request
+type auditStepRecorder struct {
+	recorder *auditRecorder
+	step     *AuditStep
+	// true once the transport has recorded the body of the response
+	sawBody bool
+}
+
+// context returns a copy of the context that lets the transport record the raw response
+func (s *auditStepRecorder) context(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, auditStepKey{}, s)
+}
+
+// setBody records the body of the response as it was sent by the service
+func (s *auditStepRecorder) setBody(body []byte) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+
+	response, truncated := s.recorder.truncate(string(body))
+	s.step.Response = response
+	s.step.Truncated = s.step.Truncated || truncated
+	s.sawBody = true
+}
+
+// finish records the response that the queryer returned
+func (s *auditStepRecorder) finish(response map[string]interface{}, err error) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+
+	s.step.Duration = time.Since(s.step.Start)
+	if err != nil {
+		s.step.Error = err.Error()
+	}
+
+	// the transport didn't see the response so the best we can do is what the queryer decoded
+	if !s.sawBody {
+		encoded, _ := json.Marshal(map[string]interface{}{"data": response})
+		response, truncated := s.recorder.truncate(string(encoded))
+		s.step.Response = response
+		s.step.Truncated = s.step.Truncated || truncated
+	}
+}
+
+// FileAuditSink is an AuditSink that appends every record to a file, one JSON document per line
+type FileAuditSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileAuditSink returns an AuditSink that writes to the file at the designated path. The file is created
+// if it doesn't exist already.
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditSink{file: file}, nil
+}
+
+// WriteAuditRecord adds the record to the end of the file
+func (s *FileAuditSink) WriteAuditRecord(record *AuditRecord) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err = s.file.Write(append(encoded, '\n'))
+	return err
+}
+
+// Close closes the file
+func (s *FileAuditSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,173 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+// auditRecords is an AuditSink that holds onto the records it is given
+type auditRecords struct {
+	mutex   sync.Mutex
+	records []*AuditRecord
+}
+
+func (s *auditRecords) WriteAuditRecord(record *AuditRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records = append(s.records, record)
+	return nil
+}
+
+func TestGateway_auditSink(t *testing.T) {
+	// the service sends back a response with some extra whitespace so we can tell it's the raw body
+	body := `{"data": {"user": {"name": "alice", "bio": "` + strings.Repeat("a", 100) + `"}}}`
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer service.Close()
+
+	schema, _ := graphql.LoadSchema(`
+		input Login {
+			username: String!
+			password: String!
+		}
+
+		type User {
+			name: String!
+			bio: String!
+		}
+
+		type Query {
+			user(login: Login!): User
+		}
+	`)
+
+	sink := &auditRecords{}
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}},
+		WithAuditSink(sink),
+		WithAuditRedaction(RedactKeys("password")),
+		WithAuditBodyLimit(60),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// query sends the query through the http handler and returns the body of the response
+	query := func(audit bool) string {
+		body := `{
+			"query": "query Login($login: Login!) { user(login: $login) { name bio } }",
+			"variables": {"login": {"username": "alice", "password": "hunter2"}}
+		}`
+		request := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+		if audit {
+			request.Header.Set(AuditHeader, "1")
+		}
+		response := httptest.NewRecorder()
+		gateway.GraphQLHandler(response, request)
+
+		return response.Body.String()
+	}
+
+	// requests that don't ask for it aren't recorded
+	unaudited := query(false)
+	assert.Len(t, sink.records, 0)
+
+	// and the audit doesn't change the response
+	assert.Equal(t, unaudited, query(true))
+	if !assert.Len(t, sink.records, 1) {
+		return
+	}
+	record := sink.records[0]
+
+	assert.Equal(t, "Login", record.OperationName)
+	assert.Equal(t, map[string]interface{}{
+		"login": map[string]interface{}{"username": "alice", "password": "[REDACTED]"},
+	}, record.Variables)
+
+	if !assert.Len(t, record.Steps, 1) {
+		return
+	}
+	step := record.Steps[0]
+	assert.Equal(t, service.URL, step.URL)
+	assert.Equal(t, "[REDACTED]", step.Variables["login"].(map[string]interface{})["password"])
+	// the response is the body the service sent, cut off at the limit
+	assert.Equal(t, body[:60], step.Response)
+	assert.True(t, step.Truncated)
+
+	// the hash is of the result the client got
+	result := map[string]interface{}{}
+	if !assert.Nil(t, json.Unmarshal([]byte(unaudited), &result)) {
+		return
+	}
+	expected := (&auditRecorder{record: &AuditRecord{}}).finish(result["data"].(map[string]interface{}), nil)
+	assert.Equal(t, expected.ResultHash, record.ResultHash)
+	assert.NotEmpty(t, record.ResultHash)
+}
+
+func TestGateway_auditCaptureContext(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+
+	sink := &auditRecords{}
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithAuditSink(sink),
+		WithServiceQueryer("url1", graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{"value": "hello"}, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	err = gateway.ExecuteInto(WithAuditCapture(context.Background()), &Request{Query: "{ value }"}, &map[string]interface{}{})
+	if !assert.Nil(t, err) || !assert.Len(t, sink.records, 1) || !assert.Len(t, sink.records[0].Steps, 1) {
+		return
+	}
+
+	// the queryer doesn't go through the transport so the audit gets the response it decoded
+	assert.Equal(t, `{"data":{"value":"hello"}}`, sink.records[0].Steps[0].Response)
+}
+
+func TestFileAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if !assert.Nil(t, err) {
+		return
+	}
+	path := filepath.Join(dir, "audit.log")
+
+	sink, err := NewFileAuditSink(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, sink.WriteAuditRecord(&AuditRecord{Query: "{ a }"}))
+	assert.Nil(t, sink.WriteAuditRecord(&AuditRecord{Query: "{ b }"}))
+	assert.Nil(t, sink.Close())
+
+	contents, err := ioutil.ReadFile(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// one record per line
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	record := &AuditRecord{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), record))
+	assert.Equal(t, "{ b }", record.Query)
+}
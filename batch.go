@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// defaultBatchConcurrency is the number of operations in a batch that are executed at the same time
+// unless the gateway is told otherwise
+const defaultBatchConcurrency = 10
+
+// WithBatchConcurrency returns an Option that limits the number of operations of a batched request
+// that the gateway executes at the same time
+func WithBatchConcurrency(operations int) Option {
+	return func(g *Gateway) {
+		g.batchConcurrency = operations
+	}
+}
+
+// BatchInfo describes where an operation falls in the batched request that it was sent in
+type BatchInfo struct {
+	Index int
+	Size  int
+}
+
+type batchInfoKey struct{}
+
+// BatchInfoFromContext returns the position of the operation in its batch. If the operation was not part
+// of a batched request, the second return value is false.
+func BatchInfoFromContext(ctx context.Context) (BatchInfo, bool) {
+	info, ok := ctx.Value(batchInfoKey{}).(BatchInfo)
+	return info, ok
+}
+
+// handleBatch performs the operations of a batched request concurrently and returns their responses in the
+// order the operations were sent
+func (g *Gateway) handleBatch(r *http.Request, operations []*HTTPOperation) []*operationResponse {
+	responses := make([]*operationResponse, len(operations))
+
+	concurrency := g.batchConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	// a slot has to be free before an operation can start
+	slots := make(chan bool, concurrency)
+	wg := &sync.WaitGroup{}
+
+	for i, operation := range operations {
+		ctx := context.WithValue(r.Context(), batchInfoKey{}, BatchInfo{Index: i, Size: len(operations)})
+
+		wg.Add(1)
+		slots <- true
+		go func(i int, ctx context.Context, operation *HTTPOperation) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			responses[i] = g.handleOperation(ctx, r, operation, false)
+		}(i, ctx, operation)
+	}
+
+	wg.Wait()
+
+	return responses
+}
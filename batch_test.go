@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLHandler_batchConcurrency(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			index: Int!
+			size: Int!
+		}
+	`)
+
+	// keep track of the number of operations being executed at once
+	lock := &sync.Mutex{}
+	arrived := 0
+	inFlight := 0
+	maxInFlight := 0
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithBatchConcurrency(2),
+		WithExecutor(ExecutorFunc(func(ctx *ExecutionContext) (map[string]interface{}, error) {
+			lock.Lock()
+			arrived++
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+
+			// wait for the other operation in our pair to show up so we know they run at the same time
+			pair := (arrived + 1) / 2
+			deadline := time.Now().Add(time.Second)
+			for arrived < pair*2 && time.Now().Before(deadline) {
+				lock.Unlock()
+				time.Sleep(time.Millisecond)
+				lock.Lock()
+			}
+			inFlight--
+			lock.Unlock()
+
+			// the position of the operation in the batch is available to everything executing it
+			info, ok := BatchInfoFromContext(ctx.RequestContext)
+			if !ok {
+				return nil, graphql.ErrorList{graphql.NewError("NO_BATCH", "could not find batch info")}
+			}
+
+			return map[string]interface{}{"index": info.Index, "size": info.Size}, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	body, _ := json.Marshal([]map[string]interface{}{
+		{"query": "{ index size }"},
+		{"query": "{ index size }"},
+		{"query": "{ index size }"},
+		{"query": "{ index size }"},
+	})
+
+	responseRecorder := httptest.NewRecorder()
+	gateway.GraphQLHandler(responseRecorder, httptest.NewRequest("POST", "/graphql", strings.NewReader(string(body))))
+	if !assert.Equal(t, http.StatusOK, responseRecorder.Code) {
+		return
+	}
+
+	result := []struct {
+		Data struct {
+			Index int
+			Size  int
+		}
+	}{}
+	if !assert.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &result)) || !assert.Len(t, result, 4) {
+		return
+	}
+
+	// the responses have to be in the same order as the operations
+	for i, entry := range result {
+		assert.Equal(t, i, entry.Data.Index)
+		assert.Equal(t, 4, entry.Data.Size)
+	}
+
+	// we never went over the limit
+	assert.Equal(t, 2, maxInFlight)
+}
+
+func TestGraphQLHandler_batchWithErrors(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithExecutor(ExecutorFunc(func(ctx *ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"value": "hello"}, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// one operation is fine, one isn't valid, and one doesn't have a query at all
+	responseRecorder := httptest.NewRecorder()
+	gateway.GraphQLHandler(responseRecorder, httptest.NewRequest("POST", "/graphql", strings.NewReader(`[
+		{"query": "{ value }"},
+		{"query": "{ notAField }"},
+		{"variables": {}}
+	]`)))
+	if !assert.Equal(t, http.StatusOK, responseRecorder.Code) {
+		return
+	}
+
+	result := []struct {
+		Data   map[string]interface{}
+		Errors []struct {
+			Extensions map[string]interface{}
+		}
+	}{}
+	if !assert.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &result)) || !assert.Len(t, result, 3) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{"value": "hello"}, result[0].Data)
+	assert.Empty(t, result[0].Errors)
+
+	if assert.Len(t, result[1].Errors, 1) {
+		assert.Equal(t, "GRAPHQL_VALIDATION_FAILED", result[1].Errors[0].Extensions["code"])
+	}
+	if assert.Len(t, result[2].Errors, 1) {
+		assert.Equal(t, "BAD_USER_INPUT", result[2].Errors[0].Extensions["code"])
+	}
+}
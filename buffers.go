@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Every response from a service is read into memory before the gateway looks at it. Instead of growing a new
+// slice for each one, the bodies are read into buffers that are handed back once nothing points into them. The
+// buffers that grew past a limit are dropped instead of being kept around for a response that big to come
+// along again.
+
+// the largest buffer that is put back in the pool
+const maxPooledBufferSize = 1 << 20
+
+// bufferPool holds buffers that can be reused once they are reset
+type bufferPool struct {
+	pool sync.Pool
+}
+
+// get returns an empty buffer
+func (p *bufferPool) get() *bytes.Buffer {
+	if buffer, ok := p.pool.Get().(*bytes.Buffer); ok {
+		buffer.Reset()
+		return buffer
+	}
+
+	return &bytes.Buffer{}
+}
+
+// put hands the buffer back. Nothing can use it (or a slice of its bytes) afterwards.
+func (p *bufferPool) put(buffer *bytes.Buffer) {
+	if buffer.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	buffer.Reset()
+	p.pool.Put(buffer)
+}
+
+// responseBuffers holds the buffers that the bodies of the services' responses are read into
+var responseBuffers = &bufferPool{}
+
+// pooledBody is the body of a response that was read into a pooled buffer. The buffer goes back to the pool
+// when the body is closed.
+type pooledBody struct {
+	*bytes.Reader
+	buffer *bytes.Buffer
+	once   sync.Once
+}
+
+func (b *pooledBody) Close() error {
+	b.once.Do(func() {
+		// whatever hasn't been read yet points into the buffer
+		b.Reader = bytes.NewReader(nil)
+		responseBuffers.put(b.buffer)
+	})
+
+	return nil
+}
@@ -47,6 +47,14 @@ type QueryPlanCache interface {
 	Retrieve(ctx *PlanningContext, hash *string, planner QueryPlanner) (QueryPlanList, error)
 }
 
+// ClearableQueryPlanCache is a QueryPlanCache that can forget the plans it holds. The gateway clears its cache
+// every time its schema changes since the plans were built for the old one. A cache that can't be cleared keeps
+// the old plans until it drops them on its own.
+type ClearableQueryPlanCache interface {
+	QueryPlanCache
+	Clear()
+}
+
 // WithNoQueryPlanCache is the default option and disables any persisted query behavior
 func WithNoQueryPlanCache() Option {
 	return WithQueryPlanCache(&NoQueryPlanCache{})
@@ -81,7 +89,9 @@ type queryPlanCacheItem struct {
 // otherwise it will compute the plan and save it for later, to be referenced by the designated hash.
 type AutomaticQueryPlanCache struct {
 	cache map[string]*queryPlanCacheItem
-	ttl   time.Duration
+	// guards the plans, which are shared with the copies made by WithCacheTTL
+	cacheMutex *sync.Mutex
+	ttl        time.Duration
 	// the automatic query plan cache needs to clear itself of query plans that have been used
 	// recently. This coordination requires a channel over which events can be trigger whenever
 	// a query is fired, triggering a check to clean up other queries.
@@ -90,6 +100,9 @@ type AutomaticQueryPlanCache struct {
 	resetTimer bool
 	// a mutex on the timer bool
 	timeMutex sync.Mutex
+	// closed when the goroutine cleaning up the cache has to stop
+	stop     chan bool
+	stopOnce *sync.Once
 }
 
 // WithCacheTTL updates and returns the cache with the new cache lifetime. Queries that haven't been
@@ -97,20 +110,50 @@ type AutomaticQueryPlanCache struct {
 func (c *AutomaticQueryPlanCache) WithCacheTTL(duration time.Duration) *AutomaticQueryPlanCache {
 	return &AutomaticQueryPlanCache{
 		cache:         c.cache,
+		cacheMutex:    c.cacheMutex,
 		ttl:           duration,
 		retrievedPlan: c.retrievedPlan,
 		resetTimer:    c.resetTimer,
+		stop:          c.stop,
+		stopOnce:      c.stopOnce,
+	}
+}
+
+// Stop stops the goroutine that cleans up the cache. Plans can still be retrieved afterwards but
+// they will no longer expire.
+func (c *AutomaticQueryPlanCache) Stop() {
+	if c.stopOnce != nil {
+		c.stopOnce.Do(func() { close(c.stop) })
 	}
 }
 
+// Len returns the number of plans in the cache
+func (c *AutomaticQueryPlanCache) Len() int {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	return len(c.cache)
+}
+
+// Clear forgets every plan in the cache
+func (c *AutomaticQueryPlanCache) Clear() {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	c.cache = map[string]*queryPlanCacheItem{}
+}
+
 // NewAutomaticQueryPlanCache returns a fresh instance of
 func NewAutomaticQueryPlanCache() *AutomaticQueryPlanCache {
 	return &AutomaticQueryPlanCache{
-		cache: map[string]*queryPlanCacheItem{},
+		cache:      map[string]*queryPlanCacheItem{},
+		cacheMutex: &sync.Mutex{},
 		// default cache lifetime of 3 days
 		ttl:           10 * 24 * time.Hour,
 		retrievedPlan: make(chan bool),
 		resetTimer:    false,
+		stop:          make(chan bool),
+		stopOnce:      &sync.Once{},
 	}
 }
 
@@ -123,6 +166,13 @@ func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, p
 	defer func() {
 		// spawn a goroutine that might be responsible for clearing the cache
 		go func() {
+			// if the cache has been stopped there's nothing to clean up
+			select {
+			case <-c.stop:
+				return
+			default:
+			}
+
 			// check if there is a timer to reset
 			c.timeMutex.Lock()
 			resetTimer := c.resetTimer
@@ -131,7 +181,10 @@ func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, p
 			// if there is already a goroutine that's waiting to clean things up
 			if resetTimer {
 				// just reset their time
-				c.retrievedPlan <- true
+				select {
+				case c.retrievedPlan <- true:
+				case <-c.stop:
+				}
 				// and we're done
 				return
 			}
@@ -146,6 +199,11 @@ func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, p
 		TRUE_LOOP:
 			for {
 				select {
+				// if the cache was stopped
+				case <-c.stop:
+					timer.Stop()
+					break TRUE_LOOP
+
 				// if another plan was retrieved
 				case <-c.retrievedPlan:
 					// reset the time
@@ -159,6 +217,7 @@ func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, p
 					c.timeMutex.Unlock()
 
 					// loop over every time in the cache
+					c.cacheMutex.Lock()
 					for key, cacheItem := range c.cache {
 						// if the cached query hasn't been used recently enough
 						if cacheItem.LastUsed.Before(time.Now().Add(-c.ttl)) {
@@ -166,6 +225,7 @@ func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, p
 							delete(c.cache, key)
 						}
 					}
+					c.cacheMutex.Unlock()
 
 					// stop consuming
 					break TRUE_LOOP
@@ -176,12 +236,15 @@ func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, p
 	}()
 
 	// if we have a cached value for the hash
+	c.cacheMutex.Lock()
 	if cached, hasCachedValue := c.cache[*hash]; hasCachedValue {
 		// update the last used
 		cached.LastUsed = time.Now()
+		c.cacheMutex.Unlock()
 		// return it
 		return cached.Value, nil
 	}
+	c.cacheMutex.Unlock()
 
 	// we dont have a cached value
 
@@ -205,10 +268,12 @@ func (c *AutomaticQueryPlanCache) Retrieve(ctx *PlanningContext, hash *string, p
 	}
 
 	// save it for later
+	c.cacheMutex.Lock()
 	c.cache[*hash] = &queryPlanCacheItem{
 		LastUsed: time.Now(),
 		Value:    plan,
 	}
+	c.cacheMutex.Unlock()
 
 	// we're done
 	return plan, nil
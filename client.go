@@ -0,0 +1,351 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/nautilus/graphql"
+)
+
+// Request is an operation sent to the gateway by code running in the same process
+type Request struct {
+	Query         string
+	OperationName string
+	Variables     map[string]interface{}
+}
+
+// ExecuteInto executes the request and decodes the data of the response into target, which must be a
+// pointer to a struct (or a map). Struct fields are matched to the fields in the response using their
+// json (or mapstructure) tags, falling back to the name of the field. If the response has errors, they are
+// returned as a graphql.ErrorList after decoding whatever data came back.
+func (g *Gateway) ExecuteInto(ctx context.Context, request *Request, target interface{}) error {
+	requestContext := &RequestContext{
+		Context:       ctx,
+		Query:         request.Query,
+		OperationName: request.OperationName,
+		Variables:     request.Variables,
+	}
+
+	// build up the plan for the request
+	plans, err := g.GetPlans(requestContext)
+	if err != nil {
+		return err
+	}
+
+	// execute it
+	result, executeErr := g.Execute(requestContext, plans)
+
+	// decode whatever data we got back
+	if result != nil {
+		if err := DecodeResult(result, target); err != nil && executeErr == nil {
+			return err
+		}
+	}
+
+	// errors from the services take priority over any trouble we had decoding
+	if executeErr != nil {
+		if _, ok := executeErr.(graphql.ErrorList); ok {
+			return executeErr
+		}
+		return graphql.ErrorList{executeErr}
+	}
+
+	return nil
+}
+
+// DecodeError is returned when the value at a particular location in a response can't be
+// decoded into the provided target
+type DecodeError struct {
+	Path    []interface{}
+	Message string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("could not decode %s: %s", formatResponsePath(e.Path), e.Message)
+}
+
+// DecodeResult copies the data of a response into target, which must be a non-nil pointer.
+// A null value clears a pointer field and is an error for fields that can't be nil while
+// values missing from the response leave the corresponding field untouched.
+func DecodeResult(data map[string]interface{}, target interface{}) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("can only decode into a non-nil pointer, found %T", target)
+	}
+
+	return decodeValue([]interface{}{}, data, value.Elem())
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// decodeValue writes the value found at path in the response to target
+func decodeValue(path []interface{}, value interface{}, target reflect.Value) error {
+	// the gateway's own fields can resolve to pointers and nil maps which are treated like they would be in json
+	switch reflected := reflect.ValueOf(value); reflected.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if reflected.IsNil() {
+			value = nil
+		} else if reflected.Kind() == reflect.Ptr {
+			value = reflected.Elem().Interface()
+		}
+	}
+
+	// pointers are the only way to tell that a value was null
+	if target.Kind() == reflect.Ptr {
+		if value == nil {
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		return decodeValue(path, value, target.Elem())
+	}
+
+	// types that know how to read themselves (ie, custom scalars) are given the json of the value
+	if target.CanAddr() && target.Addr().Type().Implements(jsonUnmarshalerType) {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return &DecodeError{Path: path, Message: err.Error()}
+		}
+
+		if err := target.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(encoded); err != nil {
+			return &DecodeError{Path: path, Message: err.Error()}
+		}
+		return nil
+	}
+
+	if value == nil {
+		switch target.Kind() {
+		case reflect.Interface, reflect.Slice, reflect.Map:
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+
+		return &DecodeError{Path: path, Message: fmt.Sprintf("found null for %s, use a pointer for values that can be null", target.Type())}
+	}
+
+	switch target.Kind() {
+	case reflect.Interface:
+		if !reflect.TypeOf(value).AssignableTo(target.Type()) {
+			return decodeMismatch(path, value, target)
+		}
+		target.Set(reflect.ValueOf(value))
+
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return decodeMismatch(path, value, target)
+		}
+
+		return decodeStruct(path, object, target)
+
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok || target.Type().Key().Kind() != reflect.String {
+			return decodeMismatch(path, value, target)
+		}
+
+		if target.IsNil() {
+			target.Set(reflect.MakeMap(target.Type()))
+		}
+
+		for key, entry := range object {
+			decoded := reflect.New(target.Type().Elem()).Elem()
+			if err := decodeValue(appendPath(path, key), entry, decoded); err != nil {
+				return err
+			}
+
+			target.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), decoded)
+		}
+
+	case reflect.Slice:
+		// the gateway's own fields can resolve to lists of something more specific than interface{}
+		list := reflect.ValueOf(value)
+		if list.Kind() != reflect.Slice {
+			return decodeMismatch(path, value, target)
+		}
+
+		decoded := reflect.MakeSlice(target.Type(), list.Len(), list.Len())
+		for i := 0; i < list.Len(); i++ {
+			if err := decodeValue(appendPath(path, i), list.Index(i).Interface(), decoded.Index(i)); err != nil {
+				return err
+			}
+		}
+		target.Set(decoded)
+
+	case reflect.String:
+		str, ok := value.(string)
+		if !ok {
+			return decodeMismatch(path, value, target)
+		}
+		target.SetString(str)
+
+	case reflect.Bool:
+		boolean, ok := value.(bool)
+		if !ok {
+			return decodeMismatch(path, value, target)
+		}
+		target.SetBool(boolean)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, ok := decodeNumber(value)
+		if !ok || number != math.Trunc(number) || target.OverflowInt(int64(number)) {
+			return decodeMismatch(path, value, target)
+		}
+		target.SetInt(int64(number))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := decodeNumber(value)
+		if !ok || number < 0 || number != math.Trunc(number) || target.OverflowUint(uint64(number)) {
+			return decodeMismatch(path, value, target)
+		}
+		target.SetUint(uint64(number))
+
+	case reflect.Float32, reflect.Float64:
+		number, ok := decodeNumber(value)
+		if !ok || target.OverflowFloat(number) {
+			return decodeMismatch(path, value, target)
+		}
+		target.SetFloat(number)
+
+	default:
+		return &DecodeError{Path: path, Message: fmt.Sprintf("cannot decode into %s", target.Type())}
+	}
+
+	return nil
+}
+
+// decodeStruct copies the fields of the object into the matching fields of the struct
+func decodeStruct(path []interface{}, object map[string]interface{}, target reflect.Value) error {
+	targetType := target.Type()
+
+	for i := 0; i < targetType.NumField(); i++ {
+		field := targetType.Field(i)
+
+		// figure out the name of the field in the response
+		name := field.Tag.Get("json")
+		if name == "" {
+			name = field.Tag.Get("mapstructure")
+		}
+		name = strings.Split(name, ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		// embedded structs without a name share the object of their parent
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			if err := decodeStruct(path, object, target.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// we can't write to unexported fields
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		// look for the value with the exact name before we try to match it without worrying about case
+		value, ok := object[name]
+		if !ok {
+			for key, entry := range object {
+				if strings.EqualFold(key, name) {
+					name, value, ok = key, entry, true
+					break
+				}
+			}
+		}
+
+		// values that aren't in the response leave the field alone
+		if !ok {
+			continue
+		}
+
+		if err := decodeValue(appendPath(path, name), value, target.Field(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeNumber returns the numeric value of something found in a response
+func decodeNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int32:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case json.Number:
+		number, err := value.Float64()
+		return number, err == nil
+	}
+
+	return 0, false
+}
+
+// decodeMismatch builds the error for a value that isn't the right type for its target
+func decodeMismatch(path []interface{}, value interface{}, target reflect.Value) error {
+	// describe the value in terms of the response
+	found := fmt.Sprintf("%T", value)
+	switch value.(type) {
+	case string:
+		found = "a string"
+	case bool:
+		found = "a boolean"
+	case float64, float32, int, int32, int64, json.Number:
+		found = fmt.Sprintf("the number %v", value)
+	case map[string]interface{}:
+		found = "an object"
+	case []interface{}:
+		found = "a list"
+	}
+
+	return &DecodeError{Path: path, Message: fmt.Sprintf("cannot use %s as %s", found, target.Type())}
+}
+
+// appendPath returns a copy of the path with an additional entry
+func appendPath(path []interface{}, entry interface{}) []interface{} {
+	result := make([]interface{}, len(path), len(path)+1)
+	copy(result, path)
+	return append(result, entry)
+}
+
+// formatResponsePath turns a path into a string like user.friends[0].name
+func formatResponsePath(path []interface{}) string {
+	if len(path) == 0 {
+		return "the response"
+	}
+
+	formatted := ""
+	for _, entry := range path {
+		switch entry := entry.(type) {
+		case int:
+			formatted += "[" + strconv.Itoa(entry) + "]"
+		default:
+			if formatted != "" {
+				formatted += "."
+			}
+			formatted += fmt.Sprint(entry)
+		}
+	}
+
+	return formatted
+}
@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeResult(t *testing.T) {
+	type Friend struct {
+		Name     string  `json:"name"`
+		Nickname *string `json:"nickname"`
+	}
+
+	type User struct {
+		ID        string    `json:"id"`
+		Age       int       `mapstructure:"age"`
+		Score     float64   `json:"score"`
+		Admin     bool      `json:"admin"`
+		Bio       *string   `json:"bio"`
+		Title     *string   `json:"title"`
+		CreatedAt time.Time `json:"createdAt"`
+		Friends   []Friend  `json:"friends"`
+		Extra     map[string]interface{}
+	}
+
+	var result struct {
+		User *User `json:"user"`
+	}
+
+	// values that are missing from the response should be left alone
+	title := "Dr."
+	result.User = &User{Title: &title}
+
+	err := DecodeResult(map[string]interface{}{
+		"user": map[string]interface{}{
+			"id":        "1",
+			"age":       float64(42),
+			"score":     1.5,
+			"admin":     true,
+			"bio":       nil,
+			"createdAt": "2020-01-02T03:04:05Z",
+			"friends": []interface{}{
+				map[string]interface{}{"name": "John", "nickname": "Johnny"},
+				map[string]interface{}{"name": "Jane", "nickname": nil},
+			},
+			"extra": map[string]interface{}{"hello": "world"},
+		},
+	}, &result)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, "1", result.User.ID)
+	assert.Equal(t, 42, result.User.Age)
+	assert.Equal(t, 1.5, result.User.Score)
+	assert.True(t, result.User.Admin)
+	assert.Nil(t, result.User.Bio)
+	assert.Equal(t, &title, result.User.Title)
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), result.User.CreatedAt)
+	assert.Equal(t, map[string]interface{}{"hello": "world"}, result.User.Extra)
+	if assert.Len(t, result.User.Friends, 2) {
+		assert.Equal(t, "Johnny", *result.User.Friends[0].Nickname)
+		assert.Nil(t, result.User.Friends[1].Nickname)
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		table := []struct {
+			Message string
+			Data    map[string]interface{}
+			Error   string
+		}{
+			{
+				"Type mismatch",
+				map[string]interface{}{"user": map[string]interface{}{"friends": []interface{}{
+					map[string]interface{}{"name": "John"},
+					map[string]interface{}{"name": float64(2)},
+				}}},
+				"could not decode user.friends[1].name: cannot use the number 2 as string",
+			},
+			{
+				"Null for a value that can't be null",
+				map[string]interface{}{"user": map[string]interface{}{"age": nil}},
+				"could not decode user.age: found null for int, use a pointer for values that can be null",
+			},
+			{
+				"Fractional integer",
+				map[string]interface{}{"user": map[string]interface{}{"age": 1.5}},
+				"could not decode user.age: cannot use the number 1.5 as int",
+			},
+		}
+
+		for _, row := range table {
+			t.Run(row.Message, func(t *testing.T) {
+				var target struct {
+					User User `json:"user"`
+				}
+
+				err := DecodeResult(row.Data, &target)
+				if !assert.NotNil(t, err) {
+					return
+				}
+				assert.IsType(t, &DecodeError{}, err)
+				assert.Equal(t, row.Error, err.Error())
+			})
+		}
+	})
+}
+
+func TestGateway_executeInto(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			me(name: String): User
+			broken: String
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithExecutor(ExecutorFunc(
+		func(ctx *ExecutionContext) (map[string]interface{}, error) {
+			if graphql.SelectedFields(ctx.Plan.Operation.SelectionSet)[0].Name == "broken" {
+				return map[string]interface{}{"broken": nil}, graphql.ErrorList{graphql.NewError("BROKEN", "it broke")}
+			}
+
+			return map[string]interface{}{
+				"me": map[string]interface{}{"id": "1", "name": ctx.Variables["name"]},
+			}, nil
+		},
+	)))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	t.Run("Data", func(t *testing.T) {
+		var result struct {
+			Me struct {
+				ID   string
+				Name string
+			}
+		}
+
+		err := gateway.ExecuteInto(context.Background(), &Request{
+			Query:     `query($name: String!) { me(name: $name) { id name } }`,
+			Variables: map[string]interface{}{"name": "John"},
+		}, &result)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, "1", result.Me.ID)
+		assert.Equal(t, "John", result.Me.Name)
+	})
+
+	t.Run("GraphQL errors", func(t *testing.T) {
+		var result struct {
+			Broken *string
+		}
+
+		err := gateway.ExecuteInto(context.Background(), &Request{Query: `{ broken }`}, &result)
+
+		errList, ok := err.(graphql.ErrorList)
+		if !assert.True(t, ok) || !assert.Len(t, errList, 1) {
+			return
+		}
+		assert.Equal(t, "it broke", errList[0].(*graphql.Error).Message)
+		assert.Equal(t, "BROKEN", errList[0].(*graphql.Error).Extensions["code"])
+		assert.Nil(t, result.Broken)
+	})
+}
@@ -1,55 +1,98 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/nautilus/gateway"
-	"github.com/nautilus/graphql"
 )
 
 func ListenAndServe(services []string) {
-	// introspect the schemas
-	schemas, err := graphql.IntrospectRemoteSchemas(services...)
-	if err != nil {
-		fmt.Println("Encountered error introspecting schemas:", err.Error())
-		os.Exit(1)
-	}
-
 	// create the gateway instance
-	gw, err := gateway.New(schemas)
+	gw, err := gateway.New(remoteSchemas(services), serverOptions()...)
 	if err != nil {
 		fmt.Println("Encountered error starting gateway:", err.Error())
 		os.Exit(1)
 	}
 
 	// add the graphql endpoints to the router
-	http.HandleFunc("/graphql", setCORSHeaders(gw.PlaygroundHandler))
+	http.HandleFunc("/graphql", gw.PlaygroundHandler)
+	// and let tools grab the schema without an introspection query
+	http.Handle("/schema.graphql", gw.CORS(http.HandlerFunc(gw.SchemaSDLHandler)))
+	// report if any of the services are being served from the snapshot or were left out
+	http.HandleFunc("/health", gw.HealthHandler)
+	// let clients check their queries without executing them
+	http.HandleFunc("/validate", gw.ValidateHandler)
+
+	server := &http.Server{Addr: fmt.Sprintf(":%s", Port)}
+
+	// when we are asked to stop, let the requests in flight finish before we go
+	stopped := make(chan bool)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := gw.Shutdown(ctx); err != nil {
+			fmt.Println("Encountered error draining requests:", err.Error())
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			fmt.Println("Encountered error stopping server:", err.Error())
+		}
+		close(stopped)
+	}()
 
 	// start the server
 	fmt.Printf("🚀 Gateway is ready at http://localhost:%s/graphql\n", Port)
-	err = http.ListenAndServe(fmt.Sprintf(":%s", Port), nil)
-	if err != nil {
+	err = server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
+
+	<-stopped
 }
 
-func setCORSHeaders(fn http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, req *http.Request) {
-		// set the necessary CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Allow-Methods", "GET,HEAD,OPTIONS,POST,PUT")
-		w.Header().Set("Access-Control-Allow-Headers", "*")
-
-		// if we are handling a pre-flight request
-		if req.Method == http.MethodOptions {
-			return
-		}
+// serverOptions returns the options of the gateway that were set with the flags of the start command
+func serverOptions() []gateway.Option {
+	// pages on any origin can use the gateway, as long as they don't send cookies
+	options := []gateway.Option{
+		gateway.WithCORS(gateway.CORSOptions{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}}),
+	}
 
-		// invoke the handler
-		fn(w, req)
+	// if we were told where to keep a snapshot, we can start even if a service is down
+	if SnapshotPath != "" {
+		options = append(options, gateway.WithSchemaSnapshot(gateway.NewFileSnapshotStore(SnapshotPath)))
+	}
+	if PartialBoot {
+		options = append(options, gateway.WithPartialBoot(true))
+	}
+	if Production {
+		options = append(options, gateway.WithProductionMode())
+	}
+	if NoIntrospection {
+		options = append(options, gateway.WithoutIntrospection())
 	}
+	if PlanCache {
+		options = append(options, gateway.WithAutomaticQueryPlanCache())
+	}
+	if MaxPlanDepth > 0 {
+		options = append(options, gateway.WithMaxPlanDepth(MaxPlanDepth))
+	}
+	if MaxPlanSteps > 0 {
+		options = append(options, gateway.WithMaxPlanSteps(MaxPlanSteps))
+	}
+	if MaxRequestBodySize > 0 {
+		options = append(options, gateway.WithMaxRequestBodySize(MaxRequestBodySize))
+	}
+
+	return options
 }
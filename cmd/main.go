@@ -10,6 +10,8 @@ import (
 var rootCmd = &cobra.Command{
 	Use:   "graphql-gateway",
 	Short: "GraphQL Gateway is a standalone service to consolidate your GraphQL APIs.",
+	// main prints the error of a command
+	SilenceErrors: true,
 }
 
 // start the gateway executable
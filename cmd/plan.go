@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nautilus/gateway"
+	"github.com/spf13/cobra"
+)
+
+// planStep is the part of a step that is printed by the plan command
+type planStep struct {
+	Service        string      `json:"service"`
+	ParentType     string      `json:"parentType"`
+	InsertionPoint []string    `json:"insertionPoint"`
+	Query          string      `json:"query"`
+	Then           []*planStep `json:"then"`
+}
+
+// newPlanCmd returns the command that prints the plan of a query without executing it
+func newPlanCmd() *cobra.Command {
+	var services []string
+	var queryPath, operationName, format string
+
+	cmd := &cobra.Command{
+		Use:          "plan",
+		Short:        "Print the steps the gateway would take to resolve a query",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "tree" && format != "json" {
+				return fmt.Errorf("unknown format %s, use tree or json", format)
+			}
+
+			query, err := readInput(queryPath)
+			if err != nil {
+				return err
+			}
+
+			gw, err := gateway.New(remoteSchemas(services))
+			if err != nil {
+				return err
+			}
+
+			ctx := &gateway.RequestContext{
+				Context:       context.Background(),
+				Query:         query,
+				OperationName: operationName,
+			}
+			plans, err := gw.GetPlans(ctx)
+			if err != nil {
+				return err
+			}
+			// a document with a single operation doesn't need to name it
+			plan := plans[0]
+			if len(plans) > 1 {
+				plan, err = plans.ForOperation(operationName)
+				if err != nil {
+					return err
+				}
+			}
+
+			steps := planSteps(plan.RootStep.Then)
+			if format == "json" {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(steps)
+			}
+
+			printPlanTree(cmd.OutOrStdout(), steps, "")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&services, "services", "s", []string{}, "the services to plan the query against")
+	cmd.MarkFlagRequired("services")
+	cmd.Flags().StringVarP(&queryPath, "query", "q", "", "the file with the query to plan (- for stdin)")
+	cmd.MarkFlagRequired("query")
+	cmd.Flags().StringVar(&operationName, "operation", "", "the operation to plan if the document has more than one")
+	cmd.Flags().StringVar(&format, "format", "tree", "how to print the plan: tree or json")
+
+	return cmd
+}
+
+// planSteps converts the steps of a plan into the form that is printed
+func planSteps(steps []*gateway.QueryPlanStep) []*planStep {
+	result := []*planStep{}
+	for _, step := range steps {
+		insertionPoint := step.InsertionPoint
+		if insertionPoint == nil {
+			insertionPoint = []string{}
+		}
+
+		result = append(result, &planStep{
+			Service:        step.Location,
+			ParentType:     step.ParentType,
+			InsertionPoint: insertionPoint,
+			Query:          step.QueryString,
+			Then:           planSteps(step.Then),
+		})
+	}
+
+	return result
+}
+
+// printPlanTree writes the steps with the ones that depend on them nested underneath
+func printPlanTree(out io.Writer, steps []*planStep, indent string) {
+	for _, step := range steps {
+		fmt.Fprintf(out, "%s- %s (%s)", indent, step.Service, step.ParentType)
+		if len(step.InsertionPoint) > 0 {
+			fmt.Fprintf(out, " at %s", strings.Join(step.InsertionPoint, "."))
+		}
+		fmt.Fprintln(out)
+
+		for _, line := range strings.Split(strings.TrimSpace(step.Query), "\n") {
+			fmt.Fprintf(out, "%s    %s\n", indent, line)
+		}
+
+		printPlanTree(out, step.Then, indent+"  ")
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(newPlanCmd())
+}
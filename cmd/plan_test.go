@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nautilus/gateway/gatewaytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan(t *testing.T) {
+	users := gatewaytest.NewMockService(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			viewer: User!
+			node(id: ID!): Node
+		}
+	`, nil)
+	defer users.Close()
+	avatars := gatewaytest.NewMockService(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			avatar: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`, nil)
+	defer avatars.Close()
+
+	dir, err := ioutil.TempDir("", "plan")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	query := filepath.Join(dir, "query.graphql")
+	ioutil.WriteFile(query, []byte(`query Viewer { viewer { name avatar } }`), 0644)
+	services := users.URL + "," + avatars.URL
+
+	// the plan can be printed as JSON
+	out, err := runCommand(newPlanCmd(), "--services", services, "--query", query, "--format", "json")
+	if !assert.Nil(t, err) {
+		return
+	}
+	steps := []*planStep{}
+	if assert.Nil(t, json.Unmarshal([]byte(out), &steps)) && assert.Len(t, steps, 1) {
+		assert.Equal(t, users.URL, steps[0].Service)
+		assert.Equal(t, "Query", steps[0].ParentType)
+		if assert.Len(t, steps[0].Then, 1) {
+			assert.Equal(t, avatars.URL, steps[0].Then[0].Service)
+			assert.Equal(t, []string{"viewer"}, steps[0].Then[0].InsertionPoint)
+			assert.Contains(t, steps[0].Then[0].Query, "avatar")
+		}
+	}
+
+	// or as a tree
+	out, err = runCommand(newPlanCmd(), "--services", services, "--query", query)
+	if assert.Nil(t, err) {
+		assert.Contains(t, out, "- "+users.URL+" (Query)\n")
+		assert.Contains(t, out, "  - "+avatars.URL+" (User) at viewer\n")
+	}
+
+	// the services were only introspected, nothing was executed
+	for _, service := range []*gatewaytest.MockService{users, avatars} {
+		for _, request := range service.Requests() {
+			assert.Contains(t, request.Query, "__schema")
+		}
+	}
+
+	// queries that don't fit the schema can't be planned
+	ioutil.WriteFile(query, []byte(`{ viewer { email } }`), 0644)
+	_, err = runCommand(newPlanCmd(), "--services", services, "--query", query)
+	assert.NotNil(t, err)
+}
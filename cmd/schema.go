@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/nautilus/gateway"
+	"github.com/nautilus/graphql"
+	"github.com/spf13/cobra"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// newSchemaCmd returns the command that groups the things that can be done with schemas
+func newSchemaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Work with the schemas of the services",
+	}
+	cmd.AddCommand(newSchemaMergeCmd(), newSchemaDiffCmd())
+
+	return cmd
+}
+
+// newSchemaMergeCmd returns the command that writes the schema the gateway would serve
+func newSchemaMergeCmd() *cobra.Command {
+	var services []string
+	var out string
+
+	cmd := &cobra.Command{
+		Use:          "merge",
+		Short:        "Introspect the services and write the merged schema",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// the gateway does the introspecting and merging for us and fails if the schemas conflict
+			gw, err := gateway.New(remoteSchemas(services))
+			if err != nil {
+				return err
+			}
+
+			sdl, err := gw.SchemaSDL(context.Background())
+			if err != nil {
+				return err
+			}
+
+			return writeOutput(cmd.OutOrStdout(), out, sdl)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&services, "services", "s", []string{}, "the services to merge")
+	cmd.MarkFlagRequired("services")
+	cmd.Flags().StringVarP(&out, "out", "o", "", "the file to write the schema to (defaults to stdout)")
+
+	return cmd
+}
+
+// newSchemaDiffCmd returns the command that reports the changes between two versions of a schema
+func newSchemaDiffCmd() *cobra.Command {
+	var oldPath, newPath string
+
+	cmd := &cobra.Command{
+		Use:          "diff",
+		Short:        "Report the changes between two schemas, failing if any of them are breaking",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			old, err := loadSchemaFile(oldPath)
+			if err != nil {
+				return err
+			}
+			new, err := loadSchemaFile(newPath)
+			if err != nil {
+				return err
+			}
+
+			changes := gateway.DiffSchemas(old, new)
+
+			out := cmd.OutOrStdout()
+			if changes.Empty() {
+				fmt.Fprintln(out, "No changes")
+				return nil
+			}
+			printChanges(out, "Breaking changes", changes.BreakingChanges)
+			printChanges(out, "Deprecations", changes.Deprecations)
+			printChanges(out, "Additions", changes.Additions)
+
+			if len(changes.BreakingChanges) > 0 {
+				return fmt.Errorf("found %d breaking changes", len(changes.BreakingChanges))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&oldPath, "old", "", "the file with the current version of the schema")
+	cmd.MarkFlagRequired("old")
+	cmd.Flags().StringVar(&newPath, "new", "", "the file with the next version of the schema")
+	cmd.MarkFlagRequired("new")
+
+	return cmd
+}
+
+// printChanges writes the list of changes under the title, if there are any
+func printChanges(out io.Writer, title string, changes []*gateway.SchemaChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	fmt.Fprintf(out, "%s:\n", title)
+	for _, change := range changes {
+		fmt.Fprintf(out, "  %s: %s\n", change.Coordinate, change.Message)
+	}
+}
+
+// loadSchemaFile reads the schema written in the file
+func loadSchemaFile(path string) (*ast.Schema, error) {
+	contents, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := graphql.LoadSchema(contents)
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s: %s", path, err.Error())
+	}
+
+	return schema, nil
+}
+
+// remoteSchemas returns the sources for the services at the urls. The gateway introspects them when it's created.
+func remoteSchemas(services []string) []*graphql.RemoteSchema {
+	schemas := []*graphql.RemoteSchema{}
+	for _, service := range services {
+		schemas = append(schemas, &graphql.RemoteSchema{URL: service})
+	}
+
+	return schemas
+}
+
+// readInput returns the contents of the file, or of stdin if the path is -
+func readInput(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("a file is required")
+	}
+
+	var contents []byte
+	var err error
+	if path == "-" {
+		contents, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		contents, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(contents), nil
+}
+
+// writeOutput writes the contents to the file, or to out if there isn't one
+func writeOutput(out io.Writer, path string, contents string) error {
+	if path == "" || path == "-" {
+		_, err := io.WriteString(out, contents)
+		return err
+	}
+
+	return ioutil.WriteFile(path, []byte(contents), 0644)
+}
+
+func init() {
+	rootCmd.AddCommand(newSchemaCmd())
+}
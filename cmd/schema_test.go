@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nautilus/gateway/gatewaytest"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+// runCommand runs the command with the designated arguments and returns what it printed
+func runCommand(cmd *cobra.Command, args ...string) (string, error) {
+	out := &bytes.Buffer{}
+	cmd.SetOutput(out)
+	cmd.SetArgs(args)
+
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestSchemaMerge(t *testing.T) {
+	users := gatewaytest.NewMockService(`
+		type User {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			viewer: User!
+		}
+	`, nil)
+	defer users.Close()
+	posts := gatewaytest.NewMockService(`
+		type Post {
+			id: ID!
+			title: String!
+		}
+
+		type Query {
+			posts: [Post!]!
+		}
+	`, nil)
+	defer posts.Close()
+
+	// the merged schema can be written to stdout
+	out, err := runCommand(newSchemaCmd(), "merge", "--services", users.URL+","+posts.URL)
+	if assert.Nil(t, err) {
+		assert.Contains(t, out, "viewer: User!")
+		assert.Contains(t, out, "posts: [Post!]!")
+	}
+
+	// or to a file
+	dir, err := ioutil.TempDir("", "merge")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "schema.graphql")
+	_, err = runCommand(newSchemaCmd(), "merge", "--services", users.URL, "--out", path)
+	if assert.Nil(t, err) {
+		written, _ := ioutil.ReadFile(path)
+		assert.Contains(t, string(written), "viewer: User!")
+	}
+
+	// services that can't be merged fail the command
+	conflict := gatewaytest.NewMockService(`
+		type User {
+			id: ID!
+			name: Int!
+		}
+
+		type Query {
+			viewer: User!
+		}
+	`, nil)
+	defer conflict.Close()
+
+	_, err = runCommand(newSchemaCmd(), "merge", "--services", users.URL+","+conflict.URL)
+	assert.NotNil(t, err)
+}
+
+func TestSchemaDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, sdl string) string {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(sdl), 0644)
+		return path
+	}
+
+	old := write("old.graphql", `
+		type User {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			viewer: User!
+		}
+	`)
+	added := write("added.graphql", `
+		type User {
+			id: ID!
+			name: String!
+			email: String
+		}
+
+		type Query {
+			viewer: User!
+		}
+	`)
+	removed := write("removed.graphql", `
+		type User {
+			id: ID!
+		}
+
+		type Query {
+			viewer: User!
+		}
+	`)
+
+	// additions are reported but don't fail the command
+	out, err := runCommand(newSchemaCmd(), "diff", "--old", old, "--new", added)
+	assert.Nil(t, err)
+	assert.Contains(t, out, "Additions:\n  User.email: ")
+
+	// breaking changes do
+	out, err = runCommand(newSchemaCmd(), "diff", "--old", old, "--new", removed)
+	assert.NotNil(t, err)
+	assert.Contains(t, out, "Breaking changes:\n  User.name: ")
+
+	out, err = runCommand(newSchemaCmd(), "diff", "--old", old, "--new", old)
+	assert.Nil(t, err)
+	assert.Equal(t, "No changes\n", out)
+}
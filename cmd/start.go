@@ -5,13 +5,22 @@ import (
 )
 
 var startCmd = &cobra.Command{
-	Use:   "start",
-	Short: "Start the gateway",
-	Run:   StartServer,
+	Use:     "start",
+	Aliases: []string{"serve"},
+	Short:   "Start the gateway",
+	Run:     StartServer,
 }
 
 var Port string
 var Services []string
+var SnapshotPath string
+var PartialBoot bool
+var Production bool
+var NoIntrospection bool
+var PlanCache bool
+var MaxPlanDepth int
+var MaxPlanSteps int
+var MaxRequestBodySize int64
 
 func init() {
 	// add the configuration paramters for the start command
@@ -20,6 +29,15 @@ func init() {
 	startCmd.Flags().StringSliceVarP(&Services, "services", "s", []string{}, "Specify the services to wrap over")
 	startCmd.MarkFlagRequired("services")
 
+	startCmd.Flags().StringVar(&SnapshotPath, "snapshot", "", "a file to keep the schemas of the services in so the gateway can start when one is down")
+	startCmd.Flags().BoolVar(&PartialBoot, "partial-boot", false, "start without the services that can't be introspected")
+	startCmd.Flags().BoolVar(&Production, "production", false, "hide the details of internal errors from clients")
+	startCmd.Flags().BoolVar(&NoIntrospection, "no-introspection", false, "refuse introspection queries")
+	startCmd.Flags().BoolVar(&PlanCache, "plan-cache", false, "cache query plans and accept automatic persisted queries")
+	startCmd.Flags().IntVar(&MaxPlanDepth, "max-plan-depth", 0, "the number of nested steps a query plan can have (0 for no limit)")
+	startCmd.Flags().IntVar(&MaxPlanSteps, "max-plan-steps", 0, "the number of steps a query plan can have (0 for no limit)")
+	startCmd.Flags().Int64Var(&MaxRequestBodySize, "max-body-size", 0, "the number of bytes a request body can have (0 for the default)")
+
 	// add the start command to the root executable
 	rootCmd.AddCommand(startCmd)
 }
@@ -0,0 +1,307 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// When request coalescing is turned on, the queries that the steps of a plan send to the same service within a
+// short window are combined into a single request. The root fields of each query are aliased so that they can
+// be pulled out of the combined response, and the variables and fragments of each query get a suffix so that
+// they can't collide with the ones from the other queries. The plan itself is not affected.
+
+// coalescedAliasPrefix is the prefix of the aliases given to the root fields of a combined query
+const coalescedAliasPrefix = "__gateway_batch_"
+
+// WithRequestCoalescing returns an Option that combines the queries sent to the same service within the
+// window into a single request. Only queries are combined, mutations and subscriptions are sent as they are.
+func WithRequestCoalescing(window time.Duration) Option {
+	return func(g *Gateway) {
+		g.coalesceWindow = window
+	}
+}
+
+// requestCoalescer collects the queries sent while executing a single plan
+type requestCoalescer struct {
+	window  time.Duration
+	mutex   sync.Mutex
+	batches map[string]*coalescedBatch
+}
+
+// coalescedBatch is the list of queries that will be sent to a service together
+type coalescedBatch struct {
+	ctx      context.Context
+	queryer  graphql.Queryer
+	requests []*coalescedRequest
+}
+
+// coalescedRequest is a single query in a batch. done is closed once the response is available.
+type coalescedRequest struct {
+	input      *graphql.QueryInput
+	done       chan struct{}
+	result     map[string]interface{}
+	extensions map[string]interface{}
+	err        error
+}
+
+func newRequestCoalescer(window time.Duration) *requestCoalescer {
+	return &requestCoalescer{window: window, batches: map[string]*coalescedBatch{}}
+}
+
+// query sends the query to the service at location, along with whatever other queries are sent to
+// the same location before the window closes
+func (c *requestCoalescer) query(ctx context.Context, queryer graphql.Queryer, location string, input *graphql.QueryInput) (map[string]interface{}, map[string]interface{}, error) {
+	// some queries can't be combined with others
+	if !coalescable(input) {
+		return executorSendQuery(ctx, queryer, input)
+	}
+
+	request := &coalescedRequest{input: input, done: make(chan struct{})}
+
+	c.mutex.Lock()
+	batch, ok := c.batches[location]
+	if !ok {
+		// the first query to a location opens the window for the others
+		batch = &coalescedBatch{ctx: ctx, queryer: queryer}
+		c.batches[location] = batch
+		time.AfterFunc(c.window, func() {
+			c.mutex.Lock()
+			delete(c.batches, location)
+			c.mutex.Unlock()
+
+			batch.send()
+		})
+	}
+	batch.requests = append(batch.requests, request)
+	c.mutex.Unlock()
+
+	<-request.done
+	return request.result, request.extensions, request.err
+}
+
+// send fires the queries in the batch and hands each one its part of the response
+func (b *coalescedBatch) send() {
+	// a single query can go as it is
+	if len(b.requests) == 1 {
+		b.sendEach()
+		return
+	}
+
+	input, err := coalesceQueries(b.requests)
+	if err != nil {
+		log.Warn("Could not combine queries: ", err)
+		b.sendEach()
+		return
+	}
+	result, extensions, err := executorSendQuery(b.ctx, b.queryer, input)
+
+	// the service drops the data of every query if one of them has an error so we need to send them
+	// on their own to figure out which one failed
+	if _, ok := err.(graphql.ErrorList); ok {
+		b.sendEach()
+		return
+	}
+
+	for i, request := range b.requests {
+		if err != nil {
+			request.err = err
+		} else {
+			request.result = map[string]interface{}{}
+			for _, selection := range request.input.QueryDocument.Operations[0].SelectionSet {
+				alias := coalescedFieldAlias(selection.(*ast.Field))
+				request.result[alias] = result[coalescedAlias(i, alias)]
+			}
+			request.extensions = extensions
+		}
+		close(request.done)
+	}
+}
+
+// sendEach sends every query in the batch on its own
+func (b *coalescedBatch) sendEach() {
+	wg := &sync.WaitGroup{}
+	for _, request := range b.requests {
+		wg.Add(1)
+		go func(request *coalescedRequest) {
+			defer wg.Done()
+
+			request.result, request.extensions, request.err = executorSendQuery(b.ctx, b.queryer, request.input)
+			close(request.done)
+		}(request)
+	}
+	wg.Wait()
+}
+
+// coalescable returns true if the query can be combined with others
+func coalescable(input *graphql.QueryInput) bool {
+	if input.QueryDocument == nil || len(input.QueryDocument.Operations) != 1 {
+		return false
+	}
+
+	operation := input.QueryDocument.Operations[0]
+	if operation.Operation != ast.Query || len(operation.Directives) > 0 {
+		return false
+	}
+
+	// we can only pull the fields at the root of the query out of the combined response
+	for _, selection := range operation.SelectionSet {
+		if _, ok := selection.(*ast.Field); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// coalesceQueries builds the query that combines every request in the batch
+func coalesceQueries(requests []*coalescedRequest) (*graphql.QueryInput, error) {
+	operation := &ast.OperationDefinition{Operation: ast.Query}
+	document := &ast.QueryDocument{Operations: ast.OperationList{operation}}
+	variables := map[string]interface{}{}
+
+	for i, request := range requests {
+		suffix := "_" + strconv.Itoa(i)
+		original := request.input.QueryDocument.Operations[0]
+
+		for _, definition := range original.VariableDefinitions {
+			operation.VariableDefinitions = append(operation.VariableDefinitions, &ast.VariableDefinition{
+				Variable:     definition.Variable + suffix,
+				Type:         definition.Type,
+				DefaultValue: coalescedValue(definition.DefaultValue, suffix),
+			})
+			if value, ok := request.input.Variables[definition.Variable]; ok {
+				variables[definition.Variable+suffix] = value
+			}
+		}
+
+		// the root fields are aliased so we can find them in the response
+		for _, selection := range coalescedSelectionSet(original.SelectionSet, suffix) {
+			field := selection.(*ast.Field)
+			field.Alias = coalescedAlias(i, coalescedFieldAlias(field))
+			operation.SelectionSet = append(operation.SelectionSet, field)
+		}
+
+		for _, fragment := range request.input.QueryDocument.Fragments {
+			document.Fragments = append(document.Fragments, &ast.FragmentDefinition{
+				Name:          fragment.Name + suffix,
+				TypeCondition: fragment.TypeCondition,
+				Directives:    coalescedDirectives(fragment.Directives, suffix),
+				SelectionSet:  coalescedSelectionSet(fragment.SelectionSet, suffix),
+			})
+		}
+	}
+
+	query, err := plannerPrintQuery(document)
+	if err != nil {
+		return nil, err
+	}
+
+	return &graphql.QueryInput{
+		Query:         query,
+		QueryDocument: document,
+		Variables:     variables,
+	}, nil
+}
+
+// coalescedAlias returns the alias of the root field of the query at the designated index in the batch
+func coalescedAlias(index int, alias string) string {
+	return fmt.Sprintf("%s%d_%s", coalescedAliasPrefix, index, alias)
+}
+
+// coalescedFieldAlias returns the key of the field in the response
+func coalescedFieldAlias(field *ast.Field) string {
+	if field.Alias != "" {
+		return field.Alias
+	}
+
+	return field.Name
+}
+
+// coalescedSelectionSet returns a copy of the selection set that refers to the variables and fragments with
+// the suffix. The original selection set is shared with the plan so it can't be modified.
+func coalescedSelectionSet(selectionSet ast.SelectionSet, suffix string) ast.SelectionSet {
+	copied := ast.SelectionSet{}
+
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			field := *selection
+			field.Arguments = ast.ArgumentList{}
+			for _, argument := range selection.Arguments {
+				field.Arguments = append(field.Arguments, &ast.Argument{
+					Name:     argument.Name,
+					Value:    coalescedValue(argument.Value, suffix),
+					Position: argument.Position,
+				})
+			}
+			field.Directives = coalescedDirectives(selection.Directives, suffix)
+			field.SelectionSet = coalescedSelectionSet(selection.SelectionSet, suffix)
+			copied = append(copied, &field)
+
+		case *ast.FragmentSpread:
+			spread := *selection
+			spread.Name = selection.Name + suffix
+			spread.Directives = coalescedDirectives(selection.Directives, suffix)
+			copied = append(copied, &spread)
+
+		case *ast.InlineFragment:
+			fragment := *selection
+			fragment.Directives = coalescedDirectives(selection.Directives, suffix)
+			fragment.SelectionSet = coalescedSelectionSet(selection.SelectionSet, suffix)
+			copied = append(copied, &fragment)
+		}
+	}
+
+	return copied
+}
+
+// coalescedDirectives returns a copy of the directives that refers to the variables with the suffix
+func coalescedDirectives(directives ast.DirectiveList, suffix string) ast.DirectiveList {
+	copied := ast.DirectiveList{}
+
+	for _, directive := range directives {
+		copiedDirective := *directive
+		copiedDirective.Arguments = ast.ArgumentList{}
+		for _, argument := range directive.Arguments {
+			copiedDirective.Arguments = append(copiedDirective.Arguments, &ast.Argument{
+				Name:     argument.Name,
+				Value:    coalescedValue(argument.Value, suffix),
+				Position: argument.Position,
+			})
+		}
+		copied = append(copied, &copiedDirective)
+	}
+
+	return copied
+}
+
+// coalescedValue returns a copy of the value that refers to the variables with the suffix
+func coalescedValue(value *ast.Value, suffix string) *ast.Value {
+	if value == nil {
+		return nil
+	}
+
+	copied := *value
+	if value.Kind == ast.Variable {
+		copied.Raw = value.Raw + suffix
+	}
+
+	if len(value.Children) > 0 {
+		copied.Children = ast.ChildValueList{}
+		for _, child := range value.Children {
+			copied.Children = append(copied.Children, &ast.ChildValue{
+				Name:     child.Name,
+				Value:    coalescedValue(child.Value, suffix),
+				Position: child.Position,
+			})
+		}
+	}
+
+	return &copied
+}
@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestGateway_requestCoalescing(t *testing.T) {
+	contentSDL := `
+		type CatPhoto {
+			id: ID!
+			url: String!
+		}
+
+		type Post {
+			id: ID!
+			title: String!
+		}
+
+		type Query {
+			photos: [CatPhoto!]!
+			posts: [Post!]!
+		}
+	`
+	usersSDL := `
+		interface Node {
+			id: ID!
+		}
+
+		type User {
+			name: String!
+		}
+
+		type CatPhoto implements Node {
+			id: ID!
+			owner: User!
+		}
+
+		type Post implements Node {
+			id: ID!
+			author: User!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`
+
+	// the queries sent to the user service
+	mutex := &sync.Mutex{}
+	userQueries := []string{}
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			if url == "content" {
+				return map[string]interface{}{
+					"photos": []interface{}{map[string]interface{}{"url": "cat.jpg", gatewayIDAlias: "photo-1"}},
+					"posts":  []interface{}{map[string]interface{}{"title": "hello", gatewayIDAlias: "post-1"}},
+				}, nil
+			}
+
+			mutex.Lock()
+			userQueries = append(userQueries, input.Query)
+			mutex.Unlock()
+
+			// every root field looks up the owner of something
+			result := map[string]interface{}{}
+			for _, selection := range input.QueryDocument.Operations[0].SelectionSet {
+				field := selection.(*ast.Field)
+				id := input.Variables[field.Arguments.ForName("id").Value.Raw]
+
+				owner := map[string]interface{}{"name": fmt.Sprintf("owner of %v", id)}
+				if id == "photo-1" {
+					result[field.Alias] = map[string]interface{}{"owner": owner}
+				} else {
+					result[field.Alias] = map[string]interface{}{"author": owner}
+				}
+			}
+			return result, nil
+		})
+	})
+
+	// execute sends the query through the gateway and returns the number of requests to the user service
+	execute := func(options ...Option) (map[string]interface{}, int) {
+		userQueries = []string{}
+
+		// merging the schemas changes them so every gateway needs its own copy
+		contentSchema, _ := graphql.LoadSchema(contentSDL)
+		usersSchema, _ := graphql.LoadSchema(usersSDL)
+		sources := []*graphql.RemoteSchema{
+			{URL: "content", Schema: contentSchema},
+			{URL: "users", Schema: usersSchema},
+		}
+
+		gateway, err := New(sources, append(options, WithQueryerFactory(&factory))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reqCtx := &RequestContext{
+			Context: context.Background(),
+			Query:   `{ photos { url owner { name } } posts { title author { name } } }`,
+		}
+		plans, err := gateway.GetPlans(reqCtx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := gateway.Execute(reqCtx, plans)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return result, len(userQueries)
+	}
+
+	expected := map[string]interface{}{
+		"photos": []interface{}{
+			map[string]interface{}{"url": "cat.jpg", "owner": map[string]interface{}{"name": "owner of photo-1"}},
+		},
+		"posts": []interface{}{
+			map[string]interface{}{"title": "hello", "author": map[string]interface{}{"name": "owner of post-1"}},
+		},
+	}
+
+	// without coalescing, each step sends its own query
+	result, requests := execute()
+	assert.Equal(t, expected, result)
+	assert.Equal(t, 2, requests)
+
+	// with it, the steps share a request
+	result, requests = execute(WithRequestCoalescing(50 * time.Millisecond))
+	assert.Equal(t, expected, result)
+	if assert.Equal(t, 1, requests) {
+		// both steps use the same variable so they have to be renamed
+		assert.Contains(t, userQueries[0], gatewayIDAlias+"_0")
+		assert.Contains(t, userQueries[0], gatewayIDAlias+"_1")
+	}
+}
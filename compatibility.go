@@ -0,0 +1,223 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// When the fields of a type are spread across services, the gateway asks for the id of the object wherever it
+// finds one and looks it up in the other services with their node field. If a service can't answer those queries
+// (the type doesn't have the id field or node can't return it), the problem would only show up as a validation
+// error from the service once a client asks for the wrong combination of fields. Instead, the queries the gateway
+// could send are checked against the schema of each service when the gateway is built.
+
+// SchemaIncompatibility is a query the gateway could have to send that the service would not accept
+type SchemaIncompatibility struct {
+	// the url of the service
+	Service string
+	// the type that the gateway would look up
+	Type string
+	// what is wrong with the service's schema
+	Reason string
+}
+
+func (i *SchemaIncompatibility) String() string {
+	return fmt.Sprintf("%s: %s %s", i.Service, i.Type, i.Reason)
+}
+
+// SchemaCompatibilityError is returned when the services can't answer the queries the gateway would send them
+type SchemaCompatibilityError struct {
+	Incompatibilities []*SchemaIncompatibility
+}
+
+func (e *SchemaCompatibilityError) Error() string {
+	messages := []string{}
+	for _, incompatibility := range e.Incompatibilities {
+		messages = append(messages, incompatibility.String())
+	}
+
+	return "services cannot resolve the queries sent by the gateway: " + strings.Join(messages, "; ")
+}
+
+// WithSchemaCompatibilityWarnings returns an Option that logs the incompatibilities between the services
+// and the queries the gateway sends them instead of refusing to start
+func WithSchemaCompatibilityWarnings() Option {
+	return func(g *Gateway) {
+		g.compatibilityWarnings = true
+	}
+}
+
+// checkCompatibility returns an error if the services can't resolve the queries the gateway could send them,
+// unless it was told to only warn about them
+func (g *Gateway) checkCompatibility(sources []*graphql.RemoteSchema, locations FieldURLMap, entityKeys EntityKeyMap) error {
+	incompatibilities := []*SchemaIncompatibility{}
+	for _, incompatibility := range schemaIncompatibilities(sources, locations, entityKeys, g.idFields) {
+		// mocked services make up an answer to any lookup
+		if _, mocked := g.mockedServices[incompatibility.Service]; mocked {
+			continue
+		}
+		incompatibilities = append(incompatibilities, incompatibility)
+	}
+	if len(incompatibilities) == 0 {
+		return nil
+	}
+
+	if !g.compatibilityWarnings {
+		return &SchemaCompatibilityError{Incompatibilities: incompatibilities}
+	}
+
+	for _, incompatibility := range incompatibilities {
+		log.WithFields(LoggerFields{
+			"service": incompatibility.Service,
+			"type":    incompatibility.Type,
+		}).Warn("Service cannot resolve the queries sent by the gateway: ", incompatibility.Reason)
+	}
+
+	return nil
+}
+
+// schemaIncompatibilities returns the problems with the queries that the gateway could send to each service
+func schemaIncompatibilities(sources []*graphql.RemoteSchema, locations FieldURLMap, entityKeys EntityKeyMap, idFields IDFieldMap) []*SchemaIncompatibility {
+	schemas := map[string]*ast.Schema{}
+	// the services that can return objects of each type, and so could have to look up the rest of the object elsewhere
+	parents := map[string]Set{}
+	for _, source := range sources {
+		schemas[source.URL] = source.Schema
+
+		for _, definition := range source.Schema.Types {
+			for _, field := range definition.Fields {
+				// the node field of a service is how the gateway looks up objects, it doesn't find new ones
+				if definition == source.Schema.Query && field.Name == "node" {
+					continue
+				}
+
+				returned := field.Type.Name()
+				for _, possibleType := range append([]*ast.Definition{source.Schema.Types[returned]}, source.Schema.PossibleTypes[returned]...) {
+					if possibleType == nil || possibleType.Kind != ast.Object || strings.HasPrefix(possibleType.Name, "__") {
+						continue
+					}
+					if parents[possibleType.Name] == nil {
+						parents[possibleType.Name] = Set{}
+					}
+					parents[possibleType.Name].Add(source.URL)
+				}
+			}
+		}
+	}
+
+	// look at the types in a consistent order so the report doesn't change between runs
+	typeNames := []string{}
+	for typeName := range parents {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	incompatibilities := []*SchemaIncompatibility{}
+	for _, typeName := range typeNames {
+		// a service is looked up if it has a field that a parent doesn't
+		lookups := Set{}
+		targets := Set{}
+		for _, source := range sources {
+			definition := source.Schema.Types[typeName]
+			if definition == nil || isRootType(source.Schema, definition) {
+				continue
+			}
+
+			for _, field := range definition.Fields {
+				urls, err := locations.URLFor(typeName, field.Name)
+				if err != nil {
+					continue
+				}
+
+				for parent := range parents[typeName] {
+					if !stringsContain(urls, parent) {
+						lookups.Add(parent)
+						targets.Add(source.URL)
+					}
+				}
+			}
+		}
+
+		idField := idFields.FieldFor(typeName)
+		for _, service := range sortedSet(lookups) {
+			definition := schemas[service].Types[typeName]
+
+			// federated services are looked up with _entities which is checked when their schema is loaded
+			if definition == nil || entityKeys.KeyFor(service, typeName) != "" {
+				continue
+			}
+
+			// the parents have to send the id of the object to the other services
+			if definition.Fields.ForName(idField) == nil {
+				incompatibilities = append(incompatibilities, &SchemaIncompatibility{
+					Service: service,
+					Type:    typeName,
+					Reason:  fmt.Sprintf("does not have the %s field used to look it up in the other services", idField),
+				})
+			}
+		}
+
+		for _, service := range sortedSet(targets) {
+			if entityKeys.KeyFor(service, typeName) != "" {
+				continue
+			}
+
+			// and the services that are looked up need to be able to find the object
+			if reason := nodeIncompatibility(schemas[service], schemas[service].Types[typeName]); reason != "" {
+				incompatibilities = append(incompatibilities, &SchemaIncompatibility{
+					Service: service,
+					Type:    typeName,
+					Reason:  reason,
+				})
+			}
+		}
+	}
+
+	return incompatibilities
+}
+
+// nodeIncompatibility returns why the type can't be looked up with the node field of the schema, if it can't
+func nodeIncompatibility(schema *ast.Schema, definition *ast.Definition) string {
+	var node *ast.FieldDefinition
+	if schema.Query != nil {
+		node = schema.Query.Fields.ForName("node")
+	}
+	if node == nil {
+		return "cannot be looked up: the service does not have a node field"
+	}
+	if node.Arguments.ForName("id") == nil {
+		return "cannot be looked up: the node field does not take an id"
+	}
+
+	nodeType := node.Type.Name()
+	if nodeType == definition.Name {
+		return ""
+	}
+	for _, possibleType := range schema.PossibleTypes[nodeType] {
+		if possibleType.Name == definition.Name {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("cannot be looked up: it is not a possible type of %s, the type of the node field", nodeType)
+}
+
+// isRootType returns true if the definition is one of the root types of the schema
+func isRootType(schema *ast.Schema, definition *ast.Definition) bool {
+	return definition == schema.Query || definition == schema.Mutation || definition == schema.Subscription
+}
+
+// sortedSet returns the entries of the set in alphabetical order
+func sortedSet(set Set) []string {
+	entries := []string{}
+	for entry := range set {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+
+	return entries
+}
@@ -0,0 +1,173 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_schemaCompatibility(t *testing.T) {
+	// the users service owns User and the reviews service adds to it
+	usersSchema := `
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			allUsers: [User!]!
+		}
+	`
+
+	testCases := []struct {
+		Message           string
+		Users             string
+		Reviews           string
+		Options           []Option
+		Incompatibilities []*SchemaIncompatibility
+	}{
+		{
+			Message: "Compatible services",
+			Users:   usersSchema,
+			Reviews: `
+				interface Node {
+					id: ID!
+				}
+
+				type User implements Node {
+					id: ID!
+					rating: Int!
+				}
+
+				type Query {
+					node(id: ID!): Node
+				}
+			`,
+		},
+		{
+			Message: "Missing node field",
+			Users:   usersSchema,
+			Reviews: `
+				type User {
+					id: ID!
+					rating: Int!
+				}
+
+				type Query {
+					topRating: Int!
+				}
+			`,
+			Incompatibilities: []*SchemaIncompatibility{
+				{Service: "reviews", Type: "User", Reason: "cannot be looked up: the service does not have a node field"},
+			},
+		},
+		{
+			Message: "Node can't return the type",
+			Users:   usersSchema,
+			Reviews: `
+				interface Node {
+					id: ID!
+				}
+
+				type Review implements Node {
+					id: ID!
+				}
+
+				type User {
+					id: ID!
+					rating: Int!
+				}
+
+				type Query {
+					node(id: ID!): Node
+				}
+			`,
+			Incompatibilities: []*SchemaIncompatibility{
+				{Service: "reviews", Type: "User", Reason: "cannot be looked up: it is not a possible type of Node, the type of the node field"},
+			},
+		},
+		{
+			Message: "Parent without the id",
+			Users: `
+				type User {
+					name: String!
+				}
+
+				type Query {
+					allUsers: [User!]!
+				}
+			`,
+			Reviews: `
+				interface Node {
+					id: ID!
+				}
+
+				type User implements Node {
+					id: ID!
+					rating: Int!
+				}
+
+				type Query {
+					node(id: ID!): Node
+				}
+			`,
+			Incompatibilities: []*SchemaIncompatibility{
+				{Service: "users", Type: "User", Reason: "does not have the id field used to look it up in the other services"},
+			},
+		},
+		{
+			Message: "Mocked service",
+			Users:   usersSchema,
+			Reviews: `
+				type User {
+					id: ID!
+					rating: Int!
+				}
+
+				type Query {
+					topRating: Int!
+				}
+			`,
+			Options: []Option{WithMockedService("reviews", MockOptions{})},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Message, func(t *testing.T) {
+			// merging the schemas modifies them so every gateway needs its own copy
+			sources := func() []*graphql.RemoteSchema {
+				users, err := graphql.LoadSchema(testCase.Users)
+				if !assert.Nil(t, err) {
+					t.FailNow()
+				}
+				reviews, err := graphql.LoadSchema(testCase.Reviews)
+				if !assert.Nil(t, err) {
+					t.FailNow()
+				}
+				return []*graphql.RemoteSchema{{URL: "users", Schema: users}, {URL: "reviews", Schema: reviews}}
+			}
+
+			_, err := New(sources(), testCase.Options...)
+			if len(testCase.Incompatibilities) == 0 {
+				assert.Nil(t, err)
+				return
+			}
+
+			compatibilityErr, ok := err.(*SchemaCompatibilityError)
+			if !assert.True(t, ok, "unexpected error: %v", err) {
+				return
+			}
+			assert.Equal(t, testCase.Incompatibilities, compatibilityErr.Incompatibilities)
+
+			// the gateway can be told to start anyway
+			_, err = New(sources(), append(testCase.Options, WithSchemaCompatibilityWarnings())...)
+			assert.Nil(t, err)
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Before a limit on the cost of operations can be turned on, someone has to know what the operations that are
+// sent today cost. The complexity of an operation is the number of fields it selects, weighted by an estimator
+// and multiplied by the size of the lists they are in. The size of a list comes from its pagination argument
+// (first, last, limit, pageSize, or any argument with a pagination limit) when it has one. The cost of the plan (the number of steps and the requests
+// we expect them to send) comes along with it so the report has everything the rate limiter sees.
+//
+// A sink gets the cost of every operation. A limit rejects the operations that cost too much. Both of them use
+// the same computation so a limit picked from the numbers in the reports rejects exactly the operations that
+// went over it.
+
+// the arguments that set the size of the page a list field returns
+var paginationArguments = []string{"first", "last", "limit", "pageSize"}
+
+// OperationCost describes how expensive an operation is to resolve
+type OperationCost struct {
+	// the name of the operation
+	OperationName string
+	// the identity of the client that sent the operation
+	Client string
+	// the fields selected by the operation, weighted by the estimator and multiplied by the size of the
+	// lists they are in
+	Complexity int
+	// the cost of the plan, as seen by the rate limiter
+	Plan QueryCost
+}
+
+// FieldCostEstimator returns the cost of resolving one instance of the field on the type
+type FieldCostEstimator func(typeName string, field *ast.FieldDefinition) int
+
+// ComplexitySink receives the cost of every operation the gateway executes
+type ComplexitySink func(ctx context.Context, cost OperationCost)
+
+// WithComplexityReporting returns an Option that sends the cost of every operation to the sink. Nothing is
+// rejected unless a limit is set with WithComplexityLimit too.
+func WithComplexityReporting(sink ComplexitySink) Option {
+	return func(g *Gateway) {
+		g.complexitySink = sink
+	}
+}
+
+// WithComplexityLimit returns an Option that rejects the operations whose complexity is over the limit
+func WithComplexityLimit(limit int) Option {
+	return func(g *Gateway) {
+		g.complexityLimit = limit
+	}
+}
+
+// WithComplexityEstimator returns an Option that weighs the fields of an operation with the estimator. Every
+// field costs 1 without one.
+func WithComplexityEstimator(estimator FieldCostEstimator) Option {
+	return func(g *Gateway) {
+		g.complexityEstimator = estimator
+	}
+}
+
+// WithComplexityClientKey returns an Option that identifies the client of each operation in the reports with
+// the designated function, ie with a value set by a middleware that looks at an api key header
+func WithComplexityClientKey(key func(ctx context.Context) string) Option {
+	return func(g *Gateway) {
+		g.complexityClientKey = key
+	}
+}
+
+// checkComplexity reports the cost of the plan and returns an error if it goes over the limit
+func (g *Gateway) checkComplexity(ctx context.Context, operationName string, plan *QueryPlan, variables map[string]interface{}) error {
+	if g.complexitySink == nil && g.complexityLimit <= 0 {
+		return nil
+	}
+
+	cost := g.operationCost(ctx, operationName, plan, variables)
+	if g.complexitySink != nil {
+		g.complexitySink(ctx, cost)
+	}
+
+	if g.complexityLimit <= 0 || cost.Complexity <= g.complexityLimit {
+		return nil
+	}
+
+	err := graphql.NewError("COMPLEXITY_LIMIT_EXCEEDED", fmt.Sprintf("operation has a complexity of %d, which is more than the limit of %d", cost.Complexity, g.complexityLimit))
+	err.Extensions["complexity"] = cost.Complexity
+	err.Extensions["limit"] = g.complexityLimit
+	return graphql.ErrorList{err}
+}
+
+// operationCost computes the cost of the plan when it is executed with the variables
+func (g *Gateway) operationCost(ctx context.Context, operationName string, plan *QueryPlan, variables map[string]interface{}) OperationCost {
+	cost := OperationCost{
+		OperationName: operationName,
+		Plan:          queryCost(plan),
+	}
+	if g.complexityClientKey != nil {
+		cost.Client = g.complexityClientKey(ctx)
+	}
+
+	if plan.Operation != nil {
+		// the arguments that have pagination limits set the size of a page too
+		arguments := append([]string{}, paginationArguments...)
+		for _, name := range sortedLimitNames(g.paginationLimits) {
+			if !stringsContain(arguments, name) {
+				arguments = append(arguments, name)
+			}
+		}
+
+		estimator := &complexityEstimator{
+			estimate:  g.complexityEstimator,
+			fragments: plan.FragmentDefinitions,
+			variables: variables,
+			arguments: arguments,
+		}
+		cost.Complexity = estimator.selectionSet(plan.Operation.SelectionSet, 1)
+	}
+
+	return cost
+}
+
+// complexityEstimator adds up the cost of the fields in an operation
+type complexityEstimator struct {
+	estimate  FieldCostEstimator
+	fragments ast.FragmentDefinitionList
+	variables map[string]interface{}
+	// the arguments that set the size of a list, in the order they are looked for
+	arguments []string
+}
+
+// selectionSet returns the cost of the selection set when it is resolved for the designated number of objects
+func (e *complexityEstimator) selectionSet(selectionSet ast.SelectionSet, multiplier int) int {
+	cost := 0
+	for _, field := range graphql.SelectedFields(collectSelectedFields(selectionSet, e.fragments)) {
+		cost += multiplier * e.field(field)
+
+		// the fields inside of a list are resolved for every entry
+		childMultiplier := multiplier
+		if field.Definition != nil && field.Definition.Type.Elem != nil {
+			childMultiplier *= e.listSize(field)
+		}
+		cost += e.selectionSet(field.SelectionSet, childMultiplier)
+	}
+
+	return cost
+}
+
+// field returns the cost of resolving the field once
+func (e *complexityEstimator) field(field *ast.Field) int {
+	if e.estimate == nil || field.Definition == nil || field.ObjectDefinition == nil {
+		return 1
+	}
+
+	return e.estimate(field.ObjectDefinition.Name, field.Definition)
+}
+
+// listSize returns the number of entries we expect the list field to have
+func (e *complexityEstimator) listSize(field *ast.Field) int {
+	arguments := field.ArgumentMap(e.variables)
+	for _, name := range e.arguments {
+		if size, ok := variableNumber(arguments[name]); ok && size >= 0 {
+			return int(size)
+		}
+	}
+
+	return EstimatedListSize
+}
@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// the context key that the tests use to identify the client
+type complexityClientKey struct{}
+
+func TestGateway_complexity(t *testing.T) {
+	// gateway returns a gateway with the designated options that reports every cost to the list
+	gateway := func(costs *[]OperationCost, options ...Option) *Gateway {
+		usersSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type User implements Node {
+				id: ID!
+				name: String!
+				friends(first: Int): [User!]!
+			}
+
+			type Query {
+				users(first: Int): [User!]!
+				node(id: ID!): Node
+			}
+		`)
+		avatarsSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type User implements Node {
+				id: ID!
+				avatar: String!
+			}
+
+			type Query {
+				node(id: ID!): Node
+			}
+		`)
+
+		factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+			return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+				return map[string]interface{}{}, nil
+			})
+		})
+
+		options = append(options,
+			WithQueryerFactory(&factory),
+			WithComplexityClientKey(func(ctx context.Context) string {
+				client, _ := ctx.Value(complexityClientKey{}).(string)
+				return client
+			}),
+			WithComplexityEstimator(func(typeName string, field *ast.FieldDefinition) int {
+				// the avatars are expensive to look up
+				if field.Name == "avatar" {
+					return 5
+				}
+				return 1
+			}),
+			WithComplexityReporting(func(ctx context.Context, cost OperationCost) {
+				*costs = append(*costs, cost)
+			}),
+		)
+
+		gateway, err := New([]*graphql.RemoteSchema{
+			{Schema: usersSchema, URL: "users"},
+			{Schema: avatarsSchema, URL: "avatars"},
+		}, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gateway
+	}
+
+	// execute sends the query to the gateway
+	execute := func(gateway *Gateway, variables map[string]interface{}) error {
+		ctx := &RequestContext{
+			Context:       context.WithValue(context.Background(), complexityClientKey{}, "ios"),
+			OperationName: "Friends",
+			Query: `
+				query Friends($friends: Int) {
+					users(first: 3) {
+						name
+						friends(first: $friends) {
+							name
+							avatar
+						}
+					}
+				}
+			`,
+			Variables: variables,
+		}
+		plans, err := gateway.GetPlans(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = gateway.Execute(ctx, plans)
+		return err
+	}
+
+	// users costs 1, name costs 1 for each of the 3 users, friends costs 1 for each user and each of their 2 friends
+	// costs 1 for the name and 5 for the avatar
+	expected := OperationCost{
+		OperationName: "Friends",
+		Client:        "ios",
+		Complexity:    1 + 3*(1+1) + 3*2*(1+5),
+		Plan: QueryCost{
+			Steps:           2,
+			ServiceRequests: map[string]int{"users": 1, "avatars": 1},
+			ListExpansion:   99,
+		},
+	}
+
+	// only reporting the cost doesn't get in the way of anything
+	reported := []OperationCost{}
+	assert.Nil(t, execute(gateway(&reported), map[string]interface{}{"friends": 2}))
+
+	// and the limit sees the same cost
+	enforced := []OperationCost{}
+	assert.Nil(t, execute(gateway(&enforced, WithComplexityLimit(expected.Complexity)), map[string]interface{}{"friends": 2}))
+
+	assert.Equal(t, []OperationCost{expected}, reported)
+	assert.Equal(t, reported, enforced)
+
+	// an operation over the limit is rejected, once its cost has been reported
+	enforced = []OperationCost{}
+	err := execute(gateway(&enforced, WithComplexityLimit(expected.Complexity)), map[string]interface{}{"friends": 3})
+	if !assert.NotNil(t, err) {
+		return
+	}
+	assert.Equal(t, "COMPLEXITY_LIMIT_EXCEEDED", err.(graphql.ErrorList)[0].(*graphql.Error).Extensions["code"])
+	if assert.Len(t, enforced, 1) {
+		assert.Equal(t, 1+3*(1+1)+3*3*(1+5), enforced[0].Complexity)
+	}
+
+	// a list without a page size is assumed to have the usual number of entries
+	reported = []OperationCost{}
+	assert.Nil(t, execute(gateway(&reported), nil))
+	if assert.Len(t, reported, 1) {
+		assert.Equal(t, 1+3*(1+1)+3*EstimatedListSize*(1+5), reported[0].Complexity)
+	}
+}
@@ -0,0 +1,245 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A page on another origin can only read the gateway's responses if the gateway says it can. With CORS, the
+// handlers answer the preflight requests that browsers send before a cross-origin request and add the headers
+// that let the page read the response:
+//
+//	gateway.New(sources, gateway.WithCORS(gateway.CORSOptions{
+//		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.com"},
+//		AllowCredentials: true,
+//		MaxAge:           10 * time.Minute,
+//	}))
+//
+// An origin matches if it's in the list, if the list has "*", or if it matches a pattern with a single * in it.
+// Browsers refuse a response that allows every origin ("*") and credentials at the same time, so the origin of
+// the request is sent back instead in that case. Every response varies by origin so caches keep them apart. The
+// request headers of a preflight are only allowed if they're in AllowedHeaders.
+//
+// A browser doesn't ask before it sends a simple request (a POST with a form or text/plain body), it just won't
+// let the page read the response. With Strict, those requests are refused before they're executed when they come
+// from another origin, and so is every request from an origin that isn't allowed.
+
+// DefaultCORSHeaders are the request headers that are allowed unless CORSOptions says otherwise
+var DefaultCORSHeaders = []string{"Accept", "Authorization", "Content-Type", "X-Requested-With", "Apollo-Require-Preflight", "X-Apollo-Operation-Name"}
+
+// the methods the handlers accept from another origin
+const corsAllowedMethods = "GET, HEAD, POST"
+
+// corsMethods are the methods in corsAllowedMethods, one by one
+var corsMethods = strings.Split(corsAllowedMethods, ", ")
+
+// CORSOptions configures the requests the handlers accept from other origins
+type CORSOptions struct {
+	// the origins that can read the responses. "*" allows every origin and a single * in an origin matches
+	// anything (ie, https://*.example.com)
+	AllowedOrigins []string
+	// the headers that the requests can be sent with. "*" allows any header. Defaults to DefaultCORSHeaders.
+	AllowedHeaders []string
+	// true if the requests can be sent with cookies
+	AllowCredentials bool
+	// how long the browser can remember the answer to a preflight. Zero leaves it up to the browser.
+	MaxAge time.Duration
+	// refuse the requests from origins that aren't allowed and the cross-origin requests that were sent
+	// without a preflight
+	Strict bool
+}
+
+// WithCORS returns an Option that makes the handlers answer cross-origin requests
+func WithCORS(opts CORSOptions) Option {
+	return func(g *Gateway) {
+		if len(opts.AllowedHeaders) == 0 {
+			opts.AllowedHeaders = DefaultCORSHeaders
+		}
+		g.cors = &opts
+	}
+}
+
+// CORS wraps a handler that isn't built by the gateway (ie, SchemaSDLHandler) so it answers cross-origin
+// requests like the gateway's handlers do
+func (g *Gateway) CORS(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.serveCORS(w, r) {
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// errCORSOrigin is returned for a request from an origin that isn't allowed
+var errCORSOrigin = errors.New("requests from this origin are not allowed")
+
+// errCORSPreflight is returned for a cross-origin request that was sent without a preflight
+var errCORSPreflight = errors.New("cross-origin requests must be sent with a JSON body or with a header that requires a preflight")
+
+// serveCORS adds the CORS headers to the response. It returns true if the request has been answered.
+func (g *Gateway) serveCORS(w http.ResponseWriter, r *http.Request) bool {
+	if g.cors == nil {
+		return false
+	}
+
+	// the answer depends on who's asking
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	allowed := g.cors.allowsOrigin(origin)
+
+	// a preflight only needs the headers
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+		if !allowed || !stringsContain(corsMethods, method) {
+			w.WriteHeader(http.StatusForbidden)
+			return true
+		}
+
+		g.cors.allowOrigin(w, origin)
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+		if headers := g.cors.allowedHeaders(r.Header.Get("Access-Control-Request-Headers")); len(headers) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		}
+		if g.cors.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(g.cors.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	}
+
+	if allowed {
+		g.cors.allowOrigin(w, origin)
+	}
+
+	if !g.cors.Strict || !corsCrossOrigin(r, origin) {
+		return false
+	}
+
+	// a strict gateway doesn't execute what the browser won't let the page read
+	var err error
+	if !allowed {
+		err = errCORSOrigin
+	} else if r.Method == http.MethodPost && corsSimpleContentType(r.Header.Get("Content-Type")) && !g.cors.preflighted(r) {
+		err = errCORSPreflight
+	}
+	if err == nil {
+		return false
+	}
+
+	response, _ := json.Marshal(g.errorResponse(r.Context(), nil, err, "FORBIDDEN"))
+	emitResponse(w, http.StatusForbidden, string(response))
+	return true
+}
+
+// allowsOrigin returns true if the origin can read the responses
+func (c *CORSOptions) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		// a pattern matches anything in place of its *
+		parts := strings.SplitN(strings.ToLower(allowed), "*", 2)
+		lower := strings.ToLower(origin)
+		if len(parts) == 2 && len(lower) > len(parts[0])+len(parts[1]) && strings.HasPrefix(lower, parts[0]) && strings.HasSuffix(lower, parts[1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowOrigin adds the headers that let the origin read the response
+func (c *CORSOptions) allowOrigin(w http.ResponseWriter, origin string) {
+	// browsers refuse credentials for every origin so we have to name the one that's asking
+	if !c.AllowCredentials && stringsContain(c.AllowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if c.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// allowedHeaders returns the headers of a preflight that the request can be sent with
+func (c *CORSOptions) allowedHeaders(requested string) []string {
+	headers := []string{}
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+
+		for _, allowed := range c.AllowedHeaders {
+			if allowed == "*" || strings.EqualFold(allowed, header) {
+				headers = append(headers, header)
+				break
+			}
+		}
+	}
+
+	return headers
+}
+
+// preflighted returns true if the request has a header that a browser only sends after a preflight
+func (c *CORSOptions) preflighted(r *http.Request) bool {
+	headers := c.AllowedHeaders
+	if stringsContain(headers, "*") {
+		headers = DefaultCORSHeaders
+	}
+
+	for _, header := range headers {
+		if corsSafelistedHeader(header) {
+			continue
+		}
+		if r.Header.Get(header) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// corsSafelistedHeader returns true if a browser can send the header without a preflight
+func corsSafelistedHeader(header string) bool {
+	switch strings.ToLower(header) {
+	case "accept", "accept-language", "content-language", "content-type":
+		return true
+	}
+
+	return false
+}
+
+// corsCrossOrigin returns true if the request was sent by a page on another origin
+func corsCrossOrigin(r *http.Request, origin string) bool {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return !strings.EqualFold(origin, scheme+"://"+r.Host)
+}
+
+// corsSimpleContentType returns true if a browser can send a body of the content type without a preflight
+func corsSimpleContentType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	switch mediaType {
+	case "", "application/x-www-form-urlencoded", "multipart/form-data", "text/plain":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,196 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLHandler_cors(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			allUsers: [String!]!
+		}
+	`)
+
+	// gateway returns a gateway with the designated CORS options that counts the operations it executes
+	executions := 0
+	gateway := func(opts CORSOptions) *Gateway {
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+			WithCORS(opts),
+			WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+				executions++
+				return map[string]interface{}{"allUsers": []interface{}{}}, nil
+			})),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gateway
+	}
+
+	// preflight sends the request a browser sends before a POST with the designated headers
+	preflight := func(gateway *Gateway, origin string, headers string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodOptions, "http://gateway.example.com/graphql", nil)
+		request.Header.Set("Origin", origin)
+		request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		if headers != "" {
+			request.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		response := httptest.NewRecorder()
+		gateway.GraphQLHandler(response, request)
+		return response
+	}
+
+	// post sends a query from the origin with the designated content type
+	post := func(gateway *Gateway, origin string, contentType string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "http://gateway.example.com/graphql", strings.NewReader(`{"query": "{ allUsers }"}`))
+		request.Header.Set("Content-Type", contentType)
+		if origin != "" {
+			request.Header.Set("Origin", origin)
+		}
+		response := httptest.NewRecorder()
+		gateway.GraphQLHandler(response, request)
+		return response
+	}
+
+	t.Run("Allowed methods", func(t *testing.T) {
+		gw := gateway(CORSOptions{AllowedOrigins: []string{"*"}})
+
+		// the method has to be one of them, not a piece of the list
+		for method, status := range map[string]int{"GET": http.StatusNoContent, "PUT": http.StatusForbidden, "GE": http.StatusForbidden, "HEAD, POST": http.StatusForbidden} {
+			request := httptest.NewRequest(http.MethodOptions, "http://gateway.example.com/graphql", nil)
+			request.Header.Set("Origin", "https://app.example.com")
+			request.Header.Set("Access-Control-Request-Method", method)
+			response := httptest.NewRecorder()
+			gw.GraphQLHandler(response, request)
+			assert.Equal(t, status, response.Code, method)
+		}
+	})
+
+	t.Run("Allowed origin", func(t *testing.T) {
+		gw := gateway(CORSOptions{AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"}})
+
+		for _, origin := range []string{"https://app.example.com", "https://admin.example.org"} {
+			response := preflight(gw, origin, "Content-Type, X-Not-Allowed, authorization")
+			assert.Equal(t, http.StatusNoContent, response.Code, origin)
+			assert.Equal(t, origin, response.Header().Get("Access-Control-Allow-Origin"), origin)
+			assert.Equal(t, "GET, HEAD, POST", response.Header().Get("Access-Control-Allow-Methods"))
+			// only the headers that are allowed are sent back
+			assert.Equal(t, "Content-Type, authorization", response.Header().Get("Access-Control-Allow-Headers"))
+			assert.Contains(t, response.Header()["Vary"], "Origin")
+			assert.Equal(t, "", response.Header().Get("Access-Control-Allow-Credentials"))
+
+			response = post(gw, origin, "application/json")
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, origin, response.Header().Get("Access-Control-Allow-Origin"))
+			assert.Contains(t, response.Header()["Vary"], "Origin")
+		}
+	})
+
+	t.Run("Disallowed origin", func(t *testing.T) {
+		gw := gateway(CORSOptions{AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"}})
+
+		for _, origin := range []string{"https://evil.com", "https://example.org", "https://app.example.com.evil.com"} {
+			response := preflight(gw, origin, "Content-Type")
+			assert.Equal(t, http.StatusForbidden, response.Code, origin)
+			assert.Equal(t, "", response.Header().Get("Access-Control-Allow-Origin"), origin)
+
+			// the browser won't let the page read the response
+			response = post(gw, origin, "application/json")
+			assert.Equal(t, http.StatusOK, response.Code, origin)
+			assert.Equal(t, "", response.Header().Get("Access-Control-Allow-Origin"), origin)
+		}
+	})
+
+	t.Run("Credentials with every origin", func(t *testing.T) {
+		// without credentials every origin gets the wildcard
+		response := preflight(gateway(CORSOptions{AllowedOrigins: []string{"*"}}), "https://app.example.com", "")
+		assert.Equal(t, "*", response.Header().Get("Access-Control-Allow-Origin"))
+
+		// browsers refuse the wildcard with credentials
+		gw := gateway(CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+		response = preflight(gw, "https://app.example.com", "")
+		assert.Equal(t, http.StatusNoContent, response.Code)
+		assert.Equal(t, "https://app.example.com", response.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", response.Header().Get("Access-Control-Allow-Credentials"))
+
+		response = post(gw, "https://other.example.com", "application/json")
+		assert.Equal(t, "https://other.example.com", response.Header().Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, response.Header()["Vary"], "Origin")
+	})
+
+	t.Run("Preflight caching", func(t *testing.T) {
+		gw := gateway(CORSOptions{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}, MaxAge: 10 * time.Minute})
+
+		response := preflight(gw, "https://app.example.com", "X-Anything")
+		assert.Equal(t, "600", response.Header().Get("Access-Control-Max-Age"))
+		assert.Equal(t, "X-Anything", response.Header().Get("Access-Control-Allow-Headers"))
+		assert.ElementsMatch(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, response.Header()["Vary"])
+
+		// without a max age the browser decides
+		response = preflight(gateway(CORSOptions{AllowedOrigins: []string{"*"}}), "https://app.example.com", "")
+		assert.Equal(t, "", response.Header().Get("Access-Control-Max-Age"))
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		gw := gateway(CORSOptions{AllowedOrigins: []string{"https://app.example.com"}, Strict: true})
+
+		executions = 0
+		// a text body from an allowed origin didn't go through a preflight
+		assert.Equal(t, http.StatusForbidden, post(gw, "https://app.example.com", "text/plain").Code)
+		// and nothing goes from an origin that isn't allowed
+		assert.Equal(t, http.StatusForbidden, post(gw, "https://evil.com", "application/json").Code)
+		assert.Equal(t, 0, executions)
+
+		// a JSON body needed a preflight
+		assert.Equal(t, http.StatusOK, post(gw, "https://app.example.com", "application/json").Code)
+		// and the gateway's own pages aren't cross-origin
+		assert.Equal(t, http.StatusOK, post(gw, "http://gateway.example.com", "text/plain").Code)
+		assert.Equal(t, http.StatusOK, post(gw, "", "text/plain").Code)
+		assert.Equal(t, 3, executions)
+	})
+}
+
+func TestGraphQLHandler_headAndOptions(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			allUsers: [String!]!
+		}
+	`)
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"allUsers": []interface{}{}}, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// a HEAD gets the headers of the GET without its body
+	response := httptest.NewRecorder()
+	gateway.GraphQLHandler(response, httptest.NewRequest(http.MethodHead, "/graphql?query={allUsers}", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+	assert.Equal(t, "", response.Body.String())
+
+	// an OPTIONS that isn't a preflight gets the methods
+	response = httptest.NewRecorder()
+	gateway.GraphQLHandler(response, httptest.NewRequest(http.MethodOptions, "/graphql", nil))
+	assert.Equal(t, http.StatusNoContent, response.Code)
+	assert.Equal(t, "GET, HEAD, POST, OPTIONS", response.Header().Get("Allow"))
+
+	// of the handler that was asked
+	response = httptest.NewRecorder()
+	gateway.Handler(HandlerAllowedMethods(http.MethodPost)).ServeHTTP(response, httptest.NewRequest(http.MethodOptions, "/graphql", nil))
+	assert.Equal(t, "POST, OPTIONS", response.Header().Get("Allow"))
+
+	response = httptest.NewRecorder()
+	gateway.Handler(HandlerAllowedMethods(http.MethodPost)).ServeHTTP(response, httptest.NewRequest(http.MethodHead, "/graphql", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+}
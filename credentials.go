@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/nautilus/graphql"
+)
+
+// CredentialProvider adds the credentials that a service expects to the requests the gateway sends it.
+// The provider is given the outgoing request along with the url of the service it targets.
+type CredentialProvider interface {
+	Authenticate(r *http.Request, url string) error
+}
+
+// CredentialProviderFunc wraps a function to be used as a CredentialProvider
+type CredentialProviderFunc func(r *http.Request, url string) error
+
+// Authenticate invokes the internal function
+func (f CredentialProviderFunc) Authenticate(r *http.Request, url string) error {
+	return f(r, url)
+}
+
+// ClientCredentialProvider is a CredentialProvider whose requests have to be sent with a particular
+// http.Client, ie one that presents a client certificate
+type ClientCredentialProvider interface {
+	CredentialProvider
+	HTTPClient() *http.Client
+}
+
+// WithServiceCredentials returns an Option that authenticates every request sent to the service at the
+// designated url with the provider, including the ones used to introspect it when the gateway is created
+func WithServiceCredentials(url string, provider CredentialProvider) Option {
+	return func(g *Gateway) {
+		if g.credentials == nil {
+			g.credentials = map[string]CredentialProvider{}
+		}
+		g.credentials[url] = provider
+	}
+}
+
+// IntrospectWithCredentials returns the options to pass to IntrospectRemoteSchema so that the introspection
+// query is authenticated the same way as the requests the gateway sends the service
+func IntrospectWithCredentials(url string, provider CredentialProvider) []*graphql.IntrospectOptions {
+	opts := []*graphql.IntrospectOptions{
+		graphql.IntrospectWithMiddlewares(credentialMiddleware(url, provider)),
+	}
+	if clientProvider, ok := provider.(ClientCredentialProvider); ok {
+		opts = append(opts, graphql.IntrospectWithHTTPClient(clientProvider.HTTPClient()))
+	}
+
+	return opts
+}
+
+// credentialMiddleware turns the provider into a middleware for the requests sent to url
+func credentialMiddleware(url string, provider CredentialProvider) graphql.NetworkMiddleware {
+	return func(r *http.Request) error {
+		return provider.Authenticate(r, url)
+	}
+}
+
+// BearerToken returns a CredentialProvider that sends the token in the Authorization header
+func BearerToken(token string) CredentialProvider {
+	return CredentialProviderFunc(func(r *http.Request, url string) error {
+		r.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// HMACSignature returns a CredentialProvider that signs the body of the request with the key and sends
+// the hex encoded HMAC-SHA256 in the designated header
+func HMACSignature(header string, key []byte) CredentialProvider {
+	return CredentialProviderFunc(func(r *http.Request, url string) error {
+		// read the body without taking it away from the request
+		body := []byte{}
+		if r.GetBody != nil {
+			reader, err := r.GetBody()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			body, err = ioutil.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+		}
+
+		mac := hmac.New(sha256.New, key)
+		mac.Write(body)
+		r.Header.Set(header, hex.EncodeToString(mac.Sum(nil)))
+
+		return nil
+	})
+}
+
+// clientCertificate is a ClientCredentialProvider that authenticates with mutual TLS
+type clientCertificate struct {
+	client *http.Client
+}
+
+// ClientCertificate returns a CredentialProvider that sends requests with the designated tls configuration,
+// which should hold the certificate the service expects
+func ClientCertificate(config *tls.Config) ClientCredentialProvider {
+	return &clientCertificate{
+		client: &http.Client{
+			Transport: &extensionsTransport{
+				base: &http.Transport{
+					Proxy:           http.ProxyFromEnvironment,
+					TLSClientConfig: config,
+				},
+			},
+		},
+	}
+}
+
+// Authenticate doesn't have to do anything since the certificate is presented by the client
+func (c *clientCertificate) Authenticate(r *http.Request, url string) error {
+	return nil
+}
+
+// HTTPClient returns the client that presents the certificate
+func (c *clientCertificate) HTTPClient() *http.Client {
+	return c.client
+}
@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_serviceCredentials(t *testing.T) {
+	key := []byte("secret")
+
+	// service returns a server for the schema that only responds to authenticated requests
+	service := func(typeDefs string, value map[string]interface{}, authenticated func(r *http.Request, body []byte) bool) *httptest.Server {
+		schema, _ := graphql.LoadSchema(typeDefs)
+		introspection, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "service"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if !authenticated(r, body) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			// the gateway knows how to answer the introspection query
+			if strings.Contains(string(body), "__schema") {
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+				introspection.GraphQLHandler(w, r)
+				return
+			}
+
+			response, _ := json.Marshal(map[string]interface{}{"data": value})
+			w.Write(response)
+		}))
+	}
+
+	tokenService := service(`
+		type Query {
+			token: String!
+		}
+	`, map[string]interface{}{"token": "hello"}, func(r *http.Request, body []byte) bool {
+		return r.Header.Get("Authorization") == "Bearer token"
+	})
+	defer tokenService.Close()
+
+	signedService := service(`
+		type Query {
+			signed: String!
+		}
+	`, map[string]interface{}{"signed": "world"}, func(r *http.Request, body []byte) bool {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(body)
+		return r.Header.Get("X-Signature") == hex.EncodeToString(mac.Sum(nil)) && r.Header.Get("Authorization") == ""
+	})
+	defer signedService.Close()
+
+	// without the credentials, the services can't be introspected
+	_, err := New([]*graphql.RemoteSchema{{URL: tokenService.URL}})
+	assert.NotNil(t, err)
+
+	gateway, err := New(
+		[]*graphql.RemoteSchema{{URL: tokenService.URL}, {URL: signedService.URL}},
+		WithServiceCredentials(tokenService.URL, BearerToken("token")),
+		WithServiceCredentials(signedService.URL, HMACSignature("X-Signature", key)),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// each step is sent with the credentials of its service
+	ctx := &RequestContext{
+		Context: context.Background(),
+		Query:   `{ token signed }`,
+	}
+	plans, err := gateway.GetPlans(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	result, err := gateway.Execute(ctx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{"token": "hello", "signed": "world"}, result)
+}
@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// A browser will send a simple request (a GET, or a POST with a form or text/plain body) to any origin without
+// asking first, along with the cookies of that origin. When the gateway is used with cookies, that lets any page
+// perform mutations on behalf of its visitors. With CSRF protection turned on, a mutation has to be sent in a
+// POST that a browser couldn't have sent without a CORS preflight: one with a JSON body or one of the designated
+// headers. CORS itself is configured with WithCORS.
+
+// DefaultCSRFHeaders are the headers that mark a request as safe unless CSRFProtectionOptions says otherwise
+var DefaultCSRFHeaders = []string{"X-Requested-With", "Apollo-Require-Preflight", "X-Apollo-Operation-Name"}
+
+// CSRFProtectionOptions configures the protection against cross site request forgery
+type CSRFProtectionOptions struct {
+	// a mutation sent with any of these headers is allowed, whatever its content type. Defaults to DefaultCSRFHeaders.
+	RequiredHeaders []string
+}
+
+// WithCSRFProtection returns an Option that refuses mutations that could have been sent by a browser
+// without a preflight request
+func WithCSRFProtection(opts CSRFProtectionOptions) Option {
+	return func(g *Gateway) {
+		if len(opts.RequiredHeaders) == 0 {
+			opts.RequiredHeaders = DefaultCSRFHeaders
+		}
+		g.csrfProtection = &opts
+	}
+}
+
+// errCSRFViolation is returned for a mutation that a browser could have been tricked into sending
+var errCSRFViolation = errors.New("mutations must be sent with a JSON body or with one of the headers that require a preflight request")
+
+// checkCSRF returns an error if the operation has side effects and the request could have been forged
+func (g *Gateway) checkCSRF(r *http.Request, ctx *RequestContext, plans QueryPlanList) error {
+	if g.csrfProtection == nil || r == nil {
+		return nil
+	}
+
+	// if we can't tell which operation is being performed, the execution will complain
+	plan, err := g.planForOperation(ctx, plans)
+	if err != nil || plan.Operation == nil || plan.Operation.Operation != ast.Mutation {
+		return nil
+	}
+
+	if r.Method != http.MethodPost {
+		return errMutationOverGET
+	}
+
+	// any header that the browser can't set without a preflight will do
+	for _, header := range g.csrfProtection.RequiredHeaders {
+		if r.Header.Get(header) != "" {
+			return nil
+		}
+	}
+
+	// so will a body that a form couldn't have sent
+	contentType := strings.TrimSpace(strings.SplitN(r.Header.Get("Content-Type"), ";", 2)[0])
+	if strings.EqualFold(contentType, "application/json") {
+		return nil
+	}
+
+	return errCSRFViolation
+}
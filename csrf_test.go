@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLHandler_csrfProtection(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			allUsers: [String!]!
+		}
+
+		type Mutation {
+			deleteUsers: Boolean!
+		}
+	`)
+
+	// the number of times an operation was executed
+	executions := 0
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithCSRFProtection(CSRFProtectionOptions{}),
+		WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+			executions++
+			return map[string]interface{}{}, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	table := []struct {
+		Name        string
+		Method      string
+		Query       string
+		ContentType string
+		Headers     map[string]string
+		Status      int
+	}{
+		{"Query over GET", "GET", "{ allUsers }", "", nil, http.StatusOK},
+		{"Query with a simple content type", "POST", "{ allUsers }", "text/plain", nil, http.StatusOK},
+		{"Mutation over GET", "GET", "mutation { deleteUsers }", "", nil, http.StatusForbidden},
+		{"Mutation over GET with the header", "GET", "mutation { deleteUsers }", "", map[string]string{"X-Requested-With": "XMLHttpRequest"}, http.StatusForbidden},
+		{"Mutation with a JSON body", "POST", "mutation { deleteUsers }", "application/json; charset=utf-8", nil, http.StatusOK},
+		{"Mutation with a simple content type", "POST", "mutation { deleteUsers }", "text/plain", nil, http.StatusForbidden},
+		{"Mutation without a content type", "POST", "mutation { deleteUsers }", "", nil, http.StatusForbidden},
+		{"Mutation with a simple content type and the header", "POST", "mutation { deleteUsers }", "text/plain", map[string]string{"Apollo-Require-Preflight": "true"}, http.StatusOK},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			executions = 0
+
+			var request *http.Request
+			if row.Method == "GET" {
+				request = httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(row.Query), nil)
+			} else {
+				body, _ := json.Marshal(map[string]interface{}{"query": row.Query})
+				request = httptest.NewRequest("POST", "/graphql", strings.NewReader(string(body)))
+			}
+			if row.ContentType != "" {
+				request.Header.Set("Content-Type", row.ContentType)
+			}
+			for key, value := range row.Headers {
+				request.Header.Set(key, value)
+			}
+
+			responseRecorder := httptest.NewRecorder()
+			gateway.GraphQLHandler(responseRecorder, request)
+			response := responseRecorder.Result()
+
+			assert.Equal(t, row.Status, response.StatusCode)
+			if row.Status == http.StatusOK {
+				assert.Equal(t, 1, executions)
+				return
+			}
+
+			// the operation was never executed
+			assert.Equal(t, 0, executions)
+
+			result := map[string]interface{}{}
+			if !assert.Nil(t, json.NewDecoder(response.Body).Decode(&result)) {
+				return
+			}
+			errs, ok := result["errors"].([]interface{})
+			if !assert.True(t, ok) || !assert.Len(t, errs, 1) {
+				return
+			}
+			assert.Equal(t, "FORBIDDEN", errs[0].(map[string]interface{})["extensions"].(map[string]interface{})["code"])
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nautilus/graphql"
+)
+
+// A service that knows how long the gateway is going to wait for it can give up on a query whose answer
+// would show up too late. With deadline propagation, every query sent to a service carries the time that's
+// left before the request's deadline (whichever comes first of the client's context and the partial results
+// deadline) in a header:
+//
+//	gateway.New(sources, gateway.WithDeadlinePropagation("X-Request-Deadline", 10*time.Millisecond))
+//
+// The budget is computed when the step is sent so the steps that wait on others get whatever is left after
+// them. The value is a number of milliseconds, unless the header is grpc-timeout in which case it has the
+// unit that header needs (ie, 250m). A step that would have less than the floor to work with isn't sent at
+// all and fails with a timeout instead. Requests without a deadline don't get the header.
+
+// WithDeadlinePropagation returns an Option that sends the time left before the request's deadline to the
+// services in the designated header, and doesn't send the steps with less than floor left
+func WithDeadlinePropagation(header string, floor time.Duration) Option {
+	return func(g *Gateway) {
+		g.deadlineHeader = header
+		g.deadlineFloor = floor
+	}
+}
+
+// stepDeadline returns the point in time that the step has to finish by, if there is one
+func stepDeadline(ctx *ExecutionContext) (time.Time, bool) {
+	deadline := ctx.Deadline
+	if ctx.RequestContext != nil {
+		if contextDeadline, ok := ctx.RequestContext.Deadline(); ok && (deadline.IsZero() || contextDeadline.Before(deadline)) {
+			deadline = contextDeadline
+		}
+	}
+
+	return deadline, !deadline.IsZero()
+}
+
+// deadlineKey is the key of the deadline that a request to a service has to tell the service about in its context
+type deadlineKey struct{}
+
+// propagatedDeadline is the deadline of a request to a service and the header it goes in
+type propagatedDeadline struct {
+	header   string
+	deadline time.Time
+}
+
+// withStepDeadline returns the context to send the step with. It returns an error if there isn't enough time
+// left to send it.
+func withStepDeadline(ctx context.Context, executionContext *ExecutionContext, step *QueryPlanStep) (context.Context, error) {
+	deadline, ok := stepDeadline(executionContext)
+	if !ok {
+		return ctx, nil
+	}
+
+	remaining := time.Until(deadline)
+	if remaining < executionContext.DeadlineFloor || remaining <= 0 {
+		return nil, graphql.NewError("TIMEOUT", "not enough time left before the deadline to query "+step.Location)
+	}
+
+	return context.WithValue(ctx, deadlineKey{}, propagatedDeadline{header: executionContext.DeadlineHeader, deadline: deadline}), nil
+}
+
+// deadlineMiddleware tells the service how long it has to answer the request. The time left is measured when
+// the request goes out so the time spent waiting to be sent (ie, in a batch) isn't given to the service.
+func deadlineMiddleware(r *http.Request) error {
+	value, ok := r.Context().Value(deadlineKey{}).(propagatedDeadline)
+	if !ok {
+		return nil
+	}
+
+	remaining := time.Until(value.deadline)
+	if remaining <= 0 {
+		return graphql.NewError("TIMEOUT", "the deadline passed before the query could be sent")
+	}
+
+	header := strconv.FormatInt(int64(remaining/time.Millisecond), 10)
+	if strings.EqualFold(value.header, "grpc-timeout") {
+		header += "m"
+	}
+	r.Header.Set(value.header, header)
+
+	return nil
+}
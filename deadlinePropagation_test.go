@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_deadlinePropagation(t *testing.T) {
+	// the budget each service was given, indexed by path
+	budgets := map[string]string{}
+	budgetsMutex := &sync.Mutex{}
+
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budgetsMutex.Lock()
+		budgets[r.URL.Path] = r.Header.Get("X-Request-Deadline")
+		budgetsMutex.Unlock()
+
+		if r.URL.Path == "/stats" {
+			w.Write([]byte(`{"data": {"node": {"age": 3}}}`))
+			return
+		}
+
+		// the users take their time so there's less left for the steps after them
+		time.Sleep(30 * time.Millisecond)
+		w.Write([]byte(`{"data": {"users": [{"id": "1", "name": "alice"}]}}`))
+	}))
+	defer service.Close()
+
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			users: [User!]!
+			node(id: ID!): Node
+		}
+	`)
+	statsSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			age: Int!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	gw, err := New([]*graphql.RemoteSchema{
+		{Schema: usersSchema, URL: service.URL + "/users"},
+		{Schema: statsSchema, URL: service.URL + "/stats"},
+	}, WithDeadlinePropagation("X-Request-Deadline", 40*time.Millisecond))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	execute := func(timeout time.Duration) (map[string]interface{}, error) {
+		requestCtx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			requestCtx, cancel = context.WithTimeout(requestCtx, timeout)
+			defer cancel()
+		}
+
+		ctx := &RequestContext{Context: requestCtx, Query: `{ users { name age } }`}
+		plans, err := gw.GetPlans(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return gw.Execute(ctx, plans)
+	}
+
+	// a request without a deadline doesn't tell the services anything
+	_, err = execute(0)
+	if assert.Nil(t, err) {
+		assert.Equal(t, map[string]string{"/users": "", "/stats": ""}, budgets)
+	}
+
+	// the step that waited on the users has less time to work with
+	_, err = execute(time.Second)
+	if !assert.Nil(t, err) {
+		return
+	}
+	users, err := strconv.Atoi(budgets["/users"])
+	assert.Nil(t, err)
+	stats, err := strconv.Atoi(budgets["/stats"])
+	assert.Nil(t, err)
+	assert.True(t, users <= 1000, users)
+	assert.True(t, stats <= users-30, "%d should be at least 30ms less than %d", stats, users)
+
+	// and a step without enough time left isn't sent at all
+	budgets = map[string]string{}
+	_, err = execute(60 * time.Millisecond)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "deadline")
+	}
+	assert.NotEmpty(t, budgets["/users"])
+	_, sent := budgets["/stats"]
+	assert.False(t, sent)
+}
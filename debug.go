@@ -0,0 +1,452 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Figuring out why a gateway is stuck or growing usually means attaching tools to the process. The debug handler
+// shows what the gateway is doing right now instead: the operations being executed along with how long they have
+// been running and which services they are still waiting for, the sizes and hit rates of the caches, the slowest
+// recent requests with their plans, and links to the profiles of the process (from runtime/pprof). The handler
+// can be mounted wherever the operator wants it:
+//
+//	gw, _ := gateway.New(sources, gateway.WithDebug(gateway.DebugOptions{SlowThreshold: 2 * time.Second}))
+//	internal.Handle("/debug/gateway/", gw.DebugHandler())
+//
+// Nothing is tracked unless the gateway was created with WithDebug, and the handler doesn't answer without it so
+// mounting it by accident doesn't expose anything. The handler shows the queries of the clients and the profiles
+// of the process so it shouldn't be reachable from outside.
+
+// the defaults of the debug options
+const (
+	defaultDebugSlowRequests  = 20
+	defaultDebugSlowThreshold = time.Second
+)
+
+// the profiles that are linked from the report
+var debugProfiles = []string{"goroutine", "heap", "allocs", "block", "mutex", "threadcreate", "profile", "trace"}
+
+// DebugOptions configures what the gateway keeps track of for the debug handler
+type DebugOptions struct {
+	// the number of slow requests that are kept. Defaults to 20.
+	SlowRequests int
+	// how long a request has to take before it's kept as a slow request. Defaults to a second.
+	SlowThreshold time.Duration
+}
+
+// WithDebug returns an Option that keeps track of what the gateway is doing so the debug handler can report it
+func WithDebug(opts DebugOptions) Option {
+	return func(g *Gateway) {
+		if opts.SlowRequests <= 0 {
+			opts.SlowRequests = defaultDebugSlowRequests
+		}
+		if opts.SlowThreshold <= 0 {
+			opts.SlowThreshold = defaultDebugSlowThreshold
+		}
+		g.debug = &debugRegistry{options: opts, inFlight: map[int64]*debugExecution{}}
+	}
+}
+
+// CacheSizer is implemented by the caches that can tell the debug handler how many entries they hold
+type CacheSizer interface {
+	Len() int
+}
+
+// DebugReport is what the debug handler responds with
+type DebugReport struct {
+	// the operations that are being executed, the oldest first
+	InFlight []*DebugExecution `json:"inFlight"`
+	// the caches of the gateway, indexed by name
+	Caches map[string]*DebugCache `json:"caches"`
+	// the last requests that took longer than the threshold, the most recent first
+	SlowRequests []*DebugSlowRequest `json:"slowRequests"`
+	// the links to the profiles of the process, indexed by name
+	Profiles map[string]string `json:"profiles"`
+}
+
+// DebugExecution describes an operation that is being executed
+type DebugExecution struct {
+	ID            int64         `json:"id"`
+	OperationName string        `json:"operationName"`
+	Query         string        `json:"query"`
+	StartedAt     time.Time     `json:"startedAt"`
+	Age           time.Duration `json:"age"`
+	// the number of queries that were sent to the services and haven't come back yet, and the services they
+	// were sent to
+	StepsOutstanding int            `json:"stepsOutstanding"`
+	Services         map[string]int `json:"services"`
+}
+
+// DebugCache describes one of the caches of the gateway
+type DebugCache struct {
+	// the number of entries in the cache, or -1 if the cache can't tell
+	Size    int     `json:"size"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+}
+
+// DebugSlowRequest is a request that took longer than the threshold
+type DebugSlowRequest struct {
+	OperationName string          `json:"operationName"`
+	Query         string          `json:"query"`
+	StartedAt     time.Time       `json:"startedAt"`
+	Duration      time.Duration   `json:"duration"`
+	Error         string          `json:"error,omitempty"`
+	Plan          json.RawMessage `json:"plan,omitempty"`
+}
+
+// DebugHandler returns the handler that reports what the gateway is doing. The profiles are served under the
+// pprof path of wherever the handler is mounted.
+func (g *Gateway) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.debug == nil {
+			http.Error(w, "the debug handler has to be turned on with WithDebug", http.StatusNotFound)
+			return
+		}
+
+		// the profiles are written by the runtime. net/http/pprof isn't used since importing it serves the
+		// profiles on the default mux of every gateway, whether it was debugging or not.
+		if index := strings.Index(r.URL.Path, "/pprof"); index >= 0 {
+			debugServeProfile(w, r, strings.Trim(r.URL.Path[index+len("/pprof"):], "/"))
+			return
+		}
+
+		report := g.debugReport(strings.TrimSuffix(r.URL.Path, "/") + "/pprof/")
+		response, err := json.Marshal(report)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(response)
+	})
+}
+
+// debugServeProfile serves the profile with the given name
+func debugServeProfile(w http.ResponseWriter, r *http.Request, name string) {
+	switch name {
+	case "":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html><body><ul>")
+		for _, profile := range debugProfiles {
+			fmt.Fprintf(w, `<li><a href="%s">%s</a></li>`, debugProfileLink(profile), profile)
+		}
+		fmt.Fprint(w, "</ul></body></html>")
+	case "profile":
+		// only one cpu profile can run at a time
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := pprof.StartCPUProfile(w); err != nil {
+			debugProfileError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		debugSleep(r)
+		pprof.StopCPUProfile()
+	case "trace":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := trace.Start(w); err != nil {
+			debugProfileError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		debugSleep(r)
+		trace.Stop()
+	default:
+		profile := pprof.Lookup(name)
+		if profile == nil {
+			debugProfileError(w, http.StatusNotFound, "unknown profile "+name)
+			return
+		}
+
+		// the profiles can be written as text or in the binary format of the pprof tool
+		debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+		if debug > 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		profile.WriteTo(w, debug)
+	}
+}
+
+// debugSleep waits for the number of seconds the request asked for (5 by default) or until the request is gone
+func debugSleep(r *http.Request) {
+	seconds, err := strconv.ParseFloat(r.URL.Query().Get("seconds"), 64)
+	if err != nil || seconds <= 0 {
+		seconds = 5
+	}
+
+	timer := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
+
+// debugProfileError responds with an error instead of a profile
+func debugProfileError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, message)
+}
+
+// debugProfileLink returns the link to the profile, relative to the pprof path
+func debugProfileLink(profile string) string {
+	switch profile {
+	case "profile", "trace":
+		return profile + "?seconds=5"
+	default:
+		return profile + "?debug=1"
+	}
+}
+
+// debugReport returns what the gateway is doing right now
+func (g *Gateway) debugReport(profilesPath string) *DebugReport {
+	report := &DebugReport{
+		InFlight:     g.debug.executions(),
+		SlowRequests: g.debug.slowRequests(),
+		Caches:       map[string]*DebugCache{},
+		Profiles:     map[string]string{},
+	}
+
+	size := -1
+	if sizer, ok := g.queryPlanCache.(CacheSizer); ok {
+		size = sizer.Len()
+	}
+	report.Caches["queryPlans"] = g.debug.planCache.report(size)
+
+	if g.responseCache != nil {
+		size := -1
+		if sizer, ok := g.responseCache.store.(CacheSizer); ok {
+			size = sizer.Len()
+		}
+		report.Caches["responses"] = g.debug.responseCache.report(size)
+	}
+
+	for _, profile := range debugProfiles {
+		report.Profiles[profile] = profilesPath + debugProfileLink(profile)
+	}
+
+	return report
+}
+
+// debugRegistry keeps track of the operations being executed and the ones that were slow. Every method can be
+// called on a nil registry so the gateway doesn't have to check if it's debugging.
+type debugRegistry struct {
+	options DebugOptions
+
+	mutex    sync.Mutex
+	nextID   int64
+	inFlight map[int64]*debugExecution
+	slow     []*DebugSlowRequest
+
+	planCache     debugCacheCounter
+	responseCache debugCacheCounter
+}
+
+// debugExecution is an operation that is being executed
+type debugExecution struct {
+	id            int64
+	operationName string
+	query         string
+	plan          *QueryPlan
+	start         time.Time
+
+	mutex       sync.Mutex
+	outstanding map[string]int
+}
+
+// start registers an operation that's about to be executed
+func (d *debugRegistry) start(operationName string, query string, plan *QueryPlan) *debugExecution {
+	if d == nil {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.nextID++
+	execution := &debugExecution{
+		id:            d.nextID,
+		operationName: operationName,
+		query:         query,
+		plan:          plan,
+		start:         time.Now(),
+		outstanding:   map[string]int{},
+	}
+	d.inFlight[execution.id] = execution
+
+	return execution
+}
+
+// finish removes the operation from the ones in flight and keeps it if it was slow
+func (d *debugRegistry) finish(execution *debugExecution, err error) {
+	if d == nil || execution == nil {
+		return
+	}
+
+	duration := time.Since(execution.start)
+
+	var slow *DebugSlowRequest
+	if duration >= d.options.SlowThreshold {
+		slow = &DebugSlowRequest{
+			OperationName: execution.operationName,
+			Query:         execution.query,
+			StartedAt:     execution.start,
+			Duration:      duration,
+		}
+		if err != nil {
+			slow.Error = err.Error()
+		}
+		// a plan that can't be written down is left out
+		if execution.plan != nil {
+			if plan, err := json.Marshal(execution.plan); err == nil {
+				slow.Plan = plan
+			}
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.inFlight, execution.id)
+	if slow != nil {
+		d.slow = append(d.slow, slow)
+		if len(d.slow) > d.options.SlowRequests {
+			d.slow = d.slow[len(d.slow)-d.options.SlowRequests:]
+		}
+	}
+}
+
+// executions returns the operations in flight, the oldest first
+func (d *debugRegistry) executions() []*DebugExecution {
+	d.mutex.Lock()
+	executions := make([]*debugExecution, 0, len(d.inFlight))
+	for _, execution := range d.inFlight {
+		executions = append(executions, execution)
+	}
+	d.mutex.Unlock()
+
+	sort.Slice(executions, func(i, j int) bool {
+		return executions[i].id < executions[j].id
+	})
+
+	result := []*DebugExecution{}
+	for _, execution := range executions {
+		described := &DebugExecution{
+			ID:            execution.id,
+			OperationName: execution.operationName,
+			Query:         execution.query,
+			StartedAt:     execution.start,
+			Age:           time.Since(execution.start),
+			Services:      map[string]int{},
+		}
+
+		execution.mutex.Lock()
+		for location, count := range execution.outstanding {
+			described.StepsOutstanding += count
+			described.Services[location] = count
+		}
+		execution.mutex.Unlock()
+
+		result = append(result, described)
+	}
+
+	return result
+}
+
+// slowRequests returns the slow requests, the most recent first
+func (d *debugRegistry) slowRequests() []*DebugSlowRequest {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	result := []*DebugSlowRequest{}
+	for i := len(d.slow) - 1; i >= 0; i-- {
+		result = append(result, d.slow[i])
+	}
+
+	return result
+}
+
+// stepStarted records a query that was sent to the service for the operation
+func (e *debugExecution) stepStarted(location string) {
+	if e == nil {
+		return
+	}
+
+	e.mutex.Lock()
+	e.outstanding[location]++
+	e.mutex.Unlock()
+}
+
+// stepFinished records a query that the service answered
+func (e *debugExecution) stepFinished(location string) {
+	if e == nil {
+		return
+	}
+
+	e.mutex.Lock()
+	e.outstanding[location]--
+	if e.outstanding[location] <= 0 {
+		delete(e.outstanding, location)
+	}
+	e.mutex.Unlock()
+}
+
+// debugCacheCounter counts the hits and misses of a cache
+type debugCacheCounter struct {
+	mutex  sync.Mutex
+	hits   int64
+	misses int64
+}
+
+// planCacheLookup counts a lookup in the query plan cache
+func (d *debugRegistry) planCacheLookup(hit bool) {
+	if d != nil {
+		d.planCache.record(hit)
+	}
+}
+
+// responseCacheLookup counts a lookup in the response cache
+func (d *debugRegistry) responseCacheLookup(hit bool) {
+	if d != nil {
+		d.responseCache.record(hit)
+	}
+}
+
+// record counts a lookup in the cache
+func (c *debugCacheCounter) record(hit bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+// report describes the cache with the given size
+func (c *debugCacheCounter) report(size int) *DebugCache {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	report := &DebugCache{Size: size, Hits: c.hits, Misses: c.misses}
+	if total := c.hits + c.misses; total > 0 {
+		report.HitRate = float64(c.hits) / float64(total)
+	}
+
+	return report
+}
@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_debugHandler(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			allUsers: [String!]!
+		}
+	`)
+
+	// the service holds on to the query until we let it go
+	sent := make(chan struct{})
+	release := make(chan struct{})
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			sent <- struct{}{}
+			<-release
+			return map[string]interface{}{"allUsers": []interface{}{"alec"}}, nil
+		})
+	})
+
+	t.Run("Off by default", func(t *testing.T) {
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		for _, path := range []string{"/debug/", "/debug/pprof/goroutine"} {
+			response := httptest.NewRecorder()
+			gateway.DebugHandler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+			assert.Equal(t, http.StatusNotFound, response.Code, path)
+		}
+
+		// nothing is served on the default mux either
+		_, pattern := http.DefaultServeMux.Handler(httptest.NewRequest(http.MethodGet, "/debug/pprof/goroutine", nil))
+		assert.Equal(t, "", pattern)
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithQueryerFactory(&factory),
+		WithAutomaticQueryPlanCache(),
+		WithDebug(DebugOptions{SlowThreshold: time.Nanosecond}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// report returns what the debug handler says
+	report := func(t *testing.T) *DebugReport {
+		response := httptest.NewRecorder()
+		gateway.DebugHandler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/debug/", nil))
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		report := &DebugReport{}
+		if err := json.Unmarshal(response.Body.Bytes(), report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	// the same query is executed twice so the second one gets its plan from the cache
+	cacheKey := ""
+	for i := 0; i < 2; i++ {
+		done := make(chan error)
+		go func() {
+			ctx := &RequestContext{Context: context.Background(), Query: `query Users { allUsers }`, CacheKey: cacheKey}
+			plans, err := gateway.GetPlans(ctx)
+			if err != nil {
+				done <- err
+				return
+			}
+			cacheKey = ctx.CacheKey
+			_, err = gateway.Execute(ctx, plans)
+			done <- err
+		}()
+		<-sent
+
+		// the operation is waiting on the service
+		inFlight := report(t).InFlight
+		if assert.Len(t, inFlight, 1) {
+			assert.Equal(t, "Users", inFlight[0].OperationName)
+			assert.Equal(t, 1, inFlight[0].StepsOutstanding)
+			assert.Equal(t, map[string]int{"url1": 1}, inFlight[0].Services)
+			assert.True(t, inFlight[0].Age > 0)
+		}
+
+		release <- struct{}{}
+		assert.Nil(t, <-done)
+	}
+
+	result := report(t)
+	assert.Len(t, result.InFlight, 0)
+	assert.Equal(t, &DebugCache{Size: 1, Hits: 1, Misses: 1, HitRate: 0.5}, result.Caches["queryPlans"])
+
+	// every request was slower than a nanosecond and they come with their plans
+	if assert.Len(t, result.SlowRequests, 2) {
+		assert.Equal(t, "Users", result.SlowRequests[0].OperationName)
+		assert.Contains(t, string(result.SlowRequests[0].Plan), "allUsers")
+	}
+	assert.Equal(t, "/debug/pprof/goroutine?debug=1", result.Profiles["goroutine"])
+
+	// the profiles come from the runtime
+	response := httptest.NewRecorder()
+	gateway.DebugHandler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/debug/pprof/goroutine?debug=1", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), "goroutine")
+
+	response = httptest.NewRecorder()
+	gateway.DebugHandler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	assert.Contains(t, response.Body.String(), `href="heap?debug=1"`)
+
+	response = httptest.NewRecorder()
+	gateway.DebugHandler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/debug/pprof/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
@@ -0,0 +1,153 @@
+package gateway
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Clients don't tend to notice that a field is deprecated until it is removed. When deprecation warnings are
+// turned on, the response to an operation that selects deprecated fields lists them under
+// extensions.deprecations. The deprecated fields of the schema are found once, when the schema is built,
+// so all a request has to do is look up the fields it selected.
+
+// DeprecatedFieldUsage describes a deprecated field selected by an operation
+type DeprecatedFieldUsage struct {
+	// the keys of the fields in the response that lead to the deprecated field
+	Path []string `json:"path"`
+	// the type and field, ie User.username
+	Coordinate string `json:"coordinate"`
+	// the reason given by the @deprecated directive
+	Reason string `json:"reason"`
+}
+
+// DeprecationWarningOptions configures the warnings about deprecated fields
+type DeprecationWarningOptions struct {
+	// pulls the identity of the client out of the context of the request (ie, a value set by a middleware
+	// that looks at a header). It is passed to the handler so usage can be broken down per client.
+	ClientKey func(ctx context.Context) string
+	// called for every deprecated field selected by an operation so its usage can be recorded as a metric
+	Handler func(ctx context.Context, client string, usage *DeprecatedFieldUsage)
+}
+
+// WithDeprecationWarnings returns an Option that lists the deprecated fields selected by an operation in the
+// extensions of its response
+func WithDeprecationWarnings(opts DeprecationWarningOptions) Option {
+	return func(g *Gateway) {
+		g.deprecationWarnings = &opts
+	}
+}
+
+// the reason given to a field that is deprecated without one
+const defaultDeprecationReason = "No longer supported"
+
+// deprecatedFields returns the reason each deprecated field in the schema was deprecated, indexed by coordinate
+func deprecatedFields(schema *ast.Schema) map[string]string {
+	fields := map[string]string{}
+	for _, definition := range schema.Types {
+		for _, field := range definition.Fields {
+			directive := field.Directives.ForName("deprecated")
+			if directive == nil {
+				continue
+			}
+
+			reason := defaultDeprecationReason
+			if argument := directive.Arguments.ForName("reason"); argument != nil && argument.Value != nil {
+				reason = argument.Value.Raw
+			}
+			fields[definition.Name+"."+field.Name] = reason
+		}
+	}
+
+	return fields
+}
+
+// addDeprecationWarnings adds the deprecated fields selected by the operation to the extensions of the response
+func (g *Gateway) addDeprecationWarnings(ctx *ExecutionContext) error {
+	if g.deprecationWarnings == nil || ctx.Plan.Operation == nil {
+		return nil
+	}
+
+	g.schemaMutex.RLock()
+	deprecated := g.deprecatedFields
+	g.schemaMutex.RUnlock()
+
+	// most schemas don't deprecate anything
+	if len(deprecated) == 0 {
+		return nil
+	}
+
+	usages := []*DeprecatedFieldUsage{}
+	err := findDeprecatedFields(deprecated, ctx.Plan.Operation.SelectionSet, ctx.Plan.FragmentDefinitions, []string{}, &usages)
+	if err != nil || len(usages) == 0 {
+		return err
+	}
+
+	// the same field could be selected more than once (ie, in a few fragments)
+	seen := Set{}
+	unique := []*DeprecatedFieldUsage{}
+	for _, usage := range usages {
+		key := usage.Coordinate + " " + strings.Join(usage.Path, ".")
+		if seen.Has(key) {
+			continue
+		}
+		seen.Add(key)
+		unique = append(unique, usage)
+	}
+	sort.SliceStable(unique, func(i, j int) bool {
+		return strings.Join(unique[i].Path, ".") < strings.Join(unique[j].Path, ".")
+	})
+
+	if ctx.Extensions == nil {
+		ctx.Extensions = map[string]interface{}{}
+	}
+	ctx.Extensions["deprecations"] = unique
+
+	if g.deprecationWarnings.Handler != nil {
+		client := ""
+		if g.deprecationWarnings.ClientKey != nil {
+			client = g.deprecationWarnings.ClientKey(ctx.RequestContext)
+		}
+
+		for _, usage := range unique {
+			g.deprecationWarnings.Handler(ctx.RequestContext, client, usage)
+		}
+	}
+
+	return nil
+}
+
+// findDeprecatedFields adds the deprecated fields in the selection set to the list of usages
+func findDeprecatedFields(deprecated map[string]string, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList, path []string, usages *[]*DeprecatedFieldUsage) error {
+	for _, selection := range collectSelectedFields(selectionSet, fragments) {
+		field, ok := selection.(*ast.Field)
+		if !ok {
+			continue
+		}
+
+		key := field.Alias
+		if key == "" {
+			key = field.Name
+		}
+		fieldPath := append(append([]string{}, path...), key)
+
+		if field.ObjectDefinition != nil {
+			coordinate := field.ObjectDefinition.Name + "." + field.Name
+			if reason, ok := deprecated[coordinate]; ok {
+				*usages = append(*usages, &DeprecatedFieldUsage{
+					Path:       fieldPath,
+					Coordinate: coordinate,
+					Reason:     reason,
+				})
+			}
+		}
+
+		if err := findDeprecatedFields(deprecated, field.SelectionSet, fragments, fieldPath, usages); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
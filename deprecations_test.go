@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+// the context key that the tests use to identify the client
+type deprecationClientKey struct{}
+
+func TestGateway_deprecationWarnings(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String!
+			username: String! @deprecated(reason: "Use name instead.")
+			age: Int @deprecated
+		}
+
+		type Query {
+			me: User
+		}
+	`)
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{
+				"me": map[string]interface{}{"name": "Alice", "handle": "alice", "age": 30},
+			}, nil
+		})
+	})
+
+	// the usages reported to the handler
+	type report struct {
+		client string
+		usage  *DeprecatedFieldUsage
+	}
+	reports := []report{}
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}},
+		WithQueryerFactory(&factory),
+		WithDeprecationWarnings(DeprecationWarningOptions{
+			ClientKey: func(ctx context.Context) string {
+				client, _ := ctx.Value(deprecationClientKey{}).(string)
+				return client
+			},
+			Handler: func(ctx context.Context, client string, usage *DeprecatedFieldUsage) {
+				reports = append(reports, report{client, usage})
+			},
+		}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx := &RequestContext{
+		Context: context.WithValue(context.Background(), deprecationClientKey{}, "ios"),
+		Query: `
+			{
+				me {
+					name
+					handle: username
+					... on User {
+						handle: username
+						age
+					}
+				}
+			}
+		`,
+	}
+	plans, err := gateway.GetPlans(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, err = gateway.Execute(ctx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// every deprecated field shows up once, no matter how many times it was selected
+	expected := []*DeprecatedFieldUsage{
+		{Path: []string{"me", "age"}, Coordinate: "User.age", Reason: "No longer supported"},
+		{Path: []string{"me", "handle"}, Coordinate: "User.username", Reason: "Use name instead."},
+	}
+	assert.Equal(t, expected, ctx.Extensions["deprecations"])
+
+	if !assert.Len(t, reports, 2) {
+		return
+	}
+	for i, report := range reports {
+		assert.Equal(t, "ios", report.client)
+		assert.Equal(t, expected[i], report.usage)
+	}
+
+	// an operation that doesn't select deprecated fields doesn't get any warnings
+	ctx = &RequestContext{Context: context.Background(), Query: `{ me { name } }`}
+	plans, err = gateway.GetPlans(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = gateway.Execute(ctx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NotContains(t, ctx.Extensions, "deprecations")
+}
@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// DirectiveMap holds the name of every directive declared by the service at each location. The planner
+// uses it to decide which of the directives in a query can be forwarded to a service.
+type DirectiveMap map[string]Set
+
+// Supports returns true if the service at location understands the directive. @skip and @include are part
+// of the spec so every service is assumed to support them. If the map is nil, every directive is forwarded.
+func (m DirectiveMap) Supports(location string, directive string) bool {
+	if m == nil || directive == "skip" || directive == "include" {
+		return true
+	}
+
+	return m[location].Has(directive)
+}
+
+// serviceDirectives returns the directives declared by each of the sources
+func serviceDirectives(sources []*graphql.RemoteSchema) DirectiveMap {
+	directives := DirectiveMap{}
+
+	for _, source := range sources {
+		declared := Set{}
+		for name := range source.Schema.Directives {
+			declared.Add(name)
+		}
+		directives[source.URL] = declared
+	}
+
+	return directives
+}
+
+// plannerFilterDirectives returns a copy of the selection set that only has the directives the service at
+// location understands. Everything else is stripped so that strict services don't reject the query.
+func plannerFilterDirectives(directives DirectiveMap, location string, selectionSet ast.SelectionSet) ast.SelectionSet {
+	filtered := ast.SelectionSet{}
+
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			field := *selection
+			field.Directives = plannerFilterDirectiveList(directives, location, selection.Directives)
+			field.SelectionSet = plannerFilterDirectives(directives, location, selection.SelectionSet)
+			filtered = append(filtered, &field)
+
+		case *ast.InlineFragment:
+			fragment := *selection
+			fragment.Directives = plannerFilterDirectiveList(directives, location, selection.Directives)
+			fragment.SelectionSet = plannerFilterDirectives(directives, location, selection.SelectionSet)
+			filtered = append(filtered, &fragment)
+
+		case *ast.FragmentSpread:
+			spread := *selection
+			spread.Directives = plannerFilterDirectiveList(directives, location, selection.Directives)
+			filtered = append(filtered, &spread)
+		}
+	}
+
+	return filtered
+}
+
+// plannerFilterDirectiveList returns the directives in the list that the service at location understands
+func plannerFilterDirectiveList(directives DirectiveMap, location string, list ast.DirectiveList) ast.DirectiveList {
+	if len(list) == 0 {
+		return list
+	}
+
+	filtered := ast.DirectiveList{}
+	for _, directive := range list {
+		if directives.Supports(location, directive.Name) {
+			filtered = append(filtered, directive)
+		}
+	}
+
+	return filtered
+}
+
+// plannerDocumentVariables returns the name of every variable used by the query a step sends
+func plannerDocumentVariables(operationDirectives ast.DirectiveList, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) Set {
+	variables := Set{}
+
+	// the operation itself can have directives that depend on variables
+	for _, variable := range plannerExtractVariables(nil, operationDirectives) {
+		variables.Add(variable)
+	}
+
+	// add walks down a selection set and adds the variables used by arguments and directives
+	var add func(selectionSet ast.SelectionSet)
+	add = func(selectionSet ast.SelectionSet) {
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				for _, variable := range plannerExtractVariables(selection.Arguments, selection.Directives) {
+					variables.Add(variable)
+				}
+				add(selection.SelectionSet)
+
+			case *ast.InlineFragment:
+				for _, variable := range plannerExtractVariables(nil, selection.Directives) {
+					variables.Add(variable)
+				}
+				add(selection.SelectionSet)
+
+			case *ast.FragmentSpread:
+				for _, variable := range plannerExtractVariables(nil, selection.Directives) {
+					variables.Add(variable)
+				}
+			}
+		}
+	}
+
+	add(selectionSet)
+	for _, fragment := range fragments {
+		for _, variable := range plannerExtractVariables(nil, fragment.Directives) {
+			variables.Add(variable)
+		}
+		add(fragment.SelectionSet)
+	}
+
+	return variables
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/nautilus/graphql"
+)
+
+// The body of the requests sent to the services is put together by the queryer out of the query, the variables,
+// and the name of the step's operation. Some services want more than that in the extensions of the request (the
+// name and version of the client for their own rate limiting, a persisted query hash, etc). A downstream
+// extensions hook provides those values for each step. They are written into the extensions of the body once
+// the queryer has built it, next to whatever a request middleware already put there. When both of them set
+// the same key, the value from the hook wins.
+//
+// Multipart requests (the ones that upload files) are sent as they are.
+
+// DownstreamExtensions returns the extensions to send along with the query of the step
+type DownstreamExtensions func(ctx context.Context, step StepInfo) map[string]interface{}
+
+// WithDownstreamExtensions returns an Option that adds the values returned by the function to the extensions
+// of the requests sent to the services
+func WithDownstreamExtensions(extensions DownstreamExtensions) Option {
+	return func(g *Gateway) {
+		g.downstreamExtensions = extensions
+	}
+}
+
+// downstreamExtensionsMiddleware adds the extensions to the body of the requests sent to the services
+func downstreamExtensionsMiddleware(extensions map[string]interface{}) graphql.NetworkMiddleware {
+	return func(r *http.Request) error {
+		if r.Body == nil || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			return nil
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		body, err = addDownstreamExtensions(body, extensions)
+		if err != nil {
+			return err
+		}
+
+		// the body could be read again if the request is redirected or signed
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+		r.ContentLength = int64(len(body))
+
+		return nil
+	}
+}
+
+// addDownstreamExtensions returns the body of the request with the extensions added to it. The rest of the
+// body isn't decoded so the numbers in the variables are sent exactly like they were.
+func addDownstreamExtensions(body []byte, extensions map[string]interface{}) ([]byte, error) {
+	payload := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	// keep the extensions that were already there
+	merged := map[string]json.RawMessage{}
+	if existing, ok := payload["extensions"]; ok && string(existing) != "null" {
+		if err := json.Unmarshal(existing, &merged); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, value := range extensions {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		merged[key] = encoded
+	}
+
+	encoded, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	payload["extensions"] = encoded
+
+	return json.Marshal(payload)
+}
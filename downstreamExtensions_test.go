@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_downstreamRequestExtensions(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+		}
+
+		type Query {
+			users: [User!]!
+		}
+	`)
+
+	// the body of the request the service was sent
+	var sent map[string]interface{}
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &sent)
+
+		w.Write([]byte(`{"data": {"users": [{"id": "1"}]}}`))
+	}))
+	defer service.Close()
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}},
+		WithDownstreamExtensions(func(ctx context.Context, step StepInfo) map[string]interface{} {
+			return map[string]interface{}{
+				"clientName": "web",
+				"operation":  step.OperationName,
+			}
+		}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	reqCtx := &RequestContext{Context: context.Background(), Query: `query Users { users { id } }`}
+	plans, err := gateway.GetPlans(reqCtx)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	result, err := gateway.Execute(reqCtx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{"users": []interface{}{map[string]interface{}{"id": "1"}}}, result)
+
+	// the service sees the name of the step's query along with the extensions
+	assert.Equal(t, plans[0].RootStep.Then[0].QueryDocument.Operations[0].Name, sent["operationName"])
+	assert.Equal(t, map[string]interface{}{"clientName": "web", "operation": "Users"}, sent["extensions"])
+}
+
+func TestAddDownstreamExtensions(t *testing.T) {
+	// the extensions that are already there are kept unless the hook sets the same key
+	body, err := addDownstreamExtensions(
+		[]byte(`{"query": "{ users { id } }", "variables": {"id": 9007199254740993}, "extensions": {"persistedQuery": {"version": 1}, "clientName": "ios"}}`),
+		map[string]interface{}{"clientName": "web"},
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.JSONEq(t, `{
+		"query": "{ users { id } }",
+		"variables": {"id": 9007199254740993},
+		"extensions": {"persistedQuery": {"version": 1}, "clientName": "web"}
+	}`, string(body))
+
+	// and the numbers are sent exactly as they were
+	assert.Contains(t, string(body), "9007199254740993")
+}
@@ -0,0 +1,103 @@
+package gateway_test
+
+import (
+	"testing"
+
+	"github.com/nautilus/gateway/gatewaytest"
+	"github.com/stretchr/testify/assert"
+)
+
+// the users and their cat photos live in different services that are only put together by the gateway
+func TestEndToEnd_catPhotos(t *testing.T) {
+	users := map[string]map[string]interface{}{
+		"1": {"id": "1", "firstName": "hello"},
+		"2": {"id": "2", "firstName": "goodbye"},
+	}
+	photos := map[string][]interface{}{
+		"1": {map[string]interface{}{"url": "cat-1.png"}},
+		"2": {map[string]interface{}{"url": "cat-2.png"}, map[string]interface{}{"url": "cat-3.png"}},
+	}
+
+	harness, err := gatewaytest.NewHarness([]gatewaytest.Service{
+		{
+			Name: "users",
+			Schema: `
+				interface Node {
+					id: ID!
+				}
+
+				type User implements Node {
+					id: ID!
+					firstName: String!
+				}
+
+				type Query {
+					allUsers: [User!]!
+					node(id: ID!): Node
+				}
+			`,
+			Resolvers: gatewaytest.Resolvers{
+				"Query.allUsers": func(args map[string]interface{}) interface{} {
+					return []interface{}{users["1"], users["2"]}
+				},
+				"User": func(args map[string]interface{}) interface{} {
+					return users[args["id"].(string)]
+				},
+			},
+		},
+		{
+			Name: "cats",
+			Schema: `
+				interface Node {
+					id: ID!
+				}
+
+				type CatPhoto {
+					url: String!
+				}
+
+				type User implements Node {
+					id: ID!
+					catPhotos: [CatPhoto!]!
+				}
+
+				type Query {
+					node(id: ID!): Node
+				}
+			`,
+			Resolvers: gatewaytest.Resolvers{
+				"User": func(args map[string]interface{}) interface{} {
+					return map[string]interface{}{"catPhotos": photos[args["id"].(string)]}
+				},
+			},
+		},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer harness.Close()
+
+	var result struct {
+		AllUsers []struct {
+			FirstName string `json:"firstName"`
+			CatPhotos []struct {
+				URL string `json:"url"`
+			} `json:"catPhotos"`
+		} `json:"allUsers"`
+	}
+	err = harness.Query(`{ allUsers { firstName catPhotos { url } } }`, nil, &result)
+	if !assert.Nil(t, err) || !assert.Len(t, result.AllUsers, 2) {
+		return
+	}
+
+	assert.Equal(t, "hello", result.AllUsers[0].FirstName)
+	assert.Equal(t, "goodbye", result.AllUsers[1].FirstName)
+	if assert.Len(t, result.AllUsers[0].CatPhotos, 1) && assert.Len(t, result.AllUsers[1].CatPhotos, 2) {
+		assert.Equal(t, "cat-1.png", result.AllUsers[0].CatPhotos[0].URL)
+		assert.Equal(t, "cat-3.png", result.AllUsers[1].CatPhotos[1].URL)
+	}
+
+	// the cat service is asked for the photos of every user
+	assert.Len(t, harness.Services["cats"].Requests(), 3)
+
+}
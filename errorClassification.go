@@ -0,0 +1,272 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/nautilus/graphql"
+)
+
+// When a service turns down a step because of the credentials that were forwarded to it, the client has to be
+// told about it in a way it can act on (ie, by refreshing its token) instead of getting the same error it would
+// get if the gateway was broken. The errors of every step are passed through a classifier along with the status
+// of the response. An error that is given a class is reported to the client as a GraphQL error with the class
+// as its code, at the path of the fields the step was resolving, and those fields are set to null (following the
+// usual rules for non-null fields) so the rest of the response is left intact. The errors without a class are
+// reported like they always were.
+//
+// By default, a 401 or a 403 from a service and the GraphQL errors with an UNAUTHENTICATED or FORBIDDEN code are
+// classified. The response to the client keeps its 200 status unless a status was configured for the class.
+
+// ErrorClass is the kind of failure an error from a service represents. It is used as the code of the error
+// sent to the client.
+type ErrorClass string
+
+const (
+	// ErrorClassInternal is a failure the client can't do anything about. These errors are reported as they are.
+	ErrorClassInternal ErrorClass = ""
+	// ErrorClassUnauthenticated is a service refusing the credentials of the request
+	ErrorClassUnauthenticated ErrorClass = "UNAUTHENTICATED"
+	// ErrorClassForbidden is a service refusing to let the user see something
+	ErrorClassForbidden ErrorClass = "FORBIDDEN"
+)
+
+// ErrorClassifier returns the class of an error a service responded with. httpStatus is the status of the
+// response (zero if it isn't known) and gqlErr is the GraphQL error the service sent, if there was one.
+type ErrorClassifier func(serviceURL string, httpStatus int, gqlErr *graphql.Error) ErrorClass
+
+// WithErrorClassifier returns an Option that sets the function used to classify the errors of the services
+func WithErrorClassifier(classifier ErrorClassifier) Option {
+	return func(g *Gateway) {
+		g.errorClassifier = classifier
+	}
+}
+
+// WithErrorClassStatus returns an Option that responds with the designated HTTP status when an operation
+// failed with an error of the class. Operations that are part of a batch always get a 200.
+func WithErrorClassStatus(class ErrorClass, status int) Option {
+	return func(g *Gateway) {
+		if g.errorClassStatuses == nil {
+			g.errorClassStatuses = map[ErrorClass]int{}
+		}
+		g.errorClassStatuses[class] = status
+	}
+}
+
+// AuthErrorClassifier returns an ErrorClassifier that treats a 401 as UNAUTHENTICATED, a 403 as FORBIDDEN, and a
+// GraphQL error with one of the codes as an error of the class with the same name
+func AuthErrorClassifier(codes ...string) ErrorClassifier {
+	classes := Set{}
+	for _, code := range codes {
+		classes.Add(code)
+	}
+
+	return func(serviceURL string, httpStatus int, gqlErr *graphql.Error) ErrorClass {
+		if gqlErr != nil {
+			if code, ok := gqlErr.Extensions["code"].(string); ok && classes.Has(code) {
+				return ErrorClass(code)
+			}
+			return ErrorClassInternal
+		}
+
+		switch httpStatus {
+		case http.StatusUnauthorized:
+			return ErrorClassUnauthenticated
+		case http.StatusForbidden:
+			return ErrorClassForbidden
+		}
+
+		return ErrorClassInternal
+	}
+}
+
+// DefaultErrorClassifier is the ErrorClassifier used by a gateway that wasn't given one
+var DefaultErrorClassifier = AuthErrorClassifier(string(ErrorClassUnauthenticated), string(ErrorClassForbidden))
+
+// executorClassifyStepError returns the errors to report for a step that failed with the error, if the error (or
+// one of the errors in it) was given a class. Nothing is returned if every error is internal.
+func executorClassifyStepError(classifier ErrorClassifier, step *QueryPlanStep, insertionPoint InsertionPoint, err error) graphql.ErrorList {
+	if classifier == nil || step.Location == internalSchemaLocation {
+		return nil
+	}
+
+	// the errors of a step that failed as a whole are reported at the first field it was resolving
+	defaultPath := executorStepErrorPath(step, insertionPoint)
+
+	// the response couldn't be read at all
+	if responseErr, ok := err.(*ServiceResponseError); ok {
+		class := classifier(responseErr.URL, responseErr.StatusCode, nil)
+		if class == ErrorClassInternal {
+			return nil
+		}
+
+		classified := graphql.NewError(string(class), errorClassMessage(class))
+		classified.Path = defaultPath
+		return graphql.ErrorList{classified}
+	}
+
+	var errs graphql.ErrorList
+	switch err := err.(type) {
+	case graphql.ErrorList:
+		errs = err
+	case *graphql.Error:
+		errs = graphql.ErrorList{err}
+	default:
+		return nil
+	}
+
+	// the errors of a GraphQL response keep what the service said, with the path pointing into the client's response
+	found := false
+	result := graphql.ErrorList{}
+	for _, entry := range errs {
+		gqlErr, ok := entry.(*graphql.Error)
+		if !ok {
+			result = append(result, entry)
+			continue
+		}
+
+		class := classifier(step.Location, http.StatusOK, gqlErr)
+		if class == ErrorClassInternal {
+			result = append(result, entry)
+			continue
+		}
+		found = true
+
+		extensions := map[string]interface{}{}
+		for key, value := range gqlErr.Extensions {
+			extensions[key] = value
+		}
+		extensions["code"] = string(class)
+
+		path := executorClientErrorPath(insertionPoint, gqlErr.Path)
+		if path == nil {
+			path = defaultPath
+		}
+
+		result = append(result, &graphql.Error{
+			Message:    gqlErr.Message,
+			Path:       path,
+			Extensions: extensions,
+		})
+	}
+	if !found {
+		return nil
+	}
+
+	return result
+}
+
+// executorStepErrorPath returns the path of the first field that the step resolves in the client's response
+func executorStepErrorPath(step *QueryPlanStep, insertionPoint InsertionPoint) []interface{} {
+	path := insertionPoint.Path()
+	for _, field := range executorSelectedFields(step.SelectionSet, step.FragmentDefinitions) {
+		// the fields added by the gateway aren't part of the response
+		if !strings.HasPrefix(field.Alias, "__gateway") && !strings.HasPrefix(field.Name, "__") {
+			return append(path, field.Alias)
+		}
+	}
+
+	return path
+}
+
+// executorClientErrorPath turns the path of an error in the response to a step's query into the path of the
+// same field in the client's response. nil is returned if the path doesn't point into the step's object.
+func executorClientErrorPath(insertionPoint InsertionPoint, path []interface{}) []interface{} {
+	if len(path) == 0 {
+		return nil
+	}
+
+	// the steps at the root of the plan have the same shape as the client's response
+	if len(insertionPoint) == 0 {
+		return path
+	}
+
+	// the other steps look up their object first
+	switch path[0] {
+	case gatewayNodeAlias, "node":
+		path = path[1:]
+	case "_entities", "nodes":
+		if len(path) < 2 {
+			return nil
+		}
+		if _, ok := path[1].(float64); !ok {
+			if _, ok := path[1].(int); !ok {
+				return nil
+			}
+		}
+		path = path[2:]
+	default:
+		return nil
+	}
+
+	return append(insertionPoint.Path(), path...)
+}
+
+// errorClassMessage returns the message of the error sent to the client when the response of a service was
+// given the class
+func errorClassMessage(class ErrorClass) string {
+	switch class {
+	case ErrorClassUnauthenticated:
+		return "the credentials of the request were not accepted"
+	case ErrorClassForbidden:
+		return "the request is not allowed to access this field"
+	}
+
+	return fmt.Sprintf("the request failed with %s", class)
+}
+
+// errorClassStatus returns the status to respond with for an operation that failed with the errors, or zero if
+// none of them have a class with a status
+func (g *Gateway) errorClassStatus(err error) int {
+	if len(g.errorClassStatuses) == 0 {
+		return 0
+	}
+
+	errs, ok := err.(graphql.ErrorList)
+	if !ok {
+		errs = graphql.ErrorList{err}
+	}
+
+	for _, entry := range errs {
+		gqlErr, ok := entry.(*graphql.Error)
+		if !ok {
+			continue
+		}
+
+		if code, ok := gqlErr.Extensions["code"].(string); ok {
+			if status, ok := g.errorClassStatuses[ErrorClass(code)]; ok {
+				return status
+			}
+		}
+	}
+
+	return 0
+}
+
+// executorDeniedStep is an invocation of a step that a service turned down
+type executorDeniedStep struct {
+	entry *executorPendingStep
+	errs  graphql.ErrorList
+}
+
+// deny marks the step as one the service turned down. The fields it was resolving are set to null and the errors
+// are reported once the plan has been executed.
+func (e *executorPendingStep) deny(resultCh chan *queryExecutionResult, stepWg *sync.WaitGroup, errs graphql.ErrorList) {
+	published, _ := e.finish(nil, stepWg)
+	if !published {
+		return
+	}
+
+	e.pending.mutex.Lock()
+	e.pending.denied = append(e.pending.denied, &executorDeniedStep{entry: e, errs: errs})
+	e.pending.mutex.Unlock()
+
+	// the executor still needs to hear that the step is done
+	e.send(resultCh, &queryExecutionResult{
+		InsertionPoint: e.insertionPoint,
+		Result:         map[string]interface{}{},
+		Location:       e.step.Location,
+	})
+}
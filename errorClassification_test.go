@@ -0,0 +1,148 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_classifiedServiceErrors(t *testing.T) {
+	// the users service resolves the viewer, the avatars service responds however the test wants
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"viewer": {"name": "alec", "__gateway_id": "1"}, "version": "1.0"}}`))
+	}))
+	defer users.Close()
+
+	// send sends a query through a gateway in front of an avatars service that responds with the status and body
+	send := func(status int, body string, options ...Option) (*httptest.ResponseRecorder, map[string]interface{}) {
+		avatars := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+		defer avatars.Close()
+
+		usersSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type User implements Node {
+				id: ID!
+				name: String!
+			}
+
+			type Query {
+				viewer: User!
+				version: String!
+				node(id: ID!): Node
+			}
+		`)
+		avatarsSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type User implements Node {
+				id: ID!
+				avatar: String
+			}
+
+			type Query {
+				node(id: ID!): Node
+			}
+		`)
+
+		gateway, err := New([]*graphql.RemoteSchema{
+			{Schema: usersSchema, URL: users.URL},
+			{Schema: avatarsSchema, URL: avatars.URL},
+		}, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader([]byte(`{"query": "{ viewer { name avatar } version }"}`)))
+		recorder := httptest.NewRecorder()
+		gateway.GraphQLHandler(recorder, request)
+
+		response := map[string]interface{}{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return recorder, response
+	}
+
+	// the rest of the response is still there when a service doesn't accept the credentials
+	recorder, response := send(http.StatusUnauthorized, `{"message": "token expired"}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, map[string]interface{}{
+		"viewer":  map[string]interface{}{"name": "alec", "avatar": nil},
+		"version": "1.0",
+	}, response["data"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"message":    "the credentials of the request were not accepted",
+			"path":       []interface{}{"viewer", "avatar"},
+			"extensions": map[string]interface{}{"code": "UNAUTHENTICATED"},
+		},
+	}, response["errors"])
+
+	// a GraphQL error with a code keeps its message and points into the client's response
+	_, response = send(http.StatusOK, `{
+		"data": {"__gateway_node": null},
+		"errors": [{"message": "no avatars for you", "path": ["__gateway_node", "avatar"], "extensions": {"code": "FORBIDDEN", "scope": "avatars:read"}}]
+	}`)
+	assert.Equal(t, map[string]interface{}{
+		"viewer":  map[string]interface{}{"name": "alec", "avatar": nil},
+		"version": "1.0",
+	}, response["data"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"message":    "no avatars for you",
+			"path":       []interface{}{"viewer", "avatar"},
+			"extensions": map[string]interface{}{"code": "FORBIDDEN", "scope": "avatars:read"},
+		},
+	}, response["errors"])
+
+	// the status of the response can follow the class of the error
+	recorder, _ = send(http.StatusForbidden, ``, WithErrorClassStatus(ErrorClassForbidden, http.StatusForbidden))
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	// the classifier decides which errors count
+	_, response = send(http.StatusOK, `{"errors": [{"message": "session revoked", "extensions": {"code": "SESSION_REVOKED"}}]}`,
+		WithErrorClassifier(AuthErrorClassifier("SESSION_REVOKED")),
+	)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"message":    "session revoked",
+			"path":       []interface{}{"viewer", "avatar"},
+			"extensions": map[string]interface{}{"code": "SESSION_REVOKED"},
+		},
+	}, response["errors"])
+
+	// and the other failures are reported like they always were
+	_, response = send(http.StatusInternalServerError, `oops`)
+	if errs, ok := response["errors"].([]interface{}); assert.True(t, ok) && assert.Len(t, errs, 1) {
+		assert.NotContains(t, errs[0], "path")
+		assert.NotContains(t, errs[0], "extensions")
+	}
+}
+
+func TestClientErrorPath(t *testing.T) {
+	insertionPoint := InsertionPoint{{Field: "users", Indices: []int{2}}}
+
+	// the object the step looked up takes the place of the field that looked it up
+	assert.Equal(t, []interface{}{"users", 2, "avatar", "url"}, executorClientErrorPath(insertionPoint, []interface{}{gatewayNodeAlias, "avatar", "url"}))
+	assert.Equal(t, []interface{}{"users", 2, "avatar"}, executorClientErrorPath(insertionPoint, []interface{}{"_entities", float64(0), "avatar"}))
+
+	// the steps at the root of the plan don't look anything up
+	assert.Equal(t, []interface{}{"viewer", "avatar"}, executorClientErrorPath(InsertionPoint{}, []interface{}{"viewer", "avatar"}))
+
+	// paths that don't point into the object are dropped
+	assert.Nil(t, executorClientErrorPath(insertionPoint, []interface{}{"somethingElse"}))
+	assert.Nil(t, executorClientErrorPath(insertionPoint, nil))
+}
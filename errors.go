@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"context"
+
+	"github.com/nautilus/graphql"
+)
+
+// ErrorFormatter is called with every error before it is written to the response. It can replace the message,
+// attach extensions, or hide the error from the client entirely by returning InternalError. Returning nil leaves
+// the error unchanged.
+type ErrorFormatter func(ctx context.Context, err error) *graphql.Error
+
+// WithErrorFormatter returns an Option that sets the function used to format errors sent to the client
+func WithErrorFormatter(formatter ErrorFormatter) Option {
+	return func(g *Gateway) {
+		g.errorFormatter = formatter
+	}
+}
+
+// WithProductionMode returns an Option that configures the gateway with defaults that are suitable for
+// production. Unless another formatter is provided, errors that did not come from a GraphQL response
+// (ie, network failures when reaching a service) are masked before they are sent to the client.
+func WithProductionMode() Option {
+	return func(g *Gateway) {
+		g.productionMode = true
+	}
+}
+
+// InternalError logs the error and returns a generic error that is safe to send to the client
+func InternalError(ctx context.Context, err error) *graphql.Error {
+	log.Warn("Internal error: ", err)
+
+	return graphql.NewError("INTERNAL_SERVER_ERROR", "internal server error")
+}
+
+// MaskTransportErrors is an ErrorFormatter that leaves GraphQL errors intact and replaces anything else,
+// like a failed connection to a service, with a generic error.
+func MaskTransportErrors(ctx context.Context, err error) *graphql.Error {
+	if gqlErr, ok := err.(*graphql.Error); ok {
+		return gqlErr
+	}
+
+	return InternalError(ctx, err)
+}
+
+// errorResponse builds the response for the given error, passing each one through the
+// gateway's formatter
+func (g *Gateway) errorResponse(ctx context.Context, data interface{}, err error, code string) map[string]interface{} {
+	// without a formatter, we leave the errors as they are
+	if g.errorFormatter == nil {
+		return formatErrorsWithCode(data, err, code)
+	}
+
+	// the errors we have to format
+	var errList graphql.ErrorList
+	if list, ok := err.(graphql.ErrorList); ok {
+		errList = list
+	} else if code == "INTERNAL_SERVER_ERROR" {
+		// we don't know what went wrong (ie, a service couldn't be reached) so the formatter gets the error as it
+		// is and can decide to hide it
+		errList = graphql.ErrorList{err}
+	} else {
+		// the rest of the errors that aren't part of a list were built by the gateway for the client
+		errList = graphql.ErrorList{graphql.NewError(code, err.Error())}
+	}
+
+	// pass each error through the formatter
+	formatted := graphql.ErrorList{}
+	for _, err := range errList {
+		formattedErr := g.errorFormatter(ctx, err)
+		if formattedErr == nil {
+			// use the error as it was
+			if gqlErr, ok := err.(*graphql.Error); ok {
+				formattedErr = gqlErr
+			} else {
+				formattedErr = graphql.NewError(code, err.Error())
+			}
+		}
+
+		formatted = append(formatted, formattedErr)
+	}
+
+	return map[string]interface{}{
+		"data":   data,
+		"errors": formatted,
+	}
+}
@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+type formattedErrorsResult struct {
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func TestGraphQLHandler_errorFormatting(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+
+	// an executor that fails with a downstream error and a transport error
+	executor := ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+		return nil, graphql.ErrorList{
+			graphql.NewError("NOT_FOUND", "could not find value"),
+			errors.New("dial tcp 10.0.0.5:4000: connect: connection refused"),
+		}
+	})
+
+	send := func(gateway *Gateway) *formattedErrorsResult {
+		request := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ value }"}`))
+		responseRecorder := httptest.NewRecorder()
+
+		gateway.GraphQLHandler(responseRecorder, request)
+
+		result := &formattedErrorsResult{}
+		if err := json.Unmarshal(responseRecorder.Body.Bytes(), result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	t.Run("Production mode", func(t *testing.T) {
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithExecutor(executor), WithProductionMode())
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		result := send(gateway)
+		if !assert.Len(t, result.Errors, 2) {
+			return
+		}
+
+		// the downstream error is left alone
+		assert.Equal(t, "could not find value", result.Errors[0].Message)
+		assert.Equal(t, "NOT_FOUND", result.Errors[0].Extensions["code"])
+
+		// the transport error is masked
+		assert.Equal(t, "internal server error", result.Errors[1].Message)
+		assert.Equal(t, "INTERNAL_SERVER_ERROR", result.Errors[1].Extensions["code"])
+	})
+
+	t.Run("Custom formatter", func(t *testing.T) {
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+			WithExecutor(executor),
+			WithProductionMode(),
+			WithErrorFormatter(func(ctx context.Context, err error) *graphql.Error {
+				formatted := graphql.NewError("CUSTOM", "formatted: "+err.Error())
+				formatted.Extensions["requestID"] = "1234"
+				return formatted
+			}),
+		)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		result := send(gateway)
+		if !assert.Len(t, result.Errors, 2) {
+			return
+		}
+
+		for _, err := range result.Errors {
+			assert.True(t, strings.HasPrefix(err.Message, "formatted: "))
+			assert.Equal(t, "CUSTOM", err.Extensions["code"])
+			assert.Equal(t, "1234", err.Extensions["requestID"])
+		}
+	})
+
+	t.Run("Validation errors", func(t *testing.T) {
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithExecutor(executor), WithProductionMode())
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		request := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ notAField }"}`))
+		responseRecorder := httptest.NewRecorder()
+		gateway.GraphQLHandler(responseRecorder, request)
+
+		result := &formattedErrorsResult{}
+		if !assert.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), result)) {
+			return
+		}
+
+		// errors generated by the gateway itself are not masked
+		assert.Equal(t, "GRAPHQL_VALIDATION_FAILED", result.Errors[0].Extensions["code"])
+		assert.NotEqual(t, "internal server error", result.Errors[0].Message)
+	})
+
+	t.Run("Single transport error", func(t *testing.T) {
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+			WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+				return nil, errors.New("dial tcp 10.0.0.5:4000: connect: connection refused")
+			})),
+			WithProductionMode(),
+		)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// an error that isn't part of a list is masked too
+		result := send(gateway)
+		if !assert.Len(t, result.Errors, 1) {
+			return
+		}
+		assert.Equal(t, "internal server error", result.Errors[0].Message)
+		assert.Equal(t, "INTERNAL_SERVER_ERROR", result.Errors[0].Extensions["code"])
+	})
+}
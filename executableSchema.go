@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"context"
+
+	gqlgen "github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/executor"
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// A service doesn't have to be on the other side of a network. WithServiceQueryer sends the queries for
+// a service through any graphql.Queryer, ie one that executes a gqlgen schema in the same process.
+
+// WithServiceQueryer returns an Option that sends the queries for the service at url through the queryer
+// instead of over HTTP. It takes precedence over the QueryerFactory.
+func WithServiceQueryer(url string, queryer graphql.Queryer) Option {
+	return func(g *Gateway) {
+		if g.serviceQueryers == nil {
+			g.serviceQueryers = map[string]graphql.Queryer{}
+		}
+		g.serviceQueryers[url] = queryer
+	}
+}
+
+// serviceQueryer returns the queryer that was configured for the service at url, if there is one
+func (g *Gateway) serviceQueryer(url string) (graphql.Queryer, bool) {
+	queryer, ok := g.serviceQueryers[url]
+	return queryer, ok
+}
+
+// ExecutableSchemaSource returns the source for a gqlgen schema that is served by the gateway's process.
+// The schema is copied so that merging it with the other services doesn't change the one gqlgen validates against.
+func ExecutableSchemaSource(url string, schema gqlgen.ExecutableSchema) (*graphql.RemoteSchema, error) {
+	sdl, err := formatSchema(schema.Schema())
+	if err != nil {
+		return nil, err
+	}
+
+	copied, err := graphql.LoadSchema(sdl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &graphql.RemoteSchema{URL: url, Schema: copied}, nil
+}
+
+// ExecutableSchemaQueryer is a graphql.Queryer that executes queries against a gqlgen schema
+type ExecutableSchemaQueryer struct {
+	executor *executor.Executor
+}
+
+// NewExecutableSchemaQueryer returns a queryer that executes queries against the schema
+func NewExecutableSchemaQueryer(schema gqlgen.ExecutableSchema) *ExecutableSchemaQueryer {
+	return &ExecutableSchemaQueryer{executor: executor.New(schema)}
+}
+
+// Query executes the query and writes the data of the response to the receiver
+func (q *ExecutableSchemaQueryer) Query(ctx context.Context, input *graphql.QueryInput, receiver interface{}) error {
+	_, err := q.QueryWithExtensions(ctx, input, receiver)
+	return err
+}
+
+// QueryWithExtensions executes the query and writes the data of the response to the receiver. The
+// extensions of the response are returned.
+func (q *ExecutableSchemaQueryer) QueryWithExtensions(ctx context.Context, input *graphql.QueryInput, receiver interface{}) (map[string]interface{}, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = gqlgen.StartOperationTrace(ctx)
+
+	operationContext, errs := q.executor.CreateOperationContext(ctx, &gqlgen.RawParams{
+		Query:         input.Query,
+		OperationName: input.OperationName,
+		Variables:     input.Variables,
+	})
+	if errs != nil {
+		return nil, executableSchemaErrors(q.executor.DispatchError(ctx, errs))
+	}
+
+	handler, ctx := q.executor.DispatchOperation(ctx, operationContext)
+	response := handler(ctx)
+	if response == nil {
+		return nil, nil
+	}
+
+	// like a service on the network, a response with errors doesn't have any data we can use
+	if len(response.Errors) > 0 {
+		return response.Extensions, executableSchemaErrors(response)
+	}
+
+	if err := unmarshalJSON(response.Data, receiver); err != nil {
+		return nil, err
+	}
+
+	return response.Extensions, nil
+}
+
+// executableSchemaErrors turns the errors of a gqlgen response into the ones the gateway expects
+func executableSchemaErrors(response *gqlgen.Response) error {
+	errs := graphql.ErrorList{}
+	for _, err := range response.Errors {
+		converted := &graphql.Error{
+			Message:    err.Message,
+			Extensions: err.Extensions,
+		}
+		for _, segment := range err.Path {
+			switch segment := segment.(type) {
+			case ast.PathName:
+				converted.Path = append(converted.Path, string(segment))
+			case ast.PathIndex:
+				converted.Path = append(converted.Path, int(segment))
+			}
+		}
+
+		errs = append(errs, converted)
+	}
+
+	return errs
+}
@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gqlgen "github.com/99designs/gqlgen/graphql"
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestGateway_executableSchema(t *testing.T) {
+	// the reviews are served by a gqlgen schema in the same process
+	reviewsSchema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			reviews: [String!]!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`})
+	reviews := &gqlgen.ExecutableSchemaMock{
+		SchemaFunc: func() *ast.Schema {
+			return reviewsSchema
+		},
+		ComplexityFunc: func(typeName string, fieldName string, childComplexity int, args map[string]interface{}) (int, bool) {
+			return 0, false
+		},
+		ExecFunc: func(ctx context.Context) gqlgen.ResponseHandler {
+			// the gateway looks up each user on its own
+			id := gqlgen.GetOperationContext(ctx).Variables[gatewayIDAlias]
+			data, _ := json.Marshal(map[string]interface{}{
+				gatewayNodeAlias: map[string]interface{}{
+					"reviews": []string{fmt.Sprintf("review of %v", id)},
+				},
+			})
+
+			return gqlgen.OneShot(&gqlgen.Response{Data: data})
+		},
+	}
+
+	// the users are behind HTTP
+	usersSchema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			users: [User!]!
+		}
+	`)
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf(`{"data": {"users": [
+			{"name": "alice", "%[1]s": "1"},
+			{"name": "bob", "%[1]s": "2"}
+		]}}`, gatewayIDAlias)))
+	}))
+	defer users.Close()
+
+	reviewsSource, err := ExecutableSchemaSource("reviews", reviews)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	gateway, err := New(
+		[]*graphql.RemoteSchema{{URL: users.URL, Schema: usersSchema}, reviewsSource},
+		WithServiceQueryer("reviews", NewExecutableSchemaQueryer(reviews)),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx := &RequestContext{Context: context.Background(), Query: `{ users { name reviews } }`}
+	plans, err := gateway.GetPlans(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	result, err := gateway.Execute(ctx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "alice", "reviews": []interface{}{"review of 1"}},
+			map[string]interface{}{"name": "bob", "reviews": []interface{}{"review of 2"}},
+		},
+	}, result)
+
+	// merging the schemas doesn't change the one gqlgen validates against
+	assert.Nil(t, reviewsSchema.Types["User"].Fields.ForName("name"))
+
+	// a query that the schema can't answer comes back as an error
+	err = NewExecutableSchemaQueryer(reviews).Query(context.Background(), &graphql.QueryInput{Query: `{ users { name } }`}, &map[string]interface{}{})
+	if assert.IsType(t, graphql.ErrorList{}, err) {
+		assert.Len(t, err.(graphql.ErrorList), 1)
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nautilus/graphql"
 	"github.com/vektah/gqlparser/v2/ast"
@@ -20,12 +20,17 @@ type Executor interface {
 
 // ParallelExecutor executes the given query plan by starting at the root of the plan and
 // walking down the path stitching the results together
-type ParallelExecutor struct{}
+type ParallelExecutor struct {
+	// the most times the steps of a plan can be executed for a single request. zero means there is no limit
+	MaxStepExecutions int
+}
 
 type queryExecutionResult struct {
-	InsertionPoint []string
+	InsertionPoint InsertionPoint
 	Result         map[string]interface{}
 	StripNode      bool
+	Location       string
+	Extensions     map[string]interface{}
 }
 
 // execution is broken up into two phases:
@@ -40,6 +45,55 @@ type ExecutionContext struct {
 	Variables          map[string]interface{}
 	RequestContext     context.Context
 	RequestMiddlewares []graphql.NetworkMiddleware
+	StepMiddlewares    []StepMiddleware
+	// the text of the query and the name of the operation being executed. The query is empty for
+	// a persisted query that was only sent as a hash.
+	Query         string
+	OperationName string
+	// the extensions to add to the response. Executors and middlewares can add their own and the
+	// extensions of the services are added once the plan has been executed. The keys that are already
+	// here take precedence.
+	Extensions map[string]interface{}
+	// the credentials to use for each service, indexed by url
+	Credentials map[string]CredentialProvider
+	// the biggest query each service accepts in bytes, indexed by url. Bigger steps are split up.
+	QueryLimits map[string]int
+	// the extensions of the responses sent back by each service, indexed by url. This is filled in by the executor.
+	ServiceExtensions map[string]map[string]interface{}
+	// the number of times each step of the plan was executed. This is filled in by the executor.
+	StepExecutions map[*QueryPlanStep]int
+	// the steps that depend on another step are abandoned if they haven't finished by the deadline.
+	// the zero value waits for every step.
+	Deadline time.Time
+	// the queries sent to the same service within this window are combined into one request. zero turns it off.
+	CoalesceWindow time.Duration
+	// the header that carries the name of the client's operation to the services. empty leaves it off.
+	ParentOperationHeader string
+	// the header that carries the time left before the deadline to the services, and the least time a step
+	// needs to be sent. empty leaves the header off.
+	DeadlineHeader string
+	DeadlineFloor  time.Duration
+	// the names the gateway gave to the types of each service, indexed by url
+	TypeRenames map[string]TypeRenames
+	// provides the variables to add to the queries of each step, bound to the arguments the services declare
+	VariableInjector VariableInjector
+	// provides the extensions to send along with the queries of each step
+	DownstreamExtensions DownstreamExtensions
+	// decides which errors of the services the client can act on. nil reports every error as it is.
+	ErrorClassifier ErrorClassifier
+	// the schema of each service, indexed by url. The names of the types are the gateway's.
+	ServiceSchemas map[string]*ast.Schema
+	// the name of the executor the client asked for, if it asked for one
+	RequestedExecutor string
+
+	// the queries waiting to be combined with the others going to the same service
+	coalescer *requestCoalescer
+	// records the queries sent to the services when the request is being audited
+	audit *auditRecorder
+	// counts the work that goes into the response
+	stats *statsRecorder
+	// shows the operation to the debug handler
+	debug *debugExecution
 }
 
 // Execute returns the result of the query plan
@@ -47,9 +101,9 @@ func (executor *ParallelExecutor) Execute(ctx *ExecutionContext) (map[string]int
 	// a place to store the result
 	result := map[string]interface{}{}
 
-	// a channel to receive query results
+	// a channel to receive query results. these channels are never closed since a step that is
+	// still running could try to send on them. Instead, steps stop sending once closeCh is closed.
 	resultCh := make(chan *queryExecutionResult, 10)
-	defer close(resultCh)
 
 	// a wait group so we know when we're done with all of the steps
 	stepWg := &sync.WaitGroup{}
@@ -57,30 +111,47 @@ func (executor *ParallelExecutor) Execute(ctx *ExecutionContext) (map[string]int
 	// and a channel for errors
 	errMutex := &sync.Mutex{}
 	errCh := make(chan error, 10)
-	defer close(errCh)
 
 	// a channel to close the goroutine
 	closeCh := make(chan bool)
 	defer close(closeCh)
 
-	// a lock for reading and writing to the result
-	resultLock := &sync.Mutex{}
+	// the requests of any steps that are still running when we return aren't needed anymore
+	requestContext := ctx.RequestContext
+	if requestContext == nil {
+		requestContext = context.Background()
+	}
+	requestContext, cancel := context.WithCancel(requestContext)
+	defer cancel()
+	stepCtx := *ctx
+	stepCtx.RequestContext = requestContext
+	if ctx.CoalesceWindow > 0 {
+		stepCtx.coalescer = newRequestCoalescer(ctx.CoalesceWindow)
+	}
+
+	// puts the results of the steps together
+	stitcher := NewStitcher(ctx.Plan.IDFields)
+
+	// the extensions that the services sent back
+	extensions := map[string]map[string]interface{}{}
+
+	// the objects we've already looked up while executing this plan
+	memo := newStepMemo()
 
 	// if there are no steps after the root step, there is a problem
 	if len(ctx.Plan.RootStep.Then) == 0 {
 		return nil, errors.New("was given empty plan")
 	}
 
-	// the root step could have multiple steps that have to happen
-	for _, step := range ctx.Plan.RootStep.Then {
-		stepWg.Add(1)
-		go executeStep(ctx, ctx.Plan, step, []string{}, resultLock, ctx.Variables, resultCh, errCh, stepWg)
-	}
+	// the steps that are still running
+	pending := newExecutorPendingSteps(executor.MaxStepExecutions, closeCh)
 
 	// the list of errors we have encountered while executing the plan
 	errs := graphql.ErrorList{}
 
-	// start a goroutine to add results to the list
+	// start a goroutine to add results to the list. The results are inserted one at a time and a step's result
+	// is sent before the steps that depend on it are started, so the lists it holds are in place before
+	// anything is inserted into their entries
 	go func() {
 		for {
 			select {
@@ -94,10 +165,14 @@ func (executor *ParallelExecutor) Execute(ctx *ExecutionContext) (map[string]int
 
 				// we have to grab the value in the result and write it to the appropriate spot in the
 				// acumulator.
-				err := executorInsertObject(result, resultLock, payload.InsertionPoint, payload.Result)
+				err := executorInsertResult(stitcher, result, payload)
 				if err != nil {
-					errCh <- err
-					continue
+					// we are the only one reading from the error channel so we can't wait on it
+					errMutex.Lock()
+					errs = append(errs, err)
+					errMutex.Unlock()
+				} else {
+					executorAddExtensions(extensions, payload.Location, payload.Extensions)
 				}
 
 				log.Debug("Done. ", result)
@@ -123,14 +198,58 @@ func (executor *ParallelExecutor) Execute(ctx *ExecutionContext) (map[string]int
 		}
 	}()
 
-	// when the wait group is finished
-	stepWg.Wait()
+	// the fields of a mutation are performed one after the other, along with everything that depends on them
+	if ctx.Plan.Operation != nil && ctx.Plan.Operation.Operation == ast.Mutation {
+		for _, step := range ctx.Plan.RootStep.Then {
+			for _, entry := range pending.start([]*QueryPlanStep{step}, stepWg) {
+				go executeStep(&stepCtx, ctx.Plan, entry.step, entry.insertionPoint, stitcher, ctx.Variables, resultCh, errCh, stepWg, memo, entry)
+			}
+			executorWait(ctx.Deadline, pending, stepWg)
+		}
+	} else {
+		// the root step could have multiple steps that have to happen
+		for _, entry := range pending.start(ctx.Plan.RootStep.Then, stepWg) {
+			go executeStep(&stepCtx, ctx.Plan, entry.step, entry.insertionPoint, stitcher, ctx.Variables, resultCh, errCh, stepWg, memo, entry)
+		}
+	}
+
+	// when the wait group is finished (or we've run out of time)
+	executorWait(ctx.Deadline, pending, stepWg)
 
 	// if we encountered any errors
 	errMutex.Lock()
-	nErrs := len(errs)
 	defer errMutex.Unlock()
 
+	// the results (and their extensions) are written by the same goroutine as the errors
+	ctx.ServiceExtensions = extensions
+	ctx.StepExecutions = pending.counts()
+
+	// the steps that didn't make it in time leave a null behind
+	for _, entry := range pending.abandoned {
+		var timeoutErr *graphql.Error
+		result, timeoutErr = executorAbandonStep(ctx.Plan, result, entry)
+		errs = append(errs, timeoutErr)
+	}
+
+	// the objects that the services couldn't find are null
+	for _, entry := range pending.missing {
+		var clean bool
+		result, clean = executorNullStep(ctx.Plan, result, entry, true)
+
+		// a null that takes the place of a non-null field has to be explained
+		if !clean {
+			notFoundErr := graphql.NewError("NOT_FOUND", fmt.Sprintf("could not find %s with id %s", entry.step.ParentType, entry.insertionPoint.ID()))
+			notFoundErr.Path = entry.insertionPoint.Path()
+			errs = append(errs, notFoundErr)
+		}
+	}
+	// the fields of the steps the services turned down are null
+	for _, denied := range pending.denied {
+		result, _ = executorNullStep(ctx.Plan, result, denied.entry, false)
+		errs = append(errs, denied.errs...)
+	}
+	nErrs := len(errs)
+
 	if nErrs > 0 {
 		return result, errs
 	}
@@ -144,13 +263,22 @@ func executeStep(
 	ctx *ExecutionContext,
 	plan *QueryPlan,
 	step *QueryPlanStep,
-	insertionPoint []string,
-	resultLock *sync.Mutex,
+	insertionPoint InsertionPoint,
+	stitcher *Stitcher,
 	queryVariables map[string]interface{},
 	resultCh chan *queryExecutionResult,
 	errCh chan error,
 	stepWg *sync.WaitGroup,
+	memo *stepMemo,
+	entry *executorPendingStep,
 ) {
+	// nothing recovers a panic in a goroutine but the goroutine itself
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			entry.fail(errCh, recoveredError(recovered, "executing a step"))
+		}
+	}()
+
 	log.Debug("")
 	log.Debug("Executing step to be inserted in ", step.ParentType, ". Insertion point: ", insertionPoint)
 
@@ -160,112 +288,103 @@ func executeStep(
 	log.QueryPlanStep(step)
 
 	// the list of variables and their definitions that pertain to this query
-	variables := map[string]interface{}{}
-
-	// we need to grab the variable definitions and values for each variable in the step
-	for variable := range step.Variables {
-		// and the value if it exists
-		if value, ok := queryVariables[variable]; ok {
-			variables[variable] = value
-		}
-	}
+	variables := executorStepVariables(step, queryVariables)
 
 	// the id of the object we are query is defined by the last step in the realized insertion point
+	pointID := insertionPoint.ID()
 	if len(insertionPoint) > 0 {
-		head := insertionPoint[max(len(insertionPoint)-1, 0)]
-
-		// get the data of the point
-		pointData, err := executorGetPointData(head)
-		if err != nil {
-			errCh <- err
-			return
-		}
-
 		// if we dont have an id
-		if pointData.ID == "" {
-			errCh <- fmt.Errorf("Could not find id in path")
+		if pointID == "" {
+			entry.fail(errCh, fmt.Errorf("Could not find id in path"))
 			return
 		}
 
-		// save the id as a variable to the query
-		variables["id"] = pointData.ID
+		// federated services look up the parent with a representation of the entity
+		if step.EntityKey != "" {
+			variables[gatewayRepresentationsVariable] = []interface{}{
+				map[string]interface{}{
+					"__typename":   step.ParentType,
+					step.EntityKey: pointID,
+				},
+			}
+		} else {
+			// save the id as a variable to the query
+			variables[gatewayIDAlias] = pointID
+		}
 	}
 
 	// if there is no queryer
 	if step.Queryer == nil {
-		errCh <- errors.New(" could not find queryer for step")
+		entry.fail(errCh, errors.New(" could not find queryer for step"))
 		return
 	}
 
-	// the query we will use
-	queryer := step.Queryer
-	// a place to save the result
-	queryResult := map[string]interface{}{}
+	// objects that show up more than once in a response are only looked up once
+	var extensions map[string]interface{}
+	fetch := func() (map[string]interface{}, error) {
+		result, stepExtensions, err := executorFetchStep(ctx, plan, step, variables, stitcher)
+		extensions = stepExtensions
+		return result, err
+	}
 
-	// if we have middlewares
-	if len(ctx.RequestMiddlewares) > 0 {
-		// if the queryer is a network queryer
-		if nQueryer, ok := queryer.(graphql.QueryerWithMiddlewares); ok {
-			queryer = nQueryer.WithMiddlewares(ctx.RequestMiddlewares)
+	var queryResult map[string]interface{}
+	var err error
+	if entry.batch != nil && pointID != "" {
+		// the batch only sends each id once so there's nothing left for the memo to do
+		queryResult, extensions, err = entry.batch.get(ctx, plan, pointID)
+		if err == nil && queryResult == nil {
+			entry.notFound(resultCh, stepWg)
+			return
+		}
+	} else if memo != nil && pointID != "" {
+		fetched := false
+		queryResult, err = memo.do(stepMemoKey(step, pointID), func() (map[string]interface{}, error) {
+			fetched = true
+			return fetch()
+		})
+		if !fetched {
+			ctx.stats.memoHit()
 		}
+	} else {
+		queryResult, err = fetch()
 	}
 
-	operationName := ""
-	if plan != nil && plan.Operation != nil {
-		operationName = plan.Operation.Name
+	// the objects whose ids the service wouldn't send back can't have anything inserted into them
+	var unresolved []*executorUnresolvedObject
+	if injected, ok := err.(*executorInjectedFieldsError); ok {
+		unresolved = executorUnresolvedObjects(insertionPoint, injected.errs)
+		err = nil
 	}
-
-	// fire the query
-	err := queryer.Query(ctx.RequestContext, &graphql.QueryInput{
-		Query:         step.QueryString,
-		QueryDocument: step.QueryDocument,
-		Variables:     variables,
-		OperationName: operationName,
-	}, &queryResult)
 	if err != nil {
-		log.Warn("Network Error: ", err)
-		errCh <- err
-		return
-	}
-
-	// NOTE: this insertion point could point to a list of values. If it did, we have to have
-	//       passed it to the this invocation of this function. It is safe to trust this
-	//       InsertionPoint as the right place to insert this result.
-
-	// if this is a query that falls underneath a `node(id: ???)` query then we only want to consider the object
-	// underneath the `node` field as the result for the query
-	stripNode := step.ParentType != "Query" && step.ParentType != "Subscription" && step.ParentType != "Mutation"
-	if stripNode {
-		log.Debug("Should strip node")
-		// get the result from the response that we have to stitch there
-		extractedResult, err := executorExtractValue(queryResult, resultLock, []string{"node"})
-		if err != nil {
-			errCh <- err
+		// the client should hear about the errors it can do something about without losing the rest of the response
+		// a step that a middleware refused to send only costs the client its fields
+		if vetoed, ok := err.(*stepVetoError); ok {
+			entry.deny(resultCh, stepWg, vetoed.errors(step, insertionPoint))
 			return
 		}
 
-		resultObj, ok := extractedResult.(map[string]interface{})
-		if !ok {
-			errCh <- fmt.Errorf("Query result of node query was not an object: %v", queryResult)
+		if classified := executorClassifyStepError(ctx.ErrorClassifier, step, insertionPoint, err); classified != nil {
+			entry.deny(resultCh, stepWg, classified)
 			return
 		}
 
-		queryResult = resultObj
+		entry.fail(errCh, err)
+		return
 	}
 
+	// NOTE: this insertion point could point to a list of values. If it did, we have to have
+	//       passed it to the this invocation of this function. It is safe to trust this
+	//       InsertionPoint as the right place to insert this result.
+
 	// we need to collect all the dependent steps and execute them at last in this function
 	// to avoid a race condition, where the result of a dependent request is published to the
 	// result channel even before the result created in this iteration
-	type stepArgs struct {
-		step           *QueryPlanStep
-		insertionPoint []string
-	}
-	var dependentSteps []stepArgs
+	var dependentSteps []*executorPendingStep
 	// defer the execution of the dependent steps after the main step has been published
 	defer func() {
 		for _, sr := range dependentSteps {
 			log.Info("Spawn ", sr.insertionPoint)
-			go executeStep(ctx, plan, sr.step, sr.insertionPoint, resultLock, queryVariables, resultCh, errCh, stepWg)
+			go executeStep(ctx, plan, sr.step, sr.insertionPoint, stitcher, queryVariables, resultCh, errCh, stepWg, memo, sr)
 		}
 	}()
 
@@ -275,428 +394,357 @@ func executeStep(
 		// we need to find the ids of the objects we are inserting into and then kick of the worker with the right
 		// insertion point. For lists, insertion points look like: ["user", "friends:0", "catPhotos:0", "owner"]
 		for _, dependent := range step.Then {
-			copiedInsertionPoint := make([]string, len(insertionPoint))
-			copy(copiedInsertionPoint, insertionPoint)
-			insertPoints, err := executorFindInsertionPoints(resultLock, dependent.InsertionPoint, step.SelectionSet, queryResult, [][]string{copiedInsertionPoint}, step.FragmentDefinitions)
-			if err != nil {
-				// reset dependent steps - result would be discarded anyways
-				dependentSteps = nil
-				errCh <- err
-				return
+			// a step that was merged with identical ones is inserted in all of their places
+			insertPoints := []InsertionPoint{}
+			for _, target := range dependent.insertionPoints() {
+				points, err := stitcher.FindInsertionPoints(target, step.SelectionSet, step.FragmentDefinitions, queryResult, insertionPoint)
+				if err != nil {
+					// reset dependent steps - result would be discarded anyways
+					dependentSteps = nil
+					entry.fail(errCh, err)
+					return
+				}
+				insertPoints = append(insertPoints, points...)
+			}
+			if len(unresolved) > 0 {
+				insertPoints = entry.denyUnresolved(dependent, insertPoints, unresolved)
 			}
 
+			// the objects might all be looked up at once
+			batch := newNodesBatch(dependent, insertPoints, executorStepVariables(dependent, queryVariables))
+
 			// this dependent needs to fire for every object that the insertion point references
 			for _, insertionPoint := range insertPoints {
-				dependentSteps = append(dependentSteps, stepArgs{
+				dependentSteps = append(dependentSteps, &executorPendingStep{
+					pending:        entry.pending,
 					step:           dependent,
 					insertionPoint: insertionPoint,
+					batch:          batch,
 				})
 			}
 		}
 	}
 
-	// before publishing the current result, tell the wait-group about the dependent steps to wait for
-	stepWg.Add(len(dependentSteps))
+	// a query that goes through a lot of lists could cause more requests than we're willing to send
+	if err := entry.reserve(dependentSteps); err != nil {
+		dependentSteps = nil
+		entry.fail(errCh, err)
+		return
+	}
+
+	// before publishing the current result, tell the wait-group about the dependent steps to wait for.
+	// if the step was abandoned while we were waiting, nobody is listening for the result anymore
+	var published bool
+	published, dependentSteps = entry.finish(dependentSteps, stepWg)
+	if !published {
+		return
+	}
 	log.Debug("Pushing Result. Insertion point: ", insertionPoint, ". Value: ", queryResult)
 	// send the result to be stitched in with our accumulator
-	resultCh <- &queryExecutionResult{
+	entry.send(resultCh, &queryExecutionResult{
 		InsertionPoint: insertionPoint,
 		Result:         queryResult,
-	}
+		Location:       step.Location,
+		Extensions:     extensions,
+	})
 }
 
-func max(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
-}
+// executorInsertResult stitches the result of a step into the response
+func executorInsertResult(stitcher *Stitcher, result map[string]interface{}, payload *queryExecutionResult) (err error) {
+	// the goroutine that collects the results has to keep going for the request to finish
+	defer recoverError(&err, "inserting the result of a step")
 
-func findSelection(matchString string, selectionSet ast.SelectionSet, fragmentDefs ast.FragmentDefinitionList) (*ast.Field, error) {
-	selectionSetFragments, err := graphql.ApplyFragments(selectionSet, fragmentDefs)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, selection := range selectionSetFragments {
-		switch selection := selection.(type) {
-		case *ast.Field:
-			if selection.Alias == matchString || selection.Name == matchString {
-				return selection, nil
-			}
-		}
-	}
-
-	return nil, nil
+	return stitcher.Insert(result, payload.InsertionPoint, payload.Result)
 }
 
-// executorFindInsertionPoints returns the list of insertion points where this step should be executed.
-func executorFindInsertionPoints(resultLock *sync.Mutex, targetPoints []string, selectionSet ast.SelectionSet, result map[string]interface{}, startingPoints [][]string, fragmentDefs ast.FragmentDefinitionList) ([][]string, error) {
-	log.Debug("Looking for insertion points. target: ", targetPoints, " Starting from ", startingPoints)
-	oldBranch := startingPoints
-
-	// track the root of the selection set while  we walk
-	selectionSetRoot := selectionSet
-
-	// a place to refer to parts of the results
-	resultChunk := result
+// executorStepVariables returns the values of the variables used by the step's query
+func executorStepVariables(step *QueryPlanStep, queryVariables map[string]interface{}) map[string]interface{} {
+	// there's room for the id of the step's parent too
+	variables := make(map[string]interface{}, len(step.Variables)+1)
 
-	// the index to start at
-	startingIndex := 0
-	if len(oldBranch) > 0 {
-		startingIndex = len(oldBranch[0])
+	// we need to grab the variable definitions and values for each variable in the step
+	for variable := range step.Variables {
+		// the variable could be standing in for one of the client's
+		source := variable
+		if original, ok := step.VariableSources[variable]; ok {
+			source = original
+		}
 
-		if len(targetPoints) == len(oldBranch[0]) {
-			return startingPoints, nil
+		// and the value if it exists
+		if value, ok := queryVariables[source]; ok {
+			variables[variable] = value
 		}
 	}
 
-	log.Debug("First meaningful path point: ", targetPoints[startingIndex])
-	log.Debug("result ", resultChunk)
+	return variables
+}
 
-	// if our starting point is []string{"users:0"} then we know everything so far
-	// is along the path of the steps insertion point
-	for pointI := startingIndex; pointI < len(targetPoints); pointI++ {
-		// the point in the steps insertion path that we want to add
-		point := targetPoints[pointI]
+// executorFetchStep sends the query for a step and returns the part of the response that has to be inserted
+// along with the extensions of the response
+func executorFetchStep(ctx *ExecutionContext, plan *QueryPlan, step *QueryPlanStep, variables map[string]interface{}, stitcher *Stitcher) (map[string]interface{}, map[string]interface{}, error) {
+	queryResult, extensions, err := executorSendStep(ctx, plan, step, step.QueryString, step.QueryDocument, variables)
+	if _, partial := err.(*executorInjectedFieldsError); err != nil && !partial {
+		return nil, nil, err
+	}
 
-		// find the selection node in the AST corresponding to the point
-		var foundSelection *ast.Field
-		foundSelection, err := findSelection(point, selectionSetRoot, fragmentDefs)
+	// if this is a query that falls underneath a `node(id: ???)` query then we only want to consider the object
+	// underneath the `node` field as the result for the query
+	stripNode := !step.atOperationRoot()
+	if stripNode && step.EntityKey != "" {
+		log.Debug("Should strip entities")
+		// the object we care about is the only entry in the _entities list
+		resultObj, err := executorExtractEntity(queryResult)
 		if err != nil {
-			log.Debug("Error looking for selection")
-			return [][]string{}, err
+			return nil, nil, err
 		}
 
-		// if we didn't find a selection
-		if foundSelection == nil {
-			log.Debug("No selection")
-			return [][]string{}, nil
+		queryResult = resultObj
+	} else if stripNode {
+		log.Debug("Should strip node")
+		// get the result from the response that we have to stitch there
+		extractedResult, err := stitcher.Extract(queryResult, InsertionPoint{{Field: executorNodeKey(queryResult)}})
+		if err != nil {
+			return nil, nil, err
 		}
 
-		log.Debug("")
-		log.Debug("Found Selection for: ", point)
-		log.Debug("Result Chunk: ", resultChunk)
-		// make sure we are looking at the top of the selection set next time
-		selectionSetRoot = foundSelection.SelectionSet
-
-		var value = resultChunk
-
-		// the bit of result chunk with the appropriate key should be a list
-		rootValue, ok := value[point]
+		resultObj, ok := extractedResult.(map[string]interface{})
 		if !ok {
-			return [][]string{}, nil
+			return nil, nil, fmt.Errorf("Query result of node query was not an object: %v", queryResult)
 		}
 
-		// get the type of the object in question
-		selectionType := foundSelection.Definition.Type
-
-		if rootValue == nil {
-			if selectionType.NonNull {
-				err := fmt.Errorf("Received null for required field: %v", foundSelection.Name)
-				log.Warn(err)
-				return nil, err
-			} else {
-				return nil, nil
-			}
-		}
-
-		// if the type is a list
-		if selectionType.Elem != nil {
-			log.Debug("Selection should be a list")
-			// make sure the root value is a list
-			rootList, ok := rootValue.([]interface{})
-			if !ok {
-				return nil, fmt.Errorf("Root value of result chunk was not a list: %v", rootValue)
-			}
-			// build up a new list of insertion points
-			newInsertionPoints := [][]string{}
-
-			// each value in the result contributes an insertion point
-			for entryI, iEntry := range rootList {
-				resultEntry, ok := iEntry.(map[string]interface{})
-				if !ok {
-					return nil, errors.New("entry in result wasn't a map")
-				}
-
-				// the point we are going to add to the list
-				entryPoint := fmt.Sprintf("%s:%v", foundSelection.Name, entryI)
-				log.Debug("Adding ", entryPoint, " to list")
+		queryResult = resultObj
+	}
 
-				newBranchSet := make([][]string, len(oldBranch))
-				for i, c := range oldBranch {
-					newBranchSet[i] = append(newBranchSet[i], c...)
-				}
+	return queryResult, extensions, err
+}
 
-				// if we are adding to an existing branch
-				if len(newBranchSet) > 0 {
-					// add the path to the end of this for the entry we just added
-					for i, newBranch := range newBranchSet {
-						// if we are looking at the last thing in the insertion list
-						if pointI == len(targetPoints)-1 {
-							// look for an id
-							id, ok := resultEntry["id"]
-							if !ok {
-								return nil, errors.New("Could not find the id for elements in target list")
-							}
-
-							// add the id to the entry so that the executor can use it to form its query
-							entryPoint = fmt.Sprintf("%s#%v", entryPoint, id)
-
-						}
-
-						// add the point for this entry in the list
-						newBranchSet[i] = append(newBranch, entryPoint)
-					}
-				} else {
-					newBranchSet = append(newBranchSet, []string{entryPoint})
-				}
+// executorSendStep sends the query of a step to its service and returns the response, with the names the gateway
+// gave to the service's types, along with the extensions of the response
+func executorSendStep(ctx *ExecutionContext, plan *QueryPlan, step *QueryPlanStep, query string, document *ast.QueryDocument, variables map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	// the query we will use
+	queryer := step.Queryer
+	// a place to save the result
+	var queryResult map[string]interface{}
 
-				// compute the insertion points for that entry
-				entryInsertionPoints, err := executorFindInsertionPoints(resultLock, targetPoints, selectionSetRoot, resultEntry, newBranchSet, fragmentDefs)
-				if err != nil {
-					return nil, err
-				}
+	clientOperation := ""
+	if plan != nil && plan.Operation != nil {
+		clientOperation = plan.Operation.Name
+	}
 
-				for _, point := range entryInsertionPoints {
-					// add the list of insertion points to the acumulator
-					newInsertionPoints = append(newInsertionPoints, point)
-				}
-			}
+	// the step's query carries its own name, unless the step was put together by hand
+	operationName := clientOperation
+	if document != nil && len(document.Operations) > 0 && document.Operations[0].Name != "" {
+		operationName = document.Operations[0].Name
+	}
 
-			// return the flat list of insertion points created by our children
-			return newInsertionPoints, nil
-		}
-		// traverse down the resultChunk for the next iteration
-		if rootValueMap, ok := rootValue.(map[string]interface{}); ok {
-			resultChunk = rootValueMap
-		}
+	// tell the service which of the client's operations the query belongs to
+	middlewares := ctx.RequestMiddlewares
+	if ctx.ParentOperationHeader != "" && clientOperation != "" {
+		middlewares = append(append([]graphql.NetworkMiddleware{}, middlewares...), parentOperationMiddleware(ctx.ParentOperationHeader, clientOperation))
+	}
 
-		// we are encountering something that isn't a list so it must be an object or a scalar
-		// regardless, we just need to add the point to the end of each list
-		for i, points := range oldBranch {
-			oldBranch[i] = append(points, point)
+	// and whatever extensions the service should get for the step
+	stepExtensions := false
+	if ctx.DownstreamExtensions != nil && step.Location != internalSchemaLocation {
+		extensions := ctx.DownstreamExtensions(ctx.RequestContext, StepInfo{
+			Location:       step.Location,
+			ParentType:     step.ParentType,
+			InsertionPoint: step.InsertionPoint,
+			OperationName:  clientOperation,
+		})
+		if len(extensions) > 0 {
+			middlewares = append(append([]graphql.NetworkMiddleware{}, middlewares...), downstreamExtensionsMiddleware(extensions))
+			stepExtensions = true
 		}
+	}
 
-		if pointI == len(targetPoints)-1 {
-			// the root value could be a list in which case the id is the id of the corresponding entry
-			// or the root value could be an object in which case the id is the id of the root value
-
-			// if the root value is a list
-			if rootList, ok := rootValue.([]interface{}); ok {
-				for i := range oldBranch {
-					entry, ok := rootList[i].(map[string]interface{})
-					if !ok {
-						return nil, errors.New("Item in root list isn't a map")
-					}
-
-					// look up the id of the object
-					resultLock.Lock()
-					id, ok := entry["id"]
-					resultLock.Unlock()
-					if !ok {
-						return nil, errors.New("Could not find the id for the object")
-					}
-
-					// log.Debug("Adding id to ", oldBranch[i][pointI])
-
-					oldBranch[i][pointI] = fmt.Sprintf("%s:%v#%v", oldBranch[i][pointI], i, id)
+	// the credentials go last so they see the request as it will be sent
+	provider := ctx.Credentials[step.Location]
+	if provider != nil {
+		middlewares = append(append([]graphql.NetworkMiddleware{}, middlewares...), credentialMiddleware(step.Location, provider))
+	}
 
-				}
-			} else {
-				rootObj, ok := rootValue.(map[string]interface{})
-				if !ok {
-					return nil, fmt.Errorf("Root value of result chunk was not an object. Point: %v Value: %v", point, rootValue)
-				}
+	input := &graphql.QueryInput{
+		Query:         query,
+		QueryDocument: document,
+		Variables:     variables,
+		OperationName: operationName,
+	}
 
-				for i := range oldBranch {
-					// look up the id of the object
-					id := rootObj["id"]
-					if !ok {
-						return nil, errors.New("Could not find the id for the object")
-					}
+	// the request-scoped arguments are added to the fields of the service that declare them
+	if ctx.VariableInjector != nil && step.Location != internalSchemaLocation {
+		injected := ctx.VariableInjector(ctx.RequestContext, StepInfo{
+			Location:       step.Location,
+			ParentType:     step.ParentType,
+			InsertionPoint: step.InsertionPoint,
+			OperationName:  clientOperation,
+		})
 
-					oldBranch[i][pointI] = fmt.Sprintf("%s#%v", oldBranch[i][pointI], id)
-				}
-			}
+		injectedInput, err := injectVariables(ctx.ServiceSchemas[step.Location], input, injected)
+		if err != nil {
+			return nil, nil, err
 		}
-
+		input = injectedInput
 	}
 
-	// return the aggregation
-	return oldBranch, nil
-}
-
-func isListElement(path string) bool {
-	if hashLocation := strings.Index(path, "#"); hashLocation > 0 {
-		path = path[:hashLocation]
+	// a service whose types were renamed has to see the names it knows
+	renames := ctx.TypeRenames[step.Location]
+	if len(renames) > 0 {
+		renamedInput, err := renames.serviceInput(input)
+		if err != nil {
+			return nil, nil, err
+		}
+		input = renamedInput
 	}
-	return strings.Contains(path, ":")
-}
 
-func executorExtractValue(source map[string]interface{}, resultLock *sync.Mutex, path []string) (interface{}, error) {
-	// a pointer to the objects we are modifying
-	var recent interface{} = source
-	log.Debug("Pulling ", path, " from ", source)
-
-	for i, point := range path[:] {
-		// if the point designates an element in the list
-		if isListElement(point) {
-			pointData, err := executorGetPointData(point)
-			if err != nil {
-				return nil, err
-			}
+	// the step middlewares have the last word on what the service sees
+	if len(ctx.StepMiddlewares) > 0 && step.Location != internalSchemaLocation {
+		modifiedInput, err := applyStepMiddlewares(ctx.RequestContext, ctx.StepMiddlewares, StepInfo{
+			Location:       step.Location,
+			ParentType:     step.ParentType,
+			InsertionPoint: step.InsertionPoint,
+			OperationName:  clientOperation,
+		}, input)
+		if err != nil {
+			return nil, nil, err
+		}
+		input = modifiedInput
+	}
 
-			recentObj, ok := recent.(map[string]interface{})
-			if !ok {
-				return nil, fmt.Errorf("List was not a child of an object. %v", pointData)
-			}
+	// the audit sees the query just like the service does
+	var audit *auditStepRecorder
+	if ctx.audit != nil {
+		audit = ctx.audit.step(step.Location, input)
+	}
 
-			// if the field does not exist
-			if _, ok := recentObj[pointData.Field]; !ok {
-				resultLock.Lock()
-				recentObj[pointData.Field] = []interface{}{}
-				resultLock.Unlock()
-			}
+	// the requests are counted against the service and go through the middlewares of this request
+	requestContext := withRequestMiddlewares(ctx.stats.context(ctx.RequestContext, step.Location), middlewares)
 
-			// it should be a list
-			resultLock.Lock()
-			field := recentObj[pointData.Field]
-			resultLock.Unlock()
+	// the service can give up once we would stop waiting for it
+	if ctx.DeadlineHeader != "" && step.Location != internalSchemaLocation {
+		deadlineContext, err := withStepDeadline(requestContext, ctx, step)
+		if err != nil {
+			return nil, nil, err
+		}
+		requestContext = deadlineContext
+	}
 
-			targetList, ok := field.([]interface{})
-			if !ok {
-				return nil, fmt.Errorf("did not encounter a list when expected. Point: %v. Field: %v. Result %v", point, pointData.Field, field)
-			}
+	// fire the query, along with the others going to the same service if we can
+	var extensions map[string]interface{}
+	var err error
+	start := time.Now()
+	ctx.debug.stepStarted(step.Location)
+	// the queries for a service with a limit aren't combined since the combined query could go over it, and
+	// neither are the ones with their own extensions since the combined request only carries one set of them
+	limit := ctx.QueryLimits[step.Location]
+	if ctx.coalescer != nil && step.Location != internalSchemaLocation && step.Location != "" && limit <= 0 && !stepExtensions {
+		// the combined request carries the context of just one of the steps so the audit can't be
+		// given the raw response
+		queryResult, extensions, err = ctx.coalescer.query(requestContext, queryer, step.Location, input)
+	} else {
+		if audit != nil {
+			requestContext = audit.context(requestContext)
+		}
+		queryResult, extensions, err = executorSendLimitedQuery(requestContext, queryer, step.Location, input, limit)
+	}
+	ctx.stats.stepDuration(step, time.Since(start))
+	ctx.debug.stepFinished(step.Location)
+	if audit != nil {
+		audit.finish(queryResult, err)
+	}
 
-			// if the field exists but does not have enough spots
-			if len(targetList) <= pointData.Index {
-				for i := len(targetList) - 1; i < pointData.Index; i++ {
-					targetList = append(targetList, map[string]interface{}{})
-				}
+	// a service that only refused the fields we added still resolved the rest of the step
+	var injectedErr error
+	if injected, ok := executorInjectedFieldErrors(err); ok && queryResult != nil {
+		injectedErr = &executorInjectedFieldsError{errs: injected}
+		err = nil
+	}
+	if err != nil {
+		log.Warn("Network Error: ", err)
+		return nil, nil, err
+	}
 
-				// update the list with what we just made
-				resultLock.Lock()
-				recentObj[pointData.Field] = targetList
-				resultLock.Unlock()
+	// and the client has to see the names the gateway gave them
+	if len(renames) > 0 && input.QueryDocument != nil {
+		for _, operation := range input.QueryDocument.Operations {
+			if err := renames.renameResponse(queryResult, operation.SelectionSet, input.QueryDocument.Fragments); err != nil {
+				return nil, nil, err
 			}
+		}
+	}
 
-			// focus on the right element
-			resultLock.Lock()
-			recent = targetList[pointData.Index]
-			resultLock.Unlock()
-		} else {
-			// it's possible that there's an id
-			pointData, err := executorGetPointData(point)
-			if err != nil {
-				return nil, err
-			}
+	return queryResult, extensions, injectedErr
+}
 
-			pointField := pointData.Field
+// executorSendQuery sends the query with the queryer and returns the response along with its extensions
+func executorSendQuery(ctx context.Context, queryer graphql.Queryer, input *graphql.QueryInput) (_ map[string]interface{}, _ map[string]interface{}, err error) {
+	// queryers can come from outside the gateway and some of them are called from their own goroutine
+	defer recoverError(&err, "sending a query")
 
-			recentObj, ok := recent.(map[string]interface{})
-			if !ok {
-				return nil, fmt.Errorf("thisone, Target was not an object. %v, %v", pointData, recent)
-			}
+	queryResult := map[string]interface{}{}
 
-			// we are add an object value
-			resultLock.Lock()
-			targetObject := recentObj[pointField]
-			resultLock.Unlock()
+	if stats := serviceStats(ctx); stats != nil {
+		atomic.AddInt64(&stats.Requests, 1)
+	}
 
-			if i != len(path)-1 && targetObject == nil {
-				resultLock.Lock()
-				recentObj[pointField] = map[string]interface{}{}
-				resultLock.Unlock()
-			}
-			// if we haven't created an object there with that field
-			if targetObject == nil {
-				recentObj[pointField] = map[string]interface{}{}
-			}
+	if eQueryer, ok := queryer.(QueryerWithExtensions); ok {
+		extensions, err := eQueryer.QueryWithExtensions(ctx, input, &queryResult)
+		return queryResult, extensions, err
+	}
 
-			// look there next
-			recent = recentObj[pointField]
-		}
+	// the gateway's client leaves the extensions in the collector
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	collector := &extensionsCollector{}
+	err = queryer.Query(context.WithValue(ctx, extensionsCollectorKey{}, collector), input, &queryResult)
+
+	// the transport could have a version of the data with all of its numbers intact. It also holds onto the
+	// data that came with errors, which the queryer throws away
+	if data := collector.getData(); data != nil {
+		queryResult = data
+	} else if err != nil {
+		queryResult = nil
 	}
 
-	return recent, nil
+	// the client wraps the errors of its transport, which already say which service sent the response
+	return queryResult, collector.get(), unwrapServiceResponseError(err)
 }
 
-func executorInsertObject(target map[string]interface{}, resultLock *sync.Mutex, path []string, value interface{}) error {
-	// log.Debug("Inserting object\n    Target: ", target, "\n    Path: ", path, "\n    Value: ", value)
-	if len(path) > 0 {
-		// a pointer to the objects we are modifying
-		obj, err := executorExtractValue(target, resultLock, path)
-		if err != nil {
-			return err
-		}
-
-		targetObj, ok := obj.(map[string]interface{})
-		if !ok {
-			return errors.New("target object is not an object")
-		}
-
-		// if the value we are assigning is an object
-		if newValue, ok := value.(map[string]interface{}); ok {
-			for k, v := range newValue {
-				resultLock.Lock()
-				targetObj[k] = v
-				resultLock.Unlock()
-			}
-		}
-	} else {
-		targetObj, ok := value.(map[string]interface{})
-		if !ok {
-			return errors.New("something went wrong")
-		}
+// executorExtractEntity returns the object in the response to an _entities query
+func executorExtractEntity(queryResult map[string]interface{}) (map[string]interface{}, error) {
+	entities, ok := queryResult["_entities"].([]interface{})
+	if !ok || len(entities) != 1 {
+		return nil, fmt.Errorf("Query result of entities query was not a list with one entry: %v", queryResult)
+	}
 
-		for key, value := range targetObj {
-			resultLock.Lock()
-			target[key] = value
-			resultLock.Unlock()
-		}
+	resultObj, ok := entities[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Query result of entities query was not an object: %v", queryResult)
 	}
-	return nil
-}
 
-type extractorPointData struct {
-	Field string
-	Index int
-	ID    string
+	return resultObj, nil
 }
 
-func executorGetPointData(point string) (*extractorPointData, error) {
-	field := point
-	index := -1
-	id := ""
-
-	// points come in the form <field>:<index>#<id> and each of index or id is optional
-	if strings.Contains(point, "#") {
-		idData := strings.Split(point, "#")
-		if len(idData) == 2 {
-			id = idData[1]
-		}
-
-		// use the index data without the id
-		field = idData[0]
+// executorNodeKey returns the key of the response to a node query that holds the parent of the step
+func executorNodeKey(queryResult map[string]interface{}) string {
+	// plans that weren't built by the MinQueriesPlanner might not use the alias
+	if _, ok := queryResult[gatewayNodeAlias]; !ok {
+		return "node"
 	}
 
-	if strings.Contains(field, ":") {
-		indexData := strings.Split(field, ":")
-		indexValue, err := strconv.ParseInt(indexData[1], 0, 32)
-		if err != nil {
-			return nil, err
-		}
+	return gatewayNodeAlias
+}
 
-		index = int(indexValue)
-		field = indexData[0]
+// executorObjectID returns the id of an object that a step is inserted into. The id added by the planner is
+// preferred over the one the user asked for since the user is free to alias any field as id.
+func executorObjectID(object map[string]interface{}, idField string) (interface{}, bool) {
+	if id, ok := object[gatewayIDAlias]; ok {
+		return id, true
 	}
 
-	return &extractorPointData{
-		Field: field,
-		Index: index,
-		ID:    id,
-	}, nil
+	id, ok := object[idField]
+	return id, ok
 }
 
 // ExecutorFunc wraps a function to be used as an executor.
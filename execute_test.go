@@ -8,8 +8,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"runtime"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/nautilus/graphql"
 	"github.com/stretchr/testify/assert"
@@ -315,7 +318,7 @@ func TestExecutor_insertIntoFragmentSpread(t *testing.T) {
 								},
 								Queryer: graphql.QueryerFunc(
 									func(input *graphql.QueryInput) (interface{}, error) {
-										assert.Equal(t, map[string]interface{}{"id": "1"}, input.Variables)
+										assert.Equal(t, map[string]interface{}{"__gateway_id": "1"}, input.Variables)
 										// make sure that we got the right variable inputs
 										return map[string]interface{}{
 											"node": map[string]interface{}{
@@ -448,10 +451,10 @@ func TestExecutor_insertIntoListFragmentSpreads(t *testing.T) {
 								},
 								Queryer: graphql.QueryerFunc(
 									func(input *graphql.QueryInput) (interface{}, error) {
-										assert.Contains(t, []interface{}{"1", "2"}, input.Variables["id"])
+										assert.Contains(t, []interface{}{"1", "2"}, input.Variables["__gateway_id"])
 										return map[string]interface{}{
 											"node": map[string]interface{}{
-												"address": fmt.Sprintf("address-%s", input.Variables["id"]),
+												"address": fmt.Sprintf("address-%s", input.Variables["__gateway_id"]),
 											},
 										}, nil
 									},
@@ -587,10 +590,10 @@ func TestExecutor_insertIntoFragmentSpreadLists(t *testing.T) {
 								},
 								Queryer: graphql.QueryerFunc(
 									func(input *graphql.QueryInput) (interface{}, error) {
-										assert.Contains(t, []interface{}{"1", "2"}, input.Variables["id"])
+										assert.Contains(t, []interface{}{"1", "2"}, input.Variables["__gateway_id"])
 										return map[string]interface{}{
 											"node": map[string]interface{}{
-												"address": fmt.Sprintf("address-%s", input.Variables["id"]),
+												"address": fmt.Sprintf("address-%s", input.Variables["__gateway_id"]),
 											},
 										}, nil
 									},
@@ -717,7 +720,7 @@ func TestExecutor_insertIntoInlineFragment(t *testing.T) {
 								},
 								Queryer: graphql.QueryerFunc(
 									func(input *graphql.QueryInput) (interface{}, error) {
-										assert.Equal(t, map[string]interface{}{"id": "1"}, input.Variables)
+										assert.Equal(t, map[string]interface{}{"__gateway_id": "1"}, input.Variables)
 										// make sure that we got the right variable inputs
 										return map[string]interface{}{
 											"node": map[string]interface{}{
@@ -830,10 +833,10 @@ func TestExecutor_insertIntoListInlineFragments(t *testing.T) {
 								},
 								Queryer: graphql.QueryerFunc(
 									func(input *graphql.QueryInput) (interface{}, error) {
-										assert.Contains(t, []interface{}{"1", "2"}, input.Variables["id"])
+										assert.Contains(t, []interface{}{"1", "2"}, input.Variables["__gateway_id"])
 										return map[string]interface{}{
 											"node": map[string]interface{}{
-												"address": fmt.Sprintf("address-%s", input.Variables["id"]),
+												"address": fmt.Sprintf("address-%s", input.Variables["__gateway_id"]),
 											},
 										}, nil
 									},
@@ -949,10 +952,10 @@ func TestExecutor_insertIntoInlineFragmentsList(t *testing.T) {
 								},
 								Queryer: graphql.QueryerFunc(
 									func(input *graphql.QueryInput) (interface{}, error) {
-										assert.Contains(t, []interface{}{"1", "2"}, input.Variables["id"])
+										assert.Contains(t, []interface{}{"1", "2"}, input.Variables["__gateway_id"])
 										return map[string]interface{}{
 											"node": map[string]interface{}{
-												"address": fmt.Sprintf("address-%s", input.Variables["id"]),
+												"address": fmt.Sprintf("address-%s", input.Variables["__gateway_id"]),
 											},
 										}, nil
 									},
@@ -1142,7 +1145,7 @@ func TestExecutor_insertIntoLists(t *testing.T) {
 										Queryer: graphql.QueryerFunc(
 											func(input *graphql.QueryInput) (interface{}, error) {
 												// make sure that we got the right variable inputs
-												assert.Equal(t, map[string]interface{}{"id": "1"}, input.Variables)
+												assert.Equal(t, map[string]interface{}{"__gateway_id": "1"}, input.Variables)
 
 												// return the payload
 												return map[string]interface{}{
@@ -1699,7 +1702,7 @@ func TestFindInsertionPoint_rootList(t *testing.T) {
 		},
 	}
 
-	generatedPoint, err := executorFindInsertionPoints(&sync.Mutex{}, planInsertionPoint, stepSelectionSet, result, startingPoint, nil)
+	generatedPoint, err := testFindInsertionPoints(planInsertionPoint, stepSelectionSet, result, startingPoint)
 	if err != nil {
 		t.Error(t, err)
 		return
@@ -1757,7 +1760,7 @@ func TestFindObject(t *testing.T) {
 		},
 	}
 
-	value, err := executorExtractValue(source, &sync.Mutex{}, []string{"hello:0", "friends:1", "friends:0"})
+	value, err := testExtractValue(source, []string{"hello:0", "friends:1", "friends:0"})
 	if err != nil {
 		t.Error(err.Error())
 		return
@@ -1797,7 +1800,7 @@ func TestFindString(t *testing.T) {
 		},
 	}
 
-	value, err := executorExtractValue(source, &sync.Mutex{}, []string{"hello:0", "friends:1", "firstName"})
+	value, err := testExtractValue(source, []string{"hello:0", "friends:1", "firstName"})
 	if err != nil {
 		t.Error(err.Error())
 		return
@@ -1814,7 +1817,7 @@ func TestExecutorInsertObject_insertObjectValues(t *testing.T) {
 	inserted := map[string]interface{}{"hello": "world"}
 
 	// insert the string deeeeep down
-	err := executorInsertObject(source, &sync.Mutex{}, []string{"hello:5#1", "message", "body:2"}, inserted)
+	err := testInsertObject(source, []string{"hello:5#1", "message", "body:2"}, inserted)
 	if err != nil {
 		t.Error(err)
 		return
@@ -1893,7 +1896,7 @@ func TestExecutorInsertObject_insertListElements(t *testing.T) {
 	}
 
 	// insert the object deeeeep down
-	err := executorInsertObject(source, &sync.Mutex{}, []string{"hello", "objects:5"}, inserted)
+	err := testInsertObject(source, []string{"hello", "objects:5"}, inserted)
 	if err != nil {
 		t.Error(err)
 		return
@@ -1934,27 +1937,58 @@ func TestExecutorInsertObject_insertListElements(t *testing.T) {
 	assert.Equal(t, inserted, list[5])
 }
 
-func TestExecutorGetPointData(t *testing.T) {
+func TestExecutorInsertObject_insertListOfListElements(t *testing.T) {
+	source := map[string]interface{}{
+		"grid": []interface{}{
+			[]interface{}{map[string]interface{}{"value": 1}},
+		},
+	}
+
+	// insert the object into a row that doesn't exist yet
+	err := testInsertObject(source, []string{"grid:1:2"}, map[string]interface{}{"value": 2})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"grid": []interface{}{
+			[]interface{}{map[string]interface{}{"value": 1}},
+			[]interface{}{
+				map[string]interface{}{},
+				map[string]interface{}{},
+				map[string]interface{}{"value": 2},
+			},
+		},
+	}, source)
+}
+
+func TestParsePathPoint(t *testing.T) {
 	table := []struct {
 		point string
-		data  *extractorPointData
+		data  PathPoint
 	}{
-		{"foo:2", &extractorPointData{Field: "foo", Index: 2, ID: ""}},
-		{"foo#3", &extractorPointData{Field: "foo", Index: -1, ID: "3"}},
-		{"foo:2#3", &extractorPointData{Field: "foo", Index: 2, ID: "3"}},
-		{"foo#Thing:1337", &extractorPointData{Field: "foo", Index: -1, ID: "Thing:1337"}},
-		{"foo:2#Thing:1337", &extractorPointData{Field: "foo", Index: 2, ID: "Thing:1337"}},
+		{"foo", PathPoint{Field: "foo"}},
+		{"foo:2", PathPoint{Field: "foo", Indices: []int{2}, ID: ""}},
+		{"foo#3", PathPoint{Field: "foo", ID: "3"}},
+		{"foo:2#3", PathPoint{Field: "foo", Indices: []int{2}, ID: "3"}},
+		{"foo#Thing:1337", PathPoint{Field: "foo", ID: "Thing:1337"}},
+		{"foo:2#Thing:1337", PathPoint{Field: "foo", Indices: []int{2}, ID: "Thing:1337"}},
+		{"foo:1:3", PathPoint{Field: "foo", Indices: []int{1, 3}, ID: ""}},
+		{"foo:1:3#Thing:1337", PathPoint{Field: "foo", Indices: []int{1, 3}, ID: "Thing:1337"}},
 	}
 
 	for _, row := range table {
 		t.Run(row.point, func(t *testing.T) {
-			pointData, err := executorGetPointData(row.point)
+			pointData, err := ParsePathPoint(row.point)
 			if err != nil {
 				t.Error(err.Error())
 				return
 			}
 
 			assert.Equal(t, row.data, pointData)
+
+			// the point can be turned back into the string it came from
+			assert.Equal(t, row.point, pointData.String())
 		})
 	}
 }
@@ -1988,7 +2022,7 @@ func TestFindInsertionPoint_bailOnNil(t *testing.T) {
 		},
 	}
 
-	generatedPoint, err := executorFindInsertionPoints(&sync.Mutex{}, planInsertionPoint, stepSelectionSet, result, [][]string{}, nil)
+	generatedPoint, err := testFindInsertionPoints(planInsertionPoint, stepSelectionSet, result, [][]string{})
 	if err != nil {
 		t.Error(t, err)
 		return
@@ -1997,6 +2031,167 @@ func TestFindInsertionPoint_bailOnNil(t *testing.T) {
 	assert.Equal(t, expected, generatedPoint)
 }
 
+func TestFindInsertionPoint_listTypes(t *testing.T) {
+	user := ast.NamedType("User", &ast.Position{})
+	nonNullUser := ast.NonNullNamedType("User", &ast.Position{})
+
+	table := []struct {
+		name     string
+		listType *ast.Type
+		value    []interface{}
+		expected [][]string
+	}{
+		{
+			"[User]!",
+			ast.NonNullListType(user, &ast.Position{}),
+			[]interface{}{map[string]interface{}{"id": "1"}, nil, map[string]interface{}{"id": "3"}},
+			[][]string{{"users:0#1"}, {"users:2#3"}},
+		},
+		{
+			"[User!]!",
+			ast.NonNullListType(nonNullUser, &ast.Position{}),
+			[]interface{}{map[string]interface{}{"id": "1"}, map[string]interface{}{"id": "2"}},
+			[][]string{{"users:0#1"}, {"users:1#2"}},
+		},
+		{
+			"[[User]]",
+			ast.ListType(ast.ListType(user, &ast.Position{}), &ast.Position{}),
+			[]interface{}{
+				[]interface{}{map[string]interface{}{"id": "1"}, map[string]interface{}{"id": "2"}},
+				nil,
+				[]interface{}{nil, map[string]interface{}{"id": "3"}},
+			},
+			[][]string{{"users:0:0#1"}, {"users:0:1#2"}, {"users:2:1#3"}},
+		},
+		{
+			"[[User!]!]!",
+			ast.NonNullListType(ast.NonNullListType(nonNullUser, &ast.Position{}), &ast.Position{}),
+			[]interface{}{
+				[]interface{}{map[string]interface{}{"id": "1"}},
+				[]interface{}{map[string]interface{}{"id": "2"}},
+			},
+			[][]string{{"users:0:0#1"}, {"users:1:0#2"}},
+		},
+	}
+
+	for _, row := range table {
+		t.Run(row.name, func(t *testing.T) {
+			selectionSet := ast.SelectionSet{
+				&ast.Field{
+					Name:       "users",
+					Alias:      "users",
+					Definition: &ast.FieldDefinition{Type: row.listType},
+					SelectionSet: ast.SelectionSet{
+						&ast.Field{
+							Name:       "id",
+							Alias:      "id",
+							Definition: &ast.FieldDefinition{Type: ast.NamedType("ID", &ast.Position{})},
+						},
+					},
+				},
+			}
+			result := map[string]interface{}{"users": row.value}
+
+			points, err := testFindInsertionPoints([]string{"users"}, selectionSet, result, [][]string{{}})
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, row.expected, points)
+
+			// every point has to lead back to the object it was made from
+			for _, point := range points {
+				value, err := testExtractValue(result, point)
+				if !assert.Nil(t, err) {
+					return
+				}
+				pointData, _ := ParsePathPoint(point[0])
+				assert.Equal(t, pointData.ID, value.(map[string]interface{})["id"])
+			}
+		})
+	}
+}
+
+func TestExecutor_insertIntoListOfLists(t *testing.T) {
+	analyticsSchema, _ := graphql.LoadSchema(`
+		type Cell {
+			id: ID!
+			value: Int!
+		}
+
+		type Query {
+			grid: [[Cell!]!]!
+		}
+	`)
+	labelsSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Cell implements Node {
+			id: ID!
+			label: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			labelCount: Int!
+		}
+	`)
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			if url == "analytics" {
+				return map[string]interface{}{
+					"grid": []interface{}{
+						[]interface{}{
+							map[string]interface{}{"value": 1, gatewayIDAlias: "a"},
+							map[string]interface{}{"value": 2, gatewayIDAlias: "b"},
+						},
+						[]interface{}{
+							map[string]interface{}{"value": 3, gatewayIDAlias: "c"},
+						},
+					},
+				}, nil
+			}
+
+			// the labels service is asked for each cell on its own
+			return map[string]interface{}{
+				gatewayNodeAlias: map[string]interface{}{"label": fmt.Sprintf("cell %v", input.Variables[gatewayIDAlias])},
+			}, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: "analytics", Schema: analyticsSchema},
+		{URL: "labels", Schema: labelsSchema},
+	}, WithQueryerFactory(&factory))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx := &RequestContext{Context: context.Background(), Query: `{ grid { value label } }`}
+	plans, err := gateway.GetPlans(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	result, err := gateway.Execute(ctx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"grid": []interface{}{
+			[]interface{}{
+				map[string]interface{}{"value": 1, "label": "cell a"},
+				map[string]interface{}{"value": 2, "label": "cell b"},
+			},
+			[]interface{}{
+				map[string]interface{}{"value": 3, "label": "cell c"},
+			},
+		},
+	}, result)
+}
+
 func TestFindInsertionPoint_stitchIntoObject(t *testing.T) {
 	// we want the list of insertion points that point to
 	planInsertionPoint := []string{"users", "photoGallery", "author"}
@@ -2064,7 +2259,7 @@ func TestFindInsertionPoint_stitchIntoObject(t *testing.T) {
 		},
 	}
 
-	generatedPoint, err := executorFindInsertionPoints(&sync.Mutex{}, planInsertionPoint, stepSelectionSet, result, startingPoint, nil)
+	generatedPoint, err := testFindInsertionPoints(planInsertionPoint, stepSelectionSet, result, startingPoint)
 	if err != nil {
 		t.Error(t, err)
 		return
@@ -2073,8 +2268,141 @@ func TestFindInsertionPoint_stitchIntoObject(t *testing.T) {
 	assert.Equal(t, finalInsertionPoint, generatedPoint)
 }
 
+func TestFindInsertionPoint_connection(t *testing.T) {
+	// the id of the objects we're stitching into is found under the node of each edge in the connection
+	planInsertionPoint := []string{"users", "list", "node"}
+
+	// the edges are aliased and one of them is missing
+	finalInsertionPoint := [][]string{
+		{"users", "list:0", "node#1"},
+		{"users", "list:2", "node#3"},
+	}
+
+	// the selection we're going to make
+	stepSelectionSet := ast.SelectionSet{
+		&ast.Field{
+			Name:  "users",
+			Alias: "users",
+			Definition: &ast.FieldDefinition{
+				Type: ast.NamedType("UserConnection", &ast.Position{}),
+			},
+			SelectionSet: ast.SelectionSet{
+				&ast.Field{
+					Name:  "edges",
+					Alias: "list",
+					Definition: &ast.FieldDefinition{
+						Type: ast.ListType(ast.NamedType("UserEdge", &ast.Position{}), &ast.Position{}),
+					},
+					SelectionSet: ast.SelectionSet{
+						&ast.Field{
+							Name:  "node",
+							Alias: "node",
+							Definition: &ast.FieldDefinition{
+								Type: ast.NamedType("User", &ast.Position{}),
+							},
+							SelectionSet: ast.SelectionSet{
+								&ast.Field{
+									Name:  "id",
+									Alias: gatewayIDAlias,
+									Definition: &ast.FieldDefinition{
+										Type: ast.NonNullNamedType("ID", &ast.Position{}),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// the result of the step
+	result := map[string]interface{}{
+		"users": map[string]interface{}{
+			"list": []interface{}{
+				map[string]interface{}{
+					"node": map[string]interface{}{gatewayIDAlias: "1"},
+				},
+				nil,
+				map[string]interface{}{
+					"node": map[string]interface{}{gatewayIDAlias: "3"},
+				},
+			},
+		},
+	}
+
+	generatedPoint, err := testFindInsertionPoints(planInsertionPoint, stepSelectionSet, result, [][]string{{}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, finalInsertionPoint, generatedPoint)
+}
+
 func TestFindInsertionPoint_handlesNullObjects(t *testing.T) {
-	t.Skip("Not yet implemented")
+	// the step is inserted into the photo of every user
+	planInsertionPoint := []string{"users", "photo"}
+
+	// the selection we're going to make
+	stepSelectionSet := ast.SelectionSet{
+		&ast.Field{
+			Name:  "users",
+			Alias: "users",
+			Definition: &ast.FieldDefinition{
+				Type: ast.ListType(ast.NamedType("User", &ast.Position{}), &ast.Position{}),
+			},
+			SelectionSet: ast.SelectionSet{
+				&ast.Field{
+					Name:  "photo",
+					Alias: "photo",
+					Definition: &ast.FieldDefinition{
+						Type: ast.NamedType("Photo", &ast.Position{}),
+					},
+					SelectionSet: ast.SelectionSet{
+						&ast.Field{
+							Name:  "id",
+							Alias: gatewayIDAlias,
+							Definition: &ast.FieldDefinition{
+								Type: ast.NonNullNamedType("ID", &ast.Position{}),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// a user without a photo and a user that is null don't have anything to insert into
+	result := map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{
+				"photo": map[string]interface{}{gatewayIDAlias: "1"},
+			},
+			map[string]interface{}{
+				"photo": nil,
+			},
+			nil,
+			map[string]interface{}{
+				"photo": map[string]interface{}{gatewayIDAlias: "4"},
+			},
+		},
+	}
+
+	generatedPoint, err := testFindInsertionPoints(planInsertionPoint, stepSelectionSet, result, [][]string{{}})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, [][]string{
+		{"users:0", "photo#1"},
+		{"users:3", "photo#4"},
+	}, generatedPoint)
+
+	// the same goes for a list that is null
+	generatedPoint, err = testFindInsertionPoints(planInsertionPoint, stepSelectionSet, map[string]interface{}{"users": nil}, [][]string{{}})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, [][]string{}, generatedPoint)
 }
 
 func TestSingleObjectWithColonInID(t *testing.T) {
@@ -2085,7 +2413,7 @@ func TestSingleObjectWithColonInID(t *testing.T) {
 		&source,
 	)
 
-	value, err := executorExtractValue(source, &sync.Mutex{}, []string{"hello#Thing:1337"})
+	value, err := testExtractValue(source, []string{"hello#Thing:1337"})
 	if err != nil {
 		t.Error(err.Error())
 		return
@@ -2273,3 +2601,456 @@ func TestExecutor_plansWithManyDeepDependencies(t *testing.T) {
 		},
 	}, result)
 }
+
+func TestExecutor_memoizesNodeLookups(t *testing.T) {
+	orderSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Product implements Node {
+			id: ID!
+		}
+
+		type Order implements Node {
+			id: ID!
+			product: Product!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			orders: [Order!]!
+		}
+	`)
+	productSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Product implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	reviewSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Review {
+			body: String!
+		}
+
+		type Product implements Node {
+			id: ID!
+			reviews: [Review!]!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	// every order points to one of two products
+	orders := []interface{}{}
+	for i := 0; i < 6; i++ {
+		orders = append(orders, map[string]interface{}{
+			"product": map[string]interface{}{gatewayIDAlias: fmt.Sprintf("product-%v", i%2)},
+		})
+	}
+
+	// count the number of times each service is asked about each object
+	requestsLock := &sync.Mutex{}
+	requests := map[string]int{}
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			requestsLock.Lock()
+			requests[fmt.Sprintf("%s:%v", url, input.Variables[gatewayIDAlias])]++
+			requestsLock.Unlock()
+
+			switch url {
+			case "orders":
+				return map[string]interface{}{"orders": orders}, nil
+			case "products":
+				return map[string]interface{}{
+					gatewayNodeAlias: map[string]interface{}{
+						"name": fmt.Sprintf("name of %v", input.Variables[gatewayIDAlias]),
+					},
+				}, nil
+			default:
+				return map[string]interface{}{
+					gatewayNodeAlias: map[string]interface{}{
+						"reviews": []interface{}{
+							map[string]interface{}{"body": fmt.Sprintf("review of %v", input.Variables[gatewayIDAlias])},
+						},
+					},
+				}, nil
+			}
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: "orders", Schema: orderSchema},
+		{URL: "products", Schema: productSchema},
+		{URL: "reviews", Schema: reviewSchema},
+	}, WithQueryerFactory(&factory))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	reqCtx := &RequestContext{
+		Context: context.Background(),
+		Query:   `{ orders { product { name reviews { body } } } }`,
+	}
+	plans, err := gateway.GetPlans(reqCtx)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	result, err := gateway.Execute(reqCtx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// each product was only looked up once per service
+	assert.Equal(t, map[string]int{
+		"orders:<nil>":       1,
+		"products:product-0": 1,
+		"products:product-1": 1,
+		"reviews:product-0":  1,
+		"reviews:product-1":  1,
+	}, requests)
+
+	// but every order got its product
+	resultOrders := result["orders"].([]interface{})
+	if !assert.Len(t, resultOrders, 6) {
+		return
+	}
+	for i, order := range resultOrders {
+		id := fmt.Sprintf("product-%v", i%2)
+
+		assert.Equal(t, map[string]interface{}{
+			"product": map[string]interface{}{
+				"name": "name of " + id,
+				"reviews": []interface{}{
+					map[string]interface{}{"body": "review of " + id},
+				},
+			},
+		}, order)
+	}
+}
+
+func TestExecutor_errorWithManySiblings(t *testing.T) {
+	// the goroutines that were running before we started
+	before := runtime.NumGoroutine()
+
+	// 50 steps at the root of the plan and the third one fails
+	steps := []*QueryPlanStep{}
+	for i := 0; i < 50; i++ {
+		field := fmt.Sprintf("value%v", i)
+		failed := i == 2
+
+		steps = append(steps, &QueryPlanStep{
+			ParentType: "Query",
+			SelectionSet: ast.SelectionSet{
+				&ast.Field{
+					Name: field,
+					Definition: &ast.FieldDefinition{
+						Type: ast.NamedType("String", &ast.Position{}),
+					},
+				},
+			},
+			Queryer: graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+				if failed {
+					return nil, errors.New("step failed")
+				}
+				return map[string]interface{}{field: "hello"}, nil
+			}),
+		})
+	}
+
+	done := make(chan bool)
+	var result map[string]interface{}
+	var err error
+	go func() {
+		result, err = (&ParallelExecutor{}).Execute(&ExecutionContext{
+			RequestContext: context.Background(),
+			Plan:           &QueryPlan{RootStep: &QueryPlanStep{Then: steps}},
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("executor did not return")
+	}
+
+	// the error is reported and the rest of the steps still make it
+	if list, ok := err.(graphql.ErrorList); assert.True(t, ok) {
+		assert.Len(t, list, 1)
+	}
+	assert.Len(t, result, 49)
+
+	// nothing should be left running once we're done
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+// testFindInsertionPoints finds the insertion points of a step starting from each of the points
+// and returns them in their string form
+func testFindInsertionPoints(target []string, selectionSet ast.SelectionSet, result map[string]interface{}, startingPoints [][]string) ([][]string, error) {
+	// no starting points means we start at the root
+	if len(startingPoints) == 0 {
+		startingPoints = [][]string{{}}
+	}
+
+	points := [][]string{}
+	for _, startingPoint := range startingPoints {
+		start, err := ParseInsertionPoint(startingPoint)
+		if err != nil {
+			return nil, err
+		}
+
+		found, err := NewStitcher(nil).FindInsertionPoints(target, selectionSet, nil, result, start)
+		if err != nil {
+			return nil, err
+		}
+		for _, point := range found {
+			points = append(points, point.Strings())
+		}
+	}
+
+	return points, nil
+}
+
+// testExtractValue returns the value at the string form of an insertion point
+func testExtractValue(source map[string]interface{}, point []string) (interface{}, error) {
+	parsed, err := ParseInsertionPoint(point)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStitcher(nil).Extract(source, parsed)
+}
+
+// testInsertObject inserts the value at the string form of an insertion point
+func testInsertObject(source map[string]interface{}, point []string, value interface{}) error {
+	parsed, err := ParseInsertionPoint(point)
+	if err != nil {
+		return err
+	}
+
+	return NewStitcher(nil).Insert(source, parsed, value)
+}
+
+// executorBenchmarkServices starts the services of a plan with a step for each of them. Each one sends back
+// a response with a few hundred values, like a real service would.
+func executorBenchmarkServices(tb testing.TB) ([]*graphql.RemoteSchema, func()) {
+	values := func(prefix string, count int) []interface{} {
+		list := []interface{}{}
+		for i := 0; i < count; i++ {
+			list = append(list, map[string]interface{}{"id": fmt.Sprintf("%s-%d", prefix, i), "score": float64(i) / 4})
+		}
+		return list
+	}
+
+	service := func(typeDefs string, data map[string]interface{}) (*graphql.RemoteSchema, *httptest.Server) {
+		schema, err := graphql.LoadSchema(typeDefs)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		response, _ := json.Marshal(map[string]interface{}{"data": data})
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ioutil.ReadAll(r.Body)
+			w.Write(response)
+		}))
+		return &graphql.RemoteSchema{Schema: schema, URL: server.URL}, server
+	}
+
+	users, usersServer := service(`
+		interface Node { id: ID! }
+		type Entry { id: ID! score: Float! }
+		type User implements Node { id: ID! name: String! tags: [Entry!]! }
+		type Query { viewer: User! node(id: ID!): Node }
+	`, map[string]interface{}{"viewer": map[string]interface{}{"id": "1", gatewayIDAlias: "1", "name": "alec", "tags": values("tag", 200)}})
+	posts, postsServer := service(`
+		interface Node { id: ID! }
+		type Entry { id: ID! score: Float! }
+		type User implements Node { id: ID! posts: [Entry!]! }
+		type Query { node(id: ID!): Node }
+	`, map[string]interface{}{gatewayNodeAlias: map[string]interface{}{"posts": values("post", 200)}})
+	photos, photosServer := service(`
+		interface Node { id: ID! }
+		type Entry { id: ID! score: Float! }
+		type User implements Node { id: ID! photos: [Entry!]! }
+		type Query { node(id: ID!): Node }
+	`, map[string]interface{}{gatewayNodeAlias: map[string]interface{}{"photos": values("photo", 200)}})
+
+	return []*graphql.RemoteSchema{users, posts, photos}, func() {
+		usersServer.Close()
+		postsServer.Close()
+		photosServer.Close()
+	}
+}
+
+func BenchmarkExecute_threeSteps(b *testing.B) {
+	sources, closeServices := executorBenchmarkServices(b)
+	defer closeServices()
+
+	gateway, err := New(sources)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	query := `{ viewer { name tags { id score } posts { id score } photos { id score } } }`
+	plans, err := gateway.GetPlans(&RequestContext{Context: context.Background(), Query: query})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if steps := len(plans[0].RootStep.Then) + len(plans[0].RootStep.Then[0].Then); steps != 3 {
+		b.Fatalf("expected 3 steps, found %d", steps)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := gateway.Execute(&RequestContext{Context: context.Background(), Query: query}, plans); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestExecutor_listInsertionOrder(t *testing.T) {
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			users: [User!]!
+		}
+	`)
+	profilesSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Profile implements Node {
+			id: ID!
+			bio: String!
+		}
+
+		type User implements Node {
+			id: ID!
+			profile: Profile!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	avatarsSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Profile implements Node {
+			id: ID!
+			avatar: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	// a few of the users show up twice so some of the lookups are shared between the entries
+	users := []interface{}{}
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("user-%v", i%90)
+		users = append(users, map[string]interface{}{"id": id, gatewayIDAlias: id})
+	}
+
+	// the lookups finish in whatever order the sleeps put them in
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			id, _ := input.Variables[gatewayIDAlias].(string)
+			time.Sleep(time.Duration(len(id)*37%5) * 100 * time.Microsecond)
+
+			switch url {
+			case "users":
+				return map[string]interface{}{"users": users}, nil
+			case "profiles":
+				return map[string]interface{}{
+					gatewayNodeAlias: map[string]interface{}{
+						"profile": map[string]interface{}{
+							"bio":          "bio of " + id,
+							gatewayIDAlias: "profile-" + id,
+						},
+					},
+				}, nil
+			default:
+				return map[string]interface{}{
+					gatewayNodeAlias: map[string]interface{}{"avatar": "avatar of " + id},
+				}, nil
+			}
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: "users", Schema: usersSchema},
+		{URL: "profiles", Schema: profilesSchema},
+		{URL: "avatars", Schema: avatarsSchema},
+	}, WithQueryerFactory(&factory))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for i := 0; i < 20; i++ {
+		reqCtx := &RequestContext{
+			Context: context.Background(),
+			Query:   `{ users { id profile { bio avatar } } }`,
+		}
+		plans, err := gateway.GetPlans(reqCtx)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		result, err := gateway.Execute(reqCtx, plans)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// every entry got the objects that were looked up for it
+		resultUsers, ok := result["users"].([]interface{})
+		if !assert.True(t, ok) || !assert.Len(t, resultUsers, 100) {
+			return
+		}
+		for j, user := range resultUsers {
+			id := fmt.Sprintf("user-%v", j%90)
+
+			assert.Equal(t, map[string]interface{}{
+				"id": id,
+				"profile": map[string]interface{}{
+					"bio":    "bio of " + id,
+					"avatar": "avatar of profile-" + id,
+				},
+			}, user)
+		}
+	}
+}
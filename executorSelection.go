@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"context"
+)
+
+// A gateway can hold more than one executor so that a new way of executing plans can be tried out on some of
+// the traffic before it replaces the default. The executors are registered under a name and an ExecutorSelector
+// picks the one to use for each request:
+//
+//	gateway.New(sources,
+//		gateway.WithNamedExecutor("batching", batchingExecutor),
+//		gateway.WithExecutorSelector(func(ctx context.Context, ectx *gateway.ExecutionContext) string {
+//			return ectx.RequestedExecutor
+//		}),
+//	)
+//
+// Clients can ask for an executor with the executor extension of their request ({"extensions": {"executor":
+// "batching"}}) but it's up to the selector to honor it. A selector that returns an empty string or a name
+// that wasn't registered gets the default executor. The name of the executor that handled the request ends up
+// in its Stats so the two populations can be told apart.
+
+// DefaultExecutorName is the name reported in the stats of the requests handled by the default executor
+const DefaultExecutorName = "default"
+
+// ExecutorSelector returns the name of the executor that should execute a request. An empty string
+// selects the default executor.
+type ExecutorSelector func(ctx context.Context, ectx *ExecutionContext) string
+
+// WithNamedExecutor returns an Option that registers an executor under the designated name for an
+// ExecutorSelector to pick
+func WithNamedExecutor(name string, e Executor) Option {
+	return func(g *Gateway) {
+		if g.namedExecutors == nil {
+			g.namedExecutors = map[string]Executor{}
+		}
+		g.namedExecutors[name] = e
+	}
+}
+
+// WithExecutorSelector returns an Option that consults the selector for every request to pick the
+// executor it should be handled by
+func WithExecutorSelector(selector ExecutorSelector) Option {
+	return func(g *Gateway) {
+		g.executorSelector = selector
+	}
+}
+
+// selectExecutor returns the executor for the request along with its name
+func (g *Gateway) selectExecutor(ectx *ExecutionContext) (Executor, string) {
+	if g.executorSelector == nil {
+		return g.executor, DefaultExecutorName
+	}
+
+	name := g.executorSelector(ectx.RequestContext, ectx)
+	if name == "" || name == DefaultExecutorName {
+		return g.executor, DefaultExecutorName
+	}
+
+	executor, ok := g.namedExecutors[name]
+	if !ok {
+		log.Warn("Could not find executor ", name, ". Using the default one instead")
+		return g.executor, DefaultExecutorName
+	}
+
+	return executor, name
+}
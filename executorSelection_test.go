@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+// the context key that marks the requests that opted into the canary
+type executorSelectionCanaryKey struct{}
+
+func TestGateway_executorSelection(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+
+	// the default executor goes to the service
+	var defaultCount int64
+	service := graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		atomic.AddInt64(&defaultCount, 1)
+		return map[string]interface{}{"value": "default"}, nil
+	})
+
+	// while the canary keeps to itself
+	var canaryCount int64
+	canary := ExecutorFunc(func(ctx *ExecutionContext) (map[string]interface{}, error) {
+		atomic.AddInt64(&canaryCount, 1)
+		return map[string]interface{}{"value": "canary"}, nil
+	})
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return service
+	})
+	gw, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "service"}},
+		WithQueryerFactory(&factory),
+		WithNamedExecutor("canary", canary),
+		WithExecutorSelector(func(ctx context.Context, ectx *ExecutionContext) string {
+			if ctx.Value(executorSelectionCanaryKey{}) != nil {
+				return "canary"
+			}
+			return ectx.RequestedExecutor
+		}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the requests with the header opt in
+	handler := gw.Handler(HandlerMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Canary") == "1" {
+				r = r.WithContext(context.WithValue(r.Context(), executorSelectionCanaryKey{}, true))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}))
+
+	// send sends the body and returns the value in the response along with the executor in the stats
+	send := func(body string, canary bool) (interface{}, string) {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set(StatsHeader, "1")
+		if canary {
+			request.Header.Set("X-Canary", "1")
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		result := struct {
+			Data       map[string]interface{} `json:"data"`
+			Extensions map[string]*Stats      `json:"extensions"`
+		}{}
+		if !assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &result)) || !assert.NotNil(t, result.Extensions[statsExtension]) {
+			return nil, ""
+		}
+		return result.Data["value"], result.Extensions[statsExtension].Executor
+	}
+
+	for i := 0; i < 3; i++ {
+		value, executor := send(`{"query": "{ value }"}`, true)
+		assert.Equal(t, "canary", value)
+		assert.Equal(t, "canary", executor)
+
+		value, executor = send(`{"query": "{ value }"}`, false)
+		assert.Equal(t, "default", value)
+		assert.Equal(t, DefaultExecutorName, executor)
+	}
+	assert.Equal(t, int64(3), atomic.LoadInt64(&canaryCount))
+	assert.Equal(t, int64(3), atomic.LoadInt64(&defaultCount))
+
+	// the selector can go with what the client asked for
+	value, executor := send(`{"query": "{ value }", "extensions": {"executor": "canary"}}`, false)
+	assert.Equal(t, "canary", value)
+	assert.Equal(t, "canary", executor)
+
+	// and an executor that doesn't exist leaves the request with the default one
+	value, executor = send(`{"query": "{ value }", "extensions": {"executor": "unknown"}}`, false)
+	assert.Equal(t, "default", value)
+	assert.Equal(t, DefaultExecutorName, executor)
+	assert.Equal(t, int64(4), atomic.LoadInt64(&canaryCount))
+	assert.Equal(t, int64(4), atomic.LoadInt64(&defaultCount))
+}
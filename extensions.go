@@ -0,0 +1,224 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nautilus/graphql"
+)
+
+// QueryerWithExtensions is a Queryer that can hand back the extensions of the response along with the data.
+// Queryers that don't implement it only have their extensions captured if they send their requests with the
+// client the gateway gives them.
+type QueryerWithExtensions interface {
+	QueryWithExtensions(ctx context.Context, input *graphql.QueryInput, receiver interface{}) (map[string]interface{}, error)
+}
+
+// ExtensionsMerger computes the extensions of the gateway's response from the extensions sent back by each
+// service, indexed by url
+type ExtensionsMerger func(ctx context.Context, extensions map[string]map[string]interface{}) map[string]interface{}
+
+// WithExtensionsMerger returns an Option that changes how the extensions of the services are combined
+// into the gateway's response
+func WithExtensionsMerger(merger ExtensionsMerger) Option {
+	return func(g *Gateway) {
+		g.extensionsMerger = merger
+	}
+}
+
+// NamespaceExtensions is the default ExtensionsMerger. It puts the extensions of every service under
+// extensions.downstream[<service url>].
+func NamespaceExtensions(ctx context.Context, extensions map[string]map[string]interface{}) map[string]interface{} {
+	if len(extensions) == 0 {
+		return nil
+	}
+
+	return map[string]interface{}{"downstream": extensions}
+}
+
+// mergeExtensions returns the extensions to add to the response
+func (g *Gateway) mergeExtensions(ctx context.Context, extensions map[string]map[string]interface{}) map[string]interface{} {
+	if g.extensionsMerger == nil {
+		return NamespaceExtensions(ctx, extensions)
+	}
+
+	return g.extensionsMerger(ctx, extensions)
+}
+
+// addServiceExtensions adds the extensions of the services to the ones that will be sent to the client
+func (g *Gateway) addServiceExtensions(ctx *ExecutionContext) {
+	if ctx.Extensions == nil {
+		ctx.Extensions = map[string]interface{}{}
+	}
+
+	for key, value := range g.mergeExtensions(ctx.RequestContext, ctx.ServiceExtensions) {
+		if _, ok := ctx.Extensions[key]; !ok {
+			ctx.Extensions[key] = value
+		}
+	}
+}
+
+// executorAddExtensions adds the extensions of a response from the service at location to the ones we've
+// already seen. A service that is sent more than one query has the keys of its later responses take precedence.
+func executorAddExtensions(acc map[string]map[string]interface{}, location string, extensions map[string]interface{}) {
+	if len(extensions) == 0 || location == internalSchemaLocation {
+		return
+	}
+
+	if _, ok := acc[location]; !ok {
+		acc[location] = map[string]interface{}{}
+	}
+	for key, value := range extensions {
+		acc[location][key] = value
+	}
+}
+
+// extensionsCollectorKey is the context key for the place a request's extensions are written to
+type extensionsCollectorKey struct{}
+
+// extensionsCollector holds the extensions of the response to a request. If the queryer would change some of
+// the numbers in the response, it also holds the data with its numbers intact.
+type extensionsCollector struct {
+	mutex      sync.Mutex
+	extensions map[string]interface{}
+	data       map[string]interface{}
+}
+
+func (c *extensionsCollector) set(extensions map[string]interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.extensions = extensions
+}
+
+func (c *extensionsCollector) get() map[string]interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.extensions
+}
+
+func (c *extensionsCollector) setData(data map[string]interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.data = data
+}
+
+func (c *extensionsCollector) getData() map[string]interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.data
+}
+
+// extensionsTransport is a http.RoundTripper that pulls the extensions out of a response before the queryer
+// throws them away. They are written to the collector in the context of the request, if there is one. Since
+// it sees the body of every response, it also turns the ones that aren't GraphQL responses into errors.
+type extensionsTransport struct {
+	base http.RoundTripper
+}
+
+func (t *extensionsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	response, err := base.RoundTrip(r)
+	if err != nil {
+		return response, err
+	}
+
+	// read the body so we can look at it. The buffer goes back to the pool once nothing points into it
+	buffer := responseBuffers.get()
+	_, err = buffer.ReadFrom(response.Body)
+	response.Body.Close()
+	if err != nil {
+		responseBuffers.put(buffer)
+		return nil, err
+	}
+	body := buffer.Bytes()
+
+	// the size of the request and response count towards the service
+	if stats := serviceStats(r.Context()); stats != nil {
+		if r.ContentLength > 0 {
+			atomic.AddInt64(&stats.BytesSent, r.ContentLength)
+		}
+		atomic.AddInt64(&stats.BytesReceived, int64(len(body)))
+	}
+
+	// the audit gets the response before anything is done with it
+	if audit, ok := r.Context().Value(auditStepKey{}).(*auditStepRecorder); ok {
+		audit.setBody(body)
+	}
+
+	// a service that sent its response in parts (ie, for @defer or @stream) gets it put back together
+	reassembled, ok, err := incrementalReassemble(response.Header.Get("Content-Type"), body)
+	if err != nil {
+		responseBuffers.put(buffer)
+		return nil, err
+	}
+	if ok {
+		body = reassembled
+		response.Header.Set("Content-Type", "application/json")
+	}
+
+	decoded, err := decodeServiceResponse(r.URL.String(), response.StatusCode, body)
+	if err != nil {
+		responseBuffers.put(buffer)
+		return nil, err
+	}
+
+	// the executor reads the data out of the collector so the queryer only has to see the errors. That saves it
+	// from reading and decoding the whole response again.
+	collector, ok := r.Context().Value(extensionsCollectorKey{}).(*extensionsCollector)
+	if ok && decoded != nil {
+		responseBuffers.put(buffer)
+
+		if len(decoded.Extensions) > 0 {
+			collector.set(decoded.Extensions)
+		}
+		collector.setData(decoded.Data)
+
+		replacement := emptyServiceResponse
+		if decoded.hasErrors() {
+			replacement = append(append([]byte(`{"errors":`), decoded.Errors...), '}')
+		}
+		response.Body = ioutil.NopCloser(bytes.NewReader(replacement))
+		response.ContentLength = int64(len(replacement))
+		return response, nil
+	}
+
+	// most responses don't have extensions so there's no need to parse them
+	if ok && bytes.Contains(body, []byte(`"extensions"`)) {
+		envelope := struct {
+			Extensions map[string]interface{} `json:"extensions"`
+		}{}
+		if err := json.Unmarshal(body, &envelope); err == nil {
+			collector.set(envelope.Extensions)
+		}
+	}
+
+	// the queryer decodes every number as a float64, which changes the ones that don't fit
+	if ok && jsonHasImpreciseNumbers(body) {
+		envelope := struct {
+			Data map[string]interface{} `json:"data"`
+		}{}
+		if err := decodeJSON(body, &envelope); err == nil {
+			collector.setData(envelope.Data)
+		}
+	}
+
+	// the queryer reads the body we already have
+	response.Body = &pooledBody{Reader: bytes.NewReader(body), buffer: buffer}
+	return response, nil
+}
+
+// extensionsClient is the client used by the queryers the gateway creates
+var extensionsClient = &http.Client{Transport: &extensionsTransport{}}
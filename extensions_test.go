@@ -0,0 +1,173 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_downstreamExtensions(t *testing.T) {
+	// service returns a server for the schema that sends back the designated extensions
+	service := func(typeDefs string, data map[string]interface{}, extensions map[string]interface{}) *httptest.Server {
+		schema, _ := graphql.LoadSchema(typeDefs)
+		introspection, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "service"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if strings.Contains(string(body), "__schema") {
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+				introspection.GraphQLHandler(w, r)
+				return
+			}
+
+			response, _ := json.Marshal(map[string]interface{}{"data": data, "extensions": extensions})
+			w.Write(response)
+		}))
+	}
+
+	users := service(`
+		type Query {
+			user: String!
+		}
+	`, map[string]interface{}{"user": "alice"}, map[string]interface{}{"cost": 2.0})
+	defer users.Close()
+
+	photos := service(`
+		type Query {
+			photo: String!
+		}
+	`, map[string]interface{}{"photo": "cat.jpg"}, map[string]interface{}{"cost": 3.0, "warning": "deprecated"})
+	defer photos.Close()
+
+	sources := []*graphql.RemoteSchema{{URL: users.URL}, {URL: photos.URL}}
+
+	// query sends the query through the http handler and returns the response
+	query := func(gateway *Gateway) map[string]interface{} {
+		request := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ user photo }"}`))
+		response := httptest.NewRecorder()
+		gateway.GraphQLHandler(response, request)
+
+		result := map[string]interface{}{}
+		if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// by default, the extensions of each service are put under its url
+	gateway, err := New(sources)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{
+		"downstream": map[string]interface{}{
+			users.URL:  map[string]interface{}{"cost": 2.0},
+			photos.URL: map[string]interface{}{"cost": 3.0, "warning": "deprecated"},
+		},
+	}, query(gateway)["extensions"])
+
+	// but they can be combined however the user wants
+	gateway, err = New(sources, WithExtensionsMerger(func(ctx context.Context, extensions map[string]map[string]interface{}) map[string]interface{} {
+		cost := 0.0
+		for _, serviceExtensions := range extensions {
+			cost += serviceExtensions["cost"].(float64)
+		}
+		return map[string]interface{}{"cost": cost}
+	}))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{"cost": 5.0}, query(gateway)["extensions"])
+}
+
+func TestExtensionsTransport_concurrentResponses(t *testing.T) {
+	// both services send back values made from the id they were given, so a response that was mixed up with
+	// another one would have the wrong values
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node { id: ID! }
+		type User implements Node { id: ID! name: String! }
+		type Query { user(id: ID!): User node(id: ID!): Node }
+	`)
+	postsSchema, _ := graphql.LoadSchema(`
+		interface Node { id: ID! }
+		type User implements Node { id: ID! posts: [String!]! }
+		type Query { node(id: ID!): Node }
+	`)
+
+	service := func(respond func(id string) map[string]interface{}) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			input := &graphql.QueryInput{}
+			json.NewDecoder(r.Body).Decode(input)
+
+			id, _ := input.Variables["id"].(string)
+			if id == "" {
+				id, _ = input.Variables[gatewayIDAlias].(string)
+			}
+
+			// the responses have different sizes so the buffers are reused for bodies of every size
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": respond(id)})
+		}))
+	}
+	users := service(func(id string) map[string]interface{} {
+		return map[string]interface{}{"user": map[string]interface{}{gatewayIDAlias: id, "name": strings.Repeat(id, len(id)*10)}}
+	})
+	defer users.Close()
+	posts := service(func(id string) map[string]interface{} {
+		return map[string]interface{}{gatewayNodeAlias: map[string]interface{}{"posts": []interface{}{id + "-1", id + "-2"}}}
+	})
+	defer posts.Close()
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: usersSchema, URL: users.URL},
+		{Schema: postsSchema, URL: posts.URL},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				id := fmt.Sprintf("user%d", i*(j+1))
+				ctx := &RequestContext{
+					Context:   context.Background(),
+					Query:     `query($id: ID!) { user(id: $id) { name posts } }`,
+					Variables: map[string]interface{}{"id": id},
+				}
+				plans, err := gateway.GetPlans(ctx)
+				if !assert.Nil(t, err) {
+					return
+				}
+
+				result, err := gateway.Execute(ctx, plans)
+				if !assert.Nil(t, err) {
+					return
+				}
+				assert.Equal(t, map[string]interface{}{
+					"user": map[string]interface{}{
+						"name":  strings.Repeat(id, len(id)*10),
+						"posts": []interface{}{id + "-1", id + "-2"},
+					},
+				}, result)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
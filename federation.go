@@ -0,0 +1,310 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+	"github.com/vektah/gqlparser/v2/validator"
+
+	"github.com/nautilus/graphql"
+)
+
+// The gateway can consume services that follow the Apollo Federation specification alongside
+// services that follow the Node convention. Federated services are recognized by the @key
+// directives on their types (or the _entities field on their Query type). Instead of
+// node(id: $id), dependent steps that target a federated service are resolved with
+//
+//	_entities(representations: [{ __typename: "User", id: "1" }]) {
+//		... on User { ... }
+//	}
+//
+// Support is currently limited to entities with a single key field named id. @requires
+// and @provides are ignored.
+
+// federationDefinitions are the definitions that the SDL of a federated service assumes exist
+const federationDefinitions = `
+	scalar _Any
+	scalar _FieldSet
+
+	directive @external on FIELD_DEFINITION
+	directive @requires(fields: _FieldSet!) on FIELD_DEFINITION
+	directive @provides(fields: _FieldSet!) on FIELD_DEFINITION
+	directive @key(fields: _FieldSet!) on OBJECT | INTERFACE
+	directive @extends on OBJECT | INTERFACE
+`
+
+// federationTypes are the types that federated services add to their schema that should not be
+// merged into the gateway's schema
+var federationTypes = Set{"_Any": true, "_FieldSet": true, "_Entity": true, "_Service": true}
+
+// federationDirectives are the directives used by federated services to describe their entities
+var federationDirectives = Set{"external": true, "requires": true, "provides": true, "key": true, "extends": true}
+
+// EntityKeyMap holds the name of the key field that a federated service uses to identify an
+// entity, indexed by location and then type.
+type EntityKeyMap map[string]map[string]string
+
+// KeyFor returns the key field that the service at location uses for the type. If the service
+// is not federated, an empty string is returned.
+func (m EntityKeyMap) KeyFor(location string, typeName string) string {
+	if m == nil {
+		return ""
+	}
+
+	return m[location][typeName]
+}
+
+// LoadFederatedSchema parses the SDL of a federated service. Type extensions for types that are
+// not defined in the SDL (because they are owned by another service) are treated as definitions.
+func LoadFederatedSchema(sdl string) (*ast.Schema, error) {
+	document, err := parser.ParseSchemas(
+		validator.Prelude,
+		&ast.Source{Name: "federation", Input: federationDefinitions, BuiltIn: true},
+		&ast.Source{Name: "service", Input: sdl},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep track of the types that have a definition
+	defined := Set{}
+	for _, definition := range document.Definitions {
+		defined.Add(definition.Name)
+	}
+
+	// any extension without a definition becomes the definition
+	extensions := ast.DefinitionList{}
+	for _, extension := range document.Extensions {
+		if !defined.Has(extension.Name) {
+			defined.Add(extension.Name)
+			document.Definitions = append(document.Definitions, extension)
+			continue
+		}
+
+		extensions = append(extensions, extension)
+	}
+	document.Extensions = extensions
+
+	schema, err := validator.ValidateSchemaDocument(document)
+	if err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// IntrospectRemoteSchema introspects the service at the designated url. If the service is federated,
+// its schema is loaded from the SDL it exposes under _service so that the gateway can see its entity keys.
+func IntrospectRemoteSchema(url string, opts ...*graphql.IntrospectOptions) (*graphql.RemoteSchema, error) {
+	var queryer graphql.Queryer = graphql.NewSingleRequestQueryer(url)
+	for _, opt := range opts {
+		queryer = opt.Apply(queryer)
+	}
+
+	ctx := context.Background()
+	for _, opt := range opts {
+		ctx = opt.Context()
+	}
+
+	return introspectRemoteSchema(ctx, url, queryer)
+}
+
+// introspectRemoteSchema introspects the service behind the queryer, which already has any options applied
+func introspectRemoteSchema(ctx context.Context, url string, queryer graphql.Queryer) (*graphql.RemoteSchema, error) {
+	// introspect the schema at the designated url
+	schema, err := IntrospectAPI(queryer, graphql.IntrospectWithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	// if the service is not federated, we're done
+	if schema.Query == nil || schema.Query.Fields.ForName("_service") == nil {
+		return &graphql.RemoteSchema{URL: url, Schema: schema}, nil
+	}
+
+	// ask the service for its SDL
+	result := map[string]interface{}{}
+	err = queryer.Query(ctx, &graphql.QueryInput{Query: "{ _service { sdl } }"}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	service, ok := result["_service"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("could not find _service in response from %s", url)
+	}
+	sdl, ok := service["sdl"].(string)
+	if !ok {
+		return nil, fmt.Errorf("could not find the sdl of the service at %s", url)
+	}
+
+	federatedSchema, err := LoadFederatedSchema(sdl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &graphql.RemoteSchema{URL: url, Schema: federatedSchema}, nil
+}
+
+// IntrospectRemoteSchemas introspects each of the designated urls. The services are introspected at the
+// same time, see IntrospectRemoteSchemasWithOptions to control how.
+func IntrospectRemoteSchemas(urls ...string) ([]*graphql.RemoteSchema, error) {
+	return IntrospectRemoteSchemasWithOptions(urls)
+}
+
+// isFederatedSchema returns true if the schema follows the federation specification
+func isFederatedSchema(schema *ast.Schema) bool {
+	if schema.Query != nil && schema.Query.Fields.ForName("_entities") != nil {
+		return true
+	}
+
+	for _, definition := range schema.Types {
+		if definition.Directives.ForName("key") != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// federatedSources looks for federated services in the list of sources and returns a version of the
+// list where their schemas are stripped of the federation specifics, along with the keys of the entities
+// they define.
+func federatedSources(sources []*graphql.RemoteSchema) ([]*graphql.RemoteSchema, EntityKeyMap, error) {
+	result := []*graphql.RemoteSchema{}
+	keys := EntityKeyMap{}
+
+	for _, source := range sources {
+		// services that aren't federated are left alone
+		if !isFederatedSchema(source.Schema) {
+			result = append(result, source)
+			continue
+		}
+
+		schema, sourceKeys, err := stripFederation(source.Schema)
+		if err != nil {
+			return nil, nil, fmt.Errorf("encountered error loading federated service %s: %s", source.URL, err.Error())
+		}
+
+		keys[source.URL] = sourceKeys
+		result = append(result, &graphql.RemoteSchema{URL: source.URL, Schema: schema})
+	}
+
+	return result, keys, nil
+}
+
+// stripFederation returns a copy of the schema without any federation types, fields, or directives along
+// with the key of each entity
+func stripFederation(source *ast.Schema) (*ast.Schema, map[string]string, error) {
+	keys := map[string]string{}
+
+	schema := &ast.Schema{
+		Types:         map[string]*ast.Definition{},
+		Directives:    map[string]*ast.DirectiveDefinition{},
+		PossibleTypes: map[string][]*ast.Definition{},
+		Implements:    map[string][]*ast.Definition{},
+	}
+
+	for name, definition := range source.Types {
+		// federation types don't make it into the gateway
+		if federationTypes.Has(name) {
+			continue
+		}
+
+		// if the type is an entity we need to record its key
+		if key := definition.Directives.ForName("key"); key != nil {
+			fields := key.Arguments.ForName("fields")
+			if fields == nil || fields.Value == nil {
+				return nil, nil, fmt.Errorf("could not find the key fields of %s", name)
+			}
+
+			keyField := strings.TrimSpace(fields.Value.Raw)
+			if strings.ContainsAny(keyField, " {") {
+				return nil, nil, fmt.Errorf("%s has a composite key which is not supported", name)
+			}
+			if keyField != "id" {
+				return nil, nil, fmt.Errorf("%s is keyed by %s but entities must be keyed by id", name, keyField)
+			}
+
+			keys[name] = keyField
+		}
+
+		// copy the definition so we can modify it
+		copied := *definition
+		copied.Directives = stripFederationDirectives(definition.Directives)
+		copied.Fields = ast.FieldList{}
+
+		for _, field := range definition.Fields {
+			// the federation fields on the query type are for the gateway, not the user
+			if name == "Query" && (field.Name == "_service" || field.Name == "_entities") {
+				continue
+			}
+
+			// external fields are owned by another service
+			if field.Directives.ForName("external") != nil {
+				continue
+			}
+
+			copiedField := *field
+			copiedField.Directives = stripFederationDirectives(field.Directives)
+			copied.Fields = append(copied.Fields, &copiedField)
+		}
+
+		schema.Types[name] = &copied
+	}
+
+	// copy over the directives that aren't part of federation
+	for name, directive := range source.Directives {
+		if !federationDirectives.Has(name) {
+			schema.Directives[name] = directive
+		}
+	}
+
+	// rebuild the relationships between types
+	for name, definitions := range source.PossibleTypes {
+		if federationTypes.Has(name) {
+			continue
+		}
+		for _, definition := range definitions {
+			if copied, ok := schema.Types[definition.Name]; ok {
+				schema.AddPossibleType(name, copied)
+			}
+		}
+	}
+	for name, definitions := range source.Implements {
+		for _, definition := range definitions {
+			if federationTypes.Has(definition.Name) {
+				continue
+			}
+			if copied, ok := schema.Types[definition.Name]; ok {
+				schema.AddImplements(name, copied)
+			}
+		}
+	}
+
+	schema.Query = schema.Types["Query"]
+	schema.Mutation = schema.Types["Mutation"]
+	schema.Subscription = schema.Types["Subscription"]
+
+	// a federated service has to have something for the gateway to resolve
+	if schema.Query == nil && len(keys) == 0 {
+		return nil, nil, errors.New("service does not define any entities or query fields")
+	}
+
+	return schema, keys, nil
+}
+
+func stripFederationDirectives(directives ast.DirectiveList) ast.DirectiveList {
+	result := ast.DirectiveList{}
+	for _, directive := range directives {
+		if !federationDirectives.Has(directive.Name) {
+			result = append(result, directive)
+		}
+	}
+
+	return result
+}
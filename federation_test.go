@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFederatedSchema(t *testing.T) {
+	schema, err := LoadFederatedSchema(`
+		type Review {
+			body: String!
+		}
+
+		extend type User @key(fields: "id") {
+			id: ID! @external
+			reviews: [Review!]!
+		}
+	`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the extension should have been turned into a definition
+	user, ok := schema.Types["User"]
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.NotNil(t, user.Directives.ForName("key"))
+	assert.NotNil(t, user.Fields.ForName("reviews"))
+}
+
+func TestFederatedSources(t *testing.T) {
+	schema, err := LoadFederatedSchema(`
+		type Review {
+			body: String!
+		}
+
+		extend type User @key(fields: "id") {
+			id: ID! @external
+			reviews: [Review!]!
+		}
+	`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	sources, keys, err := federatedSources([]*graphql.RemoteSchema{{URL: "reviews", Schema: schema}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, "id", keys.KeyFor("reviews", "User"))
+	assert.Equal(t, "", keys.KeyFor("reviews", "Review"))
+
+	// the stripped schema should not have the external field or the federation directives
+	user := sources[0].Schema.Types["User"]
+	assert.Nil(t, user.Fields.ForName("id"))
+	assert.Nil(t, user.Directives.ForName("key"))
+	_, ok := sources[0].Schema.Types["_Any"]
+	assert.False(t, ok)
+
+	t.Run("Unsupported keys", func(t *testing.T) {
+		schema, err := LoadFederatedSchema(`
+			extend type User @key(fields: "email") {
+				email: String! @external
+				reviews: [String!]!
+			}
+		`)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		_, _, err = federatedSources([]*graphql.RemoteSchema{{URL: "reviews", Schema: schema}})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGateway_federatedService(t *testing.T) {
+	// a service following the node convention
+	userSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			firstName: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			allUsers: [User!]!
+		}
+	`)
+
+	// a federated service that adds reviews to users
+	reviewSchema, err := LoadFederatedSchema(`
+		type Review {
+			body: String!
+		}
+
+		extend type User @key(fields: "id") {
+			id: ID! @external
+			reviews: [Review!]!
+		}
+	`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		if url == "users" {
+			return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+				return map[string]interface{}{
+					"allUsers": []interface{}{
+						map[string]interface{}{"__gateway_id": "1", "firstName": "John"},
+					},
+				}, nil
+			})
+		}
+
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			// make sure we were sent the representation of the user
+			assert.True(t, strings.Contains(input.Query, "_entities(representations: $__gateway_representations)"))
+			assert.Equal(t, []interface{}{
+				map[string]interface{}{"__typename": "User", "id": "1"},
+			}, input.Variables["__gateway_representations"])
+
+			return map[string]interface{}{
+				"_entities": []interface{}{
+					map[string]interface{}{
+						"reviews": []interface{}{
+							map[string]interface{}{"body": "great"},
+						},
+					},
+				},
+			}, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: "users", Schema: userSchema},
+		{URL: "reviews", Schema: reviewSchema},
+	}, WithQueryerFactory(&factory))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	requestContext := &RequestContext{
+		Context: context.Background(),
+		Query:   `{ allUsers { firstName reviews { body } } }`,
+	}
+
+	plans, err := gateway.GetPlans(requestContext)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	result, err := gateway.Execute(requestContext, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"allUsers": []interface{}{
+			map[string]interface{}{
+				"firstName": "John",
+				"reviews": []interface{}{
+					map[string]interface{}{"body": "great"},
+				},
+			},
+		},
+	}, result)
+}
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// The fields that end up under the same key in the response are merged into one when the query is broken up into
+// steps, so the planner relies on them having the same arguments. The validation of the client's query makes sure
+// of that, but the planner changes the arguments after the query was validated (the propagated arguments and the
+// page sizes) and a field can be spread into the same object from a named fragment that was changed for another
+// parent. Merging those fields would send the arguments of one of them and answer both with the result. Once the
+// arguments have been rewritten, the planner looks for the fields that would be merged with different arguments
+// and refuses the query instead of returning the wrong data.
+
+// plannerCheckFieldConflicts returns an error if two fields under the same key of the response have different
+// arguments
+func plannerCheckFieldConflicts(document *ast.QueryDocument) error {
+	for _, operation := range document.Operations {
+		if err := plannerCheckSelectionConflicts(document.Fragments, []ast.SelectionSet{operation.SelectionSet}, ast.Path{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// plannerCheckSelectionConflicts checks the fields of the selection sets that are merged into the same object
+func plannerCheckSelectionConflicts(fragments ast.FragmentDefinitionList, selectionSets []ast.SelectionSet, path ast.Path) error {
+	// the fields of each key, in the order they were selected. The fields of different types can't be merged
+	// into the same object so they are kept apart.
+	keys := []string{}
+	fields := map[string][]*ast.Field{}
+	for _, selectionSet := range selectionSets {
+		for _, field := range executorSelectedFields(selectionSet, fragments) {
+			parentType := ""
+			if field.ObjectDefinition != nil {
+				parentType = field.ObjectDefinition.Name
+			}
+			key := parentType + "." + field.Alias
+			if _, ok := fields[key]; !ok {
+				keys = append(keys, key)
+			}
+			fields[key] = append(fields[key], field)
+		}
+	}
+
+	for _, key := range keys {
+		first := fields[key][0]
+		fieldPath := append(append(ast.Path{}, path...), ast.PathName(first.Alias))
+
+		nested := []ast.SelectionSet{}
+		for _, field := range fields[key] {
+			if field.Name != first.Name || plannerArgumentsKey(field.Arguments) != plannerArgumentsKey(first.Arguments) {
+				return gqlerror.ErrorPathf(fieldPath, `Fields "%s" conflict because they have differing arguments once the gateway applied its rules. Use different aliases on the fields.`, first.Alias)
+			}
+			nested = append(nested, field.SelectionSet)
+		}
+
+		if err := plannerCheckSelectionConflicts(fragments, nested, fieldPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// plannerArgumentsKey returns a string that is the same for the same arguments, no matter their order
+func plannerArgumentsKey(arguments ast.ArgumentList) string {
+	entries := []string{}
+	for _, argument := range arguments {
+		entries = append(entries, argument.Name+":"+argument.Value.String())
+	}
+	sort.Strings(entries)
+
+	return strings.Join(entries, ",")
+}
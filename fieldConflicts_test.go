@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+func TestGateway_fieldsWithDifferentArguments(t *testing.T) {
+	// posts returns as many posts as were asked for
+	posts := func(args map[string]interface{}) interface{} {
+		first, _ := args["first"].(int64)
+		result := []interface{}{}
+		for i := int64(0); i < first; i++ {
+			result = append(result, map[string]interface{}{"title": "post"})
+		}
+		return result
+	}
+
+	query := `{
+		user(id: 1) { posts(first: 5) { title } }
+		reviewer: user(id: 1) { posts(first: 50) { title } }
+		author: user(id: 1) { posts(first: 2) { title } recent: posts(first: 1) { title } }
+	}`
+
+	// lengths returns the number of posts under each key of the response
+	lengths := func(t *testing.T, sources []*graphql.RemoteSchema, options ...Option) map[string]int {
+		gateway, err := New(sources, options...)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+
+		ctx := &RequestContext{Context: context.Background(), Query: query}
+		plans, err := gateway.GetPlans(ctx)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		result, err := gateway.Execute(ctx, plans)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+
+		counts := map[string]int{}
+		for alias, user := range result {
+			for key, value := range user.(map[string]interface{}) {
+				counts[alias+"."+key] = len(value.([]interface{}))
+			}
+		}
+		return counts
+	}
+
+	expected := map[string]int{
+		"user.posts":     5,
+		"reviewer.posts": 50,
+		"author.posts":   2,
+		"author.recent":  1,
+	}
+
+	t.Run("One service", func(t *testing.T) {
+		schema, _ := graphql.LoadSchema(`
+			type Post {
+				title: String!
+			}
+
+			type User {
+				posts(first: Int!): [Post!]!
+			}
+
+			type Query {
+				user(id: ID!): User
+			}
+		`)
+
+		assert.Equal(t, expected, lengths(t,
+			[]*graphql.RemoteSchema{{URL: "users", Schema: schema}},
+			WithMockedService("users", MockOptions{Fields: map[string]MockFieldFunc{"User.posts": posts}}),
+		))
+	})
+
+	t.Run("Posts in another service", func(t *testing.T) {
+		usersSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type User implements Node {
+				id: ID!
+			}
+
+			type Query {
+				node(id: ID!): Node
+				user(id: ID!): User
+			}
+		`)
+		postsSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type Post {
+				title: String!
+			}
+
+			type User implements Node {
+				id: ID!
+				posts(first: Int!): [Post!]!
+			}
+
+			type Query {
+				node(id: ID!): Node
+			}
+		`)
+
+		// every user is the same object so the steps are only told apart by the arguments of posts
+		assert.Equal(t, expected, lengths(t,
+			[]*graphql.RemoteSchema{{URL: "users", Schema: usersSchema}, {URL: "posts", Schema: postsSchema}},
+			WithMockedService("users", MockOptions{}),
+			WithMockedService("posts", MockOptions{Fields: map[string]MockFieldFunc{"User.posts": posts}}),
+		))
+	})
+}
+
+func TestPlanQuery_propagatedArgumentConflicts(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Post {
+			title: String!
+		}
+
+		type User {
+			posts(status: String): [Post!]!
+			postCount(status: String): Int!
+		}
+
+		type Query {
+			user: User
+		}
+	`)
+
+	locations := FieldURLMap{}
+	locations.RegisterURL("Query", "user", "posts")
+	locations.RegisterURL("User", "posts", "posts")
+	locations.RegisterURL("Post", "title", "posts")
+	locations.RegisterURL("User", "postCount", "counts")
+
+	plan := func(query string) (QueryPlanList, error) {
+		return (&MinQueriesPlanner{}).Plan(&PlanningContext{
+			Query:                query,
+			Schema:               schema,
+			Locations:            locations,
+			ArgumentPropagations: []ArgumentPropagation{{Type: "User", Fields: []string{"posts", "postCount"}}},
+		})
+	}
+
+	// the posts in the fragment end up under the same key so they get the filter too
+	plans, err := plan(`{ user { posts { title } ... on User { posts { title } postCount(status: "draft") } } }`)
+	if assert.Nil(t, err) {
+		query := plans[0].RootStep.Then[0].QueryString
+		assert.Equal(t, 2, strings.Count(query, `posts(status: "draft")`), query)
+		assert.NotContains(t, query, "posts {")
+	}
+
+	// the filter can come from a named fragment
+	plans, err = plan(`{ user { posts { title } ...Counts } } fragment Counts on User { postCount(status: "draft") }`)
+	if assert.Nil(t, err) {
+		assert.Contains(t, plans[0].RootStep.Then[0].QueryString, `posts(status: "draft")`)
+	}
+
+	// but a named fragment can't be changed for one of its parents so the fields would be merged with different filters
+	_, err = plan(`{ user { posts { title } postCount(status: "draft") ...Posts } } fragment Posts on User { posts { title } }`)
+	if gqlErr, ok := err.(*gqlerror.Error); assert.True(t, ok, err) {
+		assert.Equal(t, "user.posts", gqlErr.Path.String())
+		assert.Contains(t, gqlErr.Message, "differing arguments")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/vektah/gqlparser/v2/ast"
 
@@ -22,16 +24,198 @@ type Gateway struct {
 	merger             Merger
 	middlewares        MiddlewareList
 	queryFields        []*QueryField
+	scalarFields       []*ScalarField
+	mutationFields     []*MutationField
 	queryerFactory     *QueryerFactory
 	queryPlanCache     QueryPlanCache
 	locationPriorities []string
+	responseCache      *responseCache
+	cacheHints         []*CacheHint
+	cacheScope         CacheScopeFunc
+	errorFormatter     ErrorFormatter
+	productionMode     bool
+	asyncStore         AsyncOperationStore
+	asyncTTL           time.Duration
+	maxRequestBodySize int64
+	maxQueryLength     int
+	maxBatchSize       int
+	batchConcurrency   int
+	rateLimiter        RateLimiter
+	partialResults     bool
+	credentials        map[string]CredentialProvider
+	queryLimits        map[string]int
+	literalExtraction  bool
+	maxPlanDepth       int
+	maxPlanSteps       int
+	maxStepExecutions  int
+	extensionsMerger   ExtensionsMerger
+	idFields           IDFieldMap
+	coalesceWindow     time.Duration
+
+	// how the services we were only given the url of are introspected
+	introspectionOptions        IntrospectionOptions
+	serviceIntrospectionOptions map[string]IntrospectionOptions
+
+	// shares the executions of identical queries that arrive at the same time
+	requestCollapser *requestCollapser
+	// keeps track of what the gateway is doing for the debug handler
+	debug *debugRegistry
+
+	// the order in which services are considered for fields that more than one of them resolve,
+	// and the fields that are pinned to a service
+	locationPreferences []string
+	locationOverrides   LocationOverrides
+	// how much each service is trusted with the fields it shares with others
+	locationTiers LocationTiers
+
+	// report the queries the services can't resolve instead of refusing to start
+	compatibilityWarnings bool
+
+	// the other executors a request can be handled by and the function that picks one
+	namedExecutors   map[string]Executor
+	executorSelector ExecutorSelector
+
+	// provides the request-scoped variables of the queries sent to the services
+	variableInjector VariableInjector
+	// provides the extensions of the requests sent to the services
+	downstreamExtensions DownstreamExtensions
+	// decides which errors of the services are reported to the client as they are, and the status of the
+	// responses that have them
+	errorClassifier    ErrorClassifier
+	errorClassStatuses map[ErrorClass]int
+	// the schema of each service, after its types were renamed, indexed by url
+	serviceSchemas map[string]*ast.Schema
+	// the services that can look up a list of objects with nodes(ids:)
+	batchLookups Set
+
+	// receives the record of the requests that asked to be audited
+	auditSink      AuditSink
+	auditRedactor  AuditRedactor
+	auditBodyLimit int
+
+	// the header that carries the name of the client's operation to the services
+	parentOperationHeader string
+
+	// the header that tells the services how long they have, and the least time a step needs to be sent
+	deadlineHeader string
+	deadlineFloor  time.Duration
+
+	// the services that respond with made up values, indexed by url
+	mockedServices map[string]*MockQueryer
+	// the queryers used for the services that aren't reached over HTTP, indexed by url
+	serviceQueryers map[string]graphql.Queryer
+	// how the responses to GET requests can be cached. nil turns it off.
+	httpCaching *HTTPCachingOptions
+	// called with the changes to the schema every time it is reloaded
+	schemaChangeHandler func(*ChangeSet)
+	// keep the current schema if a reload would break clients
+	rejectBreakingChanges bool
+	// the protection against forged mutations. nil turns it off.
+	csrfProtection *CSRFProtectionOptions
+	// the requests the handlers accept from other origins
+	cors *CORSOptions
+	// how the playground is shown
+	playground PlaygroundOptions
+	// the steps executed more often than this for a single request are reported. zero turns it off.
+	stepExpansionThreshold int
+	stepExpansionHandler   func(*StepExpansion)
+	// the names to give the types of each service, indexed by url
+	typeRenames     map[string]TypeRenames
+	servicePrefixes map[string]string
+	// the arguments that are copied between fields of the same type
+	argumentPropagations []ArgumentPropagation
+	// the defaults and maximums of the pagination arguments
+	paginationLimits PaginationLimits
+	// where the cost of every operation is reported, and how it is computed
+	complexitySink      ComplexitySink
+	complexityLimit     int
+	complexityEstimator FieldCostEstimator
+	complexityClientKey func(ctx context.Context) string
+	// how to report the deprecated fields selected by an operation. nil turns it off.
+	deprecationWarnings *DeprecationWarningOptions
+	// the SDL that adds directives to the merged schema, and the directives it declared
+	schemaExtensions           []string
+	extensionDirectives        Set
+	introspectSchemaExtensions bool
+	// the roles of the user, for the fields that require one
+	roles RoleProvider
+
+	// the kinds of operations the gateway refuses to perform
+	disableMutations     bool
+	disableSubscriptions bool
+	disableIntrospection bool
+
+	// the state needed to shut down gracefully
+	lifecycleMutex sync.Mutex
+	shuttingDown   bool
+	inFlight       sync.WaitGroup
+	shutdownCtx    context.Context
+	cancelInFlight context.CancelFunc
 
 	// group up the list of middlewares at startup to avoid it during execution
 	requestMiddlewares  []graphql.NetworkMiddleware
 	responseMiddlewares []ResponseMiddleware
+	stepMiddlewares     []StepMiddleware
+
+	// guards the schema and the information we computed from it
+	schemaMutex sync.RWMutex
+	// makes sure the schema is reloaded by one goroutine at a time
+	reloadMutex sync.Mutex
+	// introspects the services again once the schema gets old
+	schemaRevalidation *SWRValue
+
+	// counts the operations and sends them to the registry
+	usageReporter *usageReporter
+
+	// the parts of the schema that each visibility profile can see, and how to pick the profile of a request
+	visibilityProfiles        map[string]VisibilityFilter
+	visibilityProfileSelector VisibilityProfileSelector
+	visibleSchemas            map[string]*ast.Schema
+
+	// the plans that were built ahead of time, indexed by operation id
+	registeredPlans      map[string]QueryPlanList
+	registeredPlansMutex sync.RWMutex
+
+	// check the stitched response against the schema, and what to do with the values that don't fit
+	responseValidation     bool
+	responseValidationMode ResponseValidationMode
+
+	// the urls we have to visit to access certain fields, and the fields that can be found at each url
+	fieldURLs      FieldURLMap
+	locationFields LocationFieldMap
+
+	// the key fields of the entities defined by federated services
+	entityKeys EntityKeyMap
 
-	// the urls we have to visit to access certain fields
-	fieldURLs FieldURLMap
+	// the directives that each service understands
+	directives DirectiveMap
+
+	// the names the gateway gave to the types of each service, indexed by url
+	renamedTypes map[string]TypeRenames
+
+	// the services that define each type, field, and enum value of the schema, indexed by coordinate
+	schemaOwners map[string][]string
+
+	// the reason each deprecated field of the schema was deprecated, indexed by coordinate (ie, User.username)
+	deprecatedFields map[string]string
+
+	// the cache hints of the response cache, indexed by "Type" and "Type.field"
+	cacheHintIndex map[string]*CacheHint
+
+	// what we know about the services behind the gateway
+	services []ServiceInfo
+
+	// the last known good version of the schemas and the services that we had to load from it
+	snapshotStore SnapshotStore
+	schemaVersion string
+	staleServices []string
+
+	// the services that were left out because they couldn't be introspected
+	partialBoot         bool
+	unavailableServices []string
+
+	// the number of times the schema was applied, so the plans built for an old one can be spotted
+	schemaGeneration int
 }
 
 // RequestContext holds all of the information required to satisfy the user's query
@@ -41,60 +225,308 @@ type RequestContext struct {
 	OperationName string
 	Variables     map[string]interface{}
 	CacheKey      string
+	// the dependent steps of the plan are abandoned if they take longer than this. zero waits for every step.
+	PartialResultsTimeout time.Duration
+	// the extensions to add to the response. This is filled in when the plan is executed.
+	Extensions map[string]interface{}
+	// the work that went into the response. This is filled in when the plan is executed.
+	Stats *Stats
+	// the name of the executor the client asked for. It's only used if the ExecutorSelector wants it to be.
+	Executor string
+	// the id of a plan that was registered with RegisterPlan. The plan is used instead of planning the query.
+	OperationID string
+	// the freshness the client asked for. With FreshnessStrong, the fields can only come from the services in
+	// the tiers that are allowed to resolve them.
+	Freshness string
+
+	// true if the plans came out of the query plan cache
+	planCacheHit bool
+
+	// the schema that the query was planned against
+	plannedSchema *ast.Schema
 }
 
 func (g *Gateway) GetPlans(ctx *RequestContext) (QueryPlanList, error) {
-	// let the persister grab the plan for us
-	return g.queryPlanCache.Retrieve(&PlanningContext{
-		Query:     ctx.Query,
-		Schema:    g.schema,
-		Gateway:   g,
-		Locations: g.fieldURLs,
-	}, &ctx.CacheKey, g.planner)
+	// a schema that's too old has to be brought up to date first
+	if err := g.checkSchemaRevalidation(ctx.Context); err != nil {
+		return nil, err
+	}
+
+	// operations that the gateway won't perform aren't worth planning
+	if ctx.Query != "" {
+		if err := g.checkRequestPolicy(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// the plans that were built ahead of time don't need the planner at all
+	plans, registered := g.registeredPlan(ctx.OperationID)
+	if !registered {
+		if ctx.OperationID != "" && ctx.Query == "" && ctx.CacheKey == "" {
+			return nil, ErrUnknownOperationID
+		}
+
+		// the schema can be replaced while we plan
+		generation := g.currentSchemaGeneration()
+
+		// the query is planned against the part of the schema the caller can see
+		visible, err := g.visibleSchema(ctx.Context)
+		if err != nil {
+			return nil, err
+		}
+
+		// the values in the query might have to be moved into variables first
+		cacheKey := g.extractLiterals(ctx, visible)
+
+		planningContext := g.planningContext(ctx.Query)
+		planningContext.Schema = visible
+		ctx.plannedSchema = visible
+
+		// the plans of a request for strong freshness can only use some of the services so they are cached apart
+		planningContext.Freshness = ctx.Freshness
+		cacheKey = freshnessCacheKey(cacheKey, ctx.Query, ctx.Freshness)
+
+		// let the persister grab the plan for us
+		planner := &countingPlanner{QueryPlanner: &preparedPlanner{QueryPlanner: g.planner, gateway: g}}
+		plans, err = g.queryPlanCache.Retrieve(planningContext, cacheKey, planner)
+		if err != nil {
+			return nil, err
+		}
+		// the cache had the plans if it didn't have to ask for them
+		ctx.planCacheHit = !planner.planned
+		g.debug.planCacheLookup(ctx.planCacheHit)
+
+		// plans built for a schema that was replaced in the meantime can't stay in the cache
+		if planner.planned && g.currentSchemaGeneration() != generation {
+			if cache, ok := g.queryPlanCache.(ClearableQueryPlanCache); ok {
+				cache.Clear()
+			}
+		}
+	}
+
+	// persisted queries don't have any text for us to check until we find their plans
+	if ctx.Query == "" {
+		if err := g.checkPlanPolicy(ctx, plans); err != nil {
+			return nil, err
+		}
+	}
+
+	// the plans might not have been planned for this caller
+	if err := g.checkVisibility(ctx, plans); err != nil {
+		return nil, err
+	}
+
+	// some fields can only be selected by the users with the right role
+	if err := g.checkRequiredRoles(ctx, plans); err != nil {
+		return nil, err
+	}
+
+	return plans, nil
+}
+
+// currentSchemaGeneration returns the number of times the schema was applied
+func (g *Gateway) currentSchemaGeneration() int {
+	g.schemaMutex.RLock()
+	defer g.schemaMutex.RUnlock()
+
+	return g.schemaGeneration
+}
+
+// planningContext returns the information the planner needs to plan the query
+func (g *Gateway) planningContext(query string) *PlanningContext {
+	g.schemaMutex.RLock()
+	defer g.schemaMutex.RUnlock()
+
+	return &PlanningContext{
+		Query:      query,
+		Schema:     g.schema,
+		Gateway:    g,
+		Locations:  g.fieldURLs,
+		EntityKeys: g.entityKeys,
+		Directives: g.directives,
+		IDFields:   g.idFields,
+
+		ArgumentPropagations: g.argumentPropagations,
+		BatchLookups:         g.batchLookups,
+		PaginationLimits:     g.paginationLimits,
+		UnavailableServices:  g.unavailableServices,
+	}
 }
 
 // Execute takes a query string, executes it, and returns the response
 func (g *Gateway) Execute(ctx *RequestContext, plans QueryPlanList) (map[string]interface{}, error) {
-	// the plan we mean to execute
-	var plan *QueryPlan
+	// make sure a shutdown waits for us to finish
+	done, err := g.trackOperation()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 
-	// if there is only one plan (one operation) then use it
-	if len(plans) == 1 {
-		plan = plans[0]
-	} else {
-		// if we weren't given an operation name then we don't know which one to send
-		if ctx.OperationName == "" {
-			return nil, errors.New("please provide an operation name")
+	return g.execute(ctx, plans)
+}
+
+// execute is the implementation of Execute for callers that are already tracked by Shutdown
+func (g *Gateway) execute(ctx *RequestContext, plans QueryPlanList) (map[string]interface{}, error) {
+	// the request might be able to share the execution of an identical one
+	if g.requestCollapser != nil {
+		if key, ok := g.requestCollapser.collapsingKey(g, ctx, plans); ok {
+			return g.requestCollapser.execute(ctx, key, func() (map[string]interface{}, error) {
+				return g.executeOperation(ctx, plans)
+			})
 		}
+	}
 
-		// find the plan for the right operation
-		operationPlan, err := plans.ForOperation(ctx.OperationName)
-		if err != nil {
-			return nil, err
+	return g.executeOperation(ctx, plans)
+}
+
+// executeOperation executes the operation of the request
+func (g *Gateway) executeOperation(ctx *RequestContext, plans QueryPlanList) (result map[string]interface{}, err error) {
+	// the request fails instead of whoever asked for it
+	defer recoverError(&err, "executing an operation")
+
+	// the caller can leave the plans up to the ones that were registered for the operation
+	if len(plans) == 0 && ctx.OperationID != "" {
+		registered, ok := g.registeredPlan(ctx.OperationID)
+		if !ok {
+			return nil, ErrUnknownOperationID
 		}
+		plans = registered
+	}
+
+	// the plan we mean to execute
+	plan, err := g.planForOperation(ctx, plans)
+	if err != nil {
+		return nil, err
+	}
+
+	// the steps can only be given variables that fit the types the operation declared
+	variables, err := g.coerceVariables(plan.Operation, ctx.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	// the page sizes that come from the variables have to be within their limits too
+	paginationWarnings, err := applyPaginationLimits(plan, variables)
+	if err != nil {
+		return nil, err
+	}
 
-		// use the one for the operation
-		plan = operationPlan
+	// the name of the operation we're executing, even if the client left it out
+	operationName := ctx.OperationName
+	if operationName == "" && plan.Operation != nil {
+		operationName = plan.Operation.Name
 	}
 
+	// make sure the query isn't too complex, and let whoever is watching know what it cost
+	if err := g.checkComplexity(ctx.Context, operationName, plan, variables); err != nil {
+		return nil, err
+	}
+
+	// make sure the client can afford the query before we send anything to the services
+	if err := g.checkRateLimit(ctx.Context, plan); err != nil {
+		return nil, err
+	}
+
+	// the execution has to stop if the gateway gives up on it during a shutdown
+	requestContext, cancel := g.withShutdown(ctx.Context)
+	defer cancel()
+
 	// build up the execution context
 	executionContext := &ExecutionContext{
-		RequestContext:     ctx.Context,
+		RequestContext:     requestContext,
 		RequestMiddlewares: g.requestMiddlewares,
+		StepMiddlewares:    g.stepMiddlewares,
+		Credentials:        g.credentials,
+		QueryLimits:        g.queryLimits,
 		Plan:               plan,
-		Variables:          ctx.Variables,
+		Query:              ctx.Query,
+		OperationName:      operationName,
+		Variables:          variables,
+		Extensions:         map[string]interface{}{},
+		Deadline:           g.partialResultsDeadline(ctx),
+		CoalesceWindow:     g.coalesceWindow,
+
+		ParentOperationHeader: g.parentOperationHeader,
+		DeadlineHeader:        g.deadlineHeader,
+		DeadlineFloor:         g.deadlineFloor,
+		TypeRenames:           g.renamedTypes,
+		VariableInjector:      g.variableInjector,
+		DownstreamExtensions:  g.downstreamExtensions,
+		ErrorClassifier:       g.errorClassifier,
+		ServiceSchemas:        g.serviceSchemas,
+		RequestedExecutor:     ctx.Executor,
+
+		stats: newStatsRecorder(plan),
+	}
+
+	// the registry hears about the operation once it's done
+	if g.usageReporter != nil {
+		executionContext.stats.trackLatencies(plan)
+		defer func() {
+			g.usageReporter.record(ctx, plan, executionContext.stats, time.Since(executionContext.stats.start), err)
+		}()
+	}
+
+	// the debug handler can see the operation while it runs
+	executionContext.debug = g.debug.start(operationName, ctx.Query, plan)
+	defer func() {
+		g.debug.finish(executionContext.debug, err)
+	}()
+
+	// the audit sink gets everything that went into the response once we're done
+	executionContext.audit = g.startAudit(ctx, operationName)
+	defer func() {
+		g.finishAudit(executionContext.audit, result, err)
+	}()
+
+	// let the client know about the deprecated fields it asked for
+	if err := g.addDeprecationWarnings(executionContext); err != nil {
+		return nil, err
+	}
+
+	// and the ones whose page sizes were lowered
+	if len(paginationWarnings) > 0 {
+		executionContext.Extensions["paginationWarnings"] = paginationWarnings
 	}
 
 	// TODO: handle plans of more than one query
-	// execute the plan and return the results
-	result, err := g.executor.Execute(executionContext)
+	// execute the plan with whichever executor is supposed to handle the request and return the results
+	executor, executorName := g.selectExecutor(executionContext)
+	result, err = executor.Execute(executionContext)
+	g.addServiceExtensions(executionContext)
+	g.reportStepExpansions(executionContext)
+
+	// the client might want to know what it took to answer them
+	ctx.Stats = executionContext.stats.stats(ctx.planCacheHit)
+	ctx.Stats.Executor = executorName
+	if statsRequested(ctx.Context) {
+		executionContext.Extensions[statsExtension] = ctx.Stats
+	}
 	if err != nil {
+		ctx.Extensions = executionContext.Extensions
 		if len(result) == 0 {
 			return nil, err
 		}
+
+		// the partial result still can't show the ids the gateway added to the queries
+		if scrubErr := scrubInsertionIDs(executionContext, result); scrubErr != nil {
+			log.Warn("Could not scrub the partial result: ", scrubErr)
+		}
 		return result, err
 	}
 
+	// the services might have sent something that doesn't fit the schema
+	var violations graphql.ErrorList
+	if g.responseValidation {
+		result, violations = g.validateResponse(executionContext, result)
+
+		// a value that couldn't be nulled where it was takes the whole response with it
+		if result == nil {
+			ctx.Extensions = executionContext.Extensions
+			return nil, violations
+		}
+	}
+
 	// now that we have our response, throw it through the list of middlewarse
 	for _, ware := range g.responseMiddlewares {
 		if err := ware(executionContext, result); err != nil {
@@ -102,13 +534,45 @@ func (g *Gateway) Execute(ctx *RequestContext, plans QueryPlanList) (map[string]
 		}
 	}
 
+	// the middlewares could have added their own extensions
+	ctx.Extensions = executionContext.Extensions
+
+	// the client gets what was left of the response along with what was wrong with it
+	if len(violations) > 0 {
+		return result, violations
+	}
+
 	// we're done here
 	return result, nil
 }
 
+// planForOperation returns the plan in the list that corresponds to the requested operation
+func (g *Gateway) planForOperation(ctx *RequestContext, plans QueryPlanList) (*QueryPlan, error) {
+	// if there is only one plan (one operation) then use it
+	if len(plans) == 1 {
+		return plans[0], nil
+	}
+
+	// if we weren't given an operation name then we don't know which one to send
+	if ctx.OperationName == "" {
+		return nil, errors.New("please provide an operation name")
+	}
+
+	// find the plan for the right operation
+	return plans.ForOperation(ctx.OperationName)
+}
+
 func (g *Gateway) internalSchema() *ast.Schema {
-	// we start off with the internal schema
-	schema := internalSchema
+	// we start off with a copy of the internal schema so that the fields of one gateway don't leak into another
+	schema := *internalSchema
+	query := *internalSchema.Query
+	query.Fields = append(ast.FieldList{}, internalSchema.Query.Fields...)
+	schema.Query = &query
+	schema.Types = map[string]*ast.Definition{}
+	for name, definition := range internalSchema.Types {
+		schema.Types[name] = definition
+	}
+	schema.Types[query.Name] = &query
 
 	// then we have to add any query fields we have
 	for _, field := range g.queryFields {
@@ -119,17 +583,42 @@ func (g *Gateway) internalSchema() *ast.Schema {
 		})
 	}
 
+	// and the ones that return scalars
+	for _, field := range g.scalarFields {
+		schema.Query.Fields = append(schema.Query.Fields, &ast.FieldDefinition{
+			Name:      field.Name,
+			Type:      field.Type,
+			Arguments: field.Arguments,
+		})
+	}
+
+	// the gateway's mutations need a type of their own
+	if len(g.mutationFields) > 0 {
+		mutation := &ast.Definition{Kind: ast.Object, Name: "Mutation"}
+		for _, field := range g.mutationFields {
+			mutation.Fields = append(mutation.Fields, &ast.FieldDefinition{
+				Name:      field.Name,
+				Type:      field.Type,
+				Arguments: field.Arguments,
+			})
+		}
+		schema.Mutation = mutation
+		schema.Types[mutation.Name] = mutation
+	}
+
 	// we're done
-	return schema
+	return &schema
 }
 
-// New instantiates a new schema with the required stuffs.
-func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
-	// if there are no source schemas
-	if len(sources) == 0 {
-		return nil, errors.New("a gateway must have at least one schema")
-	}
+// hasLocalDefinitions returns true if the gateway defines something of its own on top of what the services define
+func (g *Gateway) hasLocalDefinitions() bool {
+	// every gateway has the node field
+	return len(g.queryFields) > 1 || len(g.scalarFields) > 0 || len(g.mutationFields) > 0 || len(g.schemaExtensions) > 0
+}
 
+// New instantiates a new schema with the required stuffs. The list of sources can be empty if the gateway defines
+// fields of its own, the services can be added later on with Reload.
+func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
 	// set any default values before we start doing stuff with it
 	gateway := &Gateway{
 		sources:        sources,
@@ -138,13 +627,39 @@ func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
 		merger:         MergerFunc(mergeSchemas),
 		queryFields:    []*QueryField{nodeField},
 		queryPlanCache: &NoQueryPlanCache{},
+
+		errorClassifier:       DefaultErrorClassifier,
+		parentOperationHeader: DefaultParentOperationHeader,
 	}
+	gateway.shutdownCtx, gateway.cancelInFlight = context.WithCancel(context.Background())
 
 	// pass the gateway through any Options
 	for _, config := range configs {
 		config(gateway)
 	}
 
+	// the queryers configured for a service are shared by every plan so they are prepared for the middlewares
+	// of the requests once
+	for url, queryer := range gateway.serviceQueryers {
+		gateway.serviceQueryers[url] = prepareQueryer(queryer, gateway.credentials[url])
+	}
+
+	// a gateway without services needs something of its own to answer. The services can be added later on
+	// with Reload.
+	if len(sources) == 0 && !gateway.hasLocalDefinitions() {
+		return nil, errors.New("a gateway must have at least one schema")
+	}
+
+	// the rules that copy arguments between fields have to have fields to copy them between
+	if err := checkArgumentPropagations(gateway.argumentPropagations); err != nil {
+		return nil, err
+	}
+
+	// in production, we don't want to leak the details of transport errors unless we've been told otherwise
+	if gateway.productionMode && gateway.errorFormatter == nil {
+		gateway.errorFormatter = MaskTransportErrors
+	}
+
 	// if we have a queryer factory to assign
 	if gateway.queryerFactory != nil {
 		// if the planner can accept the factory
@@ -161,30 +676,46 @@ func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
 		}
 	}
 
-	internal := gateway.internalSchema()
-	// find the field URLs before we merge schemas. We need to make sure to include
-	// the fields defined by the gateway's internal schema
-	urls := fieldURLs(sources, true).Concat(
-		fieldURLs([]*graphql.RemoteSchema{
-			{
-				URL:    internalSchemaLocation,
-				Schema: internal,
-			}},
-			false,
-		),
-	)
+	// if we have preferences for the fields that more than one service can resolve
+	if gateway.locationPreferences != nil || gateway.locationOverrides != nil {
+		// if the planner can accept the preferences
+		if planner, ok := gateway.planner.(PlannerWithLocationPreferences); ok {
+			gateway.planner = planner.WithLocationPreferences(gateway.locationPreferences, gateway.locationOverrides)
+		}
+	}
 
-	// grab the schemas within each source
-	sourceSchemas := []*ast.Schema{}
-	for _, source := range sources {
-		sourceSchemas = append(sourceSchemas, source.Schema)
+	// if some services are trusted more than others with the fields they share
+	if len(gateway.locationTiers.Services) > 0 || len(gateway.locationTiers.Fields) > 0 {
+		// if the planner can accept the tiers
+		if planner, ok := gateway.planner.(PlannerWithLocationTiers); ok {
+			gateway.planner = planner.WithLocationTiers(gateway.locationTiers)
+		}
 	}
-	sourceSchemas = append(sourceSchemas, internal)
 
-	// merge them into one
-	schema, err := gateway.merger.Merge(sourceSchemas)
+	// if we have to limit the size of plans
+	if gateway.maxPlanDepth > 0 || gateway.maxPlanSteps > 0 {
+		// if the planner can accept the limits
+		if planner, ok := gateway.planner.(PlannerWithPlanLimits); ok {
+			gateway.planner = planner.WithPlanLimits(gateway.maxPlanDepth, gateway.maxPlanSteps)
+		}
+	}
+
+	// the executors need to know how many steps they can execute
+	if gateway.maxStepExecutions > 0 {
+		executors := []Executor{gateway.executor}
+		for _, executor := range gateway.namedExecutors {
+			executors = append(executors, executor)
+		}
+		for _, executor := range executors {
+			if executor, ok := executor.(*ParallelExecutor); ok {
+				executor.MaxStepExecutions = gateway.maxStepExecutions
+			}
+		}
+	}
+
+	// build the schema out of the services
+	built, err := gateway.buildSchema(sources)
 	if err != nil {
-		// if something went wrong during the merge, return the result
 		return nil, err
 	}
 
@@ -201,26 +732,210 @@ func New(sources []*graphql.RemoteSchema, configs ...Option) (*Gateway, error) {
 			responseMiddlewares = append(responseMiddlewares, mware)
 		case RequestMiddleware:
 			requestMiddlewares = append(requestMiddlewares, graphql.NetworkMiddleware(mware))
+		case StepMiddleware:
+			gateway.stepMiddlewares = append(gateway.stepMiddlewares, mware)
 		default:
 		}
 	}
 
-	// we should be able to ask for the id under a gateway field without going to another service
-	// that requires that the gateway knows that it is a place it can get the `id`
-	for _, field := range gateway.queryFields {
-		urls.RegisterURL(field.Type.Name(), "id", internalSchemaLocation)
+	if gateway.responseCache != nil {
+		gateway.responseCache.scope = gateway.cacheScope
 	}
 
-	// assign the computed values
-	gateway.schema = schema
-	gateway.fieldURLs = urls
+	gateway.applySchema(built, gateway.sources)
 	gateway.requestMiddlewares = requestMiddlewares
 	gateway.responseMiddlewares = responseMiddlewares
 
+	// the schema we just built is as fresh as it gets
+	if gateway.schemaRevalidation != nil {
+		gateway.schemaRevalidation.Set(gateway.SchemaVersion())
+	}
+
 	// we're done here
 	return gateway, nil
 }
 
+// builtSchema holds everything the gateway computes from the schemas of its services
+type builtSchema struct {
+	schema *ast.Schema
+	// the schemas of the services, as they were introspected
+	sources        []*graphql.RemoteSchema
+	introspectedAt map[string]time.Time
+	fieldURLs      FieldURLMap
+	locationFields LocationFieldMap
+	entityKeys     EntityKeyMap
+	directives     DirectiveMap
+	renamedTypes   map[string]TypeRenames
+	// the services that define each type, field, and enum value, indexed by coordinate
+	owners map[string][]string
+	// the schema of each service, after its types were renamed, indexed by url
+	serviceSchemas map[string]*ast.Schema
+	// the services that can look up a list of objects with nodes(ids:)
+	batchLookups Set
+	// the directives declared by the schema extensions
+	extensionDirectives Set
+	// the services whose schema came from the snapshot and the ones that were left out
+	staleServices       []string
+	unavailableServices []string
+}
+
+// buildSchema introspects the services that need it and merges their schemas with the gateway's own
+func (g *Gateway) buildSchema(sources []*graphql.RemoteSchema) (*builtSchema, error) {
+	// any services that we were only given the url of have to be introspected
+	resolved, err := g.resolveSources(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	// federated services need to be stripped of the federation specifics before we can merge them
+	sources, entityKeys, err := federatedSources(resolved.sources)
+	if err != nil {
+		return nil, err
+	}
+
+	// the mocked services make up their responses from the schema they were given
+	if err := g.assignMockSchemas(sources); err != nil {
+		return nil, err
+	}
+
+	// the services whose types clash with the others get new names before anything looks at their schema
+	sources, entityKeys, renamedTypes, err := g.renameSources(sources, entityKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	internal := g.internalSchema()
+	// find the field URLs before we merge schemas. We need to make sure to include
+	// the fields defined by the gateway's internal schema
+	urls := fieldURLs(sources, true).Concat(
+		fieldURLs([]*graphql.RemoteSchema{
+			{
+				URL:    internalSchemaLocation,
+				Schema: internal,
+			}},
+			false,
+		),
+	)
+
+	// the services have to be able to answer the queries we would send them
+	if err := g.checkCompatibility(sources, urls, entityKeys); err != nil {
+		return nil, err
+	}
+
+	// the fields that are pinned to a service have to be defined by it
+	if err := checkLocationOverrides(g.locationOverrides, urls); err != nil {
+		return nil, err
+	}
+
+	// the merge could move things around so we have to find out who defines what before it happens
+	owners := schemaOwners(sources)
+
+	// grab the schemas within each source
+	sourceSchemas := []*ast.Schema{}
+	serviceSchemas := map[string]*ast.Schema{}
+	for _, source := range sources {
+		sourceSchemas = append(sourceSchemas, source.Schema)
+		serviceSchemas[source.URL] = source.Schema
+	}
+	sourceSchemas = append(sourceSchemas, internal)
+
+	// we can only forward a directive in a query to the services that declare it
+	directives := serviceDirectives(append(sources, &graphql.RemoteSchema{URL: internalSchemaLocation, Schema: internal}))
+
+	// merge them into one
+	schema, err := g.merger.Merge(sourceSchemas)
+	if err != nil {
+		// if something went wrong during the merge, point out the services that caused it
+		return nil, serviceMergeErrors(g.merger, append(sources, &graphql.RemoteSchema{URL: internalSchemaLocation, Schema: internal}), err)
+	}
+
+	// the operators can attach their own directives to what the services defined
+	extensionDirectives, err := applySchemaExtensions(schema, g.schemaExtensions)
+	if err != nil {
+		return nil, err
+	}
+
+	// we should be able to ask for the id under a gateway field without going to another service
+	// that requires that the gateway knows that it is a place it can get the `id`
+	for _, field := range g.queryFields {
+		urls.RegisterURL(field.Type.Name(), "id", internalSchemaLocation)
+	}
+	for _, field := range g.mutationFields {
+		urls.RegisterURL(field.Type.Name(), "id", internalSchemaLocation)
+	}
+
+	return &builtSchema{
+		schema:         schema,
+		sources:        resolved.sources,
+		introspectedAt: resolved.introspectedAt,
+		fieldURLs:      urls,
+		locationFields: urls.Fields(),
+		entityKeys:     entityKeys,
+		directives:     directives,
+		renamedTypes:   renamedTypes,
+		owners:         owners,
+		serviceSchemas: serviceSchemas,
+		batchLookups:   nodesBatchLocations(sources),
+
+		extensionDirectives: extensionDirectives,
+		staleServices:       resolved.staleServices,
+		unavailableServices: resolved.unavailableServices,
+	}, nil
+}
+
+// applySchema makes the built schema the one the gateway uses to answer queries. The sources are the ones
+// the schema was built from, as they were given to the gateway.
+func (g *Gateway) applySchema(built *builtSchema, sources []*graphql.RemoteSchema) {
+	// now that we know the schemas work together, they are the new last known good version
+	version, err := g.snapshotSources(built.sources, built.introspectedAt)
+	if err != nil {
+		log.Warn("Could not save schema snapshot: ", err)
+	}
+
+	// if the response cache is enabled, we need to index the hints now that we have the final schema
+	var cacheHints map[string]*CacheHint
+	if g.responseCache != nil {
+		cacheHints = indexCacheHints(built.schema, g.cacheHints)
+	}
+
+	// every visibility profile sees its own version of the schema
+	visible := visibleSchemas(built.schema, g.visibilityProfiles)
+
+	// assign the computed values. The maps are never modified once they have been assigned, they can
+	// only be replaced while holding the lock
+	g.schemaMutex.Lock()
+	defer g.schemaMutex.Unlock()
+
+	if version != "" {
+		g.schemaVersion = version
+	}
+	g.sources = sources
+	g.staleServices = built.staleServices
+	g.unavailableServices = built.unavailableServices
+	g.visibleSchemas = visible
+	g.schema = built.schema
+	g.fieldURLs = built.fieldURLs
+	g.locationFields = built.locationFields
+	g.entityKeys = built.entityKeys
+	g.directives = built.directives
+	g.renamedTypes = built.renamedTypes
+	g.schemaOwners = built.owners
+	g.serviceSchemas = built.serviceSchemas
+	g.batchLookups = built.batchLookups
+	g.extensionDirectives = built.extensionDirectives
+	g.cacheHintIndex = cacheHints
+	if g.deprecationWarnings != nil {
+		g.deprecatedFields = deprecatedFields(built.schema)
+	}
+	g.services = g.serviceInfo(built.sources, built.introspectedAt, built.locationFields)
+
+	// the plans that were cached send their steps to the services that defined the fields before
+	g.schemaGeneration++
+	if cache, ok := g.queryPlanCache.(ClearableQueryPlanCache); ok {
+		cache.Clear()
+	}
+}
+
 // Option is a function to be passed to New that configures the
 // resulting schema
 type Option func(*Gateway)
@@ -260,6 +975,31 @@ func WithQueryFields(fields ...*QueryField) Option {
 	}
 }
 
+// WithScalarFields returns an Option that adds the given query fields that return scalars to the gateway
+func WithScalarFields(fields ...*ScalarField) Option {
+	return func(g *Gateway) {
+		g.scalarFields = append(g.scalarFields, fields...)
+	}
+}
+
+// WithAPIVersion returns an Option that adds a _apiVersion field to the gateway that returns the version
+func WithAPIVersion(version string) Option {
+	return WithScalarFields(&ScalarField{
+		Name: apiVersionField,
+		Type: ast.NonNullNamedType("String", &ast.Position{}),
+		Resolver: func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			return version, nil
+		},
+	})
+}
+
+// WithMutationFields returns an Option that adds the given mutation fields to the gateway
+func WithMutationFields(fields ...*MutationField) Option {
+	return func(g *Gateway) {
+		g.mutationFields = append(g.mutationFields, fields...)
+	}
+}
+
 // WithQueryerFactory returns an Option that changes the queryer used by the planner
 // when generating plans that interact with remote services.
 func WithQueryerFactory(factory *QueryerFactory) Option {
@@ -314,7 +1054,7 @@ func fieldURLs(schemas []*graphql.RemoteSchema, stripInternal bool) FieldURLMap
 				for _, fieldDef := range typeDef.Fields {
 
 					// if the field is not an introspection field
-					if !(name == "Query" && strings.HasPrefix(fieldDef.Name, "__")) {
+					if !(typeDef == remoteSchema.Schema.Query && strings.HasPrefix(fieldDef.Name, "__")) {
 						locations.RegisterURL(name, fieldDef.Name, remoteSchema.URL)
 					} else {
 						// its an introspection name
@@ -333,7 +1073,8 @@ func fieldURLs(schemas []*graphql.RemoteSchema, stripInternal bool) FieldURLMap
 	return locations
 }
 
-// FieldURLMap holds the intformation for retrieving the valid locations one can find the value for the field
+// FieldURLMap holds the intformation for retrieving the valid locations one can find the value for the field.
+// The locations of a field are kept in the order they were registered and each one only shows up once.
 type FieldURLMap map[string][]string
 
 // URLFor returns the list of locations one can find parent.field.
@@ -353,44 +1094,68 @@ func (m FieldURLMap) URLFor(parent string, field string) ([]string, error) {
 	return value, nil
 }
 
-// Concat returns a new field map url whose entries are the union of both maps
+// Concat returns a new field map url whose entries are the union of both maps. Neither map is modified
+// so it is safe to call on a map that is being read by other goroutines. A location that is in both
+// maps is only added once so concatenating the same map again doesn't change the result.
 func (m FieldURLMap) Concat(other FieldURLMap) FieldURLMap {
-	for key, value := range other {
-		// if we have seen the location before
-		if prevValue, ok := m[key]; ok {
-			// add the values to the internal registry
-			m[key] = append(prevValue, value...)
-
-			// we havent' seen the key before
-		} else {
-			m[key] = value
+	result := FieldURLMap{}
+	for _, source := range []FieldURLMap{m, other} {
+		for key, value := range source {
+			// copy the list so that adding to it later can't change the original
+			locations := append([]string{}, result[key]...)
+			for _, location := range value {
+				if !stringsContain(locations, location) {
+					locations = append(locations, location)
+				}
+			}
+			result[key] = locations
 		}
 	}
 
-	// return the
-	return m
+	return result
 }
 
-// RegisterURL adds a new location to the list of possible places to find the value for parent.field
+// RegisterURL adds a new location to the list of possible places to find the value for parent.field.
+// Locations that are already in the list are left where they are.
 func (m FieldURLMap) RegisterURL(parent string, field string, locations ...string) {
+	// compute the key for the field
+	key := m.keyFor(parent, field)
+
 	for _, location := range locations {
-		// compute the key for the field
-		key := m.keyFor(parent, field)
-
-		// look up the value in the map
-		_, exists := m[key]
-
-		// if we haven't seen this key before
-		if !exists {
-			// create a new list
-			m[key] = []string{location}
-		} else {
-			// we've seen this key before
+		if !stringsContain(m[key], location) {
 			m[key] = append(m[key], location)
 		}
 	}
 }
 
+// Fields returns the reverse of the map: the fields that can be found at each location
+func (m FieldURLMap) Fields() LocationFieldMap {
+	fields := LocationFieldMap{}
+	for key, locations := range m {
+		for _, location := range locations {
+			if fields[location] == nil {
+				fields[location] = Set{}
+			}
+			fields[location].Add(key)
+		}
+	}
+
+	return fields
+}
+
+// LocationFieldMap holds the fields (as Type.field) that can be found at each location
+type LocationFieldMap map[string]Set
+
+// FieldsAt returns the fields that can be found at the location in alphabetical order
+func (m LocationFieldMap) FieldsAt(location string) []string {
+	return sortedSet(m[location])
+}
+
+// Has returns true if parent.field can be found at the location
+func (m LocationFieldMap) Has(location string, parent string, field string) bool {
+	return m[location].Has(parent + "." + field)
+}
+
 func (m FieldURLMap) keyFor(parent string, field string) string {
 	return fmt.Sprintf("%s.%s", parent, field)
 }
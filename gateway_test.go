@@ -3,11 +3,16 @@ package gateway
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/nautilus/graphql"
 	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2"
 	"github.com/vektah/gqlparser/v2/ast"
 )
 
@@ -276,6 +281,52 @@ func TestGateway(t *testing.T) {
 		assert.Equal(t, map[string]interface{}{"hello": "world"}, response)
 	})
 
+	t.Run("Execution Context", func(t *testing.T) {
+		// the context the executor was given
+		var executed *ExecutionContext
+
+		gateway, err := New(sources,
+			WithExecutor(ExecutorFunc(func(ctx *ExecutionContext) (map[string]interface{}, error) {
+				executed = ctx
+				ctx.Extensions["executor"] = true
+				return map[string]interface{}{"allUsers": []interface{}{}}, nil
+			})),
+			WithMiddlewares(
+				ResponseMiddleware(func(ctx *ExecutionContext, response map[string]interface{}) error {
+					// the middlewares see the same context as the executor
+					assert.Equal(t, executed, ctx)
+					ctx.Extensions["middleware"] = true
+					return nil
+				}),
+			))
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		reqCtx := &RequestContext{
+			Context:   context.Background(),
+			Query:     "query AllUsers($withName: Boolean!) { allUsers { firstName @include(if: $withName) } }",
+			Variables: map[string]interface{}{"withName": true},
+		}
+		plans, err := gateway.GetPlans(reqCtx)
+		if !assert.Nil(t, err) {
+			return
+		}
+		_, err = gateway.Execute(reqCtx, plans)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// the executor can see everything about the request
+		assert.Equal(t, plans[0], executed.Plan)
+		assert.Equal(t, reqCtx.Query, executed.Query)
+		assert.Equal(t, "AllUsers", executed.OperationName)
+		assert.Equal(t, reqCtx.Variables, executed.Variables)
+
+		// the extensions they added end up in the response
+		assert.Equal(t, map[string]interface{}{"executor": true, "middleware": true}, reqCtx.Extensions)
+	})
+
 	t.Run("filter out automatically inserted ids", func(t *testing.T) {
 		// the query we're going to fire. Query.allUsers comes from service one. User.lastName
 		// from service two.
@@ -452,30 +503,9 @@ func TestGateway(t *testing.T) {
 		// invoke the first step
 		res := map[string]interface{}{}
 		err = plans[0].RootStep.Then[0].Queryer.Query(context.Background(), &graphql.QueryInput{
-			Query: query,
-			QueryDocument: &ast.QueryDocument{
-				Operations: ast.OperationList{
-					{
-						Operation: "Query",
-						SelectionSet: ast.SelectionSet{
-							&ast.Field{
-								Alias: "viewer",
-								Name:  "viewer",
-								Arguments: ast.ArgumentList{
-									&ast.Argument{
-										Name: "id",
-										Value: &ast.Value{
-											Kind: ast.Variable,
-											Raw:  "id",
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-			Variables: map[string]interface{}{"id": "1"},
+			Query:         plans[0].RootStep.Then[0].QueryString,
+			QueryDocument: plans[0].RootStep.Then[0].QueryDocument,
+			Variables:     map[string]interface{}{"id": "1"},
 		}, &res)
 		if err != nil {
 			t.Error(err.Error())
@@ -483,7 +513,7 @@ func TestGateway(t *testing.T) {
 		}
 
 		// make sure the result of the queryer matches exepctations
-		assert.Equal(t, map[string]interface{}{"viewer": map[string]interface{}{"id": "1"}}, res)
+		assert.Equal(t, map[string]interface{}{"viewer": map[string]interface{}{gatewayIDAlias: "1"}}, res)
 	})
 }
 
@@ -621,6 +651,268 @@ func TestGatewayExecuteRespectsOperationName(t *testing.T) {
 	}, res)
 }
 
+func TestGateway_reservedAliases(t *testing.T) {
+	userSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			allUsers: [User!]!
+		}
+	`)
+	reviewSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Review {
+			body: String!
+		}
+
+		type User implements Node {
+			id: ID!
+			reviews: [Review!]!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	// the services reject any query that isn't valid, just like the real thing would
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		schema := userSchema
+		if url == "reviews" {
+			schema = reviewSchema
+		}
+
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			if _, err := gqlparser.LoadQuery(schema, input.Query); err != nil {
+				return nil, err
+			}
+
+			switch {
+			case url == "users" && strings.Contains(input.Query, "allUsers"):
+				return map[string]interface{}{
+					"allUsers": []interface{}{
+						map[string]interface{}{"id": "John", gatewayIDAlias: "1"},
+					},
+				}, nil
+			case url == "users":
+				assert.Equal(t, "1", input.Variables[gatewayIDAlias])
+				return map[string]interface{}{
+					gatewayNodeAlias: map[string]interface{}{"name": "John"},
+				}, nil
+			default:
+				return map[string]interface{}{
+					gatewayNodeAlias: map[string]interface{}{
+						"reviews": []interface{}{
+							map[string]interface{}{"body": fmt.Sprintf("review of %v", input.Variables[gatewayIDAlias])},
+						},
+					},
+				}, nil
+			}
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: "users", Schema: userSchema},
+		{URL: "reviews", Schema: reviewSchema},
+	}, WithQueryerFactory(&factory))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	table := []struct {
+		Message  string
+		Query    string
+		Expected map[string]interface{}
+	}{
+		{
+			"Field named node",
+			`{ node(id: "1") { ... on User { name reviews { body } } } }`,
+			map[string]interface{}{
+				"node": map[string]interface{}{
+					"name": "John",
+					"reviews": []interface{}{
+						map[string]interface{}{"body": "review of 1"},
+					},
+				},
+			},
+		},
+		{
+			"Field aliased to id",
+			`{ allUsers { id: name reviews { body } } }`,
+			map[string]interface{}{
+				"allUsers": []interface{}{
+					map[string]interface{}{
+						"id": "John",
+						"reviews": []interface{}{
+							map[string]interface{}{"body": "review of 1"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, row := range table {
+		t.Run(row.Message, func(t *testing.T) {
+			reqCtx := &RequestContext{Context: context.Background(), Query: row.Query}
+
+			plans, err := gateway.GetPlans(reqCtx)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			result, err := gateway.Execute(reqCtx, plans)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			assert.Equal(t, row.Expected, result)
+		})
+	}
+}
+
+func TestGateway_connections(t *testing.T) {
+	userSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type UserEdge {
+			cursor: String!
+			node: User
+		}
+
+		type PageInfo {
+			hasNextPage: Boolean!
+		}
+
+		type UserConnection {
+			edges: [UserEdge]!
+			pageInfo: PageInfo!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			users(first: Int): UserConnection!
+		}
+	`)
+	orderSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Order {
+			total: Int!
+		}
+
+		type User implements Node {
+			id: ID!
+			ordersFromOtherService: [Order!]!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			if url == "users" {
+				return map[string]interface{}{
+					"users": map[string]interface{}{
+						"list": []interface{}{
+							map[string]interface{}{"node": map[string]interface{}{"name": "John", gatewayIDAlias: "1"}},
+							nil,
+							map[string]interface{}{"node": map[string]interface{}{"name": "Jane", gatewayIDAlias: "2"}},
+						},
+						"pageInfo": map[string]interface{}{"hasNextPage": false},
+					},
+				}, nil
+			}
+
+			// the total of a user's orders is their id
+			total, _ := strconv.Atoi(input.Variables[gatewayIDAlias].(string))
+			return map[string]interface{}{
+				gatewayNodeAlias: map[string]interface{}{
+					"ordersFromOtherService": []interface{}{
+						map[string]interface{}{"total": total},
+					},
+				},
+			}, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: "users", Schema: userSchema},
+		{URL: "orders", Schema: orderSchema},
+	}, WithQueryerFactory(&factory))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	reqCtx := &RequestContext{
+		Context: context.Background(),
+		Query: `{
+			users(first: 10) {
+				list: edges {
+					node {
+						name
+						ordersFromOtherService { total }
+					}
+				}
+				pageInfo { hasNextPage }
+			}
+		}`,
+	}
+
+	plans, err := gateway.GetPlans(reqCtx)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	result, err := gateway.Execute(reqCtx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"users": map[string]interface{}{
+			"list": []interface{}{
+				map[string]interface{}{
+					"node": map[string]interface{}{
+						"name":                   "John",
+						"ordersFromOtherService": []interface{}{map[string]interface{}{"total": 1}},
+					},
+				},
+				nil,
+				map[string]interface{}{
+					"node": map[string]interface{}{
+						"name":                   "Jane",
+						"ordersFromOtherService": []interface{}{map[string]interface{}{"total": 2}},
+					},
+				},
+			},
+			"pageInfo": map[string]interface{}{"hasNextPage": false},
+		},
+	}, result)
+}
+
 func TestFieldURLs_concat(t *testing.T) {
 	// create a field url map
 	first := FieldURLMap{}
@@ -658,4 +950,298 @@ func TestFieldURLs_concat(t *testing.T) {
 		return
 	}
 	assert.Equal(t, []string{"url2"}, urlLocations3)
+
+	// the original maps should not have been touched
+	urlLocations2, _ = first.URLFor("Parent", "field2")
+	assert.Equal(t, []string{"url1"}, urlLocations2)
+	_, err = first.URLFor("Parent", "field3")
+	assert.NotNil(t, err)
+}
+
+func TestFieldURLs_idempotent(t *testing.T) {
+	first := FieldURLMap{}
+	first.RegisterURL("Parent", "field1", "url1", "url2")
+	first.RegisterURL("Parent", "field1", "url1")
+
+	second := FieldURLMap{}
+	second.RegisterURL("Parent", "field1", "url2", "url3")
+
+	// registering a location twice doesn't add it twice
+	locations, _ := first.URLFor("Parent", "field1")
+	assert.Equal(t, []string{"url1", "url2"}, locations)
+
+	// and neither does concatenating the same map over and over
+	sum := first.Concat(second)
+	assert.Equal(t, sum, sum.Concat(second))
+	assert.Equal(t, sum, sum.Concat(first).Concat(sum))
+	locations, _ = sum.URLFor("Parent", "field1")
+	assert.Equal(t, []string{"url1", "url2", "url3"}, locations)
+}
+
+func TestGateway_locationFields(t *testing.T) {
+	// merging schemas changes the definitions of the first one so every build gets its own copies
+	load := func(trending bool) []*graphql.RemoteSchema {
+		usersSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type User implements Node {
+				id: ID!
+				name: String!
+			}
+
+			type Query {
+				node(id: ID!): Node
+				users: [User!]!
+			}
+		`)
+
+		query := "node(id: ID!): Node\n feed: [String!]!"
+		if trending {
+			query += "\n trending: [String!]!"
+		}
+		postsSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type User implements Node {
+				id: ID!
+				posts: [String!]!
+			}
+
+			type Query {
+				` + query + `
+			}
+		`)
+
+		return []*graphql.RemoteSchema{
+			{Schema: usersSchema, URL: "users"},
+			{Schema: postsSchema, URL: "posts"},
+		}
+	}
+
+	gateway, err := New(load(true))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Contains(t, gateway.ServiceFields("users"), "User.name")
+	assert.NotContains(t, gateway.ServiceFields("users"), "User.posts")
+	assert.Contains(t, gateway.ServiceFields("posts"), "Query.trending")
+	assert.True(t, gateway.locationFields.Has("posts", "Query", "trending"))
+	assert.False(t, gateway.locationFields.Has("users", "User", "posts"))
+
+	// the posts service stops serving trending
+	_, err = gateway.Reload(load(false))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// both directions know about it
+	assert.False(t, gateway.locationFields.Has("posts", "Query", "trending"))
+	assert.NotContains(t, gateway.ServiceFields("posts"), "Query.trending")
+	assert.Contains(t, gateway.ServiceFields("posts"), "Query.feed")
+	_, err = gateway.FieldLocations("Query", "trending")
+	assert.NotNil(t, err)
+
+	// and nothing was added twice
+	for key, locations := range gateway.fieldURLs {
+		unique := Set{}
+		for _, location := range locations {
+			unique.Add(location)
+		}
+		assert.Equal(t, len(unique), len(locations), key)
+	}
+	for _, service := range gateway.Services() {
+		assert.Equal(t, len(gateway.ServiceFields(service.URL)), service.FieldCount)
+	}
+}
+
+func TestGateway_typename(t *testing.T) {
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			users: [User!]!
+		}
+	`)
+	postsSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Post {
+			title: String!
+		}
+
+		type User implements Node {
+			id: ID!
+			favorite: Post
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	// keep track of the queries sent to the services
+	queries := make(chan string, 10)
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			queries <- url
+			if url == "users" {
+				user := map[string]interface{}{"name": "alice"}
+				if strings.Contains(input.Query, gatewayIDAlias) {
+					user[gatewayIDAlias] = "1"
+				}
+				response := map[string]interface{}{"users": []interface{}{user}}
+				if strings.Contains(input.Query, "__typename") {
+					response["__typename"] = "Query"
+				}
+				return response, nil
+			}
+			return map[string]interface{}{
+				gatewayNodeAlias: map[string]interface{}{"favorite": map[string]interface{}{"__typename": "Post", "title": "hello"}},
+			}, nil
+		})
+	})
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: usersSchema, URL: "users"},
+		{Schema: postsSchema, URL: "posts"},
+	}, WithQueryerFactory(&factory))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	execute := func(query string) (map[string]interface{}, []string) {
+		ctx := &RequestContext{Context: context.Background(), Query: query}
+		plans, err := gateway.GetPlans(ctx)
+		if !assert.Nil(t, err) {
+			return nil, nil
+		}
+		result, err := gateway.Execute(ctx, plans)
+		assert.Nil(t, err)
+
+		sent := []string{}
+	Drain:
+		for {
+			select {
+			case url := <-queries:
+				sent = append(sent, url)
+			default:
+				break Drain
+			}
+		}
+		return result, sent
+	}
+
+	// a probe for the root type is answered by the gateway
+	result, sent := execute(`{ __typename }`)
+	assert.Equal(t, map[string]interface{}{"__typename": "Query"}, result)
+	assert.Empty(t, sent)
+
+	// next to a real field it comes along with the field
+	result, sent = execute(`{ __typename users { name } }`)
+	assert.Equal(t, map[string]interface{}{
+		"__typename": "Query",
+		"users":      []interface{}{map[string]interface{}{"name": "alice"}},
+	}, result)
+	assert.Equal(t, []string{"users"}, sent)
+
+	// and inside of an object from another service it's sent to the service resolving the object
+	result, sent = execute(`{ users { name favorite { __typename title } } }`)
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{map[string]interface{}{
+			"name":     "alice",
+			"favorite": map[string]interface{}{"__typename": "Post", "title": "hello"},
+		}},
+	}, result)
+	assert.Equal(t, []string{"users", "posts"}, sent)
+}
+
+func TestGateway_withoutSources(t *testing.T) {
+	// a gateway without services or fields of its own has nothing to answer
+	_, err := New(nil)
+	assert.NotNil(t, err)
+
+	// the service that is added later on
+	schema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return &MockQueryer{Schema: schema, Options: MockOptions{Fields: map[string]MockFieldFunc{
+			"User.name": func(args map[string]interface{}) interface{} { return "a user" },
+		}}}
+	})
+
+	// the fields of the gateway return the ids of the objects that the services will define
+	gateway, err := New(nil,
+		WithQueryerFactory(&factory),
+		WithQueryFields(
+			&QueryField{
+				Name: "viewer",
+				Type: ast.NamedType("Node", &ast.Position{}),
+				Resolver: func(ctx context.Context, args map[string]interface{}) (string, error) {
+					return "user-1", nil
+				},
+			},
+			&QueryField{
+				Name: "admin",
+				Type: ast.NamedType("Node", &ast.Position{}),
+				Resolver: func(ctx context.Context, args map[string]interface{}) (string, error) {
+					return "user-2", nil
+				},
+			},
+		),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// query sends the query to the gateway over http and returns the response
+	query := func(t *testing.T, query string) string {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(fmt.Sprintf(`{"query": %q}`, query)))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		gateway.GraphQLHandler(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+		return response.Body.String()
+	}
+
+	assert.JSONEq(t,
+		`{"data": {"viewer": {"id": "user-1"}, "admin": {"id": "user-2"}}}`,
+		query(t, `{ viewer { id } admin { id } }`),
+	)
+
+	// the first service is added without starting over
+	_, err = gateway.Reload([]*graphql.RemoteSchema{{URL: "users", Schema: schema}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.JSONEq(t,
+		`{"data": {"viewer": {"id": "user-1", "name": "a user"}}}`,
+		query(t, `{ viewer { id ... on User { name } } }`),
+	)
 }
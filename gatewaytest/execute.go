@@ -0,0 +1,316 @@
+package gatewaytest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// execute resolves the query and returns the data of the response along with the errors of the fields
+func (s *MockService) execute(input *graphql.QueryInput) (map[string]interface{}, []*graphql.Error) {
+	document, errs := gqlparser.LoadQuery(s.Schema, input.Query)
+	if errs != nil {
+		result := []*graphql.Error{}
+		for _, err := range errs {
+			result = append(result, &graphql.Error{Message: err.Message})
+		}
+		return nil, result
+	}
+
+	operation := document.Operations.ForName(input.OperationName)
+	if operation == nil && len(document.Operations) == 1 {
+		operation = document.Operations[0]
+	}
+	if operation == nil {
+		return nil, []*graphql.Error{{Message: fmt.Sprintf("could not find the operation %q", input.OperationName)}}
+	}
+
+	var root *ast.Definition
+	switch operation.Operation {
+	case ast.Mutation:
+		root = s.Schema.Mutation
+	case ast.Subscription:
+		root = s.Schema.Subscription
+	default:
+		root = s.Schema.Query
+	}
+	if root == nil {
+		return nil, []*graphql.Error{{Message: fmt.Sprintf("the schema does not support %s operations", operation.Operation)}}
+	}
+
+	executor := &executor{
+		service:   s,
+		variables: input.Variables,
+		fragments: document.Fragments,
+	}
+	data := executor.object(root, operation.SelectionSet, map[string]interface{}{}, []interface{}{})
+
+	return data, executor.errors
+}
+
+// executor resolves a single query
+type executor struct {
+	service   *MockService
+	variables map[string]interface{}
+	fragments ast.FragmentDefinitionList
+	errors    []*graphql.Error
+}
+
+// object resolves the selected fields of an object of the type
+func (e *executor) object(definition *ast.Definition, selectionSet ast.SelectionSet, source map[string]interface{}, path []interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+
+	for _, field := range e.collectFields(definition, selectionSet) {
+		fieldPath := append(append([]interface{}{}, path...), field.Alias)
+
+		value, err := e.resolve(definition, field, source)
+		if err != nil {
+			e.fail(fieldPath, err)
+			result[field.Alias] = nil
+			continue
+		}
+
+		result[field.Alias] = e.value(field.Definition.Type, field.SelectionSet, value, fieldPath)
+	}
+
+	return result
+}
+
+// resolve returns the raw value of the field on the source
+func (e *executor) resolve(definition *ast.Definition, field *ast.Field, source map[string]interface{}) (interface{}, error) {
+	args := field.ArgumentMap(e.variables)
+	introspection := e.service.introspection
+
+	// the fields that every schema has
+	switch {
+	case field.Name == "__typename":
+		return definition.Name, nil
+	case definition == e.service.Schema.Query && field.Name == "__schema":
+		return introspection.schema, nil
+	case definition == e.service.Schema.Query && field.Name == "__type":
+		name, _ := args["name"].(string)
+		if typeValue, ok := introspection.types[name]; ok {
+			return typeValue, nil
+		}
+		return nil, nil
+	}
+
+	// a resolver for the field takes precedence over everything
+	if resolver, ok := e.service.resolvers[definition.Name+"."+field.Name]; ok {
+		return resolverValue(resolver(args))
+	}
+
+	// the objects are looked up with the resolvers of their types
+	if definition == e.service.Schema.Query {
+		switch field.Name {
+		case "node":
+			return e.node(args["id"])
+		case "nodes":
+			ids, _ := args["ids"].([]interface{})
+			nodes := []interface{}{}
+			for _, id := range ids {
+				node, err := e.node(id)
+				if err != nil {
+					return nil, err
+				}
+				nodes = append(nodes, node)
+			}
+			return nodes, nil
+		}
+	}
+
+	return source[field.Name], nil
+}
+
+// node returns the object with the id, or nil if none of the types that implement Node have it
+func (e *executor) node(id interface{}) (interface{}, error) {
+	schema := e.service.Schema
+
+	nodeInterface := schema.Types["Node"]
+	if nodeInterface == nil {
+		return nil, nil
+	}
+
+	// try the types in a fixed order so the same id always finds the same object
+	possibleTypes := schema.GetPossibleTypes(nodeInterface)
+	names := []string{}
+	for _, possibleType := range possibleTypes {
+		names = append(names, possibleType.Name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		resolver, ok := e.service.resolvers[name]
+		if !ok {
+			continue
+		}
+
+		value, err := resolverValue(resolver(map[string]interface{}{"id": id}))
+		if err != nil {
+			return nil, err
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok || object == nil {
+			continue
+		}
+
+		// the object is of the type that found it, with the id it was looked up with
+		node := map[string]interface{}{"__typename": name, "id": id}
+		for key, fieldValue := range object {
+			node[key] = fieldValue
+		}
+		return node, nil
+	}
+
+	return nil, nil
+}
+
+// value turns the raw value of a field into the value of its type
+func (e *executor) value(valueType *ast.Type, selectionSet ast.SelectionSet, value interface{}, path []interface{}) interface{} {
+	value, err := resolverValue(value)
+	if err != nil {
+		e.fail(path, err)
+		return nil
+	}
+	if value == nil {
+		return nil
+	}
+
+	// lists can be any kind of slice
+	if valueType.Elem != nil {
+		entries := reflect.ValueOf(value)
+		if entries.Kind() != reflect.Slice && entries.Kind() != reflect.Array {
+			e.fail(path, fmt.Errorf("expected a list but got %T", value))
+			return nil
+		}
+
+		list := []interface{}{}
+		for i := 0; i < entries.Len(); i++ {
+			list = append(list, e.value(valueType.Elem, selectionSet, entries.Index(i).Interface(), append(append([]interface{}{}, path...), i)))
+		}
+		return list
+	}
+
+	definition := e.service.Schema.Types[valueType.Name()]
+	if definition == nil {
+		e.fail(path, fmt.Errorf("could not find the type %s", valueType.Name()))
+		return nil
+	}
+
+	switch definition.Kind {
+	case ast.Object, ast.Interface, ast.Union:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			e.fail(path, fmt.Errorf("expected an object but got %T", value))
+			return nil
+		}
+
+		concrete, err := e.concreteType(definition, object)
+		if err != nil {
+			e.fail(path, err)
+			return nil
+		}
+		return e.object(concrete, selectionSet, object, path)
+	}
+
+	// scalars and enums are sent as they are
+	return value
+}
+
+// concreteType returns the type of the object that was returned for a field of the abstract type. The
+// object has to say which type it is with __typename unless there's only one possibility.
+func (e *executor) concreteType(definition *ast.Definition, object map[string]interface{}) (*ast.Definition, error) {
+	if definition.Kind == ast.Object {
+		return definition, nil
+	}
+
+	if name, ok := object["__typename"].(string); ok {
+		if concrete := e.service.Schema.Types[name]; concrete != nil && concrete.Kind == ast.Object {
+			return concrete, nil
+		}
+		return nil, fmt.Errorf("%s is not an object type", name)
+	}
+
+	possibleTypes := e.service.Schema.GetPossibleTypes(definition)
+	if len(possibleTypes) != 1 {
+		return nil, fmt.Errorf("the value of the %s has to have a __typename", definition.Name)
+	}
+	return possibleTypes[0], nil
+}
+
+// collectFields returns the fields of the selection set that apply to objects of the type, with the
+// selections of the fields that share an alias combined
+func (e *executor) collectFields(definition *ast.Definition, selectionSet ast.SelectionSet) []*ast.Field {
+	fields := []*ast.Field{}
+	aliases := map[string]int{}
+
+	var collect func(selectionSet ast.SelectionSet)
+	collect = func(selectionSet ast.SelectionSet) {
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				if index, ok := aliases[selection.Alias]; ok {
+					merged := *fields[index]
+					merged.SelectionSet = append(append(ast.SelectionSet{}, merged.SelectionSet...), selection.SelectionSet...)
+					fields[index] = &merged
+					continue
+				}
+
+				aliases[selection.Alias] = len(fields)
+				fields = append(fields, selection)
+
+			case *ast.InlineFragment:
+				if e.appliesTo(selection.TypeCondition, definition) {
+					collect(selection.SelectionSet)
+				}
+
+			case *ast.FragmentSpread:
+				fragment := e.fragments.ForName(selection.Name)
+				if fragment != nil && e.appliesTo(fragment.TypeCondition, definition) {
+					collect(fragment.SelectionSet)
+				}
+			}
+		}
+	}
+	collect(selectionSet)
+
+	return fields
+}
+
+// appliesTo returns true if a fragment with the type condition applies to objects of the type
+func (e *executor) appliesTo(typeCondition string, definition *ast.Definition) bool {
+	if typeCondition == "" || typeCondition == definition.Name {
+		return true
+	}
+
+	condition := e.service.Schema.Types[typeCondition]
+	if condition == nil {
+		return false
+	}
+
+	for _, possibleType := range e.service.Schema.GetPossibleTypes(condition) {
+		if possibleType.Name == definition.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fail records the error of the field at the path
+func (e *executor) fail(path []interface{}, err error) {
+	e.errors = append(e.errors, &graphql.Error{Message: err.Error(), Path: path})
+}
+
+// resolverValue splits the errors returned by a resolver from the values
+func resolverValue(value interface{}) (interface{}, error) {
+	if err, ok := value.(error); ok {
+		return nil, err
+	}
+
+	return value, nil
+}
@@ -0,0 +1,117 @@
+package gatewaytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/nautilus/gateway"
+	"github.com/nautilus/graphql"
+)
+
+// A harness puts a gateway in front of a few mock services. The gateway finds out about the services the same
+// way it would in production: by introspecting them over HTTP. The queries are sent through the gateway's
+// HTTP handler so the response is the one a client would see.
+
+// Service describes one of the mock services of a harness
+type Service struct {
+	// the name the service is known by in the harness
+	Name      string
+	Schema    string
+	Resolvers Resolvers
+}
+
+// Response is the response of the gateway to a query
+type Response struct {
+	Data       map[string]interface{} `json:"data"`
+	Errors     []*graphql.Error       `json:"errors"`
+	Extensions map[string]interface{} `json:"extensions"`
+}
+
+// Harness is a gateway in front of mock services
+type Harness struct {
+	Gateway *gateway.Gateway
+	// the mock services, indexed by name
+	Services map[string]*MockService
+}
+
+// NewHarness starts the mock services and builds a gateway in front of them with the designated options
+func NewHarness(services []Service, options ...gateway.Option) (*Harness, error) {
+	harness := &Harness{Services: map[string]*MockService{}}
+
+	urls := []string{}
+	for _, service := range services {
+		mock := NewMockService(service.Schema, service.Resolvers)
+		harness.Services[service.Name] = mock
+		urls = append(urls, mock.URL)
+	}
+
+	schemas, err := graphql.IntrospectRemoteSchemas(urls...)
+	if err != nil {
+		harness.Close()
+		return nil, err
+	}
+
+	harness.Gateway, err = gateway.New(schemas, options...)
+	if err != nil {
+		harness.Close()
+		return nil, err
+	}
+
+	return harness, nil
+}
+
+// Close shuts down the mock services
+func (h *Harness) Close() {
+	for _, service := range h.Services {
+		service.Close()
+	}
+}
+
+// Execute sends the query to the gateway and returns its response. The error is only set if the gateway
+// couldn't be reached, the errors of the query are in the response.
+func (h *Harness) Execute(query string, variables map[string]interface{}) (*Response, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	h.Gateway.GraphQLHandler(recorder, request)
+
+	response := &Response{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Query sends the query to the gateway and writes the data of the response to the receiver, like
+// encoding/json would. The errors of the response are returned as a graphql.ErrorList.
+func (h *Harness) Query(query string, variables map[string]interface{}, receiver interface{}) error {
+	response, err := h.Execute(query, variables)
+	if err != nil {
+		return err
+	}
+
+	if len(response.Errors) > 0 {
+		errs := graphql.ErrorList{}
+		for _, err := range response.Errors {
+			errs = append(errs, err)
+		}
+		return errs
+	}
+
+	data, err := json.Marshal(response.Data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, receiver)
+}
@@ -0,0 +1,206 @@
+package gatewaytest
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// The introspection of a mock service is built once, as the maps that the fields of the __Schema and __Type
+// types are read from. The types refer to each other through the same maps so the executor can follow them
+// as deep as the query asks.
+
+// introspection holds the values of the introspection fields of a schema
+type introspection struct {
+	schema map[string]interface{}
+	types  map[string]map[string]interface{}
+}
+
+// newIntrospection builds the introspection of the schema
+func newIntrospection(schema *ast.Schema) *introspection {
+	result := &introspection{types: map[string]map[string]interface{}{}}
+
+	names := []string{}
+	for name := range schema.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// every type needs a value before the others can point to it
+	for _, name := range names {
+		result.types[name] = map[string]interface{}{
+			"kind":        string(schema.Types[name].Kind),
+			"name":        name,
+			"description": introspectionDescription(schema.Types[name].Description),
+		}
+	}
+
+	types := []interface{}{}
+	for _, name := range names {
+		definition := schema.Types[name]
+		value := result.types[name]
+
+		switch definition.Kind {
+		case ast.Object, ast.Interface:
+			fields := []interface{}{}
+			for _, field := range definition.Fields {
+				if strings.HasPrefix(field.Name, "__") {
+					continue
+				}
+				fields = append(fields, result.field(field))
+			}
+			value["fields"] = fields
+
+			interfaces := []interface{}{}
+			for _, iface := range definition.Interfaces {
+				interfaces = append(interfaces, result.types[iface])
+			}
+			value["interfaces"] = interfaces
+		case ast.InputObject:
+			inputFields := []interface{}{}
+			for _, field := range definition.Fields {
+				inputFields = append(inputFields, result.inputValue(field.Name, field.Description, field.Type, field.DefaultValue))
+			}
+			value["inputFields"] = inputFields
+		case ast.Enum:
+			values := []interface{}{}
+			for _, enumValue := range definition.EnumValues {
+				deprecated, reason := introspectionDeprecation(enumValue.Directives)
+				values = append(values, map[string]interface{}{
+					"name":              enumValue.Name,
+					"description":       introspectionDescription(enumValue.Description),
+					"isDeprecated":      deprecated,
+					"deprecationReason": reason,
+				})
+			}
+			value["enumValues"] = values
+		}
+
+		if definition.Kind == ast.Interface || definition.Kind == ast.Union {
+			possibleTypes := []interface{}{}
+			for _, possibleType := range schema.GetPossibleTypes(definition) {
+				possibleTypes = append(possibleTypes, result.types[possibleType.Name])
+			}
+			value["possibleTypes"] = possibleTypes
+		}
+
+		types = append(types, value)
+	}
+
+	directiveNames := []string{}
+	for name := range schema.Directives {
+		directiveNames = append(directiveNames, name)
+	}
+	sort.Strings(directiveNames)
+
+	directives := []interface{}{}
+	for _, name := range directiveNames {
+		directive := schema.Directives[name]
+
+		locations := []interface{}{}
+		for _, location := range directive.Locations {
+			locations = append(locations, string(location))
+		}
+
+		directives = append(directives, map[string]interface{}{
+			"name":        directive.Name,
+			"description": introspectionDescription(directive.Description),
+			"locations":   locations,
+			"args":        result.arguments(directive.Arguments),
+		})
+	}
+
+	result.schema = map[string]interface{}{
+		"types":      types,
+		"directives": directives,
+	}
+	for key, definition := range map[string]*ast.Definition{
+		"queryType":        schema.Query,
+		"mutationType":     schema.Mutation,
+		"subscriptionType": schema.Subscription,
+	} {
+		if definition != nil {
+			result.schema[key] = result.types[definition.Name]
+		}
+	}
+
+	return result
+}
+
+// field returns the introspection of the field
+func (i *introspection) field(field *ast.FieldDefinition) map[string]interface{} {
+	deprecated, reason := introspectionDeprecation(field.Directives)
+
+	return map[string]interface{}{
+		"name":              field.Name,
+		"description":       introspectionDescription(field.Description),
+		"args":              i.arguments(field.Arguments),
+		"type":              i.typeRef(field.Type),
+		"isDeprecated":      deprecated,
+		"deprecationReason": reason,
+	}
+}
+
+// arguments returns the introspection of the arguments
+func (i *introspection) arguments(arguments ast.ArgumentDefinitionList) []interface{} {
+	result := []interface{}{}
+	for _, argument := range arguments {
+		result = append(result, i.inputValue(argument.Name, argument.Description, argument.Type, argument.DefaultValue))
+	}
+
+	return result
+}
+
+// inputValue returns the introspection of an argument or a field of an input object
+func (i *introspection) inputValue(name string, description string, valueType *ast.Type, defaultValue *ast.Value) map[string]interface{} {
+	var defaultString interface{}
+	if defaultValue != nil {
+		defaultString = defaultValue.String()
+	}
+
+	return map[string]interface{}{
+		"name":         name,
+		"description":  introspectionDescription(description),
+		"type":         i.typeRef(valueType),
+		"defaultValue": defaultString,
+	}
+}
+
+// typeRef returns the introspection of a reference to a type, wrapped in the lists and non-nulls around it
+func (i *introspection) typeRef(valueType *ast.Type) map[string]interface{} {
+	if valueType.NonNull {
+		nullable := *valueType
+		nullable.NonNull = false
+		return map[string]interface{}{"kind": "NON_NULL", "ofType": i.typeRef(&nullable)}
+	}
+
+	if valueType.Elem != nil {
+		return map[string]interface{}{"kind": "LIST", "ofType": i.typeRef(valueType.Elem)}
+	}
+
+	return i.types[valueType.NamedType]
+}
+
+// introspectionDescription returns the description, or nil if there isn't one
+func introspectionDescription(description string) interface{} {
+	if description == "" {
+		return nil
+	}
+
+	return description
+}
+
+// introspectionDeprecation returns whether the directives deprecate something and why
+func introspectionDeprecation(directives ast.DirectiveList) (bool, interface{}) {
+	directive := directives.ForName("deprecated")
+	if directive == nil {
+		return false, nil
+	}
+
+	reason := "No longer supported"
+	if argument := directive.Arguments.ForName("reason"); argument != nil {
+		reason = argument.Value.Raw
+	}
+	return true, reason
+}
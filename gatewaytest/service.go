@@ -0,0 +1,94 @@
+// Package gatewaytest makes it possible to test a gateway from end to end without standing up real services.
+// A mock service is a GraphQL server running on a local port that answers queries against its schema with
+// the values returned by a handful of resolvers. It speaks enough GraphQL to be introspected by the gateway,
+// to look up objects with node(id:), and to send back the errors of the resolvers.
+//
+// The values of the fields are looked up in a few places:
+//   - a resolver registered as Type.field resolves the field on every object of that type (ie Query.users)
+//   - a resolver registered with the name of a type is how node(id:) and nodes(ids:) find the objects of that
+//     type. It is given the id as the "id" argument and returns nil if there is no such object.
+//   - every other field is read from the map that was returned for its parent
+//
+// The values of object fields are maps indexed by the name of the field. A resolver that returns an error
+// has it reported for the field.
+package gatewaytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Resolver returns the value of a field given its arguments. The arguments are the ones written in the query
+// or the variables as encoding/json decoded them.
+type Resolver func(args map[string]interface{}) interface{}
+
+// Resolvers are the resolvers of a mock service, indexed by Type.field or by the name of a type
+type Resolvers map[string]Resolver
+
+// MockService is a GraphQL server that answers queries with the values of its resolvers
+type MockService struct {
+	*httptest.Server
+	Schema *ast.Schema
+
+	resolvers     Resolvers
+	introspection *introspection
+
+	mutex    sync.Mutex
+	requests []*graphql.QueryInput
+}
+
+// NewMockService starts a mock service with the designated schema. Like httptest.NewServer, it panics if
+// it can't be started so it's meant to be used in tests.
+func NewMockService(schema string, resolvers Resolvers) *MockService {
+	loaded, err := graphql.LoadSchema(schema)
+	if err != nil {
+		panic("gatewaytest: could not load the schema of the mock service: " + err.Error())
+	}
+
+	service := &MockService{
+		Schema:        loaded,
+		resolvers:     resolvers,
+		introspection: newIntrospection(loaded),
+	}
+	service.Server = httptest.NewServer(service)
+
+	return service
+}
+
+// Requests returns the queries that the service was sent, in the order they were received
+func (s *MockService) Requests() []*graphql.QueryInput {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]*graphql.QueryInput{}, s.requests...)
+}
+
+// ServeHTTP answers a query sent in the body of a POST request
+func (s *MockService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	input := &graphql.QueryInput{}
+	if err := json.NewDecoder(r.Body).Decode(input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	s.requests = append(s.requests, input)
+	s.mutex.Unlock()
+
+	response := map[string]interface{}{}
+	data, errs := s.execute(input)
+	if data != nil {
+		response["data"] = data
+	}
+	if len(errs) > 0 {
+		response["errors"] = errs
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
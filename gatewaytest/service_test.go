@@ -0,0 +1,121 @@
+package gatewaytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMockService(t *testing.T) {
+	service := NewMockService(`
+		interface Node {
+			id: ID!
+		}
+
+		enum Role {
+			ADMIN
+			MEMBER
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+			role: Role!
+			friends(first: Int): [User!]!
+		}
+
+		type Query {
+			viewer: User
+			broken: String
+			node(id: ID!): Node
+		}
+	`, Resolvers{
+		"Query.viewer": func(args map[string]interface{}) interface{} {
+			return map[string]interface{}{"id": "1", "name": "alice", "role": "ADMIN"}
+		},
+		"Query.broken": func(args map[string]interface{}) interface{} {
+			return errors.New("something went wrong")
+		},
+		"User.friends": func(args map[string]interface{}) interface{} {
+			friends := []map[string]interface{}{}
+			for i := 0; i < int(args["first"].(int64)); i++ {
+				friends = append(friends, map[string]interface{}{"name": "friend"})
+			}
+			return friends
+		},
+		"User": func(args map[string]interface{}) interface{} {
+			if args["id"] != "2" {
+				return nil
+			}
+			return map[string]interface{}{"name": "bob", "role": "MEMBER"}
+		},
+	})
+	defer service.Close()
+
+	queryer := graphql.NewSingleRequestQueryer(service.URL)
+
+	t.Run("fields", func(t *testing.T) {
+		result := map[string]interface{}{}
+		err := queryer.Query(context.Background(), &graphql.QueryInput{
+			Query: `{ viewer { name role kind: __typename friends(first: 2) { name } } }`,
+		}, &result)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, map[string]interface{}{
+			"viewer": map[string]interface{}{
+				"name":    "alice",
+				"role":    "ADMIN",
+				"kind":    "User",
+				"friends": []interface{}{map[string]interface{}{"name": "friend"}, map[string]interface{}{"name": "friend"}},
+			},
+		}, result)
+	})
+
+	t.Run("node", func(t *testing.T) {
+		result := map[string]interface{}{}
+		err := queryer.Query(context.Background(), &graphql.QueryInput{
+			Query:     `query($id: ID!, $missing: ID!) { found: node(id: $id) { id ... on User { name } } missing: node(id: $missing) { id } }`,
+			Variables: map[string]interface{}{"id": "2", "missing": "3"},
+		}, &result)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, map[string]interface{}{
+			"found":   map[string]interface{}{"id": "2", "name": "bob"},
+			"missing": nil,
+		}, result)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		result := map[string]interface{}{}
+		err := queryer.Query(context.Background(), &graphql.QueryInput{Query: `{ broken }`}, &result)
+		if !assert.NotNil(t, err) {
+			return
+		}
+
+		assert.Equal(t, "something went wrong", err.(graphql.ErrorList)[0].Error())
+	})
+
+	t.Run("introspection", func(t *testing.T) {
+		schema, err := graphql.IntrospectAPI(queryer)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		user := schema.Types["User"]
+		if assert.NotNil(t, user) {
+			assert.Equal(t, []string{"Node"}, user.Interfaces)
+			assert.Equal(t, "[User!]!", user.Fields.ForName("friends").Type.String())
+		}
+		assert.Len(t, schema.Types["Role"].EnumValues, 2)
+	})
+
+	// every query the service was sent is recorded
+	assert.Len(t, service.Requests(), 4)
+}
@@ -0,0 +1,209 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// GraphQLHandler and PlaygroundHandler serve every request the same way. A gateway can also be mounted at
+// more than one route with different settings (ie, an internal endpoint that allows introspection next to a
+// public one that doesn't) by building a http.Handler for each of them with Handler or Playground. The
+// settings of the handler travel with the context of the request and take precedence over the gateway's.
+
+// HandlerOption configures a single handler built by Handler or Playground
+type HandlerOption func(*handlerConfig)
+
+// handlerConfig holds the settings of a handler. A nil value leaves the gateway's setting alone.
+type handlerConfig struct {
+	introspection      *bool
+	playground         *bool
+	methods            []string
+	maxRequestBodySize *int64
+	middlewares        []func(http.Handler) http.Handler
+}
+
+// HandlerIntrospection returns a HandlerOption that allows or refuses introspection queries sent to the handler
+func HandlerIntrospection(enabled bool) HandlerOption {
+	return func(c *handlerConfig) {
+		c.introspection = &enabled
+	}
+}
+
+// HandlerPlayground returns a HandlerOption that shows or hides the playground of the handler
+func HandlerPlayground(enabled bool) HandlerOption {
+	return func(c *handlerConfig) {
+		c.playground = &enabled
+	}
+}
+
+// HandlerAllowedMethods returns a HandlerOption that limits the HTTP methods the handler responds to.
+// The other methods get a 405.
+func HandlerAllowedMethods(methods ...string) HandlerOption {
+	return func(c *handlerConfig) {
+		c.methods = methods
+	}
+}
+
+// HandlerMaxRequestBodySize returns a HandlerOption that limits the number of bytes the handler will read
+// from the body of a request. Zero removes the limit.
+func HandlerMaxRequestBodySize(bytes int64) HandlerOption {
+	return func(c *handlerConfig) {
+		c.maxRequestBodySize = &bytes
+	}
+}
+
+// HandlerMiddleware returns a HandlerOption that wraps the handler with the designated middlewares.
+// The first middleware is the outermost one.
+func HandlerMiddleware(middlewares ...func(http.Handler) http.Handler) HandlerOption {
+	return func(c *handlerConfig) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// Handler returns a http.Handler that executes the operations it is sent, like GraphQLHandler
+func (g *Gateway) Handler(opts ...HandlerOption) http.Handler {
+	return g.handler(http.HandlerFunc(g.serveGraphQL), opts)
+}
+
+// Playground returns a http.Handler that shows the playground on GET requests and executes the operations
+// it is sent with a POST, like PlaygroundHandler
+func (g *Gateway) Playground(opts ...HandlerOption) http.Handler {
+	return g.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// on POSTs, we have to send the request to the graphqlHandler
+		if r.Method == http.MethodPost {
+			g.serveGraphQL(w, r)
+			return
+		}
+
+		// we are not handling a POST request so we have to show the user the playground
+		g.servePlayground(w, r)
+	}), opts)
+}
+
+// handler wraps the handler so that it serves requests with the designated options
+func (g *Gateway) handler(handler http.Handler, opts []HandlerOption) http.Handler {
+	config := &handlerConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	var result http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the browser might want to know if it can send the request before it does
+		if g.serveCORS(w, r) {
+			return
+		}
+
+		// a HEAD is a GET without the body
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+
+		if method != http.MethodOptions && len(config.methods) > 0 && !handlerAllowsMethod(config.methods, method) {
+			g.rejectMethod(w, r, config.methods)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodOptions:
+			// there's nothing to do but tell the client what it can send
+			w.Header().Set("Allow", handlerAllowHeader(config.methods))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case http.MethodHead:
+			r = r.Clone(r.Context())
+			r.Method = http.MethodGet
+			w = &headResponseWriter{ResponseWriter: w}
+		}
+
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), handlerConfigKey{}, config)))
+	})
+
+	// the first middleware has to see the request first
+	for i := len(config.middlewares) - 1; i >= 0; i-- {
+		result = config.middlewares[i](result)
+	}
+
+	return result
+}
+
+// handlerConfigKey is the context key for the settings of the handler that received the request
+type handlerConfigKey struct{}
+
+// requestHandlerConfig returns the settings of the handler that received the request
+func requestHandlerConfig(ctx context.Context) *handlerConfig {
+	if ctx == nil {
+		return &handlerConfig{}
+	}
+	if config, ok := ctx.Value(handlerConfigKey{}).(*handlerConfig); ok {
+		return config
+	}
+
+	return &handlerConfig{}
+}
+
+// introspectionDisabled returns true if the request can't ask for the gateway's schema
+func (g *Gateway) introspectionDisabled(ctx context.Context) bool {
+	if enabled := requestHandlerConfig(ctx).introspection; enabled != nil {
+		return !*enabled
+	}
+
+	return g.disableIntrospection
+}
+
+// playgroundDisabled returns true if the request can't be shown the playground
+func (g *Gateway) playgroundDisabled(ctx context.Context) bool {
+	if enabled := requestHandlerConfig(ctx).playground; enabled != nil {
+		return !*enabled
+	}
+
+	return g.playground.Disabled
+}
+
+// requestBodyLimit returns the number of bytes that can be read from the body of the request
+func (g *Gateway) requestBodyLimit(ctx context.Context) int64 {
+	if limit := requestHandlerConfig(ctx).maxRequestBodySize; limit != nil {
+		return *limit
+	}
+
+	return g.maxRequestBodySize
+}
+
+// handlerAllowsMethod returns true if the method is in the list
+func handlerAllowsMethod(methods []string, method string) bool {
+	for _, allowed := range methods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handlerAllowHeader returns the value of the Allow header of a handler that accepts the designated methods
+func handlerAllowHeader(methods []string) string {
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodPost}
+	}
+
+	allowed := []string{}
+	for _, method := range methods {
+		allowed = append(allowed, strings.ToUpper(method))
+		if strings.EqualFold(method, http.MethodGet) {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+
+	return strings.Join(append(allowed, http.MethodOptions), ", ")
+}
+
+// headResponseWriter answers a HEAD with the headers of the GET it stands for
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Write drops the body
+func (w *headResponseWriter) Write(body []byte) (int, error) {
+	return len(body), nil
+}
@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_handlerOptions(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			allUsers: [String!]!
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithExecutor(ExecutorFunc(func(ctx *ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"allUsers": []interface{}{"alice"}}, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the middlewares of the public endpoint leave a mark on the response
+	tagged := func(tag string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Tag", tag)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/internal/graphql", gateway.Playground())
+	mux.Handle("/public/graphql", gateway.Playground(
+		HandlerIntrospection(false),
+		HandlerPlayground(false),
+		HandlerAllowedMethods(http.MethodPost),
+		HandlerMaxRequestBodySize(100),
+		HandlerMiddleware(tagged("first"), tagged("second")),
+	))
+
+	// post sends the query to the endpoint and returns the response
+	post := func(endpoint string, query string) (*http.Response, map[string]interface{}) {
+		body, _ := json.Marshal(map[string]interface{}{"query": query})
+		request := httptest.NewRequest(http.MethodPost, endpoint, strings.NewReader(string(body)))
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+
+		result := map[string]interface{}{}
+		json.NewDecoder(recorder.Result().Body).Decode(&result)
+		return recorder.Result(), result
+	}
+
+	t.Run("Introspection", func(t *testing.T) {
+		_, result := post("/internal/graphql", "{ __schema { queryType { name } } }")
+		assert.Nil(t, result["errors"])
+
+		_, result = post("/public/graphql", "{ __schema { queryType { name } } }")
+		assert.NotNil(t, result["errors"])
+
+		// the rest of the schema is still available
+		response, result := post("/public/graphql", "{ allUsers }")
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Nil(t, result["errors"])
+		assert.Equal(t, []string{"first", "second"}, response.Header["X-Tag"])
+	})
+
+	t.Run("Playground and methods", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/internal/graphql", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "GraphQL Playground")
+
+		recorder = httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/public/graphql", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+		assert.Equal(t, "POST", recorder.Header().Get("Allow"))
+	})
+
+	t.Run("Body size", func(t *testing.T) {
+		query := "{ allUsers " + strings.Repeat(" ", 100) + "}"
+
+		response, _ := post("/internal/graphql", query)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		response, _ = post("/public/graphql", query)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+}
@@ -1,6 +1,7 @@
 package gateway
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/nautilus/graphql"
 )
@@ -24,14 +26,19 @@ type HTTPOperation struct {
 	OperationName string                 `json:"operationName"`
 	Extensions    struct {
 		QueryPlanCache *PersistedQuerySpecification `json:"persistedQuery"`
+		Async          bool                         `json:"async"`
+		TimeoutMs      int                          `json:"timeoutMs"`
+		Executor       string                       `json:"executor"`
+		OperationID    string                       `json:"operationId"`
+		Freshness      string                       `json:"freshness"`
 	} `json:"extensions"`
 }
 
-func formatErrors(data map[string]interface{}, err error) map[string]interface{} {
+func formatErrors(data interface{}, err error) map[string]interface{} {
 	return formatErrorsWithCode(data, err, "UNKNOWN_ERROR")
 }
 
-func formatErrorsWithCode(data map[string]interface{}, err error, code string) map[string]interface{} {
+func formatErrorsWithCode(data interface{}, err error, code string) map[string]interface{} {
 	// the final list of formatted errors
 	var errList graphql.ErrorList
 
@@ -56,103 +63,97 @@ func formatErrorsWithCode(data map[string]interface{}, err error, code string) m
 // a single object with { query, variables, operationName } or a list
 // of that object.
 func (g *Gateway) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
+	g.Handler().ServeHTTP(w, r)
+}
+
+// serveGraphQL responds to a request sent to one of the gateway's handlers
+func (g *Gateway) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	// a bug in the gateway shouldn't take the connection down with it
+	defer g.recoverResponse(w, r)
+
+	// operations can only be sent with a GET or a POST
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		g.rejectMethod(w, r, []string{http.MethodGet, http.MethodPost})
+		return
+	}
+
+	// clients could be polling for the result of an asynchronous operation
+	if id := r.URL.Query().Get("operation"); r.Method == http.MethodGet && id != "" && g.asyncStore != nil {
+		g.handleAsyncPoll(w, r, id)
+		return
+	}
+
+	// make sure a shutdown waits for the request to finish
+	done, err := g.trackOperation()
+	if err != nil {
+		g.rejectShuttingDown(w, r)
+		return
+	}
+	defer done()
+
+	// make sure we don't read more of the body than we are willing to
+	body := g.limitRequestBody(w, r)
+
 	operations, batchMode, payloadErr := parseRequest(r)
 
-	// if there was an error retrieving the payload
+	// if the body was too large, there's no point in looking at what we were able to parse
+	if body != nil && body.exceeded {
+		g.rejectTooLarge(w, r, fmt.Errorf("request body is too large: the limit is %v bytes", body.limit))
+		return
+	}
+
+	// if there was an error retrieving the payload, we can't tell what the client wants
 	if payloadErr != nil {
-		// stringify the response
-		response, _ := json.Marshal(formatErrors(nil, payloadErr))
+		response, _ := json.Marshal(g.errorResponse(r.Context(), nil, payloadErr, "BAD_REQUEST"))
+		emitResponse(w, http.StatusBadRequest, string(response))
+		return
+	}
 
-		// send the error to the user
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		w.Write(response)
+	// make sure the operations are within the limits we are willing to handle
+	if err := g.checkOperationLimits(operations); err != nil {
+		g.rejectTooLarge(w, r, err)
 		return
 	}
 
 	/// Handle the operations regardless of the request method
 
-	// we have to respond to each operation in the right order
-	results := []map[string]interface{}{}
-
 	// the status code to report
 	statusCode := http.StatusOK
 
-	for _, operation := range operations {
-		// the result of the operation
-		result := map[string]interface{}{}
-
-		// there might be a query plan cache key embedded in the operation
-		cacheKey := ""
-		if operation.Extensions.QueryPlanCache != nil {
-			cacheKey = operation.Extensions.QueryPlanCache.Hash
-		}
-
-		// if there is no query or cache key
-		if operation.Query == "" && cacheKey == "" {
-			statusCode = http.StatusUnprocessableEntity
-			results = append(
-				results,
-				formatErrorsWithCode(nil, errors.New("could not find query body"), "BAD_USER_INPUT"),
-			)
-			continue
-		}
-
-		// this might get mutated by the query plan cache so we have to pull it out
-		requestContext := &RequestContext{
-			Context:       r.Context(),
-			Query:         operation.Query,
-			OperationName: operation.OperationName,
-			Variables:     operation.Variables,
-			CacheKey:      cacheKey,
-		}
+	// the final result depends on whether we are executing in batch mode or not
+	var finalResponse interface{}
 
-		// Get the plan, and return a 400 if we can't get the plan
-		plan, err := g.GetPlans(requestContext)
-		if err != nil {
-			response, err := json.Marshal(formatErrorsWithCode(nil, err, "GRAPHQL_VALIDATION_FAILED"))
-			if err != nil {
-				// if we couldn't serialize the response then we're in internal error territory
-				response, err = json.Marshal(formatErrors(nil, err))
-				if err != nil {
-					response, _ = json.Marshal(formatErrors(nil, err))
-				}
-			}
-			emitResponse(w, http.StatusBadRequest, string(response))
-			return
-		}
+	// the cache policy of the full response is the most restrictive one of every operation
+	var cachePolicy *CachePolicy
 
-		// fire the query with the request context passed through to execution
-		result, err = g.Execute(requestContext, plan)
-		if err != nil {
-			results = append(results, formatErrorsWithCode(result, err, "INTERNAL_SERVER_ERROR"))
+	if batchMode {
+		// a failure in one operation of a batch doesn't affect the others
+		responses := g.handleBatch(r, operations)
 
-			continue
+		results := []map[string]interface{}{}
+		for _, response := range responses {
+			cachePolicy = restrictCachePolicy(cachePolicy, response.policy)
+			results = append(results, response.payload)
 		}
+		finalResponse = results
+	} else {
+		response := g.handleOperation(r.Context(), r, operations[0], true)
 
-		// the result for this operation
-		payload := map[string]interface{}{"data": result}
-
-		// if there was a cache key associated with this query
-		if requestContext.CacheKey != "" {
-			// embed the cache key in the response
-			payload["extensions"] = map[string]interface{}{
-				"persistedQuery": map[string]interface{}{
-					"sha265Hash": requestContext.CacheKey,
-					"version":    "1",
-				},
-			}
-		}
+		cachePolicy = response.policy
+		statusCode = response.status
+		finalResponse = response.payload
+	}
 
-		// add this result to the list
-		results = append(results, payload)
+	// if the response cache is enabled, tell the client how long the response can be cached for
+	if g.responseCache != nil && cachePolicy != nil {
+		w.Header().Set("Cache-Control", cacheControlHeader(*cachePolicy))
 	}
 
-	// the final result depends on whether we are executing in batch mode or not
-	var finalResponse interface{}
-	if batchMode {
-		finalResponse = results
-	} else {
-		finalResponse = results[0]
+	// the ETag of a response depends on all of it so it can't be sent until it's been serialized.
+	// everything else is sent to the client as it is encoded
+	if !g.revalidatable(r, statusCode) {
+		streamResponse(w, statusCode, finalResponse)
+		return
 	}
 
 	// serialized the response
@@ -166,10 +167,159 @@ func (g *Gateway) GraphQLHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// the client might already have the response
+	if g.writeCacheHeaders(w, r, statusCode, response) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	// send the result to the user
 	emitResponse(w, statusCode, string(response))
 }
 
+// operationResponse is the response to a single operation in a request
+type operationResponse struct {
+	payload map[string]interface{}
+	status  int
+	policy  *CachePolicy
+}
+
+// handleOperation performs a single operation of a request and returns the response for it. ctx is
+// the context the operation executes with.
+func (g *Gateway) handleOperation(ctx context.Context, r *http.Request, operation *HTTPOperation, allowAsync bool) (response *operationResponse) {
+	// a panic only fails the operation that caused it, not the rest of the batch
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			response = &operationResponse{
+				payload: g.errorResponse(ctx, nil, recoveredError(recovered, "handling an operation"), "INTERNAL_SERVER_ERROR"),
+				status:  http.StatusInternalServerError,
+			}
+		}
+	}()
+
+	// there might be a query plan cache key embedded in the operation
+	cacheKey := ""
+	if operation.Extensions.QueryPlanCache != nil {
+		cacheKey = operation.Extensions.QueryPlanCache.Hash
+	}
+
+	// if there is no query, cache key, or registered plan
+	if operation.Query == "" && cacheKey == "" && operation.Extensions.OperationID == "" {
+		return &operationResponse{
+			payload: g.errorResponse(ctx, nil, errors.New("could not find query body"), "BAD_USER_INPUT"),
+			status:  http.StatusBadRequest,
+		}
+	}
+
+	// this might get mutated by the query plan cache so we have to pull it out
+	requestContext := &RequestContext{
+		Context:       g.usageContext(statsContext(g.auditContext(ctx, r), r), r),
+		Query:         operation.Query,
+		OperationName: operation.OperationName,
+		Variables:     operation.Variables,
+		CacheKey:      cacheKey,
+		// the client can trade completeness for a faster response
+		PartialResultsTimeout: time.Duration(operation.Extensions.TimeoutMs) * time.Millisecond,
+		Executor:              operation.Extensions.Executor,
+		OperationID:           operation.Extensions.OperationID,
+		Freshness:             requestFreshness(r, operation),
+	}
+
+	// Get the plan, and return a 400 if we can't get the plan (unless it's our fault)
+	plan, err := g.GetPlans(requestContext)
+	if err != nil {
+		status := http.StatusBadRequest
+		if gqlErr, ok := err.(*graphql.Error); ok && gqlErr.Extensions["code"] == "INTERNAL_SERVER_ERROR" {
+			status = http.StatusInternalServerError
+		}
+
+		return &operationResponse{
+			payload: g.errorResponse(ctx, nil, err, "GRAPHQL_VALIDATION_FAILED"),
+			status:  status,
+		}
+	}
+
+	// a cache could replay the request so it can't change anything
+	if err := g.checkHTTPMethod(r, requestContext, plan); err != nil {
+		return &operationResponse{
+			payload: g.errorResponse(ctx, nil, err, "BAD_REQUEST"),
+			status:  http.StatusMethodNotAllowed,
+		}
+	}
+
+	// a mutation could have been sent by a page the user happened to visit
+	if err := g.checkCSRF(r, requestContext, plan); err != nil {
+		return &operationResponse{
+			payload: g.errorResponse(ctx, nil, err, "FORBIDDEN"),
+			status:  http.StatusForbidden,
+		}
+	}
+
+	// if the client asked for the operation to be executed asynchronously, we just have to tell them where to look
+	if g.asyncStore != nil && allowAsync && asyncRequested(r, operation) {
+		id, err := g.EnqueueOperation(requestContext, plan)
+		if err != nil {
+			return &operationResponse{
+				payload: g.errorResponse(ctx, nil, err, "INTERNAL_SERVER_ERROR"),
+				status:  http.StatusServiceUnavailable,
+			}
+		}
+
+		return &operationResponse{
+			payload: map[string]interface{}{
+				"operation": id,
+				"status":    AsyncOperationPending,
+			},
+			status: http.StatusAccepted,
+		}
+	}
+
+	// fire the query with the request context passed through to execution
+	result, policy, err := g.executeWithCache(requestContext, plan)
+	if err != nil {
+		payload := g.errorResponse(ctx, g.orderedData(requestContext, plan, result), err, "INTERNAL_SERVER_ERROR")
+		if len(requestContext.Extensions) > 0 {
+			payload["extensions"] = requestContext.Extensions
+		}
+
+		// the errors the client can act on might call for a status of their own
+		status := http.StatusOK
+		if classStatus := g.errorClassStatus(err); classStatus != 0 {
+			status = classStatus
+		}
+
+		return &operationResponse{
+			payload: payload,
+			status:  status,
+			policy:  &policy,
+		}
+	}
+
+	// the result for this operation with its fields in the order they were requested
+	payload := map[string]interface{}{"data": g.orderedData(requestContext, plan, result)}
+
+	// the extensions that came back from the services
+	extensions := map[string]interface{}{}
+	for key, value := range requestContext.Extensions {
+		extensions[key] = value
+	}
+
+	// if there was a cache key associated with this query
+	if requestContext.CacheKey != "" {
+		// embed the cache key in the response
+		extensions["persistedQuery"] = map[string]interface{}{
+			"sha265Hash": requestContext.CacheKey,
+			"version":    "1",
+		}
+	}
+
+	if len(extensions) > 0 {
+		payload["extensions"] = extensions
+	}
+
+	return &operationResponse{payload: payload, status: http.StatusOK, policy: &policy}
+}
+
 // Parses request to operations (single or batch mode)
 func parseRequest(r *http.Request) (operations []*HTTPOperation, batchMode bool, payloadErr error) {
 	// this handler can handle multiple operations sent in the same query. Internally,
@@ -211,7 +361,7 @@ func parseGetRequest(r *http.Request) (operations []*HTTPOperation, payloadErr e
 	if variableInput, ok := parameters["variables"]; ok {
 		variables := map[string]interface{}{}
 
-		err := json.Unmarshal([]byte(variableInput[0]), &variables)
+		err := unmarshalJSON([]byte(variableInput[0]), &variables)
 		if err != nil {
 			payloadErr = errors.New("variables must be a json object")
 		}
@@ -304,7 +454,7 @@ func parseOperations(operationsJson []byte) (operations []*HTTPOperation, batchM
 
 	singleQuery := &HTTPOperation{}
 	// if we were given a single object
-	if err := json.Unmarshal(operationsJson, &singleQuery); err == nil {
+	if err := unmarshalJSON(operationsJson, &singleQuery); err == nil {
 		// add it to the list of operations
 		operations = append(operations, singleQuery)
 		// we weren't given an object
@@ -312,7 +462,7 @@ func parseOperations(operationsJson []byte) (operations []*HTTPOperation, batchM
 		// but we could have been given a list
 		batch := []*HTTPOperation{}
 
-		if err = json.Unmarshal(operationsJson, &batch); err != nil {
+		if err = unmarshalJSON(operationsJson, &batch); err != nil {
 			payloadErr = fmt.Errorf("encountered error parsing operationsJson: %s", err.Error())
 		} else {
 			operations = batch
@@ -395,6 +545,15 @@ func injectFile(operations []*HTTPOperation, file graphql.Upload, paths []string
 	return nil
 }
 
+// rejectMethod tells the client which methods the endpoint accepts
+func (g *Gateway) rejectMethod(w http.ResponseWriter, r *http.Request, allowed []string) {
+	err := fmt.Errorf("%s requests are not supported, use %s", r.Method, strings.Join(allowed, " or "))
+	response, _ := json.Marshal(g.errorResponse(r.Context(), nil, err, "METHOD_NOT_ALLOWED"))
+
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	emitResponse(w, http.StatusMethodNotAllowed, string(response))
+}
+
 func emitResponse(w http.ResponseWriter, code int, response string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -405,12 +564,5 @@ func emitResponse(w http.ResponseWriter, code int, response string) {
 // the user an interface that they can use to interact with the API. On
 // POSTs the endpoint executes the designated query
 func (g *Gateway) PlaygroundHandler(w http.ResponseWriter, r *http.Request) {
-	// on POSTs, we have to send the request to the graphqlHandler
-	if r.Method == http.MethodPost {
-		g.GraphQLHandler(w, r)
-		return
-	}
-
-	// we are not handling a POST request so we have to show the user the playground
-	w.Write(playgroundContent)
+	g.Playground().ServeHTTP(w, r)
 }
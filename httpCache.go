@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// When HTTP caching is turned on, the responses to GET requests carry an ETag so that a CDN (or the
+// client) can ask if a response has changed with If-None-Match instead of downloading it again.
+// Since a cache could replay a GET request, mutations have to be sent with a POST.
+
+// HTTPCachingOptions configures the caching of the responses to GET requests
+type HTTPCachingOptions struct {
+	// the request headers that change the response (ie, the ones that are forwarded to the services).
+	// A cache has to keep a separate copy of the response for each of their values.
+	VaryHeaders []string
+}
+
+// WithHTTPCaching returns an Option that adds an ETag to the responses to GET requests, answers
+// conditional requests whose response hasn't changed with a 304, and refuses mutations sent in a GET request.
+func WithHTTPCaching(opts HTTPCachingOptions) Option {
+	return func(g *Gateway) {
+		g.httpCaching = &opts
+	}
+}
+
+// errMutationOverGET is returned for a mutation sent in a GET request
+var errMutationOverGET = errors.New("mutations must be sent in a POST request")
+
+// checkHTTPMethod returns an error if the operation can't be sent with the method of the request
+func (g *Gateway) checkHTTPMethod(r *http.Request, ctx *RequestContext, plans QueryPlanList) error {
+	if g.httpCaching == nil || r == nil || r.Method != http.MethodGet {
+		return nil
+	}
+
+	// if we can't tell which operation is being performed, the execution will complain
+	plan, err := g.planForOperation(ctx, plans)
+	if err != nil || plan.Operation == nil {
+		return nil
+	}
+
+	if plan.Operation.Operation == ast.Mutation {
+		return errMutationOverGET
+	}
+
+	return nil
+}
+
+// writeCacheHeaders adds the headers that let a cache store the response to the request. It returns
+// true if the client already has the response so there is no need to send it.
+func (g *Gateway) writeCacheHeaders(w http.ResponseWriter, r *http.Request, statusCode int, response []byte) bool {
+	if !g.revalidatable(r, statusCode) {
+		return false
+	}
+
+	if len(g.httpCaching.VaryHeaders) > 0 {
+		w.Header().Set("Vary", strings.Join(g.httpCaching.VaryHeaders, ", "))
+	}
+
+	etag := responseETag(response)
+	w.Header().Set("ETag", etag)
+
+	return etagMatches(r.Header.Get("If-None-Match"), etag)
+}
+
+// revalidatable returns true if the response to the request gets an ETag
+func (g *Gateway) revalidatable(r *http.Request, statusCode int) bool {
+	return g.httpCaching != nil && r.Method == http.MethodGet && statusCode == http.StatusOK
+}
+
+// responseETag returns a strong ETag for the serialized response
+func responseETag(response []byte) string {
+	hash := sha256.Sum256(response)
+	return `"` + hex.EncodeToString(hash[:]) + `"`
+}
+
+// etagMatches returns true if the If-None-Match header refers to the ETag. The comparison is weak,
+// as it must be for If-None-Match.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLHandler_httpCaching(t *testing.T) {
+	// the number of times an operation was executed
+	executions := 0
+
+	// gateway returns a gateway with the options
+	gateway := func(options ...Option) *Gateway {
+		schema, _ := graphql.LoadSchema(`
+			type Query {
+				allUsers: [String!]!
+			}
+
+			type Mutation {
+				deleteUsers: Boolean!
+			}
+		`)
+
+		options = append(options, WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+			executions++
+			return map[string]interface{}{"allUsers": []interface{}{"alice"}}, nil
+		})))
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gateway
+	}
+
+	// get sends the query in a GET request with the headers
+	get := func(gateway *Gateway, query string, headers map[string]string) *http.Response {
+		request := httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(query), nil)
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		responseRecorder := httptest.NewRecorder()
+		gateway.GraphQLHandler(responseRecorder, request)
+		return responseRecorder.Result()
+	}
+
+	t.Run("Conditional Requests", func(t *testing.T) {
+		cached := gateway(WithHTTPCaching(HTTPCachingOptions{VaryHeaders: []string{"Authorization"}}))
+
+		// the first request gets the full response with the headers a cache needs
+		response := get(cached, "{ allUsers }", nil)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "Authorization", response.Header.Get("Vary"))
+		etag := response.Header.Get("ETag")
+		assert.NotEmpty(t, etag)
+
+		// the same response gets the same ETag
+		assert.Equal(t, etag, get(cached, "{ allUsers }", nil).Header.Get("ETag"))
+
+		// a client that has the response doesn't get it again
+		response = get(cached, "{ allUsers }", map[string]string{"If-None-Match": `"other", ` + etag})
+		assert.Equal(t, http.StatusNotModified, response.StatusCode)
+		assert.Equal(t, etag, response.Header.Get("ETag"))
+		body, _ := ioutil.ReadAll(response.Body)
+		assert.Empty(t, body)
+
+		// a client with a different response gets the new one
+		response = get(cached, "{ allUsers }", map[string]string{"If-None-Match": `"other"`})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+
+	t.Run("Mutations", func(t *testing.T) {
+		executions = 0
+
+		// mutations can't be sent with a GET
+		response := get(gateway(WithHTTPCaching(HTTPCachingOptions{})), "mutation { deleteUsers }", nil)
+		assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+		assert.Equal(t, 0, executions)
+	})
+
+	t.Run("Off By Default", func(t *testing.T) {
+		response := get(gateway(), "{ allUsers }", nil)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Empty(t, response.Header.Get("ETag"))
+		assert.Empty(t, response.Header.Get("Vary"))
+	})
+}
@@ -58,7 +58,7 @@ func TestGraphQLHandler_postMissingQuery(t *testing.T) {
 	gateway.GraphQLHandler(responseRecorder, request)
 
 	// make sure we got an error code
-	assert.Equal(t, http.StatusUnprocessableEntity, responseRecorder.Result().StatusCode)
+	assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
 }
 
 func TestGraphQLHandler(t *testing.T) {
@@ -94,7 +94,7 @@ func TestGraphQLHandler(t *testing.T) {
 		gateway.GraphQLHandler(responseRecorder, request)
 
 		// make sure we got an error code
-		assert.Equal(t, http.StatusUnprocessableEntity, responseRecorder.Result().StatusCode)
+		assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
 
 		// verify the graphql error code
 		result, err := readResultWithErrors(responseRecorder, t)
@@ -114,7 +114,7 @@ func TestGraphQLHandler(t *testing.T) {
 		gateway.GraphQLHandler(responseRecorder, request)
 
 		// make sure we got an error code
-		assert.Equal(t, http.StatusUnprocessableEntity, responseRecorder.Result().StatusCode)
+		assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
 	})
 
 	t.Run("Object variables succeeds", func(t *testing.T) {
@@ -714,7 +714,7 @@ func TestGraphQLHandler_DeeplyNestedFileInput(t *testing.T) {
 			"Raw Upload Variable",
 			`{ 
 				"query": "mutation ($input: WrapperOne!) { uploadInputWrapper(input: $input) }", 
-				"variables": { "input": { "wrapperOne": { "wrapperTwo": { "file": null } } } }
+				"variables": { "input": { "wrapperOne": { "wrapperTwo": { "file": null, "files": [] } } } }
 			}`,
 			`{ "0": ["variables.input.wrapperOne.wrapperTwo.file"] }`,
 			[]byte("Test file content1"),
@@ -1237,7 +1237,7 @@ func TestGraphQLHandler_postFilesWithError(t *testing.T) {
 			gateway.GraphQLHandler(responseRecorder, request)
 
 			// make sure we got an error code
-			assert.Equal(t, http.StatusUnprocessableEntity, responseRecorder.Result().StatusCode)
+			assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
 		})
 	}
 
@@ -1256,7 +1256,7 @@ func TestGraphQLHandler_postFilesWithError(t *testing.T) {
 		gateway.GraphQLHandler(responseRecorder, request)
 
 		// make sure we got an error code
-		assert.Equal(t, http.StatusUnprocessableEntity, responseRecorder.Result().StatusCode)
+		assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
 	})
 
 	t.Run("Unknown content-type", func(t *testing.T) {
@@ -1274,7 +1274,7 @@ func TestGraphQLHandler_postFilesWithError(t *testing.T) {
 		gateway.GraphQLHandler(responseRecorder, request)
 
 		// make sure we got an error code
-		assert.Equal(t, http.StatusUnprocessableEntity, responseRecorder.Result().StatusCode)
+		assert.Equal(t, http.StatusBadRequest, responseRecorder.Result().StatusCode)
 	})
 }
 
@@ -1328,3 +1328,51 @@ func createMultipartRequest(operations, fileMap []byte, filesContent ...[]byte)
 
 	return request, nil
 }
+
+func TestGraphQLHandler_statusCodes(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			allUsers: [String!]!
+		}
+	`)
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithExecutor(ExecutorFunc(
+		func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"allUsers": []string{}}, nil
+		},
+	)))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, row := range []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"malformed json", http.MethodPost, `{"query": `, http.StatusBadRequest},
+		{"unparseable query", http.MethodPost, `{"query": "{ allUsers "}`, http.StatusBadRequest},
+		{"invalid query", http.MethodPost, `{"query": "{ allPosts }"}`, http.StatusBadRequest},
+		{"put", http.MethodPut, `{"query": "{ allUsers }"}`, http.StatusMethodNotAllowed},
+		{"delete", http.MethodDelete, ``, http.StatusMethodNotAllowed},
+	} {
+		t.Run(row.name, func(t *testing.T) {
+			request := httptest.NewRequest(row.method, "/graphql", strings.NewReader(row.body))
+			request.Header.Set("Content-Type", "application/json")
+			response := httptest.NewRecorder()
+			gateway.GraphQLHandler(response, request)
+
+			assert.Equal(t, row.status, response.Code)
+
+			// every error is a GraphQL response
+			assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+			result, err := readResultWithErrors(response, t)
+			if assert.Nil(t, err) {
+				assert.NotEmpty(t, result.Errors)
+			}
+			if row.status == http.StatusMethodNotAllowed {
+				assert.Equal(t, "GET, POST", response.Header().Get("Allow"))
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package gateway
+
+// IDFieldMap holds the name of the field that identifies the objects of a type when they are looked up
+// by another service, indexed by type. Types that aren't in the map are identified by their id field.
+type IDFieldMap map[string]string
+
+// FieldFor returns the name of the field that identifies objects of the type
+func (m IDFieldMap) FieldFor(typeName string) string {
+	if field, ok := m[typeName]; ok {
+		return field
+	}
+
+	return "id"
+}
+
+// WithTypeIDField returns an Option that identifies the objects of the type with the designated field
+// instead of id when a step has to look them up in another service. The value of the field is passed
+// wherever the id would be, ie as the id argument of the node field.
+func WithTypeIDField(typeName string, fieldName string) Option {
+	return func(g *Gateway) {
+		if g.idFields == nil {
+			g.idFields = IDFieldMap{}
+		}
+		g.idFields[typeName] = fieldName
+	}
+}
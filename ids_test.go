@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_typeIDFields(t *testing.T) {
+	ordersSchema, _ := graphql.LoadSchema(`
+		type User {
+			key: String!
+		}
+
+		type Order {
+			uuid: ID!
+			total: Int!
+			customer: User!
+		}
+
+		type Query {
+			orders: [Order!]!
+		}
+	`)
+	reviewsSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			reputation: Int!
+		}
+
+		type Order implements Node {
+			id: ID!
+			rating: Int!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			topRating: Int!
+		}
+	`)
+
+	// the ids that the reviews service was asked to look up
+	mutex := &sync.Mutex{}
+	lookups := []interface{}{}
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			if url == "orders" {
+				// the objects are identified by the fields we configured
+				assert.Contains(t, input.Query, gatewayIDAlias+": uuid")
+				assert.Contains(t, input.Query, gatewayIDAlias+": key")
+
+				return map[string]interface{}{
+					"orders": []interface{}{
+						map[string]interface{}{
+							"total":        10,
+							gatewayIDAlias: "order-1",
+							"customer": map[string]interface{}{
+								gatewayIDAlias: "user-1",
+							},
+						},
+					},
+				}, nil
+			}
+
+			mutex.Lock()
+			lookups = append(lookups, input.Variables[gatewayIDAlias])
+			mutex.Unlock()
+
+			if strings.Contains(input.Query, "rating") {
+				return map[string]interface{}{gatewayNodeAlias: map[string]interface{}{"rating": 5}}, nil
+			}
+			return map[string]interface{}{gatewayNodeAlias: map[string]interface{}{"reputation": 100}}, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: "orders", Schema: ordersSchema},
+		{URL: "reviews", Schema: reviewsSchema},
+	}, WithQueryerFactory(&factory), WithTypeIDField("Order", "uuid"), WithTypeIDField("User", "key"))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	reqCtx := &RequestContext{
+		Context: context.Background(),
+		Query:   `{ orders { total rating customer { reputation } } }`,
+	}
+	plans, err := gateway.GetPlans(reqCtx)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	result, err := gateway.Execute(reqCtx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.ElementsMatch(t, []interface{}{"order-1", "user-1"}, lookups)
+	assert.Equal(t, map[string]interface{}{
+		"orders": []interface{}{
+			map[string]interface{}{
+				"total":  10,
+				"rating": 5,
+				"customer": map[string]interface{}{
+					"reputation": 100,
+				},
+			},
+		},
+	}, result)
+}
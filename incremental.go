@@ -0,0 +1,288 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"strconv"
+)
+
+// A service that supports @defer and @stream can send its response as a multipart/mixed body: the first part
+// has the data that was ready right away and every part after that patches something in at a path. The
+// gateway doesn't stream responses to its clients so the parts are put back together into a regular response
+// before anything else looks at it. The patches don't have to arrive in order - a patch whose path isn't
+// there yet waits for the ones that put it there.
+
+// incrementalPayload is one part of an incremental response. Older servers send the patches at the top level
+// of a part, newer ones put them under incremental.
+type incrementalPayload struct {
+	Data        map[string]interface{} `json:"data"`
+	Errors      []interface{}          `json:"errors"`
+	Extensions  map[string]interface{} `json:"extensions"`
+	Path        []interface{}          `json:"path"`
+	Label       string                 `json:"label"`
+	Items       []interface{}          `json:"items"`
+	Incremental []*incrementalPayload  `json:"incremental"`
+}
+
+// incrementalResponse is the response that the parts are put together into
+type incrementalResponse struct {
+	Data       map[string]interface{} `json:"data"`
+	Errors     []interface{}          `json:"errors,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// incrementalReassemble returns the body as a regular GraphQL response if it was sent in parts. The boolean
+// is false if the body is already a regular response.
+func incrementalReassemble(contentType string, body []byte) ([]byte, bool, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/mixed" {
+		return body, false, nil
+	}
+
+	// apollo leaves the boundary at its default
+	boundary := params["boundary"]
+	if boundary == "" {
+		boundary = "-"
+	}
+
+	payloads := []*incrementalPayload{}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// some servers don't close the last part
+			if len(payloads) > 0 {
+				break
+			}
+			return nil, false, err
+		}
+
+		content, err := ioutil.ReadAll(part)
+		if err != nil && len(bytes.TrimSpace(content)) == 0 {
+			return nil, false, err
+		}
+
+		// a part can be there just to keep the connection open
+		content = bytes.TrimSpace(content)
+		if len(content) == 0 || bytes.Equal(content, []byte("{}")) {
+			continue
+		}
+
+		payload := &incrementalPayload{}
+		if err := decodeJSON(content, payload); err != nil {
+			return nil, false, err
+		}
+		payloads = append(payloads, payload)
+	}
+
+	if len(payloads) == 0 {
+		return nil, false, errors.New("incremental response did not have any parts")
+	}
+
+	reassembled, err := json.Marshal(incrementalMerge(payloads))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return reassembled, true, nil
+}
+
+// incrementalMerge puts the parts of a response together
+func incrementalMerge(payloads []*incrementalPayload) *incrementalResponse {
+	response := &incrementalResponse{}
+
+	// the patches are applied once everything else is known
+	patches := []*incrementalPayload{}
+	for i, payload := range payloads {
+		response.Errors = append(response.Errors, payload.Errors...)
+		for key, value := range payload.Extensions {
+			if response.Extensions == nil {
+				response.Extensions = map[string]interface{}{}
+			}
+			response.Extensions[key] = value
+		}
+
+		switch {
+		case len(payload.Incremental) > 0:
+			for _, patch := range payload.Incremental {
+				response.Errors = append(response.Errors, patch.Errors...)
+				patches = append(patches, patch)
+			}
+		case i == 0 && payload.Path == nil:
+			response.Data = payload.Data
+		case payload.Data != nil || payload.Items != nil:
+			patches = append(patches, payload)
+		}
+	}
+
+	// every pass applies the patches whose path is there, which could make room for the others
+	for len(patches) > 0 {
+		pending := []*incrementalPayload{}
+		for _, patch := range patches {
+			if !incrementalApply(response, patch, false) {
+				pending = append(pending, patch)
+			}
+		}
+
+		// the patches that are left can't be placed where they say they go so they go as close as they can
+		if len(pending) == len(patches) {
+			for _, patch := range pending {
+				incrementalApply(response, patch, true)
+			}
+			break
+		}
+		patches = pending
+	}
+
+	return response
+}
+
+// incrementalApply applies the patch to the response. It returns false if the path of the patch isn't there
+// yet, unless force is set in which case the items of a stream are placed at the end of the list.
+func incrementalApply(response *incrementalResponse, patch *incrementalPayload, force bool) bool {
+	if response.Data == nil {
+		response.Data = map[string]interface{}{}
+	}
+
+	// a deferred fragment is merged into the object at the path
+	if patch.Items == nil {
+		target, ok := incrementalLookup(response.Data, patch.Path)
+		if !ok {
+			return false
+		}
+		object, ok := target.(map[string]interface{})
+		if !ok {
+			return force
+		}
+
+		incrementalMergeObject(object, patch.Data)
+		return true
+	}
+
+	// the items of a stream start at the index at the end of the path
+	if len(patch.Path) < 2 {
+		return force
+	}
+	index, ok := incrementalIndex(patch.Path[len(patch.Path)-1])
+	if !ok {
+		return force
+	}
+	holder, ok := incrementalLookup(response.Data, patch.Path[:len(patch.Path)-2])
+	if !ok {
+		return false
+	}
+	key := patch.Path[len(patch.Path)-2]
+	value, ok := incrementalLookup(holder, []interface{}{key})
+	if !ok {
+		return false
+	}
+	list, ok := value.([]interface{})
+	if !ok && value != nil {
+		return force
+	}
+
+	// the items before these ones have to be there first
+	if index > len(list) {
+		if !force {
+			return false
+		}
+		index = len(list)
+	}
+
+	updated := append([]interface{}{}, list[:index]...)
+	updated = append(updated, patch.Items...)
+	if len(list) > len(updated) {
+		updated = append(updated, list[len(updated):]...)
+	}
+
+	switch holder := holder.(type) {
+	case map[string]interface{}:
+		if field, ok := key.(string); ok {
+			holder[field] = updated
+		}
+	case []interface{}:
+		if i, ok := incrementalIndex(key); ok {
+			holder[i] = updated
+		}
+	}
+
+	return true
+}
+
+// incrementalLookup returns the value at the path
+func incrementalLookup(value interface{}, path []interface{}) (interface{}, bool) {
+	for _, key := range path {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			field, ok := key.(string)
+			if !ok {
+				return nil, false
+			}
+			if value, ok = current[field]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			index, ok := incrementalIndex(key)
+			if !ok || index < 0 || index >= len(current) {
+				return nil, false
+			}
+			value = current[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// incrementalIndex returns the index in a path as an int
+func incrementalIndex(key interface{}) (int, bool) {
+	switch key := key.(type) {
+	case json.Number:
+		index, err := strconv.Atoi(key.String())
+		return index, err == nil
+	case float64:
+		return int(key), true
+	case int:
+		return key, true
+	}
+
+	return 0, false
+}
+
+// incrementalMergeObject adds the fields of the patch to the object, merging the objects that are in both
+func incrementalMergeObject(object map[string]interface{}, patch map[string]interface{}) {
+	for key, value := range patch {
+		existing, ok := object[key].(map[string]interface{})
+		update, isObject := value.(map[string]interface{})
+		if ok && isObject {
+			incrementalMergeObject(existing, update)
+			continue
+		}
+
+		existingList, ok := object[key].([]interface{})
+		updateList, isList := value.([]interface{})
+		if ok && isList && len(existingList) == len(updateList) {
+			for i, entry := range updateList {
+				existingEntry, ok := existingList[i].(map[string]interface{})
+				updateEntry, isObject := entry.(map[string]interface{})
+				if ok && isObject {
+					incrementalMergeObject(existingEntry, updateEntry)
+				} else {
+					existingList[i] = entry
+				}
+			}
+			continue
+		}
+
+		object[key] = value
+	}
+}
@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+// incrementalBody returns a multipart body with each of the parts
+func incrementalBody(boundary string, parts ...string) string {
+	body := ""
+	for _, part := range parts {
+		body += "\r\n--" + boundary + "\r\nContent-Type: application/json; charset=utf-8\r\n\r\n" + part
+	}
+	return body + "\r\n--" + boundary + "--\r\n"
+}
+
+func TestIncrementalReassemble(t *testing.T) {
+	// the patch for the friends of the viewer arrives before the one that puts the viewer there and
+	// the second batch of users arrives before the first
+	body := incrementalBody("graphql",
+		`{"data": {"users": [{"name": "alice"}]}, "hasNext": true}`,
+		`{"incremental": [{"data": {"friends": ["bob"]}, "path": ["viewer"], "label": "friends"}], "hasNext": true}`,
+		`{"incremental": [{"items": [{"name": "dave"}], "path": ["users", 2]}], "hasNext": true}`,
+		`{"incremental": [
+			{"data": {"viewer": {"name": "carol"}}, "path": [], "label": "viewer"},
+			{"items": [{"name": "erin"}], "path": ["users", 1], "errors": [{"message": "slow", "path": ["users", 1]}]}
+		], "hasNext": false}`,
+	)
+
+	reassembled, ok, err := incrementalReassemble(`multipart/mixed; boundary="graphql"; deferSpec=20220824`, []byte(body))
+	if !assert.Nil(t, err) || !assert.True(t, ok) {
+		return
+	}
+
+	response := map[string]interface{}{}
+	if !assert.Nil(t, json.Unmarshal(reassembled, &response)) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "alice"},
+			map[string]interface{}{"name": "erin"},
+			map[string]interface{}{"name": "dave"},
+		},
+		"viewer": map[string]interface{}{"name": "carol", "friends": []interface{}{"bob"}},
+	}, response["data"])
+	assert.Len(t, response["errors"], 1)
+
+	// regular responses are left alone
+	_, ok, err = incrementalReassemble("application/json", []byte(`{"data": {}}`))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestGateway_incrementalService(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			name: String!
+			bio: String
+		}
+
+		type Query {
+			users: [User!]!
+		}
+	`)
+
+	// the service sends the bio of the user and the second user after the rest of the response
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `multipart/mixed; boundary="-"; deferSpec=20220824`)
+		w.Write([]byte(incrementalBody("-",
+			`{"data": {"users": [{"name": "alice"}]}, "hasNext": true}`,
+			`{"incremental": [{"data": {"bio": "hello"}, "path": ["users", 0], "label": "bio"}], "hasNext": true}`,
+			`{"incremental": [{"items": [{"name": "bob"}], "path": ["users", 1]}], "hasNext": false}`,
+		)))
+	}))
+	defer service.Close()
+
+	gw, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx := &RequestContext{Context: context.Background(), Query: `{ users { name bio } }`}
+	plans, err := gw.GetPlans(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	result, err := gw.Execute(ctx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "alice", "bio": "hello"},
+			map[string]interface{}{"name": "bob"},
+		},
+	}, result)
+}
@@ -0,0 +1,160 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nautilus/graphql"
+)
+
+// The planner adds the id of an object to the query of a step (under gatewayIDAlias) when another step has to be
+// inserted into the object. The client never asked for it so a service that refuses to resolve it (ie, because
+// the id is hidden from the user by field-level authorization) shouldn't cost the client the rest of the step.
+// When every error in a response points at one of those fields, the response is used like any other and the
+// objects the errors point at are set aside. The steps that would have been inserted into them are never sent,
+// the fields they would have resolved are set to null (following the usual rules for non-null fields), and an
+// error explaining why is added to the response for each of them.
+
+// executorInjectedFieldsError is returned along with the response of a step when the only errors the service
+// sent back are for fields the gateway added to the query
+type executorInjectedFieldsError struct {
+	errs graphql.ErrorList
+}
+
+func (e *executorInjectedFieldsError) Error() string {
+	return e.errs.Error()
+}
+
+// executorUnresolvedObject is an object in the response of a step whose id the service didn't send back
+type executorUnresolvedObject struct {
+	// where the object is in the client's response
+	insertionPoint InsertionPoint
+	// what the service said about the id
+	err *graphql.Error
+}
+
+// executorInjectedFieldErrors returns the errors in err if every one of them points at a field the
+// gateway added to the query
+func executorInjectedFieldErrors(err error) (graphql.ErrorList, bool) {
+	var errs graphql.ErrorList
+	switch err := err.(type) {
+	case graphql.ErrorList:
+		errs = err
+	case *graphql.Error:
+		errs = graphql.ErrorList{err}
+	default:
+		return nil, false
+	}
+
+	for _, entry := range errs {
+		gqlErr, ok := entry.(*graphql.Error)
+		if !ok || len(gqlErr.Path) == 0 || gqlErr.Path[len(gqlErr.Path)-1] != gatewayIDAlias {
+			return nil, false
+		}
+	}
+
+	return errs, len(errs) > 0
+}
+
+// executorUnresolvedObjects returns the objects that the errors of a step inserted at the insertion point
+// are about
+func executorUnresolvedObjects(insertionPoint InsertionPoint, errs graphql.ErrorList) []*executorUnresolvedObject {
+	objects := []*executorUnresolvedObject{}
+	for _, entry := range errs {
+		gqlErr := entry.(*graphql.Error)
+		log.Warn("Service could not resolve a field the gateway added: ", gqlErr.Message, " ", gqlErr.Path)
+
+		// the error points at the id, the object is the one that holds it
+		path := executorClientErrorPath(insertionPoint, gqlErr.Path)
+		if len(path) < 2 {
+			continue
+		}
+		path = path[:len(path)-1]
+
+		// turn the path back into an insertion point
+		point := InsertionPoint{}
+		valid := true
+		for _, key := range path {
+			switch key := key.(type) {
+			case string:
+				point = append(point, PathPoint{Field: key})
+			case float64:
+				valid = valid && len(point) > 0
+				if valid {
+					point[len(point)-1].Indices = append(point[len(point)-1].Indices, int(key))
+				}
+			case int:
+				valid = valid && len(point) > 0
+				if valid {
+					point[len(point)-1].Indices = append(point[len(point)-1].Indices, key)
+				}
+			default:
+				valid = false
+			}
+		}
+		if !valid {
+			continue
+		}
+
+		objects = append(objects, &executorUnresolvedObject{insertionPoint: point, err: gqlErr})
+	}
+
+	return objects
+}
+
+// denyUnresolved marks the invocations of the dependent that would have been inserted into one of the unresolved
+// objects as turned down and returns the rest of the insertion points
+func (e *executorPendingStep) denyUnresolved(dependent *QueryPlanStep, insertPoints []InsertionPoint, unresolved []*executorUnresolvedObject) []InsertionPoint {
+	// the objects are recognized by their path since they don't have an id
+	skipped := map[string]bool{}
+	for _, object := range unresolved {
+		fields := []string{}
+		for _, point := range object.insertionPoint {
+			fields = append(fields, point.Field)
+		}
+
+		targeted := false
+		for _, target := range dependent.insertionPoints() {
+			targeted = targeted || strings.Join(target, ".") == strings.Join(fields, ".")
+		}
+		if !targeted {
+			continue
+		}
+		skipped[fmt.Sprint(object.insertionPoint.Path())] = true
+
+		// the error goes where the client would have found the first field of the dependent
+		path := object.insertionPoint.Path()
+		for _, field := range executorSelectedFields(dependent.SelectionSet, dependent.FragmentDefinitions) {
+			if !strings.HasPrefix(field.Alias, "__gateway") && !strings.HasPrefix(field.Name, "__") {
+				path = append(path, field.Alias)
+				break
+			}
+		}
+		missingErr := graphql.NewError("MISSING_ID", fmt.Sprintf(
+			"could not look up the rest of the %s since %s did not send back its id: %s",
+			dependent.ParentType, e.step.Location, object.err.Message,
+		))
+		missingErr.Path = path
+
+		e.pending.mutex.Lock()
+		e.pending.denied = append(e.pending.denied, &executorDeniedStep{
+			entry: &executorPendingStep{
+				pending:        e.pending,
+				step:           dependent,
+				insertionPoint: object.insertionPoint,
+			},
+			errs: graphql.ErrorList{missingErr},
+		})
+		e.pending.mutex.Unlock()
+	}
+
+	// an object that isn't in a list still has an insertion point, just without an id
+	result := []InsertionPoint{}
+	for _, point := range insertPoints {
+		if !skipped[fmt.Sprint(point.Path())] {
+			result = append(result, point)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutor_injectedFieldErrors(t *testing.T) {
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			users: [User]!
+			viewer: User
+		}
+	`)
+	postsSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			posts: [String!]
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	// the users service won't tell us the id of the second user or of the viewer
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"data": {
+				"users": [
+					{"name": "alice", "__gateway_id": "1"},
+					{"name": "bob", "__gateway_id": null}
+				],
+				"viewer": {"name": "carol", "__gateway_id": null}
+			},
+			"errors": [
+				{"message": "not allowed", "path": ["users", 1, "__gateway_id"]},
+				{"message": "not allowed", "path": ["viewer", "__gateway_id"]}
+			]
+		}`))
+	}))
+	defer users.Close()
+
+	// the posts service only gets asked about the user it can be asked about
+	requested := make(chan interface{}, 10)
+	posts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		input := struct{ Variables map[string]interface{} }{}
+		json.Unmarshal(body, &input)
+		requested <- input.Variables[gatewayIDAlias]
+
+		w.Write([]byte(`{"data": {"` + gatewayNodeAlias + `": {"posts": ["hello"]}}}`))
+	}))
+	defer posts.Close()
+
+	gw, err := New([]*graphql.RemoteSchema{
+		{Schema: usersSchema, URL: users.URL},
+		{Schema: postsSchema, URL: posts.URL},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx := &RequestContext{Context: context.Background(), Query: `{ users { name posts } viewer { name posts } }`}
+	plans, err := gw.GetPlans(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	result, err := gw.Execute(ctx, plans)
+
+	// the rest of the response is still there
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "alice", "posts": []interface{}{"hello"}},
+			map[string]interface{}{"name": "bob", "posts": nil},
+		},
+		"viewer": map[string]interface{}{"name": "carol", "posts": nil},
+	}, result)
+	close(requested)
+	ids := []interface{}{}
+	for id := range requested {
+		ids = append(ids, id)
+	}
+	assert.Equal(t, []interface{}{"1"}, ids)
+
+	// and the client is told why the posts are missing
+	errs, ok := err.(graphql.ErrorList)
+	if !assert.True(t, ok, "error was not a list: %v", err) || !assert.Len(t, errs, 2) {
+		return
+	}
+	paths := [][]interface{}{}
+	for _, entry := range errs {
+		gqlErr := entry.(*graphql.Error)
+		assert.Equal(t, "MISSING_ID", gqlErr.Extensions["code"])
+		assert.Contains(t, gqlErr.Message, "not allowed")
+		paths = append(paths, gqlErr.Path)
+	}
+	assert.ElementsMatch(t, [][]interface{}{{"users", 1, "posts"}, {"viewer", "posts"}}, paths)
+}
+
+func TestExecutorInjectedFieldErrors(t *testing.T) {
+	injected := &graphql.Error{Message: "no", Path: []interface{}{"users", float64(0), gatewayIDAlias}}
+	other := &graphql.Error{Message: "no", Path: []interface{}{"users", float64(0), "name"}}
+
+	errs, ok := executorInjectedFieldErrors(graphql.ErrorList{injected})
+	assert.True(t, ok)
+	assert.Len(t, errs, 1)
+
+	// any error that isn't about an injected field makes the whole step fail like before
+	_, ok = executorInjectedFieldErrors(graphql.ErrorList{injected, other})
+	assert.False(t, ok)
+	_, ok = executorInjectedFieldErrors(graphql.ErrorList{})
+	assert.False(t, ok)
+	_, ok = executorInjectedFieldErrors(assert.AnError)
+	assert.False(t, ok)
+}
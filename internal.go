@@ -28,13 +28,60 @@ type QueryField struct {
 	Resolver  func(context.Context, map[string]interface{}) (string, error)
 }
 
+// MutationField is a hook to add gateway-level mutations to a gateway, ie to clear a session that only
+// the gateway knows about. Unlike a QueryField, the resolver can return any value. Objects are returned as
+// maps whose keys are the names of the fields.
+type MutationField struct {
+	Name      string
+	Type      *ast.Type
+	Arguments ast.ArgumentDefinitionList
+	Resolver  func(context.Context, map[string]interface{}) (interface{}, error)
+}
+
+// ScalarField is a hook to add gateway-level query fields that return a scalar or an enum, ie the version
+// of the gateway. The value returned by the resolver is sent to the client as it is.
+type ScalarField struct {
+	Name      string
+	Type      *ast.Type
+	Arguments ast.ArgumentDefinitionList
+	Resolver  func(context.Context, map[string]interface{}) (interface{}, error)
+}
+
+// apiVersionField is the name of the field that reports the version given to WithAPIVersion
+const apiVersionField = "_apiVersion"
+
+// resolve invokes the resolver of the field, turning a panic into an error for the field
+func (f *QueryField) resolve(ctx context.Context, args map[string]interface{}) (id string, err error) {
+	defer recoverError(&err, "resolving the gateway field "+f.Name)
+
+	return f.Resolver(ctx, args)
+}
+
+// resolve invokes the resolver of the field, turning a panic into an error for the field
+func (f *ScalarField) resolve(ctx context.Context, args map[string]interface{}) (value interface{}, err error) {
+	defer recoverError(&err, "resolving the gateway field "+f.Name)
+
+	return f.Resolver(ctx, args)
+}
+
+// resolve invokes the resolver of the mutation, turning a panic into an error for the field
+func (f *MutationField) resolve(ctx context.Context, args map[string]interface{}) (value interface{}, err error) {
+	defer recoverError(&err, "resolving the gateway mutation "+f.Name)
+
+	return f.Resolver(ctx, args)
+}
+
 // Query takes a query definition and writes the result to the receiver
 func (g *Gateway) Query(ctx context.Context, input *graphql.QueryInput, receiver interface{}) error {
 	// a place to store the result
 	result := map[string]interface{}{}
 
-	// wrap the schema in something capable of introspection
-	introspectionSchema := introspection.WrapSchema(g.schema)
+	// wrap the part of the schema the caller can see in something capable of introspection
+	visible, err := g.visibleSchema(ctx)
+	if err != nil {
+		return err
+	}
+	introspectionSchema := introspection.WrapSchema(g.introspectedSchema(visible))
 
 	// for local stuff we don't care about fragment directives
 	querySelection, err := graphql.ApplyFragments(input.QueryDocument.Operations[0].SelectionSet, input.QueryDocument.Fragments)
@@ -42,8 +89,35 @@ func (g *Gateway) Query(ctx context.Context, input *graphql.QueryInput, receiver
 		return err
 	}
 
+	// the mutations of the gateway are resolved in a different way than the rest of the fields
+	if input.QueryDocument.Operations[0].Operation == ast.Mutation {
+		for _, field := range graphql.SelectedFields(querySelection) {
+			if field.Name == "__typename" {
+				result[field.Alias] = rootTypeName(g.exposedSchema(), ast.Mutation)
+				continue
+			}
+
+			value, err := g.resolveMutationField(ctx, input, field)
+			if err != nil {
+				return err
+			}
+
+			result[field.Alias] = value
+		}
+
+		return internalDecode(result, receiver)
+	}
+
 	for _, field := range graphql.SelectedFields(querySelection) {
+		// the gateway might not be allowed to describe itself
+		if g.introspectionDisabled(ctx) && (field.Name == "__schema" || field.Name == "__type") {
+			return ErrIntrospectionDisabled
+		}
+
 		switch field.Name {
+		case "__typename":
+			// the name of the root type doesn't need anyone else to answer it
+			result[field.Alias] = rootTypeName(g.exposedSchema(), input.QueryDocument.Operations[0].Operation)
 		case "__schema":
 			result[field.Alias] = g.introspectSchema(introspectionSchema, field.SelectionSet)
 		case "__type":
@@ -68,6 +142,23 @@ func (g *Gateway) Query(ctx context.Context, input *graphql.QueryInput, receiver
 			}
 		// to get this far and not be one of the above means that the field is a query field
 		default:
+			// the scalars are sent back as the resolver returned them
+			for _, sField := range g.scalarFields {
+				if field.Name != sField.Name {
+					continue
+				}
+
+				args, err := internalArguments(input, field)
+				if err != nil {
+					return err
+				}
+
+				value, err := sField.resolve(ctx, args)
+				if err != nil {
+					return err
+				}
+				result[field.Alias] = value
+			}
 
 			// look for the right field
 			for _, qField := range g.queryFields {
@@ -86,18 +177,118 @@ func (g *Gateway) Query(ctx context.Context, input *graphql.QueryInput, receiver
 					}
 
 					// find the id of the entity
-					id, err := qField.Resolver(ctx, args)
+					id, err := qField.resolve(ctx, args)
 					if err != nil {
 						return err
 					}
 
-					// assign the id to the response
-					result[field.Alias] = map[string]interface{}{"id": id}
+					// the id could be asked for under any number of aliases
+					selection, err := graphql.ApplyFragments(field.SelectionSet, input.QueryDocument.Fragments)
+					if err != nil {
+						return err
+					}
+
+					object := map[string]interface{}{}
+					for _, selected := range graphql.SelectedFields(selection) {
+						if selected.Name == "id" {
+							object[selected.Alias] = id
+						}
+					}
+
+					// assign the object to the response
+					result[field.Alias] = object
 				}
 			}
 		}
 	}
 
+	return internalDecode(result, receiver)
+}
+
+// internalArguments returns the values of the arguments passed to the field
+func internalArguments(input *graphql.QueryInput, field *ast.Field) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for _, arg := range field.Arguments {
+		value, err := arg.Value.Value(input.Variables)
+		if err != nil {
+			return nil, err
+		}
+		args[arg.Name] = value
+	}
+
+	return args, nil
+}
+
+// resolveMutationField invokes the resolver of the gateway mutation for the field and returns the parts
+// of the value that the field selects
+func (g *Gateway) resolveMutationField(ctx context.Context, input *graphql.QueryInput, field *ast.Field) (interface{}, error) {
+	for _, mField := range g.mutationFields {
+		if field.Name != mField.Name {
+			continue
+		}
+
+		// consolidate the arguments in something that's easy to use
+		args := map[string]interface{}{}
+		for _, arg := range field.Arguments {
+			value, err := arg.Value.Value(input.Variables)
+			if err != nil {
+				return nil, err
+			}
+			args[arg.Name] = value
+		}
+
+		value, err := mField.resolve(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+
+		return internalSelectValue(value, field.SelectionSet, input.QueryDocument.Fragments)
+	}
+
+	return nil, fmt.Errorf("Could not find gateway mutation %s", field.Name)
+}
+
+// internalSelectValue returns the parts of the value that are asked for by the selection set
+func internalSelectValue(value interface{}, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) (interface{}, error) {
+	// scalars don't have anything to select
+	if len(selectionSet) == 0 || value == nil {
+		return value, nil
+	}
+
+	switch value := value.(type) {
+	case []interface{}:
+		list := []interface{}{}
+		for _, item := range value {
+			selected, err := internalSelectValue(item, selectionSet, fragments)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, selected)
+		}
+		return list, nil
+
+	case map[string]interface{}:
+		selection, err := graphql.ApplyFragments(selectionSet, fragments)
+		if err != nil {
+			return nil, err
+		}
+
+		object := map[string]interface{}{}
+		for _, field := range graphql.SelectedFields(selection) {
+			selected, err := internalSelectValue(value[field.Name], field.SelectionSet, fragments)
+			if err != nil {
+				return nil, err
+			}
+			object[field.Alias] = selected
+		}
+		return object, nil
+	}
+
+	return nil, fmt.Errorf("Could not select fields of %T", value)
+}
+
+// internalDecode writes the result of a query against the internal schema to the receiver
+func internalDecode(result map[string]interface{}, receiver interface{}) error {
 	// assign the result under the data key to the receiver
 	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 		TagName: "json",
@@ -250,6 +441,8 @@ func (g *Gateway) introspectInputValue(iv *introspection.InputValue, selectionSe
 			result[field.Alias] = iv.Description
 		case "type":
 			result[field.Alias] = g.introspectType(iv.Type, field.SelectionSet)
+		case "defaultValue":
+			result[field.Alias] = iv.DefaultValue
 		}
 	}
 
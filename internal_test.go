@@ -2,11 +2,13 @@ package gateway
 
 import (
 	"context"
+	"sync"
 	"testing"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/nautilus/graphql"
 	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
 )
 
 func schemaTestLoadQuery(query string, target interface{}, variables map[string]interface{}) error {
@@ -295,3 +297,189 @@ func TestSchema_resolveNodeIDFromArg(t *testing.T) {
 		ID string `json:"id"`
 	}{ID: "my-id"}}, result)
 }
+
+func TestGateway_mutationFields(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			version: String!
+		}
+
+		type Mutation {
+			increment: Int!
+		}
+	`)
+
+	// the order that the fields of the mutation were resolved in
+	mutex := &sync.Mutex{}
+	calls := []string{}
+	count := 0
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			// every field in the query is an increment
+			result := map[string]interface{}{}
+			for _, field := range graphql.SelectedFields(input.QueryDocument.Operations[0].SelectionSet) {
+				count++
+				calls = append(calls, field.Alias)
+				result[field.Alias] = count
+			}
+			return result, nil
+		})
+	})
+
+	logout := &MutationField{
+		Name: "logout",
+		Type: ast.NonNullNamedType("Boolean", &ast.Position{}),
+		Arguments: ast.ArgumentDefinitionList{
+			{Name: "session", Type: ast.NonNullNamedType("String", &ast.Position{})},
+		},
+		Resolver: func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			calls = append(calls, "logout:"+args["session"].(string))
+			return true, nil
+		},
+	}
+
+	gateway, err := New([]*graphql.RemoteSchema{{URL: "counter", Schema: schema}}, WithQueryerFactory(&factory), WithMutationFields(logout))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the mutation shows up next to the ones from the services
+	mutation := gateway.schema.Mutation
+	if assert.NotNil(t, mutation) {
+		assert.NotNil(t, mutation.Fields.ForName("increment"))
+		assert.NotNil(t, mutation.Fields.ForName("logout"))
+	}
+
+	reqCtx := &RequestContext{
+		Context:   context.Background(),
+		Query:     `mutation ($session: String!) { first: increment logout(session: $session) second: increment third: increment }`,
+		Variables: map[string]interface{}{"session": "abc"},
+	}
+	plans, err := gateway.GetPlans(reqCtx)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	result, err := gateway.Execute(reqCtx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the fields are resolved in the order they were asked for, sending the neighbors in the same service together
+	assert.Equal(t, []string{"first", "logout:abc", "second", "third"}, calls)
+	assert.Equal(t, map[string]interface{}{
+		"first":  1,
+		"logout": true,
+		"second": 2,
+		"third":  3,
+	}, result)
+}
+
+func TestGateway_scalarFields(t *testing.T) {
+	usersSchema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+		}
+
+		type Query {
+			users: [User!]!
+		}
+	`)
+	clockSchema, _ := graphql.LoadSchema(`
+		enum Season {
+			SUMMER
+			WINTER
+		}
+
+		type Query {
+			serverTime: String!
+			season: Season!
+		}
+	`)
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			values := map[string]interface{}{
+				"serverTime": "noon",
+				"season":     "SUMMER",
+				"users":      []interface{}{map[string]interface{}{"id": "1"}},
+			}
+
+			result := map[string]interface{}{}
+			for _, field := range graphql.SelectedFields(input.QueryDocument.Operations[0].SelectionSet) {
+				result[field.Alias] = values[field.Name]
+			}
+			return result, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: "users", Schema: usersSchema},
+		{URL: "clock", Schema: clockSchema},
+	}, WithQueryerFactory(&factory), WithAPIVersion("1.2.3"))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	execute := func(query string) (*QueryPlan, map[string]interface{}, error) {
+		reqCtx := &RequestContext{Context: context.Background(), Query: query}
+		plans, err := gateway.GetPlans(reqCtx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		result, err := gateway.Execute(reqCtx, plans)
+		return plans[0], result, err
+	}
+
+	t.Run("gateway field", func(t *testing.T) {
+		_, result, err := execute(`{ _apiVersion }`)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, map[string]interface{}{"_apiVersion": "1.2.3"}, result)
+	})
+
+	t.Run("only scalars", func(t *testing.T) {
+		plan, result, err := execute(`{ serverTime season }`)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// both of the fields come from one step that doesn't need anything else
+		if assert.Len(t, plan.RootStep.Then, 1) {
+			assert.Equal(t, "clock", plan.RootStep.Then[0].Location)
+			assert.Len(t, plan.RootStep.Then[0].Then, 0)
+		}
+		assert.Equal(t, map[string]interface{}{"serverTime": "noon", "season": "SUMMER"}, result)
+	})
+
+	t.Run("scalars next to objects", func(t *testing.T) {
+		plan, result, err := execute(`{ serverTime users { id } version: _apiVersion }`)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// every service gets one step and none of them depend on each other
+		locations := []string{}
+		for _, step := range plan.RootStep.Then {
+			locations = append(locations, step.Location)
+			assert.Len(t, step.Then, 0)
+		}
+		assert.ElementsMatch(t, []string{"clock", "users", internalSchemaLocation}, locations)
+
+		assert.Equal(t, map[string]interface{}{
+			"serverTime": "noon",
+			"users":      []interface{}{map[string]interface{}{"id": "1"}},
+			"version":    "1.2.3",
+		}, result)
+	})
+}
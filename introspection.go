@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// IntrospectAPI reconstructs the schema of the API behind the queryer. Unlike graphql.IntrospectAPI, the
+// default values of arguments and input fields as well as the deprecation of fields and enum values are
+// preserved so that the gateway reports the same metadata as the service.
+func IntrospectAPI(queryer graphql.Queryer, opts ...*graphql.IntrospectOptions) (*ast.Schema, error) {
+	// the options have to be applied to the queryer that talks to the service
+	for _, opt := range opts {
+		queryer = opt.Apply(queryer)
+	}
+
+	// hold onto the response so we can pull out the information that doesn't survive the conversion
+	recorder := &introspectionRecorder{Queryer: queryer}
+
+	schema, err := graphql.IntrospectAPI(recorder, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if recorder.result == nil || recorder.result.Schema == nil {
+		return schema, nil
+	}
+
+	if err := introspectionApplyMetadata(schema, recorder.result.Schema); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// introspectionRecorder is a queryer that holds onto the result of the introspection query it forwards
+type introspectionRecorder struct {
+	graphql.Queryer
+	result *graphql.IntrospectionQueryResult
+}
+
+func (r *introspectionRecorder) Query(ctx context.Context, input *graphql.QueryInput, receiver interface{}) error {
+	err := r.Queryer.Query(ctx, input, receiver)
+
+	if result, ok := receiver.(*graphql.IntrospectionQueryResult); ok {
+		r.result = result
+	}
+
+	return err
+}
+
+// introspectionApplyMetadata adds the default values and deprecations in the introspection result to the schema
+func introspectionApplyMetadata(schema *ast.Schema, remoteSchema *graphql.IntrospectionQuerySchema) error {
+	for _, remoteType := range remoteSchema.Types {
+		definition, ok := schema.Types[remoteType.Name]
+		if !ok {
+			continue
+		}
+
+		for _, remoteField := range remoteType.Fields {
+			field := definition.Fields.ForName(remoteField.Name)
+			if field == nil {
+				continue
+			}
+
+			if remoteField.IsDeprecated {
+				field.Directives = append(field.Directives, introspectionDeprecation(remoteField.DeprecationReason, ast.LocationFieldDefinition))
+			}
+
+			for _, remoteArg := range remoteField.Args {
+				arg := field.Arguments.ForName(remoteArg.Name)
+				if arg == nil || remoteArg.DefaultValue == "" {
+					continue
+				}
+
+				value, err := introspectionParseValue(remoteArg.DefaultValue)
+				if err != nil {
+					return fmt.Errorf("could not parse default value of %s.%s(%s): %s", remoteType.Name, remoteField.Name, remoteArg.Name, err.Error())
+				}
+				arg.DefaultValue = value
+			}
+		}
+
+		// input fields end up in the definition's list of fields
+		for _, remoteField := range remoteType.InputFields {
+			field := definition.Fields.ForName(remoteField.Name)
+			if field == nil || remoteField.DefaultValue == "" {
+				continue
+			}
+
+			value, err := introspectionParseValue(remoteField.DefaultValue)
+			if err != nil {
+				return fmt.Errorf("could not parse default value of %s.%s: %s", remoteType.Name, remoteField.Name, err.Error())
+			}
+			field.DefaultValue = value
+		}
+
+		for _, remoteValue := range remoteType.EnumValues {
+			value := definition.EnumValues.ForName(remoteValue.Name)
+			if value == nil || !remoteValue.IsDeprecated {
+				continue
+			}
+
+			value.Directives = append(value.Directives, introspectionDeprecation(remoteValue.DeprecationReason, ast.LocationEnumValue))
+		}
+	}
+
+	return nil
+}
+
+// introspectionDeprecation builds the @deprecated directive for something deprecated with the given reason
+func introspectionDeprecation(reason string, location ast.DirectiveLocation) *ast.Directive {
+	directive := &ast.Directive{
+		Name:      "deprecated",
+		Arguments: ast.ArgumentList{},
+		Location:  location,
+	}
+
+	if reason != "" {
+		directive.Arguments = append(directive.Arguments, &ast.Argument{
+			Name:  "reason",
+			Value: &ast.Value{Kind: ast.StringValue, Raw: reason},
+		})
+	}
+
+	return directive
+}
+
+// introspectionParseValue parses a value the way it's reported by introspection (ie, a GraphQL literal)
+func introspectionParseValue(raw string) (*ast.Value, error) {
+	query, err := parser.ParseQuery(&ast.Source{Input: fmt.Sprintf("{ value(value: %s) }", raw)})
+	if err != nil {
+		return nil, err
+	}
+
+	return query.Operations[0].SelectionSet[0].(*ast.Field).Arguments[0].Value, nil
+}
@@ -0,0 +1,391 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nautilus/graphql"
+)
+
+// Not every service can be introspected with the standard query. Some sit behind a proxy that wants a
+// header the gateway doesn't send, some reject the deeply nested ofType chains of the standard query, and
+// some expose their SDL somewhere that is faster to fetch than an introspection. IntrospectionOptions
+// covers those cases:
+//
+//	schema, err := gateway.IntrospectRemoteSchemaWithOptions("http://users/api/graphql", gateway.IntrospectionOptions{
+//		Headers:   http.Header{"Authorization": []string{"Bearer ..."}},
+//		TypeDepth: 4,
+//		Retries:   3,
+//		Backoff:   time.Second,
+//	})
+//
+// When SDLEndpoint is set, the schema is read from that endpoint instead of being introspected.
+//
+// The gateway introspects the services it was only given the url of the same way, when it's created and every
+// time it reloads, with the options of WithIntrospectionOptions and WithServiceIntrospectionOptions along with
+// the credentials of the service.
+
+// DefaultIntrospectionConcurrency is the number of services that are introspected at once by
+// IntrospectRemoteSchemasWithOptions when the options don't say otherwise
+const DefaultIntrospectionConcurrency = 8
+
+// IntrospectionOptions configures how the schema of a service is retrieved
+type IntrospectionOptions struct {
+	// Headers are added to every request sent to the service
+	Headers http.Header
+	// Client is the http.Client used to send the requests
+	Client *http.Client
+	// Context is used for the requests and to cut the retries short
+	Context context.Context
+	// TypeDepth is the number of ofType levels the introspection query asks for. The default is 7, which
+	// is enough for a type like [[String!]!]!. Types that are wrapped more than the depth cause an error.
+	TypeDepth int
+	// Retries is the number of times a failed introspection is tried again
+	Retries int
+	// Backoff is how long to wait before the first retry. It doubles with every retry after that.
+	Backoff time.Duration
+	// SDLEndpoint is where the SDL of the service can be fetched with a GET. A relative path is resolved
+	// against the url of the service.
+	SDLEndpoint string
+	// Concurrency is the number of services that IntrospectRemoteSchemasWithOptions introspects at once
+	Concurrency int
+	// Credentials authenticate every request sent to the service, after the headers are added
+	Credentials CredentialProvider
+}
+
+// WithIntrospectionOptions returns an Option that introspects every service the gateway was only given the
+// url of the way the options describe
+func WithIntrospectionOptions(opts IntrospectionOptions) Option {
+	return func(g *Gateway) {
+		g.introspectionOptions = mergeIntrospectionOptions(g.introspectionOptions, opts)
+	}
+}
+
+// WithServiceIntrospectionOptions returns an Option that introspects the service at url the way the options
+// describe, on top of the options given to WithIntrospectionOptions
+func WithServiceIntrospectionOptions(url string, opts IntrospectionOptions) Option {
+	return func(g *Gateway) {
+		if g.serviceIntrospectionOptions == nil {
+			g.serviceIntrospectionOptions = map[string]IntrospectionOptions{}
+		}
+		g.serviceIntrospectionOptions[url] = mergeIntrospectionOptions(g.serviceIntrospectionOptions[url], opts)
+	}
+}
+
+// serviceIntrospection returns the options to introspect the service at url with
+func (g *Gateway) serviceIntrospection(url string) IntrospectionOptions {
+	opts := mergeIntrospectionOptions(g.introspectionOptions, g.serviceIntrospectionOptions[url])
+
+	// the introspection query needs the same credentials as everything else
+	if provider, ok := g.credentials[url]; ok {
+		opts.Credentials = provider
+	}
+
+	return opts
+}
+
+// mergeIntrospectionOptions combines the options with the ones that come later taking precedence
+func mergeIntrospectionOptions(opts ...IntrospectionOptions) IntrospectionOptions {
+	result := IntrospectionOptions{}
+	for _, opt := range opts {
+		for key, values := range opt.Headers {
+			if result.Headers == nil {
+				result.Headers = http.Header{}
+			}
+			result.Headers[key] = values
+		}
+		if opt.Client != nil {
+			result.Client = opt.Client
+		}
+		if opt.Context != nil {
+			result.Context = opt.Context
+		}
+		if opt.TypeDepth != 0 {
+			result.TypeDepth = opt.TypeDepth
+		}
+		if opt.Retries != 0 {
+			result.Retries = opt.Retries
+		}
+		if opt.Backoff != 0 {
+			result.Backoff = opt.Backoff
+		}
+		if opt.SDLEndpoint != "" {
+			result.SDLEndpoint = opt.SDLEndpoint
+		}
+		if opt.Concurrency != 0 {
+			result.Concurrency = opt.Concurrency
+		}
+		if opt.Credentials != nil {
+			result.Credentials = opt.Credentials
+		}
+	}
+
+	return result
+}
+
+// IntrospectRemoteSchemaWithOptions retrieves the schema of the service at the designated url the way the
+// options describe. A service that fails is tried again as many times as the options allow.
+func IntrospectRemoteSchemaWithOptions(url string, opts IntrospectionOptions) (*graphql.RemoteSchema, error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	backoff := opts.Backoff
+	for attempt := 0; ; attempt++ {
+		var schema *graphql.RemoteSchema
+		var err error
+		if opts.SDLEndpoint != "" {
+			schema, err = introspectionFetchSDL(ctx, url, opts)
+		} else {
+			schema, err = introspectionWithOptions(ctx, url, opts)
+		}
+		if err == nil || attempt >= opts.Retries {
+			return schema, err
+		}
+
+		// wait before trying again unless we've been told to stop
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// IntrospectRemoteSchemasWithOptions invokes IntrospectRemoteSchemaWithOptions for each of the designated urls.
+// The services are introspected at the same time, up to the concurrency of the options. The schemas are
+// returned in the same order as the urls.
+func IntrospectRemoteSchemasWithOptions(urls []string, opts ...IntrospectionOptions) ([]*graphql.RemoteSchema, error) {
+	options := mergeIntrospectionOptions(opts...)
+
+	workers := options.Concurrency
+	if workers <= 0 {
+		workers = DefaultIntrospectionConcurrency
+	}
+	if workers > len(urls) {
+		workers = len(urls)
+	}
+
+	schemas := make([]*graphql.RemoteSchema, len(urls))
+	errs := make([]error, len(urls))
+
+	// the workers take the index of the next service off of the channel
+	indices := make(chan int)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				schemas[index], errs[index] = IntrospectRemoteSchemaWithOptions(urls[index], options)
+			}
+		}()
+	}
+	for index := range urls {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+
+	// every service that we can't introspect is reported
+	serviceErrs := MultiError{}
+	for index, err := range errs {
+		if err != nil {
+			serviceErrs = append(serviceErrs, &ServiceError{URL: urls[index], Stage: ServiceErrorIntrospection, Err: err})
+		}
+	}
+	if len(serviceErrs) > 0 {
+		return nil, serviceErrs
+	}
+
+	return schemas, nil
+}
+
+// introspectionWithOptions sends the introspection query to the service with the headers, client,
+// and depth of the options
+func introspectionWithOptions(ctx context.Context, url string, opts IntrospectionOptions) (*graphql.RemoteSchema, error) {
+	// the queryer only keeps the last list of middlewares it's given so they go in together
+	middlewares := []graphql.NetworkMiddleware{}
+	if len(opts.Headers) > 0 {
+		middlewares = append(middlewares, func(r *http.Request) error {
+			for key, values := range opts.Headers {
+				r.Header[key] = values
+			}
+			return nil
+		})
+	}
+	client := opts.Client
+	if opts.Credentials != nil {
+		middlewares = append(middlewares, credentialMiddleware(url, opts.Credentials))
+		if clientProvider, ok := opts.Credentials.(ClientCredentialProvider); ok && client == nil {
+			client = clientProvider.HTTPClient()
+		}
+	}
+
+	introspectOpts := []*graphql.IntrospectOptions{graphql.IntrospectWithContext(ctx)}
+	if len(middlewares) > 0 {
+		introspectOpts = append(introspectOpts, graphql.IntrospectWithMiddlewares(middlewares...))
+	}
+	if client != nil {
+		introspectOpts = append(introspectOpts, graphql.IntrospectWithHTTPClient(client))
+	}
+
+	var queryer graphql.Queryer = graphql.NewSingleRequestQueryer(url)
+	for _, opt := range introspectOpts {
+		queryer = opt.Apply(queryer)
+	}
+	if opts.TypeDepth > 0 {
+		queryer = &introspectionDepthQueryer{Queryer: queryer, depth: opts.TypeDepth}
+	}
+
+	return introspectRemoteSchema(ctx, url, queryer)
+}
+
+// introspectionFetchSDL reads the schema of the service from its SDL endpoint
+func introspectionFetchSDL(ctx context.Context, serviceURL string, opts IntrospectionOptions) (*graphql.RemoteSchema, error) {
+	base, err := url.Parse(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := base.Parse(opts.SDLEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	for key, values := range opts.Headers {
+		request.Header[key] = values
+	}
+	if opts.Credentials != nil {
+		if err := opts.Credentials.Authenticate(request, serviceURL); err != nil {
+			return nil, err
+		}
+	}
+
+	client := opts.Client
+	if clientProvider, ok := opts.Credentials.(ClientCredentialProvider); ok && client == nil {
+		client = clientProvider.HTTPClient()
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch the sdl from %s: %s", endpoint, response.Status)
+	}
+
+	schema, err := graphql.LoadSchema(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return &graphql.RemoteSchema{URL: serviceURL, Schema: schema}, nil
+}
+
+// introspectionDepthQueryer is a queryer that replaces the standard introspection query with one that
+// asks for a different number of ofType levels
+type introspectionDepthQueryer struct {
+	graphql.Queryer
+	depth int
+}
+
+func (q *introspectionDepthQueryer) Query(ctx context.Context, input *graphql.QueryInput, receiver interface{}) error {
+	if input.Query != graphql.IntrospectionQuery {
+		return q.Queryer.Query(ctx, input, receiver)
+	}
+
+	withDepth := *input
+	withDepth.Query = introspectionQueryWithDepth(q.depth)
+	if err := q.Queryer.Query(ctx, &withDepth, receiver); err != nil {
+		return err
+	}
+
+	// a type that is wrapped more than we asked for comes back cut off which can't be turned into a schema
+	if result, ok := receiver.(*graphql.IntrospectionQueryResult); ok && result.Schema != nil {
+		return introspectionCheckTypeRefs(result.Schema, q.depth)
+	}
+
+	return nil
+}
+
+// introspectionQueryWithDepth returns the standard introspection query with the designated number of
+// ofType levels in its TypeRef fragment
+func introspectionQueryWithDepth(depth int) string {
+	query := graphql.IntrospectionQuery
+	if start := strings.Index(query, "fragment TypeRef"); start >= 0 {
+		query = query[:start]
+	}
+
+	fragment := &strings.Builder{}
+	fragment.WriteString("fragment TypeRef on __Type {\n\t\tkind\n\t\tname\n")
+	for level := 1; level <= depth; level++ {
+		indent := strings.Repeat("\t", level+1)
+		fragment.WriteString(indent + "ofType {\n" + indent + "\tkind\n" + indent + "\tname\n")
+	}
+	for level := depth; level >= 1; level-- {
+		fragment.WriteString(strings.Repeat("\t", level+1) + "}\n")
+	}
+	fragment.WriteString("\t}\n")
+
+	return query + fragment.String()
+}
+
+// introspectionCheckTypeRefs returns an error if any of the type references in the schema end in a wrapper
+func introspectionCheckTypeRefs(schema *graphql.IntrospectionQuerySchema, depth int) error {
+	check := func(coordinate string, ref graphql.IntrospectionTypeRef) error {
+		for current := &ref; current.Kind == "NON_NULL" || current.Kind == "LIST"; current = current.OfType {
+			if current.OfType == nil {
+				return fmt.Errorf("the type of %s is wrapped more than %d times, increase the TypeDepth", coordinate, depth)
+			}
+		}
+		return nil
+	}
+
+	for _, remoteType := range schema.Types {
+		for _, field := range remoteType.Fields {
+			if err := check(remoteType.Name+"."+field.Name, field.Type); err != nil {
+				return err
+			}
+			for _, arg := range field.Args {
+				if err := check(remoteType.Name+"."+field.Name+"("+arg.Name+":)", arg.Type); err != nil {
+					return err
+				}
+			}
+		}
+		for _, field := range remoteType.InputFields {
+			if err := check(remoteType.Name+"."+field.Name, field.Type); err != nil {
+				return err
+			}
+		}
+	}
+	for _, directive := range schema.Directives {
+		for _, arg := range directive.Args {
+			if err := check("@"+directive.Name+"("+arg.Name+":)", arg.Type); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntrospectRemoteSchemaWithOptions(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			users: [String!]!
+		}
+	`)
+	introspection, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the service needs a token, fails the first two times it's asked, and keeps track of the queries it gets
+	mutex := &sync.Mutex{}
+	attempts := 0
+	queries := []string{}
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		attempt := attempts
+		mutex.Unlock()
+
+		if r.URL.Path == "/schema.graphql" {
+			w.Write([]byte(`type Query { posts: [String!]! }`))
+			return
+		}
+		if r.Header.Get("Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if attempt <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		queries = append(queries, string(body))
+		mutex.Unlock()
+
+		r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+		introspection.GraphQLHandler(w, r)
+	}))
+	defer service.Close()
+
+	// without the header, nothing works
+	_, err = IntrospectRemoteSchemaWithOptions(service.URL+"/api/graphql", IntrospectionOptions{})
+	assert.NotNil(t, err)
+
+	// with it, the retries get us past the failures
+	mutex.Lock()
+	attempts = 0
+	mutex.Unlock()
+	options := IntrospectionOptions{
+		Headers: http.Header{"Token": []string{"secret"}},
+		Retries: 2,
+		Backoff: time.Millisecond,
+	}
+	remote, err := IntrospectRemoteSchemaWithOptions(service.URL+"/api/graphql", options)
+	if assert.Nil(t, err) {
+		assert.Equal(t, service.URL+"/api/graphql", remote.URL)
+		assert.NotNil(t, remote.Schema.Query.Fields.ForName("users"))
+	}
+
+	// [String!]! needs 3 levels of ofType to be described
+	mutex.Lock()
+	attempts = 10
+	mutex.Unlock()
+	options.TypeDepth = 2
+	_, err = IntrospectRemoteSchemaWithOptions(service.URL, options)
+	assert.NotNil(t, err)
+
+	options.TypeDepth = 3
+	remote, err = IntrospectRemoteSchemaWithOptions(service.URL, options)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "[String!]!", remote.Schema.Query.Fields.ForName("users").Type.String())
+	}
+	mutex.Lock()
+	if assert.NotEmpty(t, queries) {
+		assert.Equal(t, 3, strings.Count(queries[len(queries)-1], "ofType"))
+	}
+	mutex.Unlock()
+
+	// the sdl can be fetched from a path relative to the service
+	options.SDLEndpoint = "/schema.graphql"
+	remote, err = IntrospectRemoteSchemaWithOptions(service.URL+"/api/graphql", options)
+	if assert.Nil(t, err) {
+		assert.Equal(t, service.URL+"/api/graphql", remote.URL)
+		assert.NotNil(t, remote.Schema.Query.Fields.ForName("posts"))
+	}
+}
+
+func TestIntrospectionQueryWithDepth(t *testing.T) {
+	// the TypeRef fragment has one ofType for every level
+	for _, depth := range []int{1, 3, 7} {
+		query := introspectionQueryWithDepth(depth)
+		fragment := query[strings.Index(query, "fragment TypeRef"):]
+		assert.Equal(t, depth, strings.Count(fragment, "ofType"))
+		assert.Equal(t, strings.Count(fragment, "{"), strings.Count(fragment, "}"))
+	}
+}
+
+func TestIntrospectRemoteSchemasWithOptions(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			users: [String!]!
+		}
+	`)
+	introspection, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// keep track of how many services are being introspected at once
+	mutex := &sync.Mutex{}
+	inFlight, maxInFlight := 0, 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		introspection.GraphQLHandler(w, r)
+
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+	})
+
+	urls := []string{}
+	for i := 0; i < 5; i++ {
+		service := httptest.NewServer(handler)
+		defer service.Close()
+		urls = append(urls, service.URL)
+	}
+
+	schemas, err := IntrospectRemoteSchemasWithOptions(urls, IntrospectionOptions{Concurrency: 2})
+	if !assert.Nil(t, err) {
+		return
+	}
+	mutex.Lock()
+	assert.Equal(t, 2, maxInFlight)
+	mutex.Unlock()
+
+	// the schemas come back in the order of the urls
+	for i, remote := range schemas {
+		assert.Equal(t, urls[i], remote.URL)
+	}
+
+	// and every service that fails is reported
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	_, err = IntrospectRemoteSchemas(urls[0], down.URL, urls[1], down.URL)
+	errs, ok := err.(MultiError)
+	if assert.True(t, ok, "error was not a MultiError: %v", err) && assert.Len(t, errs, 2) {
+		assert.Equal(t, down.URL, errs[0].URL)
+		assert.Equal(t, ServiceErrorIntrospection, errs[0].Stage)
+	}
+}
+
+func TestGateway_introspectionOptions(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			users: [String!]!
+		}
+	`)
+	introspection, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the service needs a token and its sdl is somewhere else
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Token") != "secret" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/schema.graphql" {
+			w.Write([]byte(`type Query { posts: [String!]! }`))
+			return
+		}
+		introspection.GraphQLHandler(w, r)
+	}))
+	defer service.Close()
+
+	// the gateway can't introspect the service on its own
+	_, err = New([]*graphql.RemoteSchema{{URL: service.URL}}, WithServiceCredentials(service.URL, BearerToken("token")))
+	assert.NotNil(t, err)
+
+	// but it can with the options, along with the credentials of the service
+	gateway, err := New([]*graphql.RemoteSchema{{URL: service.URL}},
+		WithServiceCredentials(service.URL, BearerToken("token")),
+		WithIntrospectionOptions(IntrospectionOptions{Headers: http.Header{"Token": []string{"secret"}}}),
+	)
+	if assert.Nil(t, err) {
+		assert.NotNil(t, gateway.schema.Query.Fields.ForName("users"))
+	}
+
+	// and so can the reloads, with the options of the service
+	gateway, err = New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}},
+		WithServiceCredentials(service.URL, BearerToken("token")),
+		WithIntrospectionOptions(IntrospectionOptions{Headers: http.Header{"Token": []string{"secret"}}}),
+		WithServiceIntrospectionOptions(service.URL, IntrospectionOptions{SDLEndpoint: "/schema.graphql"}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = gateway.Reload([]*graphql.RemoteSchema{{URL: service.URL}})
+	if assert.Nil(t, err) {
+		assert.NotNil(t, gateway.schema.Query.Fields.ForName("posts"))
+	}
+}
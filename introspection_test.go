@@ -0,0 +1,113 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntrospectAPI_preservesMetadata(t *testing.T) {
+	schema, err := graphql.LoadSchema(`
+		enum Role {
+			ADMIN
+			MEMBER @deprecated(reason: "everyone is an admin now")
+		}
+
+		input UserFilter {
+			role: Role = ADMIN
+			names: [String!] = ["John"]
+		}
+
+		type User {
+			id: ID!
+			name: String! @deprecated
+		}
+
+		type Query {
+			users(first: Int = 25, filter: UserFilter): [User!]!
+		}
+	`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the service we are going to introspect
+	service, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// introspect the service like we would any other API
+	introspected, err := IntrospectAPI(introspectionTestQueryer(service), graphql.IntrospectWithContext(context.Background()))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	users := introspected.Query.Fields.ForName("users")
+	if assert.NotNil(t, users.Arguments.ForName("first").DefaultValue) {
+		assert.Equal(t, "25", users.Arguments.ForName("first").DefaultValue.String())
+	}
+	assert.Nil(t, users.Arguments.ForName("filter").DefaultValue)
+
+	filter := introspected.Types["UserFilter"]
+	if assert.NotNil(t, filter.Fields.ForName("role").DefaultValue) {
+		assert.Equal(t, "ADMIN", filter.Fields.ForName("role").DefaultValue.String())
+	}
+	if assert.NotNil(t, filter.Fields.ForName("names").DefaultValue) {
+		assert.Equal(t, `["John"]`, filter.Fields.ForName("names").DefaultValue.String())
+	}
+
+	assert.NotNil(t, introspected.Types["User"].Fields.ForName("name").Directives.ForName("deprecated"))
+	assert.Nil(t, introspected.Types["User"].Fields.ForName("id").Directives.ForName("deprecated"))
+
+	deprecatedValue := introspected.Types["Role"].EnumValues.ForName("MEMBER").Directives.ForName("deprecated")
+	if assert.NotNil(t, deprecatedValue) {
+		assert.Equal(t, "everyone is an admin now", deprecatedValue.Arguments.ForName("reason").Value.Raw)
+	}
+	assert.Nil(t, introspected.Types["Role"].EnumValues.ForName("ADMIN").Directives.ForName("deprecated"))
+
+	// a gateway in front of the introspected service should report the same metadata
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: introspected, URL: "url1"}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	reintrospected, err := IntrospectAPI(introspectionTestQueryer(gateway))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	first := reintrospected.Query.Fields.ForName("users").Arguments.ForName("first")
+	if assert.NotNil(t, first.DefaultValue) {
+		assert.Equal(t, "25", first.DefaultValue.String())
+	}
+	assert.NotNil(t, reintrospected.Types["Role"].EnumValues.ForName("MEMBER").Directives.ForName("deprecated"))
+}
+
+// introspectionTestQueryer returns a queryer that executes introspection queries against the gateway
+func introspectionTestQueryer(gateway *Gateway) graphql.Queryer {
+	return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+		result := graphql.IntrospectionQueryResult{}
+
+		reqCtx := &RequestContext{Context: context.Background(), Query: input.Query}
+		plans, err := gateway.GetPlans(reqCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := gateway.Execute(reqCtx, plans)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{TagName: "json", Result: &result})
+		if err != nil {
+			return nil, err
+		}
+
+		return result, decoder.Decode(response)
+	})
+}
@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nautilus/graphql"
+)
+
+// WithMaxRequestBodySize returns an Option that limits the number of bytes the gateway will read
+// from the body of a request. Larger requests are rejected before any of the body is parsed.
+func WithMaxRequestBodySize(bytes int64) Option {
+	return func(g *Gateway) {
+		g.maxRequestBodySize = bytes
+	}
+}
+
+// WithMaxQueryLength returns an Option that limits the number of characters in the query of an operation
+func WithMaxQueryLength(chars int) Option {
+	return func(g *Gateway) {
+		g.maxQueryLength = chars
+	}
+}
+
+// WithMaxBatchSize returns an Option that limits the number of operations that can be sent in a single batched request
+func WithMaxBatchSize(operations int) Option {
+	return func(g *Gateway) {
+		g.maxBatchSize = operations
+	}
+}
+
+// WithMaxPlanDepth returns an Option that rejects queries whose plan has more than depth steps that
+// depend on each other, ie a query that goes back and forth between two services
+func WithMaxPlanDepth(depth int) Option {
+	return func(g *Gateway) {
+		g.maxPlanDepth = depth
+	}
+}
+
+// WithMaxPlanSteps returns an Option that rejects queries whose plan has more than the designated number of steps
+func WithMaxPlanSteps(steps int) Option {
+	return func(g *Gateway) {
+		g.maxPlanSteps = steps
+	}
+}
+
+// WithMaxStepExecutions returns an Option that limits the number of times the steps of a plan can be executed
+// for a single request. Steps inside of lists are executed once per entry so this is the only way to bound
+// the number of requests a query can cause.
+func WithMaxStepExecutions(executions int) Option {
+	return func(g *Gateway) {
+		g.maxStepExecutions = executions
+	}
+}
+
+// limitedBody wraps the body of a request so we can tell when it was cut off for being too large
+type limitedBody struct {
+	io.ReadCloser
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+
+	// http.MaxBytesReader only fails with something other than EOF once it has hit the limit
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		b.exceeded = true
+	}
+
+	return n, err
+}
+
+// limitRequestBody makes sure we never read more of the request body than we are configured to. The returned
+// body is nil if there is no limit.
+func (g *Gateway) limitRequestBody(w http.ResponseWriter, r *http.Request) *limitedBody {
+	limit := g.requestBodyLimit(r.Context())
+	if limit <= 0 || r.Body == nil {
+		return nil
+	}
+
+	body := &limitedBody{
+		ReadCloser: http.MaxBytesReader(w, r.Body, limit),
+		limit:      limit,
+	}
+	r.Body = body
+
+	return body
+}
+
+// checkOperationLimits returns an error if the operations exceed any of the configured limits
+func (g *Gateway) checkOperationLimits(operations []*HTTPOperation) error {
+	if g.maxBatchSize > 0 && len(operations) > g.maxBatchSize {
+		return fmt.Errorf("too many operations in batch: found %v, the limit is %v", len(operations), g.maxBatchSize)
+	}
+
+	if g.maxQueryLength > 0 {
+		for _, operation := range operations {
+			// no need to count the characters if there aren't enough bytes to go over the limit
+			if len(operation.Query) > g.maxQueryLength && utf8.RuneCountInString(operation.Query) > g.maxQueryLength {
+				return fmt.Errorf("query is too long: the limit is %v characters", g.maxQueryLength)
+			}
+		}
+	}
+
+	return nil
+}
+
+// rejectTooLarge tells the client that their request exceeded one of the gateway's limits
+func (g *Gateway) rejectTooLarge(w http.ResponseWriter, r *http.Request, err error) {
+	response, _ := json.Marshal(g.errorResponse(r.Context(), nil, err, "REQUEST_TOO_LARGE"))
+	emitResponse(w, http.StatusRequestEntityTooLarge, string(response))
+}
+
+// plannerCheckLimits returns an error if any of the plans have more steps than are allowed
+func plannerCheckLimits(plans QueryPlanList, maxDepth int, maxSteps int) error {
+	if maxDepth <= 0 && maxSteps <= 0 {
+		return nil
+	}
+
+	for _, plan := range plans {
+		if plan.RootStep == nil {
+			continue
+		}
+		steps := 0
+
+		// visit counts the children of the step. depth is the number of steps above them
+		var visit func(step *QueryPlanStep, depth int) error
+		visit = func(step *QueryPlanStep, depth int) error {
+			for _, child := range step.Then {
+				steps++
+				if maxSteps > 0 && steps > maxSteps {
+					return planLimitError(child.InsertionPoint, fmt.Sprintf("query plan has more than %v steps", maxSteps))
+				}
+				if maxDepth > 0 && depth+1 > maxDepth {
+					return planLimitError(child.InsertionPoint, fmt.Sprintf("query plan is more than %v steps deep", maxDepth))
+				}
+
+				if err := visit(child, depth+1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if err := visit(plan.RootStep, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// planLimitError builds the error for a step that went over one of the limits. The path points to
+// where the step's fields would have been inserted.
+func planLimitError(insertionPoint []string, message string) error {
+	path := []interface{}{}
+	for _, point := range insertionPoint {
+		path = append(path, point)
+	}
+
+	if len(insertionPoint) > 0 {
+		message = fmt.Sprintf("%s at %s", message, strings.Join(insertionPoint, "."))
+	}
+
+	err := graphql.NewError("QUERY_PLAN_TOO_LARGE", message)
+	err.Path = path
+	return graphql.ErrorList{err}
+}
@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLHandler_requestLimits(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value(input: String): String!
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithMaxRequestBodySize(256),
+		WithMaxQueryLength(32),
+		WithMaxBatchSize(2),
+		WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"value": "hello"}, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	table := []struct {
+		Name    string
+		Request *http.Request
+		Status  int
+	}{
+		{
+			"Within limits",
+			httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ value }"}`)),
+			http.StatusOK,
+		},
+		{
+			"Body too large",
+			httptest.NewRequest("POST", "/graphql", strings.NewReader(
+				`{"query": "{ value }", "variables": {"input": "`+strings.Repeat("a", 1024)+`"}}`,
+			)),
+			http.StatusRequestEntityTooLarge,
+		},
+		{
+			"Query too long",
+			httptest.NewRequest("POST", "/graphql", strings.NewReader(
+				`{"query": "{ value(input: \"`+strings.Repeat("a", 32)+`\") }"}`,
+			)),
+			http.StatusRequestEntityTooLarge,
+		},
+		{
+			"Query too long in GET",
+			httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`{ value(input: "`+strings.Repeat("a", 32)+`") }`), nil),
+			http.StatusRequestEntityTooLarge,
+		},
+		{
+			"Too many operations",
+			httptest.NewRequest("POST", "/graphql", strings.NewReader(
+				`[{"query": "{ value }"}, {"query": "{ value }"}, {"query": "{ value }"}]`,
+			)),
+			http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			responseRecorder := httptest.NewRecorder()
+			gateway.GraphQLHandler(responseRecorder, row.Request)
+
+			assert.Equal(t, row.Status, responseRecorder.Code)
+
+			// every response has to be a well-formed graphql response
+			result := &formattedErrorsResult{}
+			if !assert.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), result)) {
+				return
+			}
+
+			if row.Status == http.StatusOK {
+				assert.Len(t, result.Errors, 0)
+				return
+			}
+			if assert.Len(t, result.Errors, 1) {
+				assert.Equal(t, "REQUEST_TOO_LARGE", result.Errors[0].Extensions["code"])
+			}
+		})
+	}
+}
+
+func TestGateway_planLimits(t *testing.T) {
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			user: User
+			users: [User!]!
+		}
+	`)
+	friendsSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			bestFriend: User
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	// count the requests that make it to a service
+	var requests int32
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			atomic.AddInt32(&requests, 1)
+			if url == "friends" {
+				return map[string]interface{}{
+					gatewayNodeAlias: map[string]interface{}{"bestFriend": map[string]interface{}{gatewayIDAlias: "2"}},
+				}, nil
+			}
+			if _, ok := input.Variables[gatewayIDAlias]; ok {
+				return map[string]interface{}{gatewayNodeAlias: map[string]interface{}{"name": "Bob"}}, nil
+			}
+
+			user := map[string]interface{}{"name": "Alice", gatewayIDAlias: "1"}
+			return map[string]interface{}{
+				"user":  user,
+				"users": []interface{}{user, user, user},
+			}, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: usersSchema, URL: "users"},
+		{Schema: friendsSchema, URL: "friends"},
+	},
+		WithQueryerFactory(&factory),
+		WithMaxPlanDepth(2),
+		WithMaxStepExecutions(4),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// every level of bestFriend goes back to the other service
+	request := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ user { bestFriend { name bestFriend { name bestFriend { name } } } } }"}`))
+	response := httptest.NewRecorder()
+	gateway.GraphQLHandler(response, request)
+
+	result := map[string]interface{}{}
+	if !assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &result)) {
+		return
+	}
+
+	// the query is rejected before anything is sent
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+	errs, ok := result["errors"].([]interface{})
+	if !assert.True(t, ok) || !assert.Len(t, errs, 1) {
+		return
+	}
+	err1 := errs[0].(map[string]interface{})
+	assert.Equal(t, "QUERY_PLAN_TOO_LARGE", err1["extensions"].(map[string]interface{})["code"])
+	// the path points to one of the steps that were too deep
+	path, _ := err1["path"].([]interface{})
+	if assert.True(t, len(path) >= 2) {
+		assert.Equal(t, []interface{}{"user", "bestFriend"}, path[:2])
+	}
+
+	// a shallow plan can still execute too many steps if it goes through a list
+	request = httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ users { bestFriend { name } } }"}`))
+	response = httptest.NewRecorder()
+	gateway.GraphQLHandler(response, request)
+
+	result = map[string]interface{}{}
+	if !assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &result)) {
+		return
+	}
+	errs, ok = result["errors"].([]interface{})
+	if !assert.True(t, ok) || !assert.Len(t, errs, 1) {
+		return
+	}
+	err1 = errs[0].(map[string]interface{})
+	assert.Equal(t, "QUERY_PLAN_TOO_LARGE", err1["extensions"].(map[string]interface{})["code"])
+}
@@ -0,0 +1,193 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+// Some clients put the values of their arguments in the query instead of using variables, so the same operation
+// shows up as a different query for every value ({ user(id: "1") { name } }, { user(id: "2") { name } }, ...).
+// Each of them gets planned on its own and the services see as many different queries, which defeats their caches
+// too. With WithLiteralExtraction, the gateway moves the values of the arguments of the fields at the root of the
+// operation into variables before it plans the query:
+//
+//	{ user(id: "1") { name } }
+//
+// becomes
+//
+//	query ($__gateway_literal_0: ID!) { user(id: $__gateway_literal_0) { name } }
+//
+// with {"__gateway_literal_0": "1"} added to the variables. The variables are declared with the type of the
+// argument so enums and input objects keep their meaning, and the queries sent to the services use them as well.
+// The arguments of directives, the default values of the client's variables, and the values that already refer
+// to a variable are left alone, as are the fields of the fragments.
+//
+// The plans of the rewritten queries are cached under the hash of the rewritten query. The values of a query that
+// was rewritten aren't in its plan, so the gateway doesn't tell the client a hash it could send on its own.
+
+// literalVariablePrefix is the start of the names of the variables that hold the extracted values
+const literalVariablePrefix = "__gateway_literal_"
+
+// WithLiteralExtraction returns an Option that moves the values of the arguments at the root of the operations
+// into variables so the queries that only differ by those values share a plan
+func WithLiteralExtraction() Option {
+	return func(g *Gateway) {
+		g.literalExtraction = true
+	}
+}
+
+// extractLiterals moves the values of the arguments of the root fields of the operation into variables. It returns
+// the rewritten query along with the variables to use, or false if there was nothing to extract.
+func extractLiterals(schema *ast.Schema, query string, operationName string, variables map[string]interface{}) (string, map[string]interface{}, bool) {
+	// a query that isn't valid will be reported by the planner, with the values where the client put them
+	document, parseErr := parser.ParseQuery(&ast.Source{Input: query})
+	if parseErr != nil || len(validator.Validate(schema, document)) > 0 {
+		return "", nil, false
+	}
+
+	// the variables are shared between the operations so only the one that will run can be rewritten
+	var operation *ast.OperationDefinition
+	if operationName == "" && len(document.Operations) == 1 {
+		operation = document.Operations[0]
+	} else if operationName != "" {
+		operation = document.Operations.ForName(operationName)
+	}
+	if operation == nil {
+		return "", nil, false
+	}
+
+	var root *ast.Definition
+	switch operation.Operation {
+	case ast.Query:
+		root = schema.Query
+	case ast.Mutation:
+		root = schema.Mutation
+	case ast.Subscription:
+		root = schema.Subscription
+	}
+	if root == nil {
+		return "", nil, false
+	}
+
+	extracted := map[string]interface{}{}
+	for key, value := range variables {
+		extracted[key] = value
+	}
+
+	count := 0
+	for _, selection := range operation.SelectionSet {
+		field, ok := selection.(*ast.Field)
+		if !ok {
+			continue
+		}
+		// the fields we don't know about will be reported by the planner
+		definition := root.Fields.ForName(field.Name)
+		if definition == nil {
+			continue
+		}
+
+		for _, argument := range field.Arguments {
+			argumentDefinition := definition.Arguments.ForName(argument.Name)
+			// a null says that the argument was given on purpose so it stays where it is
+			if argumentDefinition == nil || argument.Value == nil || argument.Value.Kind == ast.NullValue || literalHasVariables(argument.Value) {
+				continue
+			}
+			value, err := literalValue(argument.Value)
+			if err != nil {
+				continue
+			}
+
+			// the name can't be one the client already uses
+			name := literalVariablePrefix + strconv.Itoa(count)
+			for operation.VariableDefinitions.ForName(name) != nil {
+				count++
+				name = literalVariablePrefix + strconv.Itoa(count)
+			}
+			count++
+
+			operation.VariableDefinitions = append(operation.VariableDefinitions, &ast.VariableDefinition{
+				Variable: name,
+				Type:     argumentDefinition.Type,
+			})
+			argument.Value = &ast.Value{Kind: ast.Variable, Raw: name}
+			extracted[name] = value
+		}
+	}
+	if count == 0 {
+		return "", nil, false
+	}
+
+	rewritten, err := plannerPrintQuery(document)
+	if err != nil {
+		return "", nil, false
+	}
+
+	return rewritten, extracted, true
+}
+
+// extractLiterals rewrites the query of the request if the gateway extracts literals. It returns the key of the
+// plans of the rewritten query, or the key of the request if the query was left alone.
+func (g *Gateway) extractLiterals(ctx *RequestContext, schema *ast.Schema) *string {
+	if !g.literalExtraction || ctx.Query == "" {
+		return &ctx.CacheKey
+	}
+
+	query, variables, ok := extractLiterals(schema, ctx.Query, ctx.OperationName, ctx.Variables)
+	if !ok {
+		return &ctx.CacheKey
+	}
+	ctx.Query = query
+	ctx.Variables = variables
+
+	hash := sha256.Sum256([]byte(query))
+	key := hex.EncodeToString(hash[:])
+	return &key
+}
+
+// literalHasVariables returns true if the value refers to a variable
+func literalHasVariables(value *ast.Value) bool {
+	if value.Kind == ast.Variable {
+		return true
+	}
+	for _, child := range value.Children {
+		if literalHasVariables(child.Value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// literalValue returns the value of a literal the way it would be sent as a variable
+func literalValue(value *ast.Value) (interface{}, error) {
+	switch value.Kind {
+	case ast.ListValue:
+		// an empty list is still a list
+		list := []interface{}{}
+		for _, child := range value.Children {
+			item, err := literalValue(child.Value)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case ast.ObjectValue:
+		object := map[string]interface{}{}
+		for _, child := range value.Children {
+			item, err := literalValue(child.Value)
+			if err != nil {
+				return nil, err
+			}
+			object[child.Name] = item
+		}
+		return object, nil
+	}
+
+	return value.Value(nil)
+}
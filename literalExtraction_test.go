@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_literalExtraction(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			user(id: ID!): User
+		}
+	`)
+
+	// the service remembers what it was sent
+	mutex := sync.Mutex{}
+	sent := []*graphql.QueryInput{}
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			mutex.Lock()
+			sent = append(sent, input)
+			mutex.Unlock()
+
+			return map[string]interface{}{
+				"user": map[string]interface{}{"name": "user " + input.Variables["__gateway_literal_0"].(string)},
+			}, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{{URL: "users", Schema: schema}},
+		WithQueryerFactory(&factory),
+		WithAutomaticQueryPlanCache(),
+		WithLiteralExtraction(),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	plans := []QueryPlanList{}
+	for _, id := range []string{"1", "2"} {
+		ctx := &RequestContext{Context: context.Background(), Query: `{ user(id: "` + id + `") { name } }`}
+		plan, err := gateway.GetPlans(ctx)
+		if !assert.Nil(t, err) {
+			return
+		}
+		plans = append(plans, plan)
+
+		// the second query only differs by its values so it gets the plan of the first one
+		assert.Equal(t, id == "2", ctx.planCacheHit)
+
+		result, err := gateway.Execute(ctx, plan)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, map[string]interface{}{"user": map[string]interface{}{"name": "user " + id}}, result)
+
+		// the client isn't told a hash that wouldn't come with the values
+		assert.Equal(t, "", ctx.CacheKey)
+	}
+	assert.Equal(t, plans[0], plans[1])
+
+	// the services see the same query with different variables
+	if assert.Len(t, sent, 2) {
+		assert.Equal(t, sent[0].Query, sent[1].Query)
+		assert.Contains(t, sent[0].Query, "$__gateway_literal_0: ID!")
+		assert.Equal(t, "1", sent[0].Variables["__gateway_literal_0"])
+		assert.Equal(t, "2", sent[1].Variables["__gateway_literal_0"])
+	}
+}
+
+func TestExtractLiterals(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		enum Status {
+			ACTIVE
+			BANNED
+		}
+
+		input UserFilter {
+			status: Status
+			names: [String!]
+		}
+
+		type User {
+			name(full: Boolean): String!
+		}
+
+		type Query {
+			users(filter: UserFilter, status: Status = ACTIVE, first: Int!): [User!]!
+			user(id: ID): User
+		}
+	`)
+
+	query, variables, ok := extractLiterals(schema, `query($first: Int!, $withName: Boolean!) {
+		users(filter: { status: BANNED, names: [] }, status: BANNED, first: $first) {
+			name(full: true) @include(if: $withName)
+		}
+		user(id: null) @skip(if: false) {
+			name
+		}
+	}`, "", map[string]interface{}{"first": 10, "withName": true})
+	if !assert.True(t, ok) {
+		return
+	}
+
+	// the enums and the input objects are declared with the types of their arguments
+	assert.Contains(t, query, "$__gateway_literal_0: UserFilter")
+	assert.Contains(t, query, "$__gateway_literal_1: Status")
+	assert.Contains(t, query, "users(filter: $__gateway_literal_0, status: $__gateway_literal_1, first: $first)")
+	assert.Equal(t, map[string]interface{}{
+		"first":               10,
+		"withName":            true,
+		"__gateway_literal_0": map[string]interface{}{"status": "BANNED", "names": []interface{}{}},
+		"__gateway_literal_1": "BANNED",
+	}, variables)
+
+	// the nested fields, the directives, and the nulls are left alone
+	assert.Contains(t, query, "name(full: true) @include(if: $withName)")
+	assert.Contains(t, query, "user(id: null) @skip(if: false)")
+
+	// a query without values stays as it is
+	_, _, ok = extractLiterals(schema, `query($first: Int!) { users(first: $first) { name } }`, "", nil)
+	assert.False(t, ok)
+
+	// and so does a query that isn't valid
+	_, _, ok = extractLiterals(schema, `{ users(first: "ten") { name } }`, "", nil)
+	assert.False(t, ok)
+}
@@ -0,0 +1,77 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// When a field can be resolved by more than one service (replicated reference data, a read replica that mirrors
+// another service, etc), the planner goes to the service of the parent and then to the ones it already has to visit.
+// If that doesn't settle it, the preferred locations are tried in order. A field can also be pinned to a service,
+// in which case it is always resolved there.
+
+// LocationOverrides holds the service that resolves a field, indexed by the field's coordinate (ie, User.name)
+type LocationOverrides map[string]string
+
+// PlannerWithLocationPreferences is an interface for planners that can be told which service to
+// use for the fields that more than one service can resolve
+type PlannerWithLocationPreferences interface {
+	WithLocationPreferences(preferred []string, overrides LocationOverrides) QueryPlanner
+}
+
+// WithLocationPriority returns an Option that sets the order in which the services are considered for a field
+// that none of the services around it can resolve
+func WithLocationPriority(urls ...string) Option {
+	return func(g *Gateway) {
+		g.locationPreferences = urls
+	}
+}
+
+// WithFieldLocationOverride returns an Option that makes the planner always resolve the field of the
+// designated type with the service at url
+func WithFieldLocationOverride(parentType string, field string, url string) Option {
+	return func(g *Gateway) {
+		if g.locationOverrides == nil {
+			g.locationOverrides = LocationOverrides{}
+		}
+		g.locationOverrides[parentType+"."+field] = url
+	}
+}
+
+// WithLocationPreferences returns a version of the planner that uses the preferred locations
+// to pick between the services that can resolve a field
+func (p *MinQueriesPlanner) WithLocationPreferences(preferred []string, overrides LocationOverrides) QueryPlanner {
+	p.PreferredLocations = preferred
+	p.LocationOverrides = overrides
+	return p
+}
+
+// checkLocationOverrides returns an error if one of the overrides points to a service that can't resolve the field
+func checkLocationOverrides(overrides LocationOverrides, locations FieldURLMap) error {
+	// check the fields in a consistent order so the error doesn't change between runs
+	coordinates := []string{}
+	for coordinate := range overrides {
+		coordinates = append(coordinates, coordinate)
+	}
+	sort.Strings(coordinates)
+
+	for _, coordinate := range coordinates {
+		url := overrides[coordinate]
+
+		parts := strings.SplitN(coordinate, ".", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid location override for %s: expected Type.field", coordinate)
+		}
+
+		urls, err := locations.URLFor(parts[0], parts[1])
+		if err != nil {
+			return fmt.Errorf("Invalid location override for %s: no service defines the field", coordinate)
+		}
+		if !stringsContain(urls, url) {
+			return fmt.Errorf("Invalid location override for %s: %s does not define the field (it is defined by %s)", coordinate, url, strings.Join(urls, ", "))
+		}
+	}
+
+	return nil
+}
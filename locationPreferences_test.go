@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_locationPreferences(t *testing.T) {
+	// sources returns fresh copies of the schemas since merging them changes them
+	sources := func() []*graphql.RemoteSchema {
+		// the primary service owns the users and the countries
+		primary, _ := graphql.LoadSchema(`
+			type Country {
+				code: String!
+				name: String!
+			}
+
+			type User {
+				firstName: String!
+				country: Country!
+			}
+
+			type Query {
+				allUsers: [User!]!
+				countries: [Country!]!
+			}
+		`)
+		// the replica mirrors the countries
+		replica, _ := graphql.LoadSchema(`
+			type Country {
+				code: String!
+				name: String!
+			}
+
+			type Query {
+				countries: [Country!]!
+			}
+		`)
+
+		return []*graphql.RemoteSchema{
+			{URL: "primary", Schema: primary},
+			{URL: "replica", Schema: replica},
+		}
+	}
+
+	// locations returns the url of each step under the root of the plan for the query
+	locations := func(t *testing.T, query string, opts ...Option) [][]string {
+		gateway, err := New(sources(), opts...)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+
+		plans, err := gateway.GetPlans(&RequestContext{Context: context.Background(), Query: query})
+		if !assert.Nil(t, err) {
+			return nil
+		}
+
+		result := [][]string{}
+		for _, step := range plans[0].RootStep.Then {
+			urls := []string{step.Queryer.(*graphql.SingleRequestQueryer).URL()}
+			for _, dependent := range step.Then {
+				urls = append(urls, dependent.Queryer.(*graphql.SingleRequestQueryer).URL())
+			}
+			result = append(result, urls)
+		}
+		return result
+	}
+
+	t.Run("Global priority", func(t *testing.T) {
+		query := `{ countries { code name } }`
+
+		// without any preferences, the first service that defines the field wins
+		assert.Equal(t, [][]string{{"primary"}}, locations(t, query))
+
+		assert.Equal(t, [][]string{{"replica"}}, locations(t, query, WithLocationPriority("replica", "primary")))
+	})
+
+	t.Run("Parent location comes first", func(t *testing.T) {
+		// the country of the user is already coming from the primary service so there's no point in
+		// asking the replica for its name
+		query := `{ allUsers { country { name } } }`
+
+		assert.Equal(t, [][]string{{"primary"}}, locations(t, query, WithLocationPriority("replica")))
+	})
+
+	t.Run("Field override", func(t *testing.T) {
+		query := `{ allUsers { firstName } countries { name } }`
+
+		assert.Equal(t, [][]string{{"primary"}}, locations(t, query, WithLocationPriority("primary")))
+
+		// the countries don't follow the rest of the query
+		planned := locations(t, query,
+			WithLocationPriority("primary"),
+			WithFieldLocationOverride("Query", "countries", "replica"),
+		)
+		assert.ElementsMatch(t, [][]string{{"primary"}, {"replica"}}, planned)
+	})
+
+	t.Run("Invalid override", func(t *testing.T) {
+		_, err := New(sources(), WithFieldLocationOverride("Query", "allUsers", "replica"))
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "Query.allUsers")
+			assert.Contains(t, err.Error(), "replica does not define the field")
+		}
+
+		_, err = New(sources(), WithFieldLocationOverride("User", "lastName", "primary"))
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Some services can resolve fields they don't own: a cache or a read model with a copy of the users can answer
+// User.displayName but its copy could be behind the service that owns the users. Tiers tell the planner how much
+// to trust each service with the fields it shares with others. The planner only considers the services in the
+// lowest tier that can resolve a field, and then picks between them the way it always does (the parent's
+// service, then the ones it already has to visit, then the preferred locations):
+//
+//	gateway.New(sources,
+//		gateway.WithFieldTier("http://read-model", 1),
+//		gateway.WithFieldTierOverride("Product", "title", "http://read-model", 0),
+//	)
+//
+// Services that aren't given a tier are in tier 0. A request that can't read anything out of date asks for
+// strong freshness, either with the X-Gateway-Freshness header or with "freshness": "strong" in the extensions of
+// the operation. Its fields can only come from the services whose tier is no higher than the one set with
+// WithStrongFreshnessTier (0 unless it's set) and it fails if one of them can't be resolved by any of those.
+// The overrides change which service is preferred for a field but not whether a service can be trusted with a
+// request that asks for strong freshness: that only depends on the service's own tier. The plans of those
+// requests are cached apart from the others, under the hash of their query followed by ":strong", so a client
+// that persisted a query can ask for it with either freshness.
+
+// FreshnessHeader is the header that a client can use to ask for the freshness of the data in the response
+const FreshnessHeader = "X-Gateway-Freshness"
+
+// FreshnessStrong is the freshness that only lets the services in the lowest tiers resolve the fields of a request
+const FreshnessStrong = "strong"
+
+// LocationTiers holds how much each service is trusted to resolve the fields that more than one service can
+// resolve. Lower tiers are preferred.
+type LocationTiers struct {
+	// the tier of each service, indexed by url
+	Services map[string]int
+	// the tier of a service for a single field, indexed by coordinate and then url
+	Fields map[string]map[string]int
+	// the highest tier that can resolve the fields of a request that asks for strong freshness
+	StrongFreshnessMax int
+}
+
+// PlannerWithLocationTiers is an interface for planners that can pick between the services that can resolve a
+// field based on their tiers
+type PlannerWithLocationTiers interface {
+	WithLocationTiers(tiers LocationTiers) QueryPlanner
+}
+
+// WithFieldTier returns an Option that puts the service at url in the designated tier for every field it resolves
+func WithFieldTier(serviceURL string, tier int) Option {
+	return func(g *Gateway) {
+		if g.locationTiers.Services == nil {
+			g.locationTiers.Services = map[string]int{}
+		}
+		g.locationTiers.Services[serviceURL] = tier
+	}
+}
+
+// WithFieldTierOverride returns an Option that puts the service at url in the designated tier for a single field
+func WithFieldTierOverride(parentType string, field string, serviceURL string, tier int) Option {
+	return func(g *Gateway) {
+		if g.locationTiers.Fields == nil {
+			g.locationTiers.Fields = map[string]map[string]int{}
+		}
+		coordinate := parentType + "." + field
+		if g.locationTiers.Fields[coordinate] == nil {
+			g.locationTiers.Fields[coordinate] = map[string]int{}
+		}
+		g.locationTiers.Fields[coordinate][serviceURL] = tier
+	}
+}
+
+// WithStrongFreshnessTier returns an Option that sets the highest tier that can resolve the fields of a request
+// that asks for strong freshness
+func WithStrongFreshnessTier(maxTier int) Option {
+	return func(g *Gateway) {
+		g.locationTiers.StrongFreshnessMax = maxTier
+	}
+}
+
+// WithLocationTiers returns a version of the planner that uses the tiers to pick between the services that can
+// resolve a field
+func (p *MinQueriesPlanner) WithLocationTiers(tiers LocationTiers) QueryPlanner {
+	p.LocationTiers = tiers
+	return p
+}
+
+// tier returns the tier of the service for the field
+func (t LocationTiers) tier(parentType string, field string, location string) int {
+	if tier, ok := t.Fields[parentType+"."+field][location]; ok {
+		return tier
+	}
+
+	return t.Services[location]
+}
+
+// lowest returns the locations that are in the lowest tier for the field, in the order they were given
+func (t LocationTiers) lowest(parentType string, field string, locations []string) []string {
+	if len(t.Services) == 0 && len(t.Fields) == 0 {
+		return locations
+	}
+
+	lowest := []string{}
+	lowestTier := 0
+	for _, location := range locations {
+		tier := t.tier(parentType, field, location)
+		if len(lowest) > 0 && tier > lowestTier {
+			continue
+		}
+		if len(lowest) == 0 || tier < lowestTier {
+			lowest = lowest[:0]
+			lowestTier = tier
+		}
+		lowest = append(lowest, location)
+	}
+
+	return lowest
+}
+
+// strongLocations returns the locations that can resolve the field for a request that asked for strong freshness
+func (t LocationTiers) strongLocations(parentType string, field string, locations []string) ([]string, error) {
+	allowed := []string{}
+	for _, location := range locations {
+		// the gateway's own fields are always up to date
+		if location == internalSchemaLocation || t.Services[location] <= t.StrongFreshnessMax {
+			allowed = append(allowed, location)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("%s.%s can't be resolved with strong freshness: it is only defined by %s", parentType, field, strings.Join(locations, ", "))
+	}
+
+	return allowed, nil
+}
+
+// freshnessCacheKey returns the key of the plans of the query for the freshness the client asked for. The client
+// is never told the key of the plans for strong freshness since it can't send it as the hash of its query.
+func freshnessCacheKey(key *string, query string, freshness string) *string {
+	if freshness != FreshnessStrong {
+		return key
+	}
+
+	hash := *key
+	if hash == "" {
+		// without a query there is nothing to look up, the cache asks the client for it
+		if query == "" {
+			return key
+		}
+		sum := sha256.Sum256([]byte(query))
+		hash = hex.EncodeToString(sum[:])
+	}
+
+	strongKey := hash + ":" + FreshnessStrong
+	return &strongKey
+}
+
+// requestFreshness returns the freshness the client asked for with the operation or the request's headers
+func requestFreshness(r *http.Request, operation *HTTPOperation) string {
+	if operation.Extensions.Freshness != "" {
+		return operation.Extensions.Freshness
+	}
+
+	return r.Header.Get(FreshnessHeader)
+}
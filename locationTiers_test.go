@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_locationTiers(t *testing.T) {
+	// sources returns fresh copies of the schemas since merging them changes them
+	sources := func() []*graphql.RemoteSchema {
+		// the read model has a copy of the products that the catalog owns
+		readModel, _ := graphql.LoadSchema(`
+			type Product {
+				title: String!
+			}
+
+			type Query {
+				products: [Product!]!
+				trending: [String!]!
+			}
+		`)
+		catalog, _ := graphql.LoadSchema(`
+			type Product {
+				title: String!
+			}
+
+			type Query {
+				products: [Product!]!
+				featuredTitle: String!
+			}
+		`)
+
+		return []*graphql.RemoteSchema{
+			{URL: "read-model", Schema: readModel},
+			{URL: "catalog", Schema: catalog},
+		}
+	}
+
+	// locations returns the url of each step under the root of the plan for the query
+	locations := func(t *testing.T, ctx *RequestContext, opts ...Option) []string {
+		gateway, err := New(sources(), opts...)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+
+		ctx.Context = context.Background()
+		plans, err := gateway.GetPlans(ctx)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+
+		result := []string{}
+		for _, step := range plans[0].RootStep.Then {
+			result = append(result, step.Queryer.(*graphql.SingleRequestQueryer).URL())
+		}
+		return result
+	}
+
+	query := `{ products { title } }`
+
+	t.Run("Lowest tier wins", func(t *testing.T) {
+		// without any tiers, the first service that defines the field wins
+		assert.Equal(t, []string{"read-model"}, locations(t, &RequestContext{Query: query}))
+
+		assert.Equal(t, []string{"catalog"}, locations(t, &RequestContext{Query: query}, WithFieldTier("read-model", 1)))
+	})
+
+	t.Run("Field override", func(t *testing.T) {
+		options := []Option{
+			WithFieldTier("read-model", 1),
+			WithFieldTierOverride("Query", "products", "read-model", 0),
+			WithFieldTierOverride("Product", "title", "read-model", 0),
+		}
+
+		// the services are in the same tier for the field so the first one wins
+		assert.Equal(t, []string{"read-model"}, locations(t, &RequestContext{Query: query}, options...))
+
+		// unless the client wants it from the owner
+		assert.Equal(t, []string{"catalog"}, locations(t, &RequestContext{Query: query, Freshness: FreshnessStrong}, options...))
+
+		// which the read model can be trusted to be
+		assert.Equal(t, []string{"read-model"}, locations(t,
+			&RequestContext{Query: query, Freshness: FreshnessStrong},
+			append(options, WithStrongFreshnessTier(1))...,
+		))
+	})
+
+	t.Run("Equal tiers prefer the services being visited", func(t *testing.T) {
+		// the products could come from either service but the catalog already has to be asked for the featured title
+		assert.Equal(t, []string{"catalog"}, locations(t, &RequestContext{Query: `{ featuredTitle products { title } }`}))
+	})
+
+	t.Run("Strong freshness is cached apart", func(t *testing.T) {
+		gateway, err := New(sources(),
+			WithFieldTier("read-model", 1),
+			WithFieldTierOverride("Query", "products", "read-model", 0),
+			WithFieldTierOverride("Product", "title", "read-model", 0),
+			WithAutomaticQueryPlanCache(),
+		)
+		if !assert.Nil(t, err) {
+			return
+		}
+		hash := sha256.Sum256([]byte(query))
+
+		// plan returns the service the products come from and whether the plans were cached
+		plan := func(ctx *RequestContext) (string, bool) {
+			ctx.Context = context.Background()
+			plans, err := gateway.GetPlans(ctx)
+			if !assert.Nil(t, err) {
+				return "", false
+			}
+			return plans[0].RootStep.Then[0].Queryer.(*graphql.SingleRequestQueryer).URL(), ctx.planCacheHit
+		}
+
+		location, cached := plan(&RequestContext{Query: query, Freshness: FreshnessStrong})
+		assert.Equal(t, "catalog", location)
+		assert.False(t, cached)
+
+		// the other requests don't get the plans for strong freshness
+		location, _ = plan(&RequestContext{Query: query})
+		assert.Equal(t, "read-model", location)
+
+		// and the ones that ask for it find them again, with or without the query
+		location, cached = plan(&RequestContext{Query: query, Freshness: FreshnessStrong})
+		assert.Equal(t, "catalog", location)
+		assert.True(t, cached)
+
+		location, cached = plan(&RequestContext{CacheKey: hex.EncodeToString(hash[:]), Freshness: FreshnessStrong})
+		assert.Equal(t, "catalog", location)
+		assert.True(t, cached)
+	})
+
+	t.Run("Strong freshness without an owner", func(t *testing.T) {
+		gateway, err := New(sources(), WithFieldTier("read-model", 1))
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		_, err = gateway.GetPlans(&RequestContext{
+			Context:   context.Background(),
+			Query:     `{ trending }`,
+			Freshness: FreshnessStrong,
+		})
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "Query.trending")
+		}
+	})
+}
+
+func TestRequestFreshness(t *testing.T) {
+	request := httptest.NewRequest("POST", "/graphql", strings.NewReader(""))
+	assert.Equal(t, "", requestFreshness(request, &HTTPOperation{}))
+
+	request.Header.Set(FreshnessHeader, FreshnessStrong)
+	assert.Equal(t, FreshnessStrong, requestFreshness(request, &HTTPOperation{}))
+
+	// the operation has the last word
+	operation := &HTTPOperation{}
+	operation.Extensions.Freshness = "eventual"
+	assert.Equal(t, "eventual", requestFreshness(request, operation))
+}
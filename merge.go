@@ -400,7 +400,7 @@ func mergeFieldsEqual(field1, field2 *ast.FieldDefinition) error {
 
 	// default values
 	if err := mergeValuesEqual(field1.DefaultValue, field2.DefaultValue); err != nil {
-		return fmt.Errorf("fields are not equal: %v", err.Error())
+		return fmt.Errorf("conflicting default values for field %s: %v", field1.Name, err.Error())
 	}
 
 	// directives
@@ -524,7 +524,7 @@ func mergeArgumentDefinitionsEqual(arg1 *ast.ArgumentDefinition, arg2 *ast.Argum
 
 	// check that the 2 default values are equal
 	if err := mergeValuesEqual(arg1.DefaultValue, arg2.DefaultValue); err != nil {
-		return err
+		return fmt.Errorf("conflicting default values for argument %s: %v", arg1.Name, err.Error())
 	}
 
 	return nil
@@ -547,7 +547,22 @@ func mergeValuesEqual(value1, value2 *ast.Value) error {
 	}
 	// if the raw values are not the same
 	if value1.Raw != value2.Raw {
-		return errors.New("encountered different raw values")
+		return fmt.Errorf("encountered different values: %s and %s", value1.String(), value2.String())
+	}
+
+	// lists and objects have to have the same values inside of them
+	if len(value1.Children) != len(value2.Children) {
+		return fmt.Errorf("encountered different values: %s and %s", value1.String(), value2.String())
+	}
+	for i, child1 := range value1.Children {
+		child2 := value2.Children[i]
+		if child1.Name != child2.Name {
+			return fmt.Errorf("encountered different values: %s and %s", value1.String(), value2.String())
+		}
+
+		if err := mergeValuesEqual(child1.Value, child2.Value); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -958,3 +958,81 @@ func TestMergeSchemaDifferentSetsOfInterfaces(t *testing.T) {
 	})
 	assert.Nil(t, err)
 }
+
+func TestMergeSchema_defaultValues(t *testing.T) {
+	t.Run("Matching defaults", func(t *testing.T) {
+		originalSchema, err := graphql.LoadSchema(`
+			input Filter {
+				tags: [String!] = ["a", "b"]
+			}
+
+			type User {
+				posts(first: Int = 10, filter: Filter = {tags: ["c"]}): [String!]!
+			}
+		`)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		schema, err := testMergeSchemas(t, originalSchema, `
+			input Filter {
+				tags: [String!] = ["a", "b"]
+			}
+
+			type User {
+				posts(first: Int = 10, filter: Filter = {tags: ["c"]}): [String!]!
+			}
+		`)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// the defaults should survive the merge
+		posts := schema.Types["User"].Fields.ForName("posts")
+		assert.Equal(t, "10", posts.Arguments.ForName("first").DefaultValue.String())
+		assert.Equal(t, `{tags:["c"]}`, posts.Arguments.ForName("filter").DefaultValue.String())
+		assert.Equal(t, `["a","b"]`, schema.Types["Filter"].Fields.ForName("tags").DefaultValue.String())
+	})
+
+	testMergeRunNegativeTable(t, []testMergeTableRow{
+		{
+			"Conflicting argument defaults",
+			`
+				type User {
+					posts(first: Int = 10): [String!]!
+				}
+			`,
+			`
+				type User {
+					posts(first: Int = 25): [String!]!
+				}
+			`,
+		},
+		{
+			"Missing argument default",
+			`
+				type User {
+					posts(first: Int = 10): [String!]!
+				}
+			`,
+			`
+				type User {
+					posts(first: Int): [String!]!
+				}
+			`,
+		},
+		{
+			"Conflicting list defaults",
+			`
+				input Filter {
+					tags: [String!] = ["a", "b"]
+				}
+			`,
+			`
+				input Filter {
+					tags: [String!] = ["a", "c"]
+				}
+			`,
+		},
+	})
+}
@@ -2,7 +2,6 @@ package gateway
 
 import (
 	"errors"
-	"sync"
 
 	"github.com/nautilus/graphql"
 )
@@ -39,13 +38,13 @@ func (p ResponseMiddleware) ExecutionMiddleware() {}
 // scrubInsertionIDs removes the fields from the final response that the user did not
 // explicitly ask for
 func scrubInsertionIDs(ctx *ExecutionContext, response map[string]interface{}) error {
-	lock := sync.Mutex{}
+	stitcher := NewStitcher(ctx.Plan.IDFields)
 
 	// there are many fields to scrub
 	for field, locations := range ctx.Plan.FieldsToScrub {
 		for _, location := range locations {
 			// look for the insertion points in the response for the field
-			insertionPoints, err := executorFindInsertionPoints(&lock, location, ctx.Plan.Operation.SelectionSet, response, [][]string{[]string{}}, ctx.Plan.FragmentDefinitions)
+			insertionPoints, err := stitcher.FindInsertionPoints(location, ctx.Plan.Operation.SelectionSet, ctx.Plan.FragmentDefinitions, response, InsertionPoint{})
 			if err != nil {
 				return err
 			}
@@ -53,7 +52,7 @@ func scrubInsertionIDs(ctx *ExecutionContext, response map[string]interface{}) e
 			// each insertion point needs to be cleaned up
 			for _, point := range insertionPoints {
 				// extract the obj at that point
-				value, err := executorExtractValue(response, &lock, point)
+				value, err := stitcher.Extract(response, point)
 				if err != nil {
 					return err
 				}
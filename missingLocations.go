@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// A field that's in the schema but that no service is known to resolve shouldn't happen, but it can: a service
+// that was left out when the gateway started, a visibility filter that kept a type but not its fields, or a bug
+// in a merger. Before the planner gets going, it looks for every field of the operation that doesn't have a
+// location and reports all of them at once, with the path to the field, where it is in the query, and the
+// services that were looked at, so whoever sees the error can tell which part of the query caused it.
+
+// missingLocationCode is the code of the errors for the fields that no service can resolve
+const missingLocationCode = "MISSING_LOCATION"
+
+// plannerMissingLocations returns an error for every field of the document that no service can resolve
+func plannerMissingLocations(document *ast.QueryDocument, locations FieldURLMap, unavailable []string) graphql.ErrorList {
+	errs := graphql.ErrorList{}
+	// a fragment can be spread in a few places but each path is only reported once
+	reported := Set{}
+
+	var visit func(selectionSet ast.SelectionSet, path ast.Path, spread Set)
+	visit = func(selectionSet ast.SelectionSet, path ast.Path, spread Set) {
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				// the gateway answers the introspection fields itself
+				if strings.HasPrefix(selection.Name, "__") || selection.ObjectDefinition == nil {
+					continue
+				}
+
+				fieldPath := append(append(ast.Path{}, path...), ast.PathName(selection.Alias))
+				parentType := selection.ObjectDefinition.Name
+				if _, err := locations.URLFor(parentType, selection.Name); err != nil && !reported.Has(fieldPath.String()) {
+					reported.Add(fieldPath.String())
+					errs = append(errs, missingLocationError(selection, parentType, fieldPath, locations, unavailable))
+				}
+
+				// the fields under this one could be missing too
+				visit(selection.SelectionSet, fieldPath, spread)
+			case *ast.InlineFragment:
+				visit(selection.SelectionSet, path, spread)
+			case *ast.FragmentSpread:
+				// the document is valid so the fragments can't spread each other forever, but the same
+				// fragment can show up twice under one field
+				if selection.Definition == nil || spread.Has(selection.Name) {
+					continue
+				}
+
+				nested := Set{selection.Name: true}
+				for name := range spread {
+					nested.Add(name)
+				}
+				visit(selection.Definition.SelectionSet, path, nested)
+			}
+		}
+	}
+
+	for _, operation := range document.Operations {
+		visit(operation.SelectionSet, ast.Path{}, Set{})
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// missingLocationError returns the error for a field that no service can resolve
+func missingLocationError(field *ast.Field, parentType string, path ast.Path, locations FieldURLMap, unavailable []string) *gqlerror.Error {
+	considered := missingLocationServices(locations, parentType)
+
+	message := fmt.Sprintf("Could not find location for %s.%s at %s", parentType, field.Name, path.String())
+	if len(considered) > 0 {
+		message += fmt.Sprintf(" (the services with %s are %s)", parentType, strings.Join(considered, ", "))
+	} else {
+		message += fmt.Sprintf(" (no service resolves %s)", parentType)
+	}
+
+	err := &gqlerror.Error{
+		Message: message,
+		Path:    path,
+		Extensions: map[string]interface{}{
+			"code":               missingLocationCode,
+			"parentType":         parentType,
+			"field":              field.Name,
+			"consideredServices": considered,
+		},
+	}
+	if field.Position != nil {
+		err.Locations = []gqlerror.Location{{Line: field.Position.Line, Column: field.Position.Column}}
+	}
+
+	// the services that were left out when the gateway started could be the ones with the field
+	if len(unavailable) > 0 {
+		err.Extensions["hint"] = fmt.Sprintf("the field could belong to a service that was left out when the gateway started: %s", strings.Join(unavailable, ", "))
+	}
+
+	return err
+}
+
+// missingLocationServices returns the services that resolve at least one field of the type, in order
+func missingLocationServices(locations FieldURLMap, parentType string) []string {
+	services := Set{}
+	for key, urls := range locations {
+		if !strings.HasPrefix(key, parentType+".") {
+			continue
+		}
+		for _, url := range urls {
+			if url != internalSchemaLocation {
+				services.Add(url)
+			}
+		}
+	}
+
+	result := []string{}
+	for service := range services {
+		result = append(result, service)
+	}
+	sort.Strings(result)
+
+	return result
+}
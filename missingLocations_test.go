@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+func TestPlanQuery_missingLocations(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			name: String!
+			secret: String!
+			posts: [Post!]!
+		}
+
+		type Post {
+			title: String!
+			body: String!
+		}
+
+		type Query {
+			users: [User!]!
+		}
+	`)
+
+	// User.secret and Post.body somehow didn't make it into the map
+	locations := FieldURLMap{}
+	locations.RegisterURL("Query", "users", "users")
+	locations.RegisterURL("User", "name", "users")
+	locations.RegisterURL("User", "posts", "users")
+	locations.RegisterURL("Post", "title", "posts")
+
+	_, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+		Query: `
+			query {
+				users {
+					name
+					...UserSecret
+					writing: posts {
+						title
+						body
+					}
+				}
+			}
+
+			fragment UserSecret on User {
+				secret
+			}
+		`,
+		Schema:              schema,
+		Locations:           locations,
+		UnavailableServices: []string{"comments"},
+	})
+	errs, ok := err.(graphql.ErrorList)
+	if !assert.True(t, ok, err) || !assert.Len(t, errs, 2) {
+		return
+	}
+
+	// the field in the fragment is reported where the fragment was spread
+	secret := errs[0].(*gqlerror.Error)
+	assert.Equal(t, "users.secret", secret.Path.String())
+	assert.Equal(t, []gqlerror.Location{{Line: 14, Column: 5}}, secret.Locations)
+	assert.Equal(t, "User", secret.Extensions["parentType"])
+	assert.Equal(t, []string{"users"}, secret.Extensions["consideredServices"])
+	assert.Contains(t, secret.Message, "User.secret")
+	assert.Contains(t, secret.Extensions["hint"], "comments")
+
+	// the nested field is reported with the alias of its parent
+	body := errs[1].(*gqlerror.Error)
+	assert.Equal(t, "users.writing.body", body.Path.String())
+	assert.Equal(t, []gqlerror.Location{{Line: 8, Column: 7}}, body.Locations)
+	assert.Equal(t, "Post", body.Extensions["parentType"])
+	assert.Equal(t, []string{"posts"}, body.Extensions["consideredServices"])
+
+	// they are proper entries of the errors in the response
+	payload, _ := json.Marshal(formatErrors(nil, err))
+	assert.JSONEq(t, `{
+		"data": null,
+		"errors": [
+			{
+				"message": "Could not find location for User.secret at users.secret (the services with User are users)",
+				"path": ["users", "secret"],
+				"locations": [{"line": 14, "column": 5}],
+				"extensions": {
+					"code": "MISSING_LOCATION",
+					"parentType": "User",
+					"field": "secret",
+					"consideredServices": ["users"],
+					"hint": "the field could belong to a service that was left out when the gateway started: comments"
+				}
+			},
+			{
+				"message": "Could not find location for Post.body at users.writing.body (the services with Post are posts)",
+				"path": ["users", "writing", "body"],
+				"locations": [{"line": 8, "column": 7}],
+				"extensions": {
+					"code": "MISSING_LOCATION",
+					"parentType": "Post",
+					"field": "body",
+					"consideredServices": ["posts"],
+					"hint": "the field could belong to a service that was left out when the gateway started: comments"
+				}
+			}
+		]
+	}`, string(payload))
+}
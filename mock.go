@@ -0,0 +1,356 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// A mocked service never sees a request. Instead, the gateway makes up a response by walking the query it
+// would have sent against the schema of the service. This lets a client be built against fields that
+// haven't been deployed yet while the rest of the query still goes to the real services.
+
+// MockFieldFunc returns the value of a mocked field given its arguments. Objects are returned as maps
+// whose keys are the names of the fields.
+type MockFieldFunc func(args map[string]interface{}) interface{}
+
+// MockOptions configures the values that are made up for a mocked service
+type MockOptions struct {
+	// the value of every field of a scalar type, indexed by the name of the type
+	Scalars map[string]interface{}
+	// the number of items in every list. Defaults to 2
+	ListLength int
+	// the functions that provide the value of specific fields, indexed by Type.field
+	Fields map[string]MockFieldFunc
+}
+
+// the values of the built-in scalars unless they are configured. IDs are made up for every object.
+var mockScalars = map[string]interface{}{
+	"Int":     42,
+	"Float":   4.2,
+	"String":  "Hello World",
+	"Boolean": true,
+}
+
+// the number of items in a list unless it is configured
+const mockListLength = 2
+
+// WithMockedService returns an Option that answers the queries sent to the service at url with made up
+// values instead of sending them over the network. The service has to be given to the gateway with its schema.
+func WithMockedService(url string, opts MockOptions) Option {
+	return func(g *Gateway) {
+		if g.mockedServices == nil {
+			g.mockedServices = map[string]*MockQueryer{}
+		}
+		g.mockedServices[url] = &MockQueryer{Options: opts}
+	}
+}
+
+// MockQueryer is a graphql.Queryer that responds to every query with values that fit the schema
+type MockQueryer struct {
+	Schema  *ast.Schema
+	Options MockOptions
+}
+
+// Query makes up a response for the query and writes it to the receiver
+func (q *MockQueryer) Query(ctx context.Context, input *graphql.QueryInput, receiver interface{}) error {
+	document := input.QueryDocument
+	if document == nil {
+		parsed, err := parser.ParseQuery(&ast.Source{Input: input.Query})
+		if err != nil {
+			return err
+		}
+		document = parsed
+	}
+	if len(document.Operations) == 0 {
+		return errors.New("Could not find an operation to mock")
+	}
+	operation := document.Operations[0]
+
+	// find the type at the root of the operation
+	var root *ast.Definition
+	switch operation.Operation {
+	case ast.Mutation:
+		root = q.Schema.Mutation
+	case ast.Subscription:
+		root = q.Schema.Subscription
+	default:
+		root = q.Schema.Query
+	}
+	if root == nil {
+		return fmt.Errorf("The mocked schema does not support %s operations", operation.Operation)
+	}
+
+	resolver := &mockResolver{
+		schema:    q.Schema,
+		options:   q.Options,
+		variables: input.Variables,
+		fragments: document.Fragments,
+	}
+	result, err := resolver.object(root, operation.SelectionSet, nil)
+	if err != nil {
+		return err
+	}
+
+	return internalDecode(result, receiver)
+}
+
+// mockedQueryer returns the queryer that stands in for the service at url, if it is mocked
+func (g *Gateway) mockedQueryer(url string) (graphql.Queryer, bool) {
+	queryer, ok := g.mockedServices[url]
+	return queryer, ok
+}
+
+// assignMockSchemas gives each mocked service the schema it was configured with
+func (g *Gateway) assignMockSchemas(sources []*graphql.RemoteSchema) error {
+	for url, queryer := range g.mockedServices {
+		for _, source := range sources {
+			if source.URL == url {
+				queryer.Schema = source.Schema
+			}
+		}
+
+		if queryer.Schema == nil {
+			return fmt.Errorf("Could not find the schema of the mocked service %s", url)
+		}
+	}
+
+	return nil
+}
+
+// mockResolver makes up the response to a single query
+type mockResolver struct {
+	schema    *ast.Schema
+	options   MockOptions
+	variables map[string]interface{}
+	fragments ast.FragmentDefinitionList
+	// the number of ids that have been made up so every object gets its own
+	ids int
+}
+
+// object makes up an object of the type with the selected fields. If the object was looked up by id, it
+// is given that id.
+func (m *mockResolver) object(definition *ast.Definition, selectionSet ast.SelectionSet, id interface{}) (map[string]interface{}, error) {
+	object := map[string]interface{}{}
+
+	aliases, fields := m.collectFields(definition, selectionSet)
+	for _, alias := range aliases {
+		field := fields[alias]
+
+		if field.Name == "__typename" {
+			object[alias] = definition.Name
+			continue
+		}
+
+		// an object that was looked up by id has to have it
+		if field.Name == "id" && id != nil {
+			object[alias] = id
+			continue
+		}
+
+		args := map[string]interface{}{}
+		for _, arg := range field.Arguments {
+			value, err := arg.Value.Value(m.variables)
+			if err != nil {
+				return nil, err
+			}
+			args[arg.Name] = value
+		}
+
+		// the value could be provided by the user
+		if resolve, ok := m.options.Fields[definition.Name+"."+field.Name]; ok {
+			value, err := internalSelectValue(resolve(args), field.SelectionSet, m.fragments)
+			if err != nil {
+				return nil, err
+			}
+			object[alias] = value
+			continue
+		}
+
+		var fieldType *ast.Type
+		if fieldDefinition := definition.Fields.ForName(field.Name); fieldDefinition != nil {
+			fieldType = fieldDefinition.Type
+		} else if field.Name == "node" && definition == m.schema.Query {
+			// the services don't have to declare the node field that the gateway uses to look up objects
+			fieldType = ast.NamedType("Node", nil)
+		} else {
+			return nil, fmt.Errorf("Could not find field %s on %s in the mocked schema", field.Name, definition.Name)
+		}
+
+		value, err := m.value(fieldType, field.SelectionSet, args["id"])
+		if err != nil {
+			return nil, err
+		}
+		object[alias] = value
+	}
+
+	return object, nil
+}
+
+// value makes up a value of the type
+func (m *mockResolver) value(valueType *ast.Type, selectionSet ast.SelectionSet, id interface{}) (interface{}, error) {
+	// lists get a few items
+	if valueType.Elem != nil {
+		length := m.options.ListLength
+		if length == 0 {
+			length = mockListLength
+		}
+
+		list := []interface{}{}
+		for i := 0; i < length; i++ {
+			item, err := m.value(valueType.Elem, selectionSet, nil)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	}
+
+	definition := m.schema.Types[valueType.Name()]
+	if definition == nil {
+		// the only type that can be missing is the Node interface so we have to rely on the query
+		concrete, err := m.concreteType(nil, selectionSet)
+		if err != nil {
+			return nil, err
+		}
+		return m.object(concrete, selectionSet, id)
+	}
+
+	switch definition.Kind {
+	case ast.Object:
+		return m.object(definition, selectionSet, id)
+
+	case ast.Interface, ast.Union:
+		concrete, err := m.concreteType(m.schema.GetPossibleTypes(definition), selectionSet)
+		if err != nil {
+			return nil, err
+		}
+		return m.object(concrete, selectionSet, id)
+
+	case ast.Enum:
+		if len(definition.EnumValues) == 0 {
+			return nil, nil
+		}
+		return definition.EnumValues[0].Name, nil
+	}
+
+	// the value of a scalar could be configured
+	if value, ok := m.options.Scalars[definition.Name]; ok {
+		return value, nil
+	}
+	if definition.Name == "ID" {
+		m.ids++
+		return strconv.Itoa(m.ids), nil
+	}
+	if value, ok := mockScalars[definition.Name]; ok {
+		return value, nil
+	}
+
+	// custom scalars are strings unless we're told otherwise
+	return mockScalars["String"], nil
+}
+
+// concreteType picks the type of an object whose field has an abstract type. The query tells us which
+// types it's interested in so we prefer those.
+func (m *mockResolver) concreteType(possibleTypes []*ast.Definition, selectionSet ast.SelectionSet) (*ast.Definition, error) {
+	possible := Set{}
+	for _, possibleType := range possibleTypes {
+		possible.Add(possibleType.Name)
+	}
+
+	for _, selection := range selectionSet {
+		typeCondition := ""
+		switch selection := selection.(type) {
+		case *ast.InlineFragment:
+			typeCondition = selection.TypeCondition
+		case *ast.FragmentSpread:
+			if fragment := m.fragments.ForName(selection.Name); fragment != nil {
+				typeCondition = fragment.TypeCondition
+			}
+		}
+
+		definition := m.schema.Types[typeCondition]
+		if definition != nil && definition.Kind == ast.Object && (len(possible) == 0 || possible.Has(definition.Name)) {
+			return definition, nil
+		}
+	}
+
+	if len(possibleTypes) == 0 {
+		return nil, errors.New("Could not find a type to mock")
+	}
+
+	return possibleTypes[0], nil
+}
+
+// collectFields returns the fields of the selection set that apply to the type, with the selections of
+// the fields that share an alias combined
+func (m *mockResolver) collectFields(definition *ast.Definition, selectionSet ast.SelectionSet) ([]string, map[string]*ast.Field) {
+	aliases := []string{}
+	fields := map[string]*ast.Field{}
+
+	var collect func(selectionSet ast.SelectionSet)
+	collect = func(selectionSet ast.SelectionSet) {
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				alias := selection.Alias
+				if alias == "" {
+					alias = selection.Name
+				}
+
+				if existing, ok := fields[alias]; ok {
+					merged := *existing
+					merged.SelectionSet = append(append(ast.SelectionSet{}, existing.SelectionSet...), selection.SelectionSet...)
+					fields[alias] = &merged
+					continue
+				}
+
+				aliases = append(aliases, alias)
+				fields[alias] = selection
+
+			case *ast.InlineFragment:
+				if m.appliesTo(selection.TypeCondition, definition) {
+					collect(selection.SelectionSet)
+				}
+
+			case *ast.FragmentSpread:
+				fragment := m.fragments.ForName(selection.Name)
+				if fragment != nil && m.appliesTo(fragment.TypeCondition, definition) {
+					collect(fragment.SelectionSet)
+				}
+			}
+		}
+	}
+	collect(selectionSet)
+
+	return aliases, fields
+}
+
+// appliesTo returns true if a fragment with the type condition applies to objects of the type
+func (m *mockResolver) appliesTo(typeCondition string, definition *ast.Definition) bool {
+	if typeCondition == "" || typeCondition == definition.Name {
+		return true
+	}
+
+	for _, iface := range definition.Interfaces {
+		if iface == typeCondition {
+			return true
+		}
+	}
+
+	if condition := m.schema.Types[typeCondition]; condition != nil && condition.Kind == ast.Union {
+		for _, member := range condition.Types {
+			if member == definition.Name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
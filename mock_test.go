@@ -0,0 +1,172 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_mockedService(t *testing.T) {
+	usersSchema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			me: User!
+		}
+	`)
+	photosSchema, _ := graphql.LoadSchema(`
+		enum PhotoKind {
+			THUMBNAIL
+			FULL
+		}
+
+		type Photo {
+			url(size: Int): String!
+			width: Int!
+			kind: PhotoKind!
+		}
+
+		type User {
+			photos: [Photo!]!
+		}
+
+		type Query {
+			featuredPhoto: Photo!
+		}
+	`)
+
+	// only the real service goes through the factory
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		assert.NotEqual(t, "photos", url)
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{
+				"me": map[string]interface{}{
+					"name":         "Alec",
+					gatewayIDAlias: "user-1",
+				},
+			}, nil
+		})
+	})
+
+	gateway, err := New(
+		[]*graphql.RemoteSchema{
+			{URL: "users", Schema: usersSchema},
+			{URL: "photos", Schema: photosSchema},
+		},
+		WithQueryerFactory(&factory),
+		WithMockedService("photos", MockOptions{
+			ListLength: 1,
+			Scalars:    map[string]interface{}{"Int": 100},
+			Fields: map[string]MockFieldFunc{
+				"Photo.url": func(args map[string]interface{}) interface{} {
+					return fmt.Sprintf("https://example.com/photo.jpg?size=%v", args["size"])
+				},
+			},
+		}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx := &RequestContext{
+		Context: context.Background(),
+		Query: `{
+			me {
+				name
+				photos {
+					url(size: 10)
+					width
+					kind
+				}
+			}
+			featuredPhoto {
+				width
+			}
+		}`,
+	}
+	plans, err := gateway.GetPlans(ctx)
+	if !assert.Nil(t, err) {
+		return
+	}
+	result, err := gateway.Execute(ctx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the real service supplies its fields and the mocked one fills in the rest
+	assert.Equal(t, map[string]interface{}{
+		"me": map[string]interface{}{
+			"name": "Alec",
+			"photos": []interface{}{
+				map[string]interface{}{
+					"url":   "https://example.com/photo.jpg?size=10",
+					"width": 100,
+					"kind":  "THUMBNAIL",
+				},
+			},
+		},
+		"featuredPhoto": map[string]interface{}{
+			"width": 100,
+		},
+	}, result)
+}
+
+func TestGateway_mockedServiceNode(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			users: [User!]!
+		}
+	`)
+	queryer := &MockQueryer{Schema: schema}
+
+	result := map[string]interface{}{}
+	err := queryer.Query(context.Background(), &graphql.QueryInput{
+		Query: `query ($id: ID!) {
+			node(id: $id) {
+				... on User {
+					__typename
+					id
+					name
+				}
+			}
+			users {
+				id
+			}
+		}`,
+		Variables: map[string]interface{}{"id": "user-7"},
+	}, &result)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// objects looked up by id have that id and the others get their own
+	assert.Equal(t, map[string]interface{}{
+		"node": map[string]interface{}{
+			"__typename": "User",
+			"id":         "user-7",
+			"name":       "Hello World",
+		},
+		"users": []interface{}{
+			map[string]interface{}{"id": "1"},
+			map[string]interface{}{"id": "2"},
+		},
+	}, result)
+
+	// a mocked service has to have a schema
+	_, err = New(
+		[]*graphql.RemoteSchema{{URL: "users", Schema: schema}},
+		WithMockedService("photos", MockOptions{}),
+	)
+	assert.NotNil(t, err)
+}
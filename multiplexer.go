@@ -0,0 +1,281 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A single process can serve more than one set of services by putting a gateway in front of each of them and
+// registering them with a Multiplexer under a name. Requests sent to /graphql/{name} are handled by the gateway
+// of the group with that name. Each gateway keeps its own schema, caches, and options so the groups can define
+// types with the same name and reload their schemas on their own schedule. What the groups share is the
+// multiplexer's handler options (ie, middlewares that record metrics or check credentials) and the process-wide
+// logger set by WithLogger. The name of the group that received a request is available to the middlewares
+// with MultiplexerGroup.
+//
+// Groups can be added and removed while the multiplexer is serving requests. A group that is removed stops
+// getting new requests right away and its gateway is shut down once the requests it is working on are done.
+
+// ErrMultiplexerGroupNotFound is returned when a multiplexer is asked for a group it doesn't have
+var ErrMultiplexerGroupNotFound = errors.New("could not find group")
+
+// ErrMultiplexerShutDown is returned when a group is registered with a multiplexer that has been shut down
+var ErrMultiplexerShutDown = errors.New("the multiplexer has been shut down")
+
+// MultiplexerOption configures a Multiplexer
+type MultiplexerOption func(*Multiplexer)
+
+// MultiplexerPrefix returns a MultiplexerOption that sets the path the names of the groups come after.
+// The default is /graphql/.
+func MultiplexerPrefix(prefix string) MultiplexerOption {
+	return func(m *Multiplexer) {
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		m.prefix = prefix
+	}
+}
+
+// MultiplexerHandlerOptions returns a MultiplexerOption that builds the handler of every group with the
+// designated options
+func MultiplexerHandlerOptions(opts ...HandlerOption) MultiplexerOption {
+	return func(m *Multiplexer) {
+		m.handlerOptions = append(m.handlerOptions, opts...)
+	}
+}
+
+// Multiplexer is a http.Handler that sends each request to the gateway of the group it was addressed to
+type Multiplexer struct {
+	prefix         string
+	handlerOptions []HandlerOption
+
+	mutex    sync.RWMutex
+	groups   map[string]*multiplexerGroup
+	shutDown bool
+}
+
+// multiplexerGroup is a gateway registered with a multiplexer along with the handler that serves it
+type multiplexerGroup struct {
+	gateway *Gateway
+	handler http.Handler
+}
+
+// NewMultiplexer returns a Multiplexer without any groups
+func NewMultiplexer(opts ...MultiplexerOption) *Multiplexer {
+	m := &Multiplexer{
+		prefix: "/graphql/",
+		groups: map[string]*multiplexerGroup{},
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Register adds the gateway to the multiplexer under the designated name. Names can't be empty or contain a
+// slash. A gateway can only belong to one group and can't share its query plan cache with another one since
+// the plans of one group don't make sense for the others.
+func (m *Multiplexer) Register(name string, gw *Gateway) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("%q is not a valid group name", name)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.shutDown {
+		return ErrMultiplexerShutDown
+	}
+	if _, ok := m.groups[name]; ok {
+		return fmt.Errorf("there is already a group named %s", name)
+	}
+
+	for other, group := range m.groups {
+		if group.gateway == gw {
+			return fmt.Errorf("the gateway is already registered as %s", other)
+		}
+		if multiplexerSharedPlanCache(group.gateway.queryPlanCache, gw.queryPlanCache) {
+			return fmt.Errorf("the gateway shares its query plan cache with %s", other)
+		}
+	}
+
+	m.groups[name] = &multiplexerGroup{
+		gateway: gw,
+		handler: gw.Handler(m.handlerOptions...),
+	}
+
+	return nil
+}
+
+// Remove takes the group out of the multiplexer and shuts down its gateway. New requests for the group get
+// a 404 right away while the ones in flight are given until the context expires to finish.
+func (m *Multiplexer) Remove(ctx context.Context, name string) error {
+	m.mutex.Lock()
+	group, ok := m.groups[name]
+	delete(m.groups, name)
+	m.mutex.Unlock()
+
+	if !ok {
+		return ErrMultiplexerGroupNotFound
+	}
+
+	return group.gateway.Shutdown(ctx)
+}
+
+// Gateway returns the gateway of the group with the designated name
+func (m *Multiplexer) Gateway(name string) (*Gateway, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	group, ok := m.groups[name]
+	if !ok {
+		return nil, false
+	}
+
+	return group.gateway, true
+}
+
+// Groups returns the names of the groups in the multiplexer in alphabetical order
+func (m *Multiplexer) Groups() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	names := []string{}
+	for name := range m.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ServeHTTP sends the request to the handler of the group named in its path
+func (m *Multiplexer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, m.prefix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// the name of the group is the part of the path after the prefix
+	name := strings.TrimPrefix(r.URL.Path, m.prefix)
+	if separator := strings.Index(name, "/"); separator >= 0 {
+		name = name[:separator]
+	}
+
+	m.mutex.RLock()
+	group, ok := m.groups[name]
+	m.mutex.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	group.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), multiplexerGroupKey{}, name)))
+}
+
+// HealthHandler is a http.HandlerFunc that reports the health of every group. The status is degraded if
+// any of the groups are.
+func (m *Multiplexer) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	m.mutex.RLock()
+	groups := map[string]*Gateway{}
+	for name, group := range m.groups {
+		groups[name] = group.gateway
+	}
+	m.mutex.RUnlock()
+
+	status := "ok"
+	reports := map[string]interface{}{}
+	for name, gw := range groups {
+		report := gw.health()
+		if report["status"] != "ok" {
+			status = "degraded"
+		}
+		reports[name] = report
+	}
+
+	response, err := json.Marshal(map[string]interface{}{
+		"status": status,
+		"groups": reports,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// Shutdown removes every group and shuts down their gateways at the same time. Groups can't be registered
+// afterwards. The first error returned by a gateway is returned.
+func (m *Multiplexer) Shutdown(ctx context.Context) error {
+	m.mutex.Lock()
+	groups := m.groups
+	m.groups = map[string]*multiplexerGroup{}
+	m.shutDown = true
+	m.mutex.Unlock()
+
+	errs := make(chan error, len(groups))
+	wg := &sync.WaitGroup{}
+	for _, group := range groups {
+		wg.Add(1)
+		go func(gw *Gateway) {
+			defer wg.Done()
+			errs <- gw.Shutdown(ctx)
+		}(group.gateway)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// multiplexerGroupKey is the context key for the name of the group that received the request
+type multiplexerGroupKey struct{}
+
+// MultiplexerGroup returns the name of the multiplexer group that received the request, or an empty string
+// if it didn't go through a multiplexer
+func MultiplexerGroup(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	name, _ := ctx.Value(multiplexerGroupKey{}).(string)
+	return name
+}
+
+// multiplexerSharedPlanCache returns true if the two caches hold the same plans
+func multiplexerSharedPlanCache(a QueryPlanCache, b QueryPlanCache) bool {
+	// the automatic cache can be copied with a new ttl and keep the same plans
+	if a, ok := a.(*AutomaticQueryPlanCache); ok {
+		if b, ok := b.(*AutomaticQueryPlanCache); ok {
+			return reflect.ValueOf(a.cache).Pointer() == reflect.ValueOf(b.cache).Pointer()
+		}
+	}
+
+	// the other caches can only be told apart if they are pointers to something that holds the plans
+	// (pointers to empty structs, like the NoQueryPlanCache, can all have the same address)
+	for _, cache := range []QueryPlanCache{a, b} {
+		if cache == nil || reflect.TypeOf(cache).Kind() != reflect.Ptr || reflect.TypeOf(cache).Elem().Size() == 0 {
+			return false
+		}
+	}
+
+	return a == b
+}
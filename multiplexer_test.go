@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiplexer(t *testing.T) {
+	// both groups have a User type and a viewer field, but they aren't the same
+	shopSchema, _ := graphql.LoadSchema(`
+		type User {
+			name: String!
+			cart: [String!]!
+		}
+
+		type Query {
+			viewer: User!
+		}
+	`)
+	blogSchema, _ := graphql.LoadSchema(`
+		type User {
+			name: String!
+			posts: [String!]!
+		}
+
+		type Query {
+			viewer: User!
+		}
+	`)
+
+	// service returns a service that always responds with the designated name for the viewer
+	service := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"data": {"viewer": {"name": "` + name + `"}}}`))
+		}))
+	}
+	shopService := service("shopper")
+	defer shopService.Close()
+	blogService := service("writer")
+	defer blogService.Close()
+
+	// the groups share a response cache store but not their plans
+	store := NewInMemoryCacheStore()
+	planCache := NewAutomaticQueryPlanCache()
+	shop, err := New([]*graphql.RemoteSchema{{Schema: shopSchema, URL: shopService.URL}},
+		WithQueryPlanCache(planCache),
+		WithResponseCache(store, CachePolicy{MaxAge: time.Hour}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+	blog, err := New([]*graphql.RemoteSchema{{Schema: blogSchema, URL: blogService.URL}},
+		WithAutomaticQueryPlanCache(),
+		WithResponseCache(store, CachePolicy{MaxAge: time.Hour}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the middlewares of the multiplexer see the requests of every group
+	seen := []string{}
+	seenMutex := &sync.Mutex{}
+	multiplexer := NewMultiplexer(MultiplexerHandlerOptions(HandlerMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seenMutex.Lock()
+			seen = append(seen, MultiplexerGroup(r.Context()))
+			seenMutex.Unlock()
+			next.ServeHTTP(w, r)
+		})
+	})))
+	assert.Nil(t, multiplexer.Register("shop", shop))
+	assert.Nil(t, multiplexer.Register("blog", blog))
+	assert.Equal(t, []string{"blog", "shop"}, multiplexer.Groups())
+
+	// send sends the query to the path and returns the status and the body of the response
+	send := func(path string, query string) (int, map[string]interface{}) {
+		body, _ := json.Marshal(map[string]interface{}{"query": query})
+		recorder := httptest.NewRecorder()
+		multiplexer.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+
+		response := map[string]interface{}{}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response
+	}
+
+	// the same query gets the answer of the group it was sent to, even once the responses are cached
+	for i := 0; i < 2; i++ {
+		_, response := send("/graphql/shop", `{ viewer { name } }`)
+		assert.Equal(t, map[string]interface{}{"viewer": map[string]interface{}{"name": "shopper"}}, response["data"])
+
+		_, response = send("/graphql/blog", `{ viewer { name } }`)
+		assert.Equal(t, map[string]interface{}{"viewer": map[string]interface{}{"name": "writer"}}, response["data"])
+	}
+	assert.Equal(t, []string{"shop", "blog", "shop", "blog"}, seen)
+
+	// a group only knows about its own fields
+	_, response := send("/graphql/shop", `{ viewer { posts } }`)
+	assert.NotNil(t, response["errors"])
+	assert.Nil(t, response["data"])
+
+	// and reloading one group leaves the other one alone
+	reloadedSchema, _ := graphql.LoadSchema(`
+		type User {
+			name: String!
+			posts: [String!]!
+		}
+
+		type Query {
+			viewer: User!
+			title: String!
+		}
+	`)
+	_, err = blog.Reload([]*graphql.RemoteSchema{{Schema: reloadedSchema, URL: blogService.URL}})
+	if assert.Nil(t, err) {
+		_, err = blog.GetPlans(&RequestContext{Context: context.Background(), Query: `{ title }`})
+		assert.Nil(t, err)
+		_, err = shop.GetPlans(&RequestContext{Context: context.Background(), Query: `{ title }`})
+		assert.NotNil(t, err)
+	}
+
+	// the health of every group is reported together
+	recorder := httptest.NewRecorder()
+	multiplexer.HealthHandler(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	health := map[string]interface{}{}
+	if assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &health)) {
+		assert.Equal(t, "ok", health["status"])
+		assert.Len(t, health["groups"], 2)
+	}
+
+	// the groups can't be mixed up
+	other, _ := New([]*graphql.RemoteSchema{{Schema: shopSchema, URL: shopService.URL}}, WithQueryPlanCache(planCache))
+	assert.NotNil(t, multiplexer.Register("other", other))
+	assert.NotNil(t, multiplexer.Register("shop", blog))
+	assert.NotNil(t, multiplexer.Register("again", shop))
+	assert.NotNil(t, multiplexer.Register("", blog))
+	assert.NotNil(t, multiplexer.Register("a/b", blog))
+
+	// groups can be removed while the rest are serving requests
+	assert.Nil(t, multiplexer.Remove(context.Background(), "shop"))
+	code, _ := send("/graphql/shop", `{ viewer { name } }`)
+	assert.Equal(t, http.StatusNotFound, code)
+	_, err = shop.Execute(&RequestContext{Context: context.Background(), Query: `{ viewer { name } }`}, nil)
+	assert.Equal(t, ErrGatewayShuttingDown, err)
+	assert.Equal(t, ErrMultiplexerGroupNotFound, multiplexer.Remove(context.Background(), "shop"))
+
+	code, _ = send("/graphql/blog", `{ viewer { name } }`)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = send("/graphql/unknown", `{ viewer { name } }`)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// once it's shut down, nothing can be added
+	assert.Nil(t, multiplexer.Shutdown(context.Background()))
+	assert.Empty(t, multiplexer.Groups())
+	assert.Equal(t, ErrMultiplexerShutDown, multiplexer.Register("shop", other))
+}
@@ -0,0 +1,217 @@
+package gateway
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// A step that is inserted into every entry of a list is executed once for every entry, which means one node
+// query per object. Services that implement nodes(ids: [ID!]!): [Node]! can look all of them up at once.
+// The planner prepares a second version of the query for the steps that target one of those services and
+// when a step finds more than one place to insert a dependent step, the ids of every place are sent in a single
+// nodes query. Each entry of the response belongs to the id in the same position. A null entry means the
+// service doesn't know about the object so the object is set to null in the response, which then follows the
+// usual rules for non-null fields.
+
+const (
+	// the alias of the nodes field used to look up the parents of a step all at once
+	gatewayNodesAlias = "__gateway_nodes"
+	// the variable that holds the ids of the parents of a step that is looked up with nodes
+	gatewayIDsVariable = "__gateway_ids"
+)
+
+// nodesBatchLocations returns the url of every service that can look up a list of objects with nodes(ids:)
+func nodesBatchLocations(sources []*graphql.RemoteSchema) Set {
+	locations := Set{}
+	for _, source := range sources {
+		if source.Schema.Query == nil {
+			continue
+		}
+
+		nodes := source.Schema.Query.Fields.ForName("nodes")
+		if nodes == nil || nodes.Type.Elem == nil || len(nodes.Arguments) == 0 {
+			continue
+		}
+
+		// the field can't require anything other than the ids
+		ids := nodes.Arguments.ForName("ids")
+		if ids == nil || ids.Type.String() != "[ID!]!" {
+			continue
+		}
+		supported := true
+		for _, argument := range nodes.Arguments {
+			if argument != ids && argument.Type.NonNull && argument.DefaultValue == nil {
+				supported = false
+			}
+		}
+
+		if supported {
+			locations.Add(source.URL)
+		}
+	}
+
+	return locations
+}
+
+// plannerBuildBatchQuery builds the query that resolves the selection for a list of parents with the nodes field
+func plannerBuildBatchQuery(operationName string, parentType string, variables ast.VariableDefinitionList, selectionSet ast.SelectionSet, fragmentDefinitions ast.FragmentDefinitionList) *ast.QueryDocument {
+	// we want the operation to have the equivalent of
+	// {
+	//	 	__gateway_nodes: nodes(ids: $__gateway_ids) {
+	//	 		... on parentType {
+	//	 			selection
+	//	 		}
+	//	 	}
+	// }
+	operation := &ast.OperationDefinition{
+		Name:      operationName,
+		Operation: ast.Query,
+		VariableDefinitions: append(append(ast.VariableDefinitionList{}, variables...), &ast.VariableDefinition{
+			Variable: gatewayIDsVariable,
+			Type:     ast.NonNullListType(ast.NonNullNamedType("ID", &ast.Position{}), &ast.Position{}),
+		}),
+		SelectionSet: ast.SelectionSet{
+			&ast.Field{
+				Name:  "nodes",
+				Alias: gatewayNodesAlias,
+				Arguments: ast.ArgumentList{
+					&ast.Argument{
+						Name: "ids",
+						Value: &ast.Value{
+							Kind: ast.Variable,
+							Raw:  gatewayIDsVariable,
+						},
+					},
+				},
+				SelectionSet: ast.SelectionSet{
+					&ast.InlineFragment{
+						TypeCondition: parentType,
+						SelectionSet:  selectionSet,
+					},
+				},
+			},
+		},
+	}
+
+	return &ast.QueryDocument{
+		Operations: ast.OperationList{operation},
+		Fragments:  fragmentDefinitions,
+	}
+}
+
+// nodesBatch looks up the objects for every invocation of a step that was started by the same parent with a
+// single query. The first invocation to ask for its object sends the query for all of them.
+type nodesBatch struct {
+	step *QueryPlanStep
+	// the ids to look up, without duplicates
+	ids       []string
+	variables map[string]interface{}
+
+	once       sync.Once
+	objects    map[string]map[string]interface{}
+	extensions map[string]interface{}
+	err        error
+}
+
+// newNodesBatch returns the batch for the invocations of the step at the insertion points, or nil if
+// the step is better off looking each one up on its own
+func newNodesBatch(step *QueryPlanStep, insertionPoints []InsertionPoint, variables map[string]interface{}) *nodesBatch {
+	if step.BatchQueryDocument == nil || len(insertionPoints) < 2 {
+		return nil
+	}
+
+	ids := []string{}
+	seen := Set{}
+	for _, insertionPoint := range insertionPoints {
+		id := insertionPoint.ID()
+		if id == "" || seen.Has(id) {
+			continue
+		}
+		seen.Add(id)
+		ids = append(ids, id)
+	}
+
+	return &nodesBatch{step: step, ids: ids, variables: variables}
+}
+
+// get returns the object with the id along with the extensions of the response. The object is nil if the
+// service couldn't find it.
+func (b *nodesBatch) get(ctx *ExecutionContext, plan *QueryPlan, id string) (map[string]interface{}, map[string]interface{}, error) {
+	b.once.Do(func() {
+		b.objects, b.extensions, b.err = b.fetch(ctx, plan)
+	})
+	if b.err != nil {
+		return nil, nil, b.err
+	}
+
+	object, ok := b.objects[id]
+	if !ok || object == nil {
+		return nil, b.extensions, nil
+	}
+
+	// every invocation modifies its object as it is stitched into the response
+	return stepMemoCopy(object).(map[string]interface{}), b.extensions, nil
+}
+
+// fetch sends the nodes query and returns the object for each id
+func (b *nodesBatch) fetch(ctx *ExecutionContext, plan *QueryPlan) (map[string]map[string]interface{}, map[string]interface{}, error) {
+	ids := []interface{}{}
+	for _, id := range b.ids {
+		ids = append(ids, id)
+	}
+
+	variables := map[string]interface{}{gatewayIDsVariable: ids}
+	for name, value := range b.variables {
+		variables[name] = value
+	}
+
+	queryResult, extensions, err := executorSendStep(ctx, plan, b.step, b.step.BatchQueryString, b.step.BatchQueryDocument, variables)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the entries of the list belong to the ids in the same position
+	entries, ok := queryResult[gatewayNodesAlias].([]interface{})
+	if !ok || len(entries) != len(b.ids) {
+		return nil, nil, fmt.Errorf("Query result of nodes query was not a list with %v entries: %v", len(b.ids), queryResult)
+	}
+
+	objects := map[string]map[string]interface{}{}
+	for i, entry := range entries {
+		if entry == nil {
+			objects[b.ids[i]] = nil
+			continue
+		}
+
+		object, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("Query result of nodes query was not an object: %v", entry)
+		}
+		objects[b.ids[i]] = object
+	}
+
+	return objects, extensions, nil
+}
+
+// notFound marks the object that the step was going to be inserted into as one the service couldn't find. It
+// is set to null once the plan has been executed.
+func (e *executorPendingStep) notFound(resultCh chan *queryExecutionResult, stepWg *sync.WaitGroup) {
+	published, _ := e.finish(nil, stepWg)
+	if !published {
+		return
+	}
+
+	e.pending.mutex.Lock()
+	e.pending.missing = append(e.pending.missing, e)
+	e.pending.mutex.Unlock()
+
+	// the executor still needs to hear that the step is done
+	e.send(resultCh, &queryExecutionResult{
+		InsertionPoint: e.insertionPoint,
+		Result:         map[string]interface{}{},
+		Location:       e.step.Location,
+	})
+}
@@ -0,0 +1,192 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_nodesBatchLookup(t *testing.T) {
+	usersSchema := `
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			allUsers: %s
+		}
+	`
+	// the reviews service can look up a list of users at once, unless it's the old version
+	reviewsSchema := `
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			rating: Int!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			%s
+		}
+	`
+
+	// execute runs the query against the services and returns the result along with the queries sent to reviews
+	execute := func(listType string, nodesField string) (map[string]interface{}, []*graphql.QueryInput, error) {
+		usersSource, _ := graphql.LoadSchema(fmt.Sprintf(usersSchema, listType))
+		reviewsSource, _ := graphql.LoadSchema(fmt.Sprintf(reviewsSchema, nodesField))
+
+		lock := &sync.Mutex{}
+		received := []*graphql.QueryInput{}
+		factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+			return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+				// 25 users, the last one is the same as the first and the service doesn't know about user-3
+				if url == "users" {
+					users := []interface{}{}
+					for i := 0; i < 24; i++ {
+						users = append(users, map[string]interface{}{gatewayIDAlias: fmt.Sprintf("user-%d", i)})
+					}
+					users = append(users, map[string]interface{}{gatewayIDAlias: "user-0"})
+
+					return map[string]interface{}{"allUsers": users}, nil
+				}
+
+				lock.Lock()
+				received = append(received, input)
+				lock.Unlock()
+
+				// the rating is the number in the id
+				rating := func(id interface{}) interface{} {
+					if id == "user-3" {
+						return nil
+					}
+					var rating int
+					fmt.Sscanf(id.(string), "user-%d", &rating)
+					return map[string]interface{}{"rating": rating}
+				}
+
+				if ids, ok := input.Variables[gatewayIDsVariable].([]interface{}); ok {
+					nodes := []interface{}{}
+					for _, id := range ids {
+						nodes = append(nodes, rating(id))
+					}
+					return map[string]interface{}{gatewayNodesAlias: nodes}, nil
+				}
+				return map[string]interface{}{gatewayNodeAlias: rating(input.Variables[gatewayIDAlias])}, nil
+			})
+		})
+
+		gateway, err := New([]*graphql.RemoteSchema{
+			{URL: "users", Schema: usersSource},
+			{URL: "reviews", Schema: reviewsSource},
+		}, WithQueryerFactory(&factory))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		requestContext := &RequestContext{
+			Context: context.Background(),
+			Query:   `{ allUsers { rating } }`,
+		}
+		plans, err := gateway.GetPlans(requestContext)
+		if err != nil {
+			return nil, nil, err
+		}
+		result, err := gateway.Execute(requestContext, plans)
+		return result, received, err
+	}
+
+	t.Run("nodes", func(t *testing.T) {
+		result, received, err := execute("[User]!", "nodes(ids: [ID!]!): [Node]!")
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// every user was looked up in one query, with each id sent once
+		if !assert.Len(t, received, 1) {
+			return
+		}
+		ids := received[0].Variables[gatewayIDsVariable].([]interface{})
+		assert.Len(t, ids, 24)
+		assert.Contains(t, received[0].Query, "nodes(ids: $"+gatewayIDsVariable+")")
+
+		// the users are in the same order they were returned in
+		entries := result["allUsers"].([]interface{})
+		if !assert.Len(t, entries, 25) {
+			return
+		}
+		assert.Equal(t, map[string]interface{}{"rating": 2}, entries[2])
+		assert.Equal(t, map[string]interface{}{"rating": 23}, entries[23])
+		assert.Equal(t, map[string]interface{}{"rating": 0}, entries[24])
+
+		// and the one the service couldn't find is null
+		assert.Nil(t, entries[3])
+	})
+
+	t.Run("non-null", func(t *testing.T) {
+		// the missing user can't be null so the list goes instead
+		result, _, err := execute("[User!]", "nodes(ids: [ID!]!): [Node]!")
+		assert.Equal(t, map[string]interface{}{"allUsers": nil}, result)
+
+		errs, ok := err.(graphql.ErrorList)
+		if !assert.True(t, ok) || !assert.Len(t, errs, 1) {
+			return
+		}
+		assert.Equal(t, []interface{}{"allUsers", 3}, errs[0].(*graphql.Error).Path)
+	})
+
+	t.Run("node", func(t *testing.T) {
+		// without the nodes field, every user gets its own query
+		_, received, _ := execute("[User]!", "")
+		assert.Len(t, received, 24)
+		for _, input := range received {
+			assert.NotContains(t, input.Query, "nodes")
+		}
+	})
+}
+
+func TestNodesBatchLocations(t *testing.T) {
+	testCases := []struct {
+		Message   string
+		Field     string
+		Supported bool
+	}{
+		{"Supported", "nodes(ids: [ID!]!): [Node]!", true},
+		{"Optional arguments", "nodes(ids: [ID!]!, first: Int): [Node]!", true},
+		{"Nullable ids", "nodes(ids: [ID!]): [Node]!", false},
+		{"Other arguments", "nodes(ids: [ID!]!, tenant: String!): [Node]!", false},
+		{"Not a list", "nodes(ids: [ID!]!): Node", false},
+		{"Missing", "node(id: ID!): Node", false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.Message, func(t *testing.T) {
+			schema, err := graphql.LoadSchema(fmt.Sprintf(`
+				interface Node {
+					id: ID!
+				}
+
+				type Query {
+					%s
+				}
+			`, testCase.Field))
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			locations := nodesBatchLocations([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}})
+			assert.Equal(t, testCase.Supported, locations.Has("url1"))
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Every number in a JSON document decodes to a float64 by default, which can't hold the integers past 2^53 or
+// decimals with more than 15 significant digits. A 64-bit id that goes through the gateway like that comes out
+// as a different id. Instead, when a document from a client or a service has a number like that, its numbers
+// are kept as json.Number so they are sent along exactly as they were written. Documents whose numbers all fit
+// in a float64 are decoded the usual way.
+//
+// The queryers of the graphql package decode the responses themselves, so the transport of the queryers the
+// gateway creates decodes the data of a response a second time whenever it has a number that a float64 would
+// change. The queryer's version of the response is replaced with that one once the queryer is done.
+
+// the number of significant digits that always survive a trip through a float64
+const preciseDigits = 15
+
+// decodeJSON decodes the document into the target, keeping its numbers as json.Number
+func decodeJSON(data []byte, target interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(target); err != nil {
+		return err
+	}
+
+	// like json.Unmarshal, there can't be anything after the document
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid character after top-level value")
+	}
+
+	return nil
+}
+
+// unmarshalJSON decodes the document into the target like json.Unmarshal, unless the document has a number that
+// a float64 would change. Then all of its numbers are kept as json.Number.
+func unmarshalJSON(data []byte, target interface{}) error {
+	if !jsonHasImpreciseNumbers(data) {
+		return json.Unmarshal(data, target)
+	}
+
+	return decodeJSON(data, target)
+}
+
+// jsonHasImpreciseNumbers returns true if the document has a number that would change if it was decoded as a float64
+func jsonHasImpreciseNumbers(data []byte) bool {
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		// nothing inside of a string is a number
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+			continue
+		}
+
+		if c != '-' && (c < '0' || c > '9') {
+			continue
+		}
+
+		// count the significant digits of the number, leaving out the exponent
+		digits, zeros, significant, exponent := 0, 0, false, false
+		for ; i < len(data); i++ {
+			c = data[i]
+			if c == 'e' || c == 'E' {
+				exponent = true
+			} else if c >= '0' && c <= '9' {
+				if exponent {
+					continue
+				}
+				// trailing zeros don't need to be stored, unless another digit follows them
+				if c == '0' {
+					zeros++
+					continue
+				}
+				if significant {
+					digits += zeros
+				}
+				digits++
+				zeros = 0
+				significant = true
+			} else if c != '.' && c != '-' && c != '+' {
+				break
+			}
+		}
+		i--
+
+		if digits > preciseDigits {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONHasImpreciseNumbers(t *testing.T) {
+	table := []struct {
+		document  string
+		imprecise bool
+	}{
+		{`{"a": 12345, "b": -1.5e10}`, false},
+		{`{"a": 9007199254740991}`, true},
+		{`{"a": -9007199254740993}`, true},
+		{`{"a": "9007199254740993"}`, false},
+		{`{"a": "quote \" 9007199254740993"}`, false},
+		{`{"a": 0.1, "b": 0.000000000000001}`, false},
+		{`{"a": 3.14159265358979323846}`, true},
+		{`{"a": 10000000000000000000, "b": 1e300}`, false},
+		{`[1, 2, 123456789012345678]`, true},
+	}
+
+	for _, row := range table {
+		assert.Equal(t, row.imprecise, jsonHasImpreciseNumbers([]byte(row.document)), row.document)
+	}
+}
+
+func TestGateway_preciseNumbers(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		scalar Long
+
+		type Account {
+			id: ID!
+			balance: Float!
+			sequence: Long!
+			count: Int!
+		}
+
+		type Query {
+			account(id: ID!, minBalance: Float, after: Long): Account
+		}
+	`)
+
+	// the body of the request the service was sent
+	var sent string
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		sent = string(body)
+
+		w.Write([]byte(`{"data": {"account": {
+			"id": "1",
+			"balance": 12345678901234567.89,
+			"sequence": 9007199254740993,
+			"count": 5
+		}}}`))
+	}))
+	defer service.Close()
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{
+		"query": "query($id: ID!, $min: Float, $after: Long) { account(id: $id, minBalance: $min, after: $after) { id balance sequence count } }",
+		"variables": {"id": 1234567890123456789, "min": 0.12345678901234567, "after": 18446744073709551615}
+	}`))
+	response := httptest.NewRecorder()
+	gateway.GraphQLHandler(response, request)
+
+	// the variables reach the service exactly as the client wrote them
+	assert.Contains(t, sent, `"id":"1234567890123456789"`)
+	assert.Contains(t, sent, `"min":0.12345678901234567`)
+	assert.Contains(t, sent, `"after":18446744073709551615`)
+
+	// and so does the response on the way back
+	assert.Equal(t,
+		`{"data":{"account":{"id":"1","balance":12345678901234567.89,"sequence":9007199254740993,"count":5}}}`,
+		strings.TrimSpace(response.Body.String()),
+	)
+}
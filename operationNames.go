@@ -0,0 +1,31 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/nautilus/graphql"
+)
+
+// The queries the gateway sends to the services are named after the operation the client sent (see
+// plannerOperationName) so that a slow query in the logs of a service can be traced back to the client.
+// The name of the client's operation is also sent along in a header so the services don't have to parse it.
+
+// DefaultParentOperationHeader is the header that carries the name of the client's operation unless
+// another one is provided
+const DefaultParentOperationHeader = "X-Gateway-Parent-Operation"
+
+// WithParentOperationHeader returns an Option that changes the header used to send the name of the
+// client's operation to the services. An empty header leaves it off.
+func WithParentOperationHeader(header string) Option {
+	return func(g *Gateway) {
+		g.parentOperationHeader = header
+	}
+}
+
+// parentOperationMiddleware adds the name of the client's operation to the requests sent to the services
+func parentOperationMiddleware(header string, operationName string) graphql.NetworkMiddleware {
+	return func(r *http.Request) error {
+		r.Header.Set(header, operationName)
+		return nil
+	}
+}
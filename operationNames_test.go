@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_operationNames(t *testing.T) {
+	// the requests that the service received
+	type received struct {
+		operationName string
+		query         string
+		parent        string
+	}
+	requests := []received{}
+
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := &graphql.QueryInput{}
+		json.NewDecoder(r.Body).Decode(input)
+		requests = append(requests, received{
+			operationName: input.OperationName,
+			query:         input.Query,
+			parent:        r.Header.Get(DefaultParentOperationHeader),
+		})
+
+		w.Write([]byte(`{"data": {"hello": "world"}}`))
+	}))
+	defer service.Close()
+
+	// execute sends the query through a gateway built with the options
+	execute := func(query string, options ...Option) {
+		schema, _ := graphql.LoadSchema(`
+			type Query {
+				hello: String!
+			}
+		`)
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}}, options...)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		ctx := &RequestContext{Context: context.Background(), Query: query}
+		plans, err := gateway.GetPlans(ctx)
+		if !assert.Nil(t, err) {
+			return
+		}
+		result, err := gateway.Execute(ctx, plans)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"hello": "world"}, result)
+	}
+
+	execute(`query MyQuery { hello }`)
+	execute(`query MyQuery { hello }`)
+	execute(`{ hello }`)
+	execute(`query MyQuery { hello }`, WithParentOperationHeader(""))
+	if !assert.Len(t, requests, 4) {
+		return
+	}
+
+	// the query is named after the client's operation, in the envelope and in the query itself
+	name := requests[0].operationName
+	assert.True(t, strings.HasPrefix(name, "MyQuery_"), name)
+	assert.True(t, strings.HasPrefix(requests[0].query, "query "+name+" "), requests[0].query)
+	assert.Equal(t, "MyQuery", requests[0].parent)
+
+	// the same query always gets the same name
+	assert.Equal(t, name, requests[1].operationName)
+	assert.Equal(t, requests[0].query, requests[1].query)
+
+	// anonymous operations still get a name but there is no parent to point to
+	assert.True(t, strings.HasPrefix(requests[2].operationName, anonymousOperationName+"_"), requests[2].operationName)
+	assert.Equal(t, "", requests[2].parent)
+
+	// the header can be turned off
+	assert.Equal(t, name, requests[3].operationName)
+	assert.Equal(t, "", requests[3].parent)
+}
+
+func TestPlanQuery_stableOperationNames(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			name: String!
+		}
+
+		type Query {
+			user(a: String, b: String, c: String, d: String): User
+		}
+	`)
+	planner := &MinQueriesPlanner{}
+	locations := FieldURLMap{}
+	locations.RegisterURL("Query", "user", "url1")
+	locations.RegisterURL("User", "name", "url1")
+
+	// the variables are declared in the same order every time so the query always gets the same name
+	names := Set{}
+	for i := 0; i < 30; i++ {
+		plans, err := planner.Plan(&PlanningContext{
+			Query:     `query Q($a: String, $b: String, $c: String, $d: String) { user(a: $a, b: $b, c: $c, d: $d) { name } }`,
+			Schema:    schema,
+			Locations: locations,
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+		step := plans[0].RootStep.Then[0]
+		names.Add(step.QueryDocument.Operations[0].Name)
+		assert.Contains(t, step.QueryString, "($a: String, $b: String, $c: String, $d: String)")
+	}
+	assert.Len(t, names, 1)
+}
@@ -0,0 +1,160 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// The results of a query are stitched together concurrently in maps which means that the order
+// of the keys is lost by the time the response is serialized. In order to give clients the
+// same response every time, the final value is rebuilt with objects that remember the order
+// in which fields were requested.
+
+// OrderedMap is an object whose keys are serialized in a specific order
+type OrderedMap struct {
+	Keys   []string
+	Values map[string]interface{}
+}
+
+// MarshalJSON serializes the map with its keys in order
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+
+	for i, key := range m.Keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		// write the key
+		keyBytes, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(keyBytes)
+		buf.WriteByte(':')
+
+		// and the value
+		valueBytes, err := json.Marshal(m.Values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(valueBytes)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// orderResult returns a version of the value whose objects are ordered according to the selection set
+func orderResult(value interface{}, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		// flatten the fragments so we have the list of fields in the order they were asked for
+		selection := collectSelectedFields(selectionSet, fragments)
+
+		fields := graphql.SelectedFields(selection)
+
+		// the same field can show up more than once and the value has what every one of them asked for
+		selectionSets := map[string]ast.SelectionSet{}
+		for _, field := range fields {
+			key := orderingKey(field)
+			selectionSets[key] = append(selectionSets[key], field.SelectionSet...)
+		}
+
+		result := &OrderedMap{Values: map[string]interface{}{}}
+		seen := Set{}
+
+		for _, field := range fields {
+			key := orderingKey(field)
+
+			// the field goes where it was first asked for
+			if seen.Has(key) {
+				continue
+			}
+
+			// if the field isn't in the value (ie, from a fragment on a different type)
+			fieldValue, ok := value[key]
+			if !ok {
+				continue
+			}
+			seen.Add(key)
+
+			// order the value of the field
+			ordered, err := orderResult(fieldValue, selectionSets[key], fragments)
+			if err != nil {
+				return nil, err
+			}
+
+			result.Keys = append(result.Keys, key)
+			result.Values[key] = ordered
+		}
+
+		// any keys that weren't in the selection set go at the end in a stable order
+		leftovers := []string{}
+		for key := range value {
+			if !seen.Has(key) {
+				leftovers = append(leftovers, key)
+			}
+		}
+		sort.Strings(leftovers)
+
+		for _, key := range leftovers {
+			result.Keys = append(result.Keys, key)
+			result.Values[key] = value[key]
+		}
+
+		return result, nil
+
+	case []interface{}:
+		// order each entry in the list
+		result := make([]interface{}, len(value))
+		for i, entry := range value {
+			ordered, err := orderResult(entry, selectionSet, fragments)
+			if err != nil {
+				return nil, err
+			}
+
+			result[i] = ordered
+		}
+
+		return result, nil
+
+	default:
+		// scalars don't have an order
+		return value, nil
+	}
+}
+
+// orderingKey returns the key of the field in the response
+func orderingKey(field *ast.Field) string {
+	if field.Alias == "" {
+		return field.Name
+	}
+
+	return field.Alias
+}
+
+// orderedData returns the data of the response ordered by the operation that was executed. If the
+// operation cannot be found, the data is returned as is.
+func (g *Gateway) orderedData(ctx *RequestContext, plans QueryPlanList, data map[string]interface{}) interface{} {
+	if data == nil {
+		return nil
+	}
+
+	plan, err := g.planForOperation(ctx, plans)
+	if err != nil || plan.Operation == nil {
+		return data
+	}
+
+	ordered, err := orderResult(data, plan.Operation.SelectionSet, plan.FragmentDefinitions)
+	if err != nil {
+		return data
+	}
+
+	return ordered
+}
@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestOrderResult(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			firstName: String!
+			lastName: String!
+			friends: [User!]!
+		}
+
+		type Query {
+			me: User
+		}
+	`)
+
+	query, err := gqlparser.LoadQuery(schema, `
+		{
+			me {
+				lastName
+				...Names
+				friends {
+					name: lastName
+					id
+				}
+			}
+		}
+
+		fragment Names on User {
+			firstName
+			lastName
+		}
+	`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	value := map[string]interface{}{
+		"me": map[string]interface{}{
+			"friends": []interface{}{
+				map[string]interface{}{"id": "2", "name": "Smith"},
+				map[string]interface{}{"id": "3", "name": "Doe"},
+			},
+			"firstName": "John",
+			"lastName":  "Smith",
+			"extra":     true,
+		},
+	}
+
+	ordered, orderErr := orderResult(value, query.Operations[0].SelectionSet, query.Fragments)
+	if !assert.Nil(t, orderErr) {
+		return
+	}
+
+	serialized, marshalErr := json.Marshal(ordered)
+	if !assert.Nil(t, marshalErr) {
+		return
+	}
+
+	assert.Equal(t,
+		`{"me":{"lastName":"Smith","firstName":"John","friends":[{"name":"Smith","id":"2"},{"name":"Doe","id":"3"}],"extra":true}}`,
+		string(serialized),
+	)
+}
+
+func TestOrderResult_repeatedFields(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			firstName: String!
+			lastName: String!
+		}
+
+		type Query {
+			me: User
+		}
+	`)
+
+	// the fields under me are split between 2 selections of it
+	query, err := gqlparser.LoadQuery(schema, `
+		{
+			me {
+				lastName
+			}
+			me {
+				id
+				firstName
+			}
+		}
+	`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	value := map[string]interface{}{
+		"me": map[string]interface{}{
+			"firstName": "John",
+			"lastName":  "Smith",
+			"id":        "1",
+		},
+	}
+
+	ordered, orderErr := orderResult(value, query.Operations[0].SelectionSet, query.Fragments)
+	if !assert.Nil(t, orderErr) {
+		return
+	}
+
+	serialized, marshalErr := json.Marshal(ordered)
+	if !assert.Nil(t, marshalErr) {
+		return
+	}
+	assert.Equal(t, `{"me":{"lastName":"Smith","id":"1","firstName":"John"}}`, string(serialized))
+}
+
+func TestGraphQLHandler_orderedResponse(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			alpha: String!
+			beta: String!
+			gamma: String!
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithExecutor(ExecutorFunc(
+		func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"alpha": "1", "beta": "2", "gamma": "3"}, nil
+		},
+	)))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// every request should get the fields back in the order they asked for them
+	for i := 0; i < 10; i++ {
+		request := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ gamma alpha beta }"}`))
+		responseRecorder := httptest.NewRecorder()
+
+		gateway.GraphQLHandler(responseRecorder, request)
+
+		assert.Equal(t, `{"data":{"gamma":"3","alpha":"1","beta":"2"}}`, responseRecorder.Body.String())
+	}
+}
+
+func TestOrderedMap_empty(t *testing.T) {
+	serialized, err := json.Marshal(&OrderedMap{})
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(serialized))
+
+	// make sure we handle values without a selection
+	ordered, err := orderResult("hello", ast.SelectionSet{}, ast.FragmentDefinitionList{})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", ordered)
+}
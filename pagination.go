@@ -0,0 +1,287 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Services tend to trust the page size they are given, so a client that leaves it out (or asks for a million
+// entries) can make a service do far more work than anyone meant it to. Pagination limits let the gateway
+// enforce a default and a maximum for the arguments that control the size of a page. They apply to the fields
+// that return a list (or a relay connection) and declare an Int argument with one of the configured names.
+//
+// Values written in the query are fixed while the query is planned: a missing argument gets the default and
+// a value over the maximum is lowered to it, so the queries sent to the services already carry the right
+// values. Arguments bound to a variable are checked once the variables are known. Lowering a variable changes
+// it everywhere it is used in the operation. Either way, a client whose page size was lowered finds out about it
+// under extensions.paginationWarnings.
+
+// PaginationBehavior designates what happens to a page size over the maximum
+type PaginationBehavior int
+
+const (
+	// PaginationClamp sends the maximum in place of the larger value and warns the client about it
+	PaginationClamp PaginationBehavior = iota
+	// PaginationReject fails the operation
+	PaginationReject
+)
+
+// PaginationLimit configures the values of a pagination argument
+type PaginationLimit struct {
+	// the value sent when the client leaves the argument out (or sends null). Zero leaves the argument alone.
+	Default int
+	// the largest value the client can ask for. Zero means there is no maximum.
+	Max int
+	// what happens to a value larger than the maximum
+	Behavior PaginationBehavior
+}
+
+// PaginationLimits holds the limit of each pagination argument, indexed by the name of the argument (ie, first,
+// limit, or pageSize)
+type PaginationLimits map[string]PaginationLimit
+
+// PaginationWarning describes a page size that was lowered to the maximum
+type PaginationWarning struct {
+	// the keys of the fields in the response that lead to the field with the argument
+	Path []string `json:"path"`
+	// the type and field, ie Query.users
+	Coordinate string `json:"coordinate"`
+	Argument   string `json:"argument"`
+	Requested  int    `json:"requested"`
+	Applied    int    `json:"applied"`
+}
+
+// PaginationVariable is a pagination argument that gets its value from one of the client's variables
+type PaginationVariable struct {
+	Variable   string
+	Path       []string
+	Coordinate string
+	Argument   string
+	Limit      PaginationLimit
+}
+
+// WithPaginationLimits returns an Option that enforces the limits on the pagination arguments of list fields
+func WithPaginationLimits(limits PaginationLimits) Option {
+	return func(g *Gateway) {
+		g.paginationLimits = limits
+	}
+}
+
+// plannerApplyPaginationLimits sets the pagination arguments of the fields in the query to values within their
+// limits. It returns the limiter of each operation, which holds the values that were lowered along with the
+// arguments that can only be checked once the variables are known.
+func plannerApplyPaginationLimits(query *ast.QueryDocument, limits PaginationLimits) (map[*ast.OperationDefinition]*paginationLimiter, error) {
+	limiters := map[*ast.OperationDefinition]*paginationLimiter{}
+
+	// look at the arguments in a consistent order so the query doesn't change between plans
+	names := sortedLimitNames(limits)
+
+	errs := graphql.ErrorList{}
+	for _, operation := range query.Operations {
+		limiter := &paginationLimiter{limits: limits, names: names, visited: map[*ast.FragmentDefinition]bool{}}
+		limiter.selectionSet(operation.SelectionSet, []string{})
+
+		limiters[operation] = limiter
+		errs = append(errs, limiter.errs...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return limiters, nil
+}
+
+// sortedLimitNames returns the names of the arguments that have limits in alphabetical order
+func sortedLimitNames(limits PaginationLimits) []string {
+	names := []string{}
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// paginationLimiter walks an operation and fixes the pagination arguments of its fields
+type paginationLimiter struct {
+	limits PaginationLimits
+	names  []string
+	// a fragment only has to be fixed once, no matter how many times it's spread
+	visited map[*ast.FragmentDefinition]bool
+
+	warnings  []*PaginationWarning
+	variables []*PaginationVariable
+	errs      graphql.ErrorList
+}
+
+func (l *paginationLimiter) selectionSet(selectionSet ast.SelectionSet, path []string) {
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			key := selection.Alias
+			if key == "" {
+				key = selection.Name
+			}
+			fieldPath := append(append([]string{}, path...), key)
+
+			l.field(selection, fieldPath)
+			l.selectionSet(selection.SelectionSet, fieldPath)
+
+		case *ast.InlineFragment:
+			l.selectionSet(selection.SelectionSet, path)
+
+		case *ast.FragmentSpread:
+			if selection.Definition == nil || l.visited[selection.Definition] {
+				continue
+			}
+			l.visited[selection.Definition] = true
+			l.selectionSet(selection.Definition.SelectionSet, path)
+		}
+	}
+}
+
+// field fixes the pagination arguments of the field
+func (l *paginationLimiter) field(field *ast.Field, path []string) {
+	definition := field.Definition
+	if definition == nil || !paginatedField(definition) {
+		return
+	}
+
+	coordinate := field.Name
+	if field.ObjectDefinition != nil {
+		coordinate = field.ObjectDefinition.Name + "." + field.Name
+	}
+
+	// the schema extensions can set the limits of the field
+	limits, names := paginationDirectiveLimits(l.limits, l.names, definition)
+
+	for _, name := range names {
+		limit := limits[name]
+
+		// the argument has to be a page size
+		argumentDefinition := definition.Arguments.ForName(name)
+		if argumentDefinition == nil || argumentDefinition.Type.Elem != nil || argumentDefinition.Type.NamedType != "Int" {
+			continue
+		}
+
+		argument := field.Arguments.ForName(name)
+		switch {
+		// the client didn't ask for a size
+		case argument == nil || argument.Value == nil || argument.Value.Kind == ast.NullValue:
+			if limit.Default > 0 {
+				paginationSetArgument(field, name, limit.Default)
+			}
+
+		// the value will only be known when the operation is executed
+		case argument.Value.Kind == ast.Variable:
+			l.variables = append(l.variables, &PaginationVariable{
+				Variable:   argument.Value.Raw,
+				Path:       path,
+				Coordinate: coordinate,
+				Argument:   name,
+				Limit:      limit,
+			})
+
+		case argument.Value.Kind == ast.IntValue:
+			requested, err := strconv.Atoi(argument.Value.Raw)
+			if err != nil || limit.Max <= 0 || requested <= limit.Max {
+				continue
+			}
+
+			if limit.Behavior == PaginationReject {
+				l.errs = append(l.errs, paginationError(path, coordinate, name, requested, limit.Max))
+				continue
+			}
+
+			paginationSetArgument(field, name, limit.Max)
+			l.warnings = append(l.warnings, &PaginationWarning{
+				Path:       path,
+				Coordinate: coordinate,
+				Argument:   name,
+				Requested:  requested,
+				Applied:    limit.Max,
+			})
+		}
+	}
+}
+
+// paginatedField returns true if the field returns pages of values
+func paginatedField(definition *ast.FieldDefinition) bool {
+	return definition.Type.Elem != nil || strings.HasSuffix(definition.Type.Name(), "Connection")
+}
+
+// paginationSetArgument sets the argument of the field to the designated value
+func paginationSetArgument(field *ast.Field, name string, value int) {
+	argument := &ast.Argument{
+		Name: name,
+		Value: &ast.Value{
+			Kind: ast.IntValue,
+			Raw:  strconv.Itoa(value),
+		},
+	}
+
+	// the argument could be shared with other fields so it is replaced instead of changed
+	for i, existing := range field.Arguments {
+		if existing.Name == name {
+			field.Arguments[i] = argument
+			return
+		}
+	}
+	field.Arguments = append(field.Arguments, argument)
+}
+
+// paginationError returns the error for a page size that is over the maximum
+func paginationError(path []string, coordinate string, argument string, requested int, max int) *graphql.Error {
+	err := graphql.NewError("BAD_USER_INPUT", fmt.Sprintf(`Argument "%s" of %s cannot be more than %d, got %d.`, argument, coordinate, max, requested))
+	for _, key := range path {
+		err.Path = append(err.Path, key)
+	}
+	return err
+}
+
+// applyPaginationLimits puts the variables bound to pagination arguments within their limits. It returns every page
+// size of the plan that was lowered.
+func applyPaginationLimits(plan *QueryPlan, variables map[string]interface{}) ([]*PaginationWarning, error) {
+	warnings := append([]*PaginationWarning{}, plan.PaginationWarnings...)
+
+	errs := graphql.ErrorList{}
+	for _, argument := range plan.PaginationVariables {
+		value, ok := variables[argument.Variable]
+		if !ok || value == nil {
+			if argument.Limit.Default > 0 {
+				variables[argument.Variable] = argument.Limit.Default
+			}
+			continue
+		}
+
+		number, ok := variableNumber(value)
+		if !ok || argument.Limit.Max <= 0 || int(number) <= argument.Limit.Max {
+			continue
+		}
+		requested := int(number)
+
+		if argument.Limit.Behavior == PaginationReject {
+			errs = append(errs, paginationError(argument.Path, argument.Coordinate, argument.Argument, requested, argument.Limit.Max))
+			continue
+		}
+
+		variables[argument.Variable] = argument.Limit.Max
+		warnings = append(warnings, &PaginationWarning{
+			Path:       argument.Path,
+			Coordinate: argument.Coordinate,
+			Argument:   argument.Argument,
+			Requested:  requested,
+			Applied:    argument.Limit.Max,
+		})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return warnings, nil
+}
@@ -0,0 +1,132 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_paginationLimits(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String
+		}
+
+		type UserConnection {
+			totalCount: Int
+		}
+
+		type Query {
+			users(first: Int, name: String): [User!]!
+			userConnection(first: Int): UserConnection
+			owner(first: Int): User
+			search(limit: Int): [User!]!
+		}
+	`)
+
+	// the queries the service was sent along with their variables
+	var mutex sync.Mutex
+	inputs := []*graphql.QueryInput{}
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			mutex.Lock()
+			inputs = append(inputs, input)
+			mutex.Unlock()
+			return map[string]interface{}{}, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}},
+		WithQueryerFactory(&factory),
+		WithPaginationLimits(PaginationLimits{
+			"first": {Default: 50, Max: 500},
+			"limit": {Max: 100, Behavior: PaginationReject},
+		}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	execute := func(query string, variables map[string]interface{}) (*RequestContext, error) {
+		inputs = []*graphql.QueryInput{}
+
+		ctx := &RequestContext{Context: context.Background(), Query: query, Variables: variables}
+		plans, err := gateway.GetPlans(ctx)
+		if err != nil {
+			return ctx, err
+		}
+
+		_, err = gateway.Execute(ctx, plans)
+		return ctx, err
+	}
+
+	t.Run("default", func(t *testing.T) {
+		ctx, err := execute(`{ users { id } userConnection { totalCount } owner { id } }`, nil)
+		if !assert.Nil(t, err) || !assert.Len(t, inputs, 1) {
+			return
+		}
+
+		// only the fields that return a page get the default
+		assert.Contains(t, inputs[0].Query, "users(first: 50)")
+		assert.Contains(t, inputs[0].Query, "userConnection(first: 50)")
+		assert.Contains(t, inputs[0].Query, "owner {")
+		assert.NotContains(t, ctx.Extensions, "paginationWarnings")
+	})
+
+	t.Run("clamped literal", func(t *testing.T) {
+		ctx, err := execute(`{ all: users(first: 1000, name: "a") { id } }`, nil)
+		if !assert.Nil(t, err) || !assert.Len(t, inputs, 1) {
+			return
+		}
+
+		assert.Contains(t, inputs[0].Query, `users(first: 500, name: "a")`)
+		assert.Equal(t, []*PaginationWarning{
+			{Path: []string{"all"}, Coordinate: "Query.users", Argument: "first", Requested: 1000, Applied: 500},
+		}, ctx.Extensions["paginationWarnings"])
+	})
+
+	t.Run("clamped variable", func(t *testing.T) {
+		ctx, err := execute(`query($size: Int) { users(first: $size) { id } }`, map[string]interface{}{"size": 1000})
+		if !assert.Nil(t, err) || !assert.Len(t, inputs, 1) {
+			return
+		}
+
+		assert.Equal(t, 500, inputs[0].Variables["size"])
+		assert.Equal(t, []*PaginationWarning{
+			{Path: []string{"users"}, Coordinate: "Query.users", Argument: "first", Requested: 1000, Applied: 500},
+		}, ctx.Extensions["paginationWarnings"])
+	})
+
+	t.Run("missing variable", func(t *testing.T) {
+		_, err := execute(`query($size: Int) { users(first: $size) { id } }`, nil)
+		if !assert.Nil(t, err) || !assert.Len(t, inputs, 1) {
+			return
+		}
+
+		assert.Equal(t, 50, inputs[0].Variables["size"])
+	})
+
+	t.Run("rejected literal", func(t *testing.T) {
+		_, err := execute(`{ search(limit: 101) { id } }`, nil)
+		if !assert.NotNil(t, err) {
+			return
+		}
+
+		assert.Equal(t, "BAD_USER_INPUT", err.(graphql.ErrorList)[0].(*graphql.Error).Extensions["code"])
+		assert.Len(t, inputs, 0)
+	})
+
+	t.Run("rejected variable", func(t *testing.T) {
+		_, err := execute(`query($limit: Int) { search(limit: $limit) { id } }`, map[string]interface{}{"limit": 101})
+		if !assert.NotNil(t, err) {
+			return
+		}
+
+		assert.Equal(t, "BAD_USER_INPUT", err.(graphql.ErrorList)[0].(*graphql.Error).Extensions["code"])
+		assert.Len(t, inputs, 0)
+	})
+}
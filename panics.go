@@ -0,0 +1,56 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/nautilus/graphql"
+)
+
+// A bug in the gateway (or in one of the hooks it was given) can cause a panic at any point of a request. The
+// handler, the goroutines that build a plan or execute its steps, and the resolvers of the gateway's own fields
+// all recover from them so that a panic only fails the request that caused it. The client gets a regular
+// INTERNAL_SERVER_ERROR without any of the details and the panic is logged along with the stack that led to it.
+
+// panicMessage is the message of the error the client gets in place of a panic
+const panicMessage = "internal server error"
+
+// recoveredError logs a value recovered from a panic and returns the error to report in its place
+func recoveredError(recovered interface{}, during string) *graphql.Error {
+	log.WithFields(LoggerFields{
+		"panic": fmt.Sprint(recovered),
+		"stack": string(debug.Stack()),
+	}).Warn("Recovered from a panic while ", during)
+
+	return graphql.NewError("INTERNAL_SERVER_ERROR", panicMessage)
+}
+
+// recoverError is meant to be deferred by functions that return an error. If the function panics, it
+// returns the error for the panic instead.
+func recoverError(err *error, during string) {
+	if recovered := recover(); recovered != nil {
+		*err = recoveredError(recovered, during)
+	}
+}
+
+// recoverResponse is meant to be deferred by the handlers of the gateway. If the handler panics, the client
+// gets an error response instead of a broken connection.
+func (g *Gateway) recoverResponse(w http.ResponseWriter, r *http.Request) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	// net/http uses this one to abort a response on purpose
+	if recovered == http.ErrAbortHandler {
+		panic(recovered)
+	}
+
+	err := recoveredError(recovered, "handling a request")
+	response, _ := json.Marshal(g.errorResponse(r.Context(), nil, err, "INTERNAL_SERVER_ERROR"))
+
+	// if the handler already started the response, there's nothing we can do to fix it
+	emitResponse(w, http.StatusInternalServerError, string(response))
+}
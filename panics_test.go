@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+type panickingPlanner struct{}
+
+func (p panickingPlanner) Plan(ctx *PlanningContext) (QueryPlanList, error) {
+	var plan *QueryPlan
+	// a nil pointer dereference
+	return QueryPlanList{{RootStep: plan.RootStep}}, nil
+}
+
+// panicTestRequest sends the query to the gateway's handler and returns the status and body of the response
+func panicTestRequest(t *testing.T, gateway *Gateway, query string) (int, map[string]interface{}) {
+	body, _ := json.Marshal(map[string]interface{}{"query": query})
+	request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body)))
+	recorder := httptest.NewRecorder()
+
+	gateway.GraphQLHandler(recorder, request)
+
+	response := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response), recorder.Body.String())
+	return recorder.Code, response
+}
+
+// panicTestErrorCodes returns the code of every error in the response
+func panicTestErrorCodes(response map[string]interface{}) []string {
+	codes := []string{}
+	errs, _ := response["errors"].([]interface{})
+	for _, err := range errs {
+		extensions, _ := err.(map[string]interface{})["extensions"].(map[string]interface{})
+		code, _ := extensions["code"].(string)
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+func TestGateway_recoverPlannerPanic(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value: String
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithPlanner(panickingPlanner{}))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	status, response := panicTestRequest(t, gateway, "{ value }")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, []string{"INTERNAL_SERVER_ERROR"}, panicTestErrorCodes(response))
+
+	// the details of the panic stay in the logs
+	assert.Equal(t, panicMessage, response["errors"].([]interface{})[0].(map[string]interface{})["message"])
+}
+
+func TestGateway_recoverPlannerStepPanic(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value: String
+		}
+	`)
+
+	// the queryer factory is called while the steps of the plan are built
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		panic("could not build queryer")
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithQueryerFactory(&factory))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, err = gateway.GetPlans(&RequestContext{Context: context.Background(), Query: "{ value }"})
+	if !assert.NotNil(t, err) {
+		return
+	}
+	assert.Equal(t, "INTERNAL_SERVER_ERROR", err.(*graphql.Error).Extensions["code"])
+}
+
+func TestGateway_recoverStepPanic(t *testing.T) {
+	schema1, _ := graphql.LoadSchema(`
+		type Query {
+			value: String
+		}
+	`)
+	schema2, _ := graphql.LoadSchema(`
+		type Query {
+			broken: String
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema1, URL: "url1"}, {Schema: schema2, URL: "url2"}},
+		WithServiceQueryer("url1", graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{"value": "hello"}, nil
+		})),
+		WithServiceQueryer("url2", graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			var result map[string]interface{}
+			// assignment to a nil map
+			result["broken"] = "oops"
+			return result, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the step that panicked fails on its own
+	status, response := panicTestRequest(t, gateway, "{ value broken }")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"INTERNAL_SERVER_ERROR"}, panicTestErrorCodes(response))
+	assert.Equal(t, "hello", response["data"].(map[string]interface{})["value"])
+
+	// and the gateway is still around for the next request
+	status, response = panicTestRequest(t, gateway, "{ value }")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, response["errors"])
+}
+
+func TestGateway_recoverQueryFieldPanic(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	viewer := &QueryField{
+		Name: "viewer",
+		Type: ast.NamedType("User", &ast.Position{}),
+		Resolver: func(ctx context.Context, args map[string]interface{}) (string, error) {
+			panic("no viewer")
+		},
+	}
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithQueryFields(viewer))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the resolver's panic comes back as an error for the field
+	_, err = viewer.resolve(context.Background(), map[string]interface{}{})
+	assert.Equal(t, "INTERNAL_SERVER_ERROR", err.(*graphql.Error).Extensions["code"])
+
+	status, response := panicTestRequest(t, gateway, "{ viewer { id } }")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"INTERNAL_SERVER_ERROR"}, panicTestErrorCodes(response))
+}
@@ -0,0 +1,389 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// When partial results are enabled, the steps that depend on another step give up once the deadline of the
+// request has passed. The fields they would have resolved are set to null (following the usual rules for
+// non-null fields) and a TIMEOUT error is added for each of them. The steps at the root of the plan are
+// always waited for since there would be nothing to respond with otherwise.
+
+// WithPartialResults returns an Option that responds with whatever could be resolved before the deadline of
+// the request context instead of waiting for every step of the plan
+func WithPartialResults() Option {
+	return func(g *Gateway) {
+		g.partialResults = true
+	}
+}
+
+// partialResultsDeadline returns the point in time after which the steps of the request are abandoned.
+// A zero time means that every step is waited for.
+func (g *Gateway) partialResultsDeadline(ctx *RequestContext) time.Time {
+	deadline := time.Time{}
+
+	// the client can ask for a budget of their own
+	if ctx.PartialResultsTimeout > 0 {
+		deadline = time.Now().Add(ctx.PartialResultsTimeout)
+	}
+
+	// otherwise (or if it's sooner) use the deadline of the context
+	if g.partialResults && ctx.Context != nil {
+		if contextDeadline, ok := ctx.Context.Deadline(); ok && (deadline.IsZero() || contextDeadline.Before(deadline)) {
+			deadline = contextDeadline
+		}
+	}
+
+	return deadline
+}
+
+// executorPendingSteps keeps track of the steps that have been kicked off but haven't reported back
+// so that the ones still running at the deadline can be abandoned
+type executorPendingSteps struct {
+	mutex     sync.Mutex
+	steps     map[*executorPendingStep]bool
+	abandoned []*executorPendingStep
+	expired   bool
+	// the steps whose parent couldn't be found by the service
+	missing []*executorPendingStep
+	// the steps that a service turned down, along with the errors to report for them
+	denied []*executorDeniedStep
+	// the number of steps that have been started and the most that are allowed (zero means no limit)
+	executions    int
+	maxExecutions int
+	// the number of times each step has been started
+	stepExecutions map[*QueryPlanStep]int
+	// closed once the executor has stopped listening to the steps
+	done chan bool
+}
+
+// executorPendingStep is a single invocation of a step
+type executorPendingStep struct {
+	pending        *executorPendingSteps
+	step           *QueryPlanStep
+	insertionPoint InsertionPoint
+	// steps at the root of the plan are never abandoned
+	root bool
+	// looks up the parent along with the ones of the other invocations started by the same step, if the
+	// service allows it
+	batch *nodesBatch
+}
+
+func newExecutorPendingSteps(maxExecutions int, done chan bool) *executorPendingSteps {
+	return &executorPendingSteps{
+		steps:          map[*executorPendingStep]bool{},
+		maxExecutions:  maxExecutions,
+		stepExecutions: map[*QueryPlanStep]int{},
+		done:           done,
+	}
+}
+
+// start registers the steps at the root of the plan
+func (p *executorPendingSteps) start(steps []*QueryPlanStep, stepWg *sync.WaitGroup) []*executorPendingStep {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	entries := []*executorPendingStep{}
+	for _, step := range steps {
+		entry := &executorPendingStep{pending: p, step: step, insertionPoint: InsertionPoint{}, root: true}
+		p.steps[entry] = true
+		p.stepExecutions[step]++
+		entries = append(entries, entry)
+	}
+	stepWg.Add(len(entries))
+	p.executions += len(entries)
+
+	return entries
+}
+
+// reserve makes sure that the step can start the designated number of dependents without going over the
+// maximum number of executions
+func (e *executorPendingStep) reserve(dependents []*executorPendingStep) error {
+	p := e.pending
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.maxExecutions > 0 && p.executions+len(dependents) > p.maxExecutions {
+		err := graphql.NewError("QUERY_PLAN_TOO_LARGE", fmt.Sprintf("query executed more than %v steps", p.maxExecutions))
+		err.Path = e.insertionPoint.Path()
+		return graphql.ErrorList{err}
+	}
+	p.executions += len(dependents)
+	for _, dependent := range dependents {
+		p.stepExecutions[dependent.step]++
+	}
+
+	return nil
+}
+
+// counts returns the number of times each step has been started
+func (p *executorPendingSteps) counts() map[*QueryPlanStep]int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	counts := map[*QueryPlanStep]int{}
+	for step, executions := range p.stepExecutions {
+		counts[step] = executions
+	}
+
+	return counts
+}
+
+// expire abandons every step that is still running and is allowed to be
+func (p *executorPendingSteps) expire(stepWg *sync.WaitGroup) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.expired = true
+	for entry := range p.steps {
+		if !entry.root {
+			delete(p.steps, entry)
+			p.abandoned = append(p.abandoned, entry)
+			stepWg.Done()
+		}
+	}
+}
+
+// finish marks the step as done and registers the steps that depend on it. If the step was abandoned, false
+// is returned and the step should not report anything. The entries for the dependents that have to be
+// executed are returned alongside.
+func (e *executorPendingStep) finish(dependents []*executorPendingStep, stepWg *sync.WaitGroup) (bool, []*executorPendingStep) {
+	p := e.pending
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// if the step isn't pending anymore then it was abandoned
+	if !p.steps[e] {
+		return false, nil
+	}
+	delete(p.steps, e)
+
+	// a root step that finishes after the deadline doesn't get to start anything
+	if p.expired {
+		p.abandoned = append(p.abandoned, dependents...)
+		return true, nil
+	}
+
+	if len(dependents) > 0 {
+		for _, dependent := range dependents {
+			p.steps[dependent] = true
+		}
+		stepWg.Add(len(dependents))
+	}
+
+	return true, dependents
+}
+
+// fail reports the error unless the step was abandoned
+func (e *executorPendingStep) fail(errCh chan error, err error) {
+	if ok, _ := e.finish(nil, nil); ok {
+		select {
+		case errCh <- err:
+		case <-e.pending.done:
+		}
+	}
+}
+
+// send publishes the result of the step unless the executor has stopped listening
+func (e *executorPendingStep) send(resultCh chan *queryExecutionResult, result *queryExecutionResult) {
+	select {
+	case resultCh <- result:
+	case <-e.pending.done:
+	}
+}
+
+// executorWait waits for every step to finish or, if there is a deadline, until it passes and then for the
+// steps that can't be abandoned
+func executorWait(deadline time.Time, pending *executorPendingSteps, stepWg *sync.WaitGroup) {
+	done := make(chan bool)
+	go func() {
+		stepWg.Wait()
+		close(done)
+	}()
+
+	if deadline.IsZero() {
+		<-done
+		return
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		pending.expire(stepWg)
+		<-done
+	}
+}
+
+// executorAbandonStep adds the error for a step that didn't finish in time and nulls out the fields it would
+// have resolved. If the null reaches the top of the response, nil is returned.
+func executorAbandonStep(plan *QueryPlan, result map[string]interface{}, entry *executorPendingStep) (map[string]interface{}, *graphql.Error) {
+	// the path of the error follows the response
+	timeoutErr := graphql.NewError("TIMEOUT", "did not finish before the deadline")
+	timeoutErr.Path = entry.insertionPoint.Path()
+
+	result, _ = executorNullStep(plan, result, entry, false)
+	return result, timeoutErr
+}
+
+// executorNullStep nulls out the fields the step would have resolved (or the whole object it would have been
+// inserted into) and lets the null bubble up to the closest parent that can hold it. If the null reaches the
+// top of the response, nil is returned. The boolean is false if the null had to go past a non-null field.
+func executorNullStep(plan *QueryPlan, result map[string]interface{}, entry *executorPendingStep, wholeObject bool) (map[string]interface{}, bool) {
+	if result == nil {
+		return nil, false
+	}
+
+	// the definitions of the fields leading up to the step tell us how far a null can go
+	definitions := executorPathDefinitions(plan, entry.insertionPoint)
+
+	// find the object that the step was going to be inserted into along with everything above it
+	type level struct {
+		parent map[string]interface{}
+		field  string
+		// the lists we went through to get to the entry, one for each dimension
+		lists   [][]interface{}
+		indices []int
+	}
+	levels := []level{}
+	var target interface{} = result
+	for _, pointData := range entry.insertionPoint {
+		parent, ok := target.(map[string]interface{})
+		if !ok {
+			// something above the step has already been nulled
+			return result, true
+		}
+		current := level{parent: parent, field: pointData.Field, indices: pointData.Indices}
+
+		target = parent[pointData.Field]
+		for _, index := range pointData.Indices {
+			list, ok := target.([]interface{})
+			if !ok || index >= len(list) {
+				return result, true
+			}
+			current.lists = append(current.lists, list)
+			target = list[index]
+		}
+		levels = append(levels, current)
+	}
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		return result, true
+	}
+
+	// null out the fields the step was responsible for, unless the object itself is going away
+	bubble := wholeObject
+	if !wholeObject {
+		for _, field := range executorSelectedFields(entry.step.SelectionSet, entry.step.FragmentDefinitions) {
+			// the fields added by the gateway aren't part of the response
+			if strings.HasPrefix(field.Alias, "__gateway") || strings.HasPrefix(field.Name, "__") {
+				continue
+			}
+
+			object[field.Alias] = nil
+			if field.Definition != nil && field.Definition.Type.NonNull {
+				bubble = true
+			}
+		}
+	}
+
+	// a null in a non-null field nulls the closest parent that can be
+	clean := !bubble || wholeObject
+	for i := len(levels) - 1; bubble && i >= 0; i-- {
+		current := levels[i]
+		definition := definitions[i]
+
+		// if we are inside of a list, the entry (or one of the lists around it) might be able to hold the null
+		for dimension := len(current.indices) - 1; bubble && dimension >= 0; dimension-- {
+			var entryType *ast.Type
+			if definition != nil {
+				entryType = definition.Type
+				for i := 0; i <= dimension && entryType != nil; i++ {
+					entryType = entryType.Elem
+				}
+			}
+
+			if entryType == nil || !entryType.NonNull {
+				current.lists[dimension][current.indices[dimension]] = nil
+				bubble = false
+			} else {
+				clean = false
+			}
+		}
+		if !bubble {
+			continue
+		}
+
+		if definition == nil || !definition.Type.NonNull {
+			current.parent[current.field] = nil
+			bubble = false
+		} else {
+			clean = false
+		}
+	}
+
+	// if the null went all the way up, there is no data
+	if bubble {
+		return nil, false
+	}
+
+	return result, clean
+}
+
+// executorPathDefinitions returns the definition of each field along the insertion point by looking through
+// the original operation. An entry is nil if the field couldn't be found.
+func executorPathDefinitions(plan *QueryPlan, insertionPoint InsertionPoint) []*ast.FieldDefinition {
+	definitions := make([]*ast.FieldDefinition, len(insertionPoint))
+	if plan == nil || plan.Operation == nil {
+		return definitions
+	}
+
+	selectionSet := plan.Operation.SelectionSet
+	for i, pointData := range insertionPoint {
+		var found *ast.Field
+		for _, field := range executorSelectedFields(selectionSet, plan.FragmentDefinitions) {
+			if field.Alias == pointData.Field {
+				found = field
+				break
+			}
+		}
+		if found == nil {
+			return definitions
+		}
+
+		definitions[i] = found.Definition
+		selectionSet = found.SelectionSet
+	}
+
+	return definitions
+}
+
+// executorSelectedFields returns the fields in the selection set, including the ones inside of fragments.
+// Unlike graphql.ApplyFragments, the selection set is left alone so it's safe to call while the step is
+// still being executed.
+func executorSelectedFields(selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) []*ast.Field {
+	fields := []*ast.Field{}
+
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			fields = append(fields, selection)
+		case *ast.InlineFragment:
+			fields = append(fields, executorSelectedFields(selection.SelectionSet, fragments)...)
+		case *ast.FragmentSpread:
+			if definition := fragments.ForName(selection.Name); definition != nil {
+				fields = append(fields, executorSelectedFields(definition.SelectionSet, fragments)...)
+			}
+		}
+	}
+
+	return fields
+}
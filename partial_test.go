@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_partialResults(t *testing.T) {
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			user: User
+			users: [User]!
+		}
+	`)
+	profilesSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			bio: String
+			age: Int!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	// the profile service doesn't respond until the test is over
+	release := make(chan bool)
+	defer close(release)
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			if url == "profiles" {
+				<-release
+				return map[string]interface{}{
+					gatewayNodeAlias: map[string]interface{}{"bio": "hello", "age": 1},
+				}, nil
+			}
+
+			user := func(id string) map[string]interface{} {
+				return map[string]interface{}{"name": "User " + id, gatewayIDAlias: id}
+			}
+			return map[string]interface{}{
+				"user":  user("1"),
+				"users": []interface{}{user("1"), user("2")},
+			}, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: usersSchema, URL: "users"},
+		{Schema: profilesSchema, URL: "profiles"},
+	}, WithQueryerFactory(&factory), WithPartialResults())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	execute := func(ctx *RequestContext) (map[string]interface{}, error) {
+		plans, err := gateway.GetPlans(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return gateway.Execute(ctx, plans)
+	}
+
+	// a nullable field that didn't make it in time is null
+	result, err := execute(&RequestContext{
+		Context:               context.Background(),
+		Query:                 `{ user { name bio } }`,
+		PartialResultsTimeout: 20 * time.Millisecond,
+	})
+	errs, ok := err.(graphql.ErrorList)
+	if !assert.True(t, ok) || !assert.Len(t, errs, 1) {
+		return
+	}
+	assert.Equal(t, "TIMEOUT", errs[0].(*graphql.Error).Extensions["code"])
+	assert.Equal(t, []interface{}{"user"}, errs[0].(*graphql.Error).Path)
+
+	user := result["user"].(map[string]interface{})
+	assert.Equal(t, "User 1", user["name"])
+	assert.Contains(t, user, "bio")
+	assert.Nil(t, user["bio"])
+
+	// a non-null field takes the closest nullable parent with it
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	result, err = execute(&RequestContext{
+		Context: ctx,
+		Query:   `{ users { name age } }`,
+	})
+	errs, ok = err.(graphql.ErrorList)
+	if !assert.True(t, ok) || !assert.Len(t, errs, 2) {
+		return
+	}
+	paths := []interface{}{}
+	for _, err := range errs {
+		paths = append(paths, err.(*graphql.Error).Path)
+	}
+	assert.ElementsMatch(t, []interface{}{
+		[]interface{}{"users", 0},
+		[]interface{}{"users", 1},
+	}, paths)
+	assert.Equal(t, []interface{}{nil, nil}, result["users"])
+}
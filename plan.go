@@ -1,33 +1,66 @@
 package gateway
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
-	"github.com/vektah/gqlparser/v2"
 	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/formatter"
 
 	"github.com/nautilus/graphql"
 )
 
+// the selections and variables that the gateway adds to the queries it sends are namespaced so that they
+// can never collide with something in the user's query
+const (
+	// the alias of the node field used to look up the parent of a step
+	gatewayNodeAlias = "__gateway_node"
+	// the alias of the id field added to the objects that steps are inserted into, and the variable
+	// that holds the id when looking that object up
+	gatewayIDAlias = "__gateway_id"
+	// the variable that holds the representation of the parent of a step that targets a federated service
+	gatewayRepresentationsVariable = "__gateway_representations"
+)
+
 // QueryPlanStep represents a step in the plan required to fulfill a query.
 type QueryPlanStep struct {
 	// execution meta data
 	InsertionPoint []string
 	Then           []*QueryPlanStep
+	// every place the step is inserted when identical steps were merged into it. InsertionPoint is the first one.
+	InsertionPoints [][]string
 
 	// required info to generate the query
+	Location     string
 	Queryer      graphql.Queryer
 	ParentType   string
 	ParentID     string
 	SelectionSet ast.SelectionSet
+	// the key field used to look up the parent with _entities if the step targets a federated service
+	EntityKey string
+	// the number of lists between the root of the operation and the insertion point. A step under
+	// lists is executed once for every entry so its cost grows with the product of their lengths.
+	ExpansionRisk int
 
 	// pre-generated query stuff
 	QueryDocument       *ast.QueryDocument
 	QueryString         string
 	FragmentDefinitions ast.FragmentDefinitionList
 	Variables           Set
+	// the variables of the query that stand in for one of the client's, indexed by name. These are used when a
+	// variable has to be declared with a different type in each place the step uses it.
+	VariableSources map[string]string
+	// the query that looks up the parents of many invocations at once, if the service supports nodes(ids:)
+	BatchQueryDocument *ast.QueryDocument
+	BatchQueryString   string
+
+	// the names of the fragments the step was given when it was planned
+	inheritedFragments Set
 }
 
 // QueryPlan is the full plan to resolve a particular query
@@ -36,6 +69,12 @@ type QueryPlan struct {
 	RootStep            *QueryPlanStep
 	FragmentDefinitions ast.FragmentDefinitionList
 	FieldsToScrub       map[string][][]string
+	// the fields that identify the objects of each type that a step is inserted into
+	IDFields IDFieldMap
+	// the page sizes in the query that were lowered to their maximum, and the pagination arguments that
+	// are bound to variables and have to be checked when the plan is executed
+	PaginationWarnings  []*PaginationWarning
+	PaginationVariables []*PaginationVariable
 }
 
 type newQueryPlanStepPayload struct {
@@ -45,6 +84,7 @@ type newQueryPlanStepPayload struct {
 	ParentType     string
 	Parent         *QueryPlanStep
 	InsertionPoint []string
+	ListDepth      int
 	Fragments      ast.FragmentDefinitionList
 	Wrapper        ast.SelectionSet
 }
@@ -60,6 +100,11 @@ type PlannerWithQueryerFactory interface {
 	WithQueryerFactory(*QueryerFactory) QueryPlanner
 }
 
+// PlannerWithPlanLimits is an interface for planners that can limit the size of the plans they create
+type PlannerWithPlanLimits interface {
+	WithPlanLimits(maxDepth int, maxSteps int) QueryPlanner
+}
+
 // PlannerWithLocationFactory is an interface for planners with configurable location priorities
 type PlannerWithLocationPriorities interface {
 	WithLocationPriorities(priorities []string) QueryPlanner
@@ -78,6 +123,17 @@ type Planner struct {
 type MinQueriesPlanner struct {
 	Planner
 	LocationPriorities []string
+	// the services to try, in order, when a field can be resolved by more than one of them and none are
+	// already part of the plan
+	PreferredLocations []string
+	// the services that always resolve a field, indexed by coordinate
+	LocationOverrides LocationOverrides
+	// how much each service is trusted to resolve the fields it shares with others
+	LocationTiers LocationTiers
+	// the most steps that can depend on each other in a plan. zero means there is no limit
+	MaxDepth int
+	// the most steps that can be in a plan. zero means there is no limit
+	MaxSteps int
 }
 
 // WithQueryerFactory returns a version of the planner with the factory set
@@ -91,28 +147,84 @@ func (p *MinQueriesPlanner) WithLocationPriorities(priorities []string) QueryPla
 	return p
 }
 
+// WithPlanLimits returns a version of the planner that rejects plans that are too large
+func (p *MinQueriesPlanner) WithPlanLimits(maxDepth int, maxSteps int) QueryPlanner {
+	p.MaxDepth = maxDepth
+	p.MaxSteps = maxSteps
+	return p
+}
+
 // PlanningContext is the input struct to the Plan method
 type PlanningContext struct {
-	Query     string
-	Schema    *ast.Schema
-	Locations FieldURLMap
-	Gateway   *Gateway
+	Query      string
+	Schema     *ast.Schema
+	Locations  FieldURLMap
+	EntityKeys EntityKeyMap
+	Directives DirectiveMap
+	IDFields   IDFieldMap
+	Gateway    *Gateway
+	// the arguments that are copied between fields before the query is planned
+	ArgumentPropagations []ArgumentPropagation
+	// the services that can look up a list of objects with nodes(ids:)
+	BatchLookups Set
+	// the defaults and maximums of the pagination arguments
+	PaginationLimits PaginationLimits
+	// the freshness the client asked for
+	Freshness string
+	// the services that were left out when the gateway started
+	UnavailableServices []string
 }
 
 // Plan computes the nested selections that will need to be performed
 func (p *MinQueriesPlanner) Plan(ctx *PlanningContext) (QueryPlanList, error) {
 	// the first thing to do is to parse the query
-	parsedQuery, e := gqlparser.LoadQuery(ctx.Schema, ctx.Query)
+	parsedQuery, e := loadQuery(ctx.Schema, ctx.Query)
 	if e != nil {
 		return nil, e
 	}
 
+	// the fields that share arguments have to get the same ones, no matter which service they're sent to
+	plannerPropagateArguments(parsedQuery, ctx.ArgumentPropagations)
+
+	// the page sizes written in the query are fixed before the services see them
+	paginationLimiters, err := plannerApplyPaginationLimits(parsedQuery, ctx.PaginationLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	// and the fields that would be merged in the response still have to agree on them
+	if err := plannerCheckFieldConflicts(parsedQuery); err != nil {
+		return nil, err
+	}
+
+	// every field that no service can resolve is reported before we start
+	if errs := plannerMissingLocations(parsedQuery, ctx.Locations, ctx.UnavailableServices); len(errs) > 0 {
+		return nil, errs
+	}
+
 	// generate the plan
 	plans, err := p.generatePlans(ctx, parsedQuery)
 	if err != nil {
 		return nil, err
 	}
 
+	// the same fragment spread in a few places shouldn't send the same query from each of them
+	for _, plan := range plans {
+		plannerMergeIdenticalSteps(plan.RootStep)
+	}
+
+	// a query that goes back and forth between services can create a very large plan
+	if err := plannerCheckLimits(plans, p.MaxDepth, p.MaxSteps); err != nil {
+		return nil, err
+	}
+
+	for _, plan := range plans {
+		if limiter := paginationLimiters[plan.Operation]; limiter != nil {
+			plan.PaginationWarnings = limiter.warnings
+			plan.PaginationVariables = limiter.variables
+		}
+	}
+
 	flatSelection, err := graphql.ApplyFragments(parsedQuery.Operations[0].SelectionSet, parsedQuery.Fragments)
 	if err != nil {
 		return nil, err
@@ -137,6 +249,7 @@ func (p *MinQueriesPlanner) generatePlans(ctx *PlanningContext, query *ast.Query
 		plan := &QueryPlan{
 			Operation:           operation,
 			FragmentDefinitions: query.Fragments,
+			IDFields:            ctx.IDFields,
 		}
 
 		// add the plan to the top level list
@@ -153,13 +266,7 @@ func (p *MinQueriesPlanner) generatePlans(ctx *PlanningContext, query *ast.Query
 		stepWg := &sync.WaitGroup{}
 
 		// get the type for the operation
-		operationType := "Query"
-		switch operation.Operation {
-		case ast.Mutation:
-			operationType = "Mutation"
-		case ast.Subscription:
-			operationType = "Subscription"
-		}
+		operationType := rootTypeName(ctx.Schema, operation.Operation)
 
 		// we are garunteed at least one query
 		stepWg.Add(1)
@@ -178,6 +285,13 @@ func (p *MinQueriesPlanner) generatePlans(ctx *PlanningContext, query *ast.Query
 		// start waiting for steps to be added
 		// NOTE: i dont think this closure is necessary ¯\_(ツ)_/¯
 		go func(newSteps chan *newQueryPlanStepPayload) {
+			// a panic while building a step fails the plan instead of the process
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					errCh <- recoveredError(recovered, "planning a query")
+				}
+			}()
+
 		SelectLoop:
 			// continuously drain the step channel
 			for {
@@ -187,12 +301,19 @@ func (p *MinQueriesPlanner) generatePlans(ctx *PlanningContext, query *ast.Query
 						return
 					}
 					step := &QueryPlanStep{
+						Location:            payload.Location,
 						Queryer:             p.GetQueryer(ctx, payload.Location),
 						ParentType:          payload.ParentType,
+						EntityKey:           ctx.EntityKeys.KeyFor(payload.Location, payload.ParentType),
 						SelectionSet:        ast.SelectionSet{},
 						InsertionPoint:      payload.InsertionPoint,
+						ExpansionRisk:       payload.ListDepth,
 						Variables:           Set{},
 						FragmentDefinitions: payload.Fragments,
+						inheritedFragments:  Set{},
+					}
+					for _, fragment := range payload.Fragments {
+						step.inheritedFragments.Add(fragment.Name)
 					}
 
 					// if there is a parent to this query
@@ -223,11 +344,13 @@ func (p *MinQueriesPlanner) generatePlans(ctx *PlanningContext, query *ast.Query
 						stepCh:         stepCh,
 						stepWg:         stepWg,
 						locations:      ctx.Locations,
+						freshness:      ctx.Freshness,
 						parentLocation: payload.Location,
 						parentType:     step.ParentType,
 						selection:      payload.SelectionSet,
 						step:           step,
 						insertionPoint: payload.InsertionPoint,
+						listDepth:      payload.ListDepth,
 						plan:           payload.Plan,
 						wrapper:        payload.Wrapper,
 					})
@@ -246,19 +369,57 @@ func (p *MinQueriesPlanner) generatePlans(ctx *PlanningContext, query *ast.Query
 					// now that we're done processing the step we need to preconstruct the query that we
 					// will be firing for this plan
 
-					// we need to grab the list of variable definitions
+					// the selection set we built up can have redundant selections that would only make the
+					// query we send bigger. We also can only send the directives that the service understands
+					selectionSet := plannerMinimizeSelectionSet(step.ParentType, plannerFilterDirectives(ctx.Directives, step.Location, step.SelectionSet))
+					fragmentDefinitions := ast.FragmentDefinitionList{}
+					for _, defn := range step.FragmentDefinitions {
+						fragmentDefinitions = append(fragmentDefinitions, &ast.FragmentDefinition{
+							Name:          defn.Name,
+							TypeCondition: defn.TypeCondition,
+							Directives:    plannerFilterDirectiveList(ctx.Directives, step.Location, defn.Directives),
+							SelectionSet:  plannerMinimizeSelectionSet(defn.TypeCondition, plannerFilterDirectives(ctx.Directives, step.Location, defn.SelectionSet)),
+						})
+					}
+					operationDirectives := plannerFilterDirectiveList(ctx.Directives, step.Location, plan.Operation.Directives)
+
+					// a variable that ended up somewhere its type doesn't fit has to be split up
+					selectionSet, fragmentDefinitions, renames := plannerRenameConflictingVariables(ctx.Schema, step.ParentType, plan.Operation.VariableDefinitions, selectionSet, fragmentDefinitions)
+					if len(renames.sources) > 0 {
+						step.VariableSources = renames.sources
+					}
+
+					// the step depends on every variable used by the query we are going to send
+					step.Variables = plannerDocumentVariables(operationDirectives, selectionSet, fragmentDefinitions)
+
+					// the step declares the variables it uses in the order the operation does so the query
+					// (and its name) is the same every time
 					variableDefs := ast.VariableDefinitionList{}
-					// we need to grab the variable definitions and values for each variable in the step
-					for variable := range step.Variables {
-						// add the definition
-						variableDefs = append(variableDefs, plan.Operation.VariableDefinitions.ForName(variable))
+					for _, definition := range plan.Operation.VariableDefinitions {
+						if step.Variables.Has(definition.Variable) {
+							variableDefs = append(variableDefs, definition)
+						}
+						// the variables it was split into go where it was declared
+						for _, renamed := range renames.definitions {
+							if renames.sources[renamed.Variable] == definition.Variable && step.Variables.Has(renamed.Variable) {
+								variableDefs = append(variableDefs, renamed)
+							}
+						}
 					}
 
 					// build up the query document
-					step.QueryDocument = plannerBuildQuery(plan.Operation.Name, step.ParentType, variableDefs, step.SelectionSet, step.FragmentDefinitions)
+					step.QueryDocument = plannerBuildQuery(plan.Operation.Name, plannerStepOperation(plan, step), step.ParentType, step.EntityKey, variableDefs, selectionSet, fragmentDefinitions)
+					step.QueryDocument.Operations[0].Directives = operationDirectives
 
-					// we also need to turn the query into a string
-					queryString, err := graphql.PrintQuery(step.QueryDocument)
+					// we also need to turn the query into a string. the operation is named after what it does so
+					// the services can tell the queries apart in their logs
+					queryString, err := plannerPrintQuery(step.QueryDocument)
+					if err != nil {
+						errCh <- err
+						continue SelectLoop
+					}
+					step.QueryDocument.Operations[0].Name = plannerOperationName(plan.Operation.Name, queryString)
+					queryString, err = plannerPrintQuery(step.QueryDocument)
 					if err != nil {
 						errCh <- err
 						continue SelectLoop
@@ -266,10 +427,28 @@ func (p *MinQueriesPlanner) generatePlans(ctx *PlanningContext, query *ast.Query
 
 					step.QueryString = queryString
 
-					// we're done processing this step
-					stepWg.Done()
-
+					// the service might be able to look up the parents of every invocation at once
+					if !step.atOperationRoot() && step.EntityKey == "" && ctx.BatchLookups.Has(step.Location) {
+						step.BatchQueryDocument = plannerBuildBatchQuery(plan.Operation.Name, step.ParentType, variableDefs, selectionSet, fragmentDefinitions)
+						step.BatchQueryDocument.Operations[0].Directives = operationDirectives
+
+						batchQueryString, err := plannerPrintQuery(step.BatchQueryDocument)
+						if err != nil {
+							errCh <- err
+							continue SelectLoop
+						}
+						step.BatchQueryDocument.Operations[0].Name = plannerOperationName(plan.Operation.Name, batchQueryString)
+						step.BatchQueryString, err = plannerPrintQuery(step.BatchQueryDocument)
+						if err != nil {
+							errCh <- err
+							continue SelectLoop
+						}
+					}
 					log.Debug("")
+
+					// we're done processing this step. nothing can touch shared state after this since
+					// the plan could already be returned
+					stepWg.Done()
 				}
 			}
 		}(stepCh)
@@ -313,13 +492,16 @@ type extractSelectionConfig struct {
 	stepWg *sync.WaitGroup
 
 	locations      FieldURLMap
+	freshness      string
 	parentLocation string
 	parentType     string
 	step           *QueryPlanStep
 	plan           *QueryPlan
 	selection      ast.SelectionSet
 	insertionPoint []string
-	wrapper        ast.SelectionSet
+	// the number of lists above the insertion point
+	listDepth int
+	wrapper   ast.SelectionSet
 }
 
 func (p *MinQueriesPlanner) extractSelection(config *extractSelectionConfig) (ast.SelectionSet, error) {
@@ -327,6 +509,11 @@ func (p *MinQueriesPlanner) extractSelection(config *extractSelectionConfig) (as
 	log.Debug("--- Extracting Selection ---")
 	log.Debug("Parent location: ", config.parentLocation)
 
+	// the fields at the root of a mutation have to be resolved in the order they were asked for
+	if config.plan.Operation.Operation == ast.Mutation && config.parentLocation == "" {
+		return p.extractMutationSelection(config)
+	}
+
 	// in order to group together fields in as few queries as possible, we need to group
 	// the selection set by the location.
 	locationFields, locationFragments, err := p.groupSelectionSet(config)
@@ -376,6 +563,7 @@ func (p *MinQueriesPlanner) extractSelection(config *extractSelectionConfig) (as
 			Plan:           config.plan,
 			Parent:         config.step,
 			InsertionPoint: config.insertionPoint,
+			ListDepth:      config.listDepth,
 			Wrapper:        config.wrapper,
 			ParentType:     config.parentType,
 
@@ -387,8 +575,11 @@ func (p *MinQueriesPlanner) extractSelection(config *extractSelectionConfig) (as
 
 	// if we have to have an id field on this selection set
 	if checkForID {
-		// add the id field since duplicates are ignored
-		locationFields[config.parentLocation] = append(locationFields[config.parentLocation], &ast.Field{Name: "id"})
+		// add the id field under an alias that can't collide with anything the user asked for
+		locationFields[config.parentLocation] = append(locationFields[config.parentLocation], &ast.Field{
+			Name:  config.plan.IDFields.FieldFor(config.parentType),
+			Alias: gatewayIDAlias,
+		})
 	}
 
 	// now we have to generate a selection set for fields that are coming from the same location as the parent
@@ -432,12 +623,14 @@ func (p *MinQueriesPlanner) extractSelection(config *extractSelectionConfig) (as
 					stepWg:         config.stepWg,
 					step:           config.step,
 					locations:      config.locations,
+					freshness:      config.freshness,
 					parentLocation: config.parentLocation,
 					plan:           config.plan,
 
 					parentType:     coreFieldType(selection).Name(),
 					selection:      selection.SelectionSet,
 					insertionPoint: insertionPoint,
+					listDepth:      config.listDepth + plannerListDimensions(coreFieldType(selection)),
 					wrapper:        wrapper,
 				})
 				if err != nil {
@@ -452,13 +645,6 @@ func (p *MinQueriesPlanner) extractSelection(config *extractSelectionConfig) (as
 				log.Debug("found a scalar")
 			}
 			// the field is now safe to add to the parents selection set
-
-			// any variables that this field depends on need to be added to the steps list of variables
-			for _, variable := range graphql.ExtractVariables(selection.Arguments) {
-				config.step.Variables.Add(variable)
-			}
-
-			// add it to the list
 			finalSelection = append(finalSelection, selection)
 
 		case *ast.FragmentSpread:
@@ -469,11 +655,17 @@ func (p *MinQueriesPlanner) extractSelection(config *extractSelectionConfig) (as
 			// grab the official definition for the fragment.
 			// we could have overwritten the definition to fit the local needs of the top level
 			// ie if there is a branch off of one that happens mid-fragment.
-			defn := config.step.FragmentDefinitions.ForName(selection.Name)
-			addDefn := false
+			defn := plannerFragmentDefinition(config, selection.Name)
 			if defn == nil {
-				addDefn = true
-				defn = config.plan.FragmentDefinitions.ForName(selection.Name)
+				return nil, fmt.Errorf("could not find definition for fragment: %s", selection.Name)
+			}
+			addDefn := config.step.FragmentDefinitions.ForName(selection.Name) == nil
+
+			// the fields of the fragment that belong to other locations already have their own steps so
+			// we only have to walk the ones that stay with the parent
+			fragmentSelection := defn.SelectionSet
+			if local := locationFragments[config.parentLocation].ForName(selection.Name); local != nil {
+				fragmentSelection = local.SelectionSet
 			}
 
 			// compute the actual selection set for the fragment coming from this location
@@ -482,12 +674,14 @@ func (p *MinQueriesPlanner) extractSelection(config *extractSelectionConfig) (as
 				stepWg:         config.stepWg,
 				step:           config.step,
 				locations:      config.locations,
+				freshness:      config.freshness,
 				parentLocation: config.parentLocation,
 				insertionPoint: config.insertionPoint,
+				listDepth:      config.listDepth,
 				plan:           config.plan,
 
 				parentType: defn.TypeCondition,
-				selection:  defn.SelectionSet,
+				selection:  fragmentSelection,
 				// Children should now be wrapped by this fragment and nothing else
 				wrapper: ast.SelectionSet{selection},
 			})
@@ -523,9 +717,11 @@ func (p *MinQueriesPlanner) extractSelection(config *extractSelectionConfig) (as
 				stepWg:         config.stepWg,
 				step:           config.step,
 				locations:      config.locations,
+				freshness:      config.freshness,
 				parentLocation: config.parentLocation,
 				plan:           config.plan,
 				insertionPoint: config.insertionPoint,
+				listDepth:      config.listDepth,
 
 				parentType: selection.TypeCondition,
 				selection:  selection.SelectionSet,
@@ -610,33 +806,188 @@ func (p *MinQueriesPlanner) wrapSelectionSet(config *extractSelectionConfig, loc
 	return ast.SelectionSet{selection}, nil
 }
 
-// selects one location out of possibleLocations, prioritizing the parent's location and the internal schema
-func (p *MinQueriesPlanner) selectLocation(possibleLocations []string, config *extractSelectionConfig) string {
+// extractMutationSelection adds a step for every run of root mutation fields that are sent to the same location.
+// The steps are added in the order of the document so the executor can perform them one after the other.
+func (p *MinQueriesPlanner) extractMutationSelection(config *extractSelectionConfig) (ast.SelectionSet, error) {
+	type mutationRun struct {
+		location     string
+		selectionSet ast.SelectionSet
+		fragments    ast.FragmentDefinitionList
+	}
+	runs := []*mutationRun{}
+
+	for _, selection := range config.selection {
+		// group each selection on its own so the order of the fields doesn't get lost
+		selectionConfig := *config
+		selectionConfig.selection = ast.SelectionSet{selection}
+		locationFields, locationFragments, err := p.groupSelectionSet(&selectionConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		// a fragment can span more than one location
+		locations := []string{}
+		for location := range locationFields {
+			locations = append(locations, location)
+		}
+		sort.Strings(locations)
+
+		for _, location := range locations {
+			// fields next to each other in the same location can be sent together
+			if len(runs) == 0 || runs[len(runs)-1].location != location {
+				runs = append(runs, &mutationRun{location: location})
+			}
+			run := runs[len(runs)-1]
+
+			run.selectionSet = append(run.selectionSet, locationFields[location]...)
+			for _, fragment := range locationFragments[location] {
+				if run.fragments.ForName(fragment.Name) == nil {
+					run.fragments = append(run.fragments, fragment)
+				}
+			}
+		}
+	}
+
+	for _, run := range runs {
+		config.stepWg.Add(1)
+		config.stepCh <- &newQueryPlanStepPayload{
+			Plan:           config.plan,
+			Parent:         config.step,
+			InsertionPoint: config.insertionPoint,
+			ListDepth:      config.listDepth,
+			Wrapper:        config.wrapper,
+			ParentType:     config.parentType,
+
+			Location:     run.location,
+			SelectionSet: run.selectionSet,
+			Fragments:    run.fragments,
+		}
+	}
+
+	// the root of the plan doesn't have anything to send
+	return ast.SelectionSet{}, nil
+}
+
+// selects one location out of possibleLocations, prioritizing the parent's location, the locations that its
+// siblings already have to visit, and the internal schema. The location that is picked is added to the siblings'
+// locations so that the other fields that can be found in many places end up in the same step.
+func (p *MinQueriesPlanner) selectLocation(parentType string, field string, possibleLocations []string, config *extractSelectionConfig, siblingLocations Set) (string, error) {
+	// a client that wants fresh data can't get it from just anyone
+	if config.freshness == FreshnessStrong {
+		allowed, err := p.LocationTiers.strongLocations(parentType, field, possibleLocations)
+		if err != nil {
+			return "", err
+		}
+		possibleLocations = allowed
+	}
+
+	location := p.pickLocation(parentType, field, possibleLocations, config, siblingLocations)
+	siblingLocations.Add(location)
+	return location, nil
+}
+
+func (p *MinQueriesPlanner) pickLocation(parentType string, field string, possibleLocations []string, config *extractSelectionConfig, siblingLocations Set) string {
 	// if this field can only be found in one location
 	if len(possibleLocations) == 1 {
 		return possibleLocations[0]
-		// the field can be found in many locations
-	} else {
-		// locations to prioritize first
-		priorities := make([]string, len(p.LocationPriorities), len(p.LocationPriorities)+2)
-		copy(priorities, p.LocationPriorities)
-		priorities = append(priorities, config.parentLocation, internalSchemaLocation)
-
-		for _, priority := range priorities {
-			// look to see if the current location is one of the possible locations
-			for _, location := range possibleLocations {
-				// if the location is the same as the parent
-				if location == priority {
-					// assign this field to the parents entry
-					return priority
+	}
+
+	// a field that is pinned to a location doesn't leave much of a choice
+	if override, ok := p.LocationOverrides[parentType+"."+field]; ok && stringsContain(possibleLocations, override) {
+		return override
+	}
+
+	// the services that are trusted the most with the field are the only ones worth considering
+	possibleLocations = p.LocationTiers.lowest(parentType, field, possibleLocations)
+	if len(possibleLocations) == 1 {
+		return possibleLocations[0]
+	}
+
+	// locations to prioritize first
+	priorities := make([]string, len(p.LocationPriorities), len(p.LocationPriorities)+1)
+	copy(priorities, p.LocationPriorities)
+	priorities = append(priorities, config.parentLocation)
+
+	// if the fields around this one already need a step to one of the locations then we can get this field
+	// along with them instead of adding another step
+	for _, location := range possibleLocations {
+		if siblingLocations.Has(location) && location != internalSchemaLocation {
+			priorities = append(priorities, location)
+		}
+	}
+	priorities = append(priorities, internalSchemaLocation)
+	priorities = append(priorities, p.PreferredLocations...)
+
+	for _, priority := range priorities {
+		// look to see if the current location is one of the possible locations
+		for _, location := range possibleLocations {
+			if location == priority {
+				return priority
+			}
+		}
+	}
+
+	// if we got here then this field can be found in multiple services and none of the top priority locations.
+	// for now, just use the first one
+	return possibleLocations[0]
+}
+
+// plannerFieldLocations returns the locations that can resolve the field. Whoever resolves an object can
+// tell us its __typename so it doesn't need a location of its own (the gateway answers it at the root)
+func plannerFieldLocations(config *extractSelectionConfig, parentType string, field string) ([]string, error) {
+	locations, err := config.locations.URLFor(parentType, field)
+	if err != nil && field == "__typename" {
+		if config.parentLocation == "" {
+			return []string{internalSchemaLocation}, nil
+		}
+		return []string{config.parentLocation}, nil
+	}
+
+	return locations, err
+}
+
+// plannerRequiredLocations returns the locations that the selection set has to visit no matter how we
+// decide to group it, ie the locations of the fields that can only be found in one place
+func plannerRequiredLocations(config *extractSelectionConfig) Set {
+	required := Set{}
+
+	add := func(parentType string, selectionSet ast.SelectionSet) {
+		for _, selection := range selectionSet {
+			if field, ok := selection.(*ast.Field); ok {
+				if locations, err := config.locations.URLFor(parentType, field.Name); err == nil && len(locations) == 1 {
+					required.Add(locations[0])
 				}
 			}
 		}
+	}
 
-		// if we got here then this field can be found in multiple services and none of the top priority locations.
-		// for now, just use the first one
-		return possibleLocations[0]
+	add(config.parentType, config.selection)
+	for _, selection := range config.selection {
+		switch selection := selection.(type) {
+		case *ast.FragmentSpread:
+			if defn := plannerFragmentDefinition(config, selection.Name); defn != nil {
+				add(defn.TypeCondition, defn.SelectionSet)
+			}
+		case *ast.InlineFragment:
+			add(selection.TypeCondition, selection.SelectionSet)
+		}
 	}
+
+	return required
+}
+
+// plannerFragmentDefinition returns the definition of the fragment that the selection should be split up with.
+// A step that was given its own version of a fragment uses that one. The step's version of a fragment that
+// it added itself only holds the fields of the first place the fragment was spread so the other places have
+// to start from the operation's version.
+func plannerFragmentDefinition(config *extractSelectionConfig, name string) *ast.FragmentDefinition {
+	if config.step.inheritedFragments.Has(name) {
+		if defn := config.step.FragmentDefinitions.ForName(name); defn != nil {
+			return defn
+		}
+	}
+
+	return config.plan.FragmentDefinitions.ForName(name)
 }
 
 func (p *MinQueriesPlanner) groupSelectionSet(config *extractSelectionConfig) (map[string]ast.SelectionSet, map[string]ast.FragmentDefinitionList, error) {
@@ -644,6 +995,10 @@ func (p *MinQueriesPlanner) groupSelectionSet(config *extractSelectionConfig) (m
 	locationFields := map[string]ast.SelectionSet{}
 	locationFragments := map[string]ast.FragmentDefinitionList{}
 
+	// the locations that the selection set has to visit regardless of where we send the fields that can be
+	// found in more than one place
+	siblingLocations := plannerRequiredLocations(config)
+
 	// split each selection into groups of selection sets to be sent to a single service
 	for _, selection := range config.selection {
 		// each kind of selection contributes differently to the final selection set
@@ -662,12 +1017,15 @@ func (p *MinQueriesPlanner) groupSelectionSet(config *extractSelectionConfig) (m
 			}
 
 			// look up the location for this field
-			possibleLocations, err := config.locations.URLFor(config.parentType, selection.Name)
+			possibleLocations, err := plannerFieldLocations(config, config.parentType, selection.Name)
 			if err != nil {
 				return nil, nil, err
 			}
 
-			location := p.selectLocation(possibleLocations, config)
+			location, err := p.selectLocation(config.parentType, selection.Name, possibleLocations, config, siblingLocations)
+			if err != nil {
+				return nil, nil, err
+			}
 			locationFields[location] = append(locationFields[location], field)
 		case *ast.FragmentSpread:
 			log.Debug("Encountered fragment spread ", selection.Name)
@@ -675,16 +1033,10 @@ func (p *MinQueriesPlanner) groupSelectionSet(config *extractSelectionConfig) (m
 			// a fragments fields can span multiple services so a single fragment can result in many selections being added
 			fragmentLocations := map[string]ast.SelectionSet{}
 
-			// look up if we already have a definition for this fragment in the step
-			defn := config.step.FragmentDefinitions.ForName(selection.Name)
-
-			// if we don't have it
+			// look up the definition that the step was given, or the one in the operation
+			defn := plannerFragmentDefinition(config, selection.Name)
 			if defn == nil {
-				// look in the operation
-				defn = config.plan.FragmentDefinitions.ForName(selection.Name)
-				if defn == nil {
-					return nil, nil, fmt.Errorf("Could not find definition for directive: %s", selection.Name)
-				}
+				return nil, nil, fmt.Errorf("could not find definition for fragment: %s", selection.Name)
 			}
 
 			// each field in the fragment should be bundled with whats around it (still wrapped in fragment)
@@ -703,12 +1055,15 @@ func (p *MinQueriesPlanner) groupSelectionSet(config *extractSelectionConfig) (m
 					}
 
 					// look up the location of the field
-					fieldLocations, err := config.locations.URLFor(defn.TypeCondition, field.Name)
+					fieldLocations, err := plannerFieldLocations(config, defn.TypeCondition, field.Name)
 					if err != nil {
 						return nil, nil, err
 					}
 
-					fieldLocation := p.selectLocation(fieldLocations, config)
+					fieldLocation, err := p.selectLocation(defn.TypeCondition, field.Name, fieldLocations, config, siblingLocations)
+					if err != nil {
+						return nil, nil, err
+					}
 					fragmentLocations[fieldLocation] = append(fragmentLocations[fieldLocation], field)
 
 				case *ast.FragmentSpread, *ast.InlineFragment:
@@ -748,13 +1103,18 @@ func (p *MinQueriesPlanner) groupSelectionSet(config *extractSelectionConfig) (m
 				switch fragmentSelection := fragmentSelection.(type) {
 				case *ast.Field:
 					// look up the location of the field
-					fieldLocations, err := config.locations.URLFor(selection.TypeCondition, fragmentSelection.Name)
+					fieldLocations, err := plannerFieldLocations(config, selection.TypeCondition, fragmentSelection.Name)
 					if err != nil {
 						return nil, nil, err
 					}
 
-					// add the field to the location
-					fragmentLocations[fieldLocations[0]] = append(fragmentLocations[fieldLocations[0]], fragmentSelection)
+					// add the field to the location, preferring the parent's so we don't ask another service
+					// for something the parent is already fetching
+					fieldLocation, err := p.selectLocation(selection.TypeCondition, fragmentSelection.Name, fieldLocations, config, siblingLocations)
+					if err != nil {
+						return nil, nil, err
+					}
+					fragmentLocations[fieldLocation] = append(fragmentLocations[fieldLocation], fragmentSelection)
 
 				case *ast.FragmentSpread, *ast.InlineFragment:
 					// non-field selections will be handled in the next tick
@@ -784,7 +1144,7 @@ func (p *MinQueriesPlanner) groupSelectionSet(config *extractSelectionConfig) (m
 func (p *MinQueriesPlanner) generateScrubFields(plans QueryPlanList, requestSelection ast.SelectionSet) error {
 	for _, plan := range plans {
 		// the list of fields to scrub in this plan
-		fieldsToScrub := map[string][][]string{"id": {}}
+		fieldsToScrub := map[string][][]string{gatewayIDAlias: {}}
 
 		// add all of the plans for the next step along with those from this step
 		for _, nextStep := range plan.RootStep.Then {
@@ -809,46 +1169,37 @@ func (p *MinQueriesPlanner) generateScrubFieldsWalk(step *QueryPlanStep, selecti
 	// the acumulator of plans
 	acc := map[string][][]string{}
 
-	insertionPoint := step.InsertionPoint
-	targetSelection := selection
+	for _, insertionPoint := range step.insertionPoints() {
+		targetSelection := selection
 
-	// we need to look if this steps insertion point artificially asked for the id
-	for _, point := range insertionPoint {
-		foundField := false
+		// make sure that the insertion point of the step can be found in the selection
+		for _, point := range insertionPoint {
+			foundField := false
 
-		// look over the points in the selection
-		for _, field := range graphql.SelectedFields(targetSelection) {
-			// if the field name is what we expected
-			if field.Name == point || field.Alias == point {
-				// our next selection set is the fields selection set
-				targetSelection = field.SelectionSet
+			// look over the points in the selection
+			for _, field := range graphql.SelectedFields(targetSelection) {
+				// if the field name is what we expected
+				if field.Name == point || field.Alias == point {
+					// our next selection set is the fields selection set
+					targetSelection = field.SelectionSet
 
-				// we found the field for this point
-				foundField = true
-				break
+					// we found the field for this point
+					foundField = true
+					break
+				}
 			}
-		}
 
-		if !foundField {
-			return nil, fmt.Errorf("error adding scrub fields: could not find field for point %s", point)
+			if !foundField {
+				return nil, fmt.Errorf("error adding scrub fields: could not find field for point %s", point)
+			}
 		}
-	}
 
-	// look through the selection for a field named id
-	naturalID := false
-	for _, field := range graphql.SelectedFields(targetSelection) {
-		// if the field is for id
-		if field.Alias == "id" {
-			naturalID = true
+		// the id we added to find the parent is never something the user asked for
+		if len(insertionPoint) > 0 {
+			acc[gatewayIDAlias] = append(acc[gatewayIDAlias], insertionPoint)
 		}
 	}
 
-	// if the id was not natural and we were going to be inserted somewhere
-	if !naturalID && len(insertionPoint) > 0 {
-		// we have to add this insertion point to the list places to scrub
-		acc["id"] = append(acc["id"], insertionPoint)
-	}
-
 	// add all of the plans for the next step along with those from this step
 	for _, nextStep := range step.Then {
 		// compute the fields that our children have to add
@@ -865,6 +1216,97 @@ func (p *MinQueriesPlanner) generateScrubFieldsWalk(step *QueryPlanStep, selecti
 	return acc, nil
 }
 
+// plannerExtractVariables returns the name of every variable referenced by the arguments and directives
+// of a selection, including the ones nested in lists and input objects
+func plannerExtractVariables(arguments ast.ArgumentList, directives ast.DirectiveList) []string {
+	variables := graphql.ExtractVariables(arguments)
+
+	// directives like @include and @skip can also depend on variables
+	for _, directive := range directives {
+		variables = append(variables, graphql.ExtractVariables(directive.Arguments)...)
+	}
+
+	return variables
+}
+
+// plannerMinimizeSelectionSet returns a copy of the selection set without the redundant selections the planner
+// leaves behind: inline fragments that don't narrow the enclosing type are merged into their parent and a scalar
+// field is only asked for once per selection set.
+func plannerMinimizeSelectionSet(parentType string, selectionSet ast.SelectionSet) ast.SelectionSet {
+	minimized := ast.SelectionSet{}
+	leaves := Set{}
+
+	// add adds the selection to the minimized set unless it's a scalar we've already seen
+	add := func(selection ast.Selection) {
+		if field, ok := selection.(*ast.Field); ok && len(field.SelectionSet) == 0 && len(field.Arguments) == 0 && len(field.Directives) == 0 {
+			key := plannerLeafKey(field)
+			if leaves.Has(key) {
+				return
+			}
+			leaves.Add(key)
+		}
+
+		minimized = append(minimized, selection)
+	}
+
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			// fields with a selection set need to have their children minimized too
+			if len(selection.SelectionSet) > 0 {
+				field := *selection
+				field.SelectionSet = plannerMinimizeSelectionSet(coreFieldType(selection).Name(), selection.SelectionSet)
+				add(&field)
+				continue
+			}
+
+			add(selection)
+
+		case *ast.InlineFragment:
+			typeCondition := selection.TypeCondition
+			if typeCondition == "" {
+				typeCondition = parentType
+			}
+
+			// if the fragment doesn't do anything, its selections belong to the parent
+			if typeCondition == parentType && len(selection.Directives) == 0 {
+				for _, child := range plannerMinimizeSelectionSet(parentType, selection.SelectionSet) {
+					add(child)
+				}
+				continue
+			}
+
+			fragment := *selection
+			fragment.SelectionSet = plannerMinimizeSelectionSet(typeCondition, selection.SelectionSet)
+			add(&fragment)
+
+		default:
+			add(selection)
+		}
+	}
+
+	return minimized
+}
+
+// plannerLeafKey identifies the value a scalar field adds to the response
+func plannerLeafKey(field *ast.Field) string {
+	alias := field.Alias
+	if alias == "" {
+		alias = field.Name
+	}
+
+	return alias + ":" + field.Name
+}
+
+// plannerListDimensions returns the number of lists the type is wrapped in
+func plannerListDimensions(fieldType *ast.Type) int {
+	dimensions := 0
+	for ; fieldType != nil && fieldType.Elem != nil; fieldType = fieldType.Elem {
+		dimensions++
+	}
+	return dimensions
+}
+
 func coreFieldType(source *ast.Field) *ast.Type {
 	// if we are looking at a
 	return source.Definition.Type
@@ -897,44 +1339,95 @@ func (p *Planner) GetQueryer(ctx *PlanningContext, url string) graphql.Queryer {
 		return ctx.Gateway
 	}
 
+	// a mocked service never sees the request and some services aren't reached over HTTP
+	if ctx.Gateway != nil {
+		if queryer, ok := ctx.Gateway.mockedQueryer(url); ok {
+			return queryer
+		}
+		if queryer, ok := ctx.Gateway.serviceQueryer(url); ok {
+			return queryer
+		}
+	}
+
 	// if there is a queryer factory defined
 	if p.QueryerFactory != nil {
 		// use the factory
 		return (*p.QueryerFactory)(ctx, url)
 	}
 
-	// return the queryer for the url
-	return graphql.NewSingleRequestQueryer(url)
+	// return the queryer for the url. the client lets us see the extensions of the response
+	return graphql.NewSingleRequestQueryer(url).WithHTTPClient(extensionsClient)
 }
 
-func plannerBuildQuery(operationName, parentType string, variables ast.VariableDefinitionList, selectionSet ast.SelectionSet, fragmentDefinitions ast.FragmentDefinitionList) *ast.QueryDocument {
+// plannerStepOperation returns the operation of the plan if the step resolves fields of its root type. The other
+// steps look up their parent so they are always queries.
+func plannerStepOperation(plan *QueryPlan, step *QueryPlanStep) ast.Operation {
+	if !step.atOperationRoot() {
+		return ""
+	}
+	if plan.Operation.Operation == "" {
+		return ast.Query
+	}
+
+	return plan.Operation.Operation
+}
+
+// plannerBuildQuery builds the query that resolves the selection. The root operation is empty if the selection
+// doesn't belong to the root type, in which case the parent is looked up by its id (or entity key).
+func plannerBuildQuery(operationName string, root ast.Operation, parentType, entityKey string, variables ast.VariableDefinitionList, selectionSet ast.SelectionSet, fragmentDefinitions ast.FragmentDefinitionList) *ast.QueryDocument {
 	log.Debug("Building Query: \n"+"\tParentType: ", parentType, " ")
 	// build up an operation for the query
 	operation := &ast.OperationDefinition{
 		VariableDefinitions: variables,
 		Name:                operationName,
-	}
-
-	// assign the right operation
-	switch parentType {
-	case "Mutation":
-		operation.Operation = ast.Mutation
-	case "Subscription":
-		operation.Operation = ast.Subscription
-	default:
-		operation.Operation = ast.Query
+		Operation:           ast.Query,
 	}
 
 	// if we are querying an operation all we need to do is add the selection set at the root
-	if parentType == "Query" || parentType == "Mutation" || parentType == "Subscription" {
+	if root != "" {
+		operation.Operation = root
 		operation.SelectionSet = selectionSet
+	} else if entityKey != "" {
+		// federated services look up the parent with the _entities field
+		// {
+		//	 	_entities(representations: $__gateway_representations) {
+		//	 		... on parentType {
+		//	 			selection
+		//	 		}
+		//	 	}
+		// }
+		operation.SelectionSet = ast.SelectionSet{
+			&ast.Field{
+				Name: "_entities",
+				Arguments: ast.ArgumentList{
+					&ast.Argument{
+						Name: "representations",
+						Value: &ast.Value{
+							Kind: ast.Variable,
+							Raw:  gatewayRepresentationsVariable,
+						},
+					},
+				},
+				SelectionSet: ast.SelectionSet{
+					&ast.InlineFragment{
+						TypeCondition: parentType,
+						SelectionSet:  selectionSet,
+					},
+				},
+			},
+		}
+
+		operation.VariableDefinitions = append(operation.VariableDefinitions, &ast.VariableDefinition{
+			Variable: gatewayRepresentationsVariable,
+			Type:     ast.NonNullListType(ast.NonNullNamedType("_Any", &ast.Position{}), &ast.Position{}),
+		})
 	} else {
 		// if we are not querying the top level then we have to embed the selection set
 		// under the node query with the right id as the argument
 
 		// we want the operation to have the equivalent of
 		// {
-		//	 	node(id: $id) {
+		//	 	__gateway_node: node(id: $__gateway_id) {
 		//	 		... on parentType {
 		//	 			selection
 		//	 		}
@@ -942,13 +1435,14 @@ func plannerBuildQuery(operationName, parentType string, variables ast.VariableD
 		// }
 		operation.SelectionSet = ast.SelectionSet{
 			&ast.Field{
-				Name: "node",
+				Name:  "node",
+				Alias: gatewayNodeAlias,
 				Arguments: ast.ArgumentList{
 					&ast.Argument{
 						Name: "id",
 						Value: &ast.Value{
 							Kind: ast.Variable,
-							Raw:  "id",
+							Raw:  gatewayIDAlias,
 						},
 					},
 				},
@@ -961,13 +1455,11 @@ func plannerBuildQuery(operationName, parentType string, variables ast.VariableD
 			},
 		}
 
-		// if the original query didn't have an id arg we need to add one
-		if variables.ForName("id") == nil {
-			operation.VariableDefinitions = append(operation.VariableDefinitions, &ast.VariableDefinition{
-				Variable: "id",
-				Type:     ast.NonNullNamedType("ID", &ast.Position{}),
-			})
-		}
+		// the id of the parent is passed as a variable that the original query can't be using
+		operation.VariableDefinitions = append(operation.VariableDefinitions, &ast.VariableDefinition{
+			Variable: gatewayIDAlias,
+			Type:     ast.NonNullNamedType("ID", &ast.Position{}),
+		})
 	}
 
 	// add the operation to a QueryDocument
@@ -977,6 +1469,28 @@ func plannerBuildQuery(operationName, parentType string, variables ast.VariableD
 	}
 }
 
+// plannerPrintQuery turns the query document for a step into the string we send to the service. Every query is
+// printed the same way (arguments and fields in the order they were written) so that the services can compare them.
+func plannerPrintQuery(document *ast.QueryDocument) (string, error) {
+	var buf bytes.Buffer
+	formatter.NewFormatter(&buf).FormatQueryDocument(document)
+	return buf.String(), nil
+}
+
+// anonymousOperationName is the start of the name of the queries sent for an operation that the client didn't name
+const anonymousOperationName = "Gateway"
+
+// plannerOperationName returns the name of the operation sent for a step. The name starts with the name of
+// the client's operation and ends with a hash of the query so that the same step always gets the same name.
+func plannerOperationName(clientOperation string, query string) string {
+	if clientOperation == "" {
+		clientOperation = anonymousOperationName
+	}
+
+	hash := sha256.Sum256([]byte(query))
+	return clientOperation + "_" + hex.EncodeToString(hash[:])[:8]
+}
+
 // MockErrPlanner always returns the provided error. Useful in testing.
 type MockErrPlanner struct {
 	Err error
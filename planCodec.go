@@ -0,0 +1,247 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// Query plans can be written as JSON so that they can be built ahead of time (ie, at deploy time against a
+// snapshot of the schema) and loaded with RegisterPlan when the gateway starts. The queries are written as
+// GraphQL documents and parsed again when the plan is read. The steps only record the url of their service
+// since a queryer can't be written down - RegisterPlan asks the planner for the queryer of each step, just
+// like it would have when the plan was built.
+
+// queryPlanJSON is the shape of a plan when it's written as JSON
+type queryPlanJSON struct {
+	// the operation along with the fragments it uses
+	Operation           string                `json:"operation"`
+	RootStep            *QueryPlanStep        `json:"rootStep"`
+	FieldsToScrub       map[string][][]string `json:"fieldsToScrub,omitempty"`
+	IDFields            IDFieldMap            `json:"idFields,omitempty"`
+	PaginationWarnings  []*PaginationWarning  `json:"paginationWarnings,omitempty"`
+	PaginationVariables []*PaginationVariable `json:"paginationVariables,omitempty"`
+}
+
+// queryPlanStepJSON is the shape of a step when it's written as JSON
+type queryPlanStepJSON struct {
+	Location        string     `json:"location"`
+	ParentType      string     `json:"parentType"`
+	ParentID        string     `json:"parentID,omitempty"`
+	EntityKey       string     `json:"entityKey,omitempty"`
+	InsertionPoint  []string   `json:"insertionPoint"`
+	InsertionPoints [][]string `json:"insertionPoints,omitempty"`
+	ExpansionRisk   int        `json:"expansionRisk,omitempty"`
+	// the selection set of the step along with the fragments it uses
+	SelectionSet    string            `json:"selectionSet,omitempty"`
+	Query           string            `json:"query,omitempty"`
+	BatchQuery      string            `json:"batchQuery,omitempty"`
+	Variables       []string          `json:"variables,omitempty"`
+	VariableSources map[string]string `json:"variableSources,omitempty"`
+	Then            []*QueryPlanStep  `json:"then,omitempty"`
+}
+
+// MarshalJSON writes the plan in a form that UnmarshalJSON can read
+func (p *QueryPlan) MarshalJSON() ([]byte, error) {
+	if p.Operation == nil {
+		return nil, errors.New("cannot write a plan without an operation")
+	}
+
+	// the planner applies the fragments of the operation so some of them might not be used anymore
+	fragments := planCodecUsedFragments(p.Operation.SelectionSet, p.FragmentDefinitions)
+	operation, err := planCodecPrint(p.Operation.Operation, p.Operation.SelectionSet, fragments, p.Operation)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&queryPlanJSON{
+		Operation:           operation,
+		RootStep:            p.RootStep,
+		FieldsToScrub:       p.FieldsToScrub,
+		IDFields:            p.IDFields,
+		PaginationWarnings:  p.PaginationWarnings,
+		PaginationVariables: p.PaginationVariables,
+	})
+}
+
+// UnmarshalJSON reads a plan written by MarshalJSON. The plan can't be executed until it's registered with
+// a gateway since that's where the steps find their queryers.
+func (p *QueryPlan) UnmarshalJSON(data []byte) error {
+	payload := &queryPlanJSON{}
+	if err := json.Unmarshal(data, payload); err != nil {
+		return err
+	}
+
+	document, err := planCodecParse(payload.Operation)
+	if err != nil {
+		return err
+	}
+	if document == nil || len(document.Operations) != 1 {
+		return errors.New("a plan must have exactly one operation")
+	}
+	if payload.RootStep == nil {
+		return errors.New("a plan must have a root step")
+	}
+
+	*p = QueryPlan{
+		Operation:           document.Operations[0],
+		RootStep:            payload.RootStep,
+		FragmentDefinitions: document.Fragments,
+		FieldsToScrub:       payload.FieldsToScrub,
+		IDFields:            payload.IDFields,
+		PaginationWarnings:  payload.PaginationWarnings,
+		PaginationVariables: payload.PaginationVariables,
+	}
+
+	return nil
+}
+
+// MarshalJSON writes the step and the steps that depend on it
+func (s *QueryPlanStep) MarshalJSON() ([]byte, error) {
+	selectionSet, err := planCodecPrint(ast.Query, s.SelectionSet, s.FragmentDefinitions, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&queryPlanStepJSON{
+		Location:        s.Location,
+		ParentType:      s.ParentType,
+		ParentID:        s.ParentID,
+		EntityKey:       s.EntityKey,
+		InsertionPoint:  s.InsertionPoint,
+		InsertionPoints: s.InsertionPoints,
+		ExpansionRisk:   s.ExpansionRisk,
+		SelectionSet:    selectionSet,
+		Query:           s.QueryString,
+		BatchQuery:      s.BatchQueryString,
+		Variables:       sortedSet(s.Variables),
+		VariableSources: s.VariableSources,
+		Then:            s.Then,
+	})
+}
+
+// UnmarshalJSON reads a step written by MarshalJSON
+func (s *QueryPlanStep) UnmarshalJSON(data []byte) error {
+	payload := &queryPlanStepJSON{}
+	if err := json.Unmarshal(data, payload); err != nil {
+		return err
+	}
+
+	*s = QueryPlanStep{
+		Location:           payload.Location,
+		ParentType:         payload.ParentType,
+		ParentID:           payload.ParentID,
+		EntityKey:          payload.EntityKey,
+		InsertionPoint:     payload.InsertionPoint,
+		InsertionPoints:    payload.InsertionPoints,
+		ExpansionRisk:      payload.ExpansionRisk,
+		SelectionSet:       ast.SelectionSet{},
+		QueryString:        payload.Query,
+		BatchQueryString:   payload.BatchQuery,
+		Variables:          Set{},
+		VariableSources:    payload.VariableSources,
+		Then:               payload.Then,
+		inheritedFragments: Set{},
+	}
+	if s.InsertionPoint == nil {
+		s.InsertionPoint = []string{}
+	}
+	for _, variable := range payload.Variables {
+		s.Variables.Add(variable)
+	}
+
+	// the selection set and its fragments
+	selection, err := planCodecParse(payload.SelectionSet)
+	if err != nil {
+		return err
+	}
+	if selection != nil {
+		if len(selection.Operations) > 0 {
+			s.SelectionSet = selection.Operations[0].SelectionSet
+		}
+		s.FragmentDefinitions = selection.Fragments
+	}
+
+	// and the queries that are sent to the service
+	if s.QueryDocument, err = planCodecParse(payload.Query); err != nil {
+		return err
+	}
+	if s.BatchQueryDocument, err = planCodecParse(payload.BatchQuery); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// planCodecPrint writes the selection set and fragments as a document. operation is used for the name,
+// variables and directives of the document if it's there.
+func planCodecPrint(kind ast.Operation, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList, operation *ast.OperationDefinition) (string, error) {
+	// there's no way to write down an empty selection set (and nothing could use the fragments)
+	if len(selectionSet) == 0 {
+		return "", nil
+	}
+
+	definition := &ast.OperationDefinition{Operation: kind, SelectionSet: selectionSet}
+	if operation != nil {
+		definition.Name = operation.Name
+		definition.VariableDefinitions = operation.VariableDefinitions
+		definition.Directives = operation.Directives
+	}
+
+	return plannerPrintQuery(&ast.QueryDocument{
+		Operations: ast.OperationList{definition},
+		Fragments:  fragments,
+	})
+}
+
+// planCodecUsedFragments returns the fragments that are spread in the selection set (or in one of the
+// fragments it spreads) in the order they were defined
+func planCodecUsedFragments(selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) ast.FragmentDefinitionList {
+	used := Set{}
+
+	var visit func(selectionSet ast.SelectionSet)
+	visit = func(selectionSet ast.SelectionSet) {
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				visit(selection.SelectionSet)
+			case *ast.InlineFragment:
+				visit(selection.SelectionSet)
+			case *ast.FragmentSpread:
+				if used.Has(selection.Name) {
+					continue
+				}
+				used.Add(selection.Name)
+				if definition := fragments.ForName(selection.Name); definition != nil {
+					visit(definition.SelectionSet)
+				}
+			}
+		}
+	}
+	visit(selectionSet)
+
+	result := ast.FragmentDefinitionList{}
+	for _, definition := range fragments {
+		if used.Has(definition.Name) {
+			result = append(result, definition)
+		}
+	}
+
+	return result
+}
+
+// planCodecParse reads a document written by planCodecPrint
+func planCodecParse(source string) (*ast.QueryDocument, error) {
+	if source == "" {
+		return nil, nil
+	}
+
+	document, err := parser.ParseQuery(&ast.Source{Input: source})
+	if err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
@@ -0,0 +1,156 @@
+package gateway_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/gateway"
+	"github.com/nautilus/gateway/gatewaytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryPlan_json(t *testing.T) {
+	users := map[string]map[string]interface{}{
+		"1": {"id": "1", "firstName": "hello"},
+		"2": {"id": "2", "firstName": "goodbye"},
+	}
+	photos := map[string][]interface{}{
+		"1": {map[string]interface{}{"url": "cat-1.png"}},
+		"2": {map[string]interface{}{"url": "cat-2.png"}, map[string]interface{}{"url": "cat-3.png"}},
+	}
+
+	harness, err := gatewaytest.NewHarness([]gatewaytest.Service{
+		{
+			Name: "users",
+			Schema: `
+				interface Node {
+					id: ID!
+				}
+
+				type User implements Node {
+					id: ID!
+					firstName: String!
+				}
+
+				type Query {
+					allUsers: [User!]!
+					node(id: ID!): Node
+				}
+			`,
+			Resolvers: gatewaytest.Resolvers{
+				"Query.allUsers": func(args map[string]interface{}) interface{} {
+					return []interface{}{users["1"], users["2"]}
+				},
+				"User": func(args map[string]interface{}) interface{} {
+					return users[args["id"].(string)]
+				},
+			},
+		},
+		{
+			Name: "cats",
+			Schema: `
+				interface Node {
+					id: ID!
+				}
+
+				type CatPhoto {
+					url: String!
+				}
+
+				type User implements Node {
+					id: ID!
+					catPhotos(first: Int): [CatPhoto!]!
+				}
+
+				type Query {
+					node(id: ID!): Node
+				}
+			`,
+			Resolvers: gatewaytest.Resolvers{
+				"User": func(args map[string]interface{}) interface{} {
+					return map[string]interface{}{"catPhotos": photos[args["id"].(string)]}
+				},
+			},
+		},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer harness.Close()
+
+	query := `
+		query AllUsers($first: Int) {
+			allUsers {
+				...UserInfo
+				catPhotos(first: $first) { url }
+			}
+		}
+
+		fragment UserInfo on User {
+			firstName
+		}
+	`
+
+	// build the plans like we would at deploy time
+	plans, err := harness.Gateway.GetPlans(&gateway.RequestContext{Context: context.Background(), Query: query})
+	if !assert.Nil(t, err) {
+		return
+	}
+	encoded, err := json.Marshal(plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// and read them back when the gateway starts
+	loaded := gateway.QueryPlanList{}
+	if !assert.Nil(t, json.Unmarshal(encoded, &loaded)) {
+		return
+	}
+	reencoded, err := json.Marshal(loaded)
+	if assert.Nil(t, err) {
+		assert.JSONEq(t, string(encoded), string(reencoded))
+	}
+	if !assert.Nil(t, harness.Gateway.RegisterPlan("AllUsers", loaded)) {
+		return
+	}
+
+	// send sends the body to the gateway and returns the response as it was written
+	send := func(body string) string {
+		request := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		harness.Gateway.GraphQLHandler(response, request)
+		return response.Body.String()
+	}
+
+	// the registered plan gives the same response as the query it was built from
+	planned, err := json.Marshal(map[string]interface{}{"query": query, "variables": map[string]interface{}{"first": 1}})
+	if !assert.Nil(t, err) {
+		return
+	}
+	fresh := send(string(planned))
+	assert.Contains(t, fresh, "cat-3.png")
+	assert.Equal(t, fresh, send(`{"variables": {"first": 1}, "extensions": {"operationId": "AllUsers"}}`))
+
+	// the plans can also be handed straight to Execute
+	ctx := &gateway.RequestContext{Context: context.Background(), OperationID: "AllUsers"}
+	result, err := harness.Gateway.Execute(ctx, nil)
+	if assert.Nil(t, err) {
+		assert.Len(t, result["allUsers"], 2)
+	}
+
+	// an operation id that wasn't registered can't be executed
+	_, err = harness.Gateway.GetPlans(&gateway.RequestContext{Context: context.Background(), OperationID: "Unknown"})
+	assert.Equal(t, gateway.ErrUnknownOperationID, err)
+
+	// and a plan that doesn't fit the schema is turned down
+	stale := gateway.QueryPlanList{}
+	if !assert.Nil(t, json.Unmarshal([]byte(strings.Replace(string(encoded), "firstName", "lastName", -1)), &stale)) {
+		return
+	}
+	assert.NotNil(t, harness.Gateway.RegisterPlan("Stale", stale))
+}
@@ -1,12 +1,20 @@
 package gateway
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/nautilus/graphql"
 	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2"
 	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
 )
 
 func TestPlanQuery_singleRootField(t *testing.T) {
@@ -903,7 +911,7 @@ func TestPlanQuery_scrubFields(t *testing.T) {
 		// each transition between step requires an id field. None of them were requested so we should have two
 		// places where we want to scrub it
 		assert.Equal(t, map[string][][]string{
-			"id": {
+			gatewayIDAlias: {
 				{"allUsers"},
 				{"allUsers", "catPhotos"},
 			},
@@ -930,7 +938,7 @@ func TestPlanQuery_scrubFields(t *testing.T) {
 		// each transition between step requires an id field. None of them were requested so we should have two
 		// places where we want to scrub it
 		assert.Equal(t, map[string][][]string{
-			"id": {},
+			gatewayIDAlias: {},
 		}, plans[0].FieldsToScrub)
 	})
 
@@ -956,10 +964,11 @@ func TestPlanQuery_scrubFields(t *testing.T) {
 			return
 		}
 
-		// each transition between step requires an id field. None of them were requested so we should have two
-		// places where we want to scrub it
+		// the id the user asked for is a separate field from the one the gateway adds so we still have to
+		// scrub it from both places
 		assert.Equal(t, map[string][][]string{
-			"id": {
+			gatewayIDAlias: {
+				{"allUsers"},
 				{"allUsers", "catPhotos"},
 			},
 		}, plans[0].FieldsToScrub)
@@ -1232,14 +1241,14 @@ func TestPlanQuery_stepVariables(t *testing.T) {
 		t.Error("Could not find query document")
 		return
 	}
-	// we need to have a query with id and category since id is passed to node
-	if len(nextStep.QueryDocument.Operations[0].VariableDefinitions) != 2 {
-		t.Errorf("Did not find the right number of variable definitions in the next step. Expected 2 found %v", len(nextStep.QueryDocument.Operations[0].VariableDefinitions))
+	// we need to have a query with id and category along with the variable the gateway passes to node
+	if len(nextStep.QueryDocument.Operations[0].VariableDefinitions) != 3 {
+		t.Errorf("Did not find the right number of variable definitions in the next step. Expected 3 found %v", len(nextStep.QueryDocument.Operations[0].VariableDefinitions))
 		return
 	}
 
 	for _, definition := range nextStep.QueryDocument.Operations[0].VariableDefinitions {
-		if definition.Variable != "id" && definition.Variable != "category" {
+		if definition.Variable != "id" && definition.Variable != "category" && definition.Variable != gatewayIDAlias {
 			t.Errorf("Encountered a variable with an unknown name: %v", definition.Variable)
 			return
 		}
@@ -1458,7 +1467,7 @@ func TestPlannerBuildQuery_query(t *testing.T) {
 	}
 
 	// the query we're building goes to the top level Query object
-	operation := plannerBuildQuery("hoopla", "Query", variables, selection, ast.FragmentDefinitionList{})
+	operation := plannerBuildQuery("hoopla", ast.Query, "Query", "", variables, selection, ast.FragmentDefinitionList{})
 	if operation == nil {
 		t.Error("Did not receive a query.")
 		return
@@ -1476,7 +1485,7 @@ func TestPlannerBuildQuery_query(t *testing.T) {
 func TestPlannerBuildQuery_node(t *testing.T) {
 	// if we are querying a specific type/id then we need to perform a query similar to
 	// {
-	// 		node(id: $id) {
+	// 		__gateway_node: node(id: $__gateway_id) {
 	// 			... on User {
 	// 				firstName
 	// 			}
@@ -1497,7 +1506,7 @@ func TestPlannerBuildQuery_node(t *testing.T) {
 	}
 
 	// the query we're building goes to the User object
-	operation := plannerBuildQuery("", objType, ast.VariableDefinitionList{}, selection, ast.FragmentDefinitionList{})
+	operation := plannerBuildQuery("", "", objType, "", ast.VariableDefinitionList{}, selection, ast.FragmentDefinitionList{})
 	if operation == nil {
 		t.Error("Did not receive a query.")
 		return
@@ -1525,6 +1534,10 @@ func TestPlannerBuildQuery_node(t *testing.T) {
 		t.Error("Did not ask for node at the top")
 		return
 	}
+	if node.Alias != gatewayNodeAlias {
+		t.Error("Did not alias the node field")
+		return
+	}
 	// there should be one argument (id)
 	if len(node.Arguments) != 1 {
 		t.Error("Found the wrong number of arguments for the node field")
@@ -1535,7 +1548,7 @@ func TestPlannerBuildQuery_node(t *testing.T) {
 		t.Error("Did not pass id to the node field")
 		return
 	}
-	if argument.Value.Raw != "id" {
+	if argument.Value.Raw != gatewayIDAlias {
 		t.Error("Did not pass the right id value to the node field")
 		return
 	}
@@ -1566,7 +1579,55 @@ func TestPlannerBuildQuery_node(t *testing.T) {
 }
 
 func TestPlanQuery_mutationsInSeries(t *testing.T) {
-	t.Skip("Not implemented")
+	// the mutations are split between 2 services
+	locations := FieldURLMap{}
+	locations.RegisterURL("Query", "foo", "url1")
+	locations.RegisterURL("Mutation", "createUser", "url1")
+	locations.RegisterURL("Mutation", "addFriend", "url1")
+	locations.RegisterURL("Mutation", "uploadPhoto", "url2")
+	locations.RegisterURL("Mutation", "tagPhoto", "url1")
+
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			foo: Boolean
+		}
+
+		type Mutation {
+			createUser: Boolean
+			addFriend: Boolean
+			uploadPhoto: Boolean
+			tagPhoto: Boolean
+		}
+	`)
+
+	plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+		Query:     "mutation { createUser addFriend uploadPhoto tagPhoto }",
+		Schema:    schema,
+		Locations: locations,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the fields next to each other in the same service are sent together and every group of them is its own
+	// step, in the order of the document
+	type step struct {
+		URL    string
+		Fields []string
+	}
+	steps := []step{}
+	for _, root := range plans[0].RootStep.Then {
+		fields := []string{}
+		for _, field := range graphql.SelectedFields(root.SelectionSet) {
+			fields = append(fields, field.Name)
+		}
+		steps = append(steps, step{URL: root.Queryer.(*graphql.SingleRequestQueryer).URL(), Fields: fields})
+	}
+	assert.Equal(t, []step{
+		{URL: "url1", Fields: []string{"createUser", "addFriend"}},
+		{URL: "url2", Fields: []string{"uploadPhoto"}},
+		{URL: "url1", Fields: []string{"tagPhoto"}},
+	}, steps)
 }
 
 func TestPlanQuery_forcedPriorityResolution(t *testing.T) {
@@ -1753,3 +1814,795 @@ func TestPlanQuery_scrubWithAlias(t *testing.T) {
 	assert.Equal(t, "allUsers", firstField.Name)
 	assert.Equal(t, "users", firstField.Alias)
 }
+
+func TestPlanQuery_boundaryFieldArguments(t *testing.T) {
+	// the location map for fields for this query
+	locations := FieldURLMap{}
+	locations.RegisterURL("Query", "user", "url1")
+	locations.RegisterURL("User", "id", "url1")
+	locations.RegisterURL("User", "orders", "url2")
+	locations.RegisterURL("Order", "id", "url2")
+
+	schema, _ := graphql.LoadSchema(`
+		enum OrderStatus {
+			SHIPPED
+			PENDING
+		}
+
+		input OrderFilter {
+			status: OrderStatus
+			tags: [String!]
+			since: String
+		}
+
+		type Order {
+			id: ID!
+		}
+
+		type User {
+			id: ID!
+			orders(first: Int, price: Float, paid: Boolean, status: OrderStatus, statuses: [OrderStatus!], filter: OrderFilter, note: String): [Order!]!
+		}
+
+		type Query {
+			user: User
+		}
+	`)
+
+	table := []struct {
+		name        string
+		definitions string
+		arguments   string
+		variables   Set
+		// the arguments as they are printed, if that's different from how they were written
+		printed string
+	}{
+		{"int", "", `first: 10`, Set{}, ""},
+		{"float", "", `price: 1.5`, Set{}, ""},
+		{"boolean", "", `paid: true`, Set{}, ""},
+		{"string", "", `note: "hello"`, Set{}, ""},
+		{"null", "", `note: null`, Set{}, ""},
+		{"enum", "", `status: SHIPPED`, Set{}, ""},
+		{"list of enums", "", `statuses: [SHIPPED, PENDING]`, Set{}, `statuses: [SHIPPED,PENDING]`},
+		{"input object", "", `filter: {status: SHIPPED, tags: ["a", "b"]}`, Set{}, `filter: {status:SHIPPED,tags:["a","b"]}`},
+		{"variable", "($first: Int)", `first: $first`, Set{"first": true}, ""},
+		{"variable in list", "($status: OrderStatus!)", `statuses: [SHIPPED, $status]`, Set{"status": true}, `statuses: [SHIPPED,$status]`},
+		{"variable in input object", "($since: String)", `filter: {tags: ["a"], since: $since}`, Set{"since": true}, `filter: {tags:["a"],since:$since}`},
+		{"variable in directive", "($include: Boolean!)", `first: 10) @include(if: $include`, Set{"include": true}, ""},
+	}
+
+	for _, row := range table {
+		t.Run(row.name, func(t *testing.T) {
+			plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+				Query: fmt.Sprintf(`
+					query %s {
+						user {
+							orders(%s) {
+								id
+							}
+						}
+					}
+				`, row.definitions, row.arguments),
+				Schema:    schema,
+				Locations: locations,
+			})
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			// the dependent step should ask for the field with every argument
+			dependent := plans[0].RootStep.Then[0].Then[0]
+			assert.Equal(t, row.variables, dependent.Variables)
+
+			// the printed query should have the arguments exactly as they were given
+			printed := row.printed
+			if printed == "" {
+				printed = row.arguments
+			}
+			expected := fmt.Sprintf("orders(%s)", printed)
+			assert.Contains(t, dependent.QueryString, expected)
+
+			// the variables should be defined by the query
+			for variable := range row.variables {
+				assert.NotNil(t, dependent.QueryDocument.Operations[0].VariableDefinitions.ForName(variable))
+			}
+		})
+	}
+}
+
+// run the tests with -update to regenerate the golden files after an intentional change to the planner
+var updateGolden = flag.Bool("update", false, "update the golden files of rendered queries")
+
+// plannerGoldenLocations builds the schema and locations shared by the tests of the rendered queries
+func plannerGoldenLocations() (*ast.Schema, FieldURLMap) {
+	locations := FieldURLMap{}
+	locations.RegisterURL("Query", "user", "url1")
+	locations.RegisterURL("User", "id", "url1", "url2")
+	locations.RegisterURL("User", "name", "url1")
+	locations.RegisterURL("User", "friends", "url1")
+	locations.RegisterURL("User", "photos", "url2")
+	locations.RegisterURL("Photo", "id", "url2")
+	locations.RegisterURL("Photo", "url", "url2")
+	locations.RegisterURL("Photo", "owner", "url2")
+
+	schema, _ := graphql.LoadSchema(`
+		type Photo {
+			id: ID!
+			url: String!
+			owner: User!
+		}
+
+		type User {
+			id: ID!
+			name: String!
+			friends: [User!]!
+			photos: [Photo!]!
+		}
+
+		type Query {
+			user: User
+		}
+	`)
+
+	return schema, locations
+}
+
+// a query that bounces between services a few times, the way real clients tend to
+const plannerDeepQuery = `
+	{
+		user {
+			... on User {
+				id
+				name
+				friends {
+					... on User {
+						id
+						name
+						photos {
+							id
+							url
+							owner {
+								... on User {
+									id
+									name
+									friends {
+										id
+										name
+										photos {
+											url
+											owner {
+												name
+											}
+										}
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+`
+
+// renderPlanQueries returns the queries sent by every step in the plan, sorted so that
+// the order the planner visits siblings doesn't matter
+func renderPlanQueries(plan *QueryPlan) string {
+	queries := []string{}
+
+	var walk func(step *QueryPlanStep)
+	walk = func(step *QueryPlanStep) {
+		queries = append(queries, fmt.Sprintf("# %s\n%s", strings.Join(step.InsertionPoint, "."), step.QueryString))
+		for _, dependent := range step.Then {
+			walk(dependent)
+		}
+	}
+	for _, step := range plan.RootStep.Then {
+		walk(step)
+	}
+	sort.Strings(queries)
+
+	return strings.Join(queries, "\n")
+}
+
+func TestPlanQuery_renderedQueries(t *testing.T) {
+	schema, locations := plannerGoldenLocations()
+
+	table := []struct {
+		Name  string
+		Query string
+	}{
+		{"deep", plannerDeepQuery},
+		{"redundantFragments", `
+			{
+				user {
+					... on User {
+						... on User {
+							name
+						}
+						... on User {
+							photos {
+								... on Photo {
+									url
+								}
+							}
+						}
+					}
+				}
+			}
+		`},
+		{"duplicateIDs", `
+			{
+				user {
+					id
+					friends {
+						id
+						photos {
+							url
+						}
+					}
+				}
+			}
+		`},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+				Query:     row.Query,
+				Schema:    schema,
+				Locations: locations,
+			})
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			rendered := renderPlanQueries(plans[0])
+			golden := filepath.Join("testdata", "plans", row.Name+".golden")
+
+			if *updateGolden {
+				if err := ioutil.WriteFile(golden, []byte(rendered), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expected, err := ioutil.ReadFile(golden)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, string(expected), rendered)
+		})
+	}
+}
+
+func BenchmarkPlanQuery_deepQuery(b *testing.B) {
+	schema, locations := plannerGoldenLocations()
+
+	var plans QueryPlanList
+	for i := 0; i < b.N; i++ {
+		var err error
+		plans, err = (&MinQueriesPlanner{}).Plan(&PlanningContext{
+			Query:     plannerDeepQuery,
+			Schema:    schema,
+			Locations: locations,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	// compare the size of the queries we send against the ones we would send without minimizing them
+	sent := 0
+	unminimized := 0
+
+	var walk func(step *QueryPlanStep)
+	walk = func(step *QueryPlanStep) {
+		sent += len(step.QueryString)
+
+		variables := ast.VariableDefinitionList{}
+		for _, variable := range step.QueryDocument.Operations[0].VariableDefinitions {
+			if variable.Variable != "id" {
+				variables = append(variables, variable)
+			}
+		}
+		query, err := graphql.PrintQuery(plannerBuildQuery("", plannerStepOperation(plans[0], step), step.ParentType, step.EntityKey, variables, step.SelectionSet, step.FragmentDefinitions))
+		if err != nil {
+			b.Fatal(err)
+		}
+		unminimized += len(query)
+
+		for _, dependent := range step.Then {
+			walk(dependent)
+		}
+	}
+	for _, step := range plans[0].RootStep.Then {
+		walk(step)
+	}
+
+	b.ReportMetric(float64(sent), "query-bytes")
+
+	// the queries should be meaningfully smaller than what the planner walked through
+	if float64(sent) > 0.9*float64(unminimized) {
+		b.Fatalf("rendered queries were not minimized: sent %v bytes, expected well under %v", sent, unminimized)
+	}
+}
+
+func TestPlanQuery_directives(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		directive @live on QUERY
+		directive @connection(key: String!) on FIELD
+		directive @track(event: String!) on INLINE_FRAGMENT
+
+		type User {
+			id: ID!
+			friends: [User!]!
+			favoriteCatPhoto: CatPhoto!
+		}
+
+		type CatPhoto {
+			URL: String!
+		}
+
+		type Query {
+			user: User
+		}
+	`)
+
+	locations := FieldURLMap{}
+	locations.RegisterURL("Query", "user", "url1")
+	locations.RegisterURL("User", "id", "url1")
+	locations.RegisterURL("User", "friends", "url1")
+	locations.RegisterURL("User", "favoriteCatPhoto", "url2")
+	locations.RegisterURL("CatPhoto", "URL", "url2")
+
+	// only the first service knows about the custom directives
+	directives := DirectiveMap{
+		"url1": Set{"live": true, "connection": true, "track": true},
+		"url2": Set{},
+	}
+
+	table := []struct {
+		Name      string
+		Query     string
+		Service1  string
+		Variables Set
+	}{
+		{
+			"Field",
+			`query($key: String!) {
+				user {
+					friends @connection(key: $key) { id }
+					favoriteCatPhoto @connection(key: $key) { URL }
+				}
+			}`,
+			`query Gateway_2b2d6715 ($key: String!) { user { friends @connection(key: $key) { id } __gateway_id: id } }`,
+			Set{"key": true},
+		},
+		{
+			"Inline fragment",
+			`query($event: String!) {
+				user {
+					... on User @track(event: $event) {
+						id
+						favoriteCatPhoto { URL }
+					}
+				}
+			}`,
+			`query Gateway_8020a54c ($event: String!) { user { ... on User @track(event: $event) { id } __gateway_id: id } }`,
+			Set{"event": true},
+		},
+		{
+			"Operation",
+			`query @live {
+				user {
+					favoriteCatPhoto { URL }
+				}
+			}`,
+			`query Gateway_6a90276b @live { user { __gateway_id: id } }`,
+			Set{},
+		},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+				Query:      row.Query,
+				Schema:     schema,
+				Locations:  locations,
+				Directives: directives,
+			})
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			// the first service gets the directives along with the variables they use
+			firstStep := plans[0].RootStep.Then[0]
+			assert.Equal(t, row.Service1, strings.Join(strings.Fields(firstStep.QueryString), " "))
+			assert.Equal(t, row.Variables, firstStep.Variables)
+
+			// the second service doesn't know about any of them so they have to be stripped, along with their variables
+			if !assert.Len(t, firstStep.Then, 1) {
+				return
+			}
+			secondStep := firstStep.Then[0]
+			assert.Equal(t, Set{}, secondStep.Variables)
+			assert.NotContains(t, secondStep.QueryString, "@")
+			assert.Contains(t, secondStep.QueryString, "URL")
+		})
+	}
+}
+
+func TestPlanQuery_siblingLocations(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String!
+			bio: String!
+			age: Int!
+			height: Int!
+		}
+
+		type Query {
+			user: User
+		}
+	`)
+
+	// age and height can be found in two services but the first one we know about isn't
+	// the one the rest of the selection needs
+	locations := FieldURLMap{}
+	locations.RegisterURL("Query", "user", "url1")
+	locations.RegisterURL("User", "id", "url1", "url2", "url3")
+	locations.RegisterURL("User", "name", "url1")
+	locations.RegisterURL("User", "bio", "url2")
+	locations.RegisterURL("User", "age", "url3", "url2")
+	locations.RegisterURL("User", "height", "url2", "url3")
+
+	// countSteps returns the number of steps that send a query
+	var countSteps func(step *QueryPlanStep) int
+	countSteps = func(step *QueryPlanStep) int {
+		count := len(step.Then)
+		for _, child := range step.Then {
+			count += countSteps(child)
+		}
+		return count
+	}
+
+	table := []struct {
+		Name     string
+		Query    string
+		Location string
+	}{
+		// picking the first location for age would be 3 steps but bio means we have to visit url2 anyway
+		{"Required by a sibling", `{ user { name bio age } }`, "url2"},
+		// neither field is required to be anywhere so they should be grouped together
+		{"Shared by siblings", `{ user { name age height } }`, "url3"},
+		// the sibling can come from a fragment
+		{"Fragment sibling", `{ user { name ... on User { bio } age } }`, "url2"},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+				Query:     row.Query,
+				Schema:    schema,
+				Locations: locations,
+			})
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			assert.Equal(t, 2, countSteps(plans[0].RootStep))
+
+			// the second step has to go to a single service
+			userStep := plans[0].RootStep.Then[0]
+			if assert.Len(t, userStep.Then, 1) {
+				assert.Equal(t, row.Location, userStep.Then[0].Location)
+			}
+		})
+	}
+}
+
+func TestPlanQuery_literalArguments(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		enum Color {
+			RED
+			BLUE
+		}
+
+		input Filter {
+			color: Color
+			colors: [Color!]
+			flag: Boolean
+			count: Int
+			ratio: Float
+			name: String
+			nested: Filter
+		}
+
+		type User {
+			id: ID!
+			photos(filter: Filter, color: Color, first: Int): [String]
+		}
+
+		type Query {
+			search(id: ID, flag: Boolean, count: Int, ratio: Float, name: String, color: Color, counts: [Int], filter: Filter): [User]
+		}
+	`)
+
+	locations := FieldURLMap{}
+	locations.RegisterURL("Query", "search", "url1")
+	locations.RegisterURL("User", "id", "url1")
+	locations.RegisterURL("User", "photos", "url2")
+
+	table := []struct {
+		Name  string
+		Query string
+	}{
+		{"ID as an Int", `{ search(id: 1) { id } }`},
+		{"ID as a String", `{ search(id: "1") { id } }`},
+		{"Boolean", `{ search(flag: false) { id } }`},
+		{"Int", `{ search(count: -3) { id } }`},
+		{"Float", `{ search(ratio: 1.5e3) { id } }`},
+		{"String", `{ search(name: "a \"quoted\" \\ string\nwith a newline") { id } }`},
+		{"Block String", `{ search(name: """
+			a block string
+			  with "quotes" and indentation
+		""") { id } }`},
+		{"Null", `{ search(name: null) { id } }`},
+		{"Enum", `{ search(color: BLUE) { id } }`},
+		{"List", `{ search(counts: [1, 2, null]) { id } }`},
+		{"Object", `{ search(filter: {color: RED, colors: [RED, BLUE], flag: true, count: 0, ratio: 0.5, name: null, nested: {name: """deep"""}}) { id } }`},
+		{"Dependent Step", `{ search { photos(first: 2, color: RED, filter: {flag: true, nested: {colors: [BLUE], name: """deep"""}}) } }`},
+	}
+
+	// arguments collects the printed value of every argument in the selection set by field
+	var arguments func(selectionSet ast.SelectionSet, acc map[string]string)
+	arguments = func(selectionSet ast.SelectionSet, acc map[string]string) {
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				// the fields that the gateway adds aren't part of the original query
+				if selection.Name != "node" && selection.Name != "_entities" {
+					for _, argument := range selection.Arguments {
+						acc[selection.Name+"."+argument.Name] = argument.Value.String()
+					}
+				}
+				arguments(selection.SelectionSet, acc)
+			case *ast.InlineFragment:
+				arguments(selection.SelectionSet, acc)
+			}
+		}
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			original, errs := gqlparser.LoadQuery(schema, row.Query)
+			if !assert.Nil(t, errs) {
+				return
+			}
+			expected := map[string]string{}
+			arguments(original.Operations[0].SelectionSet, expected)
+
+			plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+				Query:     row.Query,
+				Schema:    schema,
+				Locations: locations,
+			})
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			// every query sent downstream has to parse back to the same arguments
+			printed := map[string]string{}
+			var walk func(step *QueryPlanStep) bool
+			walk = func(step *QueryPlanStep) bool {
+				document, err := parser.ParseQuery(&ast.Source{Input: step.QueryString})
+				if !assert.Nil(t, err, step.QueryString) {
+					return false
+				}
+				arguments(document.Operations[0].SelectionSet, printed)
+
+				for _, dependent := range step.Then {
+					if !walk(dependent) {
+						return false
+					}
+				}
+				return true
+			}
+			for _, step := range plans[0].RootStep.Then {
+				if !walk(step) {
+					return
+				}
+			}
+
+			assert.Equal(t, expected, printed)
+		})
+	}
+}
+
+func TestPlanQuery_preservesFragments(t *testing.T) {
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		interface Animal {
+			name: String!
+		}
+
+		type Cat implements Animal {
+			name: String!
+			meows: Boolean!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+			pets: [Animal!]!
+		}
+
+		type Query {
+			viewer: User
+			node(id: ID!): Node
+		}
+	`)
+	avatarsSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			avatar: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: usersSchema, URL: "users"},
+		{Schema: avatarsSchema, URL: "avatars"},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	plans, err := gateway.GetPlans(&RequestContext{
+		Context: context.Background(),
+		Query: `
+			query Viewer { viewer { ...UserFields } }
+			fragment UserFields on User { name ...Avatar pets { ...AnimalFields } }
+			fragment Avatar on User { avatar }
+			fragment AnimalFields on Animal { name ... on Cat { meows } }
+		`,
+	})
+	if !assert.Nil(t, err) || !assert.Len(t, plans[0].RootStep.Then, 1) {
+		return
+	}
+
+	// fragments returns the fragments sent by the step, printed as a query
+	fragments := func(step *QueryPlanStep) map[string]string {
+		result := map[string]string{}
+		for _, fragment := range step.QueryDocument.Fragments {
+			result[fragment.Name] = fragment.TypeCondition + " " + graphql.FormatSelectionSet(fragment.SelectionSet)
+		}
+		return result
+	}
+
+	// the fragments are sent to each service with the fields it owns and only if the query uses them
+	usersStep := plans[0].RootStep.Then[0]
+	usersFragments := fragments(usersStep)
+	assert.Contains(t, usersStep.QueryString, "... UserFields")
+	assert.NotContains(t, usersFragments, "Avatar")
+	if assert.Contains(t, usersFragments, "UserFields") {
+		assert.NotContains(t, usersFragments["UserFields"], "avatar")
+	}
+	// and a fragment on an interface keeps its type conditions
+	if assert.Contains(t, usersFragments, "AnimalFields") {
+		assert.True(t, strings.HasPrefix(usersFragments["AnimalFields"], "Animal "))
+		assert.Contains(t, usersFragments["AnimalFields"], "... on Cat")
+	}
+
+	if !assert.Len(t, usersStep.Then, 1) {
+		return
+	}
+	avatarsFragments := fragments(usersStep.Then[0])
+	assert.NotContains(t, avatarsFragments, "AnimalFields")
+	if assert.Contains(t, avatarsFragments, "UserFields") && assert.Contains(t, avatarsFragments, "Avatar") {
+		assert.NotContains(t, avatarsFragments["UserFields"], "name")
+		assert.Contains(t, avatarsFragments["Avatar"], "avatar")
+	}
+}
+
+func TestPlanQuery_invalidFragments(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String!
+			friends: [User!]!
+		}
+
+		type Query {
+			viewer: User!
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, row := range []struct {
+		query string
+		error string
+	}{
+		{
+			`query Viewer { viewer { ...UserFields } } fragment UserFields on User { id } fragment UserFields on User { name }`,
+			`There can be only one fragment named "UserFields"`,
+		},
+		{
+			`query Viewer { viewer { id } } query Viewer { viewer { name } }`,
+			`There can be only one operation named "Viewer"`,
+		},
+		{
+			`query Viewer { viewer { ...UserFields } } fragment UserFields on User { ...Missing }`,
+			`Unknown fragment "Missing"`,
+		},
+		{
+			`query Viewer { viewer { ...A } } fragment A on User { friends { ...B } } fragment B on User { friends { ...A } }`,
+			`Cannot spread fragment "A" within itself via B`,
+		},
+		{
+			`query Viewer { viewer { id } } fragment UserFields on User { name }`,
+			`Fragment "UserFields" is never used`,
+		},
+	} {
+		_, err := gateway.GetPlans(&RequestContext{Context: context.Background(), Query: row.query, OperationName: "Viewer"})
+		if assert.NotNil(t, err, row.query) {
+			assert.Contains(t, err.Error(), row.error)
+		}
+	}
+
+	// the fragments of the other operations in the document are left out of the steps of the one being executed
+	plans, err := gateway.GetPlans(&RequestContext{
+		Context: context.Background(),
+		Query: `
+			query Viewer { viewer { ...UserFields } }
+			query Friends { viewer { friends { ...FriendFields } } }
+			fragment UserFields on User { name }
+			fragment FriendFields on User { id }
+		`,
+		OperationName: "Viewer",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	plan, err := gateway.planForOperation(&RequestContext{OperationName: "Viewer"}, plans)
+	if assert.Nil(t, err) && assert.Len(t, plan.RootStep.Then, 1) {
+		fragments := plan.RootStep.Then[0].QueryDocument.Fragments
+		if assert.Len(t, fragments, 1) {
+			assert.Equal(t, "UserFields", fragments[0].Name)
+		}
+	}
+}
+
+func TestPlannerFieldLocations_typename(t *testing.T) {
+	locations := FieldURLMap{}
+	locations.RegisterURL("User", "name", "users")
+
+	// __typename goes wherever the object is resolved
+	found, err := plannerFieldLocations(&extractSelectionConfig{locations: locations, parentLocation: "users"}, "User", "__typename")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users"}, found)
+
+	// and is answered by the gateway at the root
+	found, err = plannerFieldLocations(&extractSelectionConfig{locations: locations}, "Query", "__typename")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{internalSchemaLocation}, found)
+
+	// the other fields still need a location
+	_, err = plannerFieldLocations(&extractSelectionConfig{locations: locations}, "User", "age")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,218 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// The playground is served by PlaygroundHandler. By default, the page loads graphql-playground from a CDN
+// and starts it with an inline script. A page served with ServeAssetsLocally doesn't have anything inline
+// and loads every file with a request to the handler itself (?playgroundAsset=<file>) so it works no matter
+// where the handler is mounted.
+
+// playgroundCDN is where the assets of the playground come from unless they are served locally
+const playgroundCDN = "//cdn.jsdelivr.net/npm/graphql-playground-react/build/"
+
+// playgroundAssetParameter is the query parameter that designates the asset to serve
+const playgroundAssetParameter = "playgroundAsset"
+
+// the files the gateway generates itself when the assets are served locally
+const (
+	playgroundStylesAsset = "gateway/playground.css"
+	playgroundScriptAsset = "gateway/playground.js"
+)
+
+// PlaygroundTab is a tab that is open when the playground loads
+type PlaygroundTab struct {
+	Name      string            `json:"name,omitempty"`
+	Endpoint  string            `json:"endpoint,omitempty"`
+	Query     string            `json:"query"`
+	Variables string            `json:"variables,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// PlaygroundOptions configures the playground shown by PlaygroundHandler
+type PlaygroundOptions struct {
+	// the url the playground sends queries to. Defaults to the url the playground was loaded from.
+	Endpoint string
+	// the headers sent with every query unless a tab says otherwise
+	DefaultHeaders map[string]string
+	// the tabs that are open when the playground loads
+	Tabs []PlaygroundTab
+	// serve every asset from the gateway so the page works with a strict Content Security Policy and
+	// without access to the CDN. Assets holds the build directory of graphql-playground-react.
+	ServeAssetsLocally bool
+	Assets             http.FileSystem
+	// don't show the playground. POST requests are still executed.
+	Disabled bool
+}
+
+// WithPlayground returns an Option that configures the playground shown by PlaygroundHandler
+func WithPlayground(opts PlaygroundOptions) Option {
+	return func(g *Gateway) {
+		g.playground = opts
+	}
+}
+
+// servePlayground responds to a GET request to the PlaygroundHandler
+func (g *Gateway) servePlayground(w http.ResponseWriter, r *http.Request) {
+	if g.playgroundDisabled(r.Context()) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// the page could be asking for one of its assets
+	if asset := r.URL.Query().Get(playgroundAssetParameter); asset != "" && g.playground.ServeAssetsLocally {
+		g.servePlaygroundAsset(w, r, asset)
+		return
+	}
+
+	page, err := g.playgroundPage()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+// servePlaygroundAsset responds with one of the files the playground needs
+func (g *Gateway) servePlaygroundAsset(w http.ResponseWriter, r *http.Request, asset string) {
+	switch asset {
+	case playgroundStylesAsset:
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Write([]byte(playgroundStyles))
+		return
+
+	case playgroundScriptAsset:
+		script, err := g.playgroundScript()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		w.Write([]byte(script))
+		return
+	}
+
+	if g.playground.Assets == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// the asset can't point outside of the directory
+	name := path.Clean("/" + asset)
+	file, err := g.playground.Assets.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+// playgroundPage returns the html of the playground
+func (g *Gateway) playgroundPage() (string, error) {
+	// where the assets come from
+	assets := playgroundCDN
+	if g.playground.ServeAssetsLocally {
+		assets = "?" + playgroundAssetParameter + "="
+	}
+
+	page := &strings.Builder{}
+	page.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset=utf-8 />
+  <meta name="viewport" content="user-scalable=no, initial-scale=1.0, minimum-scale=1.0, maximum-scale=1.0, minimal-ui">
+  <title>GraphQL Playground</title>
+  <link rel="stylesheet" href="` + assets + `static/css/index.css" />
+  <link rel="shortcut icon" href="` + assets + `favicon.png" />
+  <script src="` + assets + `static/js/middleware.js"></script>
+`)
+
+	// the styles of the loading page can't be inline with a strict Content Security Policy
+	if g.playground.ServeAssetsLocally {
+		page.WriteString(`  <link rel="stylesheet" href="` + assets + playgroundStylesAsset + `" />
+</head>
+<body>
+`)
+	} else {
+		page.WriteString(`</head>
+<body>
+<style type="text/css">` + playgroundStyles + `</style>
+`)
+	}
+
+	page.WriteString(playgroundLoading)
+	page.WriteString(`<div id="root"></div>
+`)
+
+	// the same goes for the script that starts the playground
+	if g.playground.ServeAssetsLocally {
+		page.WriteString(`<script src="` + assets + playgroundScriptAsset + `"></script>
+`)
+	} else {
+		script, err := g.playgroundScript()
+		if err != nil {
+			return "", err
+		}
+		page.WriteString(`<script type="text/javascript">` + script + `</script>
+`)
+	}
+
+	page.WriteString(`</body>
+</html>
+`)
+
+	return page.String(), nil
+}
+
+// playgroundScript returns the script that starts the playground
+func (g *Gateway) playgroundScript() (string, error) {
+	config := map[string]interface{}{}
+	if g.playground.Endpoint != "" {
+		config["endpoint"] = g.playground.Endpoint
+	}
+	if len(g.playground.DefaultHeaders) > 0 {
+		config["headers"] = g.playground.DefaultHeaders
+	}
+	if len(g.playground.Tabs) > 0 {
+		// the tabs go to the playground's endpoint unless they say otherwise
+		tabs := []PlaygroundTab{}
+		for _, tab := range g.playground.Tabs {
+			if tab.Endpoint == "" {
+				tab.Endpoint = g.playground.Endpoint
+			}
+			tabs = append(tabs, tab)
+		}
+		config["tabs"] = tabs
+	}
+
+	// the encoder escapes the characters that could close the script tag
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	return `
+window.addEventListener('load', function (event) {
+  const loadingWrapper = document.getElementById('loading-wrapper');
+  loadingWrapper.classList.add('fadeOut');
+  const root = document.getElementById('root');
+  root.classList.add('playgroundIn');
+  GraphQLPlayground.init(root, ` + string(encoded) + `)
+})
+`, nil
+}
@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaygroundHandler_options(t *testing.T) {
+	// gateway returns a gateway with the options
+	gateway := func(options ...Option) *Gateway {
+		schema, _ := graphql.LoadSchema(`
+			type Query {
+				allUsers: [String!]!
+			}
+		`)
+		options = append(options, WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"allUsers": []interface{}{}}, nil
+		})))
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gateway
+	}
+
+	// request sends a request to the playground and returns the status and the body of the response
+	request := func(gateway *Gateway, method string, url string, body string) (int, string) {
+		responseRecorder := httptest.NewRecorder()
+		gateway.PlaygroundHandler(responseRecorder, httptest.NewRequest(method, url, strings.NewReader(body)))
+
+		response, _ := ioutil.ReadAll(responseRecorder.Result().Body)
+		return responseRecorder.Code, string(response)
+	}
+
+	t.Run("Default", func(t *testing.T) {
+		status, page := request(gateway(), "GET", "/graphql", "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, page, playgroundCDN+"static/js/middleware.js")
+		assert.Contains(t, page, "GraphQLPlayground.init(root, {})")
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		// a directory with the build of the playground
+		assets, err := ioutil.TempDir("", "playground")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(assets)
+		os.MkdirAll(filepath.Join(assets, "static", "js"), 0755)
+		ioutil.WriteFile(filepath.Join(assets, "static", "js", "middleware.js"), []byte("window.GraphQLPlayground = {}"), 0644)
+
+		configured := gateway(WithPlayground(PlaygroundOptions{
+			Endpoint:       "/api/graphql",
+			DefaultHeaders: map[string]string{"Authorization": "Bearer token"},
+			Tabs: []PlaygroundTab{
+				{Name: "Users", Query: "{ allUsers }"},
+			},
+			ServeAssetsLocally: true,
+			Assets:             http.Dir(assets),
+		}))
+
+		// the page doesn't have anything inline or from the CDN
+		status, page := request(configured, "GET", "/graphql", "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.NotContains(t, page, playgroundCDN)
+		assert.NotContains(t, page, "<style")
+		assert.NotContains(t, page, "style=")
+		assert.NotContains(t, page, "<script type")
+		assert.Contains(t, page, `src="?playgroundAsset=static/js/middleware.js"`)
+
+		// the script that starts the playground has the configuration
+		status, script := request(configured, "GET", "/graphql?playgroundAsset="+playgroundScriptAsset, "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, script, `"endpoint":"/api/graphql"`)
+		assert.Contains(t, script, `"headers":{"Authorization":"Bearer token"}`)
+		assert.Contains(t, script, `"tabs":[{"name":"Users","endpoint":"/api/graphql","query":"{ allUsers }"}]`)
+
+		// the rest of the assets come from the directory
+		status, middleware := request(configured, "GET", "/graphql?playgroundAsset=static/js/middleware.js", "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "window.GraphQLPlayground = {}", middleware)
+
+		// but nothing outside of it
+		status, _ = request(configured, "GET", "/graphql?playgroundAsset=../../../etc/passwd", "")
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		disabled := gateway(WithPlayground(PlaygroundOptions{Disabled: true}))
+
+		status, _ := request(disabled, "GET", "/graphql", "")
+		assert.Equal(t, http.StatusNotFound, status)
+
+		// queries still go through
+		status, body := request(disabled, "POST", "/graphql", `{"query": "{ allUsers }"}`)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, `{"data":{"allUsers":[]}}`, body)
+	})
+}
@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"errors"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// ErrIntrospectionDisabled is returned when asking for the schema of a gateway that was created
+// with WithoutIntrospection
+var ErrIntrospectionDisabled = errors.New("introspection is disabled")
+
+// WithoutMutations returns an Option that makes the gateway reject mutations before they are planned.
+// The Mutation type is also left out of introspection and the SDL so that tools don't offer operations
+// that can't be performed.
+func WithoutMutations() Option {
+	return func(g *Gateway) {
+		g.disableMutations = true
+	}
+}
+
+// WithoutSubscriptions returns an Option that makes the gateway reject subscriptions before they are planned.
+// Like WithoutMutations, the Subscription type is no longer reported by introspection.
+func WithoutSubscriptions() Option {
+	return func(g *Gateway) {
+		g.disableSubscriptions = true
+	}
+}
+
+// WithoutIntrospection returns an Option that makes the gateway reject queries for __schema or __type
+// and refuse to export its schema as SDL
+func WithoutIntrospection() Option {
+	return func(g *Gateway) {
+		g.disableIntrospection = true
+	}
+}
+
+// checkRequestPolicy returns an error if the operation being requested is one the gateway was configured to reject
+func (g *Gateway) checkRequestPolicy(ctx *RequestContext) error {
+	if !g.disableMutations && !g.disableSubscriptions && !g.introspectionDisabled(ctx.Context) {
+		return nil
+	}
+
+	// a query that doesn't parse will be reported by the planner
+	document, err := parser.ParseQuery(&ast.Source{Input: ctx.Query})
+	if err != nil {
+		return nil
+	}
+
+	for _, operation := range document.Operations {
+		// if the request names an operation then that's the only one that could run
+		if ctx.OperationName != "" && operation.Name != ctx.OperationName {
+			continue
+		}
+
+		if err := g.checkOperationPolicy(ctx, operation, document.Fragments); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPlanPolicy is the equivalent of checkRequestPolicy for requests that only refer to a plan (ie, persisted queries)
+func (g *Gateway) checkPlanPolicy(ctx *RequestContext, plans QueryPlanList) error {
+	for _, plan := range plans {
+		if ctx.OperationName != "" && plan.Operation.Name != ctx.OperationName {
+			continue
+		}
+
+		if err := g.checkOperationPolicy(ctx, plan.Operation, plan.FragmentDefinitions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkOperationPolicy returns an error if the gateway doesn't allow the operation
+func (g *Gateway) checkOperationPolicy(ctx *RequestContext, operation *ast.OperationDefinition, fragments ast.FragmentDefinitionList) error {
+	if operation.Operation == ast.Mutation && g.disableMutations {
+		return policyError("mutations are not allowed")
+	}
+	if operation.Operation == ast.Subscription && g.disableSubscriptions {
+		return policyError("subscriptions are not allowed")
+	}
+
+	if !g.introspectionDisabled(ctx.Context) {
+		return nil
+	}
+
+	// the introspection fields can only show up at the root of the operation
+	for _, field := range graphql.SelectedFields(collectSelectedFields(operation.SelectionSet, fragments)) {
+		if field.Name == "__schema" || field.Name == "__type" {
+			return policyError("introspection is not allowed")
+		}
+	}
+
+	return nil
+}
+
+// policyError builds the error returned to the user when their request is rejected
+func policyError(message string) error {
+	return graphql.ErrorList{graphql.NewError("OPERATION_NOT_ALLOWED", message)}
+}
+
+// exposedSchema returns the version of the schema that clients are allowed to see
+func (g *Gateway) exposedSchema() *ast.Schema {
+	g.schemaMutex.RLock()
+	schema := g.schema
+	g.schemaMutex.RUnlock()
+
+	return g.exposeSchema(schema)
+}
+
+// exposeSchema returns the version of the designated schema that clients are allowed to see
+func (g *Gateway) exposeSchema(source *ast.Schema) *ast.Schema {
+	if !g.disableMutations && !g.disableSubscriptions {
+		return source
+	}
+
+	// copy the schema so we can remove the types without touching what the planner uses
+	schema := *source
+	schema.Types = map[string]*ast.Definition{}
+	for name, definition := range source.Types {
+		schema.Types[name] = definition
+	}
+
+	if g.disableMutations && schema.Mutation != nil {
+		delete(schema.Types, schema.Mutation.Name)
+		schema.Mutation = nil
+	}
+	if g.disableSubscriptions && schema.Subscription != nil {
+		delete(schema.Types, schema.Subscription.Name)
+		schema.Subscription = nil
+	}
+
+	return &schema
+}
@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLHandler_operationPolicy(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+
+		type Mutation {
+			setValue(value: String!): String!
+		}
+
+		type Subscription {
+			valueChanged: String!
+		}
+	`)
+
+	// count every request that makes it to the service
+	var requests int32
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			atomic.AddInt32(&requests, 1)
+			return map[string]interface{}{"value": "hello"}, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithQueryerFactory(&factory),
+		WithoutMutations(),
+		WithoutSubscriptions(),
+		WithoutIntrospection(),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	table := []struct {
+		Name    string
+		Query   string
+		Allowed bool
+	}{
+		{"Query", `{ value __typename }`, true},
+		{"Mutation", `mutation { setValue(value: "hello") }`, false},
+		{"Subscription", `subscription { valueChanged }`, false},
+		{"Introspection", `{ __schema { queryType { name } } }`, false},
+		{"Introspection in a fragment", `{ ...Schema } fragment Schema on Query { __type(name: "Query") { name } }`, false},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+
+			body, _ := json.Marshal(map[string]interface{}{"query": row.Query})
+			responseRecorder := httptest.NewRecorder()
+			gateway.GraphQLHandler(responseRecorder, httptest.NewRequest("POST", "/graphql", strings.NewReader(string(body))))
+
+			if row.Allowed {
+				assert.Equal(t, http.StatusOK, responseRecorder.Code)
+				assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+				return
+			}
+
+			assert.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+			assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+
+			result := &formattedErrorsResult{}
+			if !assert.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), result)) || !assert.Len(t, result.Errors, 1) {
+				return
+			}
+			assert.Equal(t, "OPERATION_NOT_ALLOWED", result.Errors[0].Extensions["code"])
+		})
+	}
+
+	t.Run("SDL", func(t *testing.T) {
+		_, err := gateway.SchemaSDL(context.Background())
+		assert.Equal(t, ErrIntrospectionDisabled, err)
+
+		responseRecorder := httptest.NewRecorder()
+		gateway.SchemaSDLHandler(responseRecorder, httptest.NewRequest("GET", "/schema.graphql", nil))
+		assert.Equal(t, http.StatusForbidden, responseRecorder.Code)
+	})
+}
+
+func TestGateway_withoutMutationsHidesMutationType(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+
+		type Mutation {
+			setValue(value: String!): String!
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithoutMutations())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var result struct {
+		Schema struct {
+			MutationType *struct{ Name string }
+			Types        []struct{ Name string }
+		} `json:"__schema"`
+	}
+	err = gateway.ExecuteInto(context.Background(), &Request{
+		Query: `{ __schema { mutationType { name } types { name } } }`,
+	}, &result)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Nil(t, result.Schema.MutationType)
+	for _, introspectedType := range result.Schema.Types {
+		assert.NotEqual(t, "Mutation", introspectedType.Name)
+	}
+
+	sdl, err := gateway.SchemaSDL(context.Background())
+	if assert.Nil(t, err) {
+		assert.NotContains(t, sdl, "Mutation")
+		assert.Contains(t, sdl, "type Query")
+	}
+}
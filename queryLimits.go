@@ -0,0 +1,240 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// Some services refuse queries that are bigger than a certain size, which a step with a wide selection (ie, a
+// large fragment on a big type) can easily be. When a service has a limit and the query of a step doesn't fit,
+// the fields of the step are split between as many queries as it takes, which are sent to the service at the same
+// time and whose responses are merged before the executor sees them. The fields that the gateway added to the
+// query (the ids it needs to insert other steps and __typename) are sent with every one of the queries, and each
+// query only declares the variables and the fragments it uses.
+//
+// Only the fields at the top of the step are split up. A field whose query doesn't fit on its own can't be sent
+// at all and the step fails with an error that names it. The fields of a mutation have to run one after the
+// other so a mutation that doesn't fit isn't split up either, it fails instead.
+
+// WithServiceQueryLimit returns an Option that splits up the queries sent to the service at url so that none of
+// them is bigger than maxBytes
+func WithServiceQueryLimit(url string, maxBytes int) Option {
+	return func(g *Gateway) {
+		if g.queryLimits == nil {
+			g.queryLimits = map[string]int{}
+		}
+		g.queryLimits[url] = maxBytes
+	}
+}
+
+// executorSendLimitedQuery sends the query to the service, split up into as many queries as it takes for each of
+// them to fit in the limit
+func executorSendLimitedQuery(ctx context.Context, queryer graphql.Queryer, location string, input *graphql.QueryInput, limit int) (map[string]interface{}, map[string]interface{}, error) {
+	if limit <= 0 || len(input.Query) <= limit {
+		return executorSendQuery(ctx, queryer, input)
+	}
+
+	partitions, err := splitQuery(location, input, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the queries are sent at the same time
+	results := make([]map[string]interface{}, len(partitions))
+	extensions := make([]map[string]interface{}, len(partitions))
+	errs := make([]error, len(partitions))
+	wg := &sync.WaitGroup{}
+	for i, partition := range partitions {
+		wg.Add(1)
+		go func(i int, partition *graphql.QueryInput) {
+			defer wg.Done()
+			results[i], extensions[i], errs[i] = executorSendQuery(ctx, queryer, partition)
+		}(i, partition)
+	}
+	wg.Wait()
+
+	// the responses are put back together as if they were the response to the whole query
+	var result map[string]interface{}
+	var mergedExtensions map[string]interface{}
+	var mergedErrs graphql.ErrorList
+	for i := range partitions {
+		if errs[i] != nil {
+			// an error that isn't about the fields of the query means that the step failed
+			list, ok := errs[i].(graphql.ErrorList)
+			if !ok {
+				return nil, nil, errs[i]
+			}
+			mergedErrs = append(mergedErrs, list...)
+		}
+
+		if results[i] != nil {
+			if result == nil {
+				result = map[string]interface{}{}
+			}
+			incrementalMergeObject(result, results[i])
+		}
+		if extensions[i] != nil {
+			if mergedExtensions == nil {
+				mergedExtensions = map[string]interface{}{}
+			}
+			incrementalMergeObject(mergedExtensions, extensions[i])
+		}
+	}
+	if len(mergedErrs) > 0 {
+		return result, mergedExtensions, mergedErrs
+	}
+
+	return result, mergedExtensions, nil
+}
+
+// splitQuery breaks the query up into queries that each fit in the limit
+func splitQuery(location string, input *graphql.QueryInput, limit int) ([]*graphql.QueryInput, error) {
+	document := input.QueryDocument
+	if document == nil {
+		parsed, err := parser.ParseQuery(&ast.Source{Input: input.Query})
+		if err != nil {
+			return nil, err
+		}
+		document = parsed
+	}
+	if len(document.Operations) != 1 {
+		return nil, fmt.Errorf("the query sent to %s is %d bytes, more than the %d it accepts", location, len(input.Query), limit)
+	}
+	// the queries are sent at the same time so the fields of a mutation could run out of order
+	if document.Operations[0].Operation == ast.Mutation {
+		return nil, fmt.Errorf("the mutation sent to %s is %d bytes, more than the %d it accepts", location, len(input.Query), limit)
+	}
+
+	// the fields of the step are under the field the gateway looks up the parent with, if there is one
+	selectionSet, rebuild := splitQueryTarget(document.Operations[0])
+
+	// the fields the gateway needs are sent with every query
+	required := ast.SelectionSet{}
+	rest := ast.SelectionSet{}
+	for _, selection := range selectionSet {
+		if field, ok := selection.(*ast.Field); ok && (strings.HasPrefix(field.Alias, "__gateway") || field.Name == "__typename") {
+			required = append(required, field)
+			continue
+		}
+		rest = append(rest, selection)
+	}
+
+	// build returns the query for the selections, along with the fields the gateway needs
+	build := func(selections ast.SelectionSet) (*graphql.QueryInput, error) {
+		operation := rebuild(append(append(ast.SelectionSet{}, required...), selections...))
+		fragments := planCodecUsedFragments(operation.SelectionSet, document.Fragments)
+
+		// only the variables that the query uses can be declared
+		used := plannerDocumentVariables(operation.Directives, operation.SelectionSet, fragments)
+		operation.VariableDefinitions = ast.VariableDefinitionList{}
+		for _, definition := range document.Operations[0].VariableDefinitions {
+			if used.Has(definition.Variable) {
+				operation.VariableDefinitions = append(operation.VariableDefinitions, definition)
+			}
+		}
+		variables := map[string]interface{}{}
+		for name, value := range input.Variables {
+			if used.Has(name) {
+				variables[name] = value
+			}
+		}
+
+		partition := &ast.QueryDocument{Operations: ast.OperationList{operation}, Fragments: fragments}
+		query, err := plannerPrintQuery(partition)
+		if err != nil {
+			return nil, err
+		}
+
+		return &graphql.QueryInput{
+			Query:         query,
+			QueryDocument: partition,
+			Variables:     variables,
+			OperationName: input.OperationName,
+		}, nil
+	}
+
+	// the selections are added to a query until the next one doesn't fit
+	partitions := []*graphql.QueryInput{}
+	current := ast.SelectionSet{}
+	var last *graphql.QueryInput
+	for _, selection := range rest {
+		candidate, err := build(append(append(ast.SelectionSet{}, current...), selection))
+		if err != nil {
+			return nil, err
+		}
+		if len(candidate.Query) <= limit {
+			current = append(current, selection)
+			last = candidate
+			continue
+		}
+
+		// a selection that doesn't fit on its own can't be sent
+		alone := candidate
+		if len(current) > 0 {
+			partitions = append(partitions, last)
+			alone, err = build(ast.SelectionSet{selection})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(alone.Query) > limit {
+			return nil, fmt.Errorf("%s needs a query of %d bytes on its own, more than the %d that %s accepts", splitQueryName(selection), len(alone.Query), limit, location)
+		}
+		current = ast.SelectionSet{selection}
+		last = alone
+	}
+	if last == nil {
+		// there was nothing to split up, the fields of the gateway were too big on their own
+		return nil, fmt.Errorf("the query sent to %s is %d bytes, more than the %d it accepts", location, len(input.Query), limit)
+	}
+
+	return append(partitions, last), nil
+}
+
+// splitQueryTarget returns the selection set that holds the fields of the step, and a function that returns a
+// copy of the operation with a different selection set in its place
+func splitQueryTarget(operation *ast.OperationDefinition) (ast.SelectionSet, func(ast.SelectionSet) *ast.OperationDefinition) {
+	// the steps that aren't at the root of the plan look up their parent first
+	if len(operation.SelectionSet) == 1 {
+		field, ok := operation.SelectionSet[0].(*ast.Field)
+		if ok && (field.Alias == gatewayNodeAlias || field.Alias == gatewayNodesAlias || field.Name == "_entities") && len(field.SelectionSet) == 1 {
+			if fragment, ok := field.SelectionSet[0].(*ast.InlineFragment); ok {
+				return fragment.SelectionSet, func(selectionSet ast.SelectionSet) *ast.OperationDefinition {
+					copiedFragment := *fragment
+					copiedFragment.SelectionSet = selectionSet
+					copiedField := *field
+					copiedField.SelectionSet = ast.SelectionSet{&copiedFragment}
+					copied := *operation
+					copied.SelectionSet = ast.SelectionSet{&copiedField}
+					return &copied
+				}
+			}
+		}
+	}
+
+	return operation.SelectionSet, func(selectionSet ast.SelectionSet) *ast.OperationDefinition {
+		copied := *operation
+		copied.SelectionSet = selectionSet
+		return &copied
+	}
+}
+
+// splitQueryName returns the name of a selection for an error
+func splitQueryName(selection ast.Selection) string {
+	switch selection := selection.(type) {
+	case *ast.Field:
+		return selection.Alias
+	case *ast.FragmentSpread:
+		return "..." + selection.Name
+	case *ast.InlineFragment:
+		return "... on " + selection.TypeCondition
+	}
+
+	return "the selection"
+}
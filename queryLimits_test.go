@@ -0,0 +1,148 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestGateway_serviceQueryLimit(t *testing.T) {
+	query := `query($withCompany: Boolean!) {
+		user(id: "1") {
+			name
+			bio
+			website
+			location
+			company @include(if: $withCompany)
+			twitter
+			...Links
+		}
+	}
+
+	fragment Links on User {
+		github
+		mastodon
+	}`
+
+	// execute runs the query against a profiles service that refuses the queries bigger than refuse and returns
+	// the result along with the queries each service was sent
+	execute := func(t *testing.T, refuse int, options ...Option) (map[string]interface{}, map[string][]string, error) {
+		usersSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type User implements Node {
+				id: ID!
+				name: String!
+			}
+
+			type Query {
+				node(id: ID!): Node
+				user(id: ID!): User
+			}
+		`)
+		profilesSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type User implements Node {
+				id: ID!
+				bio: String!
+				website: String!
+				location: String!
+				company: String!
+				twitter: String!
+				github: String!
+				mastodon: String!
+			}
+
+			type Query {
+				node(id: ID!): Node
+			}
+		`)
+		schemas := map[string]*ast.Schema{"users": usersSchema, "profiles": profilesSchema}
+
+		// the services refuse the queries that are too big, like the real ones would
+		mutex := sync.Mutex{}
+		sent := map[string][]string{}
+		factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+			mock := &MockQueryer{Schema: schemas[url]}
+			return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+				mutex.Lock()
+				sent[url] = append(sent[url], input.Query)
+				mutex.Unlock()
+
+				if url == "profiles" && len(input.Query) > refuse {
+					return nil, errors.New("query too big")
+				}
+				result := map[string]interface{}{}
+				err := mock.Query(context.Background(), input, &result)
+				return result, err
+			})
+		})
+
+		gateway, err := New([]*graphql.RemoteSchema{{URL: "users", Schema: usersSchema}, {URL: "profiles", Schema: profilesSchema}},
+			append([]Option{WithQueryerFactory(&factory)}, options...)...,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ctx := &RequestContext{Context: context.Background(), Query: query, Variables: map[string]interface{}{"withCompany": true}}
+		plans, err := gateway.GetPlans(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		result, err := gateway.Execute(ctx, plans)
+		return result, sent, err
+	}
+
+	// the whole query is too big for the service
+	_, _, err := execute(t, 200)
+	assert.NotNil(t, err)
+
+	// so the service gets it in pieces that are put back together
+	result, sent, err := execute(t, 200, WithServiceQueryLimit("profiles", 200))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Len(t, sent["users"], 1)
+	if assert.Len(t, sent["profiles"], 3) {
+		for _, partition := range sent["profiles"] {
+			assert.True(t, len(partition) <= 200, partition)
+			assert.Contains(t, partition, "__gateway_node")
+		}
+	}
+
+	// the result is the same as the one for the query in one piece
+	expected, sent, err := execute(t, 1000, WithServiceQueryLimit("profiles", 1000))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Len(t, sent["profiles"], 1)
+	assert.Equal(t, expected, result)
+
+	// a field that doesn't fit on its own can't be sent
+	_, _, err = execute(t, 120, WithServiceQueryLimit("profiles", 120))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "bio needs a query of")
+		assert.Contains(t, err.Error(), "profiles")
+	}
+}
+
+func TestSplitQuery_mutation(t *testing.T) {
+	// the fields of a mutation can't be sent at the same time
+	_, err := splitQuery("users", &graphql.QueryInput{
+		Query: `mutation { createUser(name: "alice") { id } deleteUser(id: "2") { id } }`,
+	}, 40)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "mutation")
+	}
+}
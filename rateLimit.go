@@ -0,0 +1,258 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// EstimatedListSize is the number of entries the gateway assumes a list has when estimating how many
+// requests the steps inside of it will send
+const EstimatedListSize = 10
+
+// QueryCost describes the work the gateway has to do in order to resolve a query. It is computed from
+// the plan so it is known before any request is sent to a service.
+type QueryCost struct {
+	// the number of steps in the plan
+	Steps int
+	// the number of steps that are sent to each service, indexed by url
+	ServiceRequests map[string]int
+	// the number of extra requests we expect because steps inside of lists are executed once per entry
+	ListExpansion int
+}
+
+// Total returns the estimated number of requests that the query will cause
+func (c QueryCost) Total() int {
+	return c.Steps + c.ListExpansion
+}
+
+// RateLimiter decides if a query can be executed. The identity of the client can be pulled out of the
+// context however the user sees fit (ie, with a middleware that looks at an api key header). Returning
+// an error stops the query before anything is sent to a service.
+type RateLimiter interface {
+	Allow(ctx context.Context, cost QueryCost) error
+}
+
+// RateLimitError is returned by a RateLimiter to tell the client when it can try again
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+// QueryCostError is returned by a RateLimiter when a query costs more than it would ever allow, so there's no
+// point in trying again
+type QueryCostError struct {
+	Cost  float64
+	Limit float64
+}
+
+func (e *QueryCostError) Error() string {
+	return fmt.Sprintf("the query costs %v, more than the %v that is ever allowed", e.Cost, e.Limit)
+}
+
+// WithRateLimiter returns an Option that checks the cost of every query with the designated limiter
+func WithRateLimiter(limiter RateLimiter) Option {
+	return func(g *Gateway) {
+		g.rateLimiter = limiter
+	}
+}
+
+// checkRateLimit returns the error to send back to the user if the limiter doesn't allow the plan
+func (g *Gateway) checkRateLimit(ctx context.Context, plan *QueryPlan) error {
+	if g.rateLimiter == nil {
+		return nil
+	}
+
+	err := g.rateLimiter.Allow(ctx, queryCost(plan))
+	if err == nil {
+		return nil
+	}
+
+	// a query that will never be allowed isn't something the client should wait for
+	if _, ok := err.(*QueryCostError); ok {
+		return graphql.ErrorList{graphql.NewError("QUERY_COST_EXCEEDED", err.Error())}
+	}
+
+	rateLimitErr := graphql.NewError("RATE_LIMITED", err.Error())
+	if limitErr, ok := err.(*RateLimitError); ok {
+		rateLimitErr.Extensions["retryAfter"] = math.Ceil(limitErr.RetryAfter.Seconds())
+	}
+
+	return graphql.ErrorList{rateLimitErr}
+}
+
+// queryCost computes the cost of executing the plan
+func queryCost(plan *QueryPlan) QueryCost {
+	cost := QueryCost{ServiceRequests: map[string]int{}}
+
+	// visit adds the children of the step to the cost. depth is the number of lists the step is nested in
+	var visit func(step *QueryPlanStep, depth int)
+	visit = func(step *QueryPlanStep, depth int) {
+		for _, child := range step.Then {
+			cost.Steps++
+
+			// the gateway's own fields don't cost a request to anyone
+			if child.Location != internalSchemaLocation {
+				cost.ServiceRequests[child.Location]++
+			}
+
+			// the path from the parent to where the child is inserted can go through more lists
+			childDepth := depth
+			if len(child.InsertionPoint) >= len(step.InsertionPoint) {
+				childDepth += queryCostListDepth(step, child.InsertionPoint[len(step.InsertionPoint):])
+			}
+			if childDepth > 0 {
+				cost.ListExpansion += int(math.Pow(EstimatedListSize, float64(childDepth))) - 1
+			}
+
+			visit(child, childDepth)
+		}
+	}
+	if plan.RootStep != nil {
+		visit(plan.RootStep, 0)
+	}
+
+	return cost
+}
+
+// queryCostListDepth returns the number of list fields along the path through the selection set of the step
+func queryCostListDepth(step *QueryPlanStep, path []string) int {
+	depth := 0
+	selectionSet := step.SelectionSet
+
+	for _, alias := range path {
+		var field *ast.Field
+		for _, selected := range graphql.SelectedFields(collectSelectedFields(selectionSet, step.FragmentDefinitions)) {
+			if selected.Alias == alias {
+				field = selected
+				break
+			}
+		}
+		if field == nil {
+			return depth
+		}
+
+		if field.Definition != nil && field.Definition.Type.Elem != nil {
+			depth++
+		}
+		selectionSet = field.SelectionSet
+	}
+
+	return depth
+}
+
+// TokenBucketRateLimiter is a RateLimiter that gives every client a bucket of tokens which refills at a
+// constant rate. Each query takes the total of its cost from the bucket of the client that sent it, which
+// means that a query that costs more than Burst is never allowed. The buckets that have filled back up are
+// the same as the ones of a client we haven't seen so they are forgotten, which keeps the limiter from
+// holding on to every client it ever saw.
+type TokenBucketRateLimiter struct {
+	// the number of tokens added to a bucket each second. Without a rate, a client only ever gets one bucket of tokens
+	Rate float64
+	// the most tokens a bucket can hold
+	Burst float64
+	// Key identifies the client that sent the request. Every client shares one bucket if it is nil.
+	Key func(ctx context.Context) string
+
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	// the last time the full buckets were forgotten
+	swept time.Time
+	now   func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewTokenBucketRateLimiter returns a TokenBucketRateLimiter with the designated rate and burst
+func NewTokenBucketRateLimiter(rate float64, burst float64, key func(ctx context.Context) string) *TokenBucketRateLimiter {
+	return &TokenBucketRateLimiter{
+		Rate:  rate,
+		Burst: burst,
+		Key:   key,
+	}
+}
+
+// Allow takes the cost of the query from the bucket of the client. If there aren't enough tokens, a
+// RateLimitError is returned with the time until there will be. A query that costs more than Burst gets
+// a QueryCostError instead.
+func (l *TokenBucketRateLimiter) Allow(ctx context.Context, cost QueryCost) error {
+	key := ""
+	if l.Key != nil {
+		key = l.Key(ctx)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = map[string]*tokenBucket{}
+	}
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+
+	// a query that doesn't fit in a full bucket will never be allowed
+	total := float64(cost.Total())
+	if total > l.Burst {
+		return &QueryCostError{Cost: total, Limit: l.Burst}
+	}
+
+	l.sweep(now)
+
+	// a client we haven't seen before starts with a full bucket
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.Burst, updated: now}
+		l.buckets[key] = bucket
+	}
+
+	// refill the bucket for the time that has passed since we last looked at it
+	bucket.tokens = math.Min(l.Burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.Rate)
+	bucket.updated = now
+
+	if total > bucket.tokens {
+		// without a rate, the bucket never fills back up so there's no point in trying again
+		if l.Rate <= 0 {
+			return &QueryCostError{Cost: total, Limit: bucket.tokens}
+		}
+
+		return &RateLimitError{
+			RetryAfter: time.Duration((total - bucket.tokens) / l.Rate * float64(time.Second)),
+		}
+	}
+
+	bucket.tokens -= total
+	return nil
+}
+
+// sweep forgets the buckets that have filled back up, once every time it takes an empty bucket to fill. The
+// mutex has to be held.
+func (l *TokenBucketRateLimiter) sweep(now time.Time) {
+	// the buckets never fill back up without a rate
+	if l.Rate <= 0 {
+		return
+	}
+	refill := time.Duration(l.Burst / l.Rate * float64(time.Second))
+	if now.Sub(l.swept) < refill {
+		return
+	}
+	l.swept = now
+
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.Rate >= l.Burst {
+			delete(l.buckets, key)
+		}
+	}
+}
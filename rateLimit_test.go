@@ -0,0 +1,183 @@
+package gateway
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryCost(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			favoriteCatPhoto: CatPhoto!
+		}
+
+		type CatPhoto {
+			URL: String!
+		}
+
+		type Query {
+			users: [User!]!
+		}
+	`)
+
+	locations := FieldURLMap{}
+	locations.RegisterURL("Query", "users", "url1")
+	locations.RegisterURL("User", "id", "url1")
+	locations.RegisterURL("User", "favoriteCatPhoto", "url2")
+	locations.RegisterURL("CatPhoto", "URL", "url2")
+
+	plans, err := (&MinQueriesPlanner{}).Plan(&PlanningContext{
+		Query:     `{ allUsers: users { favoriteCatPhoto { URL } } }`,
+		Schema:    schema,
+		Locations: locations,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the second step is executed once for every user
+	assert.Equal(t, QueryCost{
+		Steps:           2,
+		ServiceRequests: map[string]int{"url1": 1, "url2": 1},
+		ListExpansion:   EstimatedListSize - 1,
+	}, queryCost(plans[0]))
+}
+
+type rateLimitClientKey struct{}
+
+func TestGateway_rateLimiter(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+
+	// count the requests that make it to the service
+	var requests int32
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			atomic.AddInt32(&requests, 1)
+			return map[string]interface{}{"value": "hello"}, nil
+		})
+	})
+
+	// every client can send one query a minute
+	limiter := NewTokenBucketRateLimiter(1.0/60, 1, func(ctx context.Context) string {
+		client, _ := ctx.Value(rateLimitClientKey{}).(string)
+		return client
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithQueryerFactory(&factory),
+		WithRateLimiter(limiter),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	execute := func(client string) error {
+		ctx := &RequestContext{
+			Context: context.WithValue(context.Background(), rateLimitClientKey{}, client),
+			Query:   `{ value }`,
+		}
+		plans, err := gateway.GetPlans(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = gateway.Execute(ctx, plans)
+		return err
+	}
+
+	// the first query is fine
+	assert.Nil(t, execute("client1"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// the second one is turned away before it gets to the service
+	err = execute("client1")
+	if assert.IsType(t, graphql.ErrorList{}, err) && assert.Len(t, err.(graphql.ErrorList), 1) {
+		limitErr := err.(graphql.ErrorList)[0].(*graphql.Error)
+		assert.Equal(t, "RATE_LIMITED", limitErr.Extensions["code"])
+		assert.Equal(t, float64(60), limitErr.Extensions["retryAfter"])
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// other clients have their own bucket
+	assert.Nil(t, execute("client2"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestTokenBucketRateLimiter_refill(t *testing.T) {
+	now := time.Now()
+	limiter := NewTokenBucketRateLimiter(1, 5, nil)
+	limiter.now = func() time.Time { return now }
+
+	// a query that costs 4 leaves one token in the bucket
+	cost := QueryCost{Steps: 2, ListExpansion: 2}
+	assert.Nil(t, limiter.Allow(context.Background(), cost))
+
+	// so we have to wait 3 seconds for the next one
+	err := limiter.Allow(context.Background(), cost)
+	if assert.IsType(t, &RateLimitError{}, err) {
+		assert.Equal(t, 3*time.Second, err.(*RateLimitError).RetryAfter)
+	}
+
+	now = now.Add(3 * time.Second)
+	assert.Nil(t, limiter.Allow(context.Background(), cost))
+}
+
+func TestTokenBucketRateLimiter_tooExpensive(t *testing.T) {
+	limiter := NewTokenBucketRateLimiter(1, 5, nil)
+
+	// a query that doesn't fit in a full bucket isn't worth waiting for
+	err := limiter.Allow(context.Background(), QueryCost{Steps: 6})
+	if assert.IsType(t, &QueryCostError{}, err) {
+		assert.Equal(t, float64(6), err.(*QueryCostError).Cost)
+	}
+}
+
+func TestTokenBucketRateLimiter_withoutRate(t *testing.T) {
+	limiter := NewTokenBucketRateLimiter(0, 5, nil)
+
+	// the bucket can be emptied once
+	assert.Nil(t, limiter.Allow(context.Background(), QueryCost{Steps: 4}))
+
+	// but it never fills back up
+	err := limiter.Allow(context.Background(), QueryCost{Steps: 4})
+	if assert.IsType(t, &QueryCostError{}, err) {
+		assert.Equal(t, float64(1), err.(*QueryCostError).Limit)
+	}
+}
+
+func TestTokenBucketRateLimiter_forgetsFullBuckets(t *testing.T) {
+	now := time.Now()
+	limiter := NewTokenBucketRateLimiter(1, 5, func(ctx context.Context) string {
+		return ctx.Value(rateLimitClientKey{}).(string)
+	})
+	limiter.now = func() time.Time { return now }
+	allow := func(client string) error {
+		return limiter.Allow(context.WithValue(context.Background(), rateLimitClientKey{}, client), QueryCost{Steps: 4})
+	}
+
+	assert.Nil(t, allow("client1"))
+	assert.Nil(t, allow("client2"))
+	assert.Len(t, limiter.buckets, 2)
+
+	// once the buckets have filled back up, there's nothing to remember about their clients
+	now = now.Add(5 * time.Second)
+	assert.Nil(t, allow("client3"))
+	assert.Len(t, limiter.buckets, 1)
+
+	// but the ones that are still filling are kept
+	now = now.Add(4 * time.Second)
+	assert.Nil(t, allow("client4"))
+	now = now.Add(time.Second)
+	assert.NotNil(t, allow("client4"))
+	assert.Len(t, limiter.buckets, 1)
+	assert.Contains(t, limiter.buckets, "client4")
+}
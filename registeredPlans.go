@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// The plans of the operations that get the most traffic can be built ahead of time, written with
+// json.Marshal, and registered when the gateway starts:
+//
+//	plans := gateway.QueryPlanList{}
+//	json.Unmarshal(compiled, &plans)
+//	gw.RegisterPlan("GetUsers", plans)
+//
+// A request that comes with the id of a registered plan (RequestContext.OperationID, or the operationId
+// extension over HTTP) skips the planner entirely. The operation of the plan is checked against the
+// schema when it's registered so a plan that was built for a different schema is turned down instead of
+// failing at execution time. Registered plans are not rebuilt when the schema is reloaded.
+
+// ErrUnknownOperationID is returned when a request asks for a plan that was never registered and didn't
+// send a query to plan instead
+var ErrUnknownOperationID = errors.New("could not find a plan for the operation id")
+
+// RegisterPlan makes the plans available to the requests with the designated operation id. The steps of
+// the plans are given the queryers of their services.
+func (g *Gateway) RegisterPlan(operationID string, plans []*QueryPlan) error {
+	if operationID == "" {
+		return errors.New("plans must be registered under an operation id")
+	}
+	if len(plans) == 0 {
+		return errors.New("no plans to register")
+	}
+
+	ctx := g.planningContext("")
+	for _, plan := range plans {
+		if err := g.bindPlan(ctx, plan); err != nil {
+			return fmt.Errorf("could not register the plans of %s: %s", operationID, err.Error())
+		}
+	}
+
+	g.registeredPlansMutex.Lock()
+	defer g.registeredPlansMutex.Unlock()
+
+	if g.registeredPlans == nil {
+		g.registeredPlans = map[string]QueryPlanList{}
+	}
+	g.registeredPlans[operationID] = plans
+
+	return nil
+}
+
+// registeredPlan returns the plans registered under the operation id
+func (g *Gateway) registeredPlan(operationID string) (QueryPlanList, bool) {
+	g.registeredPlansMutex.RLock()
+	defer g.registeredPlansMutex.RUnlock()
+
+	plans, ok := g.registeredPlans[operationID]
+	return plans, ok
+}
+
+// queryerGetter is implemented by the planners that know how to reach a service
+type queryerGetter interface {
+	GetQueryer(ctx *PlanningContext, url string) graphql.Queryer
+}
+
+// bindPlan prepares a plan that was read from JSON for execution by the gateway
+func (g *Gateway) bindPlan(ctx *PlanningContext, plan *QueryPlan) error {
+	if plan == nil || plan.Operation == nil || plan.RootStep == nil {
+		return errors.New("the plan is missing its operation")
+	}
+
+	// the operation has to fit the schema. this also tells the fields about their definitions
+	if errs := validateQueryDocument(ctx.Schema, &ast.QueryDocument{
+		Operations: ast.OperationList{plan.Operation},
+		Fragments:  plan.FragmentDefinitions,
+	}); len(errs) > 0 {
+		return errs
+	}
+
+	// the steps find their queryers the same way they would have if they were just planned
+	var getter queryerGetter = &Planner{}
+	if planner, ok := g.planner.(queryerGetter); ok {
+		getter = planner
+	}
+
+	var bind func(step *QueryPlanStep)
+	bind = func(step *QueryPlanStep) {
+		if step.Location != "" {
+			step.Queryer = getter.GetQueryer(ctx, step.Location)
+		}
+		bindSelectionSet(ctx.Schema, step.ParentType, step.SelectionSet, step.FragmentDefinitions, Set{})
+		for _, dependent := range step.Then {
+			bind(dependent)
+		}
+	}
+	bind(plan.RootStep)
+	g.preparePlan(plan)
+
+	return nil
+}
+
+// bindSelectionSet points the fields of the selection set at their definitions in the schema
+func bindSelectionSet(schema *ast.Schema, parentType string, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList, visited Set) {
+	parent := schema.Types[parentType]
+
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if parent == nil {
+				continue
+			}
+			selection.ObjectDefinition = parent
+			if definition := parent.Fields.ForName(selection.Name); definition != nil {
+				selection.Definition = definition
+				bindSelectionSet(schema, definition.Type.Name(), selection.SelectionSet, fragments, visited)
+			}
+		case *ast.InlineFragment:
+			typeCondition := selection.TypeCondition
+			if typeCondition == "" {
+				typeCondition = parentType
+			}
+			selection.ObjectDefinition = schema.Types[typeCondition]
+			bindSelectionSet(schema, typeCondition, selection.SelectionSet, fragments, visited)
+		case *ast.FragmentSpread:
+			definition := fragments.ForName(selection.Name)
+			if definition == nil {
+				continue
+			}
+			selection.Definition = definition
+			selection.ObjectDefinition = schema.Types[definition.TypeCondition]
+
+			// a fragment only has to be bound once
+			if !visited.Has(selection.Name) {
+				visited.Add(selection.Name)
+				bindSelectionSet(schema, definition.TypeCondition, definition.SelectionSet, fragments, visited)
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// When a popular page loads, the gateway can get the same query with the same variables from hundreds of clients
+// at once and each of them would be sent to the services. With request collapsing, the requests that are executed
+// while an identical one is already running wait for it and share its response instead:
+//
+//	gateway.New(sources, gateway.WithRequestCollapsing(func(ctx context.Context, query string, variables map[string]interface{}) (string, bool) {
+//		// the personalized queries can't be shared
+//		if user := ctx.Value(userKey{}); user != nil {
+//			return "", false
+//		}
+//		return query, true
+//	}))
+//
+// The key function decides which requests are identical. On top of its key, the requests also have to agree on
+// the variables, the operation, the freshness, the executor, the partial results timeout, and the group of the
+// multiplexer. Only queries are collapsed, mutations and subscriptions are always executed on their own. Every
+// request gets its own copy of the response, the extensions, and the errors, so what a caller does with one
+// doesn't show up in the others. If the request that executed the operation was canceled, the ones that were
+// waiting for it execute it themselves.
+
+// RequestCollapsingKey returns the key that identifies the requests that can share an execution, and false if
+// the request can't be shared with anyone
+type RequestCollapsingKey func(ctx context.Context, query string, variables map[string]interface{}) (string, bool)
+
+// WithRequestCollapsing returns an Option that shares the execution of a query between the identical requests that
+// are executed at the same time
+func WithRequestCollapsing(key RequestCollapsingKey) Option {
+	return func(g *Gateway) {
+		g.requestCollapser = &requestCollapser{key: key, calls: map[string]*collapsedCall{}}
+	}
+}
+
+// RequestCollapsingStats counts the requests that went through request collapsing
+type RequestCollapsingStats struct {
+	// the executions that could have been shared
+	Executions int64 `json:"executions"`
+	// the requests that shared the execution of another one, including the ones still waiting for it
+	Collapsed int64 `json:"collapsed"`
+}
+
+// RequestCollapsingStats returns the counts of the requests that went through request collapsing
+func (g *Gateway) RequestCollapsingStats() RequestCollapsingStats {
+	if g.requestCollapser == nil {
+		return RequestCollapsingStats{}
+	}
+
+	return RequestCollapsingStats{
+		Executions: atomic.LoadInt64(&g.requestCollapser.executions),
+		Collapsed:  atomic.LoadInt64(&g.requestCollapser.collapsed),
+	}
+}
+
+// requestCollapser keeps track of the executions that are running
+type requestCollapser struct {
+	key        RequestCollapsingKey
+	mutex      sync.Mutex
+	calls      map[string]*collapsedCall
+	executions int64
+	collapsed  int64
+}
+
+// collapsedCall is an execution that can be shared. done is closed once the response is available.
+type collapsedCall struct {
+	done       chan struct{}
+	result     map[string]interface{}
+	extensions map[string]interface{}
+	stats      *Stats
+	err        error
+	canceled   bool
+}
+
+// collapsingKey returns the key of the execution that the request can share, and false if it can't be shared
+func (c *requestCollapser) collapsingKey(g *Gateway, ctx *RequestContext, plans QueryPlanList) (string, bool) {
+	// a registered plan doesn't have a query for the key function to look at
+	if ctx.Query == "" {
+		return "", false
+	}
+
+	// only queries are safe to share
+	plan, err := g.planForOperation(ctx, plans)
+	if err != nil || plan.Operation == nil || plan.Operation.Operation != ast.Query {
+		return "", false
+	}
+
+	key, ok := c.key(ctx.Context, ctx.Query, ctx.Variables)
+	if !ok {
+		return "", false
+	}
+
+	// the variables are compared by value since the key function could leave them out
+	variables, err := json.Marshal(ctx.Variables)
+	if err != nil {
+		return "", false
+	}
+
+	return strings.Join([]string{
+		key,
+		string(variables),
+		ctx.OperationName,
+		ctx.Freshness,
+		ctx.Executor,
+		ctx.PartialResultsTimeout.String(),
+		MultiplexerGroup(ctx.Context),
+	}, "\x00"), true
+}
+
+// execute executes the request, unless an identical one is already being executed
+func (c *requestCollapser) execute(ctx *RequestContext, key string, execute func() (map[string]interface{}, error)) (map[string]interface{}, error) {
+	c.mutex.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mutex.Unlock()
+		atomic.AddInt64(&c.collapsed, 1)
+
+		select {
+		case <-call.done:
+		case <-ctx.Context.Done():
+			atomic.AddInt64(&c.collapsed, -1)
+			return nil, ctx.Context.Err()
+		}
+
+		// the execution was given up on by the request that started it, not by us
+		if call.canceled {
+			atomic.AddInt64(&c.collapsed, -1)
+			return execute()
+		}
+
+		return call.response(ctx)
+	}
+
+	call := &collapsedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mutex.Unlock()
+	atomic.AddInt64(&c.executions, 1)
+
+	result, err := execute()
+
+	// the response is copied before the caller gets a chance to change it
+	call.result = collapsedCopy(result)
+	call.extensions = collapsedCopy(ctx.Extensions)
+	call.err = collapsedCopyError(err)
+	call.canceled = err != nil && ctx.Context.Err() != nil
+	if ctx.Stats != nil {
+		stats := *ctx.Stats
+		call.stats = &stats
+	}
+
+	c.mutex.Lock()
+	delete(c.calls, key)
+	c.mutex.Unlock()
+	close(call.done)
+
+	return result, err
+}
+
+// response returns a copy of the response of the call and fills in the request's extensions and stats
+func (c *collapsedCall) response(ctx *RequestContext) (map[string]interface{}, error) {
+	ctx.Extensions = collapsedCopy(c.extensions)
+
+	if c.stats != nil {
+		stats := *c.stats
+		stats.Collapsed = true
+		ctx.Stats = &stats
+
+		// the stats in the extensions are ours, if we asked for them
+		delete(ctx.Extensions, statsExtension)
+		if statsRequested(ctx.Context) {
+			if ctx.Extensions == nil {
+				ctx.Extensions = map[string]interface{}{}
+			}
+			ctx.Extensions[statsExtension] = ctx.Stats
+		}
+	}
+
+	return collapsedCopy(c.result), collapsedCopyError(c.err)
+}
+
+// collapsedCopy returns a deep copy of a response or of its extensions
+func collapsedCopy(value map[string]interface{}) map[string]interface{} {
+	if value == nil {
+		return nil
+	}
+
+	return stepMemoCopy(value).(map[string]interface{})
+}
+
+// collapsedCopyError returns a copy of the error that can be changed without changing the original
+func collapsedCopyError(err error) error {
+	switch err := err.(type) {
+	case graphql.ErrorList:
+		copied := graphql.ErrorList{}
+		for _, entry := range err {
+			copied = append(copied, collapsedCopyError(entry))
+		}
+		return copied
+	case *graphql.Error:
+		copied := *err
+		copied.Path = append([]interface{}{}, err.Path...)
+		copied.Extensions = collapsedCopy(err.Extensions)
+		return &copied
+	default:
+		return err
+	}
+}
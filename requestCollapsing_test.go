@@ -0,0 +1,186 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_requestCollapsing(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			allUsers: [String!]!
+		}
+	`)
+
+	const requests = 50
+
+	// the executor holds on until every other request is waiting for it
+	var executions int64
+	var gateway *Gateway
+	executor := ExecutorFunc(func(ctx *ExecutionContext) (map[string]interface{}, error) {
+		atomic.AddInt64(&executions, 1)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for gateway.RequestCollapsingStats().Collapsed < requests-1 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+
+		return map[string]interface{}{"allUsers": []interface{}{"alec"}}, nil
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithExecutor(executor),
+		WithRequestCollapsing(func(ctx context.Context, query string, variables map[string]interface{}) (string, bool) {
+			return query, true
+		}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// every request changes its own response once it has it
+	seen := make([]interface{}, requests)
+	collapsed := int64(0)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ctx := &RequestContext{Context: context.Background(), Query: `{ allUsers }`}
+			plans, err := gateway.GetPlans(ctx)
+			if !assert.Nil(t, err) {
+				return
+			}
+			result, err := gateway.Execute(ctx, plans)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			users := result["allUsers"].([]interface{})
+			seen[i] = users[0]
+			users[0] = "changed"
+			if ctx.Stats.Collapsed {
+				atomic.AddInt64(&collapsed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&executions))
+	assert.Equal(t, int64(requests-1), collapsed)
+	assert.Equal(t, RequestCollapsingStats{Executions: 1, Collapsed: requests - 1}, gateway.RequestCollapsingStats())
+	for _, value := range seen {
+		assert.Equal(t, "alec", value)
+	}
+
+	// the next request doesn't get an old response
+	ctx := &RequestContext{Context: context.Background(), Query: `{ allUsers }`}
+	plans, _ := gateway.GetPlans(ctx)
+	_, err = gateway.Execute(ctx, plans)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&executions))
+}
+
+func TestRequestCollapsingKey(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			user(id: ID!): String
+		}
+
+		type Mutation {
+			logIn: String
+		}
+	`)
+
+	type userKey struct{}
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithRequestCollapsing(func(ctx context.Context, query string, variables map[string]interface{}) (string, bool) {
+			// the personalized queries are left out
+			return query, ctx.Value(userKey{}) == nil
+		}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	key := func(ctx *RequestContext) (string, bool) {
+		if ctx.Context == nil {
+			ctx.Context = context.Background()
+		}
+		plans, err := gateway.GetPlans(ctx)
+		if !assert.Nil(t, err) {
+			return "", false
+		}
+		return gateway.requestCollapser.collapsingKey(gateway, ctx, plans)
+	}
+
+	query := `query($id: ID!) { user(id: $id) }`
+	first, ok := key(&RequestContext{Query: query, Variables: map[string]interface{}{"id": "1"}})
+	assert.True(t, ok)
+	second, ok := key(&RequestContext{Query: query, Variables: map[string]interface{}{"id": "1"}})
+	assert.True(t, ok)
+	assert.Equal(t, first, second)
+
+	// other variables are another execution
+	other, ok := key(&RequestContext{Query: query, Variables: map[string]interface{}{"id": "2"}})
+	assert.True(t, ok)
+	assert.NotEqual(t, first, other)
+
+	// mutations are never shared
+	_, ok = key(&RequestContext{Query: `mutation { logIn }`})
+	assert.False(t, ok)
+
+	// and neither is what the key function leaves out
+	_, ok = key(&RequestContext{
+		Context:   context.WithValue(context.Background(), userKey{}, "alec"),
+		Query:     query,
+		Variables: map[string]interface{}{"id": "1"},
+	})
+	assert.False(t, ok)
+}
+
+func TestRequestCollapser_canceledLeader(t *testing.T) {
+	collapser := &requestCollapser{calls: map[string]*collapsedCall{}}
+
+	// the first request is canceled while the second one waits for it
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		ctx := &RequestContext{Context: leaderCtx}
+		_, _ = collapser.execute(ctx, "key", func() (map[string]interface{}, error) {
+			close(started)
+			<-leaderCtx.Done()
+			return nil, errors.New("canceled")
+		})
+	}()
+	<-started
+
+	followerDone := make(chan map[string]interface{})
+	go func() {
+		result, _ := collapser.execute(&RequestContext{Context: context.Background()}, "key", func() (map[string]interface{}, error) {
+			return map[string]interface{}{"ran": true}, nil
+		})
+		followerDone <- result
+	}()
+
+	// wait for the follower to join before the leader gives up
+	for atomic.LoadInt64(&collapser.collapsed) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-leaderDone
+
+	// the follower executed the request itself
+	assert.Equal(t, map[string]interface{}{"ran": true}, <-followerDone)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&collapser.collapsed))
+}
@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nautilus/graphql"
+)
+
+// The queryers of a plan are shared by every request that uses the plan once it's cached, so they can't be
+// given the middlewares of one request (the name of its operation, its extensions, ...) without the others
+// seeing them too. Instead, the queryers are given a single middleware when their plan is built, and that
+// middleware runs the ones that the executor put in the context of the request to the service.
+
+// requestMiddlewaresKey is the key of the network middlewares of a request to a service in its context
+type requestMiddlewaresKey struct{}
+
+// withRequestMiddlewares returns a context whose requests to the services go through the middlewares
+func withRequestMiddlewares(ctx context.Context, middlewares []graphql.NetworkMiddleware) context.Context {
+	if len(middlewares) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, requestMiddlewaresKey{}, middlewares)
+}
+
+// requestNetworkMiddleware runs the middlewares in the context of the request
+func requestNetworkMiddleware(r *http.Request) error {
+	middlewares, _ := r.Context().Value(requestMiddlewaresKey{}).([]graphql.NetworkMiddleware)
+	for _, middleware := range middlewares {
+		if err := middleware(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prepareQueryer returns the queryer with the middlewares that read the deadline and run the middlewares of
+// each request, and the client of the credentials of the service if they live in one
+func prepareQueryer(queryer graphql.Queryer, provider CredentialProvider) graphql.Queryer {
+	if nQueryer, ok := queryer.(graphql.QueryerWithMiddlewares); ok {
+		queryer = nQueryer.WithMiddlewares([]graphql.NetworkMiddleware{deadlineMiddleware, requestNetworkMiddleware})
+	}
+
+	if clientProvider, ok := provider.(ClientCredentialProvider); ok {
+		if hQueryer, ok := queryer.(graphql.HTTPQueryer); ok {
+			queryer = hQueryer.WithHTTPClient(clientProvider.HTTPClient())
+		}
+	}
+
+	return queryer
+}
+
+// preparePlan gets the queryers of a plan that was just built ready for the middlewares of the requests
+func (g *Gateway) preparePlan(plan *QueryPlan) {
+	var prepare func(step *QueryPlanStep)
+	prepare = func(step *QueryPlanStep) {
+		// the queryers that were configured for a service are shared by every plan so they were prepared once
+		if _, shared := g.serviceQueryer(step.Location); step.Queryer != nil && step.Location != internalSchemaLocation && !shared {
+			step.Queryer = prepareQueryer(step.Queryer, g.credentials[step.Location])
+		}
+		for _, dependent := range step.Then {
+			prepare(dependent)
+		}
+	}
+
+	if plan != nil && plan.RootStep != nil {
+		prepare(plan.RootStep)
+	}
+}
+
+// preparedPlanner is a QueryPlanner whose plans are ready for the middlewares of the requests
+type preparedPlanner struct {
+	QueryPlanner
+	gateway *Gateway
+}
+
+func (p *preparedPlanner) Plan(ctx *PlanningContext) (QueryPlanList, error) {
+	plans, err := p.QueryPlanner.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, plan := range plans {
+		p.gateway.preparePlan(plan)
+	}
+
+	return plans, nil
+}
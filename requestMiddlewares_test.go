@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_requestMiddlewaresAreNotShared(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			operation: String!
+		}
+	`)
+
+	// the service answers with the name of the operation it was told about
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data": {"operation": %q}}`, r.Header.Get(DefaultParentOperationHeader))
+	}))
+	defer service.Close()
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: service.URL}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// every request uses the same plans, and the same queryers, at the same time like they would if the plans
+	// were cached
+	query := `query First { operation } query Second { operation }`
+	plans, err := gateway.GetPlans(&RequestContext{Context: context.Background(), Query: query})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		operationName := []string{"First", "Second"}[i%2]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx := &RequestContext{Context: context.Background(), Query: query, OperationName: operationName}
+			result, err := gateway.Execute(ctx, plans)
+			if assert.Nil(t, err) {
+				assert.Equal(t, map[string]interface{}{"operation": operationName}, result)
+			}
+		}()
+	}
+	wg.Wait()
+}
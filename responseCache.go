@@ -0,0 +1,468 @@
+package gateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Response caching allows the gateway to skip the execution of a query plan entirely when
+// an equivalent query has been answered recently. Whether a response can be cached (and for
+// how long) is computed per query from max-age hints attached to the types and fields that
+// were selected. Hints can either be configured at the gateway with WithCacheHints or read
+// from an @cacheControl(maxAge: Int, scope: CacheControlScope) directive in the downstream
+// schemas. The smallest max-age across the selected fields wins.
+
+// CacheScope designates who is allowed to share a cached response
+type CacheScope string
+
+const (
+	// CacheScopePublic responses can be shared between every user of the gateway
+	CacheScopePublic CacheScope = "PUBLIC"
+	// CacheScopePrivate responses can only be shared between requests with the same scope key
+	CacheScopePrivate CacheScope = "PRIVATE"
+)
+
+// CachePolicy describes how long and for whom a response can be cached
+type CachePolicy struct {
+	MaxAge time.Duration
+	Scope  CacheScope
+}
+
+// CacheHint assigns a cache policy to a type or to a specific field of a type. If FieldName
+// is empty, the hint applies to every field that returns the type.
+type CacheHint struct {
+	TypeName  string
+	FieldName string
+	MaxAge    time.Duration
+	Scope     CacheScope
+}
+
+// CacheStore holds serialized responses until they expire. Implementations must be safe for
+// concurrent use.
+type CacheStore interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// CacheScopeFunc returns the key identifying the scope of a request (ie, the id of the current user)
+// and is used to partition PRIVATE responses.
+type CacheScopeFunc func(ctx context.Context) string
+
+// WithResponseCache returns an Option that enables the response cache using the given store. The provided
+// policy is used for root fields that do not have a more specific hint.
+func WithResponseCache(store CacheStore, defaults CachePolicy) Option {
+	return func(g *Gateway) {
+		g.responseCache = &responseCache{
+			store:    store,
+			defaults: defaults,
+		}
+	}
+}
+
+// WithCacheHints returns an Option that adds cache hints for specific types and fields
+func WithCacheHints(hints ...*CacheHint) Option {
+	return func(g *Gateway) {
+		g.cacheHints = append(g.cacheHints, hints...)
+	}
+}
+
+// WithCacheScope returns an Option that sets the function used to compute the scope of private responses
+func WithCacheScope(scope CacheScopeFunc) Option {
+	return func(g *Gateway) {
+		g.cacheScope = scope
+	}
+}
+
+// responseCache holds the configuration of the response cache once the gateway has been built
+type responseCache struct {
+	store    CacheStore
+	defaults CachePolicy
+	scope    CacheScopeFunc
+}
+
+// indexCacheHints indexes the hints provided by the user and the ones found in the schema by "Type"
+// and "Type.field"
+func indexCacheHints(schema *ast.Schema, hints []*CacheHint) map[string]*CacheHint {
+	index := map[string]*CacheHint{}
+
+	// the schema might define hints with the @cacheControl directive
+	for _, definition := range schema.Types {
+		if hint := cacheHintFromDirectives(definition.Directives); hint != nil {
+			hint.TypeName = definition.Name
+			index[definition.Name] = hint
+		}
+
+		for _, field := range definition.Fields {
+			if hint := cacheHintFromDirectives(field.Directives); hint != nil {
+				hint.TypeName = definition.Name
+				hint.FieldName = field.Name
+				index[definition.Name+"."+field.Name] = hint
+			}
+		}
+	}
+
+	// hints configured at the gateway take precedence over the schema
+	for _, hint := range hints {
+		key := hint.TypeName
+		if hint.FieldName != "" {
+			key = fmt.Sprintf("%s.%s", hint.TypeName, hint.FieldName)
+		}
+
+		index[key] = hint
+	}
+
+	return index
+}
+
+// cacheHintFromDirectives looks for a @cacheControl directive in the list
+func cacheHintFromDirectives(directives ast.DirectiveList) *CacheHint {
+	directive := directives.ForName("cacheControl")
+	if directive == nil {
+		return nil
+	}
+
+	hint := &CacheHint{}
+	if maxAge := directive.Arguments.ForName("maxAge"); maxAge != nil && maxAge.Value != nil {
+		seconds, err := strconv.Atoi(maxAge.Value.Raw)
+		if err == nil {
+			hint.MaxAge = time.Duration(seconds) * time.Second
+		}
+	}
+	if scope := directive.Arguments.ForName("scope"); scope != nil && scope.Value != nil {
+		hint.Scope = CacheScope(scope.Value.Raw)
+	}
+
+	return hint
+}
+
+// policyFor computes the cache policy of the operation described by the plan. A zero max-age
+// means that the response must not be cached.
+func (c *responseCache) policyFor(schema *ast.Schema, hints map[string]*CacheHint, plan *QueryPlan) (CachePolicy, error) {
+	// mutations and subscriptions are never cached
+	if plan.Operation.Operation != ast.Query {
+		return CachePolicy{}, nil
+	}
+
+	policy := &CachePolicy{MaxAge: -1, Scope: CacheScopePublic}
+	if err := c.walkPolicy(schema, hints, policy, rootTypeName(schema, plan.Operation.Operation), plan.Operation.SelectionSet, plan.FragmentDefinitions, &c.defaults); err != nil {
+		return CachePolicy{}, err
+	}
+
+	// if we didn't visit anything there's nothing to cache
+	if policy.MaxAge < 0 {
+		return CachePolicy{}, nil
+	}
+
+	return *policy, nil
+}
+
+func (c *responseCache) walkPolicy(schema *ast.Schema, hints map[string]*CacheHint, acc *CachePolicy, parentType string, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList, inherited *CachePolicy) error {
+	for _, field := range graphql.SelectedFields(collectSelectedFields(selectionSet, fragments)) {
+		// introspection fields don't affect the policy
+		if field.Name == "__typename" {
+			continue
+		}
+
+		// fields without a hint inherit the policy of their parent
+		policy := *inherited
+
+		// a hint on the type that the field returns
+		if field.Definition != nil {
+			if hint, ok := hints[field.Definition.Type.Name()]; ok {
+				policy = policyFromHint(hint, policy)
+			}
+		}
+
+		// a hint on the field itself takes precedence over the type
+		if hint, ok := hints[fmt.Sprintf("%s.%s", parentType, field.Name)]; ok {
+			policy = policyFromHint(hint, policy)
+		}
+
+		// the aggregate policy is the most restrictive one we have seen
+		if acc.MaxAge < 0 || policy.MaxAge < acc.MaxAge {
+			acc.MaxAge = policy.MaxAge
+		}
+		if policy.Scope == CacheScopePrivate {
+			acc.Scope = CacheScopePrivate
+		}
+
+		// visit the fields underneath this one
+		if len(field.SelectionSet) > 0 && field.Definition != nil {
+			if err := c.walkPolicy(schema, hints, acc, field.Definition.Type.Name(), field.SelectionSet, fragments, &policy); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func policyFromHint(hint *CacheHint, fallback CachePolicy) CachePolicy {
+	policy := CachePolicy{MaxAge: hint.MaxAge, Scope: hint.Scope}
+	if policy.Scope == "" {
+		policy.Scope = fallback.Scope
+	}
+	return policy
+}
+
+// keyFor computes the key that identifies the response for the operation in the store
+func (c *responseCache) keyFor(ctx *RequestContext, plan *QueryPlan, policy CachePolicy) (string, error) {
+	// normalize the query by printing the operation we are going to execute
+	query, err := graphql.PrintQuery(&ast.QueryDocument{
+		Operations: ast.OperationList{plan.Operation},
+		Fragments:  plan.FragmentDefinitions,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// encoding/json sorts map keys so equivalent variables result in the same string
+	variables, err := json.Marshal(ctx.Variables)
+	if err != nil {
+		return "", err
+	}
+
+	// private responses are only shared within the same scope
+	scope := ""
+	if policy.Scope == CacheScopePrivate {
+		if c.scope == nil {
+			return "", nil
+		}
+		scope = c.scope(ctx.Context)
+		if scope == "" {
+			return "", nil
+		}
+	}
+
+	key := query + "|" + string(variables) + "|" + scope
+
+	// the groups of a multiplexer can share a store without seeing each other's responses
+	if group := MultiplexerGroup(ctx.Context); group != "" {
+		key = group + "|" + key
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// executeWithCache executes the plans, using the response cache to avoid the work if possible. The
+// returned policy reflects how long the response can be cached for. Callers are responsible for
+// tracking the operation for Shutdown.
+func (g *Gateway) executeWithCache(ctx *RequestContext, plans QueryPlanList) (map[string]interface{}, CachePolicy, error) {
+	// if there is no response cache then just execute the plan
+	if g.responseCache == nil {
+		result, err := g.execute(ctx, plans)
+		return result, CachePolicy{}, err
+	}
+
+	// figure out the plan we are going to execute
+	plan, err := g.planForOperation(ctx, plans)
+	if err != nil {
+		return nil, CachePolicy{}, err
+	}
+
+	// compute the policy for the operation against the schema it was planned with
+	g.schemaMutex.RLock()
+	schema, hints := g.schema, g.cacheHintIndex
+	g.schemaMutex.RUnlock()
+	if ctx.plannedSchema != nil {
+		schema = ctx.plannedSchema
+	}
+	policy, err := g.responseCache.policyFor(schema, hints, plan)
+	if err != nil {
+		return nil, CachePolicy{}, err
+	}
+
+	// if the response can be cached we need to look for it in the store
+	key := ""
+	if policy.MaxAge > 0 {
+		key, err = g.responseCache.keyFor(ctx, plan, policy)
+		if err != nil {
+			return nil, CachePolicy{}, err
+		}
+
+		// a private response without a scope can't be cached
+		if key == "" {
+			policy = CachePolicy{}
+		}
+	}
+
+	// if we have a key, look for the response
+	if key != "" {
+		cached, ok, err := g.responseCache.store.Get(ctx.Context, key)
+		if err != nil {
+			log.Warn("Could not read from response cache: ", err)
+		} else if ok {
+			result := map[string]interface{}{}
+			if err := unmarshalJSON(cached, &result); err == nil {
+				g.debug.responseCacheLookup(true)
+
+				// nothing was sent to the services
+				ctx.Stats = newStatsRecorder(plan).stats(ctx.planCacheHit)
+				ctx.Stats.ResponseCacheHit = true
+				if statsRequested(ctx.Context) {
+					ctx.Extensions = map[string]interface{}{statsExtension: ctx.Stats}
+				}
+
+				return result, policy, nil
+			}
+		}
+	}
+
+	// we don't have a cached value so we have to execute the plan
+	if key != "" {
+		g.debug.responseCacheLookup(false)
+	}
+	result, err := g.execute(ctx, plans)
+	if err != nil {
+		// responses with errors are never cached
+		return result, CachePolicy{}, err
+	}
+
+	// save the result for next time
+	if key != "" {
+		serialized, err := json.Marshal(result)
+		if err == nil {
+			err = g.responseCache.store.Set(ctx.Context, key, serialized, policy.MaxAge)
+		}
+		if err != nil {
+			log.Warn("Could not write to response cache: ", err)
+		}
+	}
+
+	return result, policy, nil
+}
+
+// restrictCachePolicy returns the most restrictive combination of the 2 policies. An operation without a policy
+// (ie, one that failed) can't be cached.
+func restrictCachePolicy(current *CachePolicy, next *CachePolicy) *CachePolicy {
+	policy := CachePolicy{}
+	if next != nil {
+		policy = *next
+	}
+
+	// if this is the first policy we've seen, use it
+	if current == nil {
+		return &policy
+	}
+
+	result := *current
+	if policy.MaxAge < result.MaxAge {
+		result.MaxAge = policy.MaxAge
+	}
+	if policy.Scope == CacheScopePrivate {
+		result.Scope = CacheScopePrivate
+	}
+
+	return &result
+}
+
+// cacheControlHeader returns the value of the Cache-Control header that reflects the policy
+func cacheControlHeader(policy CachePolicy) string {
+	seconds := int(policy.MaxAge / time.Second)
+	if seconds <= 0 {
+		return "no-store"
+	}
+
+	if policy.Scope == CacheScopePrivate {
+		return fmt.Sprintf("max-age=%d, private", seconds)
+	}
+	return fmt.Sprintf("max-age=%d, public", seconds)
+}
+
+// InMemoryCacheStore is a CacheStore that keeps entries in a map until they expire. The entries that expire
+// without being read again are cleaned up by the next Set once a minute has gone by since the last time.
+type InMemoryCacheStore struct {
+	entries map[string]*inMemoryCacheEntry
+	mutex   sync.RWMutex
+	// the last time the expired entries were cleaned up
+	swept time.Time
+}
+
+// inMemoryCacheSweepInterval is how often the expired entries of an InMemoryCacheStore are cleaned up
+const inMemoryCacheSweepInterval = time.Minute
+
+type inMemoryCacheEntry struct {
+	Value     []byte
+	ExpiresAt time.Time
+}
+
+// NewInMemoryCacheStore returns a fresh instance of InMemoryCacheStore
+func NewInMemoryCacheStore() *InMemoryCacheStore {
+	return &InMemoryCacheStore{
+		entries: map[string]*inMemoryCacheEntry{},
+	}
+}
+
+// Get returns the value stored under key if it has not expired
+func (s *InMemoryCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mutex.RLock()
+	entry, ok := s.entries[key]
+	s.mutex.RUnlock()
+
+	// if we don't have an entry
+	if !ok {
+		return nil, false, nil
+	}
+
+	// if the entry has expired, clean it up
+	if time.Now().After(entry.ExpiresAt) {
+		s.mutex.Lock()
+		// the entry could have been replaced since we looked
+		if current, ok := s.entries[key]; ok && time.Now().After(current.ExpiresAt) {
+			delete(s.entries, key)
+		}
+		s.mutex.Unlock()
+
+		return nil, false, nil
+	}
+
+	return entry.Value, true, nil
+}
+
+// Len returns the number of entries in the store, including the ones that expired but haven't been cleaned up
+func (s *InMemoryCacheStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.entries)
+}
+
+// Set saves the value under key for the designated duration
+func (s *InMemoryCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	s.entries[key] = &inMemoryCacheEntry{
+		Value:     value,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	return nil
+}
+
+// sweep forgets the entries that have expired, once every inMemoryCacheSweepInterval. The mutex has to be held.
+func (s *InMemoryCacheStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < inMemoryCacheSweepInterval {
+		return
+	}
+	s.swept = now
+
+	for key, entry := range s.entries {
+		if now.After(entry.ExpiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+type testUserKey struct{}
+
+func TestResponseCache_policy(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Product {
+			name: String!
+			price: Int! @cacheControl(maxAge: 30)
+		}
+
+		type Query {
+			products: [Product!]!
+			me: String! @cacheControl(maxAge: 60, scope: PRIVATE)
+		}
+
+		directive @cacheControl(maxAge: Int, scope: CacheControlScope) on FIELD_DEFINITION | OBJECT
+		enum CacheControlScope {
+			PUBLIC
+			PRIVATE
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithResponseCache(NewInMemoryCacheStore(), CachePolicy{MaxAge: 2 * time.Minute}),
+		WithCacheHints(&CacheHint{TypeName: "Product", FieldName: "name", MaxAge: 10 * time.Second}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	table := []struct {
+		query    string
+		expected CachePolicy
+	}{
+		{`{ products { name } }`, CachePolicy{MaxAge: 10 * time.Second, Scope: CacheScopePublic}},
+		{`{ products { price } }`, CachePolicy{MaxAge: 30 * time.Second, Scope: CacheScopePublic}},
+		{`{ products { __typename } }`, CachePolicy{MaxAge: 2 * time.Minute, Scope: CacheScopePublic}},
+		{`{ me }`, CachePolicy{MaxAge: time.Minute, Scope: CacheScopePrivate}},
+	}
+
+	for _, row := range table {
+		plans, err := gateway.GetPlans(&RequestContext{Context: context.Background(), Query: row.query})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		policy, err := gateway.responseCache.policyFor(gateway.schema, gateway.cacheHintIndex, plans[0])
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, row.expected, policy, row.query)
+	}
+}
+
+func TestResponseCache_handler(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value: String!
+			me: String!
+		}
+
+		type Mutation {
+			update: String!
+		}
+	`)
+
+	// count the number of times we execute a plan
+	count := 0
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithResponseCache(NewInMemoryCacheStore(), CachePolicy{MaxAge: time.Minute}),
+		WithCacheHints(&CacheHint{TypeName: "Query", FieldName: "me", MaxAge: time.Minute, Scope: CacheScopePrivate}),
+		WithCacheScope(func(ctx context.Context) string {
+			user, _ := ctx.Value(testUserKey{}).(string)
+			return user
+		}),
+		WithExecutor(ExecutorFunc(func(*ExecutionContext) (map[string]interface{}, error) {
+			count++
+			return map[string]interface{}{"value": "hello"}, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	send := func(query string, user string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/graphql", strings.NewReader(query))
+		request = request.WithContext(context.WithValue(request.Context(), testUserKey{}, user))
+		responseRecorder := httptest.NewRecorder()
+
+		gateway.GraphQLHandler(responseRecorder, request)
+		return responseRecorder
+	}
+
+	t.Run("Public queries", func(t *testing.T) {
+		count = 0
+
+		first := send(`{"query": "{ value }"}`, "")
+		second := send(`{"query": "{ value }"}`, "")
+
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, "max-age=60, public", second.Header().Get("Cache-Control"))
+		assert.Equal(t, 1, count)
+
+		// different variables result in a different key
+		send(`{"query": "{ value }", "variables": {"foo": 1}}`, "")
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("Private queries", func(t *testing.T) {
+		count = 0
+
+		send(`{"query": "{ me }"}`, "1")
+		send(`{"query": "{ me }"}`, "1")
+		response := send(`{"query": "{ me }"}`, "2")
+
+		assert.Equal(t, "max-age=60, private", response.Header().Get("Cache-Control"))
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("Mutations", func(t *testing.T) {
+		count = 0
+
+		send(`{"query": "mutation { update }"}`, "")
+		response := send(`{"query": "mutation { update }"}`, "")
+
+		assert.Equal(t, "no-store", response.Header().Get("Cache-Control"))
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("Batches with a failed operation", func(t *testing.T) {
+		// the failed operation can't be cached so neither can the batch
+		response := send(`[{"query": "{ value }"}, {"query": "{ missing }"}]`, "")
+
+		assert.Equal(t, "no-store", response.Header().Get("Cache-Control"))
+	})
+}
+
+func TestInMemoryCacheStore(t *testing.T) {
+	store := NewInMemoryCacheStore()
+
+	// save a value that expires right away and one that sticks around
+	assert.Nil(t, store.Set(context.Background(), "expired", []byte("1"), -time.Second))
+	assert.Nil(t, store.Set(context.Background(), "fresh", []byte("2"), time.Minute))
+
+	_, ok, err := store.Get(context.Background(), "expired")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	value, ok, err := store.Get(context.Background(), "fresh")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("2"), value)
+
+	// the entries that expire without being read again are cleaned up by a later Set
+	assert.Nil(t, store.Set(context.Background(), "forgotten", []byte("3"), -time.Second))
+	assert.Equal(t, 2, store.Len())
+
+	store.swept = time.Now().Add(-inMemoryCacheSweepInterval)
+	assert.Nil(t, store.Set(context.Background(), "later", []byte("4"), time.Minute))
+	assert.Equal(t, 2, store.Len())
+	_, ok, _ = store.Get(context.Background(), "later")
+	assert.True(t, ok)
+}
@@ -0,0 +1,380 @@
+package gateway
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// The gateway trusts the services to send back values that fit the schema. When one doesn't (an Int that
+// comes back as 1.5, an enum value that was never declared, a null where the schema promised there wouldn't
+// be one) the client is the first to find out. With strict response validation, the gateway walks the
+// stitched response along with the operation and checks every value against the schema before it's sent:
+//
+//	gateway.New(sources, gateway.WithStrictResponseValidation())
+//
+// Every value that doesn't fit is reported as an error with its path and the url of the service that sent
+// it. By default the value is replaced with null and the rest of the response is left alone. With
+// ResponseValidationPropagate, the null goes up to the closest field that can be null, the same way it
+// would if the service itself had failed to resolve the field. A field that can't be null and is missing from
+// an object is reported too, unless it was selected in a fragment for another type (told apart with the
+// __typename of the object) or its directives left it out of the response.
+//
+// Walking the whole response isn't free so validation is off unless it's turned on.
+
+// ResponseValidationMode decides what happens to the values that don't fit the schema
+type ResponseValidationMode int
+
+const (
+	// ResponseValidationNullify replaces the value with null, even if the field can't be null
+	ResponseValidationNullify ResponseValidationMode = iota
+	// ResponseValidationPropagate replaces the value with null and propagates the null up to the closest field
+	// that can be null
+	ResponseValidationPropagate
+)
+
+// WithStrictResponseValidation returns an Option that checks the responses of the services against the schema
+func WithStrictResponseValidation() Option {
+	return func(g *Gateway) {
+		g.responseValidation = true
+	}
+}
+
+// WithStrictResponseValidationMode returns an Option that checks the responses of the services against the
+// schema and deals with the values that don't fit in the designated way
+func WithStrictResponseValidationMode(mode ResponseValidationMode) Option {
+	return func(g *Gateway) {
+		g.responseValidation = true
+		g.responseValidationMode = mode
+	}
+}
+
+// validateResponse checks the result against the schema and returns what's left of it with the errors that
+// were found. The result is nil if a null made it all the way to the top.
+func (g *Gateway) validateResponse(ctx *ExecutionContext, result map[string]interface{}) (map[string]interface{}, graphql.ErrorList) {
+	if ctx.Plan == nil || ctx.Plan.Operation == nil {
+		return result, nil
+	}
+
+	g.schemaMutex.RLock()
+	schema := g.schema
+	g.schemaMutex.RUnlock()
+
+	validator := &responseValidator{
+		schema:    schema,
+		fragments: ctx.Plan.FragmentDefinitions,
+		variables: ctx.Variables,
+		owners:    responseValidationOwners(ctx.Plan),
+		propagate: g.responseValidationMode == ResponseValidationPropagate,
+	}
+
+	var root *ast.Definition
+	switch ctx.Plan.Operation.Operation {
+	case ast.Mutation:
+		root = schema.Mutation
+	case ast.Subscription:
+		root = schema.Subscription
+	default:
+		root = schema.Query
+	}
+	rootType := ""
+	if root != nil {
+		rootType = root.Name
+	}
+
+	// the path of a value is built in place so most responses don't have to grow it
+	if !validator.object(result, ctx.Plan.Operation.SelectionSet, rootType, make([]interface{}, 0, 16), "", "") {
+		return nil, validator.errs
+	}
+
+	return result, validator.errs
+}
+
+// responseValidationOwners returns the url of the service that sent each field that a step asked for, indexed
+// by the path to the field without the indices of the lists along the way. The fields that aren't in the map
+// were sent by whoever sent their parent.
+func responseValidationOwners(plan *QueryPlan) map[string]string {
+	owners := map[string]string{}
+
+	var visit func(step *QueryPlanStep)
+	visit = func(step *QueryPlanStep) {
+		if step.Location != "" {
+			insertionPoints := step.InsertionPoints
+			if len(insertionPoints) == 0 {
+				insertionPoints = [][]string{step.InsertionPoint}
+			}
+
+			for _, insertionPoint := range insertionPoints {
+				for _, field := range executorSelectedFields(step.SelectionSet, step.FragmentDefinitions) {
+					owners[strings.Join(append(append([]string{}, insertionPoint...), field.Alias), ".")] = step.Location
+				}
+			}
+		}
+
+		for _, dependent := range step.Then {
+			visit(dependent)
+		}
+	}
+	if plan.RootStep != nil {
+		visit(plan.RootStep)
+	}
+
+	return owners
+}
+
+// responseValidator holds the state of the walk through a response
+type responseValidator struct {
+	schema    *ast.Schema
+	fragments ast.FragmentDefinitionList
+	variables map[string]interface{}
+	owners    map[string]string
+	propagate bool
+	errs      graphql.ErrorList
+
+	// every object at the same path has the same fields so they only have to be looked up once
+	fields map[string][]*responseValidationField
+}
+
+// responseValidationField is a field that the operation selects at a path
+type responseValidationField struct {
+	field *ast.Field
+	key   string
+	owner string
+	// the alias as an entry of a path
+	step interface{}
+	// true if the directives of the field (or of the fragments around it) could leave it out of the response
+	optional bool
+}
+
+// selectedFields returns the fields of the selection set at the path
+func (v *responseValidator) selectedFields(selectionSet ast.SelectionSet, key string) []*responseValidationField {
+	if fields, ok := v.fields[key]; ok {
+		return fields
+	}
+
+	fields := []*responseValidationField{}
+	var collect func(selectionSet ast.SelectionSet, optional bool)
+	collect = func(selectionSet ast.SelectionSet, optional bool) {
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				// the gateway answers introspection itself
+				if selection.Definition == nil || strings.HasPrefix(selection.Name, "__") {
+					continue
+				}
+
+				fieldKey := selection.Alias
+				if key != "" {
+					fieldKey = key + "." + selection.Alias
+				}
+				fields = append(fields, &responseValidationField{
+					field:    selection,
+					key:      fieldKey,
+					owner:    v.owners[fieldKey],
+					step:     selection.Alias,
+					optional: optional || v.optional(selection.Directives),
+				})
+			case *ast.InlineFragment:
+				collect(selection.SelectionSet, optional || v.optional(selection.Directives))
+			case *ast.FragmentSpread:
+				if definition := v.fragments.ForName(selection.Name); definition != nil {
+					collect(definition.SelectionSet, optional || v.optional(selection.Directives))
+				}
+			}
+		}
+	}
+	collect(selectionSet, false)
+
+	if v.fields == nil {
+		v.fields = map[string][]*responseValidationField{}
+	}
+	v.fields[key] = fields
+	return fields
+}
+
+// optional returns true if the directives could leave a selection out of the response
+func (v *responseValidator) optional(directives ast.DirectiveList) bool {
+	for _, directive := range directives {
+		switch directive.Name {
+		case "skip", "include":
+			// the selection is left out if the condition says so or we can't tell what it says
+			condition, ok := directive.ArgumentMap(v.variables)["if"].(bool)
+			if !ok || condition == (directive.Name == "skip") {
+				return true
+			}
+		case "defer":
+			return true
+		}
+	}
+
+	return false
+}
+
+// object checks the fields of the object that were selected by the selection set. It returns false if the
+// object has to be replaced with null.
+func (v *responseValidator) object(object map[string]interface{}, selectionSet ast.SelectionSet, parentType string, path []interface{}, key string, location string) bool {
+	valid := true
+
+	// the fields that were selected for another type don't have to be there
+	typename, _ := object["__typename"].(string)
+	if typename == "" {
+		if definition := v.schema.Types[parentType]; definition != nil && definition.Kind == ast.Object {
+			typename = parentType
+		}
+	}
+
+	for _, selected := range v.selectedFields(selectionSet, key) {
+		field := selected.field
+
+		fieldLocation := location
+		if selected.owner != "" {
+			fieldLocation = selected.owner
+		}
+		if fieldLocation == internalSchemaLocation {
+			continue
+		}
+
+		value, ok := object[field.Alias]
+		if !ok {
+			if selected.optional || !field.Definition.Type.NonNull || !v.applies(field, typename, parentType) {
+				continue
+			}
+			v.fail(field, append(path, selected.step), fieldLocation, "nothing")
+		} else if v.value(value, field.Definition.Type, field, append(path, selected.step), selected.key, fieldLocation) {
+			continue
+		}
+
+		object[field.Alias] = nil
+		if v.propagate && field.Definition.Type.NonNull {
+			valid = false
+		}
+	}
+
+	return valid
+}
+
+// value checks a value of the field against its type. It returns false if the value has to be replaced with null.
+func (v *responseValidator) value(value interface{}, fieldType *ast.Type, field *ast.Field, path []interface{}, key string, location string) bool {
+	if value == nil {
+		if fieldType.NonNull {
+			v.fail(field, path, location, "null")
+			return false
+		}
+		return true
+	}
+
+	// lists have to be lists and their entries have to fit too
+	if fieldType.Elem != nil {
+		list, ok := value.([]interface{})
+		if !ok {
+			v.fail(field, path, location, "a value that isn't a list")
+			return false
+		}
+
+		for i, entry := range list {
+			if v.value(entry, fieldType.Elem, field, append(path, i), key, location) {
+				continue
+			}
+
+			list[i] = nil
+			if v.propagate && fieldType.Elem.NonNull {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	definition := v.schema.Types[fieldType.NamedType]
+	if definition == nil {
+		return true
+	}
+
+	switch definition.Kind {
+	case ast.Scalar:
+		if !responseValidScalar(definition.Name, value) {
+			v.fail(field, path, location, fmt.Sprintf("%v, which is not a valid %s", value, definition.Name))
+			return false
+		}
+	case ast.Enum:
+		name, ok := value.(string)
+		if !ok || definition.EnumValues.ForName(name) == nil {
+			v.fail(field, path, location, fmt.Sprintf("%v, which is not a value of %s", value, definition.Name))
+			return false
+		}
+	case ast.Object, ast.Interface, ast.Union:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			v.fail(field, path, location, "a value that isn't an object")
+			return false
+		}
+		return v.object(object, field.SelectionSet, definition.Name, path, key, location)
+	}
+
+	return true
+}
+
+// applies returns true if the field was selected for the type of the object. Without the name of the type, only the
+// fields that were selected on the parent type itself apply.
+func (v *responseValidator) applies(field *ast.Field, typename string, parentType string) bool {
+	if field.ObjectDefinition == nil {
+		return false
+	}
+	if typename == "" {
+		return field.ObjectDefinition.Name == parentType
+	}
+	if field.ObjectDefinition.Name == typename {
+		return true
+	}
+	for _, possible := range v.schema.GetPossibleTypes(field.ObjectDefinition) {
+		if possible.Name == typename {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fail records a value that doesn't fit the schema
+func (v *responseValidator) fail(field *ast.Field, path []interface{}, location string, sent string) {
+	coordinate := field.Name
+	if field.ObjectDefinition != nil {
+		coordinate = field.ObjectDefinition.Name + "." + field.Name
+	}
+
+	err := graphql.NewError("INVALID_RESPONSE", fmt.Sprintf("%s sent %s for %s", location, sent, coordinate))
+	// the walk reuses the path for its siblings
+	err.Path = append([]interface{}{}, path...)
+	err.Extensions["service"] = location
+	v.errs = append(v.errs, err)
+}
+
+// responseValidScalar returns true if the value can be sent as a value of the built-in scalar. Custom scalars
+// can be anything.
+func responseValidScalar(scalar string, value interface{}) bool {
+	switch scalar {
+	case "Int":
+		number, ok := variableNumber(value)
+		return ok && number == math.Trunc(number) && number >= math.MinInt32 && number <= math.MaxInt32
+	case "Float":
+		_, ok := variableNumber(value)
+		return ok
+	case "String":
+		_, ok := value.(string)
+		return ok
+	case "Boolean":
+		_, ok := value.(bool)
+		return ok
+	case "ID":
+		if _, ok := value.(string); ok {
+			return true
+		}
+		number, ok := variableNumber(value)
+		return ok && number == math.Trunc(number)
+	}
+
+	return true
+}
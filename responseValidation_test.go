@@ -0,0 +1,207 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+// responseValidationGateway returns a gateway in front of a users service that sends back the users and a
+// stats service that adds their age
+func responseValidationGateway(t testing.TB, users []interface{}, age interface{}, options ...Option) *Gateway {
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		enum Role {
+			ADMIN
+			MEMBER
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+			role: Role!
+		}
+
+		type Query {
+			users: [User!]!
+			node(id: ID!): Node
+		}
+	`)
+	statsSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			age: Int
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			if url == "stats" {
+				return map[string]interface{}{
+					"node": map[string]interface{}{"age": age},
+				}, nil
+			}
+			return map[string]interface{}{"users": users}, nil
+		})
+	})
+
+	gw, err := New([]*graphql.RemoteSchema{
+		{Schema: usersSchema, URL: "users"},
+		{Schema: statsSchema, URL: "stats"},
+	}, append([]Option{WithQueryerFactory(&factory)}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return gw
+}
+
+func TestGateway_strictResponseValidation(t *testing.T) {
+	query := `{ users { name role age } }`
+
+	execute := func(gw *Gateway) (map[string]interface{}, error) {
+		ctx := &RequestContext{Context: context.Background(), Query: query}
+		plans, err := gw.GetPlans(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return gw.Execute(ctx, plans)
+	}
+	users := func(role string) []interface{} {
+		return []interface{}{map[string]interface{}{"id": "1", "name": "alice", "role": role}}
+	}
+
+	// the gateway passes the values along as they are unless it was asked to check them
+	result, err := execute(responseValidationGateway(t, users("OWNER"), 1.5))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "OWNER", result["users"].([]interface{})[0].(map[string]interface{})["role"])
+	}
+
+	// values that fit are left alone
+	result, err = execute(responseValidationGateway(t, users("ADMIN"), 31.0, WithStrictResponseValidation()))
+	if assert.Nil(t, err) {
+		assert.Equal(t, map[string]interface{}{
+			"users": []interface{}{map[string]interface{}{"id": "1", "name": "alice", "role": "ADMIN", "age": 31.0}},
+		}, result)
+	}
+
+	// the ones that don't are replaced with null and blamed on the service that sent them
+	result, err = execute(responseValidationGateway(t, users("OWNER"), 1.5, WithStrictResponseValidation()))
+	errs, ok := err.(graphql.ErrorList)
+	if !assert.True(t, ok, fmt.Sprintf("%v", err)) || !assert.Len(t, errs, 2) {
+		return
+	}
+	services := map[string]interface{}{}
+	for _, err := range errs {
+		services[fmt.Sprintf("%v", err.(*graphql.Error).Path)] = err.(*graphql.Error).Extensions["service"]
+	}
+	assert.Equal(t, map[string]interface{}{
+		"[users 0 role]": "users",
+		"[users 0 age]":  "stats",
+	}, services)
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{map[string]interface{}{"id": "1", "name": "alice", "role": nil, "age": nil}},
+	}, result)
+
+	// a null in a field that can't be null can take everything above it with it
+	result, err = execute(responseValidationGateway(t, users("OWNER"), 31.0, WithStrictResponseValidationMode(ResponseValidationPropagate)))
+	assert.NotNil(t, err)
+	assert.Nil(t, result)
+
+	// but it stops at the first field that can be null
+	result, err = execute(responseValidationGateway(t, users("ADMIN"), "old", WithStrictResponseValidationMode(ResponseValidationPropagate)))
+	assert.NotNil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{map[string]interface{}{"id": "1", "name": "alice", "role": "ADMIN", "age": nil}},
+	}, result)
+}
+
+func TestGateway_strictResponseValidationMissingFields(t *testing.T) {
+	execute := func(query string, variables map[string]interface{}) (map[string]interface{}, error) {
+		// the service leaves out the name of the user
+		gw := responseValidationGateway(t, []interface{}{map[string]interface{}{"id": "1", "role": "ADMIN"}}, 31.0, WithStrictResponseValidation())
+
+		ctx := &RequestContext{Context: context.Background(), Query: query, Variables: variables}
+		plans, err := gw.GetPlans(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return gw.Execute(ctx, plans)
+	}
+
+	// a field that can't be null has to be there
+	result, err := execute(`{ users { name role } }`, nil)
+	errs, ok := err.(graphql.ErrorList)
+	if assert.True(t, ok, fmt.Sprintf("%v", err)) && assert.Len(t, errs, 1) {
+		assert.Equal(t, []interface{}{"users", 0, "name"}, errs[0].(*graphql.Error).Path)
+		assert.Equal(t, "users", errs[0].(*graphql.Error).Extensions["service"])
+	}
+	assert.Equal(t, map[string]interface{}{
+		"users": []interface{}{map[string]interface{}{"id": "1", "name": nil, "role": "ADMIN"}},
+	}, result)
+
+	// the same goes for the fields of a fragment on the type of the object
+	_, err = execute(`{ users { role ... on User { name } } }`, nil)
+	assert.NotNil(t, err)
+
+	// unless the client left it out
+	_, err = execute(`query($withName: Boolean!) { users { role name @include(if: $withName) } }`, map[string]interface{}{"withName": false})
+	assert.Nil(t, err)
+}
+
+func TestResponseValidScalar(t *testing.T) {
+	assert.True(t, responseValidScalar("Int", 1))
+	assert.True(t, responseValidScalar("Int", 2.0))
+	assert.False(t, responseValidScalar("Int", 2.5))
+	assert.False(t, responseValidScalar("Int", float64(1<<40)))
+	assert.False(t, responseValidScalar("Int", "1"))
+	assert.True(t, responseValidScalar("Float", 2.5))
+	assert.True(t, responseValidScalar("ID", "abc"))
+	assert.True(t, responseValidScalar("ID", 12))
+	assert.False(t, responseValidScalar("ID", true))
+	assert.False(t, responseValidScalar("Boolean", "true"))
+	assert.True(t, responseValidScalar("DateTime", map[string]interface{}{}))
+}
+
+func BenchmarkGateway_strictResponseValidation(b *testing.B) {
+	users := []interface{}{}
+	for i := 0; i < 10000; i++ {
+		users = append(users, map[string]interface{}{"id": fmt.Sprint(i), "name": "alice", "role": "ADMIN"})
+	}
+
+	for _, validate := range []bool{false, true} {
+		options := []Option{}
+		if validate {
+			options = append(options, WithStrictResponseValidation())
+		}
+		gw := responseValidationGateway(b, users, nil, options...)
+
+		ctx := &RequestContext{Context: context.Background(), Query: `{ users { name role } }`}
+		plans, err := gw.GetPlans(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(fmt.Sprintf("validate=%v", validate), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := gw.Execute(ctx, plans); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
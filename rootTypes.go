@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// A service can give its root types any name it wants with a schema definition (ie, schema { query: RootQuery }).
+// The gateway's schema always uses Query, Mutation, and Subscription so the root types of those services are
+// renamed before they are merged with the others. The queries sent to the service use the names it knows and the
+// __typename values in its responses are translated, just like any other renamed type.
+
+// the names of the root types in the gateway's schema
+var standardRootTypes = map[ast.Operation]string{
+	ast.Query:        "Query",
+	ast.Mutation:     "Mutation",
+	ast.Subscription: "Subscription",
+}
+
+// rootTypeRenames returns the names the root types of the schema are given so they match the gateway's
+func rootTypeRenames(url string, schema *ast.Schema) (TypeRenames, error) {
+	renames := TypeRenames{}
+
+	for operation, definition := range map[ast.Operation]*ast.Definition{
+		ast.Query:        schema.Query,
+		ast.Mutation:     schema.Mutation,
+		ast.Subscription: schema.Subscription,
+	} {
+		if definition == nil || definition.Name == standardRootTypes[operation] {
+			continue
+		}
+
+		// the name could already be used for something else
+		if _, taken := schema.Types[standardRootTypes[operation]]; taken {
+			return nil, fmt.Errorf("Cannot use %s as the %s type of %s: the schema defines another type with that name", definition.Name, operation, url)
+		}
+
+		renames[definition.Name] = standardRootTypes[operation]
+	}
+
+	return renames, nil
+}
+
+// rootTypeName returns the name of the schema's root type for the operation
+func rootTypeName(schema *ast.Schema, operation ast.Operation) string {
+	if operation != ast.Mutation && operation != ast.Subscription {
+		operation = ast.Query
+	}
+
+	var definition *ast.Definition
+	if schema != nil {
+		switch operation {
+		case ast.Mutation:
+			definition = schema.Mutation
+		case ast.Subscription:
+			definition = schema.Subscription
+		default:
+			definition = schema.Query
+		}
+	}
+
+	if definition == nil {
+		return standardRootTypes[operation]
+	}
+	return definition.Name
+}
+
+// atOperationRoot returns true if the step resolves fields of the operation's root type instead of an object
+// that was found by another step
+func (s *QueryPlanStep) atOperationRoot() bool {
+	return len(s.InsertionPoint) == 0
+}
@@ -0,0 +1,211 @@
+package gateway
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_customRootTypeNames(t *testing.T) {
+	// a service that names its root types
+	usersSchema, err := graphql.LoadSchema(`
+		schema {
+			query: RootQuery
+			mutation: RootMutation
+		}
+
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			firstName: String!
+		}
+
+		type RootQuery {
+			node(id: ID!): Node
+			allUsers: [User!]!
+		}
+
+		type RootMutation {
+			createUser(firstName: String!): User!
+		}
+	`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// and one that doesn't
+	namesSchema, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			lastName: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+
+		type Mutation {
+			rename(id: ID!, lastName: String!): User!
+		}
+	`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the queries sent to each service
+	sentMutex := &sync.Mutex{}
+	sent := map[string][]string{}
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			sentMutex.Lock()
+			sent[url] = append(sent[url], input.Query)
+			sentMutex.Unlock()
+
+			switch {
+			case url == "names":
+				return map[string]interface{}{
+					"node": map[string]interface{}{"lastName": "Smith"},
+				}, nil
+			case strings.HasPrefix(input.Query, "mutation"):
+				return map[string]interface{}{
+					"createUser": map[string]interface{}{gatewayIDAlias: "1", "firstName": "John"},
+				}, nil
+			default:
+				return map[string]interface{}{
+					"__typename": "RootQuery",
+					"allUsers": []interface{}{
+						map[string]interface{}{gatewayIDAlias: "1", "firstName": "John"},
+					},
+				}, nil
+			}
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: "users", Schema: usersSchema},
+		{URL: "names", Schema: namesSchema},
+	}, WithQueryerFactory(&factory))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the root types of both services were merged into the gateway's
+	assert.Equal(t, "Query", gateway.schema.Query.Name)
+	assert.Equal(t, "Mutation", gateway.schema.Mutation.Name)
+	assert.NotNil(t, gateway.schema.Query.Fields.ForName("allUsers"))
+	assert.NotNil(t, gateway.schema.Mutation.Fields.ForName("createUser"))
+	assert.NotNil(t, gateway.schema.Mutation.Fields.ForName("rename"))
+	_, ok := gateway.schema.Types["RootQuery"]
+	assert.False(t, ok)
+
+	t.Run("Query", func(t *testing.T) {
+		sent = map[string][]string{}
+
+		requestContext := &RequestContext{
+			Context: context.Background(),
+			Query: `
+				{ __typename ...Users }
+				fragment Users on Query { allUsers { firstName lastName } }
+			`,
+		}
+		plans, err := gateway.GetPlans(requestContext)
+		if !assert.Nil(t, err) {
+			return
+		}
+		result, err := gateway.Execute(requestContext, plans)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, map[string]interface{}{
+			"__typename": "Query",
+			"allUsers": []interface{}{
+				map[string]interface{}{"firstName": "John", "lastName": "Smith"},
+			},
+		}, result)
+
+		// the root fields go straight to the service, with the names it knows
+		if assert.Len(t, sent["users"], 1) {
+			assert.Contains(t, sent["users"][0], "on RootQuery")
+			assert.NotContains(t, sent["users"][0], "node(")
+		}
+		// the other service is asked for the user
+		if assert.Len(t, sent["names"], 1) {
+			assert.Contains(t, sent["names"][0], "node(")
+		}
+	})
+
+	t.Run("Mutation", func(t *testing.T) {
+		sent = map[string][]string{}
+
+		requestContext := &RequestContext{
+			Context: context.Background(),
+			Query:   `mutation { createUser(firstName: "John") { firstName lastName } }`,
+		}
+		plans, err := gateway.GetPlans(requestContext)
+		if !assert.Nil(t, err) {
+			return
+		}
+		result, err := gateway.Execute(requestContext, plans)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, map[string]interface{}{
+			"createUser": map[string]interface{}{"firstName": "John", "lastName": "Smith"},
+		}, result)
+		if assert.Len(t, sent["users"], 1) {
+			assert.True(t, strings.HasPrefix(sent["users"][0], "mutation"))
+			assert.NotContains(t, sent["users"][0], "node(")
+		}
+	})
+}
+
+func TestRootTypeRenames(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		schema {
+			query: RootQuery
+		}
+
+		type RootQuery {
+			value: Int
+		}
+	`)
+
+	renames, err := rootTypeRenames("url", schema)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, TypeRenames{"RootQuery": "Query"}, renames)
+
+	t.Run("Taken name", func(t *testing.T) {
+		schema, _ := graphql.LoadSchema(`
+			schema {
+				query: RootQuery
+			}
+
+			type Query {
+				value: Int
+			}
+
+			type RootQuery {
+				query: Query
+			}
+		`)
+
+		_, err := rootTypeRenames("url", schema)
+		assert.NotNil(t, err)
+	})
+}
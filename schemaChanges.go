@@ -0,0 +1,428 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// When the gateway reloads the schemas of its services, the new schema is compared to the old one so that
+// the changes can be reported. Removing something or making it harder to use breaks the clients that
+// rely on it so those changes are logged loudly and, if we've been told to, the reload is refused.
+
+// SchemaChange describes a single difference between two versions of the schema
+type SchemaChange struct {
+	// the part of the schema that changed, ie User, User.name, User.name(id:), or Status.ACTIVE
+	Coordinate string
+	// a description of the change
+	Message string
+	// the services that define the part of the schema that changed
+	Services []string
+}
+
+// ChangeSet holds the differences between two versions of the schema
+type ChangeSet struct {
+	// the things that are new
+	Additions []*SchemaChange
+	// the things that have been deprecated since the last version
+	Deprecations []*SchemaChange
+	// the changes that could break a client
+	BreakingChanges []*SchemaChange
+	// true if the new schema was not applied because of the breaking changes
+	Rejected bool
+}
+
+// Empty returns true if nothing changed
+func (c *ChangeSet) Empty() bool {
+	return len(c.Additions) == 0 && len(c.Deprecations) == 0 && len(c.BreakingChanges) == 0
+}
+
+// BreakingChangesError is returned when a reload is refused because it contains breaking changes
+type BreakingChangesError struct {
+	Changes *ChangeSet
+}
+
+func (e *BreakingChangesError) Error() string {
+	messages := []string{}
+	for _, change := range e.Changes.BreakingChanges {
+		messages = append(messages, change.Message)
+	}
+
+	return "refusing to apply a schema with breaking changes: " + strings.Join(messages, ", ")
+}
+
+// WithSchemaChangeHandler returns an Option that passes the changes to the schema to the handler every
+// time the gateway reloads it. The changes are logged either way.
+func WithSchemaChangeHandler(handler func(*ChangeSet)) Option {
+	return func(g *Gateway) {
+		g.schemaChangeHandler = handler
+	}
+}
+
+// WithRejectBreakingChanges returns an Option that keeps the current schema when a reload would introduce
+// breaking changes. ForceReload applies the schema anyway.
+func WithRejectBreakingChanges() Option {
+	return func(g *Gateway) {
+		g.rejectBreakingChanges = true
+	}
+}
+
+// Reload rebuilds the gateway's schema out of the designated services. Pass the sources that were given to
+// New to introspect the same services again. If the gateway rejects breaking changes and the new schema
+// has some, the current one is kept and a *BreakingChangesError is returned.
+func (g *Gateway) Reload(sources []*graphql.RemoteSchema) (*ChangeSet, error) {
+	return g.reload(sources, false)
+}
+
+// ForceReload rebuilds the gateway's schema like Reload, even if it has breaking changes
+func (g *Gateway) ForceReload(sources []*graphql.RemoteSchema) (*ChangeSet, error) {
+	return g.reload(sources, true)
+}
+
+func (g *Gateway) reload(sources []*graphql.RemoteSchema, force bool) (*ChangeSet, error) {
+	// the reloads have to happen one at a time
+	g.reloadMutex.Lock()
+	defer g.reloadMutex.Unlock()
+
+	if len(sources) == 0 && !g.hasLocalDefinitions() {
+		return nil, fmt.Errorf("a gateway must have at least one schema")
+	}
+
+	built, err := g.buildSchema(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	g.schemaMutex.RLock()
+	changes := DiffSchemas(g.schema, built.schema)
+	oldOwners := g.schemaOwners
+	g.schemaMutex.RUnlock()
+	changes.assignServices(oldOwners, built.owners)
+
+	if g.rejectBreakingChanges && !force && len(changes.BreakingChanges) > 0 {
+		changes.Rejected = true
+	}
+	g.reportSchemaChanges(changes)
+	if changes.Rejected {
+		return changes, &BreakingChangesError{Changes: changes}
+	}
+
+	// the services that are stale or missing are only replaced along with the schema they describe
+	g.applySchema(built, sources)
+
+	return changes, nil
+}
+
+// reportSchemaChanges logs the changes and passes them to the handler
+func (g *Gateway) reportSchemaChanges(changes *ChangeSet) {
+	for _, change := range changes.BreakingChanges {
+		log.WithFields(LoggerFields{
+			"coordinate": change.Coordinate,
+			"services":   strings.Join(change.Services, ", "),
+		}).Warn("Breaking schema change: ", change.Message)
+	}
+	for _, change := range changes.Deprecations {
+		log.WithFields(LoggerFields{
+			"coordinate": change.Coordinate,
+			"services":   strings.Join(change.Services, ", "),
+		}).Info("Schema deprecation: ", change.Message)
+	}
+	for _, change := range changes.Additions {
+		log.WithFields(LoggerFields{
+			"coordinate": change.Coordinate,
+			"services":   strings.Join(change.Services, ", "),
+		}).Info("Schema addition: ", change.Message)
+	}
+
+	if g.schemaChangeHandler != nil && !changes.Empty() {
+		g.schemaChangeHandler(changes)
+	}
+}
+
+// schemaOwners returns the services that define each type, field, and enum value, indexed by coordinate
+func schemaOwners(sources []*graphql.RemoteSchema) map[string][]string {
+	owners := map[string][]string{}
+	for _, source := range sources {
+		for name, definition := range source.Schema.Types {
+			owners[name] = append(owners[name], source.URL)
+			for _, field := range definition.Fields {
+				coordinate := name + "." + field.Name
+				owners[coordinate] = append(owners[coordinate], source.URL)
+			}
+			for _, value := range definition.EnumValues {
+				coordinate := name + "." + value.Name
+				owners[coordinate] = append(owners[coordinate], source.URL)
+			}
+		}
+	}
+
+	return owners
+}
+
+// assignServices records the services responsible for each change. Something that was removed is
+// blamed on the services that used to define it.
+func (c *ChangeSet) assignServices(oldOwners map[string][]string, newOwners map[string][]string) {
+	for _, changes := range [][]*SchemaChange{c.Additions, c.Deprecations, c.BreakingChanges} {
+		for _, change := range changes {
+			// arguments belong to their field
+			coordinate := strings.SplitN(change.Coordinate, "(", 2)[0]
+
+			services := Set{}
+			for _, url := range append(append([]string{}, oldOwners[coordinate]...), newOwners[coordinate]...) {
+				if url != internalSchemaLocation {
+					services.Add(url)
+				}
+			}
+
+			change.Services = []string{}
+			for url := range services {
+				change.Services = append(change.Services, url)
+			}
+			sort.Strings(change.Services)
+		}
+	}
+}
+
+// DiffSchemas returns the changes that turn the old schema into the new one
+func DiffSchemas(old *ast.Schema, new *ast.Schema) *ChangeSet {
+	changes := &ChangeSet{}
+
+	for _, name := range sortedTypeNames(old) {
+		oldType := old.Types[name]
+		if !diffableType(oldType) {
+			continue
+		}
+
+		newType, ok := new.Types[name]
+		if !ok {
+			changes.breaking(name, "Type %s was removed", name)
+			continue
+		}
+		if oldType.Kind != newType.Kind {
+			changes.breaking(name, "Type %s changed from %s to %s", name, oldType.Kind, newType.Kind)
+			continue
+		}
+
+		switch oldType.Kind {
+		case ast.Object, ast.Interface:
+			diffOutputFields(changes, oldType, newType)
+			for _, iface := range oldType.Interfaces {
+				if !stringsContain(newType.Interfaces, iface) {
+					changes.breaking(name, "Type %s no longer implements %s", name, iface)
+				}
+			}
+		case ast.InputObject:
+			diffInputFields(changes, oldType, newType)
+		case ast.Enum:
+			diffEnumValues(changes, oldType, newType)
+		case ast.Union:
+			for _, member := range oldType.Types {
+				if !stringsContain(newType.Types, member) {
+					changes.breaking(name, "Type %s was removed from union %s", member, name)
+				}
+			}
+			for _, member := range newType.Types {
+				if !stringsContain(oldType.Types, member) {
+					changes.addition(name, "Type %s was added to union %s", member, name)
+				}
+			}
+		}
+	}
+
+	for _, name := range sortedTypeNames(new) {
+		if _, ok := old.Types[name]; !ok && diffableType(new.Types[name]) {
+			changes.addition(name, "Type %s was added", name)
+		}
+	}
+
+	return changes
+}
+
+// diffOutputFields adds the changes to the fields of an object or interface
+func diffOutputFields(changes *ChangeSet, oldType *ast.Definition, newType *ast.Definition) {
+	for _, oldField := range oldType.Fields {
+		coordinate := oldType.Name + "." + oldField.Name
+
+		newField := newType.Fields.ForName(oldField.Name)
+		if newField == nil {
+			changes.breaking(coordinate, "Field %s was removed", coordinate)
+			continue
+		}
+
+		if !safeOutputTypeChange(oldField.Type, newField.Type) {
+			changes.breaking(coordinate, "Field %s changed type from %s to %s", coordinate, oldField.Type.String(), newField.Type.String())
+		}
+		if !isDeprecated(oldField.Directives) && isDeprecated(newField.Directives) {
+			changes.deprecation(coordinate, "Field %s was deprecated", coordinate)
+		}
+
+		diffArguments(changes, coordinate, oldField.Arguments, newField.Arguments)
+	}
+
+	for _, newField := range newType.Fields {
+		if oldType.Fields.ForName(newField.Name) == nil {
+			coordinate := newType.Name + "." + newField.Name
+			changes.addition(coordinate, "Field %s was added", coordinate)
+		}
+	}
+}
+
+// diffArguments adds the changes to the arguments of a field
+func diffArguments(changes *ChangeSet, field string, oldArguments ast.ArgumentDefinitionList, newArguments ast.ArgumentDefinitionList) {
+	for _, oldArgument := range oldArguments {
+		coordinate := field + "(" + oldArgument.Name + ":)"
+
+		newArgument := newArguments.ForName(oldArgument.Name)
+		if newArgument == nil {
+			changes.breaking(coordinate, "Argument %s was removed", coordinate)
+			continue
+		}
+		if !safeInputTypeChange(oldArgument.Type, newArgument.Type) {
+			changes.breaking(coordinate, "Argument %s changed type from %s to %s", coordinate, oldArgument.Type.String(), newArgument.Type.String())
+		}
+	}
+
+	for _, newArgument := range newArguments {
+		if oldArguments.ForName(newArgument.Name) != nil {
+			continue
+		}
+
+		coordinate := field + "(" + newArgument.Name + ":)"
+		if newArgument.Type.NonNull && newArgument.DefaultValue == nil {
+			changes.breaking(coordinate, "Required argument %s was added", coordinate)
+		} else {
+			changes.addition(coordinate, "Argument %s was added", coordinate)
+		}
+	}
+}
+
+// diffInputFields adds the changes to the fields of an input object
+func diffInputFields(changes *ChangeSet, oldType *ast.Definition, newType *ast.Definition) {
+	for _, oldField := range oldType.Fields {
+		coordinate := oldType.Name + "." + oldField.Name
+
+		newField := newType.Fields.ForName(oldField.Name)
+		if newField == nil {
+			changes.breaking(coordinate, "Input field %s was removed", coordinate)
+			continue
+		}
+		if !safeInputTypeChange(oldField.Type, newField.Type) {
+			changes.breaking(coordinate, "Input field %s changed type from %s to %s", coordinate, oldField.Type.String(), newField.Type.String())
+		}
+	}
+
+	for _, newField := range newType.Fields {
+		if oldType.Fields.ForName(newField.Name) != nil {
+			continue
+		}
+
+		coordinate := newType.Name + "." + newField.Name
+		if newField.Type.NonNull && newField.DefaultValue == nil {
+			changes.breaking(coordinate, "Required input field %s was added", coordinate)
+		} else {
+			changes.addition(coordinate, "Input field %s was added", coordinate)
+		}
+	}
+}
+
+// diffEnumValues adds the changes to the values of an enum
+func diffEnumValues(changes *ChangeSet, oldType *ast.Definition, newType *ast.Definition) {
+	for _, oldValue := range oldType.EnumValues {
+		coordinate := oldType.Name + "." + oldValue.Name
+
+		newValue := newType.EnumValues.ForName(oldValue.Name)
+		if newValue == nil {
+			changes.breaking(coordinate, "Enum value %s was removed", coordinate)
+			continue
+		}
+		if !isDeprecated(oldValue.Directives) && isDeprecated(newValue.Directives) {
+			changes.deprecation(coordinate, "Enum value %s was deprecated", coordinate)
+		}
+	}
+
+	for _, newValue := range newType.EnumValues {
+		if oldType.EnumValues.ForName(newValue.Name) == nil {
+			coordinate := newType.Name + "." + newValue.Name
+			changes.addition(coordinate, "Enum value %s was added", coordinate)
+		}
+	}
+}
+
+// safeOutputTypeChange returns true if a client that could handle a value of the old type can handle the new one.
+// A field can promise more (ie, become non-null) but not less.
+func safeOutputTypeChange(old *ast.Type, new *ast.Type) bool {
+	if old.NonNull && !new.NonNull {
+		return false
+	}
+	if (old.Elem == nil) != (new.Elem == nil) {
+		return false
+	}
+	if old.Elem != nil {
+		return safeOutputTypeChange(old.Elem, new.Elem)
+	}
+
+	return old.NamedType == new.NamedType
+}
+
+// safeInputTypeChange returns true if every value a client could send for the old type is valid for the new one.
+// An input can ask for less (ie, become nullable) but not more.
+func safeInputTypeChange(old *ast.Type, new *ast.Type) bool {
+	if !old.NonNull && new.NonNull {
+		return false
+	}
+	if (old.Elem == nil) != (new.Elem == nil) {
+		return false
+	}
+	if old.Elem != nil {
+		return safeInputTypeChange(old.Elem, new.Elem)
+	}
+
+	return old.NamedType == new.NamedType
+}
+
+// diffableType returns true if the type's changes are worth reporting
+func diffableType(definition *ast.Definition) bool {
+	return !definition.BuiltIn && !strings.HasPrefix(definition.Name, "__")
+}
+
+// isDeprecated returns true if the directives mark something as deprecated
+func isDeprecated(directives ast.DirectiveList) bool {
+	return directives.ForName("deprecated") != nil
+}
+
+// sortedTypeNames returns the names of the types in the schema so that changes are always reported in the same order
+func sortedTypeNames(schema *ast.Schema) []string {
+	names := []string{}
+	for name := range schema.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// stringsContain returns true if the list has the value
+func stringsContain(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *ChangeSet) addition(coordinate string, format string, args ...interface{}) {
+	c.Additions = append(c.Additions, &SchemaChange{Coordinate: coordinate, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *ChangeSet) deprecation(coordinate string, format string, args ...interface{}) {
+	c.Deprecations = append(c.Deprecations, &SchemaChange{Coordinate: coordinate, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *ChangeSet) breaking(coordinate string, format string, args ...interface{}) {
+	c.BreakingChanges = append(c.BreakingChanges, &SchemaChange{Coordinate: coordinate, Message: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,301 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	old := `
+		enum Status {
+			ACTIVE
+			BANNED
+		}
+
+		input UserFilter {
+			status: Status
+		}
+
+		type User {
+			id: ID!
+			name: String!
+			email: String
+			status: Status!
+			friends(first: Int): [User!]!
+		}
+
+		type Query {
+			users(filter: UserFilter): [User!]!
+		}
+	`
+
+	table := []struct {
+		Name string
+		New  string
+		// the messages of the changes we expect in each category
+		Additions       []string
+		Deprecations    []string
+		BreakingChanges []string
+	}{
+		{
+			Name: "Removed field",
+			New: `
+				enum Status { ACTIVE BANNED }
+				input UserFilter { status: Status }
+				type User { id: ID! name: String! status: Status! friends(first: Int): [User!]! }
+				type Query { users(filter: UserFilter): [User!]! }
+			`,
+			BreakingChanges: []string{"Field User.email was removed"},
+		},
+		{
+			Name: "Changed field type",
+			New: `
+				enum Status { ACTIVE BANNED }
+				input UserFilter { status: Status }
+				type User { id: ID! name: String email: String! status: Status! friends(first: Int): [User!]! }
+				type Query { users(filter: UserFilter): [User!]! }
+			`,
+			// making email non-null is safe for clients, making name nullable is not
+			BreakingChanges: []string{"Field User.name changed type from String! to String"},
+		},
+		{
+			Name: "Removed enum value",
+			New: `
+				enum Status { ACTIVE }
+				input UserFilter { status: Status }
+				type User { id: ID! name: String! email: String status: Status! friends(first: Int): [User!]! }
+				type Query { users(filter: UserFilter): [User!]! }
+			`,
+			BreakingChanges: []string{"Enum value Status.BANNED was removed"},
+		},
+		{
+			Name: "New required argument",
+			New: `
+				enum Status { ACTIVE BANNED }
+				input UserFilter { status: Status }
+				type User { id: ID! name: String! email: String status: Status! friends(first: Int, after: String!, last: Int): [User!]! }
+				type Query { users(filter: UserFilter): [User!]! }
+			`,
+			Additions:       []string{"Argument User.friends(last:) was added"},
+			BreakingChanges: []string{"Required argument User.friends(after:) was added"},
+		},
+		{
+			Name: "Tightened input",
+			New: `
+				enum Status { ACTIVE BANNED }
+				input UserFilter { status: Status! }
+				type User { id: ID! name: String! email: String status: Status! friends(first: Int!): [User!]! }
+				type Query { users(filter: UserFilter): [User!]! }
+			`,
+			BreakingChanges: []string{
+				"Input field UserFilter.status changed type from Status to Status!",
+				"Argument User.friends(first:) changed type from Int to Int!",
+			},
+		},
+		{
+			Name: "Additions and deprecations",
+			New: `
+				enum Status { ACTIVE BANNED @deprecated DELETED }
+				input UserFilter { status: Status name: String }
+				type Photo { url: String! }
+				type User {
+					id: ID!
+					name: String! @deprecated(reason: "use handle")
+					handle: String!
+					email: String
+					status: Status!
+					friends(first: Int): [User!]!
+				}
+				type Query { users(filter: UserFilter): [User!]! }
+			`,
+			Additions: []string{
+				"Input field UserFilter.name was added",
+				"Enum value Status.DELETED was added",
+				"Type Photo was added",
+				"Field User.handle was added",
+			},
+			Deprecations: []string{
+				"Enum value Status.BANNED was deprecated",
+				"Field User.name was deprecated",
+			},
+		},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			oldSchema, err := graphql.LoadSchema(old)
+			if !assert.Nil(t, err) {
+				return
+			}
+			newSchema, err := graphql.LoadSchema(row.New)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			changes := DiffSchemas(oldSchema, newSchema)
+			assert.ElementsMatch(t, row.Additions, schemaChangeMessages(changes.Additions), "additions")
+			assert.ElementsMatch(t, row.Deprecations, schemaChangeMessages(changes.Deprecations), "deprecations")
+			assert.ElementsMatch(t, row.BreakingChanges, schemaChangeMessages(changes.BreakingChanges), "breaking changes")
+		})
+	}
+}
+
+func TestGateway_reload(t *testing.T) {
+	usersSchema := `
+		type User {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			me: User
+		}
+	`
+	// the users service drops the name in favor of a handle
+	usersSchemaWithoutName := `
+		type User {
+			id: ID!
+			handle: String!
+		}
+
+		type Query {
+			me: User
+		}
+	`
+	photosSchema := `
+		type Photo {
+			url: String!
+		}
+
+		type Query {
+			photos: [Photo!]!
+		}
+	`
+
+	// sources returns fresh copies of the schemas since merging them changes them
+	sources := func(users string) []*graphql.RemoteSchema {
+		usersSource, _ := graphql.LoadSchema(users)
+		photosSource, _ := graphql.LoadSchema(photosSchema)
+		return []*graphql.RemoteSchema{
+			{URL: "users", Schema: usersSource},
+			{URL: "photos", Schema: photosSource},
+		}
+	}
+
+	t.Run("Reports changes", func(t *testing.T) {
+		reported := []*ChangeSet{}
+		gateway, err := New(sources(usersSchema), WithSchemaChangeHandler(func(changes *ChangeSet) {
+			reported = append(reported, changes)
+		}))
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// nothing changed so there's nothing to report
+		changes, err := gateway.Reload(sources(usersSchema))
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.True(t, changes.Empty())
+		assert.Len(t, reported, 0)
+
+		changes, err = gateway.Reload(sources(usersSchemaWithoutName))
+		if !assert.Nil(t, err) {
+			return
+		}
+		if !assert.Len(t, reported, 1) {
+			return
+		}
+		assert.Equal(t, changes, reported[0])
+		assert.False(t, changes.Rejected)
+		assert.Equal(t, []*SchemaChange{{Coordinate: "User.handle", Message: "Field User.handle was added", Services: []string{"users"}}}, changes.Additions)
+		assert.Equal(t, []*SchemaChange{{Coordinate: "User.name", Message: "Field User.name was removed", Services: []string{"users"}}}, changes.BreakingChanges)
+
+		// the new schema is the one the gateway uses
+		assert.NotNil(t, gateway.schema.Types["User"].Fields.ForName("handle"))
+		assert.Nil(t, gateway.schema.Types["User"].Fields.ForName("name"))
+	})
+
+	t.Run("Rejects breaking changes", func(t *testing.T) {
+		gateway, err := New(sources(usersSchema), WithRejectBreakingChanges())
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		changes, err := gateway.Reload(sources(usersSchemaWithoutName))
+		_, isBreaking := err.(*BreakingChangesError)
+		if !assert.True(t, isBreaking) {
+			return
+		}
+		assert.True(t, changes.Rejected)
+
+		// the gateway kept the old schema
+		assert.NotNil(t, gateway.schema.Types["User"].Fields.ForName("name"))
+
+		// unless we insist
+		changes, err = gateway.ForceReload(sources(usersSchemaWithoutName))
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.False(t, changes.Rejected)
+		assert.Nil(t, gateway.schema.Types["User"].Fields.ForName("name"))
+	})
+
+	t.Run("Clears the cached plans", func(t *testing.T) {
+		gateway, err := New(sources(usersSchema), WithAutomaticQueryPlanCache())
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// planURL returns the url the plan of the query sends its first step to
+		hash := ""
+		planURL := func() string {
+			ctx := &RequestContext{Context: context.Background(), Query: "{ photos { url } }", CacheKey: hash}
+			plans, err := gateway.GetPlans(ctx)
+			if !assert.Nil(t, err) {
+				return ""
+			}
+			hash = ctx.CacheKey
+			return plans[0].RootStep.Then[0].Queryer.(*graphql.SingleRequestQueryer).URL()
+		}
+		assert.Equal(t, "photos", planURL())
+		assert.Equal(t, "photos", planURL())
+		assert.Equal(t, 1, gateway.queryPlanCache.(*AutomaticQueryPlanCache).Len())
+
+		// the photos are moved to the users service
+		usersSource, _ := graphql.LoadSchema(`
+			type User {
+				id: ID!
+				name: String!
+			}
+
+			type Photo {
+				url: String!
+			}
+
+			type Query {
+				me: User
+				photos: [Photo!]!
+			}
+		`)
+		_, err = gateway.Reload([]*graphql.RemoteSchema{{URL: "users", Schema: usersSource}})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// the plan we cached before would still send the query to the old service
+		assert.Equal(t, "users", planURL())
+	})
+}
+
+// schemaChangeMessages returns the message of each change
+func schemaChangeMessages(changes []*SchemaChange) []string {
+	messages := []string{}
+	for _, change := range changes {
+		messages = append(messages, change.Message)
+	}
+	return messages
+}
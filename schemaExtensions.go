@@ -0,0 +1,413 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// Schema extensions describe how the gateway should treat the types and fields of its schema in one reviewed
+// document instead of a pile of options. They are written as SDL that extends the merged schema with directives:
+//
+//	extend type User @cacheControl(maxAge: 60) {
+//		orders: [Order] @requiresRole(role: "support") @paginationLimit(max: 100)
+//	}
+//
+// Every type and field that is extended has to exist in the merged schema (the types of the fields are only
+// there to make the document valid SDL and aren't checked). The directives are attached to the merged schema so
+// they show up when it is exported as SDL and the rest of the gateway finds them there:
+//   - @cacheControl(maxAge: Int, scope: String) sets the cache hint of the type or field for the response cache
+//   - @requiresRole(role: String!) only lets the users with the role select the field (or the fields of the type)
+//   - @paginationLimit(max: Int, default: Int, argument: String = "first", reject: Boolean = false) sets the
+//     limit of a pagination argument of the field, on top of the ones configured with WithPaginationLimits
+//
+// Other directives have to be declared in the document (or by one of the services). Their arguments can be
+// looked up with Gateway.DirectiveArguments. The declarations of the directives that were added by the extensions
+// are left out of introspection unless WithIntrospectedSchemaExtensions is used.
+
+// the directives the gateway knows what to do with
+const schemaExtensionsPrelude = `
+	directive @cacheControl(maxAge: Int, scope: String) on OBJECT | INTERFACE | FIELD_DEFINITION
+	directive @requiresRole(role: String!) on OBJECT | INTERFACE | FIELD_DEFINITION
+	directive @paginationLimit(max: Int, default: Int, argument: String = "first", reject: Boolean = false) on FIELD_DEFINITION
+`
+
+// RoleProvider returns the roles of the user making the request
+type RoleProvider func(ctx context.Context) []string
+
+// WithSchemaExtensions returns an Option that extends the merged schema with the directives in the SDL
+func WithSchemaExtensions(sdl string) Option {
+	return func(g *Gateway) {
+		g.schemaExtensions = append(g.schemaExtensions, sdl)
+	}
+}
+
+// WithIntrospectedSchemaExtensions returns an Option that shows the directives declared by the schema extensions
+// to introspection queries
+func WithIntrospectedSchemaExtensions() Option {
+	return func(g *Gateway) {
+		g.introspectSchemaExtensions = true
+	}
+}
+
+// WithRoles returns an Option that sets the function used to find the roles of the user for @requiresRole. Without
+// one, nobody can select the fields that require a role.
+func WithRoles(roles RoleProvider) Option {
+	return func(g *Gateway) {
+		g.roles = roles
+	}
+}
+
+// DirectiveArguments returns the arguments of the directive applied to the type or field at the coordinate (ie,
+// User or User.orders) with the defaults of its declaration filled in. The boolean is false if the directive
+// isn't applied there. The directive can come from the schema extensions or from one of the services.
+func (g *Gateway) DirectiveArguments(coordinate string, directive string) (map[string]interface{}, bool) {
+	g.schemaMutex.RLock()
+	schema := g.schema
+	g.schemaMutex.RUnlock()
+
+	if schema == nil {
+		return nil, false
+	}
+
+	parts := strings.SplitN(coordinate, ".", 2)
+	definition := schema.Types[parts[0]]
+	if definition == nil {
+		return nil, false
+	}
+
+	directives := definition.Directives
+	if len(parts) == 2 {
+		field := definition.Fields.ForName(parts[1])
+		if field == nil {
+			return nil, false
+		}
+		directives = field.Directives
+	}
+
+	applied := directives.ForName(directive)
+	if applied == nil {
+		return nil, false
+	}
+
+	return schemaDirectiveArguments(schema, applied), true
+}
+
+// schemaDirectiveArguments returns the values of the arguments of the directive, including the defaults
+func schemaDirectiveArguments(schema *ast.Schema, directive *ast.Directive) map[string]interface{} {
+	arguments := map[string]interface{}{}
+
+	if definition := schema.Directives[directive.Name]; definition != nil {
+		for _, argument := range definition.Arguments {
+			if argument.DefaultValue == nil {
+				continue
+			}
+			if value, err := argument.DefaultValue.Value(nil); err == nil {
+				arguments[argument.Name] = value
+			}
+		}
+	}
+
+	for _, argument := range directive.Arguments {
+		if argument.Value == nil {
+			continue
+		}
+		if value, err := argument.Value.Value(nil); err == nil {
+			arguments[argument.Name] = value
+		}
+	}
+
+	return arguments
+}
+
+// applySchemaExtensions attaches the directives of the extensions to the merged schema. The definitions that are
+// extended are replaced with copies so the schemas of the services are left alone. The names of the directives
+// the extensions declared are returned.
+func applySchemaExtensions(schema *ast.Schema, extensions []string) (Set, error) {
+	declared := Set{}
+	if len(extensions) == 0 {
+		return declared, nil
+	}
+
+	prelude, err := parser.ParseSchema(&ast.Source{Name: "gateway directives", Input: schemaExtensionsPrelude})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, sdl := range extensions {
+		document, parseErr := parser.ParseSchema(&ast.Source{Name: fmt.Sprintf("schema extensions %d", i+1), Input: sdl})
+		if parseErr != nil {
+			return nil, fmt.Errorf("could not parse the schema extensions: %s", parseErr.Error())
+		}
+
+		// the extensions configure what's already there
+		if len(document.Definitions) > 0 {
+			return nil, schemaExtensionError(document.Definitions[0].Position, "%s can only be extended, not defined", document.Definitions[0].Name)
+		}
+		if len(document.Schema) > 0 || len(document.SchemaExtension) > 0 {
+			return nil, fmt.Errorf("the schema extensions cannot change the schema definition")
+		}
+
+		for _, directive := range document.Directives {
+			if _, ok := schema.Directives[directive.Name]; ok {
+				return nil, schemaExtensionError(directive.Position, "the directive @%s is already declared", directive.Name)
+			}
+			schema.Directives[directive.Name] = directive
+			declared.Add(directive.Name)
+		}
+
+		// the directives that the extensions use have to be declared somewhere
+		declare := func(directives ast.DirectiveList) error {
+			for _, directive := range directives {
+				definition := schema.Directives[directive.Name]
+				if definition == nil {
+					definition = prelude.Directives.ForName(directive.Name)
+					if definition == nil {
+						return schemaExtensionError(directive.Position, "the directive @%s is not declared", directive.Name)
+					}
+					schema.Directives[directive.Name] = definition
+					declared.Add(directive.Name)
+				}
+
+				for _, argument := range directive.Arguments {
+					if definition.Arguments.ForName(argument.Name) == nil {
+						return schemaExtensionError(argument.Position, "the directive @%s does not have a %s argument", directive.Name, argument.Name)
+					}
+				}
+			}
+
+			return nil
+		}
+
+		for _, extension := range document.Extensions {
+			original := schema.Types[extension.Name]
+			if original == nil {
+				return nil, schemaExtensionError(extension.Position, "cannot extend %s: the type does not exist", extension.Name)
+			}
+			if len(extension.Interfaces) > 0 || len(extension.Types) > 0 || len(extension.EnumValues) > 0 {
+				return nil, schemaExtensionError(extension.Position, "the extension of %s can only add directives", extension.Name)
+			}
+
+			if err := declare(extension.Directives); err != nil {
+				return nil, err
+			}
+
+			definition := *original
+			definition.Directives = schemaExtensionDirectives(original.Directives, extension.Directives)
+
+			if len(extension.Fields) > 0 {
+				definition.Fields = append(ast.FieldList{}, original.Fields...)
+			}
+			for _, extensionField := range extension.Fields {
+				index := -1
+				for j, field := range definition.Fields {
+					if field.Name == extensionField.Name {
+						index = j
+						break
+					}
+				}
+				if index < 0 {
+					return nil, schemaExtensionError(extensionField.Position, "cannot extend %s.%s: the field does not exist", extension.Name, extensionField.Name)
+				}
+
+				if err := declare(extensionField.Directives); err != nil {
+					return nil, err
+				}
+
+				field := *definition.Fields[index]
+				field.Directives = schemaExtensionDirectives(field.Directives, extensionField.Directives)
+				definition.Fields[index] = &field
+			}
+
+			schemaReplaceDefinition(schema, original, &definition)
+		}
+	}
+
+	return declared, nil
+}
+
+// schemaExtensionDirectives returns the directives with the ones from the extensions in place of the ones with
+// the same name
+func schemaExtensionDirectives(existing ast.DirectiveList, extensions ast.DirectiveList) ast.DirectiveList {
+	result := ast.DirectiveList{}
+	for _, directive := range existing {
+		if extensions.ForName(directive.Name) == nil {
+			result = append(result, directive)
+		}
+	}
+
+	return append(result, extensions...)
+}
+
+// schemaReplaceDefinition points everything in the schema that referred to the definition to its replacement
+func schemaReplaceDefinition(schema *ast.Schema, original *ast.Definition, replacement *ast.Definition) {
+	schema.Types[replacement.Name] = replacement
+
+	for _, root := range []**ast.Definition{&schema.Query, &schema.Mutation, &schema.Subscription} {
+		if *root == original {
+			*root = replacement
+		}
+	}
+
+	for _, related := range []map[string][]*ast.Definition{schema.PossibleTypes, schema.Implements} {
+		for name, definitions := range related {
+			for i, definition := range definitions {
+				if definition == original {
+					replaced := append([]*ast.Definition{}, definitions...)
+					replaced[i] = replacement
+					related[name] = replaced
+					break
+				}
+			}
+		}
+	}
+}
+
+// schemaExtensionError returns an error that points to the position in the extensions
+func schemaExtensionError(position *ast.Position, message string, args ...interface{}) error {
+	message = fmt.Sprintf(message, args...)
+	if position == nil {
+		return fmt.Errorf("invalid schema extensions: %s", message)
+	}
+
+	return fmt.Errorf("invalid schema extensions (line %d): %s", position.Line, message)
+}
+
+// introspectedSchema returns the version of the schema that introspection reports
+func (g *Gateway) introspectedSchema(source *ast.Schema) *ast.Schema {
+	schema := g.exposeSchema(source)
+	if g.introspectSchemaExtensions || len(g.extensionDirectives) == 0 {
+		return schema
+	}
+
+	// copy the schema so we can remove the directives without touching the one the SDL is made from
+	introspected := *schema
+	introspected.Directives = map[string]*ast.DirectiveDefinition{}
+	for name, directive := range schema.Directives {
+		if !g.extensionDirectives.Has(name) {
+			introspected.Directives[name] = directive
+		}
+	}
+
+	return &introspected
+}
+
+// checkRequiredRoles returns an error if the operation of the plan selects a field that requires a role the user
+// doesn't have
+func (g *Gateway) checkRequiredRoles(ctx *RequestContext, plans QueryPlanList) error {
+	var roles Set
+
+	errs := graphql.ErrorList{}
+	for _, plan := range plans {
+		if plan.Operation == nil || (ctx.OperationName != "" && plan.Operation.Name != ctx.OperationName) {
+			continue
+		}
+
+		visit := func(field *ast.Field, path []interface{}) {
+			role := requiredRole(field)
+			if role == "" {
+				return
+			}
+
+			// the roles are only looked up for the operations that need them
+			if roles == nil {
+				roles = Set{}
+				if g.roles != nil {
+					for _, role := range g.roles(ctx.Context) {
+						roles.Add(role)
+					}
+				}
+			}
+
+			if !roles.Has(role) {
+				err := graphql.NewError("FORBIDDEN", fmt.Sprintf("the %s role is required to select %s.%s", role, field.ObjectDefinition.Name, field.Name))
+				err.Path = path
+				errs = append(errs, err)
+			}
+		}
+		walkOperationFields(plan.Operation.SelectionSet, plan.FragmentDefinitions, []interface{}{}, visit)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// requiredRole returns the role required to select the field, or an empty string if anybody can
+func requiredRole(field *ast.Field) string {
+	directive := field.Definition.Directives.ForName("requiresRole")
+	if directive == nil && field.ObjectDefinition != nil {
+		directive = field.ObjectDefinition.Directives.ForName("requiresRole")
+	}
+	if directive == nil {
+		return ""
+	}
+
+	if argument := directive.Arguments.ForName("role"); argument != nil && argument.Value != nil {
+		return argument.Value.Raw
+	}
+	return ""
+}
+
+// walkOperationFields calls visit with every field in the selection set (including the ones in fragments) and
+// the path of the field in the response
+func walkOperationFields(selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList, path []interface{}, visit func(*ast.Field, []interface{})) {
+	for _, field := range executorSelectedFields(selectionSet, fragments) {
+		if field.Definition == nil || strings.HasPrefix(field.Alias, "__gateway") {
+			continue
+		}
+
+		fieldPath := append(append([]interface{}{}, path...), field.Alias)
+		visit(field, fieldPath)
+		walkOperationFields(field.SelectionSet, fragments, fieldPath, visit)
+	}
+}
+
+// paginationDirectiveLimits returns the limits of the field, with the one from its @paginationLimit directive in
+// place of the one configured for the same argument
+func paginationDirectiveLimits(limits PaginationLimits, names []string, definition *ast.FieldDefinition) (PaginationLimits, []string) {
+	directive := definition.Directives.ForName("paginationLimit")
+	if directive == nil {
+		return limits, names
+	}
+
+	argument := "first"
+	if value := directive.Arguments.ForName("argument"); value != nil && value.Value != nil {
+		argument = value.Value.Raw
+	}
+
+	// the directive only changes the values it was given
+	limit := limits[argument]
+	if value := directive.Arguments.ForName("max"); value != nil && value.Value != nil {
+		if max, err := strconv.Atoi(value.Value.Raw); err == nil {
+			limit.Max = max
+		}
+	}
+	if value := directive.Arguments.ForName("default"); value != nil && value.Value != nil {
+		if defaultValue, err := strconv.Atoi(value.Value.Raw); err == nil {
+			limit.Default = defaultValue
+		}
+	}
+	if value := directive.Arguments.ForName("reject"); value != nil && value.Value != nil {
+		if value.Value.Raw == "true" {
+			limit.Behavior = PaginationReject
+		} else {
+			limit.Behavior = PaginationClamp
+		}
+	}
+
+	fieldLimits := PaginationLimits{}
+	for name, existing := range limits {
+		fieldLimits[name] = existing
+	}
+	fieldLimits[argument] = limit
+
+	return fieldLimits, sortedLimitNames(fieldLimits)
+}
@@ -0,0 +1,153 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+// the context key that the tests use to hold the roles of the user
+type schemaExtensionsRolesKey struct{}
+
+func TestGateway_schemaExtensions(t *testing.T) {
+	// gateway returns a gateway in front of a service with the designated options
+	gateway := func(options ...Option) (*Gateway, error) {
+		schema, _ := graphql.LoadSchema(`
+			type Order {
+				id: ID!
+				total: Int!
+			}
+
+			type User {
+				id: ID!
+				name: String!
+				orders(first: Int): [Order!]!
+			}
+
+			type Query {
+				viewer: User!
+			}
+		`)
+
+		return New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}}, options...)
+	}
+
+	extensions := `
+		directive @owner(team: String!) on OBJECT
+
+		extend type User @cacheControl(maxAge: 60) @owner(team: "accounts") {
+			orders: [Order] @requiresRole(role: "support") @paginationLimit(max: 2)
+		}
+	`
+
+	gw, err := gateway(
+		WithSchemaExtensions(extensions),
+		WithResponseCache(NewInMemoryCacheStore(), CachePolicy{MaxAge: time.Hour}),
+		WithRoles(func(ctx context.Context) []string {
+			roles, _ := ctx.Value(schemaExtensionsRolesKey{}).([]string)
+			return roles
+		}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the arguments of the directives can be looked up by the rest of the gateway
+	arguments, ok := gw.DirectiveArguments("User.orders", "paginationLimit")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"max": int64(2), "argument": "first", "reject": false}, arguments)
+	arguments, ok = gw.DirectiveArguments("User", "owner")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"team": "accounts"}, arguments)
+	_, ok = gw.DirectiveArguments("User.name", "requiresRole")
+	assert.False(t, ok)
+
+	// the directives are part of the exported schema
+	sdl, err := gw.SchemaSDL(context.Background())
+	if assert.Nil(t, err) {
+		assert.Contains(t, sdl, `@requiresRole(role: "support")`)
+		assert.Contains(t, sdl, `directive @owner`)
+	}
+
+	// but introspection doesn't report them
+	introspectDirectives := func(gw *Gateway) []string {
+		result := struct {
+			Schema struct {
+				Directives []struct{ Name string }
+			} `json:"__schema"`
+		}{}
+		ctx := &RequestContext{Context: context.Background(), Query: `{ __schema { directives { name } } }`}
+		plans, err := gw.GetPlans(ctx)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		response, err := gw.Execute(ctx, plans)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		assert.Nil(t, internalDecode(response, &result))
+
+		names := []string{}
+		for _, directive := range result.Schema.Directives {
+			names = append(names, directive.Name)
+		}
+		return names
+	}
+	assert.NotContains(t, introspectDirectives(gw), "requiresRole")
+	assert.NotContains(t, introspectDirectives(gw), "owner")
+
+	introspected, err := gateway(WithSchemaExtensions(extensions), WithIntrospectedSchemaExtensions())
+	if assert.Nil(t, err) {
+		assert.Contains(t, introspectDirectives(introspected), "requiresRole")
+	}
+
+	// the fields that need a role can only be selected by the users that have it
+	query := `{ viewer { name orders(first: 5) { total } } }`
+	_, err = gw.GetPlans(&RequestContext{Context: context.Background(), Query: query})
+	if assert.NotNil(t, err) {
+		forbidden := err.(graphql.ErrorList)[0].(*graphql.Error)
+		assert.Equal(t, "FORBIDDEN", forbidden.Extensions["code"])
+		assert.Equal(t, []interface{}{"viewer", "orders"}, forbidden.Path)
+	}
+
+	plans, err := gw.GetPlans(&RequestContext{
+		Context: context.WithValue(context.Background(), schemaExtensionsRolesKey{}, []string{"support"}),
+		Query:   query,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the page size is held to the limit of the field
+	if assert.Len(t, plans[0].PaginationWarnings, 1) {
+		assert.Equal(t, &PaginationWarning{
+			Path:       []string{"viewer", "orders"},
+			Coordinate: "User.orders",
+			Argument:   "first",
+			Requested:  5,
+			Applied:    2,
+		}, plans[0].PaginationWarnings[0])
+	}
+
+	// and the cache hint of the type applies to the fields that return it
+	policy, err := gw.responseCache.policyFor(gw.schema, gw.cacheHintIndex, plans[0])
+	if assert.Nil(t, err) {
+		assert.Equal(t, time.Minute, policy.MaxAge)
+	}
+
+	// the extensions have to refer to the schema that's there
+	for _, invalid := range []string{
+		`extend type Account @cacheControl(maxAge: 60)`,
+		`extend type User { email: String @cacheControl(maxAge: 60) }`,
+		`extend type User @unknown`,
+		`extend type User @cacheControl(ttl: 60)`,
+		`type Account { id: ID! }`,
+		`directive @deprecated on FIELD_DEFINITION`,
+	} {
+		_, err := gateway(WithSchemaExtensions(invalid))
+		assert.NotNil(t, err, invalid)
+	}
+}
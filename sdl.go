@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/formatter"
+)
+
+// SchemaSDL returns the gateway's merged schema in the schema definition language. The types and
+// directives that every GraphQL schema has are left out. Only the part of the schema that the visibility
+// profile of the context can see is included.
+func (g *Gateway) SchemaSDL(ctx context.Context) (string, error) {
+	schema, err := g.visibleSchema(ctx)
+	if err != nil {
+		return "", err
+	}
+	if schema == nil {
+		return "", errors.New("the gateway does not have a schema")
+	}
+	if g.introspectionDisabled(ctx) {
+		return "", ErrIntrospectionDisabled
+	}
+
+	return formatSchema(g.exposeSchema(schema))
+}
+
+// SchemaSDLHandler is a http.HandlerFunc that responds with the gateway's merged schema in the
+// schema definition language
+func (g *Gateway) SchemaSDLHandler(w http.ResponseWriter, r *http.Request) {
+	sdl, err := g.SchemaSDL(r.Context())
+	if err == ErrIntrospectionDisabled {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(sdl))
+}
+
+// formatSchema prints the user-defined types and directives of the schema
+func formatSchema(schema *ast.Schema) (string, error) {
+	// the types and directives that are defined by every schema
+	prelude, err := gqlparser.LoadSchema()
+	if err != nil {
+		return "", err
+	}
+
+	// the formatter only knows to leave out the definitions that were parsed as part of the prelude
+	// which isn't the case for schemas that were introspected so we have to do it ourselves
+	printed := &ast.Schema{
+		Query:        schema.Query,
+		Mutation:     schema.Mutation,
+		Subscription: schema.Subscription,
+		Types:        map[string]*ast.Definition{},
+		Directives:   map[string]*ast.DirectiveDefinition{},
+	}
+
+	for name, definition := range schema.Types {
+		if _, ok := prelude.Types[name]; ok {
+			continue
+		}
+
+		// introspection records the possible types of interfaces which the formatter would print as union members
+		if definition.Kind != ast.Union && len(definition.Types) > 0 {
+			withoutTypes := *definition
+			withoutTypes.Types = nil
+			definition = &withoutTypes
+		}
+		printed.Types[name] = definition
+	}
+
+	for name, directive := range schema.Directives {
+		if _, ok := prelude.Directives[name]; ok {
+			continue
+		}
+
+		// introspected directives don't have a position which the formatter needs
+		if directive.Position == nil || directive.Position.Src == nil {
+			withPosition := *directive
+			withPosition.Position = &ast.Position{Src: &ast.Source{}}
+			directive = &withPosition
+		}
+		printed.Directives[name] = directive
+	}
+
+	buf := &bytes.Buffer{}
+	formatter.NewFormatter(buf).FormatSchema(printed)
+
+	return buf.String(), nil
+}
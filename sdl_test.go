@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_schemaSDL(t *testing.T) {
+	schema1, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		directive @audit(reason: String!) on FIELD_DEFINITION
+
+		enum Role {
+			"someone who can do anything"
+			ADMIN
+			MEMBER @deprecated(reason: "everyone is an admin now")
+		}
+
+		"""
+		The ways users can be filtered
+		"""
+		input UserFilter {
+			role: Role = ADMIN
+			names: [String!]
+		}
+
+		type User implements Node {
+			id: ID!
+			"the name the user signed up with"
+			name: String!
+			role: Role! @audit(reason: "compliance")
+		}
+
+		type Query {
+			users(filter: UserFilter, first: Int = 10): [User!]!
+		}
+	`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	schema2, err := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Photo implements Node {
+			id: ID!
+			url: String!
+		}
+
+		type Video implements Node {
+			id: ID!
+			duration: Float
+		}
+
+		union Media = Photo | Video
+
+		type Query {
+			media(first: Int!): [Media!]!
+		}
+
+		type Mutation {
+			deleteMedia(ids: [ID!]!): Boolean!
+		}
+	`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schema1, URL: "url1"},
+		{Schema: schema2, URL: "url2"},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	sdl, err := gateway.SchemaSDL(context.Background())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// make sure everything made it into the schema
+	for _, expected := range []string{
+		`The ways users can be filtered`,
+		`directive @audit(reason: String!) on FIELD_DEFINITION`,
+		`MEMBER @deprecated(reason: "everyone is an admin now")`,
+		`role: Role = ADMIN`,
+		`type User implements Node`,
+		`role: Role! @audit(reason: "compliance")`,
+		`union Media = Photo | Video`,
+		`deleteMedia(ids: [ID!]!): Boolean!`,
+		`node(id: ID!): Node`,
+	} {
+		assert.Contains(t, sdl, expected)
+	}
+
+	// the built-in types aren't part of the output
+	assert.NotContains(t, sdl, "__Schema")
+	assert.NotContains(t, sdl, "directive @skip")
+
+	// loading the schema and printing it again should give us the same thing
+	loaded, err := graphql.LoadSchema(sdl)
+	if !assert.Nil(t, err, sdl) {
+		return
+	}
+	reprinted, err := formatSchema(loaded)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, sdl, reprinted)
+
+	t.Run("Handler", func(t *testing.T) {
+		responseRecorder := httptest.NewRecorder()
+		gateway.SchemaSDLHandler(responseRecorder, httptest.NewRequest("GET", "/schema.graphql", nil))
+
+		assert.Equal(t, http.StatusOK, responseRecorder.Code)
+		assert.True(t, strings.HasPrefix(responseRecorder.Header().Get("Content-Type"), "text/plain"))
+		assert.Equal(t, sdl, responseRecorder.Body.String())
+	})
+
+	t.Run("Handler without introspection", func(t *testing.T) {
+		// the schema is only handed out where it could be introspected
+		introspection := false
+		ctx := context.WithValue(context.Background(), handlerConfigKey{}, &handlerConfig{introspection: &introspection})
+
+		_, err := gateway.SchemaSDL(ctx)
+		assert.Equal(t, ErrIntrospectionDisabled, err)
+	})
+}
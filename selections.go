@@ -0,0 +1,40 @@
+package gateway
+
+import (
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// The selection sets of a plan are shared by every request that uses it and by every invocation of its steps,
+// which all walk them at the same time. graphql.ApplyFragments saves the selection sets it merges over the
+// ones of the fields it was given so it can't be used on them. collectSelectedFields flattens a selection set
+// the same way without touching it.
+
+// collectSelectedFields returns the fields of the selection set, including the ones inside of fragments, with
+// one entry for every key in the response. A field that is selected more than once is replaced by a copy whose
+// selection set holds the selections of every one of them. Unlike graphql.ApplyFragments, the fragments inside
+// of the fields' selection sets are left for the caller to collect when it gets there.
+func collectSelectedFields(selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) ast.SelectionSet {
+	result := ast.SelectionSet{}
+	indices := map[string]int{}
+
+	for _, field := range executorSelectedFields(selectionSet, fragments) {
+		key := field.Alias
+		if key == "" {
+			key = field.Name
+		}
+
+		index, ok := indices[key]
+		if !ok {
+			indices[key] = len(result)
+			result = append(result, field)
+			continue
+		}
+
+		existing := result[index].(*ast.Field)
+		merged := *existing
+		merged.SelectionSet = append(append(ast.SelectionSet{}, existing.SelectionSet...), field.SelectionSet...)
+		result[index] = &merged
+	}
+
+	return result
+}
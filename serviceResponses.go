@@ -0,0 +1,132 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// A service behind a misconfigured proxy can respond with an HTML error page, an empty body, or JSON that
+// has nothing to do with GraphQL. Instead of letting those fall through to the stitching (where they turn into
+// confusing errors about missing objects), the gateway checks the envelope of every response it gets from a
+// service and reports the ones that aren't GraphQL responses along with the service that sent them.
+
+// the number of characters of a bad response that are included in the error
+const serviceResponseSnippetLength = 200
+
+// ServiceResponseError is returned when a service responds with something that isn't a GraphQL response
+type ServiceResponseError struct {
+	// the url of the service
+	URL string
+	// the status code of the response
+	StatusCode int
+	// the start of the body of the response
+	Body string
+	// what was wrong with the response
+	Reason string
+}
+
+func (e *ServiceResponseError) Error() string {
+	return fmt.Sprintf("%s from %s (status %d): %q", e.Reason, e.URL, e.StatusCode, e.Body)
+}
+
+// checkServiceResponse returns an error if the response isn't a GraphQL response
+func checkServiceResponse(url string, statusCode int, body []byte) error {
+	invalid := func(reason string) error {
+		return &ServiceResponseError{
+			URL:        url,
+			StatusCode: statusCode,
+			Body:       responseSnippet(body),
+			Reason:     reason,
+		}
+	}
+
+	if statusCode < 200 || statusCode > 299 {
+		return invalid("unsuccessful response")
+	}
+
+	envelope := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return invalid("service returned non-GraphQL response")
+	}
+
+	// the errors are enough to say what went wrong, even without any data
+	if errs, ok := envelope["errors"]; ok && string(errs) != "null" {
+		return nil
+	}
+
+	data, ok := envelope["data"]
+	if !ok || string(data) == "null" {
+		return invalid("service returned a response without data or errors")
+	}
+	if object := map[string]interface{}{}; json.Unmarshal(data, &object) != nil {
+		return invalid("service returned non-GraphQL response")
+	}
+
+	return nil
+}
+
+// serviceResponse is the envelope of a response from a service, decoded once by the transport
+type serviceResponse struct {
+	Data       map[string]interface{} `json:"data"`
+	Errors     json.RawMessage        `json:"errors"`
+	Extensions map[string]interface{} `json:"extensions"`
+}
+
+// hasErrors returns true if the service sent back any errors
+func (r *serviceResponse) hasErrors() bool {
+	return len(r.Errors) > 0 && string(r.Errors) != "null"
+}
+
+// the body the queryer is given in place of a response whose data was handed to the executor directly
+var emptyServiceResponse = []byte(`{"data":{}}`)
+
+// decodeServiceResponse checks the response like checkServiceResponse and decodes it, keeping the numbers that
+// wouldn't fit in a float64 as json.Number. Nothing is returned for the unusual responses that are valid but
+// don't fit in the envelope (ie, errors next to data that isn't an object). They are left for the queryer.
+func decodeServiceResponse(url string, statusCode int, body []byte) (*serviceResponse, error) {
+	if statusCode < 200 || statusCode > 299 {
+		return nil, checkServiceResponse(url, statusCode, body)
+	}
+
+	response := &serviceResponse{}
+	if err := unmarshalJSON(body, response); err != nil {
+		return nil, checkServiceResponse(url, statusCode, body)
+	}
+
+	// the errors are enough to say what went wrong, even without any data
+	if !response.hasErrors() && response.Data == nil {
+		return nil, checkServiceResponse(url, statusCode, body)
+	}
+
+	return response, nil
+}
+
+// responseSnippet returns the start of the body, cut off at a reasonable length
+func responseSnippet(body []byte) string {
+	snippet := strings.TrimSpace(string(body))
+	if len(snippet) <= serviceResponseSnippetLength {
+		return snippet
+	}
+
+	// don't cut a character in half
+	end := serviceResponseSnippetLength
+	for end > 0 && !utf8.RuneStart(snippet[end]) {
+		end--
+	}
+
+	return snippet[:end] + "..."
+}
+
+// unwrapServiceResponseError returns the ServiceResponseError behind the error the http client returned, if there is one
+func unwrapServiceResponseError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		if responseErr, ok := urlErr.Err.(*ServiceResponseError); ok {
+			return responseErr
+		}
+	}
+
+	return err
+}
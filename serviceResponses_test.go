@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_malformedServiceResponses(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			allUsers: [String!]!
+		}
+	`)
+
+	table := []struct {
+		Name        string
+		Status      int
+		ContentType string
+		Body        string
+		// the pieces of the error we expect
+		Error []string
+	}{
+		{
+			Name:        "Error page",
+			Status:      http.StatusBadGateway,
+			ContentType: "text/html",
+			Body:        "<html><body>Bad Gateway</body></html>",
+			Error:       []string{"unsuccessful response", "status 502", "Bad Gateway"},
+		},
+		{
+			Name:        "HTML with a successful status",
+			Status:      http.StatusOK,
+			ContentType: "text/html",
+			Body:        "<html><body>Please log in</body></html>",
+			Error:       []string{"service returned non-GraphQL response", "status 200", "Please log in"},
+		},
+		{
+			Name:   "Empty body",
+			Status: http.StatusOK,
+			Body:   "",
+			Error:  []string{"service returned non-GraphQL response", `""`},
+		},
+		{
+			Name:        "JSON without data",
+			Status:      http.StatusOK,
+			ContentType: "application/json",
+			Body:        `{"message": "not found"}`,
+			Error:       []string{"service returned a response without data or errors"},
+		},
+		{
+			Name:        "Data that isn't an object",
+			Status:      http.StatusOK,
+			ContentType: "application/json",
+			Body:        `{"data": "hello"}`,
+			Error:       []string{"service returned non-GraphQL response"},
+		},
+		{
+			Name:        "Long body",
+			Status:      http.StatusInternalServerError,
+			ContentType: "text/plain",
+			Body:        strings.Repeat("a", 1000),
+			Error:       []string{strings.Repeat("a", serviceResponseSnippetLength) + `..."`},
+		},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if row.ContentType != "" {
+					w.Header().Set("Content-Type", row.ContentType)
+				}
+				w.WriteHeader(row.Status)
+				w.Write([]byte(row.Body))
+			}))
+			defer server.Close()
+
+			gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: server.URL}})
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			ctx := &RequestContext{Context: context.Background(), Query: "{ allUsers }"}
+			plans, err := gateway.GetPlans(ctx)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			// the executor collects the errors of every step
+			_, err = gateway.Execute(ctx, plans)
+			errs, ok := err.(graphql.ErrorList)
+			if !assert.True(t, ok, "unexpected error: %v", err) || !assert.Len(t, errs, 1) {
+				return
+			}
+			responseErr, ok := errs[0].(*ServiceResponseError)
+			if !assert.True(t, ok, "unexpected error: %v", errs[0]) {
+				return
+			}
+
+			// the error has to point to the service
+			assert.Equal(t, server.URL, responseErr.URL)
+			assert.Equal(t, row.Status, responseErr.StatusCode)
+			for _, piece := range append(row.Error, server.URL) {
+				assert.Contains(t, err.Error(), piece)
+			}
+		})
+	}
+
+	t.Run("Errors without data", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"errors": [{"message": "not allowed", "extensions": {"code": "FORBIDDEN"}}]}`))
+		}))
+		defer server.Close()
+
+		gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: server.URL}})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		ctx := &RequestContext{Context: context.Background(), Query: "{ allUsers }"}
+		plans, err := gateway.GetPlans(ctx)
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// the service's errors are passed along as they are
+		_, err = gateway.Execute(ctx, plans)
+		errs, ok := err.(graphql.ErrorList)
+		if !assert.True(t, ok, "unexpected error: %v", err) || !assert.Len(t, errs, 1) {
+			return
+		}
+		assert.Equal(t, "not allowed", errs[0].(*graphql.Error).Message)
+		assert.Equal(t, "FORBIDDEN", errs[0].(*graphql.Error).Extensions["code"])
+	})
+}
@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2"
+)
+
+// ServiceInfo describes one of the services behind the gateway
+type ServiceInfo struct {
+	URL string `json:"url"`
+	// the number of types the service defines, not including the ones every schema has
+	TypeCount int `json:"typeCount"`
+	// the number of fields the gateway can send to the service, including __typename
+	FieldCount int `json:"fieldCount"`
+	// when the schema of the service was introspected
+	IntrospectedAt time.Time `json:"introspectedAt"`
+	// true if the schema was loaded from a snapshot because the service could not be introspected
+	Stale bool `json:"stale"`
+}
+
+// Services returns the services behind the gateway
+func (g *Gateway) Services() []ServiceInfo {
+	g.schemaMutex.RLock()
+	defer g.schemaMutex.RUnlock()
+
+	return append([]ServiceInfo{}, g.services...)
+}
+
+// FieldLocations returns the url of every service that can resolve the field. Fields that are resolved by
+// the gateway itself don't have a location.
+func (g *Gateway) FieldLocations(parentType string, field string) ([]string, error) {
+	g.schemaMutex.RLock()
+	defer g.schemaMutex.RUnlock()
+
+	locations, err := g.fieldURLs.URLFor(parentType, field)
+	if err != nil {
+		return nil, err
+	}
+
+	return servicesWithoutInternal(locations), nil
+}
+
+// ServiceFields returns the fields (as Type.field) that the gateway can send to the service at the url
+func (g *Gateway) ServiceFields(url string) []string {
+	g.schemaMutex.RLock()
+	defer g.schemaMutex.RUnlock()
+
+	return g.locationFields.FieldsAt(url)
+}
+
+// FieldLocationsHandler is a http.HandlerFunc that responds with the services behind the gateway and the
+// locations of every field as JSON. It exposes the layout of the services so it should only be mounted
+// somewhere that operators can reach.
+func (g *Gateway) FieldLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	g.schemaMutex.RLock()
+	services := append([]ServiceInfo{}, g.services...)
+
+	// group the locations by type so they are easy to look through
+	fields := map[string]map[string][]string{}
+	for key, locations := range g.fieldURLs {
+		locations = servicesWithoutInternal(locations)
+		if len(locations) == 0 {
+			continue
+		}
+
+		separator := strings.LastIndex(key, ".")
+		parentType, field := key[:separator], key[separator+1:]
+		if _, ok := fields[parentType]; !ok {
+			fields[parentType] = map[string][]string{}
+		}
+		fields[parentType][field] = locations
+	}
+	g.schemaMutex.RUnlock()
+
+	response, err := json.Marshal(map[string]interface{}{
+		"services": services,
+		"fields":   fields,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// serviceInfo builds the description of each of the sources
+func (g *Gateway) serviceInfo(sources []*graphql.RemoteSchema, introspectedAt map[string]time.Time, fields LocationFieldMap) []ServiceInfo {
+	stale := Set{}
+	for _, url := range g.staleServices {
+		stale.Add(url)
+	}
+
+	// the types that every schema has don't say anything about the service
+	prelude, _ := gqlparser.LoadSchema()
+
+	services := []ServiceInfo{}
+	for _, source := range sources {
+		typeCount := 0
+		for name := range source.Schema.Types {
+			if prelude == nil || prelude.Types[name] == nil {
+				typeCount++
+			}
+		}
+
+		services = append(services, ServiceInfo{
+			URL:            source.URL,
+			TypeCount:      typeCount,
+			FieldCount:     len(fields[source.URL]),
+			IntrospectedAt: introspectedAt[source.URL],
+			Stale:          stale.Has(source.URL),
+		})
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].URL < services[j].URL
+	})
+
+	return services
+}
+
+// servicesWithoutInternal returns a copy of the locations without the gateway's internal schema
+func servicesWithoutInternal(locations []string) []string {
+	services := []string{}
+	for _, location := range locations {
+		if location != internalSchemaLocation {
+			services = append(services, location)
+		}
+	}
+
+	return services
+}
@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_fieldLocations(t *testing.T) {
+	schema1, _ := graphql.LoadSchema(`
+		type Product {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			products: [Product!]!
+		}
+	`)
+	schema2, _ := graphql.LoadSchema(`
+		type Product {
+			id: ID!
+			price: Float!
+		}
+
+		type Query {
+			product(id: ID!): Product
+		}
+	`)
+
+	// the services can't look up each other's products but this gateway never has to
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: schema2, URL: "url2"},
+		{Schema: schema1, URL: "url1"},
+	}, WithSchemaCompatibilityWarnings())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	locations, err := gateway.FieldLocations("Product", "price")
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"url2"}, locations)
+	}
+	locations, err = gateway.FieldLocations("Product", "id")
+	if assert.Nil(t, err) {
+		assert.ElementsMatch(t, []string{"url1", "url2"}, locations)
+	}
+
+	// changing the result can't change the gateway
+	locations[0] = "somewhere else"
+	locations, _ = gateway.FieldLocations("Product", "id")
+	assert.ElementsMatch(t, []string{"url1", "url2"}, locations)
+
+	// the gateway resolves node on its own
+	locations, err = gateway.FieldLocations("Query", "node")
+	if assert.Nil(t, err) {
+		assert.Empty(t, locations)
+	}
+
+	_, err = gateway.FieldLocations("Product", "weight")
+	assert.NotNil(t, err)
+
+	// the services are reported in a stable order
+	services := gateway.Services()
+	if assert.Len(t, services, 2) {
+		assert.Equal(t, "url1", services[0].URL)
+		assert.Equal(t, 2, services[0].TypeCount)
+		assert.False(t, services[0].IntrospectedAt.IsZero())
+		assert.False(t, services[0].Stale)
+		assert.Equal(t, "url2", services[1].URL)
+	}
+
+	// the handler shows the same information
+	responseRecorder := httptest.NewRecorder()
+	gateway.FieldLocationsHandler(responseRecorder, httptest.NewRequest("GET", "/admin/fields", nil))
+
+	result := struct {
+		Services []ServiceInfo
+		Fields   map[string]map[string][]string
+	}{}
+	if assert.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &result)) {
+		assert.Len(t, result.Services, 2)
+		assert.Equal(t, []string{"url2"}, result.Fields["Product"]["price"])
+		assert.Equal(t, []string{"url1"}, result.Fields["Query"]["products"])
+		assert.NotContains(t, result.Fields["Query"], "node")
+	}
+
+	// the information can be read while the gateway is planning queries
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gateway.GetPlans(&RequestContext{Query: `{ products { name price } }`})
+			gateway.FieldLocations("Product", "price")
+			gateway.Services()
+		}()
+	}
+	wg.Wait()
+}
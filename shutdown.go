@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// ErrGatewayShuttingDown is returned when the gateway is asked to execute an operation after Shutdown has been called
+var ErrGatewayShuttingDown = errors.New("the gateway is shutting down")
+
+// shutdownRetryAfter is the number of seconds clients are told to wait before trying a request
+// that was rejected because the gateway is shutting down
+const shutdownRetryAfter = 5
+
+// Stopper is implemented by the parts of the gateway (planners, executors, caches, stores) that run goroutines
+// in the background which need to be stopped when the gateway shuts down
+type Stopper interface {
+	Stop()
+}
+
+// Shutdown gracefully stops the gateway. Once called, the gateway stops accepting new operations and waits
+// for the ones that are in flight to finish. If the context expires before then, the remaining operations are
+// canceled and the context's error is returned. Shutdown also stops any background goroutines owned by the gateway.
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	// stop accepting new work
+	g.lifecycleMutex.Lock()
+	g.shuttingDown = true
+	g.lifecycleMutex.Unlock()
+
+	// stop anything that's running in the background
+	services := []interface{}{g.planner, g.executor, g.queryPlanCache, g.asyncStore}
+	for _, executor := range g.namedExecutors {
+		services = append(services, executor)
+	}
+	for _, service := range services {
+		if stopper, ok := service.(Stopper); ok {
+			stopper.Stop()
+		}
+	}
+	if g.responseCache != nil {
+		if stopper, ok := g.responseCache.store.(Stopper); ok {
+			stopper.Stop()
+		}
+	}
+
+	// wait for the operations that are in flight
+	drained := make(chan bool)
+	go func() {
+		g.inFlight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		g.cancelInFlight()
+	case <-ctx.Done():
+		// we ran out of time so cancel whatever is left
+		g.cancelInFlight()
+		err = ctx.Err()
+	}
+
+	// the usage of the operations that just finished still has to be reported
+	if g.usageReporter != nil {
+		g.usageReporter.Stop()
+	}
+
+	return err
+}
+
+// trackOperation registers an operation that Shutdown has to wait for. The returned function must
+// be called when the operation is done.
+func (g *Gateway) trackOperation() (func(), error) {
+	g.lifecycleMutex.Lock()
+	defer g.lifecycleMutex.Unlock()
+
+	if g.shuttingDown {
+		return nil, ErrGatewayShuttingDown
+	}
+
+	g.inFlight.Add(1)
+	return g.inFlight.Done, nil
+}
+
+// withShutdown returns a context that is canceled along with ctx or when the gateway gives up
+// on the operations in flight
+func (g *Gateway) withShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		select {
+		case <-g.shutdownCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// rejectShuttingDown tells the client to come back later since the gateway is shutting down
+func (g *Gateway) rejectShuttingDown(w http.ResponseWriter, r *http.Request) {
+	response, _ := json.Marshal(g.errorResponse(r.Context(), nil, ErrGatewayShuttingDown, "SERVICE_UNAVAILABLE"))
+
+	w.Header().Set("Retry-After", strconv.Itoa(shutdownRetryAfter))
+	emitResponse(w, http.StatusServiceUnavailable, string(response))
+}
@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_shutdownDrainsRequests(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			slow: String!
+		}
+	`)
+
+	// an executor that waits until we tell it to finish
+	started := make(chan bool)
+	release := make(chan bool)
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithExecutor(ExecutorFunc(
+		func(ctx *ExecutionContext) (map[string]interface{}, error) {
+			started <- true
+			<-release
+			return map[string]interface{}{"slow": "done"}, nil
+		},
+	)))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	send := func() *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "{ slow }"}`))
+		responseRecorder := httptest.NewRecorder()
+		gateway.GraphQLHandler(responseRecorder, request)
+		return responseRecorder
+	}
+
+	// start a request that will be in flight when we shut down
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() {
+		inFlight <- send()
+	}()
+	<-started
+
+	// start shutting down
+	shutdownErr := make(chan error)
+	go func() {
+		shutdownErr <- gateway.Shutdown(context.Background())
+	}()
+
+	// wait for the gateway to stop accepting requests
+	for i := 0; i < 100; i++ {
+		gateway.lifecycleMutex.Lock()
+		shuttingDown := gateway.shuttingDown
+		gateway.lifecycleMutex.Unlock()
+
+		if shuttingDown {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// new requests are turned away
+	rejected := send()
+	assert.Equal(t, http.StatusServiceUnavailable, rejected.Code)
+	assert.Equal(t, "5", rejected.Header().Get("Retry-After"))
+
+	// the shutdown has to wait for the request in flight
+	select {
+	case <-shutdownErr:
+		t.Error("shutdown finished before the request in flight")
+		return
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// let the request finish
+	release <- true
+
+	response := <-inFlight
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `{"data":{"slow":"done"}}`, response.Body.String())
+	assert.Nil(t, <-shutdownErr)
+}
+
+func TestGateway_shutdownCancelsRequests(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			stuck: String!
+		}
+	`)
+
+	// an executor that only finishes when its context is canceled
+	started := make(chan bool)
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}}, WithExecutor(ExecutorFunc(
+		func(ctx *ExecutionContext) (map[string]interface{}, error) {
+			started <- true
+			<-ctx.RequestContext.Done()
+			return nil, ctx.RequestContext.Err()
+		},
+	)))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	result := make(chan error)
+	go func() {
+		_, err := gateway.Execute(&RequestContext{Context: context.Background(), Query: "{ stuck }"}, QueryPlanList{{}})
+		result <- err
+	}()
+	<-started
+
+	// give up on the request almost immediately
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, gateway.Shutdown(ctx))
+
+	// the request should have been canceled
+	assert.Equal(t, context.Canceled, <-result)
+
+	// and new ones are turned away
+	_, err = gateway.Execute(&RequestContext{Context: context.Background(), Query: "{ stuck }"}, QueryPlanList{{}})
+	assert.Equal(t, ErrGatewayShuttingDown, err)
+}
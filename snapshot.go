@@ -0,0 +1,374 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// When a gateway is created with WithSchemaSnapshot, the schema of every service is saved after it has been
+// introspected. If the gateway is restarted while one of the services is unavailable, the schema of that service
+// is loaded from the snapshot so that the rest of the API can still be served.
+
+// SchemaSnapshot is the last known good version of the schemas behind the gateway
+type SchemaSnapshot struct {
+	// Version is a hash of every schema in the snapshot which can be used to detect when a service changed
+	Version  string             `json:"version"`
+	Services []*ServiceSnapshot `json:"services"`
+}
+
+// ServiceSnapshot holds the schema of a single service
+type ServiceSnapshot struct {
+	URL            string    `json:"url"`
+	SDL            string    `json:"sdl"`
+	Federated      bool      `json:"federated"`
+	Hash           string    `json:"hash"`
+	IntrospectedAt time.Time `json:"introspectedAt"`
+}
+
+// SnapshotStore persists the snapshot of the gateway's schemas. Load returns a nil snapshot if one has not been saved yet.
+type SnapshotStore interface {
+	Load() (*SchemaSnapshot, error)
+	Save(snapshot *SchemaSnapshot) error
+}
+
+// FileSnapshotStore is a SnapshotStore that saves the snapshot as JSON in a file on disk
+type FileSnapshotStore struct {
+	Path string
+}
+
+// NewFileSnapshotStore returns a SnapshotStore that keeps the snapshot in the file at the designated path
+func NewFileSnapshotStore(path string) *FileSnapshotStore {
+	return &FileSnapshotStore{Path: path}
+}
+
+// Load reads the snapshot from the file
+func (s *FileSnapshotStore) Load() (*SchemaSnapshot, error) {
+	contents, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &SchemaSnapshot{}
+	if err := json.Unmarshal(contents, snapshot); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// Save writes the snapshot to the file. The file is replaced in one step so that a gateway that
+// stops halfway through never leaves a broken snapshot behind.
+func (s *FileSnapshotStore) Save(snapshot *SchemaSnapshot) error {
+	contents, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// WithSchemaSnapshot returns an Option that saves the schema of every service to the store once the gateway
+// has been created. Sources that are passed to New without a schema are introspected, and if that fails, their
+// schema is loaded from the snapshot instead.
+func WithSchemaSnapshot(store SnapshotStore) Option {
+	return func(g *Gateway) {
+		g.snapshotStore = store
+	}
+}
+
+// SchemaVersion returns a hash that identifies the schemas of the services behind the gateway
+func (g *Gateway) SchemaVersion() string {
+	g.schemaMutex.RLock()
+	defer g.schemaMutex.RUnlock()
+
+	return g.schemaVersion
+}
+
+// StaleServices returns the url of every service whose schema was loaded from a snapshot because
+// the service could not be introspected
+func (g *Gateway) StaleServices() []string {
+	g.schemaMutex.RLock()
+	defer g.schemaMutex.RUnlock()
+
+	return append([]string(nil), g.staleServices...)
+}
+
+// HealthHandler is a http.HandlerFunc that reports the version of the gateway's schema and any services
+// that are being served from a snapshot or were left out of the gateway
+func (g *Gateway) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	response, err := json.Marshal(g.health())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// health returns the body of the gateway's health report
+func (g *Gateway) health() map[string]interface{} {
+	staleServices := g.StaleServices()
+	if staleServices == nil {
+		staleServices = []string{}
+	}
+	unavailableServices := g.UnavailableServices()
+	if unavailableServices == nil {
+		unavailableServices = []string{}
+	}
+
+	status := "ok"
+	if len(staleServices) > 0 || len(unavailableServices) > 0 {
+		status = "degraded"
+	}
+
+	return map[string]interface{}{
+		"status":              status,
+		"schemaVersion":       g.SchemaVersion(),
+		"staleServices":       staleServices,
+		"unavailableServices": unavailableServices,
+	}
+}
+
+// resolvedSources holds the schemas of the services once the ones we only had the url of were introspected
+type resolvedSources struct {
+	sources []*graphql.RemoteSchema
+	// the time each schema was introspected, indexed by url
+	introspectedAt map[string]time.Time
+	// the services whose schema came from the snapshot
+	staleServices []string
+	// the services that were left out because they couldn't be introspected
+	unavailableServices []string
+}
+
+// resolveSources introspects the sources that were passed to New without a schema, falling back to the
+// snapshot for the services that can't be reached
+func (g *Gateway) resolveSources(sources []*graphql.RemoteSchema) (*resolvedSources, error) {
+	var snapshot *SchemaSnapshot
+	result := &resolvedSources{
+		sources:        []*graphql.RemoteSchema{},
+		introspectedAt: map[string]time.Time{},
+	}
+
+	// we want to report every service that we couldn't reach, not just the first one
+	errs := MultiError{}
+
+	for _, source := range sources {
+		// the schemas we were given were introspected as part of creating the gateway
+		if source.Schema != nil {
+			result.sources = append(result.sources, source)
+			result.introspectedAt[source.URL] = time.Now()
+			continue
+		}
+
+		// the service is introspected the way we were told to, with its credentials
+		introspected, err := IntrospectRemoteSchemaWithOptions(source.URL, g.serviceIntrospection(source.URL))
+		if err == nil {
+			result.sources = append(result.sources, introspected)
+			result.introspectedAt[source.URL] = time.Now()
+			continue
+		}
+
+		// if we don't have a snapshot, there's nothing to fall back to
+		if g.snapshotStore == nil {
+			errs = append(errs, &ServiceError{URL: source.URL, Stage: ServiceErrorIntrospection, Err: err})
+			continue
+		}
+
+		// only load the snapshot the first time we need it
+		if snapshot == nil {
+			snapshot, err = g.snapshotStore.Load()
+			if err != nil {
+				return nil, fmt.Errorf("could not load schema snapshot: %s", err.Error())
+			}
+			if snapshot == nil {
+				snapshot = &SchemaSnapshot{}
+			}
+		}
+
+		service := snapshot.ForURL(source.URL)
+		if service == nil {
+			errs = append(errs, &ServiceError{
+				URL:   source.URL,
+				Stage: ServiceErrorIntrospection,
+				Err:   fmt.Errorf("%s (and it is not in the schema snapshot)", err.Error()),
+			})
+			continue
+		}
+
+		remoteSchema, loadErr := service.RemoteSchema()
+		if loadErr != nil {
+			errs = append(errs, &ServiceError{
+				URL:   source.URL,
+				Stage: ServiceErrorIntrospection,
+				Err:   fmt.Errorf("%s (and its snapshot could not be loaded: %s)", err.Error(), loadErr.Error()),
+			})
+			continue
+		}
+
+		log.Warn("Could not introspect ", source.URL, ", using the schema from the snapshot: ", err)
+		result.staleServices = append(result.staleServices, source.URL)
+		result.sources = append(result.sources, remoteSchema)
+		result.introspectedAt[source.URL] = service.IntrospectedAt
+	}
+
+	if len(errs) > 0 {
+		// the gateway can start without the services that are down if we've been told it's okay
+		if !g.partialBoot || len(result.sources) == 0 {
+			return nil, errs
+		}
+
+		for _, err := range errs {
+			log.Warn("Leaving ", err.URL, " out of the gateway: ", err.Err)
+			result.unavailableServices = append(result.unavailableServices, err.URL)
+		}
+	}
+
+	return result, nil
+}
+
+// snapshotSources builds the snapshot of the sources and saves it to the store if there is one. It returns the
+// version of the snapshot.
+func (g *Gateway) snapshotSources(sources []*graphql.RemoteSchema, introspectedAt map[string]time.Time) (string, error) {
+	snapshot := &SchemaSnapshot{}
+	for _, source := range sources {
+		service, err := newServiceSnapshot(source)
+		if err != nil {
+			return "", err
+		}
+		service.IntrospectedAt = introspectedAt[source.URL]
+		snapshot.Services = append(snapshot.Services, service)
+	}
+	snapshot.Version = snapshotVersion(snapshot.Services)
+
+	if g.snapshotStore == nil {
+		return snapshot.Version, nil
+	}
+
+	// let the operators know if a service changed since the last snapshot
+	previous, err := g.snapshotStore.Load()
+	if err != nil {
+		log.Warn("Could not load schema snapshot: ", err)
+	} else if previous != nil && previous.Version != snapshot.Version {
+		for _, service := range snapshot.Services {
+			if before := previous.ForURL(service.URL); before != nil && before.Hash != service.Hash {
+				log.Info("The schema of ", service.URL, " changed since the last snapshot")
+			}
+		}
+	}
+
+	return snapshot.Version, g.snapshotStore.Save(snapshot)
+}
+
+// ForURL returns the snapshot of the service at the designated url
+func (s *SchemaSnapshot) ForURL(url string) *ServiceSnapshot {
+	for _, service := range s.Services {
+		if service.URL == url {
+			return service
+		}
+	}
+
+	return nil
+}
+
+// RemoteSchema loads the schema in the snapshot
+func (s *ServiceSnapshot) RemoteSchema() (*graphql.RemoteSchema, error) {
+	if s.Federated {
+		schema, err := LoadFederatedSchema(s.SDL)
+		if err != nil {
+			return nil, err
+		}
+		return &graphql.RemoteSchema{URL: s.URL, Schema: schema}, nil
+	}
+
+	schema, err := gqlparser.LoadSchema(&ast.Source{Name: s.URL, Input: s.SDL})
+	if err != nil {
+		return nil, err
+	}
+
+	return &graphql.RemoteSchema{URL: s.URL, Schema: schema}, nil
+}
+
+// newServiceSnapshot returns the snapshot of the designated source
+func newServiceSnapshot(source *graphql.RemoteSchema) (*ServiceSnapshot, error) {
+	federated := isFederatedSchema(source.Schema)
+
+	// the definitions that LoadFederatedSchema adds can't show up in the SDL again
+	schema := source.Schema
+	if federated {
+		copied := *schema
+		copied.Types = map[string]*ast.Definition{}
+		copied.Directives = map[string]*ast.DirectiveDefinition{}
+		for name, definition := range schema.Types {
+			if name != "_Any" && name != "_FieldSet" {
+				copied.Types[name] = definition
+			}
+		}
+		for name, directive := range schema.Directives {
+			if !federationDirectives.Has(name) {
+				copied.Directives[name] = directive
+			}
+		}
+		schema = &copied
+	}
+
+	sdl, err := formatSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(sdl))
+	return &ServiceSnapshot{
+		URL:       source.URL,
+		SDL:       sdl,
+		Federated: federated,
+		Hash:      hex.EncodeToString(hash[:]),
+	}, nil
+}
+
+// snapshotVersion computes the version of a snapshot with the designated services
+func snapshotVersion(services []*ServiceSnapshot) string {
+	// the order the services were passed to the gateway shouldn't matter
+	entries := []string{}
+	for _, service := range services {
+		entries = append(entries, service.URL+"\x00"+service.Hash)
+	}
+	sort.Strings(entries)
+
+	hash := sha256.New()
+	for _, entry := range entries {
+		hash.Write([]byte(entry + "\n"))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
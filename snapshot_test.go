@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_schemaSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	store := NewFileSnapshotStore(filepath.Join(dir, "schemas.json"))
+
+	// a service we can take down
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			value: String!
+		}
+	`)
+	service, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}})
+	if !assert.Nil(t, err) {
+		return
+	}
+	server := httptest.NewServer(http.HandlerFunc(service.GraphQLHandler))
+
+	// the first gateway introspects the service and saves the snapshot
+	gateway, err := New([]*graphql.RemoteSchema{{URL: server.URL}}, WithSchemaSnapshot(store))
+	if !assert.Nil(t, err) {
+		server.Close()
+		return
+	}
+	assert.Empty(t, gateway.StaleServices())
+	version := gateway.SchemaVersion()
+	assert.NotEmpty(t, version)
+
+	snapshot, err := store.Load()
+	if !assert.Nil(t, err) || !assert.NotNil(t, snapshot) {
+		server.Close()
+		return
+	}
+	assert.Equal(t, version, snapshot.Version)
+	assert.NotNil(t, snapshot.ForURL(server.URL))
+
+	// take the service down
+	server.Close()
+
+	// without a snapshot, the gateway can't start
+	_, err = New([]*graphql.RemoteSchema{{URL: server.URL}})
+	assert.NotNil(t, err)
+
+	// with one, the service is loaded from the snapshot
+	gateway, err = New([]*graphql.RemoteSchema{{URL: server.URL}}, WithSchemaSnapshot(store))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, []string{server.URL}, gateway.StaleServices())
+	assert.Equal(t, version, gateway.SchemaVersion())
+	assert.NotNil(t, gateway.schema.Query.Fields.ForName("value"))
+
+	// the health endpoint shows that we are running off of the snapshot
+	responseRecorder := httptest.NewRecorder()
+	gateway.HealthHandler(responseRecorder, httptest.NewRequest("GET", "/health", nil))
+
+	health := map[string]interface{}{}
+	if !assert.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &health)) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{
+		"status":              "degraded",
+		"schemaVersion":       version,
+		"staleServices":       []interface{}{server.URL},
+		"unavailableServices": []interface{}{},
+	}, health)
+
+	// a reload that is rejected leaves the gateway describing the schema it kept
+	gateway, err = New([]*graphql.RemoteSchema{{URL: server.URL}}, WithSchemaSnapshot(store), WithRejectBreakingChanges())
+	if !assert.Nil(t, err) {
+		return
+	}
+	otherSchema, _ := graphql.LoadSchema(`
+		type Query {
+			other: String!
+		}
+	`)
+	_, err = gateway.Reload([]*graphql.RemoteSchema{{Schema: otherSchema, URL: "url2"}})
+	_, rejected := err.(*BreakingChangesError)
+	assert.True(t, rejected)
+	assert.Equal(t, []string{server.URL}, gateway.StaleServices())
+}
+
+func TestServiceSnapshot_federated(t *testing.T) {
+	schema, err := LoadFederatedSchema(`
+		type Review {
+			body: String!
+		}
+
+		extend type User @key(fields: "id") {
+			id: ID! @external
+			reviews: [Review!]!
+		}
+	`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	snapshot, err := newServiceSnapshot(&graphql.RemoteSchema{URL: "reviews", Schema: schema})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, snapshot.Federated)
+
+	// loading the snapshot should give us back the same schema
+	loaded, err := snapshot.RemoteSchema()
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, keys, err := federatedSources([]*graphql.RemoteSchema{loaded})
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "id", keys.KeyFor("reviews", "User"))
+
+	reloaded, err := newServiceSnapshot(loaded)
+	if assert.Nil(t, err) {
+		assert.Equal(t, snapshot.Hash, reloaded.Hash)
+	}
+}
@@ -0,0 +1,233 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Some of what the gateway knows can go out of date (the schemas of the services, for one) but waiting for a
+// fresh copy every time it's old would put that wait in front of whichever request happened to notice. An
+// SWRValue serves what it has while it's fresh, keeps serving it for a while after that while a new copy is
+// loaded in the background, and only makes the callers wait once it's too old to use:
+//
+//	age < Fresh              the value is returned
+//	age < Fresh + Stale      the value is returned and a new one is loaded in the background
+//	otherwise                the callers wait for the new value
+//
+// Only one load happens at a time no matter how many callers notice the value is old. A load that fails
+// leaves the old value in place (and is passed to OnRefreshError) so a value can stay stale until it expires.
+// After a failure, the value isn't loaded again until RetryBackoff has passed, and the wait doubles with
+// every failure in a row (up to a minute) so a service that's down isn't asked on every call. The callers
+// of a value that expired in the meantime get the error of the last load.
+
+// swrDefaultRetryBackoff is how long a value waits to be loaded again after a failure, unless told otherwise
+const swrDefaultRetryBackoff = time.Second
+
+// swrMaxRetryBackoff is the longest a value waits to be loaded again after a failure
+const swrMaxRetryBackoff = time.Minute
+
+// SWRLoader loads a fresh copy of a value
+type SWRLoader func(ctx context.Context) (interface{}, error)
+
+// SWROptions controls how long a value is served before it has to be loaded again
+type SWROptions struct {
+	// how long a value is used without checking for a new one
+	Fresh time.Duration
+	// how long a value is used after it stops being fresh while a new one is loaded
+	Stale time.Duration
+	// called with the error of every load that fails
+	OnRefreshError func(err error)
+	// how long to wait before loading the value again after the first failure, one second if it's zero
+	RetryBackoff time.Duration
+}
+
+// SWRValue is a value that is loaded again in the background once it gets old
+type SWRValue struct {
+	load    SWRLoader
+	options SWROptions
+
+	mutex    sync.Mutex
+	value    interface{}
+	loaded   bool
+	loadedAt time.Time
+	// the load that's happening right now, if there is one
+	refresh *swrRefresh
+	// the loads that failed in a row, when the last one did and why
+	failures  int
+	failedAt  time.Time
+	lastError error
+
+	// the clock, so the tests don't have to wait
+	now func() time.Time
+}
+
+// swrRefresh is a single load of the value that every caller who needs it can wait on
+type swrRefresh struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// NewSWRValue returns a value that is loaded with the designated function the first time it's needed
+func NewSWRValue(load SWRLoader, options SWROptions) *SWRValue {
+	return &SWRValue{load: load, options: options, now: time.Now}
+}
+
+// Set replaces the value with one that was loaded somewhere else
+func (v *SWRValue) Set(value interface{}) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.value = value
+	v.loaded = true
+	v.loadedAt = v.now()
+	v.failures = 0
+}
+
+// Get returns the value, loading it first if it's too old to use
+func (v *SWRValue) Get(ctx context.Context) (interface{}, error) {
+	v.mutex.Lock()
+
+	age := v.now().Sub(v.loadedAt)
+	if v.loaded && age < v.options.Fresh {
+		value := v.value
+		v.mutex.Unlock()
+		return value, nil
+	}
+
+	// a value that isn't fresh gets loaded again, once, unless the last load failed too recently
+	var refresh *swrRefresh
+	if v.refresh != nil || !v.backingOff() {
+		refresh = v.startRefresh()
+	}
+
+	// but it can still be used for a while
+	if v.loaded && age < v.options.Fresh+v.options.Stale {
+		value := v.value
+		v.mutex.Unlock()
+		return value, nil
+	}
+	if refresh == nil {
+		err := v.lastError
+		v.mutex.Unlock()
+		return nil, err
+	}
+	v.mutex.Unlock()
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case <-refresh.done:
+		return refresh.value, refresh.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// startRefresh returns the load that's happening, starting one if there isn't. The mutex has to be held.
+func (v *SWRValue) startRefresh() *swrRefresh {
+	if v.refresh != nil {
+		return v.refresh
+	}
+
+	refresh := &swrRefresh{done: make(chan struct{})}
+	v.refresh = refresh
+
+	go func() {
+		// the load isn't tied to whichever request started it
+		refresh.value, refresh.err = v.safeLoad()
+
+		v.mutex.Lock()
+		if refresh.err == nil {
+			v.value = refresh.value
+			v.loaded = true
+			v.loadedAt = v.now()
+			v.failures = 0
+		} else {
+			v.failures++
+			v.failedAt = v.now()
+			v.lastError = refresh.err
+		}
+		v.refresh = nil
+		v.mutex.Unlock()
+
+		if refresh.err != nil && v.options.OnRefreshError != nil {
+			v.options.OnRefreshError(refresh.err)
+		}
+		close(refresh.done)
+	}()
+
+	return refresh
+}
+
+// backingOff returns true if the last load failed too recently to try again. The mutex has to be held.
+func (v *SWRValue) backingOff() bool {
+	if v.failures == 0 {
+		return false
+	}
+
+	backoff := v.options.RetryBackoff
+	if backoff <= 0 {
+		backoff = swrDefaultRetryBackoff
+	}
+	for i := 1; i < v.failures && backoff < swrMaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > swrMaxRetryBackoff {
+		backoff = swrMaxRetryBackoff
+	}
+
+	return v.now().Sub(v.failedAt) < backoff
+}
+
+// safeLoad loads the value, turning a panic into an error
+func (v *SWRValue) safeLoad() (value interface{}, err error) {
+	defer recoverError(&err, "loading a value")
+
+	return v.load(context.Background())
+}
+
+// WithSchemaRevalidation returns an Option that introspects the services again once the schema is older than
+// options.Fresh. The requests that come in while the services are introspected use the schema the gateway
+// already has, unless it's older than options.Fresh + options.Stale in which case they wait for the new one
+// (and fail if the services can't be introspected). The schema is reloaded like it would be with Reload so
+// breaking changes can still be rejected, in which case the schema the gateway has counts as revalidated.
+// Only the services that were given to New by url are introspected again.
+func WithSchemaRevalidation(options SWROptions) Option {
+	return func(g *Gateway) {
+		g.schemaRevalidation = NewSWRValue(g.revalidateSchema, options)
+	}
+}
+
+// revalidateSchema introspects the services again and applies the schema they have now
+func (g *Gateway) revalidateSchema(ctx context.Context) (interface{}, error) {
+	// the sources we were handed a schema for (or a way to get one) are kept as they are, the rest
+	// are introspected again by the reload
+	g.schemaMutex.RLock()
+	sources := g.sources
+	g.schemaMutex.RUnlock()
+
+	if _, err := g.Reload(sources); err != nil {
+		// the schema we have was checked against the services, it just stays the one we use
+		if _, rejected := err.(*BreakingChangesError); rejected {
+			return g.SchemaVersion(), nil
+		}
+
+		log.Warn("Could not revalidate the schema: ", err)
+		return nil, err
+	}
+
+	return g.SchemaVersion(), nil
+}
+
+// checkSchemaRevalidation makes sure the schema isn't too old to plan the request with
+func (g *Gateway) checkSchemaRevalidation(ctx context.Context) error {
+	if g.schemaRevalidation == nil {
+		return nil
+	}
+
+	_, err := g.schemaRevalidation.Get(ctx)
+	return err
+}
@@ -0,0 +1,321 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+// swrTestValue returns a value whose loads wait for release and a way to move its clock forward
+func swrTestValue(options SWROptions, release chan bool, loads *int32, err error) (*SWRValue, func(time.Duration)) {
+	now := time.Now()
+	clockMutex := &sync.Mutex{}
+
+	value := NewSWRValue(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(loads, 1)
+		<-release
+		if err != nil {
+			return nil, err
+		}
+		return "new", nil
+	}, options)
+	value.now = func() time.Time {
+		clockMutex.Lock()
+		defer clockMutex.Unlock()
+		return now
+	}
+	value.Set("old")
+
+	return value, func(d time.Duration) {
+		clockMutex.Lock()
+		defer clockMutex.Unlock()
+		now = now.Add(d)
+	}
+}
+
+// swrGetAll calls Get from many goroutines at once and returns what they got
+func swrGetAll(value *SWRValue) ([]interface{}, []error) {
+	values := make([]interface{}, 50)
+	errs := make([]error, 50)
+
+	wg := &sync.WaitGroup{}
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = value.Get(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	return values, errs
+}
+
+func TestSWRValue_singleFlight(t *testing.T) {
+	release := make(chan bool)
+	var loads int32
+	value, advance := swrTestValue(SWROptions{Fresh: time.Minute, Stale: time.Hour}, release, &loads, nil)
+
+	// a fresh value isn't loaded again
+	values, _ := swrGetAll(value)
+	assert.Equal(t, "old", values[0])
+	assert.Equal(t, int32(0), atomic.LoadInt32(&loads))
+
+	// a stale one is still served right away, while it's loaded once in the background
+	advance(2 * time.Minute)
+	values, errs := swrGetAll(value)
+	for i := range values {
+		assert.Nil(t, errs[i])
+		assert.Equal(t, "old", values[i])
+	}
+	for i := 0; i < 100 && atomic.LoadInt32(&loads) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	swrGetAll(value)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	// once the load is done, the new value is the one that's served
+	close(release)
+	for i := 0; i < 100; i++ {
+		if current, _ := value.Get(context.Background()); current == "new" {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	current, _ := value.Get(context.Background())
+	assert.Equal(t, "new", current)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+}
+
+func TestSWRValue_expired(t *testing.T) {
+	release := make(chan bool)
+	var loads int32
+	value, advance := swrTestValue(SWROptions{Fresh: time.Minute, Stale: time.Hour}, release, &loads, nil)
+
+	// a value that's too old makes everyone wait for the same load
+	advance(2 * time.Hour)
+	go func() {
+		for atomic.LoadInt32(&loads) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		// give the other callers time to find the load that's already happening
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	values, errs := swrGetAll(value)
+	for i := range values {
+		assert.Nil(t, errs[i])
+		assert.Equal(t, "new", values[i])
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+}
+
+func TestSWRValue_refreshErrors(t *testing.T) {
+	release := make(chan bool)
+	close(release)
+	var loads int32
+	var failures int32
+	value, advance := swrTestValue(SWROptions{
+		Fresh: time.Minute,
+		Stale: time.Hour,
+		OnRefreshError: func(err error) {
+			atomic.AddInt32(&failures, 1)
+		},
+	}, release, &loads, errors.New("service unavailable"))
+
+	// a load that fails in the background leaves the stale value in place
+	advance(2 * time.Minute)
+	current, err := value.Get(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "old", current)
+	for i := 0; i < 100 && atomic.LoadInt32(&failures) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&failures))
+
+	// but the callers find out once the value has expired
+	advance(2 * time.Hour)
+	_, err = value.Get(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestSWRValue_refreshBackoff(t *testing.T) {
+	release := make(chan bool)
+	close(release)
+	var loads int32
+	var failures int32
+	value, advance := swrTestValue(SWROptions{
+		Fresh:        time.Minute,
+		Stale:        time.Hour,
+		RetryBackoff: time.Second,
+		OnRefreshError: func(err error) {
+			atomic.AddInt32(&failures, 1)
+		},
+	}, release, &loads, errors.New("service unavailable"))
+	waitForFailures := func(count int32) {
+		for i := 0; i < 100 && atomic.LoadInt32(&failures) < count; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	advance(2 * time.Minute)
+	value.Get(context.Background())
+	waitForFailures(1)
+
+	// the stale value is served without asking again right after a failure
+	values, errs := swrGetAll(value)
+	for i := range values {
+		assert.Nil(t, errs[i])
+		assert.Equal(t, "old", values[i])
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	// until the backoff has passed
+	advance(2 * time.Second)
+	value.Get(context.Background())
+	waitForFailures(2)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+
+	// which doubles with every failure
+	advance(1500 * time.Millisecond)
+	value.Get(context.Background())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+
+	// the callers of a value that expired in the meantime get the last error
+	advance(time.Hour)
+	_, err := value.Get(context.Background())
+	assert.NotNil(t, err)
+	waitForFailures(3)
+	_, err = value.Get(context.Background())
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&loads))
+}
+
+func TestGateway_schemaRevalidation(t *testing.T) {
+	// the schema the service has right now, and whether it can be introspected
+	var introspection *Gateway
+	var failing bool
+	serviceMutex := &sync.Mutex{}
+	setSchema := func(typeDefs string, fail bool) {
+		schema, _ := graphql.LoadSchema(typeDefs)
+		gw, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "service"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		serviceMutex.Lock()
+		defer serviceMutex.Unlock()
+		introspection = gw
+		failing = fail
+	}
+	setSchema(`
+		type Query {
+			hello: String!
+		}
+	`, false)
+
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(body), "__schema") {
+			w.Write([]byte(`{"data": {"hello": "world", "goodbye": "moon"}}`))
+			return
+		}
+
+		serviceMutex.Lock()
+		gw, fail := introspection, failing
+		serviceMutex.Unlock()
+
+		// a service that's struggling takes its time to fail
+		if fail {
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		gw.GraphQLHandler(w, r)
+	}))
+	defer service.Close()
+
+	// a service whose schema we were given isn't introspected again
+	staticSchema, _ := graphql.LoadSchema(`
+		type Query {
+			static: String!
+		}
+	`)
+
+	var failures int32
+	gw, err := New([]*graphql.RemoteSchema{{URL: service.URL}, {Schema: staticSchema, URL: "static"}}, WithSchemaRevalidation(SWROptions{
+		// every request checks for a new schema
+		Fresh:        0,
+		Stale:        time.Hour,
+		RetryBackoff: 10 * time.Millisecond,
+		OnRefreshError: func(err error) {
+			atomic.AddInt32(&failures, 1)
+		},
+	}))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	plan := func(query string) error {
+		_, err := gw.GetPlans(&RequestContext{Context: context.Background(), Query: query})
+		return err
+	}
+
+	// the requests don't wait for a revalidation that fails
+	setSchema(`
+		type Query {
+			hello: String!
+		}
+	`, true)
+	start := time.Now()
+	assert.Nil(t, plan(`{ hello }`))
+	assert.True(t, time.Since(start) < 50*time.Millisecond, time.Since(start).String())
+	for i := 0; i < 100 && atomic.LoadInt32(&failures) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NotZero(t, atomic.LoadInt32(&failures))
+
+	// and pick up the new schema once the service can be introspected again
+	setSchema(`
+		type Query {
+			hello: String!
+			goodbye: String!
+		}
+	`, false)
+	err = plan(`{ goodbye }`)
+	for i := 0; i < 100 && err != nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		err = plan(`{ goodbye }`)
+	}
+	assert.Nil(t, err)
+	assert.Nil(t, plan(`{ static }`))
+
+	// a schema that's rejected leaves the gateway with the one it has, without failing the requests
+	rejecting, err := New([]*graphql.RemoteSchema{{URL: service.URL}}, WithRejectBreakingChanges(), WithSchemaRevalidation(SWROptions{
+		// every request waits for a new schema
+		Fresh: 0,
+		Stale: 0,
+	}))
+	if !assert.Nil(t, err) {
+		return
+	}
+	setSchema(`
+		type Query {
+			goodbye: String!
+		}
+	`, false)
+	_, err = rejecting.GetPlans(&RequestContext{Context: context.Background(), Query: `{ hello }`})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// the steps of creating a gateway that a service can fail
+const (
+	ServiceErrorIntrospection = "introspection"
+	ServiceErrorMerge         = "merge"
+)
+
+// ServiceError is something that went wrong with a single service while the gateway was being created
+type ServiceError struct {
+	URL string
+	// the step that failed, ie ServiceErrorIntrospection or ServiceErrorMerge
+	Stage string
+	Err   error
+}
+
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("%s failed for %s: %s", e.Stage, e.URL, e.Err.Error())
+}
+
+// Unwrap returns the underlying error
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError holds every problem with the services that was found while creating the gateway so that
+// they can be fixed at once
+type MultiError []*ServiceError
+
+func (e MultiError) Error() string {
+	messages := []string{}
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// WithPartialBoot returns an Option that lets the gateway start when some of the services can't be
+// introspected. Their fields are left out of the schema and the services are reported as unavailable
+// by the HealthHandler. The gateway still fails to start if none of the services can be introspected.
+func WithPartialBoot(allowed bool) Option {
+	return func(g *Gateway) {
+		g.partialBoot = allowed
+	}
+}
+
+// UnavailableServices returns the url of every service that was left out of the gateway because it
+// could not be introspected
+func (g *Gateway) UnavailableServices() []string {
+	g.schemaMutex.RLock()
+	defer g.schemaMutex.RUnlock()
+
+	return append([]string(nil), g.unavailableServices...)
+}
+
+// serviceMergeErrors finds the sources that can't be merged with the ones that come before them. If the
+// conflict can't be pinned on a source, the error of the merge is returned as it is.
+func serviceMergeErrors(merger Merger, sources []*graphql.RemoteSchema, mergeErr error) error {
+	errs := MultiError{}
+	merged := []*ast.Schema{}
+
+	for _, source := range sources {
+		candidate := append(append([]*ast.Schema{}, merged...), source.Schema)
+		if _, err := merger.Merge(candidate); err != nil {
+			errs = append(errs, &ServiceError{URL: source.URL, Stage: ServiceErrorMerge, Err: err})
+			continue
+		}
+
+		merged = candidate
+	}
+
+	if len(errs) == 0 {
+		return mergeErr
+	}
+
+	return errs
+}
@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_serviceErrors(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			users: [String!]!
+		}
+	`)
+	introspection, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := httptest.NewServer(http.HandlerFunc(introspection.GraphQLHandler))
+	defer users.Close()
+
+	// one service is down and the other doesn't send back json
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not json"))
+	}))
+	defer broken.Close()
+
+	sources := []*graphql.RemoteSchema{{URL: down.URL}, {URL: users.URL}, {URL: broken.URL}}
+
+	// every service that we couldn't introspect is reported
+	_, err = New(sources)
+	errs, ok := err.(MultiError)
+	if !assert.True(t, ok, "error was not a MultiError: %v", err) || !assert.Len(t, errs, 2) {
+		return
+	}
+	assert.Equal(t, down.URL, errs[0].URL)
+	assert.Equal(t, broken.URL, errs[1].URL)
+	for _, serviceErr := range errs {
+		assert.Equal(t, ServiceErrorIntrospection, serviceErr.Stage)
+		assert.NotNil(t, serviceErr.Err)
+	}
+
+	// unless we can start without them
+	gateway, err := New(sources, WithPartialBoot(true))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NotNil(t, gateway.schema.Query.Fields.ForName("users"))
+
+	unavailable := gateway.UnavailableServices()
+	sort.Strings(unavailable)
+	expected := []string{down.URL, broken.URL}
+	sort.Strings(expected)
+	assert.Equal(t, expected, unavailable)
+
+	// which shows up in the health check
+	response := httptest.NewRecorder()
+	gateway.HealthHandler(response, httptest.NewRequest("GET", "/health", nil))
+	health := map[string]interface{}{}
+	if assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &health)) {
+		assert.Equal(t, "degraded", health["status"])
+		assert.Len(t, health["unavailableServices"], 2)
+	}
+}
+
+func TestNew_mergeErrors(t *testing.T) {
+	users, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+		}
+
+		type Query {
+			users: [User!]!
+		}
+	`)
+	photos, _ := graphql.LoadSchema(`
+		type Query {
+			photos: [String!]!
+		}
+	`)
+	legacy, _ := graphql.LoadSchema(`
+		type User {
+			id: Int!
+		}
+
+		type Query {
+			legacyUsers: [User!]!
+		}
+	`)
+
+	_, err := New([]*graphql.RemoteSchema{
+		{URL: "users", Schema: users},
+		{URL: "photos", Schema: photos},
+		{URL: "legacy", Schema: legacy},
+	})
+	errs, ok := err.(MultiError)
+	if !assert.True(t, ok, "error was not a MultiError: %v", err) || !assert.Len(t, errs, 1) {
+		return
+	}
+
+	// the service that conflicts with the others is the one that's blamed, along with the type
+	assert.Equal(t, "legacy", errs[0].URL)
+	assert.Equal(t, ServiceErrorMerge, errs[0].Stage)
+	assert.Contains(t, errs[0].Error(), "User")
+}
@@ -0,0 +1,229 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Every request keeps a few counters about the work it took to answer it: how many steps the plan had, how
+// many requests were sent to each service and how big they were, and whether any of the caches saved some of
+// the work. They are left in the Stats of the RequestContext once the request has been executed. Clients that
+// send the stats header also get them under extensions.gatewayStats. The counters are bumped by the steps while
+// they run so they are kept with atomic adds and copied into the Stats once the plan has been executed.
+
+// StatsHeader is the header that adds the stats of the request to the extensions of its response when it is set to 1
+const StatsHeader = "X-Gateway-Stats"
+
+// the key of the stats in the extensions of the response
+const statsExtension = "gatewayStats"
+
+// Stats describes the work that went into the response to an operation
+type Stats struct {
+	// the number of steps in the plan
+	PlanSteps int `json:"planSteps"`
+	// the number of requests sent to the services
+	Requests int64 `json:"requests"`
+	// the size of the bodies of the requests sent to the services and of their responses. Only the services
+	// that the gateway talks to over HTTP are counted.
+	BytesSent     int64 `json:"bytesSent"`
+	BytesReceived int64 `json:"bytesReceived"`
+	// the counts for each service, indexed by url
+	Services map[string]*ServiceStats `json:"services"`
+	// how long the request took to execute
+	Duration time.Duration `json:"duration"`
+	// true if the plan came out of the query plan cache
+	PlanCacheHit bool `json:"planCacheHit"`
+	// true if the response came out of the response cache
+	ResponseCacheHit bool `json:"responseCacheHit"`
+	// true if the response was shared with an identical request that was executed at the same time
+	Collapsed bool `json:"collapsed"`
+	// the number of objects that were already looked up by another invocation of the same step
+	MemoHits int64 `json:"memoHits"`
+	// the name of the executor that executed the plan
+	Executor string `json:"executor"`
+}
+
+// ServiceStats holds the counts for a single service
+type ServiceStats struct {
+	Requests      int64 `json:"requests"`
+	BytesSent     int64 `json:"bytesSent"`
+	BytesReceived int64 `json:"bytesReceived"`
+}
+
+// statsRequestedKey is the context key that marks a request that wants its stats in the extensions
+type statsRequestedKey struct{}
+
+// statsContext returns the context to execute the request with, marked if the client asked for the stats
+func statsContext(ctx context.Context, r *http.Request) context.Context {
+	if r.Header.Get(StatsHeader) != "1" {
+		return ctx
+	}
+	return context.WithValue(ctx, statsRequestedKey{}, true)
+}
+
+// statsRequested returns true if the client asked for the stats of the request
+func statsRequested(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	requested, _ := ctx.Value(statsRequestedKey{}).(bool)
+	return requested
+}
+
+// statsRecorder holds the counters of a request while it is executed. The services are known before the
+// plan is executed so the map is never written to by the steps.
+type statsRecorder struct {
+	start    time.Time
+	steps    int
+	memoHits int64
+	services map[string]*ServiceStats
+	// how long the requests of each step took, if anyone asked
+	latencies map[*QueryPlanStep]*stepLatency
+}
+
+// stepLatency holds the durations of the requests of a single step
+type stepLatency struct {
+	mutex   sync.Mutex
+	latency UsageLatency
+}
+
+// newStatsRecorder returns the recorder for the execution of the plan
+func newStatsRecorder(plan *QueryPlan) *statsRecorder {
+	recorder := &statsRecorder{start: time.Now(), services: map[string]*ServiceStats{}}
+	if plan == nil || plan.RootStep == nil {
+		return recorder
+	}
+
+	var visit func(steps []*QueryPlanStep)
+	visit = func(steps []*QueryPlanStep) {
+		for _, step := range steps {
+			recorder.steps++
+			if _, ok := recorder.services[step.Location]; !ok {
+				recorder.services[step.Location] = &ServiceStats{}
+			}
+			visit(step.Then)
+		}
+	}
+	visit(plan.RootStep.Then)
+
+	return recorder
+}
+
+// trackLatencies makes the recorder time the requests of the steps in the plan. It has to be called before
+// the plan is executed.
+func (s *statsRecorder) trackLatencies(plan *QueryPlan) {
+	s.latencies = map[*QueryPlanStep]*stepLatency{}
+	if plan == nil || plan.RootStep == nil {
+		return
+	}
+
+	var visit func(steps []*QueryPlanStep)
+	visit = func(steps []*QueryPlanStep) {
+		for _, step := range steps {
+			s.latencies[step] = &stepLatency{}
+			visit(step.Then)
+		}
+	}
+	visit(plan.RootStep.Then)
+}
+
+// stepDuration records how long a request of the step took
+func (s *statsRecorder) stepDuration(step *QueryPlanStep, duration time.Duration) {
+	if s == nil || s.latencies[step] == nil {
+		return
+	}
+
+	latency := s.latencies[step]
+	latency.mutex.Lock()
+	latency.latency.add(duration)
+	latency.mutex.Unlock()
+}
+
+// stepLatencies returns the durations of the steps that sent at least one request
+func (s *statsRecorder) stepLatencies() map[*QueryPlanStep]*UsageLatency {
+	latencies := map[*QueryPlanStep]*UsageLatency{}
+	if s == nil {
+		return latencies
+	}
+
+	for step, latency := range s.latencies {
+		latency.mutex.Lock()
+		if latency.latency.Count > 0 {
+			copied := latency.latency
+			latencies[step] = &copied
+		}
+		latency.mutex.Unlock()
+	}
+
+	return latencies
+}
+
+// memoHit counts an object that didn't have to be looked up again
+func (s *statsRecorder) memoHit() {
+	if s != nil {
+		atomic.AddInt64(&s.memoHits, 1)
+	}
+}
+
+// stats returns the counts so far
+func (s *statsRecorder) stats(planCacheHit bool) *Stats {
+	stats := &Stats{
+		PlanSteps:    s.steps,
+		Services:     map[string]*ServiceStats{},
+		Duration:     time.Since(s.start),
+		PlanCacheHit: planCacheHit,
+		MemoHits:     atomic.LoadInt64(&s.memoHits),
+	}
+
+	for location, counts := range s.services {
+		service := &ServiceStats{
+			Requests:      atomic.LoadInt64(&counts.Requests),
+			BytesSent:     atomic.LoadInt64(&counts.BytesSent),
+			BytesReceived: atomic.LoadInt64(&counts.BytesReceived),
+		}
+		stats.Services[location] = service
+
+		stats.Requests += service.Requests
+		stats.BytesSent += service.BytesSent
+		stats.BytesReceived += service.BytesReceived
+	}
+
+	return stats
+}
+
+// serviceStatsKey is the context key for the counts of the service that a request is sent to
+type serviceStatsKey struct{}
+
+// context returns a copy of the context that counts the requests sent with it against the service
+func (s *statsRecorder) context(ctx context.Context, location string) context.Context {
+	if s == nil || s.services[location] == nil {
+		return ctx
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, serviceStatsKey{}, s.services[location])
+}
+
+// serviceStats returns the counts of the service that a request with the context is sent to, if there are any
+func serviceStats(ctx context.Context) *ServiceStats {
+	if ctx == nil {
+		return nil
+	}
+	stats, _ := ctx.Value(serviceStatsKey{}).(*ServiceStats)
+	return stats
+}
+
+// countingPlanner is a QueryPlanner that remembers if it was asked for a plan
+type countingPlanner struct {
+	QueryPlanner
+	planned bool
+}
+
+func (p *countingPlanner) Plan(ctx *PlanningContext) (QueryPlanList, error) {
+	p.planned = true
+	return p.QueryPlanner.Plan(ctx)
+}
@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_stats(t *testing.T) {
+	// the user is in one service, their orders in another, and the products of the orders in a third
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+		}
+
+		type Query {
+			me: User
+			node(id: ID!): Node
+		}
+	`)
+	ordersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Order implements Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			orders: [Order!]!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	catalogSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Order implements Node {
+			id: ID!
+			product: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	// service returns a server that responds with the designated body and counts the bytes it sends
+	var received int64
+	service := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ioutil.ReadAll(r.Body)
+			atomic.AddInt64(&received, int64(len(body)))
+			w.Write([]byte(body))
+		}))
+	}
+
+	users := service(`{"data": {"me": {"` + gatewayIDAlias + `": "1"}}}`)
+	defer users.Close()
+	// both orders are the same one
+	orders := service(`{"data": {"` + gatewayNodeAlias + `": {"orders": [{"` + gatewayIDAlias + `": "2"}, {"` + gatewayIDAlias + `": "2"}]}}}`)
+	defer orders.Close()
+	catalog := service(`{"data": {"` + gatewayNodeAlias + `": {"product": "hat"}}}`)
+	defer catalog.Close()
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: users.URL, Schema: usersSchema},
+		{URL: orders.URL, Schema: ordersSchema},
+		{URL: catalog.URL, Schema: catalogSchema},
+	}, WithAutomaticQueryPlanCache())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	query := `{ me { orders { product } } }`
+	requestContext := &RequestContext{Context: context.Background(), Query: query}
+	plans, err := gateway.GetPlans(requestContext)
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = gateway.Execute(requestContext, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	stats := requestContext.Stats
+	if !assert.NotNil(t, stats) {
+		return
+	}
+	assert.Equal(t, 3, stats.PlanSteps)
+	// the second order didn't need its own request
+	assert.Equal(t, int64(3), stats.Requests)
+	assert.Equal(t, int64(1), stats.MemoHits)
+	for _, url := range []string{users.URL, orders.URL, catalog.URL} {
+		if assert.NotNil(t, stats.Services[url], url) {
+			assert.Equal(t, int64(1), stats.Services[url].Requests, url)
+			assert.True(t, stats.Services[url].BytesSent > 0, url)
+		}
+	}
+	assert.Equal(t, atomic.LoadInt64(&received), stats.BytesReceived)
+	assert.True(t, stats.BytesSent > 0)
+	assert.False(t, stats.PlanCacheHit)
+	assert.False(t, stats.ResponseCacheHit)
+
+	// the second time around, the plan comes out of the cache
+	cachedContext := &RequestContext{Context: context.Background(), Query: query, CacheKey: requestContext.CacheKey}
+	plans, err = gateway.GetPlans(cachedContext)
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = gateway.Execute(cachedContext, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, cachedContext.Stats.PlanCacheHit)
+
+	// the stats are only in the response when they are asked for
+	for _, requested := range []bool{false, true} {
+		request := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "`+query+`"}`))
+		if requested {
+			request.Header.Set(StatsHeader, "1")
+		}
+		response := httptest.NewRecorder()
+		gateway.GraphQLHandler(response, request)
+
+		result := struct {
+			Extensions map[string]*Stats `json:"extensions"`
+		}{}
+		if !assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &result)) {
+			return
+		}
+
+		if !requested {
+			assert.Nil(t, result.Extensions[statsExtension])
+			continue
+		}
+		if assert.NotNil(t, result.Extensions[statsExtension]) {
+			assert.Equal(t, int64(3), result.Extensions[statsExtension].Requests)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"sort"
+	"strings"
+)
+
+// A fragment that crosses into another service creates a step everywhere it is spread. When it is spread under
+// two fields that return the same type ({ assignee { ...UserBadge } reporter { ...UserBadge } }), the planner
+// ends up with two steps that send the exact same query from two different places. Those steps are merged into
+// one that is inserted in both places so the executor sees all of their objects together: the batch and the memo
+// only have to look up an object once, no matter which of the fields it came from.
+//
+// Only the steps that don't have any steps of their own are merged. The insertion points of the steps that
+// depend on a step are relative to the one it was planned for so they couldn't be shared.
+
+// insertionPoints returns every place the step is inserted, as it was planned
+func (s *QueryPlanStep) insertionPoints() [][]string {
+	if len(s.InsertionPoints) > 0 {
+		return s.InsertionPoints
+	}
+
+	return [][]string{s.InsertionPoint}
+}
+
+// plannerMergeIdenticalSteps merges the dependents of each step in the plan that send the same query to the
+// same service
+func plannerMergeIdenticalSteps(step *QueryPlanStep) {
+	if step == nil {
+		return
+	}
+
+	merged := []*QueryPlanStep{}
+	identical := map[string]*QueryPlanStep{}
+	for _, child := range step.Then {
+		plannerMergeIdenticalSteps(child)
+
+		// the steps that only get sent once can't be merged
+		if len(child.Then) > 0 || child.atOperationRoot() || child.QueryString == "" {
+			merged = append(merged, child)
+			continue
+		}
+
+		key := strings.Join([]string{child.Location, child.ParentType, child.EntityKey, child.QueryString}, "\x00")
+		existing, ok := identical[key]
+		if !ok {
+			identical[key] = child
+			merged = append(merged, child)
+			continue
+		}
+
+		existing.InsertionPoints = append(existing.insertionPoints(), child.insertionPoints()...)
+		if child.ExpansionRisk > existing.ExpansionRisk {
+			existing.ExpansionRisk = child.ExpansionRisk
+		}
+	}
+
+	// the steps are planned concurrently so the order of the insertion points has to be fixed
+	for _, child := range merged {
+		if len(child.InsertionPoints) > 1 {
+			sort.Slice(child.InsertionPoints, func(i, j int) bool {
+				return strings.Join(child.InsertionPoints[i], ".") < strings.Join(child.InsertionPoints[j], ".")
+			})
+			child.InsertionPoint = child.InsertionPoints[0]
+		}
+	}
+
+	step.Then = merged
+}
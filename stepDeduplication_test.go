@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_mergeIdenticalSteps(t *testing.T) {
+	issuesSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Issue {
+			assignee: User!
+			reporter: User!
+		}
+
+		type Query {
+			issue: Issue!
+			node(id: ID!): Node
+		}
+	`)
+	avatarsSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			avatar: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	// the ids of the users the avatar service was asked for
+	var mutex sync.Mutex
+	lookups := []string{}
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			if url == "issues" {
+				return map[string]interface{}{
+					"issue": map[string]interface{}{
+						"assignee": map[string]interface{}{gatewayIDAlias: "1", "name": "alice"},
+						"reporter": map[string]interface{}{gatewayIDAlias: "2", "name": "bob"},
+					},
+				}, nil
+			}
+
+			id := input.Variables[gatewayIDAlias].(string)
+			mutex.Lock()
+			lookups = append(lookups, id)
+			mutex.Unlock()
+
+			return map[string]interface{}{
+				"node": map[string]interface{}{"avatar": "avatar-" + id},
+			}, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: issuesSchema, URL: "issues"},
+		{Schema: avatarsSchema, URL: "avatars"},
+	}, WithQueryerFactory(&factory))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	reqCtx := &RequestContext{
+		Context: context.Background(),
+		Query: `
+			query {
+				issue {
+					assignee { ...UserBadge }
+					reporter { ...UserBadge }
+				}
+			}
+
+			fragment UserBadge on User {
+				name
+				avatar
+			}
+		`,
+	}
+	plans, err := gateway.GetPlans(reqCtx)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the avatars are looked up by one step that is inserted under both fields
+	root := plans[0].RootStep.Then[0]
+	if !assert.Len(t, root.Then, 1) {
+		return
+	}
+	assert.Equal(t, [][]string{{"issue", "assignee"}, {"issue", "reporter"}}, root.Then[0].InsertionPoints)
+
+	result, err := gateway.Execute(reqCtx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"issue": map[string]interface{}{
+			"assignee": map[string]interface{}{"name": "alice", "avatar": "avatar-1"},
+			"reporter": map[string]interface{}{"name": "bob", "avatar": "avatar-2"},
+		},
+	}, result)
+	assert.ElementsMatch(t, []string{"1", "2"}, lookups)
+}
@@ -0,0 +1,66 @@
+package gateway
+
+import "strings"
+
+// A step that depends on a field inside of a list is executed once for every entry. When it sits under a few
+// nested lists, the number of requests sent to the service is the product of their lengths which is easy to miss
+// until a large response comes along. The planner records how many lists a step is under (its ExpansionRisk)
+// and the gateway can report the steps that were executed more than expected so they can be pointed towards
+// a field that takes a batch of objects. WithMaxStepExecutions puts a hard limit on them.
+
+// StepExpansion describes a step of a plan that was executed more often than the threshold for a single request
+type StepExpansion struct {
+	// the name of the client's operation
+	OperationName string
+	// the path to the field where the step's results are inserted
+	Path []string
+	// the service that the step was sent to
+	Location string
+	// the number of lists between the root of the operation and the step
+	ExpansionRisk int
+	// the number of times the step was executed
+	Executions int
+}
+
+// WithStepExpansionWarning returns an Option that warns about the steps that are executed more than threshold
+// times for a single request. The warnings are logged and passed to the handler, if there is one, so they can
+// be recorded as metrics.
+func WithStepExpansionWarning(threshold int, handler func(*StepExpansion)) Option {
+	return func(g *Gateway) {
+		g.stepExpansionThreshold = threshold
+		g.stepExpansionHandler = handler
+	}
+}
+
+// reportStepExpansions warns about the steps that were executed more often than we were told to expect
+func (g *Gateway) reportStepExpansions(ctx *ExecutionContext) {
+	if g.stepExpansionThreshold <= 0 {
+		return
+	}
+
+	for step, executions := range ctx.StepExecutions {
+		if executions <= g.stepExpansionThreshold {
+			continue
+		}
+
+		expansion := &StepExpansion{
+			OperationName: ctx.OperationName,
+			Path:          step.InsertionPoint,
+			Location:      step.Location,
+			ExpansionRisk: step.ExpansionRisk,
+			Executions:    executions,
+		}
+
+		log.WithFields(LoggerFields{
+			"operation":      expansion.OperationName,
+			"path":           strings.Join(expansion.Path, "."),
+			"location":       expansion.Location,
+			"expansion risk": expansion.ExpansionRisk,
+			"executions":     expansion.Executions,
+		}).Warn("A step of the query plan was executed more often than expected. Consider a field that accepts a batch of objects.")
+
+		if g.stepExpansionHandler != nil {
+			g.stepExpansionHandler(expansion)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_stepExpansionWarning(t *testing.T) {
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			users: [User!]!
+		}
+	`)
+	friendsSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			friends: [User!]!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			// every user has two friends
+			if url == "friends" {
+				return map[string]interface{}{
+					gatewayNodeAlias: map[string]interface{}{
+						"friends": []interface{}{
+							map[string]interface{}{gatewayIDAlias: "2"},
+							map[string]interface{}{gatewayIDAlias: "3"},
+						},
+					},
+				}, nil
+			}
+			if _, ok := input.Variables[gatewayIDAlias]; ok {
+				return map[string]interface{}{gatewayNodeAlias: map[string]interface{}{"name": "Bob"}}, nil
+			}
+
+			// and there are three users
+			user := map[string]interface{}{gatewayIDAlias: "1"}
+			return map[string]interface{}{"users": []interface{}{user, user, user}}, nil
+		})
+	})
+
+	// the steps that were executed too often
+	expansions := []*StepExpansion{}
+	lock := &sync.Mutex{}
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: usersSchema, URL: "users"},
+		{Schema: friendsSchema, URL: "friends"},
+	},
+		WithQueryerFactory(&factory),
+		WithStepExpansionWarning(4, func(expansion *StepExpansion) {
+			lock.Lock()
+			defer lock.Unlock()
+			expansions = append(expansions, expansion)
+		}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	plans, err := gateway.GetPlans(&RequestContext{
+		Context: context.Background(),
+		Query:   `query Friends { users { friends { name } } }`,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the planner knows how many lists are above each step
+	usersStep := plans[0].RootStep.Then[0]
+	if !assert.Len(t, usersStep.Then, 1) || !assert.Len(t, usersStep.Then[0].Then, 1) {
+		return
+	}
+	friendsStep := usersStep.Then[0]
+	namesStep := friendsStep.Then[0]
+	assert.Equal(t, 0, usersStep.ExpansionRisk)
+	assert.Equal(t, 1, friendsStep.ExpansionRisk)
+	assert.Equal(t, 2, namesStep.ExpansionRisk)
+
+	_, err = gateway.Execute(&RequestContext{Context: context.Background()}, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the names were looked up once for each friend of each user, which is more than we wanted
+	if !assert.Len(t, expansions, 1) {
+		return
+	}
+	assert.Equal(t, &StepExpansion{
+		OperationName: "Friends",
+		Path:          []string{"users", "friends"},
+		Location:      "users",
+		ExpansionRisk: 2,
+		Executions:    6,
+	}, expansions[0])
+}
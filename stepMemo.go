@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// stepMemo holds onto the objects looked up while executing a single plan so that a step which has to
+// be inserted into the same object more than once (ie, a list that refers to the same entity many times)
+// only sends one query to the service. It is safe to use from the goroutines of sibling steps.
+type stepMemo struct {
+	mutex   sync.Mutex
+	entries map[string]*stepMemoEntry
+}
+
+// stepMemoEntry is the result of a single lookup. done is closed once the result is available.
+type stepMemoEntry struct {
+	done   chan struct{}
+	result map[string]interface{}
+	err    error
+}
+
+func newStepMemo() *stepMemo {
+	return &stepMemo{entries: map[string]*stepMemoEntry{}}
+}
+
+// do returns the result of fetch for the key, calling it only for the first caller with that key. Everyone
+// else waits for that call to finish and gets their own copy of its result to insert into the response.
+func (m *stepMemo) do(key string, fetch func() (map[string]interface{}, error)) (map[string]interface{}, error) {
+	m.mutex.Lock()
+	entry, ok := m.entries[key]
+	if !ok {
+		entry = &stepMemoEntry{done: make(chan struct{})}
+		m.entries[key] = entry
+	}
+	m.mutex.Unlock()
+
+	// if someone else is responsible for the lookup we just have to wait for them
+	if ok {
+		<-entry.done
+
+		// the error was already reported by the step that sent the query
+		if entry.err != nil && entry.result == nil {
+			return map[string]interface{}{}, nil
+		}
+
+		// a response that is missing some of the ids still has to be looked at by every step that uses it
+		return stepMemoCopy(entry.result).(map[string]interface{}), entry.err
+	}
+
+	result, err := fetch()
+
+	// the result we return is going to be modified as it is stitched into the response so
+	// the memo needs its own copy
+	entry.err = err
+	if _, partial := err.(*executorInjectedFieldsError); err == nil || partial {
+		entry.result = stepMemoCopy(result).(map[string]interface{})
+	}
+	close(entry.done)
+
+	return result, err
+}
+
+// stepMemoKey identifies the query that a step sends to look up the object with the given id
+func stepMemoKey(step *QueryPlanStep, id string) string {
+	// steps that weren't built by the planner might not know where they are going
+	location := step.Location
+	if location == "" {
+		location = fmt.Sprintf("%p", step)
+	}
+
+	return strings.Join([]string{location, step.ParentType, id, step.QueryString}, "\x00")
+}
+
+// stepMemoCopy returns a deep copy of a value in a response
+func stepMemoCopy(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, entry := range value {
+			copied[key] = stepMemoCopy(entry)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, entry := range value {
+			copied[i] = stepMemoCopy(entry)
+		}
+		return copied
+	default:
+		return value
+	}
+}
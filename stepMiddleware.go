@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"context"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// A RequestMiddleware only sees the http request that carries a step's query, when the query is just a string
+// in a body. A StepMiddleware sees the query itself right before it's sent to the service, after the injected
+// variables and the renamed types have been applied, and can change it or refuse to send it:
+//
+//	gateway.WithMiddlewares(gateway.StepMiddlewareFunc(func(ctx context.Context, step *gateway.StepRequest) error {
+//		if step.Location == "https://legacy.example.com" && step.ParentType == "Account" {
+//			return errors.New("accounts can't be read from the legacy service")
+//		}
+//		return nil
+//	}))
+//
+// The step middlewares run in the order they were given to the gateway and each one sees what the ones before it
+// changed. A middleware can change the document or the query string, whichever is more convenient. If the string
+// was changed, it's parsed and replaces the document, otherwise the document is printed and replaces the string,
+// so the two always agree when the next middleware gets them. The document is a copy, changing it doesn't change
+// the plan.
+//
+// A middleware that returns an error vetoes the step: the query isn't sent, the fields of the step are null in
+// the response, and the error is reported at the first of them. The gateway's own steps (ie, introspection)
+// don't go through the step middlewares.
+
+// StepRequest is the query that's about to be sent for a step of the plan
+type StepRequest struct {
+	StepInfo
+
+	// the query sent to the service and its parsed form
+	Query         string
+	QueryDocument *ast.QueryDocument
+	// the variables sent with the query
+	Variables map[string]interface{}
+}
+
+// StepMiddleware is a middleware that can change or veto the queries sent to the services
+type StepMiddleware interface {
+	Middleware
+	ModifyStep(ctx context.Context, step *StepRequest) error
+}
+
+// StepMiddlewareFunc turns a function into a StepMiddleware
+type StepMiddlewareFunc func(ctx context.Context, step *StepRequest) error
+
+// Middleware marks StepMiddlewareFunc as a valid middleware
+func (f StepMiddlewareFunc) Middleware() {}
+
+// ModifyStep calls the function
+func (f StepMiddlewareFunc) ModifyStep(ctx context.Context, step *StepRequest) error {
+	return f(ctx, step)
+}
+
+// stepVetoError is returned for a step that a middleware refused to send
+type stepVetoError struct {
+	err error
+}
+
+func (e *stepVetoError) Error() string {
+	return e.err.Error()
+}
+
+// errors returns the errors to report for the fields of the step
+func (e *stepVetoError) errors(step *QueryPlanStep, insertionPoint InsertionPoint) graphql.ErrorList {
+	vetoed := graphql.NewError("STEP_VETOED", e.err.Error())
+	// an error from the middleware can have its own code and details
+	if gqlErr, ok := e.err.(*graphql.Error); ok {
+		for key, value := range gqlErr.Extensions {
+			vetoed.Extensions[key] = value
+		}
+	}
+	vetoed.Path = executorStepErrorPath(step, insertionPoint)
+
+	return graphql.ErrorList{vetoed}
+}
+
+// applyStepMiddlewares passes the input through the middlewares and returns what should be sent instead
+func applyStepMiddlewares(ctx context.Context, middlewares []StepMiddleware, info StepInfo, input *graphql.QueryInput) (*graphql.QueryInput, error) {
+	// the middlewares get their own copy of the document since the plan's is shared between requests
+	document, err := parser.ParseQuery(&ast.Source{Input: input.Query})
+	if err != nil {
+		return nil, err
+	}
+
+	variables := map[string]interface{}{}
+	for key, value := range input.Variables {
+		variables[key] = value
+	}
+
+	request := &StepRequest{
+		StepInfo:      info,
+		Query:         input.Query,
+		QueryDocument: document,
+		Variables:     variables,
+	}
+
+	for _, middleware := range middlewares {
+		query := request.Query
+		if err := middleware.ModifyStep(ctx, request); err != nil {
+			return nil, &stepVetoError{err: err}
+		}
+
+		// make sure the string and the document agree before the next middleware sees them
+		if request.Query != query {
+			document, err := parser.ParseQuery(&ast.Source{Input: request.Query})
+			if err != nil {
+				return nil, err
+			}
+			request.QueryDocument = document
+		} else {
+			printed, err := plannerPrintQuery(request.QueryDocument)
+			if err != nil {
+				return nil, err
+			}
+			request.Query = printed
+		}
+	}
+
+	// the name of the operation could have changed too
+	operationName := input.OperationName
+	if len(request.QueryDocument.Operations) > 0 && request.QueryDocument.Operations[0].Name != "" {
+		operationName = request.QueryDocument.Operations[0].Name
+	}
+
+	return &graphql.QueryInput{
+		Query:         request.Query,
+		QueryDocument: request.QueryDocument,
+		Variables:     request.Variables,
+		OperationName: operationName,
+	}, nil
+}
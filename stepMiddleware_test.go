@@ -0,0 +1,131 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestGateway_stepMiddlewares(t *testing.T) {
+	usersSchema, _ := graphql.LoadSchema(`
+		type User {
+			firstName: String!
+		}
+
+		type Query {
+			allUsers: [User!]!
+		}
+	`)
+	catalogSchema, _ := graphql.LoadSchema(`
+		type Query {
+			categories: [String!]
+		}
+	`)
+
+	// the inputs sent to each service
+	sentMutex := &sync.Mutex{}
+	sent := map[string]*graphql.QueryInput{}
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			sentMutex.Lock()
+			sent[url] = input
+			sentMutex.Unlock()
+
+			if url == "users" {
+				return map[string]interface{}{
+					"allUsers": []interface{}{map[string]interface{}{"firstName": "John"}},
+				}, nil
+			}
+			return map[string]interface{}{"categories": []interface{}{"books"}}, nil
+		})
+	})
+
+	// the compliance tooling wants a tag on every query to the users service
+	tag := StepMiddlewareFunc(func(ctx context.Context, step *StepRequest) error {
+		if step.Location == "users" {
+			operation := step.QueryDocument.Operations[0]
+			operation.SelectionSet = append(operation.SelectionSet, &ast.Field{Alias: "complianceTag", Name: "complianceTag"})
+			step.Variables["complianceId"] = "abc"
+		}
+		return nil
+	})
+
+	// the users service still knows the first name by its old name
+	sawTag := false
+	rename := StepMiddlewareFunc(func(ctx context.Context, step *StepRequest) error {
+		if step.Location == "users" {
+			sawTag = strings.Contains(step.Query, "complianceTag")
+			step.Query = strings.Replace(step.Query, "firstName", "givenName: firstName", -1)
+		}
+		return nil
+	})
+
+	// and nobody gets to see the categories
+	veto := StepMiddlewareFunc(func(ctx context.Context, step *StepRequest) error {
+		if step.Location == "catalog" {
+			return errors.New("the catalog is closed")
+		}
+		return nil
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: "users", Schema: usersSchema},
+		{URL: "catalog", Schema: catalogSchema},
+	}, WithQueryerFactory(&factory), WithMiddlewares(tag, rename, veto))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader([]byte(`{"query": "{ allUsers { firstName } categories }"}`)))
+	recorder := httptest.NewRecorder()
+	gateway.GraphQLHandler(recorder, request)
+
+	response := map[string]interface{}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	// the fields of the vetoed step are null and the rest of the response is still there
+	assert.Equal(t, map[string]interface{}{
+		"allUsers":   []interface{}{map[string]interface{}{"firstName": "John"}},
+		"categories": nil,
+	}, response["data"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"message":    "the catalog is closed",
+			"path":       []interface{}{"categories"},
+			"extensions": map[string]interface{}{"code": "STEP_VETOED"},
+		},
+	}, response["errors"])
+	assert.Nil(t, sent["catalog"])
+
+	// the middlewares ran in order and the users service got what both of them did
+	assert.True(t, sawTag)
+	if input := sent["users"]; assert.NotNil(t, input) {
+		assert.Contains(t, input.Query, "complianceTag")
+		assert.Contains(t, input.Query, "givenName: firstName")
+		assert.Equal(t, "abc", input.Variables["complianceId"])
+
+		// the document agrees with the string
+		printed, err := plannerPrintQuery(input.QueryDocument)
+		assert.Nil(t, err)
+		assert.Equal(t, input.Query, printed)
+	}
+
+	// the plan wasn't changed along the way
+	plans, err := gateway.GetPlans(&RequestContext{Context: context.Background(), Query: `{ allUsers { firstName } }`})
+	if assert.Nil(t, err) {
+		assert.NotContains(t, plans[0].RootStep.Then[0].QueryString, "complianceTag")
+	}
+}
@@ -0,0 +1,409 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// The result of every step of a plan has to be inserted into the right object of the response. A step knows the
+// fields it is inserted under (ie, users.friends) but a field that is a list could point to many objects, so the
+// result of the step before it is used to find every object the step has to be executed for. Each of those is
+// identified by an InsertionPoint which records the index of the entries in every list along the way, as well as
+// the id of the object at the end.
+
+// PathPoint is a single field along the path to an object in the response
+type PathPoint struct {
+	// the key of the field in the response (its alias, if it has one)
+	Field string
+	// the index of the entry in each dimension of the list. Empty if the field is not a list.
+	Indices []int
+	// the id of the object. Only the last point of an insertion point has one.
+	ID string
+}
+
+// String returns the point in the form <field>:<index>#<id> where each of index or id is optional. A list of
+// lists has an index for every dimension, ie <field>:<index>:<index>#<id>
+func (p PathPoint) String() string {
+	point := &strings.Builder{}
+	point.WriteString(p.Field)
+	for _, index := range p.Indices {
+		point.WriteString(":")
+		point.WriteString(strconv.Itoa(index))
+	}
+	if p.ID != "" {
+		point.WriteString("#")
+		point.WriteString(p.ID)
+	}
+
+	return point.String()
+}
+
+// ParsePathPoint reads a point in the form produced by PathPoint.String
+func ParsePathPoint(point string) (PathPoint, error) {
+	result := PathPoint{Field: point}
+
+	// the id can have anything in it so we have to pull it out first
+	if strings.Contains(point, "#") {
+		idData := strings.SplitN(point, "#", 2)
+		result.Field = idData[0]
+		result.ID = idData[1]
+	}
+
+	if strings.Contains(result.Field, ":") {
+		indexData := strings.Split(result.Field, ":")
+		for _, indexString := range indexData[1:] {
+			indexValue, err := strconv.ParseInt(indexString, 0, 32)
+			if err != nil {
+				return PathPoint{}, err
+			}
+
+			result.Indices = append(result.Indices, int(indexValue))
+		}
+		result.Field = indexData[0]
+	}
+
+	return result, nil
+}
+
+// InsertionPoint is the path from the root of the response to an object that the result of a step is inserted into
+type InsertionPoint []PathPoint
+
+// ParseInsertionPoint reads an insertion point from the string form of its points
+func ParseInsertionPoint(points []string) (InsertionPoint, error) {
+	result := InsertionPoint{}
+	for _, point := range points {
+		parsed, err := ParsePathPoint(point)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, parsed)
+	}
+
+	return result, nil
+}
+
+// Strings returns the string form of each point
+func (p InsertionPoint) Strings() []string {
+	result := []string{}
+	for _, point := range p {
+		result = append(result, point.String())
+	}
+
+	return result
+}
+
+// String returns the points of the insertion point separated by spaces
+func (p InsertionPoint) String() string {
+	return strings.Join(p.Strings(), " ")
+}
+
+// ID returns the id of the object at the end of the insertion point
+func (p InsertionPoint) ID() string {
+	if len(p) == 0 {
+		return ""
+	}
+
+	return p[len(p)-1].ID
+}
+
+// Path returns the path of the object in the response, as it would show up in an error
+func (p InsertionPoint) Path() []interface{} {
+	path := []interface{}{}
+	for _, point := range p {
+		path = append(path, point.Field)
+		for _, index := range point.Indices {
+			path = append(path, index)
+		}
+	}
+
+	return path
+}
+
+// append returns a copy of the insertion point with the point added to the end
+func (p InsertionPoint) append(point PathPoint) InsertionPoint {
+	return append(append(InsertionPoint{}, p...), point)
+}
+
+// Stitcher puts the results of the steps of a plan together into a single response. It is safe to
+// use from the goroutines of every step.
+type Stitcher struct {
+	// the fields that identify the objects of each type
+	IDFields IDFieldMap
+
+	lock sync.Mutex
+}
+
+// NewStitcher returns a stitcher that identifies objects with the designated fields
+func NewStitcher(idFields IDFieldMap) *Stitcher {
+	return &Stitcher{IDFields: idFields}
+}
+
+// FindInsertionPoints returns every insertion point of a step given the result of the step it depends on.
+// target is the step's insertion point as it was planned (the keys of the fields it is inserted under),
+// selectionSet is what the previous step asked for, and start is where the previous step was inserted.
+func (s *Stitcher) FindInsertionPoints(target []string, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList, result map[string]interface{}, start InsertionPoint) ([]InsertionPoint, error) {
+	log.Debug("Looking for insertion points. target: ", target, " Starting from ", start)
+
+	// the step is inserted right where the previous one was
+	if len(start) >= len(target) {
+		return []InsertionPoint{start}, nil
+	}
+
+	return s.findInsertionPoints(target, selectionSet, fragments, result, start)
+}
+
+// findInsertionPoints walks down the result along the target, starting from the field after the point
+func (s *Stitcher) findInsertionPoints(target []string, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList, result map[string]interface{}, point InsertionPoint) ([]InsertionPoint, error) {
+	for i := len(point); i < len(target); i++ {
+		field := target[i]
+		last := i == len(target)-1
+
+		// find the selection node in the AST corresponding to the point
+		selection := stitcherFindSelection(field, selectionSet, fragments)
+		// if the previous step didn't ask for the field, there's nothing to insert into
+		if selection == nil {
+			return []InsertionPoint{}, nil
+		}
+		selectionSet = selection.SelectionSet
+
+		// the service could have left the field out, ie because the user isn't allowed to see it
+		value, ok := result[field]
+		if !ok {
+			log.Debug("Could not find ", field, " in the result. Skipping the insertion points under it")
+			return []InsertionPoint{}, nil
+		}
+
+		// there's nothing to insert into an object that's null. If the field couldn't be null, the
+		// service already reported why it is
+		fieldType := selection.Definition.Type
+		if value == nil {
+			return []InsertionPoint{}, nil
+		}
+
+		// every object in a list gets its own insertion points. The response gets the final say in case
+		// it doesn't agree with the definition of the field.
+		if _, isList := value.([]interface{}); isList || fieldType.Elem != nil {
+			entries, err := stitcherListEntries(value, fieldType, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			points := []InsertionPoint{}
+			for _, entry := range entries {
+				entryPoint := PathPoint{Field: field, Indices: entry.indices}
+				if last {
+					// an entry without an id is one the service couldn't resolve so only it is skipped
+					id, ok := s.objectID(entry.value, fieldType.Name())
+					if !ok {
+						log.Debug("Could not find the id of entry ", entry.indices, " of ", field, ". Skipping it")
+						continue
+					}
+					entryPoint.ID = id
+				}
+
+				entryPoints, err := s.findInsertionPoints(target, selectionSet, fragments, entry.value, point.append(entryPoint))
+				if err != nil {
+					return nil, err
+				}
+				points = append(points, entryPoints...)
+			}
+
+			return points, nil
+		}
+
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Root value of result chunk was not an object. Point: %v Value: %v", field, value)
+		}
+
+		objectPoint := PathPoint{Field: field}
+		if last {
+			// the id might have already been scrubbed
+			objectPoint.ID, _ = s.objectID(object, fieldType.Name())
+		}
+
+		point = point.append(objectPoint)
+		result = object
+	}
+
+	return []InsertionPoint{point}, nil
+}
+
+// objectID returns the id of an object that a step is inserted into
+func (s *Stitcher) objectID(object map[string]interface{}, typeName string) (string, bool) {
+	id, ok := executorObjectID(object, s.IDFields.FieldFor(typeName))
+	if !ok {
+		return "", false
+	}
+
+	return fmt.Sprintf("%v", id), true
+}
+
+// Extract returns the value at the insertion point. Any objects or lists missing along the way are created.
+func (s *Stitcher) Extract(source map[string]interface{}, point InsertionPoint) (interface{}, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.extract(source, point)
+}
+
+// Insert adds the fields of the value to the object at the insertion point
+func (s *Stitcher) Insert(target map[string]interface{}, point InsertionPoint, value interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	object := target
+	if len(point) > 0 {
+		found, err := s.extract(target, point)
+		if err != nil {
+			return err
+		}
+
+		foundObject, ok := found.(map[string]interface{})
+		if !ok {
+			return errors.New("target object is not an object")
+		}
+		object = foundObject
+	}
+
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		// there's nothing to add to an object that's already in the response
+		if len(point) > 0 {
+			return nil
+		}
+		return errors.New("the value inserted at the root of the response is not an object")
+	}
+
+	for key, fieldValue := range fields {
+		object[key] = fieldValue
+	}
+
+	return nil
+}
+
+// extract is the implementation of Extract for callers that are holding the lock
+func (s *Stitcher) extract(source map[string]interface{}, point InsertionPoint) (interface{}, error) {
+	var current interface{} = source
+
+	for _, pathPoint := range point {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Target was not an object. %v, %v", pathPoint, current)
+		}
+
+		// if the point is an object, all we have to do is make sure it's there
+		if len(pathPoint.Indices) == 0 {
+			if object[pathPoint.Field] == nil {
+				object[pathPoint.Field] = map[string]interface{}{}
+			}
+
+			current = object[pathPoint.Field]
+			continue
+		}
+
+		// otherwise it should be a list
+		if _, ok := object[pathPoint.Field]; !ok {
+			object[pathPoint.Field] = []interface{}{}
+		}
+		value := object[pathPoint.Field]
+
+		// a list of lists has an index for each dimension. set puts a list back where we found it
+		// in case we had to make room
+		field := pathPoint.Field
+		set := func(value interface{}) { object[field] = value }
+		for dimension, index := range pathPoint.Indices {
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("did not encounter a list when expected. Point: %v. Field: %v. Result %v", pathPoint, pathPoint.Field, value)
+			}
+
+			// if the list does not have enough spots
+			if len(list) <= index {
+				for len(list) <= index {
+					// the last dimension holds the objects
+					if dimension == len(pathPoint.Indices)-1 {
+						list = append(list, map[string]interface{}{})
+					} else {
+						list = append(list, []interface{}{})
+					}
+				}
+
+				// update the list with what we just made
+				set(list)
+			}
+
+			// focus on the right element
+			value = list[index]
+
+			entries, entryIndex := list, index
+			set = func(value interface{}) { entries[entryIndex] = value }
+		}
+
+		current = value
+	}
+
+	return current, nil
+}
+
+// stitcherListEntry is an object in a list along with its index in each dimension of the list
+type stitcherListEntry struct {
+	indices []int
+	value   map[string]interface{}
+}
+
+// stitcherListEntries returns the objects in a value of the list type. A list of lists is walked until
+// we get to the objects.
+func stitcherListEntries(value interface{}, listType *ast.Type, indices []int) ([]stitcherListEntry, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Root value of result chunk was not a list: %v", value)
+	}
+
+	entries := []stitcherListEntry{}
+	for i, item := range list {
+		// there's nothing to insert into an entry that's null (ie, a missing edge of a connection)
+		if item == nil {
+			continue
+		}
+
+		itemIndices := append(append([]int{}, indices...), i)
+
+		// if the entries are lists themselves, we have to look inside of them
+		if listType.Elem != nil && listType.Elem.Elem != nil {
+			nested, err := stitcherListEntries(item, listType.Elem, itemIndices)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, nested...)
+			continue
+		}
+
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("entry in result wasn't a map")
+		}
+		entries = append(entries, stitcherListEntry{indices: itemIndices, value: object})
+	}
+
+	return entries, nil
+}
+
+// stitcherFindSelection returns the field in the selection set with the key, looking inside of fragments
+func stitcherFindSelection(key string, selectionSet ast.SelectionSet, fragmentDefs ast.FragmentDefinitionList) *ast.Field {
+	for _, selection := range collectSelectedFields(selectionSet, fragmentDefs) {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if selection.Alias == key || selection.Name == key {
+				return selection
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,322 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2"
+)
+
+// the schema shared by the stitcher's golden tests
+const stitcherTestSchema = `
+	type Photo {
+		id: ID!
+		url: String!
+		owner: User!
+	}
+
+	type User {
+		id: ID!
+		name: String!
+		bestFriend: User
+		friends: [User!]!
+		photoGrid: [[Photo]]
+	}
+
+	type Query {
+		user: User
+		users: [User!]!
+	}
+`
+
+func TestStitcher_golden(t *testing.T) {
+	schema, err := graphql.LoadSchema(stitcherTestSchema)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	table := []struct {
+		Name string
+		// the query sent by the step the results are inserted after
+		Query string
+		// the response of that step
+		Result string
+		// where the next step is inserted
+		Target []string
+		// the response of the next step for each object it's executed for, indexed by insertion point
+		Results map[string]string
+	}{
+		{
+			Name:   "object",
+			Query:  `{ user { id bestFriend { id } } }`,
+			Result: `{"user": {"id": "1", "bestFriend": {"id": "2"}}}`,
+			Target: []string{"user", "bestFriend"},
+			Results: map[string]string{
+				"user bestFriend#2": `{"name": "Bob"}`,
+			},
+		},
+		{
+			Name:   "rootList",
+			Query:  `{ users { id } }`,
+			Result: `{"users": [{"id": "1"}, {"id": "2"}]}`,
+			Target: []string{"users"},
+			Results: map[string]string{
+				"users:0#1": `{"name": "Alice"}`,
+				"users:1#2": `{"name": "Bob"}`,
+			},
+		},
+		{
+			Name:   "nestedLists",
+			Query:  `{ users { id friends { id } } }`,
+			Result: `{"users": [{"id": "1", "friends": [{"id": "2"}, {"id": "3"}]}, {"id": "2", "friends": [{"id": "1"}]}]}`,
+			Target: []string{"users", "friends"},
+			Results: map[string]string{
+				"users:0 friends:0#2": `{"name": "Bob"}`,
+				"users:0 friends:1#3": `{"name": "Carol"}`,
+				"users:1 friends:0#1": `{"name": "Alice"}`,
+			},
+		},
+		{
+			Name:   "listOfLists",
+			Query:  `{ user { id photoGrid { id } } }`,
+			Result: `{"user": {"id": "1", "photoGrid": [[{"id": "1"}, null], [{"id": "2"}]]}}`,
+			Target: []string{"user", "photoGrid"},
+			Results: map[string]string{
+				"user photoGrid:0:0#1": `{"url": "1.jpg"}`,
+				"user photoGrid:1:0#2": `{"url": "2.jpg"}`,
+			},
+		},
+		{
+			Name:   "aliasesAndFragments",
+			Query:  `{ user { ... on User { id pals: friends { ... on User { id } } } } }`,
+			Result: `{"user": {"id": "1", "pals": [{"id": "2"}]}}`,
+			Target: []string{"user", "pals"},
+			Results: map[string]string{
+				"user pals:0#2": `{"name": "Bob"}`,
+			},
+		},
+		{
+			Name:    "nullObject",
+			Query:   `{ user { id bestFriend { id } } }`,
+			Result:  `{"user": {"id": "1", "bestFriend": null}}`,
+			Target:  []string{"user", "bestFriend"},
+			Results: map[string]string{},
+		},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			query, errs := gqlparser.LoadQuery(schema, row.Query)
+			if !assert.Nil(t, errs) {
+				return
+			}
+
+			response := map[string]interface{}{}
+			if !assert.Nil(t, json.Unmarshal([]byte(row.Result), &response)) {
+				return
+			}
+
+			stitcher := NewStitcher(IDFieldMap{})
+			points, err := stitcher.FindInsertionPoints(row.Target, query.Operations[0].SelectionSet, query.Fragments, response, InsertionPoint{})
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			// insert the result for every point we found
+			rendered := &strings.Builder{}
+			for _, point := range points {
+				fmt.Fprintf(rendered, "# %s\n", point)
+
+				// the point has to survive a trip through its string form
+				parsed, err := ParseInsertionPoint(point.Strings())
+				if !assert.Nil(t, err) || !assert.Equal(t, point.String(), parsed.String()) {
+					return
+				}
+
+				value := map[string]interface{}{}
+				if !assert.Nil(t, json.Unmarshal([]byte(row.Results[point.String()]), &value)) {
+					return
+				}
+				if !assert.Nil(t, stitcher.Insert(response, parsed, value)) {
+					return
+				}
+			}
+
+			stitched, err := json.MarshalIndent(response, "", "  ")
+			if !assert.Nil(t, err) {
+				return
+			}
+			rendered.Write(stitched)
+			rendered.WriteString("\n")
+
+			golden := filepath.Join("testdata", "stitcher", row.Name+".golden")
+			if *updateGolden {
+				if err := ioutil.WriteFile(golden, []byte(rendered.String()), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expected, err := ioutil.ReadFile(golden)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, string(expected), rendered.String())
+		})
+	}
+}
+
+func TestStitcher_extractCreatesMissingObjects(t *testing.T) {
+	stitcher := NewStitcher(IDFieldMap{})
+	response := map[string]interface{}{}
+
+	point, err := ParseInsertionPoint([]string{"user", "friends:1#2"})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	value, err := stitcher.Extract(response, point)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{}, value)
+	assert.Equal(t, map[string]interface{}{
+		"user": map[string]interface{}{
+			"friends": []interface{}{
+				map[string]interface{}{},
+				map[string]interface{}{},
+			},
+		},
+	}, response)
+	assert.Equal(t, []interface{}{"user", "friends", 1}, point.Path())
+	assert.Equal(t, "2", point.ID())
+}
+
+func TestGateway_dependentStepsOfMissingParents(t *testing.T) {
+	// execute sends the query to a gateway whose user service responds with the designated data and
+	// returns the result along with the ids the cat service was asked about
+	execute := func(query string, users map[string]interface{}) (map[string]interface{}, []string, error) {
+		// the merge changes the schemas so every gateway needs its own
+		usersSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type User implements Node {
+				id: ID!
+				firstName: String!
+			}
+
+			type Query {
+				viewer: User
+				allUsers: [User]
+				node(id: ID!): Node
+			}
+		`)
+		catsSchema, _ := graphql.LoadSchema(`
+			interface Node {
+				id: ID!
+			}
+
+			type User implements Node {
+				id: ID!
+				favoriteCat: String!
+			}
+
+			type Query {
+				node(id: ID!): Node
+			}
+		`)
+
+		var mutex sync.Mutex
+		lookups := []string{}
+
+		factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+			return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+				if url == "users" {
+					return users, nil
+				}
+
+				id := input.Variables[gatewayIDAlias].(string)
+				mutex.Lock()
+				lookups = append(lookups, id)
+				mutex.Unlock()
+
+				return map[string]interface{}{
+					gatewayNodeAlias: map[string]interface{}{"favoriteCat": "cat-" + id},
+				}, nil
+			})
+		})
+
+		gateway, err := New([]*graphql.RemoteSchema{
+			{Schema: usersSchema, URL: "users"},
+			{Schema: catsSchema, URL: "cats"},
+		}, WithQueryerFactory(&factory))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reqCtx := &RequestContext{Context: context.Background(), Query: query}
+		plans, err := gateway.GetPlans(reqCtx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		result, err := gateway.Execute(reqCtx, plans)
+		return result, lookups, err
+	}
+
+	t.Run("null parent", func(t *testing.T) {
+		result, lookups, err := execute(`{ viewer { firstName favoriteCat } }`, map[string]interface{}{
+			"viewer": nil,
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, map[string]interface{}{"viewer": nil}, result)
+		assert.Len(t, lookups, 0)
+	})
+
+	t.Run("missing parent", func(t *testing.T) {
+		// the service left the field out of its response, ie because the user isn't allowed to see it
+		result, lookups, err := execute(`{ viewer { firstName favoriteCat } }`, map[string]interface{}{})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, map[string]interface{}{}, result)
+		assert.Len(t, lookups, 0)
+	})
+
+	t.Run("null entries", func(t *testing.T) {
+		result, lookups, err := execute(`{ allUsers { firstName favoriteCat } }`, map[string]interface{}{
+			"allUsers": []interface{}{
+				map[string]interface{}{gatewayIDAlias: "1", "firstName": "alice"},
+				nil,
+				map[string]interface{}{"firstName": "carol"},
+				map[string]interface{}{gatewayIDAlias: "4", "firstName": "dave"},
+			},
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		assert.Equal(t, map[string]interface{}{
+			"allUsers": []interface{}{
+				map[string]interface{}{"firstName": "alice", "favoriteCat": "cat-1"},
+				nil,
+				map[string]interface{}{"firstName": "carol"},
+				map[string]interface{}{"firstName": "dave", "favoriteCat": "cat-4"},
+			},
+		}, result)
+		assert.ElementsMatch(t, []string{"1", "4"}, lookups)
+	})
+}
@@ -0,0 +1,167 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// A large response would have to be in memory twice if it was serialized before it was sent: once as the stitched
+// maps and once as bytes. Instead, the response is encoded straight to the client as the maps are walked. The status
+// code is only sent with the first chunk of the response so an error that happens before then can still be reported
+// properly. Once the client has started to receive the response there is nothing left to do but stop writing and log
+// the error, the client will see a truncated document.
+
+// the number of bytes that are encoded before they are sent to the client
+const streamBufferSize = 32 * 1024
+
+// streamResponse encodes the response to the client. An error is returned if the response couldn't be sent in full.
+func streamResponse(w http.ResponseWriter, code int, response interface{}) error {
+	// the length of the response isn't known until it has been written, the server will chunk it
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+
+	out := &deferredHeaderWriter{ResponseWriter: w, code: code}
+	encoder := &responseEncoder{w: bufio.NewWriterSize(out, streamBufferSize)}
+
+	err := encoder.encode(response)
+	if err == nil {
+		err = encoder.w.Flush()
+	}
+	if err == nil {
+		return nil
+	}
+
+	// if the client hasn't seen anything yet, we can still tell them what went wrong
+	if !out.written {
+		body, _ := json.Marshal(formatErrors(nil, err))
+		emitResponse(w, http.StatusInternalServerError, string(body))
+		return nil
+	}
+
+	log.Warn("Could not finish writing the response: ", err)
+	return err
+}
+
+// deferredHeaderWriter sends the status code along with the first bytes of the body
+type deferredHeaderWriter struct {
+	http.ResponseWriter
+	code    int
+	written bool
+}
+
+func (w *deferredHeaderWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.written = true
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// responseEncoder writes the JSON encoding of a response. The output is the same as json.Marshal.
+type responseEncoder struct {
+	w *bufio.Writer
+}
+
+// encode writes the value
+func (e *responseEncoder) encode(value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		_, err := e.w.WriteString("null")
+		return err
+
+	case *OrderedMap:
+		if value == nil {
+			_, err := e.w.WriteString("null")
+			return err
+		}
+		return e.object(value.Keys, value.Values)
+
+	case map[string]interface{}:
+		if value == nil {
+			_, err := e.w.WriteString("null")
+			return err
+		}
+
+		// json.Marshal sorts the keys of a map
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		return e.object(keys, value)
+
+	case []interface{}:
+		if value == nil {
+			_, err := e.w.WriteString("null")
+			return err
+		}
+		return e.list(len(value), func(i int) interface{} { return value[i] })
+
+	case []map[string]interface{}:
+		if value == nil {
+			_, err := e.w.WriteString("null")
+			return err
+		}
+		return e.list(len(value), func(i int) interface{} { return value[i] })
+
+	default:
+		// everything else is small enough to leave to the standard library
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = e.w.Write(encoded)
+		return err
+	}
+}
+
+// object writes an object with the keys in the designated order
+func (e *responseEncoder) object(keys []string, values map[string]interface{}) error {
+	if err := e.w.WriteByte('{'); err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		if i > 0 {
+			if err := e.w.WriteByte(','); err != nil {
+				return err
+			}
+		}
+
+		if err := e.encode(key); err != nil {
+			return err
+		}
+		if err := e.w.WriteByte(':'); err != nil {
+			return err
+		}
+		if err := e.encode(values[key]); err != nil {
+			return err
+		}
+	}
+
+	return e.w.WriteByte('}')
+}
+
+// list writes a list of the designated length
+func (e *responseEncoder) list(length int, entry func(int) interface{}) error {
+	if err := e.w.WriteByte('['); err != nil {
+		return err
+	}
+
+	for i := 0; i < length; i++ {
+		if i > 0 {
+			if err := e.w.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		if err := e.encode(entry(i)); err != nil {
+			return err
+		}
+	}
+
+	return e.w.WriteByte(']')
+}
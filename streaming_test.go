@@ -0,0 +1,166 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamResponse(t *testing.T) {
+	t.Run("Same as json.Marshal", func(t *testing.T) {
+		values := []interface{}{
+			nil,
+			map[string]interface{}{"b": 1, "a": "<html> & friends", "c": nil},
+			&OrderedMap{Keys: []string{"b", "a"}, Values: map[string]interface{}{"a": []interface{}{1.5, true}, "b": "hello"}},
+			&OrderedMap{},
+			[]map[string]interface{}{{"data": map[string]interface{}{"x": 1}}, {"errors": graphql.ErrorList{graphql.NewError("CODE", "oops")}}},
+			map[string]interface{}{"list": []interface{}{}, "nilList": []interface{}(nil), "nilMap": map[string]interface{}(nil)},
+		}
+
+		for _, value := range values {
+			expected, err := json.Marshal(value)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			recorder := httptest.NewRecorder()
+			if !assert.Nil(t, streamResponse(recorder, http.StatusOK, value)) {
+				return
+			}
+			assert.Equal(t, string(expected), recorder.Body.String())
+			assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("Error before anything was written", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		assert.Nil(t, streamResponse(recorder, http.StatusOK, map[string]interface{}{"data": math.Inf(1)}))
+
+		// the client is told what went wrong
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		result := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+		assert.NotNil(t, result["errors"])
+	})
+
+	t.Run("Error after the response started", func(t *testing.T) {
+		entries := []interface{}{}
+		for i := 0; i < streamBufferSize; i++ {
+			entries = append(entries, i)
+		}
+		entries = append(entries, math.Inf(1))
+
+		recorder := httptest.NewRecorder()
+		assert.NotNil(t, streamResponse(recorder, http.StatusOK, map[string]interface{}{"data": entries}))
+
+		// the response is cut off instead of being followed by another document
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.True(t, strings.HasPrefix(recorder.Body.String(), `{"data":[0,1,2`))
+		assert.NotContains(t, recorder.Body.String(), "errors")
+	})
+}
+
+func TestGraphQLHandler_streamsLargeResponses(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			values: [String!]!
+		}
+	`)
+
+	values := []interface{}{}
+	for i := 0; i < 10000; i++ {
+		values = append(values, fmt.Sprintf("value-%d", i))
+	}
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithExecutor(ExecutorFunc(func(ctx *ExecutionContext) (map[string]interface{}, error) {
+			return map[string]interface{}{"values": values}, nil
+		})),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(gateway.GraphQLHandler))
+	defer server.Close()
+
+	response, err := http.Post(server.URL, "application/json", strings.NewReader(`{"query": "{ values }"}`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer response.Body.Close()
+
+	// the length isn't known ahead of time
+	assert.Equal(t, int64(-1), response.ContentLength)
+	assert.Equal(t, []string{"chunked"}, response.TransferEncoding)
+
+	result := struct {
+		Data struct {
+			Values []string `json:"values"`
+		} `json:"data"`
+	}{}
+	if !assert.Nil(t, json.NewDecoder(response.Body).Decode(&result)) {
+		return
+	}
+	assert.Len(t, result.Data.Values, len(values))
+}
+
+// discardResponseWriter is a http.ResponseWriter that throws away what it is sent
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = http.Header{}
+	}
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+func (w *discardResponseWriter) WriteHeader(int) {}
+
+func BenchmarkResponseSerialization(b *testing.B) {
+	// a result that serializes to roughly 50MB
+	entries := []interface{}{}
+	for i := 0; i < 200000; i++ {
+		entries = append(entries, &OrderedMap{
+			Keys: []string{"id", "name", "email", "bio"},
+			Values: map[string]interface{}{
+				"id":    fmt.Sprintf("user-%d", i),
+				"name":  fmt.Sprintf("User Number %d", i),
+				"email": fmt.Sprintf("user-%d@example.com", i),
+				"bio":   strings.Repeat("lorem ipsum ", 15),
+			},
+		})
+	}
+	response := map[string]interface{}{"data": &OrderedMap{Keys: []string{"users"}, Values: map[string]interface{}{"users": entries}}}
+
+	b.Run("Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			serialized, err := json.Marshal(response)
+			if err != nil {
+				b.Fatal(err)
+			}
+			emitResponse(&discardResponseWriter{}, http.StatusOK, string(serialized))
+		}
+	})
+
+	b.Run("Stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := streamResponse(&discardResponseWriter{}, http.StatusOK, response); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,381 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Two services can use the same name for types that have nothing to do with each other. Instead of
+// asking one of them to change, the gateway can give the types of a service new names. The schema of the
+// service is renamed before it is merged, the queries sent to the service are translated back to the names
+// it knows, and the __typename values in its responses are translated to the names the clients see.
+
+// TypeRenames holds the name that the gateway gives to the types of a service, indexed by the name
+// of the type in the service's schema
+type TypeRenames map[string]string
+
+// WithTypeRename returns an Option that gives the type of the service at url a new name in the gateway's schema
+func WithTypeRename(url string, from string, to string) Option {
+	return func(g *Gateway) {
+		if g.typeRenames == nil {
+			g.typeRenames = map[string]TypeRenames{}
+		}
+		if g.typeRenames[url] == nil {
+			g.typeRenames[url] = TypeRenames{}
+		}
+		g.typeRenames[url][from] = to
+	}
+}
+
+// WithServicePrefix returns an Option that adds the prefix to the name of every type defined by the
+// service at url. The root types, the built-in scalars, and the Node interface keep their names.
+// Types renamed with WithTypeRename get the name they were given instead.
+func WithServicePrefix(url string, prefix string) Option {
+	return func(g *Gateway) {
+		if g.servicePrefixes == nil {
+			g.servicePrefixes = map[string]string{}
+		}
+		g.servicePrefixes[url] = prefix
+	}
+}
+
+// renameSources returns a version of the sources where the types of the services are renamed, along with the
+// names given to the types of each service. The entity keys are updated to refer to the new names.
+func (g *Gateway) renameSources(sources []*graphql.RemoteSchema, entityKeys EntityKeyMap) ([]*graphql.RemoteSchema, EntityKeyMap, map[string]TypeRenames, error) {
+	result := []*graphql.RemoteSchema{}
+	var renamed map[string]TypeRenames
+	for _, source := range sources {
+		renames, err := g.serviceRenames(source)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(renames) == 0 {
+			result = append(result, source)
+			continue
+		}
+
+		if renamed == nil {
+			renamed = map[string]TypeRenames{}
+		}
+		renamed[source.URL] = renames
+		result = append(result, &graphql.RemoteSchema{URL: source.URL, Schema: renameSchemaTypes(source.Schema, renames)})
+
+		// the keys of the entities are indexed by type
+		if keys, ok := entityKeys[source.URL]; ok {
+			renamedKeys := map[string]string{}
+			for typeName, key := range keys {
+				renamedKeys[renames.forward(typeName)] = key
+			}
+			entityKeys[source.URL] = renamedKeys
+		}
+	}
+
+	return result, entityKeys, renamed, nil
+}
+
+// serviceRenames returns the names that the types of the service are given in the gateway's schema
+func (g *Gateway) serviceRenames(source *graphql.RemoteSchema) (TypeRenames, error) {
+	// the root types have to use the gateway's names
+	renames, err := rootTypeRenames(source.URL, source.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix := g.servicePrefixes[source.URL]; prefix != "" {
+		for name, definition := range source.Schema.Types {
+			if !renamableType(source.Schema, definition) || name == "Node" {
+				continue
+			}
+			renames[name] = prefix + name
+		}
+	}
+
+	for from, to := range g.typeRenames[source.URL] {
+		definition, ok := source.Schema.Types[from]
+		if !ok {
+			return nil, fmt.Errorf("Could not find type %s to rename in %s", from, source.URL)
+		}
+		if !renamableType(source.Schema, definition) {
+			return nil, fmt.Errorf("Cannot rename type %s in %s", from, source.URL)
+		}
+		renames[from] = to
+	}
+
+	return renames, nil
+}
+
+// renamableType returns true if the type can be given a new name
+func renamableType(schema *ast.Schema, definition *ast.Definition) bool {
+	// the gateway relies on the names of the root types
+	if definition == schema.Query || definition == schema.Mutation || definition == schema.Subscription {
+		return false
+	}
+
+	// the built-in types and the ones that are reserved by graphql (or federation) are the same everywhere
+	return !definition.BuiltIn && !graphqlBuiltinTypes.Has(definition.Name) && !strings.HasPrefix(definition.Name, "_")
+}
+
+// the scalars defined by every schema
+var graphqlBuiltinTypes = Set{
+	"Int":     true,
+	"Float":   true,
+	"String":  true,
+	"Boolean": true,
+	"ID":      true,
+}
+
+// forward returns the name of the type in the gateway's schema
+func (r TypeRenames) forward(name string) string {
+	if renamed, ok := r[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// backward returns the name of the type in the service's schema
+func (r TypeRenames) backward(name string) string {
+	for from, to := range r {
+		if to == name {
+			return from
+		}
+	}
+	return name
+}
+
+// renameSchemaTypes returns a copy of the schema where the types are renamed, along with every reference to them
+func renameSchemaTypes(source *ast.Schema, renames TypeRenames) *ast.Schema {
+	schema := &ast.Schema{
+		Types:         map[string]*ast.Definition{},
+		Directives:    map[string]*ast.DirectiveDefinition{},
+		PossibleTypes: map[string][]*ast.Definition{},
+		Implements:    map[string][]*ast.Definition{},
+	}
+
+	// the copies of the definitions, indexed by their original name
+	copies := map[string]*ast.Definition{}
+	for name, definition := range source.Types {
+		copied := *definition
+		copied.Name = renames.forward(definition.Name)
+
+		copied.Interfaces = []string{}
+		for _, iface := range definition.Interfaces {
+			copied.Interfaces = append(copied.Interfaces, renames.forward(iface))
+		}
+		copied.Types = []string{}
+		for _, member := range definition.Types {
+			copied.Types = append(copied.Types, renames.forward(member))
+		}
+
+		copied.Fields = ast.FieldList{}
+		for _, field := range definition.Fields {
+			copiedField := *field
+			copiedField.Type = mapTypeName(field.Type, renames.forward)
+			copiedField.Arguments = renameArgumentDefinitions(field.Arguments, renames)
+			copied.Fields = append(copied.Fields, &copiedField)
+		}
+
+		copies[name] = &copied
+		schema.Types[copied.Name] = &copied
+	}
+
+	for name, directive := range source.Directives {
+		copied := *directive
+		copied.Arguments = renameArgumentDefinitions(directive.Arguments, renames)
+		schema.Directives[name] = &copied
+	}
+
+	for name, possibleTypes := range source.PossibleTypes {
+		for _, possibleType := range possibleTypes {
+			schema.AddPossibleType(renames.forward(name), copies[possibleType.Name])
+		}
+	}
+	for name, implements := range source.Implements {
+		for _, iface := range implements {
+			schema.AddImplements(renames.forward(name), copies[iface.Name])
+		}
+	}
+
+	if source.Query != nil {
+		schema.Query = copies[source.Query.Name]
+	}
+	if source.Mutation != nil {
+		schema.Mutation = copies[source.Mutation.Name]
+	}
+	if source.Subscription != nil {
+		schema.Subscription = copies[source.Subscription.Name]
+	}
+
+	return schema
+}
+
+// renameArgumentDefinitions returns a copy of the arguments that refers to the renamed types
+func renameArgumentDefinitions(arguments ast.ArgumentDefinitionList, renames TypeRenames) ast.ArgumentDefinitionList {
+	if arguments == nil {
+		return nil
+	}
+
+	result := ast.ArgumentDefinitionList{}
+	for _, argument := range arguments {
+		copied := *argument
+		copied.Type = mapTypeName(argument.Type, renames.forward)
+		result = append(result, &copied)
+	}
+
+	return result
+}
+
+// mapTypeName returns a copy of the type with the name of the underlying type changed by the function
+func mapTypeName(source *ast.Type, rename func(string) string) *ast.Type {
+	if source == nil {
+		return nil
+	}
+
+	copied := *source
+	if source.Elem != nil {
+		copied.Elem = mapTypeName(source.Elem, rename)
+	} else {
+		copied.NamedType = rename(source.NamedType)
+	}
+
+	return &copied
+}
+
+// serviceInput returns a version of the input that uses the names the service gave its types
+func (r TypeRenames) serviceInput(input *graphql.QueryInput) (*graphql.QueryInput, error) {
+	document := input.QueryDocument
+	if document == nil {
+		return input, nil
+	}
+
+	renamed := &ast.QueryDocument{}
+	for _, operation := range document.Operations {
+		copied := *operation
+		copied.SelectionSet = r.serviceSelectionSet(operation.SelectionSet)
+
+		copied.VariableDefinitions = ast.VariableDefinitionList{}
+		for _, variable := range operation.VariableDefinitions {
+			copiedVariable := *variable
+			copiedVariable.Type = mapTypeName(variable.Type, r.backward)
+			copied.VariableDefinitions = append(copied.VariableDefinitions, &copiedVariable)
+		}
+
+		renamed.Operations = append(renamed.Operations, &copied)
+	}
+	for _, fragment := range document.Fragments {
+		copied := *fragment
+		copied.TypeCondition = r.backward(fragment.TypeCondition)
+		copied.SelectionSet = r.serviceSelectionSet(fragment.SelectionSet)
+		renamed.Fragments = append(renamed.Fragments, &copied)
+	}
+
+	query, err := plannerPrintQuery(renamed)
+	if err != nil {
+		return nil, err
+	}
+
+	// the representations of entities carry the name of their type
+	variables, _ := r.serviceValue(input.Variables).(map[string]interface{})
+
+	return &graphql.QueryInput{
+		Query:         query,
+		QueryDocument: renamed,
+		OperationName: input.OperationName,
+		Variables:     variables,
+	}, nil
+}
+
+// serviceSelectionSet returns a copy of the selection set whose fragments refer to the names the service
+// gave its types
+func (r TypeRenames) serviceSelectionSet(selectionSet ast.SelectionSet) ast.SelectionSet {
+	if selectionSet == nil {
+		return nil
+	}
+
+	result := ast.SelectionSet{}
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			copied := *selection
+			copied.SelectionSet = r.serviceSelectionSet(selection.SelectionSet)
+			result = append(result, &copied)
+
+		case *ast.InlineFragment:
+			copied := *selection
+			copied.TypeCondition = r.backward(selection.TypeCondition)
+			copied.SelectionSet = r.serviceSelectionSet(selection.SelectionSet)
+			result = append(result, &copied)
+
+		default:
+			result = append(result, selection)
+		}
+	}
+
+	return result
+}
+
+// serviceValue returns a copy of the variable value where __typename refers to the names the service gave its types
+func (r TypeRenames) serviceValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+		for key, entry := range value {
+			if typeName, ok := entry.(string); ok && key == "__typename" {
+				result[key] = r.backward(typeName)
+				continue
+			}
+			result[key] = r.serviceValue(entry)
+		}
+		return result
+
+	case []interface{}:
+		result := []interface{}{}
+		for _, entry := range value {
+			result = append(result, r.serviceValue(entry))
+		}
+		return result
+	}
+
+	return value
+}
+
+// renameResponse changes the __typename values in the response to the query so they refer to the
+// names in the gateway's schema
+func (r TypeRenames) renameResponse(value interface{}, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) error {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, selection := range collectSelectedFields(selectionSet, fragments) {
+			field, ok := selection.(*ast.Field)
+			if !ok {
+				continue
+			}
+
+			key := field.Alias
+			if key == "" {
+				key = field.Name
+			}
+
+			if field.Name == "__typename" {
+				if typeName, ok := value[key].(string); ok {
+					value[key] = r.forward(typeName)
+				}
+				continue
+			}
+
+			if err := r.renameResponse(value[key], field.SelectionSet, fragments); err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		for _, entry := range value {
+			if err := r.renameResponse(entry, selectionSet, fragments); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
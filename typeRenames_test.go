@@ -0,0 +1,211 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestGateway_servicePrefix(t *testing.T) {
+	// both services have settings that have nothing to do with each other
+	accountsSchema, _ := graphql.LoadSchema(`
+		type Settings {
+			theme: String!
+		}
+
+		type Query {
+			accountSettings: Settings!
+		}
+	`)
+	billingSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		enum Currency {
+			USD
+			EUR
+		}
+
+		input SettingsFilter {
+			currency: Currency
+		}
+
+		type Settings implements Node {
+			id: ID!
+			currency: Currency!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			billingSettings(filter: SettingsFilter): [Settings!]!
+		}
+	`)
+
+	gateway, err := New(
+		[]*graphql.RemoteSchema{
+			{URL: "accounts", Schema: accountsSchema},
+			{URL: "billing", Schema: billingSchema},
+		},
+		WithServicePrefix("billing", "Billing"),
+		WithServiceQueryer("accounts", graphql.QueryerFunc(func(*graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{"accountSettings": map[string]interface{}{"theme": "dark"}}, nil
+		})),
+		// the mocked service only knows the names in its own schema
+		WithMockedService("billing", MockOptions{ListLength: 1}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the types of the billing service are prefixed
+	for _, name := range []string{"Settings", "BillingSettings", "BillingCurrency", "BillingSettingsFilter", "Node"} {
+		assert.NotNil(t, gateway.schema.Types[name], name)
+	}
+	assert.Nil(t, gateway.schema.Types["BillingNode"])
+	assert.Nil(t, gateway.schema.Types["BillingQuery"])
+	assert.Equal(t, "BillingSettingsFilter", gateway.schema.Query.Fields.ForName("billingSettings").Arguments.ForName("filter").Type.Name())
+
+	plans, err := gateway.GetPlans(&RequestContext{
+		Context: context.Background(),
+		Query: `
+			query($filter: BillingSettingsFilter) {
+				accountSettings {
+					theme
+				}
+				billingSettings(filter: $filter) {
+					__typename
+					kind: __typename
+					currency
+					... on BillingSettings {
+						id
+					}
+				}
+			}
+		`,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	result, err := gateway.Execute(&RequestContext{
+		Context:   context.Background(),
+		Variables: map[string]interface{}{"filter": map[string]interface{}{"currency": "EUR"}},
+	}, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{"theme": "dark"}, result["accountSettings"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"__typename": "BillingSettings",
+			"kind":       "BillingSettings",
+			"currency":   "USD",
+			"id":         "1",
+		},
+	}, result["billingSettings"])
+}
+
+func TestGateway_typeRenameNotFound(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			hello: String
+		}
+	`)
+
+	_, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithTypeRename("url1", "Settings", "UserSettings"))
+	assert.NotNil(t, err)
+
+	_, err = New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithTypeRename("url1", "Query", "UserQuery"))
+	assert.NotNil(t, err)
+}
+
+func TestRenameSchemaTypes(t *testing.T) {
+	source, _ := graphql.LoadSchema(`
+		interface Named {
+			name: String!
+		}
+
+		type Settings implements Named {
+			name: String!
+		}
+
+		union Result = Settings
+
+		type Query {
+			result: Result
+			named(names: [String!]): [Named!]!
+		}
+	`)
+
+	schema := renameSchemaTypes(source, TypeRenames{"Named": "UserNamed", "Settings": "UserSettings", "Result": "UserResult"})
+
+	// every reference to the types was renamed
+	assert.Equal(t, []string{"UserNamed"}, schema.Types["UserSettings"].Interfaces)
+	assert.Equal(t, []string{"UserSettings"}, schema.Types["UserResult"].Types)
+	assert.Equal(t, "UserResult", schema.Query.Fields.ForName("result").Type.Name())
+	assert.Equal(t, "[UserNamed!]!", schema.Query.Fields.ForName("named").Type.String())
+	assert.Equal(t, "[String!]", schema.Query.Fields.ForName("named").Arguments.ForName("names").Type.String())
+	assert.Equal(t, "UserSettings", schema.GetPossibleTypes(schema.Types["UserNamed"])[0].Name)
+	assert.Equal(t, "UserSettings", schema.GetPossibleTypes(schema.Types["UserResult"])[0].Name)
+	assert.Equal(t, "UserNamed", schema.GetImplements(schema.Types["UserSettings"])[0].Name)
+
+	// the source was left alone
+	assert.NotNil(t, source.Types["Settings"])
+	assert.Equal(t, []string{"Named"}, source.Types["Settings"].Interfaces)
+}
+
+func TestTypeRenames_serviceInput(t *testing.T) {
+	renames := TypeRenames{"Settings": "BillingSettings", "SettingsFilter": "BillingSettingsFilter"}
+
+	input, err := renames.serviceInput(&graphql.QueryInput{
+		QueryDocument: &ast.QueryDocument{
+			Operations: ast.OperationList{
+				{
+					Operation: ast.Query,
+					Name:      "Billing",
+					VariableDefinitions: ast.VariableDefinitionList{
+						{Variable: "filter", Type: ast.NonNullNamedType("BillingSettingsFilter", nil)},
+						{Variable: "representations", Type: ast.NonNullListType(ast.NonNullNamedType("_Any", nil), nil)},
+					},
+					SelectionSet: ast.SelectionSet{
+						&ast.Field{
+							Name: "settings",
+							SelectionSet: ast.SelectionSet{
+								&ast.InlineFragment{
+									TypeCondition: "BillingSettings",
+									SelectionSet: ast.SelectionSet{
+										&ast.Field{Name: "currency"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		OperationName: "Billing",
+		Variables: map[string]interface{}{
+			"representations": []interface{}{
+				map[string]interface{}{"__typename": "BillingSettings", "id": "1"},
+			},
+		},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Contains(t, input.Query, "query Billing ($filter: SettingsFilter!, $representations: [_Any!]!)")
+	assert.Contains(t, input.Query, "... on Settings {")
+	assert.NotContains(t, input.Query, "BillingSettings")
+	assert.Equal(t, "Billing", input.OperationName)
+	assert.Equal(t, map[string]interface{}{
+		"representations": []interface{}{
+			map[string]interface{}{"__typename": "Settings", "id": "1"},
+		},
+	}, input.Variables)
+}
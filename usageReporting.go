@@ -0,0 +1,613 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// A registry of the operations that clients send (which ones, how often, how fast, and how often they fail)
+// is what tells the people who own a schema whether a field can be removed. With usage reporting, the gateway
+// keeps counts for every operation it executes and sends them to the registry every so often:
+//
+//	gateway.New(sources, gateway.WithUsageReporting(gateway.UsageReportingOptions{
+//		Endpoint: "https://registry.example.com/usage",
+//		Interval: 30 * time.Second,
+//	}))
+//
+// Operations are told apart by their signature: the operation with its literals replaced by zero values,
+// its aliases removed, and everything sorted, so the same query with different arguments or formatting counts
+// as the same operation. The counts are kept per signature and client (which comes from the headers of the
+// request). The buffer has room for a limited number of them. Once it's full, the operations it doesn't have
+// yet are dropped (and counted as dropped) so reporting never holds up a request. A report that can't be sent
+// is merged back into the buffer for the next attempt, under the same limit.
+
+// DefaultClientNameHeader is the header that holds the name of the client unless another one is provided
+const DefaultClientNameHeader = "apollographql-client-name"
+
+// DefaultClientVersionHeader is the header that holds the version of the client unless another one is provided
+const DefaultClientVersionHeader = "apollographql-client-version"
+
+// the defaults for the options that are left out
+const (
+	defaultUsageReportingInterval = 10 * time.Second
+	defaultUsageReportingMaxSize  = 1000
+)
+
+// UsageReportingOptions configures the reports of the operations the gateway executes
+type UsageReportingOptions struct {
+	// the url the reports are posted to as JSON. It's only used if there's no Transport.
+	Endpoint string
+	// how often the reports are sent. Defaults to 10 seconds.
+	Interval time.Duration
+	// the headers with the name and version of the client
+	ClientNameHeader    string
+	ClientVersionHeader string
+	// changes the signature of an operation before it's reported, ie to hide the names of sensitive fields.
+	// Operations are still told apart by their signature before it was redacted.
+	Redact func(signature string) string
+	// the number of operations (per client) that are kept between reports. Defaults to 1000.
+	MaxOperations int
+	// sends the reports somewhere else, or in another format
+	Transport UsageReportTransport
+}
+
+// UsageReportTransport sends a report to the registry
+type UsageReportTransport interface {
+	SendUsageReport(ctx context.Context, report *UsageReport) error
+}
+
+// UsageReportTransportFunc turns a function into a UsageReportTransport
+type UsageReportTransportFunc func(ctx context.Context, report *UsageReport) error
+
+// SendUsageReport calls the function
+func (f UsageReportTransportFunc) SendUsageReport(ctx context.Context, report *UsageReport) error {
+	return f(ctx, report)
+}
+
+// UsageReport is what the gateway saw between two reports
+type UsageReport struct {
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Operations []*OperationUsage `json:"operations"`
+	// the number of operations that weren't counted because the buffer was full
+	Dropped int64 `json:"dropped"`
+}
+
+// OperationUsage holds the counts of a single operation sent by a single client
+type OperationUsage struct {
+	Signature     string `json:"signature"`
+	Hash          string `json:"hash"`
+	OperationName string `json:"operationName"`
+	ClientName    string `json:"clientName"`
+	ClientVersion string `json:"clientVersion"`
+	// the number of times the operation was executed, and how many of them had errors
+	Count  int64 `json:"count"`
+	Errors int64 `json:"errors"`
+	// how long the operation took
+	Latency *UsageLatency `json:"latency"`
+	// how long the requests that resolved each field took, indexed by coordinate (ie, User.name)
+	Fields map[string]*UsageLatency `json:"fields"`
+}
+
+// UsageLatency summarizes a number of durations
+type UsageLatency struct {
+	Count int64         `json:"count"`
+	Total time.Duration `json:"total"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+}
+
+// add counts another duration
+func (l *UsageLatency) add(duration time.Duration) {
+	if l.Count == 0 || duration < l.Min {
+		l.Min = duration
+	}
+	if duration > l.Max {
+		l.Max = duration
+	}
+	l.Count++
+	l.Total += duration
+}
+
+// merge adds the durations of the other summary
+func (l *UsageLatency) merge(other *UsageLatency) {
+	if other == nil || other.Count == 0 {
+		return
+	}
+	if l.Count == 0 || other.Min < l.Min {
+		l.Min = other.Min
+	}
+	if other.Max > l.Max {
+		l.Max = other.Max
+	}
+	l.Count += other.Count
+	l.Total += other.Total
+}
+
+// HTTPUsageReportTransport posts the reports to the endpoint as JSON
+type HTTPUsageReportTransport struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+// SendUsageReport posts the report
+func (t *HTTPUsageReportTransport) SendUsageReport(ctx context.Context, report *UsageReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, t.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("usage report was not accepted: %s", response.Status)
+	}
+
+	return nil
+}
+
+// WithUsageReporting returns an Option that reports the operations the gateway executes to a registry
+func WithUsageReporting(options UsageReportingOptions) Option {
+	return func(g *Gateway) {
+		g.usageReporter = newUsageReporter(options)
+	}
+}
+
+// usageClientKey is the context key for the client that sent the request
+type usageClientKey struct{}
+
+// usageClient is the client that sent a request
+type usageClient struct {
+	name    string
+	version string
+}
+
+// usageContext returns the context to execute the request with, carrying the client that sent it
+func (g *Gateway) usageContext(ctx context.Context, r *http.Request) context.Context {
+	if g.usageReporter == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, usageClientKey{}, usageClient{
+		name:    r.Header.Get(g.usageReporter.options.ClientNameHeader),
+		version: r.Header.Get(g.usageReporter.options.ClientVersionHeader),
+	})
+}
+
+// usageReporter collects the counts of the operations and sends them every interval
+type usageReporter struct {
+	options UsageReportingOptions
+
+	mutex      sync.Mutex
+	operations map[string]*OperationUsage
+	dropped    int64
+	start      time.Time
+
+	stop     chan bool
+	stopOnce sync.Once
+	done     chan bool
+}
+
+// newUsageReporter returns a reporter that sends its reports in the background
+func newUsageReporter(options UsageReportingOptions) *usageReporter {
+	if options.Interval <= 0 {
+		options.Interval = defaultUsageReportingInterval
+	}
+	if options.MaxOperations <= 0 {
+		options.MaxOperations = defaultUsageReportingMaxSize
+	}
+	if options.ClientNameHeader == "" {
+		options.ClientNameHeader = DefaultClientNameHeader
+	}
+	if options.ClientVersionHeader == "" {
+		options.ClientVersionHeader = DefaultClientVersionHeader
+	}
+	if options.Transport == nil {
+		options.Transport = &HTTPUsageReportTransport{Endpoint: options.Endpoint}
+	}
+
+	reporter := &usageReporter{
+		options:    options,
+		operations: map[string]*OperationUsage{},
+		start:      time.Now(),
+		stop:       make(chan bool),
+		done:       make(chan bool),
+	}
+	go reporter.run()
+
+	return reporter
+}
+
+// run sends a report every interval until the reporter is stopped
+func (r *usageReporter) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.flush()
+		case <-r.stop:
+			// whatever was counted since the last report still has to go out
+			r.flush()
+			return
+		}
+	}
+}
+
+// Stop sends what's left and stops sending reports
+func (r *usageReporter) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+}
+
+// flush sends what was counted since the last report. If it can't be sent, it's kept for the next one.
+func (r *usageReporter) flush() {
+	report := r.take()
+	if len(report.Operations) == 0 && report.Dropped == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.options.Interval)
+	defer cancel()
+
+	if err := r.options.Transport.SendUsageReport(ctx, report); err != nil {
+		log.Warn("Could not send usage report: ", err)
+		r.restore(report)
+	}
+}
+
+// take empties the buffer and returns what was in it
+func (r *usageReporter) take() *UsageReport {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := &UsageReport{
+		Start:      r.start,
+		End:        time.Now(),
+		Operations: make([]*OperationUsage, 0, len(r.operations)),
+		Dropped:    r.dropped,
+	}
+	for _, operation := range r.operations {
+		report.Operations = append(report.Operations, operation)
+	}
+	sort.Slice(report.Operations, func(i, j int) bool {
+		return usageKey(report.Operations[i]) < usageKey(report.Operations[j])
+	})
+
+	r.operations = map[string]*OperationUsage{}
+	r.dropped = 0
+	r.start = report.End
+
+	return report
+}
+
+// restore puts a report that couldn't be sent back in the buffer, as long as there's room for it
+func (r *usageReporter) restore(report *UsageReport) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if report.Start.Before(r.start) {
+		r.start = report.Start
+	}
+	r.dropped += report.Dropped
+	for _, operation := range report.Operations {
+		r.add(operation)
+	}
+}
+
+// add merges the counts of the operation into the buffer. The mutex has to be held.
+func (r *usageReporter) add(operation *OperationUsage) {
+	key := usageKey(operation)
+
+	existing, ok := r.operations[key]
+	if !ok {
+		if len(r.operations) >= r.options.MaxOperations {
+			r.dropped += operation.Count
+			return
+		}
+		r.operations[key] = operation
+		return
+	}
+
+	existing.Count += operation.Count
+	existing.Errors += operation.Errors
+	existing.Latency.merge(operation.Latency)
+	for coordinate, latency := range operation.Fields {
+		if _, ok := existing.Fields[coordinate]; !ok {
+			existing.Fields[coordinate] = &UsageLatency{}
+		}
+		existing.Fields[coordinate].merge(latency)
+	}
+}
+
+// record counts an operation that was executed
+func (r *usageReporter) record(ctx *RequestContext, plan *QueryPlan, stats *statsRecorder, duration time.Duration, err error) {
+	if plan == nil || plan.Operation == nil {
+		return
+	}
+
+	signature := usageSignature(ctx.Query, plan)
+	sum := sha256.Sum256([]byte(signature))
+	hash := hex.EncodeToString(sum[:])
+	if r.options.Redact != nil {
+		signature = r.options.Redact(signature)
+	}
+
+	var client usageClient
+	if ctx.Context != nil {
+		client, _ = ctx.Context.Value(usageClientKey{}).(usageClient)
+	}
+
+	// the fields are timed by the requests that resolved them
+	fields := map[string]*UsageLatency{}
+	for step, latency := range stats.stepLatencies() {
+		for _, coordinate := range usageStepCoordinates(step) {
+			if _, ok := fields[coordinate]; !ok {
+				fields[coordinate] = &UsageLatency{}
+			}
+			fields[coordinate].merge(latency)
+		}
+	}
+
+	errors := int64(0)
+	if err != nil {
+		errors = 1
+	}
+
+	usage := &OperationUsage{
+		Signature:     signature,
+		Hash:          hash,
+		OperationName: plan.Operation.Name,
+		ClientName:    client.name,
+		ClientVersion: client.version,
+		Count:         1,
+		Errors:        errors,
+		Latency:       &UsageLatency{},
+		Fields:        fields,
+	}
+	usage.Latency.add(duration)
+
+	r.mutex.Lock()
+	r.add(usage)
+	r.mutex.Unlock()
+}
+
+// usageKey returns the key that the counts of the operation are kept under
+func usageKey(operation *OperationUsage) string {
+	return strings.Join([]string{operation.Hash, operation.OperationName, operation.ClientName, operation.ClientVersion}, "\x00")
+}
+
+// usageStepCoordinates returns the coordinates of the fields that a step resolves
+func usageStepCoordinates(step *QueryPlanStep) []string {
+	coordinates := []string{}
+
+	var visit func(parentType string, selectionSet ast.SelectionSet)
+	visit = func(parentType string, selectionSet ast.SelectionSet) {
+		for _, field := range executorSelectedFields(selectionSet, step.FragmentDefinitions) {
+			if strings.HasPrefix(field.Name, "__") {
+				continue
+			}
+
+			owner := parentType
+			if field.ObjectDefinition != nil {
+				owner = field.ObjectDefinition.Name
+			}
+			coordinates = append(coordinates, owner+"."+field.Name)
+
+			if field.Definition != nil {
+				visit(field.Definition.Type.Name(), field.SelectionSet)
+			}
+		}
+	}
+	visit(step.ParentType, step.SelectionSet)
+
+	return coordinates
+}
+
+// usageSignature returns the normalized version of the operation that was executed
+func usageSignature(query string, plan *QueryPlan) string {
+	document := &ast.QueryDocument{
+		Operations: ast.OperationList{plan.Operation},
+		Fragments:  plan.FragmentDefinitions,
+	}
+
+	// the query the client sent is a better place to start than the one the planner changed
+	if query != "" {
+		if parsed, err := parser.ParseQuery(&ast.Source{Input: query}); err == nil {
+			if operation := usageOperation(parsed, plan.Operation.Name); operation != nil {
+				document = &ast.QueryDocument{Operations: ast.OperationList{operation}, Fragments: parsed.Fragments}
+			}
+		}
+	}
+
+	normalized := usageNormalize(document)
+	printed, err := plannerPrintQuery(normalized)
+	if err != nil {
+		return ""
+	}
+
+	return strings.Join(strings.Fields(printed), " ")
+}
+
+// usageOperation returns the operation of the document with the designated name
+func usageOperation(document *ast.QueryDocument, name string) *ast.OperationDefinition {
+	if len(document.Operations) == 1 {
+		return document.Operations[0]
+	}
+
+	return document.Operations.ForName(name)
+}
+
+// usageNormalize returns a copy of the operation in the document (and the fragments it uses) with the literals
+// replaced, the aliases removed, and everything in order
+func usageNormalize(document *ast.QueryDocument) *ast.QueryDocument {
+	operation := document.Operations[0]
+	used := Set{}
+
+	var selections func(selectionSet ast.SelectionSet) ast.SelectionSet
+	selections = func(selectionSet ast.SelectionSet) ast.SelectionSet {
+		result := ast.SelectionSet{}
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				result = append(result, &ast.Field{
+					Name:         selection.Name,
+					Alias:        selection.Name,
+					Arguments:    usageArguments(selection.Arguments),
+					Directives:   usageDirectives(selection.Directives),
+					SelectionSet: selections(selection.SelectionSet),
+				})
+			case *ast.InlineFragment:
+				result = append(result, &ast.InlineFragment{
+					TypeCondition: selection.TypeCondition,
+					Directives:    usageDirectives(selection.Directives),
+					SelectionSet:  selections(selection.SelectionSet),
+				})
+			case *ast.FragmentSpread:
+				used.Add(selection.Name)
+				result = append(result, &ast.FragmentSpread{
+					Name:       selection.Name,
+					Directives: usageDirectives(selection.Directives),
+				})
+			}
+		}
+
+		sort.SliceStable(result, func(i, j int) bool {
+			return usageSelectionKey(result[i]) < usageSelectionKey(result[j])
+		})
+		return result
+	}
+
+	normalized := &ast.OperationDefinition{
+		Operation:  operation.Operation,
+		Name:       operation.Name,
+		Directives: usageDirectives(operation.Directives),
+	}
+	normalized.SelectionSet = selections(operation.SelectionSet)
+	for _, variable := range operation.VariableDefinitions {
+		normalized.VariableDefinitions = append(normalized.VariableDefinitions, &ast.VariableDefinition{
+			Variable:     variable.Variable,
+			Type:         variable.Type,
+			DefaultValue: usageValue(variable.DefaultValue),
+		})
+	}
+	sort.SliceStable(normalized.VariableDefinitions, func(i, j int) bool {
+		return normalized.VariableDefinitions[i].Variable < normalized.VariableDefinitions[j].Variable
+	})
+
+	// the fragments can use other fragments
+	fragments := ast.FragmentDefinitionList{}
+	for added := true; added; {
+		added = false
+		for _, fragment := range document.Fragments {
+			if !used.Has(fragment.Name) || fragments.ForName(fragment.Name) != nil {
+				continue
+			}
+			fragments = append(fragments, &ast.FragmentDefinition{
+				Name:          fragment.Name,
+				TypeCondition: fragment.TypeCondition,
+				Directives:    usageDirectives(fragment.Directives),
+				SelectionSet:  selections(fragment.SelectionSet),
+			})
+			added = true
+		}
+	}
+	sort.Slice(fragments, func(i, j int) bool {
+		return fragments[i].Name < fragments[j].Name
+	})
+
+	return &ast.QueryDocument{Operations: ast.OperationList{normalized}, Fragments: fragments}
+}
+
+// usageSelectionKey returns the key that a selection is sorted by
+func usageSelectionKey(selection ast.Selection) string {
+	switch selection := selection.(type) {
+	case *ast.Field:
+		return "0" + selection.Name
+	case *ast.FragmentSpread:
+		return "1" + selection.Name
+	case *ast.InlineFragment:
+		return "2" + selection.TypeCondition
+	}
+
+	return ""
+}
+
+// usageArguments returns the arguments in order, with their literals replaced
+func usageArguments(arguments ast.ArgumentList) ast.ArgumentList {
+	result := ast.ArgumentList{}
+	for _, argument := range arguments {
+		result = append(result, &ast.Argument{Name: argument.Name, Value: usageValue(argument.Value)})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// usageDirectives returns the directives in order, with the literals of their arguments replaced
+func usageDirectives(directives ast.DirectiveList) ast.DirectiveList {
+	result := ast.DirectiveList{}
+	for _, directive := range directives {
+		result = append(result, &ast.Directive{Name: directive.Name, Arguments: usageArguments(directive.Arguments)})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// usageValue returns the value with its literals replaced by zero values. Booleans, enums, nulls, and
+// variables don't say anything about the client's data so they are left alone.
+func usageValue(value *ast.Value) *ast.Value {
+	if value == nil {
+		return nil
+	}
+
+	switch value.Kind {
+	case ast.IntValue, ast.FloatValue:
+		return &ast.Value{Kind: ast.IntValue, Raw: "0"}
+	case ast.StringValue, ast.BlockValue:
+		return &ast.Value{Kind: ast.StringValue, Raw: ""}
+	case ast.ListValue:
+		return &ast.Value{Kind: ast.ListValue}
+	case ast.ObjectValue:
+		return &ast.Value{Kind: ast.ObjectValue}
+	}
+
+	return &ast.Value{Kind: value.Kind, Raw: value.Raw}
+}
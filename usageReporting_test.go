@@ -0,0 +1,231 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestUsageSignature(t *testing.T) {
+	plan := &QueryPlan{Operation: &ast.OperationDefinition{Operation: ast.Query, Name: "Users"}}
+
+	// the same operation with different literals, aliases, order, and formatting
+	signature := usageSignature(`query Users { users(first: 10, after: "abc") { name id } }`, plan)
+	for _, query := range []string{
+		`query Users { users(after: "xyz", first: 20) { id name } }`,
+		`query Users {
+			users(
+				first: 5
+				after: """block"""
+			) {
+				id
+				displayName: name
+			}
+		}`,
+		`query Users{users(first:1.5,after:""){name,id}}`,
+	} {
+		assert.Equal(t, signature, usageSignature(query, plan), query)
+	}
+	assert.Equal(t, `query Users { users(after: "", first: 0) { id name } }`, signature)
+
+	// but a different selection is a different operation
+	assert.NotEqual(t, signature, usageSignature(`query Users { users(first: 10) { id } }`, plan))
+
+	// variables, enums, and booleans are kept, lists and objects are emptied
+	assert.Equal(t,
+		`query Users ($a: String = "", $b: Int) { users(filter: {}, ids: [], order: ASC, show: true, where: $a) { id } }`,
+		usageSignature(`query Users($b: Int, $a: String = "hello") {
+			users(where: $a, order: ASC, show: true, ids: ["1", "2"], filter: {name: "bob"}) { id }
+		}`, plan),
+	)
+
+	// only the fragments the operation uses are kept, in order
+	assert.Equal(t,
+		`query Users { users { ... A ... B } } fragment A on User { id } fragment B on User { name }`,
+		usageSignature(`
+			fragment Unused on User { id }
+			fragment B on User { name }
+			query Users { users { ...B ...A } }
+			fragment A on User { id }
+		`, plan),
+	)
+
+	// the operation is picked out of a document with more than one
+	assert.Equal(t,
+		`query Users { users { id } }`,
+		usageSignature(`query Other { users { name } } query Users { users { id } }`, plan),
+	)
+}
+
+func TestGateway_usageReporting(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String!
+		}
+
+		type Query {
+			users(first: Int): [User!]!
+		}
+	`)
+
+	reports := []*UsageReport{}
+	reportsMutex := &sync.Mutex{}
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{"users": []interface{}{map[string]interface{}{"id": "1", "name": "alice"}}}, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}},
+		WithQueryerFactory(&factory),
+		WithUsageReporting(UsageReportingOptions{
+			// the report is sent when the gateway shuts down
+			Interval: time.Hour,
+			Redact: func(signature string) string {
+				return strings.Replace(signature, "name", "<redacted>", -1)
+			},
+			Transport: UsageReportTransportFunc(func(ctx context.Context, report *UsageReport) error {
+				reportsMutex.Lock()
+				defer reportsMutex.Unlock()
+				reports = append(reports, report)
+				return nil
+			}),
+		}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, query := range []string{
+		`{"query": "query Users { users(first: 1) { id name } }"}`,
+		`{"query": "query Users { users(first: 2) { name id } }"}`,
+	} {
+		request := httptest.NewRequest("POST", "/graphql", strings.NewReader(query))
+		request.Header.Set(DefaultClientNameHeader, "web")
+		request.Header.Set(DefaultClientVersionHeader, "1.2.3")
+		response := httptest.NewRecorder()
+		gateway.GraphQLHandler(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+	}
+
+	// a client that didn't say who it is
+	request := httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query": "query Users { users { id } }"}`))
+	gateway.GraphQLHandler(httptest.NewRecorder(), request)
+
+	assert.Nil(t, gateway.Shutdown(context.Background()))
+
+	reportsMutex.Lock()
+	defer reportsMutex.Unlock()
+	if !assert.Len(t, reports, 1) || !assert.Len(t, reports[0].Operations, 2) {
+		return
+	}
+
+	var web *OperationUsage
+	for _, operation := range reports[0].Operations {
+		if operation.ClientName == "web" {
+			web = operation
+		}
+	}
+	if !assert.NotNil(t, web) {
+		return
+	}
+
+	assert.Equal(t, "Users", web.OperationName)
+	assert.Equal(t, "1.2.3", web.ClientVersion)
+	assert.Equal(t, `query Users { users(first: 0) { id <redacted> } }`, web.Signature)
+	assert.Len(t, web.Hash, 64)
+	assert.Equal(t, int64(2), web.Count)
+	assert.Equal(t, int64(0), web.Errors)
+	assert.Equal(t, int64(2), web.Latency.Count)
+	for _, coordinate := range []string{"Query.users", "User.id", "User.name"} {
+		if assert.NotNil(t, web.Fields[coordinate], coordinate) {
+			assert.Equal(t, int64(2), web.Fields[coordinate].Count, coordinate)
+		}
+	}
+}
+
+func TestUsageReporter_outage(t *testing.T) {
+	// the registry is down until we say otherwise
+	available := false
+	reports := []*UsageReport{}
+	mutex := &sync.Mutex{}
+
+	reporter := newUsageReporter(UsageReportingOptions{
+		Interval:      time.Hour,
+		MaxOperations: 5,
+		Transport: UsageReportTransportFunc(func(ctx context.Context, report *UsageReport) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if !available {
+				return errors.New("registry is down")
+			}
+			reports = append(reports, report)
+			return nil
+		}),
+	})
+	defer reporter.Stop()
+
+	// a lot of different operations come in while the reports fail
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 20; i++ {
+			name := string(rune('a' + i))
+			reporter.record(&RequestContext{Query: "query " + name + " { " + name + " }"}, &QueryPlan{
+				Operation: &ast.OperationDefinition{Operation: ast.Query, Name: name},
+			}, nil, time.Millisecond, nil)
+		}
+		reporter.flush()
+
+		// the buffer never grows past its limit
+		reporter.mutex.Lock()
+		assert.True(t, len(reporter.operations) <= 5)
+		reporter.mutex.Unlock()
+	}
+
+	// once the registry is back, it gets what was kept along with the number that was dropped
+	mutex.Lock()
+	available = true
+	mutex.Unlock()
+	reporter.flush()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !assert.Len(t, reports, 1) {
+		return
+	}
+	assert.Len(t, reports[0].Operations, 5)
+	total := int64(0)
+	for _, operation := range reports[0].Operations {
+		assert.Equal(t, int64(10), operation.Count)
+		total += operation.Count
+	}
+	assert.Equal(t, int64(200), total+reports[0].Dropped)
+}
+
+func TestHTTPUsageReportTransport(t *testing.T) {
+	status := http.StatusOK
+	received := ""
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("Content-Type")
+		w.WriteHeader(status)
+	}))
+	defer registry.Close()
+
+	transport := &HTTPUsageReportTransport{Endpoint: registry.URL}
+	assert.Nil(t, transport.SendUsageReport(context.Background(), &UsageReport{}))
+	assert.Equal(t, "application/json", received)
+
+	// the registry has to accept the report
+	status = http.StatusServiceUnavailable
+	assert.NotNil(t, transport.SendUsageReport(context.Background(), &UsageReport{}))
+}
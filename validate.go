@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// ValidationReport describes what would happen if a query was sent to the gateway
+type ValidationReport struct {
+	// true if the query can be executed
+	Valid bool `json:"valid"`
+	// false if the query could not be parsed
+	SyntaxValid bool `json:"syntaxValid"`
+	// the reasons the query can't be executed
+	Errors gqlerror.List `json:"errors"`
+	// the url of every service that the query would be sent to
+	Services []string `json:"services"`
+	// the number of steps in the plans for the query
+	Steps int `json:"steps"`
+	// the deprecated fields that the query uses
+	DeprecatedFields []DeprecatedField `json:"deprecatedFields"`
+}
+
+// DeprecatedField is a deprecated field that is used by a query
+type DeprecatedField struct {
+	Type      string              `json:"type"`
+	Field     string              `json:"field"`
+	Reason    string              `json:"reason"`
+	Locations []gqlerror.Location `json:"locations"`
+}
+
+// Validate checks the query against the gateway's schema and plans it without sending anything to the
+// services. Problems with the query are described in the report, the error is only for things that went
+// wrong inside of the gateway.
+func (g *Gateway) Validate(ctx context.Context, query string) (*ValidationReport, error) {
+	report := &ValidationReport{
+		Errors:           gqlerror.List{},
+		Services:         []string{},
+		DeprecatedFields: []DeprecatedField{},
+	}
+
+	planningContext := g.planningContext(query)
+
+	// make sure the query is a valid document
+	document, parseErr := parser.ParseQuery(&ast.Source{Input: query})
+	if parseErr != nil {
+		report.Errors = append(report.Errors, parseErr)
+		return report, nil
+	}
+	report.SyntaxValid = true
+
+	// and that it makes sense for our schema
+	if errs := validateQueryDocument(planningContext.Schema, document); len(errs) > 0 {
+		report.Errors = append(report.Errors, errs...)
+		return report, nil
+	}
+	report.DeprecatedFields = validateDeprecatedFields(document)
+
+	// the plans tell us where the query would go
+	plans, err := g.planner.Plan(planningContext)
+	if err != nil {
+		report.Errors = append(report.Errors, gqlerror.Errorf("%s", err.Error()))
+		return report, nil
+	}
+
+	services := Set{}
+	for _, plan := range plans {
+		cost := queryCost(plan)
+		report.Steps += cost.Steps
+		for url := range cost.ServiceRequests {
+			services.Add(url)
+		}
+	}
+	for url := range services {
+		report.Services = append(report.Services, url)
+	}
+	sort.Strings(report.Services)
+
+	report.Valid = true
+	return report, nil
+}
+
+// ValidateHandler is a http.HandlerFunc that responds with the ValidationReport for the query in the body of
+// the request. The body has the same shape as a request to GraphQLHandler. Nothing is sent to the services.
+func (g *Gateway) ValidateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "validation requests must be sent with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	operation := &HTTPOperation{}
+	if err := json.NewDecoder(r.Body).Decode(operation); err != nil {
+		http.Error(w, "could not read the body of the request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := g.Validate(r.Context(), operation.Query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// validateDeprecatedFields returns the deprecated fields that are used in the validated document
+func validateDeprecatedFields(document *ast.QueryDocument) []DeprecatedField {
+	// the same field can show up in many places of the query
+	fields := map[string]*DeprecatedField{}
+	order := []string{}
+
+	var visit func(selectionSet ast.SelectionSet)
+	visit = func(selectionSet ast.SelectionSet) {
+		for _, selection := range selectionSet {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				if selection.Definition != nil && selection.ObjectDefinition != nil {
+					if deprecated := selection.Definition.Directives.ForName("deprecated"); deprecated != nil {
+						key := selection.ObjectDefinition.Name + "." + selection.Name
+						if _, ok := fields[key]; !ok {
+							reason := "No longer supported"
+							if argument := deprecated.Arguments.ForName("reason"); argument != nil {
+								reason = argument.Value.Raw
+							}
+							fields[key] = &DeprecatedField{
+								Type:      selection.ObjectDefinition.Name,
+								Field:     selection.Name,
+								Reason:    reason,
+								Locations: []gqlerror.Location{},
+							}
+							order = append(order, key)
+						}
+
+						if selection.Position != nil {
+							fields[key].Locations = append(fields[key].Locations, gqlerror.Location{
+								Line:   selection.Position.Line,
+								Column: selection.Position.Column,
+							})
+						}
+					}
+				}
+				visit(selection.SelectionSet)
+			case *ast.InlineFragment:
+				visit(selection.SelectionSet)
+			}
+		}
+	}
+
+	for _, operation := range document.Operations {
+		visit(operation.SelectionSet)
+	}
+	for _, fragment := range document.Fragments {
+		visit(fragment.SelectionSet)
+	}
+
+	result := []DeprecatedField{}
+	for _, key := range order {
+		result = append(result, *fields[key])
+	}
+
+	return result
+}
@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_validate(t *testing.T) {
+	usersSchema, _ := graphql.LoadSchema(`
+		type User {
+			id: ID!
+			name: String!
+			username: String! @deprecated(reason: "use name")
+		}
+
+		type Query {
+			users: [User!]!
+		}
+	`)
+	photosSchema, _ := graphql.LoadSchema(`
+		type Query {
+			photos: [String!]!
+		}
+	`)
+
+	// nothing can be sent to the services
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			t.Error("validation sent a query to ", url)
+			return nil, nil
+		})
+	})
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{Schema: usersSchema, URL: "users"},
+		{Schema: photosSchema, URL: "photos"},
+	}, WithQueryerFactory(&factory))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	table := []struct {
+		Name        string
+		Query       string
+		Valid       bool
+		SyntaxValid bool
+		Services    []string
+		Steps       int
+		Deprecated  []string
+	}{
+		{"Valid", `{ users { name } photos }`, true, true, []string{"photos", "users"}, 2, []string{}},
+		{"Deprecated", `{ users { username } }`, true, true, []string{"users"}, 1, []string{"User.username"}},
+		{"Syntax error", `{ users { name }`, false, false, []string{}, 0, []string{}},
+		{"Unknown field", `{ users { age } }`, false, true, []string{}, 0, []string{}},
+	}
+
+	for _, row := range table {
+		t.Run(row.Name, func(t *testing.T) {
+			report, err := gateway.Validate(context.Background(), row.Query)
+			if !assert.Nil(t, err) {
+				return
+			}
+
+			assert.Equal(t, row.Valid, report.Valid)
+			assert.Equal(t, row.SyntaxValid, report.SyntaxValid)
+			assert.Equal(t, row.Valid, len(report.Errors) == 0)
+			assert.Equal(t, row.Services, report.Services)
+			assert.Equal(t, row.Steps, report.Steps)
+
+			deprecated := []string{}
+			for _, field := range report.DeprecatedFields {
+				deprecated = append(deprecated, field.Type+"."+field.Field)
+			}
+			assert.Equal(t, row.Deprecated, deprecated)
+		})
+	}
+
+	// the same report is available over http
+	response := httptest.NewRecorder()
+	gateway.ValidateHandler(response, httptest.NewRequest("POST", "/validate", strings.NewReader(`{"query": "{ users { username } }"}`)))
+
+	report := map[string]interface{}{}
+	if !assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &report)) {
+		return
+	}
+	assert.Equal(t, true, report["valid"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"type":      "User",
+			"field":     "username",
+			"reason":    "use name",
+			"locations": []interface{}{map[string]interface{}{"line": 1.0, "column": 11.0}},
+		},
+	}, report["deprecatedFields"])
+}
@@ -0,0 +1,269 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// The variables of a step are declared the same way the client declared them. That's fine as long as every place
+// the step uses a variable accepts the client's type, but the planner can put a variable somewhere the client
+// didn't (ie, when an argument is propagated to a field that gives it a different type) and the service would
+// reject the query. When a step uses a variable in a position the client's definition doesn't fit, the variable
+// is split into one variable per type it is used as ($filter_1, $filter_2, ...). Each of them is declared with the
+// type of its position and the executor gives all of them the value the client sent for the original.
+
+// plannerVariableRenames holds the variables of a step that had to be split
+type plannerVariableRenames struct {
+	// the definitions of the new variables
+	definitions ast.VariableDefinitionList
+	// the name of the client's variable for each new one
+	sources map[string]string
+}
+
+// plannerRenameConflictingVariables returns the selection set and fragments of a step with the variables that
+// don't fit where they are used replaced by new ones
+func plannerRenameConflictingVariables(
+	schema *ast.Schema,
+	parentType string,
+	definitions ast.VariableDefinitionList,
+	selectionSet ast.SelectionSet,
+	fragments ast.FragmentDefinitionList,
+) (ast.SelectionSet, ast.FragmentDefinitionList, *plannerVariableRenames) {
+	// look for the types each variable is used as
+	usages := &variableUsages{schema: schema, types: map[string][]*ast.Type{}}
+	usages.walk(parentType, selectionSet, fragments)
+
+	renames := &plannerVariableRenames{sources: map[string]string{}}
+	names := map[string]map[string]string{}
+	taken := Set{}
+	for _, definition := range definitions {
+		taken.Add(definition.Variable)
+	}
+
+	for _, definition := range definitions {
+		types := usages.types[definition.Variable]
+
+		conflict := false
+		for _, usage := range types {
+			if !plannerVariableAllowed(definition, usage) {
+				conflict = true
+				break
+			}
+		}
+		if !conflict {
+			continue
+		}
+
+		// every type the variable is used as gets its own variable
+		names[definition.Variable] = map[string]string{}
+		suffix := 1
+		for _, usage := range types {
+			if _, ok := names[definition.Variable][usage.String()]; ok {
+				continue
+			}
+
+			name := fmt.Sprintf("%s_%d", definition.Variable, suffix)
+			for taken.Has(name) {
+				suffix++
+				name = fmt.Sprintf("%s_%d", definition.Variable, suffix)
+			}
+			suffix++
+			taken.Add(name)
+
+			names[definition.Variable][usage.String()] = name
+			renames.sources[name] = definition.Variable
+			renames.definitions = append(renames.definitions, &ast.VariableDefinition{
+				Variable:     name,
+				Type:         usage,
+				DefaultValue: definition.DefaultValue,
+			})
+		}
+	}
+
+	// nothing has to change
+	if len(names) == 0 {
+		return selectionSet, fragments, renames
+	}
+
+	rewrite := &variableUsages{schema: schema, types: map[string][]*ast.Type{}, renames: names}
+	selectionSet, fragments = rewrite.walk(parentType, selectionSet, fragments)
+
+	return selectionSet, fragments, renames
+}
+
+// plannerVariableAllowed returns true if the variable can be passed where a value of the type is expected
+func plannerVariableAllowed(definition *ast.VariableDefinition, expected *ast.Type) bool {
+	// a default value takes the place of a missing value
+	if expected.NonNull && !definition.Type.NonNull && definition.DefaultValue != nil {
+		nullable := *expected
+		nullable.NonNull = false
+		expected = &nullable
+	}
+
+	return plannerTypeCovers(definition.Type, expected)
+}
+
+// plannerTypeCovers returns true if a value of the first type is always a valid value of the second one
+func plannerTypeCovers(declared *ast.Type, expected *ast.Type) bool {
+	if expected.NonNull && !declared.NonNull {
+		return false
+	}
+	if (declared.Elem == nil) != (expected.Elem == nil) {
+		return false
+	}
+	if declared.Elem != nil {
+		return plannerTypeCovers(declared.Elem, expected.Elem)
+	}
+
+	return declared.NamedType == expected.NamedType
+}
+
+// variableUsages walks the selections of a step and records the type of every place a variable is used. If it
+// was given new names for the variables, it returns a copy of the selections that uses them.
+type variableUsages struct {
+	schema *ast.Schema
+	// the types each variable is used as, in the order they were found
+	types map[string][]*ast.Type
+	// the new name of a variable, indexed by the variable and the type it is used as
+	renames map[string]map[string]string
+}
+
+func (u *variableUsages) walk(parentType string, selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) (ast.SelectionSet, ast.FragmentDefinitionList) {
+	selectionSet = u.selectionSet(u.schema.Types[parentType], selectionSet)
+
+	copied := ast.FragmentDefinitionList{}
+	for _, fragment := range fragments {
+		definition := *fragment
+		definition.Directives = u.directives(fragment.Directives)
+		definition.SelectionSet = u.selectionSet(u.schema.Types[fragment.TypeCondition], fragment.SelectionSet)
+		copied = append(copied, &definition)
+	}
+
+	return selectionSet, copied
+}
+
+func (u *variableUsages) selectionSet(parent *ast.Definition, selectionSet ast.SelectionSet) ast.SelectionSet {
+	if selectionSet == nil {
+		return nil
+	}
+
+	result := ast.SelectionSet{}
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			field := *selection
+			field.Directives = u.directives(selection.Directives)
+
+			var definition *ast.FieldDefinition
+			if parent != nil {
+				definition = parent.Fields.ForName(selection.Name)
+			}
+			if definition != nil {
+				field.Arguments = u.arguments(definition.Arguments, selection.Arguments)
+				field.SelectionSet = u.selectionSet(u.schema.Types[definition.Type.Name()], selection.SelectionSet)
+			} else {
+				field.SelectionSet = u.selectionSet(nil, selection.SelectionSet)
+			}
+			result = append(result, &field)
+
+		case *ast.InlineFragment:
+			fragment := *selection
+			fragment.Directives = u.directives(selection.Directives)
+			fragmentParent := parent
+			if selection.TypeCondition != "" {
+				fragmentParent = u.schema.Types[selection.TypeCondition]
+			}
+			fragment.SelectionSet = u.selectionSet(fragmentParent, selection.SelectionSet)
+			result = append(result, &fragment)
+
+		case *ast.FragmentSpread:
+			spread := *selection
+			spread.Directives = u.directives(selection.Directives)
+			result = append(result, &spread)
+
+		default:
+			result = append(result, selection)
+		}
+	}
+
+	return result
+}
+
+func (u *variableUsages) directives(directives ast.DirectiveList) ast.DirectiveList {
+	if directives == nil {
+		return nil
+	}
+
+	result := ast.DirectiveList{}
+	for _, directive := range directives {
+		copied := *directive
+		if definition := u.schema.Directives[directive.Name]; definition != nil {
+			copied.Arguments = u.arguments(definition.Arguments, directive.Arguments)
+		}
+		result = append(result, &copied)
+	}
+
+	return result
+}
+
+func (u *variableUsages) arguments(definitions ast.ArgumentDefinitionList, arguments ast.ArgumentList) ast.ArgumentList {
+	if arguments == nil {
+		return nil
+	}
+
+	result := ast.ArgumentList{}
+	for _, argument := range arguments {
+		copied := *argument
+		if definition := definitions.ForName(argument.Name); definition != nil {
+			copied.Value = u.value(argument.Value, definition.Type)
+		}
+		result = append(result, &copied)
+	}
+
+	return result
+}
+
+// value records the variables in the value, which is used where a value of the type is expected
+func (u *variableUsages) value(value *ast.Value, expected *ast.Type) *ast.Value {
+	if value == nil || expected == nil {
+		return value
+	}
+
+	switch value.Kind {
+	case ast.Variable:
+		u.types[value.Raw] = append(u.types[value.Raw], expected)
+		if name, ok := u.renames[value.Raw][expected.String()]; ok {
+			copied := *value
+			copied.Raw = name
+			return &copied
+		}
+
+	case ast.ListValue:
+		copied := *value
+		copied.Children = ast.ChildValueList{}
+		for _, child := range value.Children {
+			entry := *child
+			entry.Value = u.value(child.Value, expected.Elem)
+			copied.Children = append(copied.Children, &entry)
+		}
+		return &copied
+
+	case ast.ObjectValue:
+		definition := u.schema.Types[expected.Name()]
+		copied := *value
+		copied.Children = ast.ChildValueList{}
+		for _, child := range value.Children {
+			entry := *child
+			if definition != nil {
+				if field := definition.Fields.ForName(child.Name); field != nil {
+					entry.Value = u.value(child.Value, field.Type)
+				}
+			}
+			copied.Children = append(copied.Children, &entry)
+		}
+		return &copied
+	}
+
+	return value
+}
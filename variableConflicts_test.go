@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+func TestGateway_conflictingVariables(t *testing.T) {
+	// the count takes the status as a string while the list takes an enum
+	serviceSchema := `
+		enum OrderStatus {
+			PENDING
+			SHIPPED
+		}
+
+		type Order {
+			id: ID!
+		}
+
+		type User {
+			orders(status: OrderStatus): [Order!]!
+			orderCount(status: String): Int!
+		}
+
+		type Query {
+			me: User
+		}
+	`
+	schema, _ := graphql.LoadSchema(serviceSchema)
+	validationSchema, _ := graphql.LoadSchema(serviceSchema)
+
+	// the service rejects any query that isn't valid, just like a real one would
+	var received *graphql.QueryInput
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			received = input
+			if _, err := gqlparser.LoadQuery(validationSchema, input.Query); err != nil {
+				return nil, err
+			}
+
+			return map[string]interface{}{
+				"me": map[string]interface{}{
+					"orders":     []interface{}{map[string]interface{}{"id": "1"}},
+					"orderCount": 1,
+				},
+			}, nil
+		})
+	})
+
+	// the status the client passes to the list is copied to the count
+	gateway, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "url1"}},
+		WithQueryerFactory(&factory),
+		WithArgumentPropagation(ArgumentPropagation{Type: "User", Fields: []string{"orders", "orderCount"}, Arguments: []string{"status"}}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	requestContext := &RequestContext{
+		Context: context.Background(),
+		Query: `
+			query($status: OrderStatus) {
+				me {
+					orders(status: $status) { id }
+					orderCount
+				}
+			}
+		`,
+		Variables: map[string]interface{}{"status": "SHIPPED"},
+	}
+	plans, err := gateway.GetPlans(requestContext)
+	if !assert.Nil(t, err) {
+		return
+	}
+	result, err := gateway.Execute(requestContext, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{
+		"me": map[string]interface{}{
+			"orders":     []interface{}{map[string]interface{}{"id": "1"}},
+			"orderCount": 1,
+		},
+	}, result)
+
+	// each variable got the client's value
+	assert.Equal(t, map[string]interface{}{"status_1": "SHIPPED", "status_2": "SHIPPED"}, received.Variables)
+}
+
+func TestPlannerRenameConflictingVariables(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		input Filter {
+			status: String
+			tags: [String!]
+		}
+
+		type Query {
+			byEnum(status: String): Int
+			byFilter(filter: Filter): Int
+			required(status: String!): Int
+		}
+	`)
+
+	// rename returns the document the variables are renamed in along with the renames
+	rename := func(query string) (*ast.QueryDocument, *plannerVariableRenames) {
+		// the planner can produce queries that the client couldn't have sent so they aren't validated
+		document, err := parser.ParseQuery(&ast.Source{Input: query})
+		if err != nil {
+			t.Fatal(err)
+		}
+		operation := document.Operations[0]
+
+		selectionSet, fragments, renames := plannerRenameConflictingVariables(schema, "Query", operation.VariableDefinitions, operation.SelectionSet, document.Fragments)
+		return &ast.QueryDocument{
+			Operations: ast.OperationList{{Operation: ast.Query, SelectionSet: selectionSet}},
+			Fragments:  fragments,
+		}, renames
+	}
+
+	// variables that fit everywhere they are used are left alone
+	_, renames := rename(`query($status: String!) { byEnum(status: $status) required(status: $status) byFilter(filter: { status: $status }) }`)
+	assert.Empty(t, renames.sources)
+
+	// so are the ones whose default value fills in for a missing value
+	_, renames = rename(`query($status: String = "a") { required(status: $status) }`)
+	assert.Empty(t, renames.sources)
+
+	// a variable that doesn't fit is split up, even when it's nested in an input object
+	document, renames := rename(`query($status: String) { byEnum(status: $status) byFilter(filter: { status: $status }) ...Required } fragment Required on Query { required(status: $status) }`)
+	assert.Equal(t, map[string]string{"status_1": "status", "status_2": "status"}, renames.sources)
+	if assert.Len(t, renames.definitions, 2) {
+		assert.Equal(t, "String", renames.definitions[0].Type.String())
+		assert.Equal(t, "String!", renames.definitions[1].Type.String())
+	}
+
+	query, err := plannerPrintQuery(document)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Contains(t, query, "byEnum(status: $status_1)")
+	assert.Contains(t, query, "{status:$status_1}")
+	assert.Contains(t, query, "required(status: $status_2)")
+	assert.NotContains(t, query, "$status)")
+}
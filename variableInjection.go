@@ -0,0 +1,192 @@
+package gateway
+
+import (
+	"context"
+	"sort"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Some services expect request-scoped information (the tenant, the locale, etc) as an argument instead of a header.
+// A variable injector provides those values while the plan is executed. Each value is sent as a variable of the
+// step's query and bound to the arguments of the same name, but only for the fields whose definition in the
+// service's schema declares that argument. Services that don't know about the argument never see the variable.
+//
+// The injected values take precedence over whatever the client sent for the same argument. The arguments are
+// still part of the gateway's schema so the services should declare them as nullable, otherwise the clients
+// would have to provide them.
+
+// StepInfo describes the step of the plan that a query is about to be sent for
+type StepInfo struct {
+	// the url of the service
+	Location string
+	// the type of the object the step resolves fields on
+	ParentType string
+	// the path of the object in the response
+	InsertionPoint []string
+	// the name of the client's operation
+	OperationName string
+}
+
+// VariableInjector returns the variables to add to the query sent for the step
+type VariableInjector func(ctx context.Context, step StepInfo) map[string]interface{}
+
+// WithVariableInjector returns an Option that adds the variables returned by the injector to the queries
+// sent to the services that accept them
+func WithVariableInjector(injector VariableInjector) Option {
+	return func(g *Gateway) {
+		g.variableInjector = injector
+	}
+}
+
+// injectVariables returns a version of the input where the injected variables are bound to the arguments of
+// the same name, as long as the fields declare them in the service's schema
+func injectVariables(schema *ast.Schema, input *graphql.QueryInput, injected map[string]interface{}) (*graphql.QueryInput, error) {
+	if schema == nil || input.QueryDocument == nil || len(injected) == 0 {
+		return input, nil
+	}
+
+	injector := &variableInjector{
+		schema:      schema,
+		values:      injected,
+		definitions: map[string]*ast.Type{},
+	}
+
+	document := &ast.QueryDocument{}
+	for _, fragment := range input.QueryDocument.Fragments {
+		copied := *fragment
+		copied.SelectionSet = injector.selectionSet(schema.Types[fragment.TypeCondition], fragment.SelectionSet)
+		document.Fragments = append(document.Fragments, &copied)
+	}
+	for _, operation := range input.QueryDocument.Operations {
+		copied := *operation
+		copied.SelectionSet = injector.selectionSet(schema.Types[rootTypeName(schema, operation.Operation)], operation.SelectionSet)
+		document.Operations = append(document.Operations, &copied)
+	}
+
+	// none of the fields take the variables
+	if len(injector.definitions) == 0 {
+		return input, nil
+	}
+
+	variables := map[string]interface{}{}
+	for name, value := range input.Variables {
+		variables[name] = value
+	}
+
+	// the query has to be the same every time so the services can cache it
+	names := []string{}
+	for name := range injector.definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, operation := range document.Operations {
+		definitions := ast.VariableDefinitionList{}
+		for _, definition := range operation.VariableDefinitions {
+			if _, ok := injector.definitions[definition.Variable]; !ok {
+				definitions = append(definitions, definition)
+			}
+		}
+		for _, name := range names {
+			definitions = append(definitions, &ast.VariableDefinition{Variable: name, Type: injector.definitions[name]})
+		}
+		operation.VariableDefinitions = definitions
+	}
+	for name := range injector.definitions {
+		variables[name] = injected[name]
+	}
+
+	query, err := plannerPrintQuery(document)
+	if err != nil {
+		return nil, err
+	}
+
+	return &graphql.QueryInput{
+		Query:         query,
+		QueryDocument: document,
+		OperationName: input.OperationName,
+		Variables:     variables,
+	}, nil
+}
+
+// variableInjector walks a query and binds the injected variables to the arguments that accept them
+type variableInjector struct {
+	schema *ast.Schema
+	values map[string]interface{}
+	// the type of each variable that was used, indexed by name
+	definitions map[string]*ast.Type
+}
+
+// selectionSet returns a copy of the selection set where the fields of the parent take the injected variables
+func (i *variableInjector) selectionSet(parent *ast.Definition, selectionSet ast.SelectionSet) ast.SelectionSet {
+	if selectionSet == nil {
+		return nil
+	}
+
+	result := ast.SelectionSet{}
+	for _, selection := range selectionSet {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			copied := *selection
+
+			var definition *ast.FieldDefinition
+			if parent != nil {
+				definition = parent.Fields.ForName(selection.Name)
+			}
+			if definition != nil {
+				copied.Arguments = i.arguments(definition, selection.Arguments)
+				copied.SelectionSet = i.selectionSet(i.schema.Types[definition.Type.Name()], selection.SelectionSet)
+			} else {
+				copied.SelectionSet = i.selectionSet(nil, selection.SelectionSet)
+			}
+			result = append(result, &copied)
+
+		case *ast.InlineFragment:
+			copied := *selection
+			fragmentParent := parent
+			if selection.TypeCondition != "" {
+				fragmentParent = i.schema.Types[selection.TypeCondition]
+			}
+			copied.SelectionSet = i.selectionSet(fragmentParent, selection.SelectionSet)
+			result = append(result, &copied)
+
+		default:
+			// the definitions of the fragments are handled on their own
+			result = append(result, selection)
+		}
+	}
+
+	return result
+}
+
+// arguments returns the arguments of the field with the injected variables bound to the ones the field declares
+func (i *variableInjector) arguments(field *ast.FieldDefinition, arguments ast.ArgumentList) ast.ArgumentList {
+	result := ast.ArgumentList{}
+	for _, argument := range arguments {
+		// the injected value takes the place of the one sent by the client
+		if _, ok := i.values[argument.Name]; ok && field.Arguments.ForName(argument.Name) != nil {
+			continue
+		}
+		result = append(result, argument)
+	}
+
+	for _, argument := range field.Arguments {
+		if _, ok := i.values[argument.Name]; !ok {
+			continue
+		}
+
+		result = append(result, &ast.Argument{
+			Name:  argument.Name,
+			Value: &ast.Value{Kind: ast.Variable, Raw: argument.Name},
+		})
+
+		// a non-null variable can be passed to a nullable argument but not the other way around
+		if previous, ok := i.definitions[argument.Name]; !ok || (!previous.NonNull && argument.Type.NonNull) {
+			i.definitions[argument.Name] = argument.Type
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway_variableInjector(t *testing.T) {
+	// the users and orders services need to know the tenant, the catalog doesn't
+	usersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type User implements Node {
+			id: ID!
+			firstName: String!
+		}
+
+		type Query {
+			node(id: ID!): Node
+			allUsers(tenantId: ID): [User!]!
+		}
+	`)
+	ordersSchema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Order {
+			total: Int!
+		}
+
+		type User implements Node {
+			id: ID!
+			orders(tenantId: ID, first: Int): [Order!]!
+		}
+
+		type Query {
+			node(id: ID!): Node
+		}
+	`)
+	catalogSchema, _ := graphql.LoadSchema(`
+		type Query {
+			categories: [String!]!
+		}
+	`)
+
+	// the inputs sent to each service
+	sentMutex := &sync.Mutex{}
+	sent := map[string]*graphql.QueryInput{}
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			sentMutex.Lock()
+			sent[url] = input
+			sentMutex.Unlock()
+
+			switch url {
+			case "users":
+				return map[string]interface{}{
+					"allUsers": []interface{}{
+						map[string]interface{}{gatewayIDAlias: "1", "firstName": "John"},
+					},
+				}, nil
+			case "orders":
+				return map[string]interface{}{
+					"node": map[string]interface{}{
+						"orders": []interface{}{map[string]interface{}{"total": 10}},
+					},
+				}, nil
+			default:
+				return map[string]interface{}{"categories": []interface{}{"books"}}, nil
+			}
+		})
+	})
+
+	// the tenant of the request is in its context
+	type tenantKey struct{}
+	injector := func(ctx context.Context, step StepInfo) map[string]interface{} {
+		return map[string]interface{}{"tenantId": ctx.Value(tenantKey{})}
+	}
+
+	gateway, err := New([]*graphql.RemoteSchema{
+		{URL: "users", Schema: usersSchema},
+		{URL: "orders", Schema: ordersSchema},
+		{URL: "catalog", Schema: catalogSchema},
+	}, WithQueryerFactory(&factory), WithVariableInjector(injector))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	requestContext := &RequestContext{
+		Context: context.WithValue(context.Background(), tenantKey{}, "acme"),
+		// the client can't pick the tenant on its own
+		Query: `
+			query($first: Int) {
+				allUsers(tenantId: "other") {
+					firstName
+					orders(first: $first) { total }
+				}
+				categories
+			}
+		`,
+		Variables: map[string]interface{}{"first": 2},
+	}
+	plans, err := gateway.GetPlans(requestContext)
+	if !assert.Nil(t, err) {
+		return
+	}
+	result, err := gateway.Execute(requestContext, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{
+		"allUsers": []interface{}{
+			map[string]interface{}{
+				"firstName": "John",
+				"orders":    []interface{}{map[string]interface{}{"total": 10}},
+			},
+		},
+		"categories": []interface{}{"books"},
+	}, result)
+
+	// the services that declare the argument get the tenant
+	for _, url := range []string{"users", "orders"} {
+		input := sent[url]
+		if !assert.NotNil(t, input, url) {
+			continue
+		}
+		assert.Contains(t, input.Query, "tenantId: $tenantId)", url)
+		assert.Contains(t, input.Query, "$tenantId: ID", url)
+		assert.NotContains(t, input.Query, "other", url)
+		assert.Equal(t, "acme", input.Variables["tenantId"], url)
+	}
+	if input := sent["orders"]; assert.NotNil(t, input) {
+		assert.Equal(t, 2, input.Variables["first"])
+	}
+
+	// the catalog never sees the variable
+	if input := sent["catalog"]; assert.NotNil(t, input) {
+		assert.NotContains(t, input.Query, "tenantId")
+		_, ok := input.Variables["tenantId"]
+		assert.False(t, ok)
+	}
+}
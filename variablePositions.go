@@ -0,0 +1,218 @@
+package gateway
+
+import (
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+// A variable can only be used where a value of its type is allowed. A nullable variable ($count: Int) can't
+// be passed to a non-null argument (count: Int!) unless the variable or the argument has a default to fall
+// back on. The services don't agree on what to do with a query that breaks this rule (some refuse it, some
+// treat the null as if the argument was left out) so the gateway has to catch it before the query is sent
+// anywhere.
+//
+// gqlparser checks this too but it gets the defaults wrong: it ignores the defaults of arguments and input
+// fields, and it allows a variable with a default by changing the type of the argument in the schema, which
+// lets every query after it through. The gateway hides the defaults of the variables from gqlparser's
+// rules and checks the positions of the variables itself.
+
+// variablePositionsRule is the name of the rule that checks where the variables are used
+const variablePositionsRule = "VariablesInAllowedPosition"
+
+// loadQuery parses the query and validates it against the schema, like gqlparser.LoadQuery
+func loadQuery(schema *ast.Schema, query string) (*ast.QueryDocument, gqlerror.List) {
+	document, err := parser.ParseQuery(&ast.Source{Input: query})
+	if err != nil {
+		return nil, gqlerror.List{err}
+	}
+
+	if errs := validateQueryDocument(schema, document); len(errs) > 0 {
+		return nil, errs
+	}
+
+	return document, nil
+}
+
+// validateQueryDocument checks the document against the schema, like validator.Validate
+func validateQueryDocument(schema *ast.Schema, document *ast.QueryDocument) gqlerror.List {
+	// the defaults are put back once gqlparser is done with the document
+	defaults := map[*ast.VariableDefinition]*ast.Value{}
+	withDefaults := ast.VariableDefinitionList{}
+	for _, operation := range document.Operations {
+		for _, variable := range operation.VariableDefinitions {
+			if variable.DefaultValue != nil && variable.DefaultValue.Kind != ast.NullValue {
+				defaults[variable] = variable.DefaultValue
+				withDefaults = append(withDefaults, variable)
+				variable.DefaultValue = nil
+			}
+		}
+	}
+
+	errs := gqlerror.List{}
+	for _, err := range validator.Validate(schema, document) {
+		if err.Rule != variablePositionsRule {
+			errs = append(errs, err)
+		}
+	}
+
+	for variable, value := range defaults {
+		variable.DefaultValue = value
+	}
+
+	// the defaults still have to fit the types of their variables
+	if len(withDefaults) > 0 {
+		for _, err := range validator.Validate(schema, &ast.QueryDocument{
+			Operations: ast.OperationList{{
+				Operation:           ast.Query,
+				VariableDefinitions: withDefaults,
+				SelectionSet:        ast.SelectionSet{&ast.Field{Name: "__typename", Alias: "__typename"}},
+			}},
+		}) {
+			if err.Rule == "ValuesOfCorrectType" {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	errs = append(errs, validateVariablePositions(schema, document)...)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// validateVariablePositions returns an error for every place a variable is used where a value of its type
+// isn't allowed
+func validateVariablePositions(schema *ast.Schema, document *ast.QueryDocument) gqlerror.List {
+	errs := gqlerror.List{}
+	// a fragment used by more than one operation only has to report its usages once
+	reported := map[*ast.Value]bool{}
+
+	for _, operation := range document.Operations {
+		visited := Set{}
+
+		// checkValue checks the value in a position of the designated type
+		var checkValue func(value *ast.Value, locationType *ast.Type, locationDefault bool)
+		checkValue = func(value *ast.Value, locationType *ast.Type, locationDefault bool) {
+			if value == nil || locationType == nil {
+				return
+			}
+
+			switch value.Kind {
+			case ast.Variable:
+				variable := operation.VariableDefinitions.ForName(value.Raw)
+				if variable == nil || reported[value] || variableUsageAllowed(variable, locationType, locationDefault) {
+					return
+				}
+				reported[value] = true
+
+				err := gqlerror.ErrorPosf(value.Position, `Variable "%s" of type "%s" used in position expecting type "%s".`,
+					value.String(), variable.Type.String(), locationType.String())
+				err.Rule = variablePositionsRule
+				errs = append(errs, err)
+			case ast.ListValue:
+				if locationType.Elem == nil {
+					return
+				}
+				for _, child := range value.Children {
+					checkValue(child.Value, locationType.Elem, false)
+				}
+			case ast.ObjectValue:
+				definition := schema.Types[locationType.Name()]
+				if definition == nil || locationType.Elem != nil {
+					return
+				}
+				for _, child := range value.Children {
+					if field := definition.Fields.ForName(child.Name); field != nil {
+						checkValue(child.Value, field.Type, field.DefaultValue != nil)
+					}
+				}
+			}
+		}
+
+		checkArguments := func(arguments ast.ArgumentList, definitions ast.ArgumentDefinitionList) {
+			for _, argument := range arguments {
+				if definition := definitions.ForName(argument.Name); definition != nil {
+					checkValue(argument.Value, definition.Type, definition.DefaultValue != nil)
+				}
+			}
+		}
+
+		checkDirectives := func(directives ast.DirectiveList) {
+			for _, directive := range directives {
+				if definition := schema.Directives[directive.Name]; definition != nil {
+					checkArguments(directive.Arguments, definition.Arguments)
+				}
+			}
+		}
+
+		var checkSelectionSet func(parent *ast.Definition, selectionSet ast.SelectionSet)
+		checkSelectionSet = func(parent *ast.Definition, selectionSet ast.SelectionSet) {
+			for _, selection := range selectionSet {
+				switch selection := selection.(type) {
+				case *ast.Field:
+					var definition *ast.FieldDefinition
+					if parent != nil {
+						definition = parent.Fields.ForName(selection.Name)
+					}
+
+					var fieldType *ast.Definition
+					if definition != nil {
+						checkArguments(selection.Arguments, definition.Arguments)
+						fieldType = schema.Types[definition.Type.Name()]
+					}
+					checkDirectives(selection.Directives)
+					checkSelectionSet(fieldType, selection.SelectionSet)
+				case *ast.InlineFragment:
+					checkDirectives(selection.Directives)
+
+					typeCondition := parent
+					if selection.TypeCondition != "" {
+						typeCondition = schema.Types[selection.TypeCondition]
+					}
+					checkSelectionSet(typeCondition, selection.SelectionSet)
+				case *ast.FragmentSpread:
+					checkDirectives(selection.Directives)
+
+					fragment := document.Fragments.ForName(selection.Name)
+					if fragment == nil || visited.Has(fragment.Name) {
+						continue
+					}
+					visited.Add(fragment.Name)
+
+					checkDirectives(fragment.Directives)
+					checkSelectionSet(schema.Types[fragment.TypeCondition], fragment.SelectionSet)
+				}
+			}
+		}
+
+		root := schema.Types[rootTypeName(schema, operation.Operation)]
+		if root == nil {
+			continue
+		}
+		checkDirectives(operation.Directives)
+		checkSelectionSet(root, operation.SelectionSet)
+	}
+
+	return errs
+}
+
+// variableUsageAllowed returns true if the variable can be used in a position of the designated type
+func variableUsageAllowed(variable *ast.VariableDefinition, locationType *ast.Type, locationDefault bool) bool {
+	// a nullable variable can go where a null isn't allowed if there's a default to use instead
+	if locationType.NonNull && !variable.Type.NonNull {
+		variableDefault := variable.DefaultValue != nil && variable.DefaultValue.Kind != ast.NullValue
+		if !variableDefault && !locationDefault {
+			return false
+		}
+
+		nullable := *locationType
+		nullable.NonNull = false
+		return variable.Type.IsCompatible(&nullable)
+	}
+
+	return variable.Type.IsCompatible(locationType)
+}
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+func TestGateway_variablePositions(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		input Filter {
+			count: Int!
+			tags: [String!]
+			limit: Int! = 10
+		}
+
+		type Query {
+			users(count: Int!, ids: [ID!], filter: Filter, page: Int! = 1): [String!]!
+		}
+	`)
+	gw, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	plan := func(query string) error {
+		_, err := gw.GetPlans(&RequestContext{Context: context.Background(), Query: query})
+		return err
+	}
+
+	// the variables that can't be null, or have something to fall back on, are fine
+	for _, query := range []string{
+		`query($x: Int!) { users(count: $x) }`,
+		`query($x: Int = 3) { users(count: $x) }`,
+		`query($x: Int) { users(count: 1, page: $x) }`,
+		`query($x: Int) { users(count: 1, filter: {count: 1, limit: $x}) }`,
+		`query($x: [ID!]) { users(count: 1, ids: $x) }`,
+		`query($x: Boolean!) { users(count: 1) @include(if: $x) }`,
+	} {
+		assert.Nil(t, plan(query), query)
+	}
+
+	// but a nullable variable can't go anywhere a null isn't allowed
+	for _, query := range []string{
+		`query($x: Int) { users(count: $x) }`,
+		`query($x: Int = null) { users(count: $x) }`,
+		`query($x: ID) { users(count: 1, ids: [$x]) }`,
+		`query($x: [ID]) { users(count: 1, ids: $x) }`,
+		`query($x: Int) { users(count: 1, filter: {count: $x}) }`,
+		`query($x: String) { users(count: 1, filter: {count: 1, tags: [$x]}) }`,
+		`query($x: Boolean) { users(count: 1) @include(if: $x) }`,
+		`query($x: Int) { ...Users } fragment Users on Query { users(count: $x) }`,
+	} {
+		assert.NotNil(t, plan(query), query)
+	}
+
+	// a variable with a default doesn't change what the schema allows for the queries after it
+	assert.Nil(t, plan(`query($x: Int = 3) { users(count: $x) }`))
+	assert.NotNil(t, plan(`query($x: Int) { users(count: $x) }`))
+	assert.True(t, gw.schema.Query.Fields.ForName("users").Arguments.ForName("count").Type.NonNull)
+
+	// the default still has to fit the variable
+	assert.NotNil(t, plan(`query($x: Int = "three") { users(count: $x) }`))
+
+	// every usage that isn't allowed is reported at once
+	err = plan(`
+		query($x: Int, $y: Boolean) {
+			a: users(count: $x) @skip(if: $y)
+			b: users(count: 1, filter: {count: $x})
+		}
+	`)
+	errs, ok := err.(gqlerror.List)
+	if assert.True(t, ok) && assert.Len(t, errs, 3) {
+		assert.Equal(t, `Variable "$x" of type "Int" used in position expecting type "Int!".`, errs[0].Message)
+		assert.Equal(t, []gqlerror.Location{{Line: 3, Column: 20}}, errs[0].Locations)
+	}
+}
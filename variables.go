@@ -0,0 +1,285 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nautilus/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// The variables of a request are whatever JSON the client sent. Before any of them are sent to a service, they
+// are coerced to the types the operation declares so that a bad value is reported the same way no matter
+// which service would have seen it first.
+
+// coerceVariables returns the values of the operation's variables after they have been coerced to their
+// declared types. Variables that weren't sent get their default value.
+func (g *Gateway) coerceVariables(operation *ast.OperationDefinition, variables map[string]interface{}) (map[string]interface{}, error) {
+	if operation == nil {
+		return variables, nil
+	}
+
+	g.schemaMutex.RLock()
+	schema := g.schema
+	g.schemaMutex.RUnlock()
+
+	coercer := &variableCoercer{schema: schema}
+	coerced := map[string]interface{}{}
+
+	for _, definition := range operation.VariableDefinitions {
+		value, ok := variables[definition.Variable]
+		if !ok {
+			if definition.DefaultValue != nil {
+				defaultValue, err := definition.DefaultValue.Value(nil)
+				if err != nil {
+					return nil, err
+				}
+				coerced[definition.Variable] = defaultValue
+			} else if definition.Type.NonNull {
+				coercer.fail(fmt.Sprintf(`Variable "$%s" of required type "%s" was not provided.`, definition.Variable, definition.Type.String()))
+			}
+			continue
+		}
+
+		if value == nil && definition.Type.NonNull {
+			coercer.fail(fmt.Sprintf(`Variable "$%s" of non-null type "%s" must not be null.`, definition.Variable, definition.Type.String()))
+			continue
+		}
+
+		coercer.variable = definition.Variable
+		coercer.variableValue = value
+		coerced[definition.Variable] = coercer.value(definition.Type, value, []interface{}{})
+	}
+
+	if len(coercer.errs) > 0 {
+		return nil, coercer.errs
+	}
+
+	return coerced, nil
+}
+
+// variableCoercer coerces the value of a variable and collects the problems along the way
+type variableCoercer struct {
+	schema *ast.Schema
+	errs   graphql.ErrorList
+	// the variable being coerced
+	variable      string
+	variableValue interface{}
+}
+
+// fail records an error with the variables
+func (c *variableCoercer) fail(message string) {
+	c.errs = append(c.errs, graphql.NewError("BAD_USER_INPUT", message))
+}
+
+// invalid records an error with the value at the path in the current variable
+func (c *variableCoercer) invalid(path []interface{}, reason string) {
+	message := fmt.Sprintf(`Variable "$%s" got invalid value %s`, c.variable, inspectValue(c.variableValue))
+	if len(path) > 0 {
+		message += fmt.Sprintf(` at "%s"`, variablePath(c.variable, path))
+	}
+
+	c.fail(message + "; " + reason)
+}
+
+// value returns the value coerced to the type
+func (c *variableCoercer) value(valueType *ast.Type, value interface{}, path []interface{}) interface{} {
+	if value == nil {
+		if valueType.NonNull {
+			c.invalid(path, fmt.Sprintf(`Expected non-nullable type "%s" not to be null.`, valueType.String()))
+		}
+		return nil
+	}
+
+	if valueType.Elem != nil {
+		// a single value is treated as a list with one entry
+		list, ok := value.([]interface{})
+		if !ok {
+			return []interface{}{c.value(valueType.Elem, value, path)}
+		}
+
+		coerced := []interface{}{}
+		for i, entry := range list {
+			coerced = append(coerced, c.value(valueType.Elem, entry, append(path, i)))
+		}
+		return coerced
+	}
+
+	definition := c.schema.Types[valueType.Name()]
+	if definition == nil {
+		return value
+	}
+
+	switch definition.Kind {
+	case ast.InputObject:
+		return c.inputObject(definition, value, path)
+
+	case ast.Enum:
+		name, ok := value.(string)
+		if !ok || definition.EnumValues.ForName(name) == nil {
+			c.invalid(path, fmt.Sprintf(`Value %s does not exist in "%s" enum.`, inspectValue(value), definition.Name))
+			return nil
+		}
+		return name
+
+	case ast.Scalar:
+		coerced, reason := coerceScalar(definition.Name, value)
+		if reason != "" {
+			c.invalid(path, reason)
+			return nil
+		}
+		return coerced
+	}
+
+	return value
+}
+
+// inputObject returns the value coerced to the input object, with the default values of the missing fields
+func (c *variableCoercer) inputObject(definition *ast.Definition, value interface{}, path []interface{}) interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		c.invalid(path, fmt.Sprintf(`Expected type "%s" to be an object.`, definition.Name))
+		return nil
+	}
+
+	coerced := map[string]interface{}{}
+	for _, field := range definition.Fields {
+		fieldValue, ok := object[field.Name]
+		if !ok {
+			if field.DefaultValue != nil {
+				defaultValue, err := field.DefaultValue.Value(nil)
+				if err != nil {
+					c.invalid(path, err.Error())
+					continue
+				}
+				coerced[field.Name] = defaultValue
+			} else if field.Type.NonNull {
+				c.invalid(path, fmt.Sprintf(`Field "%s" of required type "%s" was not provided.`, field.Name, field.Type.String()))
+			}
+			continue
+		}
+
+		coerced[field.Name] = c.value(field.Type, fieldValue, append(path, field.Name))
+	}
+
+	// the fields the type doesn't know about are reported in the same order every time
+	unknown := []string{}
+	for name := range object {
+		if definition.Fields.ForName(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		c.invalid(path, fmt.Sprintf(`Field "%s" is not defined by type "%s".`, name, definition.Name))
+	}
+
+	return coerced
+}
+
+// coerceScalar returns the value coerced to one of the built-in scalars. Custom scalars are left alone. If the
+// value can't be coerced, the reason is returned.
+func coerceScalar(name string, value interface{}) (interface{}, string) {
+	switch name {
+	case "Int":
+		number, ok := variableNumber(value)
+		if !ok || number != math.Trunc(number) {
+			return nil, fmt.Sprintf("Int cannot represent non-integer value: %s", inspectValue(value))
+		}
+		if number > math.MaxInt32 || number < math.MinInt32 {
+			return nil, fmt.Sprintf("Int cannot represent non 32-bit signed integer value: %s", inspectValue(value))
+		}
+		return int(number), ""
+
+	case "Float":
+		number, ok := variableNumber(value)
+		if !ok {
+			return nil, fmt.Sprintf("Float cannot represent non numeric value: %s", inspectValue(value))
+		}
+		// a number the client sent in the body keeps all of its digits
+		if literal, ok := value.(json.Number); ok {
+			return literal, ""
+		}
+		return number, ""
+
+	case "String":
+		if _, ok := value.(string); !ok {
+			return nil, fmt.Sprintf("String cannot represent a non string value: %s", inspectValue(value))
+		}
+		return value, ""
+
+	case "Boolean":
+		if _, ok := value.(bool); !ok {
+			return nil, fmt.Sprintf("Boolean cannot represent a non boolean value: %s", inspectValue(value))
+		}
+		return value, ""
+
+	case "ID":
+		// ids can be sent as strings or integers but they are always strings
+		if id, ok := value.(string); ok {
+			return id, ""
+		}
+		if literal, ok := value.(json.Number); ok {
+			if _, integer := new(big.Int).SetString(literal.String(), 10); integer {
+				return literal.String(), ""
+			}
+		}
+		if number, ok := variableNumber(value); ok && number == math.Trunc(number) {
+			return strconv.FormatFloat(number, 'f', -1, 64), ""
+		}
+		return nil, fmt.Sprintf("ID cannot represent value: %s", inspectValue(value))
+	}
+
+	return value, ""
+}
+
+// variableNumber returns the value as a number if it is one
+func variableNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int32:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case json.Number:
+		number, err := value.Float64()
+		return number, err == nil
+	}
+
+	return 0, false
+}
+
+// inspectValue returns the JSON representation of the value
+func inspectValue(value interface{}) string {
+	inspected, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(inspected)
+}
+
+// variablePath returns the path to a value inside of the variable, ie input.items[0].name
+func variablePath(variable string, path []interface{}) string {
+	result := &strings.Builder{}
+	result.WriteString(variable)
+	for _, segment := range path {
+		switch segment := segment.(type) {
+		case int:
+			result.WriteString(fmt.Sprintf("[%d]", segment))
+		default:
+			result.WriteString(fmt.Sprintf(".%v", segment))
+		}
+	}
+
+	return result.String()
+}
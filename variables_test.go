@@ -0,0 +1,219 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+func TestCoerceVariables(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		enum Color {
+			RED
+			GREEN
+		}
+
+		input Tag {
+			name: String!
+		}
+
+		input Filter {
+			color: Color!
+			limit: Int = 10
+			tags: [Tag!]
+		}
+
+		type Query {
+			things(
+				filter: Filter
+				count: Int
+				ratio: Float
+				id: ID
+				colors: [Color!]
+				flag: Boolean
+				name: String
+			): [String!]!
+		}
+	`)
+
+	gateway, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// the operation that declares the variables
+	query, parseErr := parser.ParseQuery(&ast.Source{Input: `
+		query (
+			$filter: Filter
+			$count: Int!
+			$ratio: Float
+			$id: ID
+			$colors: [Color!]
+			$flag: Boolean = true
+			$name: String
+		) {
+			things(filter: $filter, count: $count, ratio: $ratio, id: $id, colors: $colors, flag: $flag, name: $name)
+		}
+	`})
+	if !assert.Nil(t, parseErr) {
+		return
+	}
+	operation := query.Operations[0]
+
+	for _, row := range []struct {
+		message   string
+		variables map[string]interface{}
+		expected  map[string]interface{}
+		errors    []string
+	}{
+		{
+			"Coerced values",
+			map[string]interface{}{
+				"count":  float64(5),
+				"ratio":  2,
+				"id":     float64(1),
+				"colors": "RED",
+				"filter": map[string]interface{}{"color": "GREEN", "tags": []interface{}{map[string]interface{}{"name": "a"}}},
+				"name":   nil,
+			},
+			map[string]interface{}{
+				"count":  5,
+				"ratio":  float64(2),
+				"id":     "1",
+				"colors": []interface{}{"RED"},
+				"filter": map[string]interface{}{"color": "GREEN", "limit": int64(10), "tags": []interface{}{map[string]interface{}{"name": "a"}}},
+				"flag":   true,
+				"name":   nil,
+			},
+			nil,
+		},
+		{
+			"Missing required variable",
+			map[string]interface{}{},
+			nil,
+			[]string{`Variable "$count" of required type "Int!" was not provided.`},
+		},
+		{
+			"Null required variable",
+			map[string]interface{}{"count": nil},
+			nil,
+			[]string{`Variable "$count" of non-null type "Int!" must not be null.`},
+		},
+		{
+			"Wrong scalars",
+			map[string]interface{}{"count": "5", "ratio": "fast", "id": true, "flag": "yes", "name": 5},
+			nil,
+			[]string{
+				`Variable "$count" got invalid value "5"; Int cannot represent non-integer value: "5"`,
+				`Variable "$ratio" got invalid value "fast"; Float cannot represent non numeric value: "fast"`,
+				`Variable "$id" got invalid value true; ID cannot represent value: true`,
+				`Variable "$flag" got invalid value "yes"; Boolean cannot represent a non boolean value: "yes"`,
+				`Variable "$name" got invalid value 5; String cannot represent a non string value: 5`,
+			},
+		},
+		{
+			"Integers",
+			map[string]interface{}{"count": 1.5, "ratio": float64(1e10)},
+			nil,
+			[]string{`Variable "$count" got invalid value 1.5; Int cannot represent non-integer value: 1.5`},
+		},
+		{
+			"Large integers",
+			map[string]interface{}{"count": float64(1e10)},
+			nil,
+			[]string{`Variable "$count" got invalid value 10000000000; Int cannot represent non 32-bit signed integer value: 10000000000`},
+		},
+		{
+			"Input objects",
+			map[string]interface{}{
+				"count": 1,
+				"filter": map[string]interface{}{
+					"color": "BLUE",
+					"tags":  []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{}},
+					"extra": true,
+				},
+				"colors": []interface{}{"RED", nil},
+			},
+			nil,
+			[]string{
+				`Variable "$filter" got invalid value {"color":"BLUE","extra":true,"tags":[{"name":"a"},{}]} at "filter.color"; Value "BLUE" does not exist in "Color" enum.`,
+				`Variable "$filter" got invalid value {"color":"BLUE","extra":true,"tags":[{"name":"a"},{}]} at "filter.tags[1]"; Field "name" of required type "String!" was not provided.`,
+				`Variable "$filter" got invalid value {"color":"BLUE","extra":true,"tags":[{"name":"a"},{}]}; Field "extra" is not defined by type "Filter".`,
+				`Variable "$colors" got invalid value ["RED",null] at "colors[1]"; Expected non-nullable type "Color!" not to be null.`,
+			},
+		},
+		{
+			"Not an object",
+			map[string]interface{}{"count": 1, "filter": "RED"},
+			nil,
+			[]string{`Variable "$filter" got invalid value "RED"; Expected type "Filter" to be an object.`},
+		},
+	} {
+		t.Run(row.message, func(t *testing.T) {
+			coerced, err := gateway.coerceVariables(operation, row.variables)
+			if row.errors == nil {
+				if assert.Nil(t, err) {
+					assert.Equal(t, row.expected, coerced)
+				}
+				return
+			}
+
+			errs, ok := err.(graphql.ErrorList)
+			if !assert.True(t, ok, "did not get an error list") {
+				return
+			}
+			messages := []string{}
+			for _, err := range errs {
+				assert.Equal(t, "BAD_USER_INPUT", err.(*graphql.Error).Extensions["code"])
+				messages = append(messages, err.Error())
+			}
+			assert.Equal(t, row.errors, messages)
+		})
+	}
+}
+
+func TestGateway_coercesVariables(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Query {
+			things(count: Int!): [String!]!
+		}
+	`)
+
+	// the variables the executor was given
+	var executed map[string]interface{}
+
+	gateway, err := New([]*graphql.RemoteSchema{{URL: "url1", Schema: schema}}, WithExecutor(ExecutorFunc(func(ctx *ExecutionContext) (map[string]interface{}, error) {
+		executed = ctx.Variables
+		return map[string]interface{}{"things": []interface{}{}}, nil
+	})))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	reqCtx := &RequestContext{
+		Context:   context.Background(),
+		Query:     "query ($count: Int!) { things(count: $count) }",
+		Variables: map[string]interface{}{"count": float64(2), "unused": true},
+	}
+	plans, err := gateway.GetPlans(reqCtx)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, err = gateway.Execute(reqCtx, plans)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{"count": 2}, executed)
+
+	// a bad value never makes it to the executor
+	executed = nil
+	reqCtx.Variables = map[string]interface{}{"count": "2"}
+	_, err = gateway.Execute(reqCtx, plans)
+	assert.NotNil(t, err)
+	assert.Nil(t, executed)
+}
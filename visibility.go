@@ -0,0 +1,247 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// A visibility profile is the part of the schema that a group of clients is allowed to see. The gateway
+// builds a copy of the schema for each profile whenever the schema changes and uses the one picked for the
+// request to validate and plan the query, and to answer introspection. A field that's hidden from the caller
+// doesn't exist as far as they can tell - asking for it is the same validation error as asking for a field
+// that was never there.
+//
+//	gateway.New(sources,
+//		gateway.WithVisibilityProfile("partner", func(typeName, fieldName string) bool {
+//			return !strings.HasPrefix(fieldName, "internal")
+//		}),
+//		gateway.WithVisibilityProfileSelector(func(ctx context.Context) string {
+//			return ctx.Value(profileKey).(string)
+//		}),
+//	)
+//
+// A type that is left without any fields is hidden along with the fields that return it. Requests whose
+// selector returns an empty string see the whole schema.
+
+// VisibilityFilter returns true if the field should be part of the schema of a profile
+type VisibilityFilter func(typeName, fieldName string) bool
+
+// VisibilityProfileSelector returns the name of the visibility profile of the request
+type VisibilityProfileSelector func(ctx context.Context) string
+
+// WithVisibilityProfile returns an Option that adds a version of the schema with the fields allowed by the filter
+func WithVisibilityProfile(name string, filter VisibilityFilter) Option {
+	return func(g *Gateway) {
+		if g.visibilityProfiles == nil {
+			g.visibilityProfiles = map[string]VisibilityFilter{}
+		}
+		g.visibilityProfiles[name] = filter
+	}
+}
+
+// WithVisibilityProfileSelector returns an Option that uses the selector to pick the visibility profile of
+// every request
+func WithVisibilityProfileSelector(selector VisibilityProfileSelector) Option {
+	return func(g *Gateway) {
+		g.visibilityProfileSelector = selector
+	}
+}
+
+// visibleSchema returns the schema that the request is allowed to see
+func (g *Gateway) visibleSchema(ctx context.Context) (*ast.Schema, error) {
+	g.schemaMutex.RLock()
+	defer g.schemaMutex.RUnlock()
+
+	if g.visibilityProfileSelector == nil {
+		return g.schema, nil
+	}
+
+	name := g.visibilityProfileSelector(ctx)
+	if name == "" {
+		return g.schema, nil
+	}
+
+	// a request can't see more than it should because of a typo
+	schema, ok := g.visibleSchemas[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown visibility profile: %s", name)
+	}
+
+	return schema, nil
+}
+
+// checkVisibility returns an error if one of the plans selects a field that isn't part of the schema of the
+// request. The plans that were planned for the request were already validated against it but the ones that came
+// out of the cache could have been planned for someone who can see more.
+func (g *Gateway) checkVisibility(ctx *RequestContext, plans QueryPlanList) error {
+	if g.visibilityProfileSelector == nil {
+		return nil
+	}
+
+	schema, err := g.visibleSchema(ctx.Context)
+	if err != nil {
+		return err
+	}
+
+	// walk every field of the operation
+	var check func(selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) error
+	check = func(selectionSet ast.SelectionSet, fragments ast.FragmentDefinitionList) error {
+		for _, field := range executorSelectedFields(selectionSet, fragments) {
+			if field.ObjectDefinition != nil && !strings.HasPrefix(field.Name, "__") {
+				definition := schema.Types[field.ObjectDefinition.Name]
+				if definition == nil || definition.Fields.ForName(field.Name) == nil {
+					return gqlerror.List{&gqlerror.Error{
+						Message: fmt.Sprintf(`Cannot query field "%s" on type "%s".`, field.Name, field.ObjectDefinition.Name),
+						Rule:    "FieldsOnCorrectType",
+					}}
+				}
+			}
+
+			if err := check(field.SelectionSet, fragments); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, plan := range plans {
+		if plan.Operation == nil {
+			continue
+		}
+		if err := check(plan.Operation.SelectionSet, plan.FragmentDefinitions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// visibleSchemas builds the schema of each of the profiles
+func visibleSchemas(schema *ast.Schema, profiles map[string]VisibilityFilter) map[string]*ast.Schema {
+	schemas := map[string]*ast.Schema{}
+	for name, filter := range profiles {
+		schemas[name] = visibilityFilterSchema(schema, filter)
+	}
+
+	return schemas
+}
+
+// visibilityFilterSchema returns a copy of the schema with the fields that pass the filter
+func visibilityFilterSchema(schema *ast.Schema, filter VisibilityFilter) *ast.Schema {
+	filtered := *schema
+	filtered.Types = map[string]*ast.Definition{}
+
+	// the root types are always there, even if the profile can't ask for anything on them
+	roots := Set{}
+	for _, root := range []*ast.Definition{schema.Query, schema.Mutation, schema.Subscription} {
+		if root != nil {
+			roots.Add(root.Name)
+		}
+	}
+
+	for name, definition := range schema.Types {
+		copied := *definition
+		if visibilityHasFields(definition) {
+			copied.Fields = ast.FieldList{}
+			for _, field := range definition.Fields {
+				if strings.HasPrefix(field.Name, "__") || filter(name, field.Name) {
+					copied.Fields = append(copied.Fields, field)
+				}
+			}
+		}
+		filtered.Types[name] = &copied
+	}
+
+	// hiding a type can leave another one without fields so we have to keep going until nothing changes
+	for changed := true; changed; {
+		changed = false
+
+		for name, definition := range filtered.Types {
+			// a type without fields (or a union without members) can't be described
+			empty := (visibilityHasFields(definition) && len(definition.Fields) == 0) ||
+				(definition.Kind == ast.Union && len(definition.Types) == 0)
+			if empty && !roots.Has(name) {
+				delete(filtered.Types, name)
+				changed = true
+			}
+		}
+
+		for _, definition := range filtered.Types {
+			if visibilityHasFields(definition) {
+				fields := ast.FieldList{}
+				for _, field := range definition.Fields {
+					if _, ok := filtered.Types[field.Type.Name()]; ok {
+						fields = append(fields, field)
+					}
+				}
+				changed = changed || len(fields) != len(definition.Fields)
+				definition.Fields = fields
+			}
+
+			if definition.Kind == ast.Union {
+				members := []string{}
+				for _, member := range definition.Types {
+					if _, ok := filtered.Types[member]; ok {
+						members = append(members, member)
+					}
+				}
+				changed = changed || len(members) != len(definition.Types)
+				definition.Types = members
+			}
+		}
+	}
+
+	// the types that are left have to point to each other
+	for _, definition := range filtered.Types {
+		if len(definition.Interfaces) > 0 {
+			interfaces := []string{}
+			for _, iface := range definition.Interfaces {
+				if _, ok := filtered.Types[iface]; ok {
+					interfaces = append(interfaces, iface)
+				}
+			}
+			definition.Interfaces = interfaces
+		}
+	}
+	filtered.PossibleTypes = visibilityFilterTypeMap(schema.PossibleTypes, filtered.Types)
+	filtered.Implements = visibilityFilterTypeMap(schema.Implements, filtered.Types)
+	if schema.Query != nil {
+		filtered.Query = filtered.Types[schema.Query.Name]
+	}
+	if schema.Mutation != nil {
+		filtered.Mutation = filtered.Types[schema.Mutation.Name]
+	}
+	if schema.Subscription != nil {
+		filtered.Subscription = filtered.Types[schema.Subscription.Name]
+	}
+
+	return &filtered
+}
+
+// visibilityHasFields returns true if the fields of the definition can be hidden
+func visibilityHasFields(definition *ast.Definition) bool {
+	return (definition.Kind == ast.Object || definition.Kind == ast.Interface) && !strings.HasPrefix(definition.Name, "__")
+}
+
+// visibilityFilterTypeMap points the entries of the map at the filtered types, leaving out the ones that were hidden
+func visibilityFilterTypeMap(source map[string][]*ast.Definition, types map[string]*ast.Definition) map[string][]*ast.Definition {
+	result := map[string][]*ast.Definition{}
+	for name, definitions := range source {
+		if _, ok := types[name]; !ok {
+			continue
+		}
+
+		for _, definition := range definitions {
+			if filtered, ok := types[definition.Name]; ok {
+				result[name] = append(result[name], filtered)
+			}
+		}
+	}
+
+	return result
+}
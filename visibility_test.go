@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nautilus/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// the context key with the visibility profile of the request
+type visibilityProfileKey struct{}
+
+func TestGateway_visibilityProfiles(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		type Report {
+			total: Int!
+		}
+
+		type User {
+			name: String!
+			salary: Int!
+		}
+
+		type Query {
+			users: [User!]!
+			report: Report
+		}
+	`)
+
+	factory := QueryerFactory(func(ctx *PlanningContext, url string) graphql.Queryer {
+		return graphql.QueryerFunc(func(input *graphql.QueryInput) (interface{}, error) {
+			return map[string]interface{}{
+				"users": []interface{}{map[string]interface{}{"name": "alice", "salary": 10}},
+			}, nil
+		})
+	})
+	gw, err := New([]*graphql.RemoteSchema{{Schema: schema, URL: "users"}},
+		WithQueryerFactory(&factory),
+		WithAutomaticQueryPlanCache(),
+		WithVisibilityProfile("internal", func(typeName, fieldName string) bool {
+			return true
+		}),
+		WithVisibilityProfile("partner", func(typeName, fieldName string) bool {
+			return fieldName != "salary" && typeName != "Report"
+		}),
+		WithVisibilityProfileSelector(func(ctx context.Context) string {
+			profile, _ := ctx.Value(visibilityProfileKey{}).(string)
+			return profile
+		}),
+	)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	execute := func(profile string, query string) (map[string]interface{}, error) {
+		ctx := &RequestContext{
+			Context: context.WithValue(context.Background(), visibilityProfileKey{}, profile),
+			Query:   query,
+		}
+		plans, err := gw.GetPlans(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return gw.Execute(ctx, plans)
+	}
+
+	// the internal apps can see everything. Their plan ends up in the cache
+	result, err := execute("internal", `{ users { name salary } }`)
+	if assert.Nil(t, err) {
+		assert.Equal(t, map[string]interface{}{
+			"users": []interface{}{map[string]interface{}{"name": "alice", "salary": 10}},
+		}, result)
+	}
+
+	// but a partner gets the same error as if the field didn't exist
+	_, err = execute("partner", `{ users { name salary } }`)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), `Cannot query field "salary" on type "User"`)
+	}
+	_, err = execute("partner", `{ users { name salary } report { total } }`)
+	assert.NotNil(t, err)
+	_, err = execute("partner", `{ users { name } }`)
+	assert.Nil(t, err)
+
+	// introspection only shows what the caller can see
+	fields := func(profile string) []string {
+		result, err := execute(profile, `{ __type(name: "User") { fields { name } } }`)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		names := []string{}
+		for _, field := range result["__type"].(map[string]interface{})["fields"].([]map[string]interface{}) {
+			names = append(names, field["name"].(string))
+		}
+		return names
+	}
+	assert.Equal(t, []string{"name", "salary"}, fields("internal"))
+	assert.Equal(t, []string{"name"}, fields("partner"))
+
+	// and so does the SDL
+	sdl := func(profile string) string {
+		sdl, err := gw.SchemaSDL(context.WithValue(context.Background(), visibilityProfileKey{}, profile))
+		assert.Nil(t, err)
+		return sdl
+	}
+	assert.Contains(t, sdl("internal"), "salary")
+	assert.Contains(t, sdl("internal"), "type Report")
+	assert.NotContains(t, sdl("partner"), "salary")
+	assert.NotContains(t, sdl("partner"), "type Report")
+	assert.Contains(t, sdl("partner"), "users: [User!]!")
+
+	// a profile that doesn't exist doesn't get to see anything
+	_, err = execute("unknown", `{ users { name } }`)
+	assert.NotNil(t, err)
+}
+
+func TestVisibilityFilterSchema(t *testing.T) {
+	schema, _ := graphql.LoadSchema(`
+		interface Node {
+			id: ID!
+		}
+
+		type Secret implements Node {
+			id: ID!
+		}
+
+		type Report {
+			secret: Secret
+		}
+
+		union Result = Secret | Report
+
+		type Query {
+			node(id: ID!): Node
+			report: Report
+			search: [Result!]!
+		}
+	`)
+
+	// hiding the secrets leaves the report without any fields
+	filtered := visibilityFilterSchema(schema, func(typeName, fieldName string) bool {
+		return typeName != "Secret"
+	})
+	assert.Nil(t, filtered.Types["Secret"])
+	assert.Nil(t, filtered.Types["Report"])
+	assert.Nil(t, filtered.Types["Result"])
+	assert.Nil(t, filtered.Query.Fields.ForName("report"))
+	assert.Nil(t, filtered.Query.Fields.ForName("search"))
+	assert.NotNil(t, filtered.Query.Fields.ForName("node"))
+	assert.Empty(t, filtered.PossibleTypes["Node"])
+
+	// and the original schema is left alone
+	assert.NotNil(t, schema.Types["Secret"])
+	assert.NotNil(t, schema.Query.Fields.ForName("report"))
+	assert.Equal(t, []*ast.Definition{schema.Types["Secret"]}, schema.PossibleTypes["Node"])
+}